@@ -712,18 +712,20 @@ class TopicEvent(_message.Message):
     def __init__(self, action: _Optional[_Union[Crud, str]] = ..., name: _Optional[str] = ..., desc: _Optional[_Union[TopicDesc, _Mapping]] = ...) -> None: ...
 
 class AccountEvent(_message.Message):
-    __slots__ = ["action", "user_id", "default_acs", "public", "tags"]
+    __slots__ = ["action", "user_id", "default_acs", "public", "tags", "locked_until"]
     ACTION_FIELD_NUMBER: _ClassVar[int]
     USER_ID_FIELD_NUMBER: _ClassVar[int]
     DEFAULT_ACS_FIELD_NUMBER: _ClassVar[int]
     PUBLIC_FIELD_NUMBER: _ClassVar[int]
     TAGS_FIELD_NUMBER: _ClassVar[int]
+    LOCKED_UNTIL_FIELD_NUMBER: _ClassVar[int]
     action: Crud
     user_id: str
     default_acs: DefaultAcsMode
     public: bytes
     tags: _containers.RepeatedScalarFieldContainer[str]
-    def __init__(self, action: _Optional[_Union[Crud, str]] = ..., user_id: _Optional[str] = ..., default_acs: _Optional[_Union[DefaultAcsMode, _Mapping]] = ..., public: _Optional[bytes] = ..., tags: _Optional[_Iterable[str]] = ...) -> None: ...
+    locked_until: int
+    def __init__(self, action: _Optional[_Union[Crud, str]] = ..., user_id: _Optional[str] = ..., default_acs: _Optional[_Union[DefaultAcsMode, _Mapping]] = ..., public: _Optional[bytes] = ..., tags: _Optional[_Iterable[str]] = ..., locked_until: _Optional[int] = ...) -> None: ...
 
 class SubscriptionEvent(_message.Message):
     __slots__ = ["action", "topic", "user_id", "del_id", "read_id", "recv_id", "mode", "private"]
@@ -12,9 +12,16 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
 )
 
 // Singned AppID. Composition:
@@ -38,12 +45,32 @@ const (
 	apikeyLength = apikeyVersion + apikeyAppID + apikeySequence + apikeyWho + apikeySignature
 )
 
+// Signed ID of a stored API key. Composition:
+//
+//	[1:algorithm version = 2][8:key ID][15:signature] = 24 bytes
+//
+// The key ID is the ID of the APIKey record in the database.
+const (
+	// apikeyID is the ID of the stored key.
+	apikeyID = 8
+	// apikeySignatureV2 is the truncated HMAC-SHA256 signature of the key.
+	apikeySignatureV2 = apikeyLength - apikeyVersion - apikeyID
+)
+
+// How long the stored API keys are cached before being re-read from the database. Changes are
+// broadcast to other cluster nodes, the TTL limits the delay if a node missed the broadcast.
+const apiKeyCacheTTL = time.Minute
+
+// legacyAPIKey is the key used by clients with keys of version 1. Such keys are not stored, they
+// cannot be restricted or revoked individually.
+var legacyAPIKey = &types.APIKey{Name: "legacy"}
+
 // Client signature validation
 //
 //	key: client's secret key
 //
-// Returns application id, key type.
-func checkAPIKey(apikey string) (isValid, isRoot bool) {
+// Returns the ID of the stored key for keys of version 2, zero ID for keys of version 1.
+func checkAPIKey(apikey string) (isValid bool, keyId types.Uid) {
 	if declen := base64.URLEncoding.DecodedLen(len(apikey)); declen != apikeyLength {
 		return
 	}
@@ -53,22 +80,211 @@ func checkAPIKey(apikey string) (isValid, isRoot bool) {
 		logs.Warn.Println("failed to decode.base64 appid ", err)
 		return
 	}
-	if data[0] != 1 {
+
+	switch data[0] {
+	case 1:
+		hasher := hmac.New(md5.New, globals.apiKeySalt)
+		hasher.Write(data[:apikeyVersion+apikeyAppID+apikeySequence+apikeyWho])
+		check := hasher.Sum(nil)
+		if !bytes.Equal(data[apikeyVersion+apikeyAppID+apikeySequence+apikeyWho:], check) {
+			logs.Warn.Println("invalid apikey signature")
+			return
+		}
+	case 2:
+		if !hmac.Equal(data[apikeyVersion+apikeyID:], apiKeySignature(data[:apikeyVersion+apikeyID])) {
+			logs.Warn.Println("invalid apikey signature")
+			return
+		}
+		keyId = types.Uid(binary.LittleEndian.Uint64(data[apikeyVersion:]))
+		if keyId.IsZero() {
+			return
+		}
+	default:
 		logs.Warn.Println("unknown appid signature algorithm ", data[0])
 		return
 	}
 
-	hasher := hmac.New(md5.New, globals.apiKeySalt)
-	hasher.Write(data[:apikeyVersion+apikeyAppID+apikeySequence+apikeyWho])
-	check := hasher.Sum(nil)
-	if !bytes.Equal(data[apikeyVersion+apikeyAppID+apikeySequence+apikeyWho:], check) {
-		logs.Warn.Println("invalid apikey signature")
-		return
+	isValid = true
+
+	return
+}
+
+// makeAPIKey generates the API key string for the stored key with the given ID.
+func makeAPIKey(keyId types.Uid) string {
+	var data [apikeyLength]byte
+	data[0] = 2
+	binary.LittleEndian.PutUint64(data[apikeyVersion:], uint64(keyId))
+	copy(data[apikeyVersion+apikeyID:], apiKeySignature(data[:apikeyVersion+apikeyID]))
+	return base64.URLEncoding.EncodeToString(data[:])
+}
+
+// apiKeySignature calculates the signature of the API key of version 2.
+func apiKeySignature(data []byte) []byte {
+	hasher := hmac.New(sha256.New, globals.apiKeySalt)
+	hasher.Write(data)
+	return hasher.Sum(nil)[:apikeySignatureV2]
+}
+
+// resolveAPIKey validates the API key and returns the corresponding stored key, or nil if the API key
+// is invalid, unknown or revoked.
+func resolveAPIKey(apikey string) *types.APIKey {
+	isValid, keyId := checkAPIKey(apikey)
+	if !isValid {
+		return nil
+	}
+	if keyId.IsZero() {
+		return legacyAPIKey
+	}
+	key, err := globals.apiKeyCache.get(keyId)
+	if err != nil {
+		logs.Warn.Println("failed to get apikey", keyId, err)
+		return nil
 	}
+	if key == nil || key.RevokedAt != nil {
+		logs.Warn.Println("unknown or revoked apikey", keyId)
+		return nil
+	}
+	return key
+}
 
-	isRoot = (data[apikeyVersion+apikeyAppID+apikeySequence] == 1)
+// apiKeyAllows checks if the value is present in the list of allowed values. Empty list allows all values.
+func apiKeyAllows(allowed types.StringSlice, value string) bool {
+	return len(allowed) == 0 || slices.Contains(allowed, value)
+}
 
-	isValid = true
+// apiKeyCacheEntry is a cached stored API key.
+type apiKeyCacheEntry struct {
+	// The key or nil if the key is not found.
+	key     *types.APIKey
+	expires time.Time
+}
 
-	return
+// apiKeyCache caches stored API keys to avoid reading them from the database on every request.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[types.Uid]apiKeyCacheEntry
+	// Time when the next message is allowed by the rate limit of the key.
+	rateNext map[types.Uid]time.Time
+}
+
+func newAPIKeyCache(ttl time.Duration) *apiKeyCache {
+	return &apiKeyCache{
+		ttl:      ttl,
+		entries:  make(map[types.Uid]apiKeyCacheEntry),
+		rateNext: make(map[types.Uid]time.Time),
+	}
+}
+
+// get returns the stored key with the given ID from cache or from the database. Returns nil if the
+// key is not found.
+func (c *apiKeyCache) get(keyId types.Uid) (*types.APIKey, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[keyId]
+	c.mu.Unlock()
+	if ok && entry.expires.After(now) {
+		return entry.key, nil
+	}
+
+	key, err := store.APIKeys.Get(keyId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	// Remove expired entries to keep the cache from growing.
+	for id, e := range c.entries {
+		if !e.expires.After(now) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[keyId] = apiKeyCacheEntry{key: key, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+
+	return key, nil
+}
+
+// invalidate removes the key from cache so the next request reads it from the database.
+func (c *apiKeyCache) invalidate(keyId types.Uid) {
+	c.mu.Lock()
+	delete(c.entries, keyId)
+	c.mu.Unlock()
+}
+
+// rateWait checks if a message is permitted under the rate limit of the API key. The limit is shared
+// by all sessions with the key. If permitted, the message is counted and 0 is returned, otherwise it
+// returns how long the client must wait. Up to key.RateBurst messages can be sent at once over the limit.
+func (c *apiKeyCache) rateWait(key *types.APIKey, now time.Time) time.Duration {
+	if key.RateLimit <= 0 {
+		return 0
+	}
+
+	interval := time.Minute / time.Duration(key.RateLimit)
+	// Allowance for sending messages ahead of schedule.
+	allowance := time.Duration(key.RateBurst) * interval
+
+	keyId := key.Uid()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	next := c.rateNext[keyId]
+	if next.Before(now) {
+		next = now
+	}
+	if wait := next.Sub(now) - allowance; wait > 0 {
+		return wait
+	}
+	c.rateNext[keyId] = next.Add(interval)
+	return 0
+}
+
+// apiKeyChanged drops the cached copy of the key on this and other cluster nodes after the key
+// was updated or revoked.
+func apiKeyChanged(keyId types.Uid) {
+	globals.apiKeyCache.invalidate(keyId)
+	if globals.cluster != nil {
+		globals.cluster.apiKeyChanged(keyId)
+	}
+}
+
+// apiKeyCheck enforces restrictions of the API key the session was created with. Returns
+// an error response if the message is not allowed, nil otherwise.
+func (s *Session) apiKeyCheck(msg *ClientComMessage, what string, now time.Time) *ServerComMessage {
+	if s.apiKey == nil || s.apiKey.Id == "" {
+		// Key of version 1 or a session without a key, e.g. gRPC.
+		return nil
+	}
+
+	// Re-read the key to pick up changes and revocation.
+	key, err := globals.apiKeyCache.get(s.apiKey.Uid())
+	if err != nil {
+		logs.Warn.Println("s.dispatch: failed to get apikey", s.apiKey.Id, err, s.sid)
+		return ErrUnknownReply(msg, now)
+	}
+	if key == nil || key.RevokedAt != nil {
+		logs.Warn.Println("s.dispatch: revoked apikey", s.apiKey.Id, s.sid)
+		return ErrAPIKeyRequired(now)
+	}
+
+	if !apiKeyAllows(key.Messages, what) {
+		logs.Warn.Println("s.dispatch: message not allowed by apikey", what, key.Id, s.sid)
+		return ErrPermissionDeniedReply(msg, now)
+	}
+
+	var scheme string
+	if msg.Login != nil {
+		scheme = msg.Login.Scheme
+	} else if msg.Acc != nil {
+		scheme = msg.Acc.Scheme
+	}
+	if scheme != "" && !apiKeyAllows(key.Schemes, scheme) {
+		logs.Warn.Println("s.dispatch: auth scheme not allowed by apikey", scheme, key.Id, s.sid)
+		return ErrPermissionDeniedReply(msg, now)
+	}
+
+	if wait := globals.apiKeyCache.rateWait(key, now); wait > 0 {
+		return ErrTooManyRequestsReply(msg, now, wait)
+	}
+	return nil
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+func setupTestAPIKeys(t *testing.T) *mock_store.MockAPIKeysPersistenceInterface {
+	ctrl := gomock.NewController(t)
+	kk := mock_store.NewMockAPIKeysPersistenceInterface(ctrl)
+	store.APIKeys = kk
+	globals.apiKeySalt = []byte("test-api-key-salt")
+	globals.apiKeyCache = newAPIKeyCache(time.Minute)
+	t.Cleanup(func() {
+		store.APIKeys = nil
+		globals.apiKeySalt = nil
+		globals.apiKeyCache = nil
+		ctrl.Finish()
+	})
+	return kk
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	setupTestAPIKeys(t)
+
+	keyId := types.Uid(1234)
+	apikey := makeAPIKey(keyId)
+	if isValid, id := checkAPIKey(apikey); !isValid || id != keyId {
+		t.Errorf("Valid key: expected %v, got %v %v", keyId, isValid, id)
+	}
+
+	// Tampered key ID.
+	tampered := []byte(apikey)
+	tampered[3] ^= 1
+	if isValid, _ := checkAPIKey(string(tampered)); isValid {
+		t.Error("Tampered key must be rejected")
+	}
+
+	// Key of version 1 generated by keygen with the salt from its README.
+	globals.apiKeySalt, _ = base64.StdEncoding.DecodeString("TC0Jzr8f28kAspXrb4UYccJUJ63b7CSA16n1qMxxGpw=")
+	if isValid, id := checkAPIKey("AQAAAAABAACGOIyP2vh5avSff5oVvMpk"); !isValid || !id.IsZero() {
+		t.Errorf("Legacy key: expected valid with zero ID, got %v %v", isValid, id)
+	}
+	if key := resolveAPIKey("AQAAAAABAACGOIyP2vh5avSff5oVvMpk"); key != legacyAPIKey {
+		t.Errorf("Legacy key: expected legacy key, got %v", key)
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	kk := setupTestAPIKeys(t)
+
+	active := &types.APIKey{ObjHeader: types.ObjHeader{Id: types.Uid(1).String()},
+		Origins: types.StringSlice{"https://web.example.com"}}
+	revokedAt := time.Now()
+	revoked := &types.APIKey{ObjHeader: types.ObjHeader{Id: types.Uid(2).String()}, RevokedAt: &revokedAt}
+	// Keys are cached.
+	kk.EXPECT().Get(active.Id).Return(active, nil).Times(1)
+	kk.EXPECT().Get(revoked.Id).Return(revoked, nil).Times(1)
+	kk.EXPECT().Get(types.Uid(3).String()).Return(nil, nil).Times(1)
+
+	for i, tc := range []struct {
+		keyId    types.Uid
+		origin   string
+		expected *types.APIKey
+	}{
+		{1, "https://web.example.com", active},
+		{1, "https://evil.example.com", nil},
+		{1, "", nil},
+		{2, "", nil},
+		{3, "", nil},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v0/channels", nil)
+		req.Header.Set("X-Tinode-APIKey", makeAPIKey(tc.keyId))
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if key := getAPIKey(req); key != tc.expected {
+			t.Errorf("%d: expected %v, got %v", i, tc.expected, key)
+		}
+	}
+}
+
+func TestDispatchAPIKeyRestrictions(t *testing.T) {
+	kk := setupTestAPIKeys(t)
+
+	key := &types.APIKey{
+		ObjHeader: types.ObjHeader{Id: types.Uid(1).String()},
+		Schemes:   types.StringSlice{"token"},
+		Messages:  types.StringSlice{"login", "note"},
+		RateLimit: 60,
+		RateBurst: 1,
+	}
+	kk.EXPECT().Get(key.Id).Return(key, nil).Times(1)
+
+	s := &Session{
+		send:   make(chan any, 10),
+		ver:    16,
+		apiKey: key,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	// Message not allowed.
+	s.dispatch(&ClientComMessage{Pub: &MsgClientPub{Id: "1", Topic: "grpabc"}})
+	// Auth scheme not allowed.
+	s.dispatch(&ClientComMessage{Login: &MsgClientLogin{Id: "2", Scheme: "basic", Secret: []byte("alice:password")}})
+	// {note} without authentication is silently ignored, two are within the rate limit, the third is not.
+	for range 3 {
+		s.dispatch(&ClientComMessage{Note: &MsgClientNote{Topic: "grpabc", What: "kp"}})
+	}
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusForbidden, http.StatusForbidden, http.StatusTooManyRequests}, t)
+}
+
+func TestAPIKeyRateWait(t *testing.T) {
+	cache := newAPIKeyCache(time.Minute)
+	key := &types.APIKey{ObjHeader: types.ObjHeader{Id: types.Uid(1).String()}, RateLimit: 60, RateBurst: 1}
+	other := &types.APIKey{ObjHeader: types.ObjHeader{Id: types.Uid(2).String()}, RateLimit: 60}
+
+	now := time.Now()
+	// The limit applies to the key, not to the session: reconnecting does not reset it.
+	for i := range 2 {
+		if wait := cache.rateWait(key, now); wait != 0 {
+			t.Errorf("%d: expected no wait, got %v", i, wait)
+		}
+	}
+	if wait := cache.rateWait(key, now); wait != time.Second {
+		t.Errorf("Expected 1s wait, got %v", wait)
+	}
+	// Keys are counted separately.
+	if wait := cache.rateWait(other, now); wait != 0 {
+		t.Errorf("Other key: expected no wait, got %v", wait)
+	}
+	if wait := cache.rateWait(key, now.Add(time.Second)); wait != 0 {
+		t.Errorf("Expected no wait after 1s, got %v", wait)
+	}
+}
@@ -0,0 +1,293 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Audit log of privileged and security-relevant actions.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Actions recorded in the audit log.
+const (
+	// Login on a new device with primary credentials. Token logins which continue an existing
+	// login on the device are not recorded.
+	auditLogin = "login"
+	// Failed login attempt.
+	auditLoginFailed = "login_failed"
+	// Change of the authentication secret, e.g. password.
+	auditAuthChange = "auth_change"
+	// Credential, e.g. email, added or confirmed.
+	auditCredAdd = "cred_add"
+	// Credential deleted.
+	auditCredDel = "cred_del"
+	// Message of the root user on behalf of another user.
+	auditObo = "obo"
+	// Change of the account state.
+	auditStateChange = "state_change"
+	// Transfer of the topic ownership.
+	auditOwnerChange = "owner_change"
+	// User deleted.
+	auditUserDelete = "user_delete"
+	// All sessions of the user terminated and logins revoked by the administrator.
+	auditLogout = "logout"
+	// User's data exported by the administrator.
+	auditExport = "export"
+)
+
+// Maximum length of the remote address in audit records.
+const auditMaxAddrLength = 64
+
+// Number of records waiting to be written. Records are dropped when the queue is full.
+const auditQueueSize = 1024
+
+// Failed logins from the same address are recorded at most once per this interval.
+const auditLoginFailedInterval = time.Minute
+
+// Maximum number of addresses tracked for failed logins before stale ones are purged.
+const auditLoginFailedMaxAddrs = 4096
+
+// auditConfig is the configuration of the audit log.
+type auditConfig struct {
+	Enabled bool `json:"enabled"`
+	// Sinks to write the log to: "db" for the database, "file" for a JSON lines file.
+	Sinks []string `json:"sinks"`
+	// Path to the file of the "file" sink.
+	File string `json:"file"`
+}
+
+// auditSink writes records of the audit log.
+type auditSink interface {
+	Write(rec *types.AuditRecord) error
+	Close() error
+}
+
+// auditLog writes records to all configured sinks in the background.
+type auditLog struct {
+	sinks []auditSink
+	// Records waiting to be written.
+	queue chan *types.AuditRecord
+	// Closed when the writer exits.
+	done chan struct{}
+
+	// Failed logins by address: time of the last record and the count of failures not recorded since.
+	failedLock sync.Mutex
+	failed     map[string]*auditFailedLogins
+}
+
+// auditFailedLogins counts failed logins from one address.
+type auditFailedLogins struct {
+	recorded   time.Time
+	suppressed int
+}
+
+// auditEntry is a record of the audit log as written to the file and returned by the admin API.
+type auditEntry struct {
+	Id         string         `json:"id"`
+	Time       time.Time      `json:"ts"`
+	Action     string         `json:"action"`
+	Actor      string         `json:"actor,omitempty"`
+	User       string         `json:"user,omitempty"`
+	Topic      string         `json:"topic,omitempty"`
+	RemoteAddr string         `json:"remote_addr,omitempty"`
+	Params     map[string]any `json:"params,omitempty"`
+}
+
+func newAuditLog(config *auditConfig) (*auditLog, error) {
+	if len(config.Sinks) == 0 {
+		return nil, errors.New("audit: no sinks configured")
+	}
+
+	al := &auditLog{}
+	for _, name := range config.Sinks {
+		var sink auditSink
+		switch name {
+		case "db":
+			sink = dbAuditSink{}
+		case "file":
+			if config.File == "" {
+				al.close()
+				return nil, errors.New("audit: missing file name")
+			}
+			file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				al.close()
+				return nil, err
+			}
+			sink = &fileAuditSink{file: file}
+		default:
+			al.close()
+			return nil, errors.New("audit: unknown sink '" + name + "'")
+		}
+		al.sinks = append(al.sinks, sink)
+	}
+
+	al.queue = make(chan *types.AuditRecord, auditQueueSize)
+	al.done = make(chan struct{})
+	al.failed = make(map[string]*auditFailedLogins)
+	go al.run()
+
+	return al, nil
+}
+
+// run writes queued records to all sinks until the queue is closed. Errors are logged
+// but not returned: failure to write the log does not fail the action.
+func (al *auditLog) run() {
+	defer close(al.done)
+	for rec := range al.queue {
+		for _, sink := range al.sinks {
+			if err := sink.Write(rec); err != nil {
+				logs.Err.Println("audit: failed to write record", rec.Action, rec.Id, err)
+			}
+		}
+	}
+}
+
+// record assigns ID and time to the record and queues it for writing. The caller is not blocked:
+// if the queue is full, the record is dropped.
+func (al *auditLog) record(rec *types.AuditRecord) {
+	rec.Id = store.Store.GetUidString()
+	rec.CreatedAt = types.TimeNow()
+	select {
+	case al.queue <- rec:
+	default:
+		logs.Err.Println("audit: queue full, record dropped", rec.Action, rec.Id)
+	}
+}
+
+// allowLoginFailed reports if a failed login from the given address should be recorded and returns
+// the number of failures from the address which were not recorded since the last record.
+// Clients which keep sending invalid credentials are recorded once per auditLoginFailedInterval.
+func (al *auditLog) allowLoginFailed(remoteAddr string) (bool, int) {
+	key := loginLimitAddrKey(loginLimitAddr(remoteAddr))
+	now := time.Now()
+
+	al.failedLock.Lock()
+	defer al.failedLock.Unlock()
+
+	if entry := al.failed[key]; entry != nil && now.Sub(entry.recorded) < auditLoginFailedInterval {
+		entry.suppressed++
+		return false, 0
+	}
+
+	if len(al.failed) >= auditLoginFailedMaxAddrs {
+		for k, entry := range al.failed {
+			if now.Sub(entry.recorded) >= auditLoginFailedInterval {
+				delete(al.failed, k)
+			}
+		}
+	}
+
+	var suppressed int
+	if entry := al.failed[key]; entry != nil {
+		suppressed = entry.suppressed
+		entry.recorded = now
+		entry.suppressed = 0
+	} else if len(al.failed) < auditLoginFailedMaxAddrs {
+		al.failed[key] = &auditFailedLogins{recorded: now}
+	}
+	return true, suppressed
+}
+
+// close writes the queued records and closes the sinks. No records can be added after close.
+func (al *auditLog) close() {
+	if al.queue != nil {
+		close(al.queue)
+		<-al.done
+	}
+	for _, sink := range al.sinks {
+		if err := sink.Close(); err != nil {
+			logs.Warn.Println("audit: failed to close sink", err)
+		}
+	}
+}
+
+// auditRecord writes a record to the audit log if the log is enabled. The actor and the user
+// may be zero if not known or not applicable.
+func auditRecord(action string, actor, user types.Uid, topic, remoteAddr string, params map[string]any) {
+	if globals.audit == nil {
+		return
+	}
+
+	rec := &types.AuditRecord{
+		Action: action,
+		Topic:  topic,
+		Params: params,
+	}
+	if !actor.IsZero() {
+		rec.Actor = actor.String()
+	}
+	if !user.IsZero() {
+		rec.User = user.String()
+	}
+	rec.RemoteAddr = loginLimitAddr(remoteAddr)
+	if len(rec.RemoteAddr) > auditMaxAddrLength {
+		rec.RemoteAddr = rec.RemoteAddr[:auditMaxAddrLength]
+	}
+	globals.audit.record(rec)
+}
+
+// auditEntryFromStore converts the stored record to its external representation.
+func auditEntryFromStore(rec *types.AuditRecord) *auditEntry {
+	entry := &auditEntry{
+		Id:         rec.Id,
+		Time:       rec.CreatedAt,
+		Action:     rec.Action,
+		Topic:      rec.Topic,
+		RemoteAddr: rec.RemoteAddr,
+		Params:     rec.Params,
+	}
+	if uid := types.ParseUid(rec.Actor); !uid.IsZero() {
+		entry.Actor = uid.UserId()
+	}
+	if uid := types.ParseUid(rec.User); !uid.IsZero() {
+		entry.User = uid.UserId()
+	}
+	return entry
+}
+
+// dbAuditSink writes the audit log to the database.
+type dbAuditSink struct{}
+
+func (dbAuditSink) Write(rec *types.AuditRecord) error {
+	return store.Audit.Add(rec)
+}
+
+func (dbAuditSink) Close() error {
+	return nil
+}
+
+// fileAuditSink appends the audit log to a file, one JSON object per line.
+type fileAuditSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func (fs *fileAuditSink) Write(rec *types.AuditRecord) error {
+	line, err := json.Marshal(auditEntryFromStore(rec))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	_, err = fs.file.Write(line)
+	return err
+}
+
+func (fs *fileAuditSink) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.file.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+func TestAuditFileSink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	saved := store.Store
+	store.Store = ss
+	t.Cleanup(func() {
+		store.Store = saved
+		globals.audit = nil
+		ctrl.Finish()
+	})
+	ss.EXPECT().GetUidString().Return("rec1")
+	ss.EXPECT().GetUidString().Return("rec2")
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// Records are appended to the existing file.
+	if err := os.WriteFile(path, []byte(`{"id":"rec0","action":"login"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	al, err := newAuditLog(&auditConfig{Enabled: true, Sinks: []string{"file"}, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.audit = al
+
+	auditRecord(auditObo, types.Uid(1), types.Uid(2), "grpabc", "10.0.0.1:1234", map[string]any{"what": "pub"})
+	auditRecord(auditLoginFailed, types.ZeroUid, types.ZeroUid, "", "[fe80::1]:443", nil)
+	al.close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(entries))
+	}
+	obo := entries[1]
+	if obo.Id != "rec1" || obo.Action != auditObo || obo.Actor != types.Uid(1).UserId() ||
+		obo.User != types.Uid(2).UserId() || obo.Topic != "grpabc" || obo.RemoteAddr != "10.0.0.1" ||
+		obo.Params["what"] != "pub" || obo.Time.IsZero() {
+		t.Errorf("Wrong obo record %+v", obo)
+	}
+	failed := entries[2]
+	if failed.Id != "rec2" || failed.Actor != "" || failed.User != "" || failed.RemoteAddr != "fe80::1" {
+		t.Errorf("Wrong failed login record %+v", failed)
+	}
+}
+
+func TestAuditConfig(t *testing.T) {
+	for i, config := range []*auditConfig{
+		{Enabled: true},
+		{Enabled: true, Sinks: []string{"syslog"}},
+		{Enabled: true, Sinks: []string{"db", "file"}},
+	} {
+		if _, err := newAuditLog(config); err == nil {
+			t.Errorf("%d: invalid config must be rejected", i)
+		}
+	}
+}
+
+func TestAuditLoginFailedLimit(t *testing.T) {
+	al := &auditLog{failed: make(map[string]*auditFailedLogins)}
+
+	if ok, suppressed := al.allowLoginFailed("10.0.0.1:1234"); !ok || suppressed != 0 {
+		t.Fatalf("First failure must be recorded, got %v %d", ok, suppressed)
+	}
+	for _, addr := range []string{"10.0.0.1:1235", "10.0.0.1:1236"} {
+		if ok, _ := al.allowLoginFailed(addr); ok {
+			t.Errorf("Repeated failure from %s must not be recorded", addr)
+		}
+	}
+	// Addresses in the same IPv6 /64 network are counted together.
+	if ok, _ := al.allowLoginFailed("[2001:db8::1]:443"); !ok {
+		t.Error("First failure from IPv6 address must be recorded")
+	}
+	if ok, _ := al.allowLoginFailed("[2001:db8::2]:443"); ok {
+		t.Error("Failure from the same IPv6 network must not be recorded")
+	}
+
+	// After the interval the failure is recorded with the count of skipped failures.
+	al.failed[loginLimitAddrKey("10.0.0.1")].recorded = time.Now().Add(-auditLoginFailedInterval)
+	if ok, suppressed := al.allowLoginFailed("10.0.0.1:1237"); !ok || suppressed != 2 {
+		t.Errorf("Expected failure recorded with 2 repeated, got %v %d", ok, suppressed)
+	}
+}
@@ -111,12 +111,16 @@ const (
 	FeatureValidated Feature = 1 << iota
 	// FeatureNoLogin is set if the token should not be used to permanently authenticate a session (L).
 	FeatureNoLogin
+	// FeatureSecondFactor is set if the user has passed the second authentication factor (F).
+	FeatureSecondFactor
+	// FeaturePasskey is set if the user has authenticated with a passkey (P).
+	FeaturePasskey
 )
 
 // MarshalText converts Feature to ASCII byte slice.
 func (f Feature) MarshalText() ([]byte, error) {
 	res := []byte{}
-	for i, chr := range []byte{'V', 'L'} {
+	for i, chr := range []byte{'V', 'L', 'F', 'P'} {
 		if (f & (1 << uint(i))) != 0 {
 			res = append(res, chr)
 		}
@@ -139,6 +143,10 @@ func (f *Feature) UnmarshalText(b []byte) error {
 					f0 |= int(FeatureValidated)
 				case 'L', 'l':
 					f0 |= int(FeatureNoLogin)
+				case 'F', 'f':
+					f0 |= int(FeatureSecondFactor)
+				case 'P', 'p':
+					f0 |= int(FeaturePasskey)
 				default:
 					err = errors.New("Feature: invalid character '" + string(b[i]) + "'")
 					break Loop
@@ -220,12 +228,17 @@ type Rec struct {
 	State types.ObjState
 	// Credential 'method:value' associated with this record.
 	Credential string `json:"cred,omitempty"`
+	// ID of the authenticated session (login on a device) the token belongs to.
+	SessionId types.Uid `json:"sid,omitempty"`
 
 	// Authenticator may request the server to create a new account.
 	// These are the account parameters which can be used for creating the account.
 	DefAcs  *types.DefaultAccess `json:"defacs,omitempty"`
 	Public  any                  `json:"public,omitempty"`
 	Private any                  `json:"private,omitempty"`
+
+	// Authenticator-specific data to return to the client, such as a key for an authenticator app.
+	Params map[string]any `json:"params,omitempty"`
 }
 
 // AuthHandler is the interface which auth providers must implement.
@@ -278,3 +291,22 @@ type AuthHandler interface {
 	// GetRealName returns the hardcoded name of the authenticator.
 	GetRealName() string
 }
+
+// SecondFactor is implemented by authenticators which verify the second authentication factor,
+// such as a one-time password, after the user is authenticated by another scheme.
+type SecondFactor interface {
+	// IsEnrolled checks if the user has enabled the second factor.
+	IsEnrolled(uid types.Uid) (bool, error)
+
+	// Verify checks the user's response to the second factor challenge.
+	// Returns types.ErrFailed if the response is invalid.
+	Verify(uid types.Uid, resp []byte, remoteAddr string) error
+}
+
+// LoginNamer is implemented by authenticators which identify the account by a login contained
+// in the secret, such as a user name. It's used for limiting failed login attempts per account.
+type LoginNamer interface {
+	// LoginName returns the login from the secret or an empty string if the secret is malformed.
+	// The login must be the unique value of the authentication record.
+	LoginName(secret []byte) string
+}
@@ -2,11 +2,14 @@
 package basic
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"os"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/tinode/chat/server/auth"
 	"github.com/tinode/chat/server/store"
@@ -21,8 +24,22 @@ const (
 	defaultMaxLoginLength = 32
 
 	defaultMinPasswordLength = 3
+
+	// Previous passwords are stored in one auth record as bcrypt hashes. SQL adapters limit the
+	// secret to 255 bytes which fits 4 hashes plus the current password.
+	maxPasswordHistory = 5
 )
 
+// Character classes which may be required in a password.
+var passwordCharClasses = map[string]func(rune) bool{
+	"lower": unicode.IsLower,
+	"upper": unicode.IsUpper,
+	"digit": unicode.IsDigit,
+	"symbol": func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+	},
+}
+
 // Token suitable as a login: starts and ends with a Unicode letter (class L) or number (class N),
 // contains Unicode letters, numbers, dot and underscore.
 var loginPattern = regexp.MustCompile(`^[\pL\pN][_.\pL\pN]*[\pL\pN]+$`)
@@ -34,6 +51,17 @@ type authenticator struct {
 
 	minPasswordLength int
 	minLoginLength    int
+
+	// Character classes which must be present in a password.
+	passwordCharClasses []string
+	// Lowercase breached or common passwords which cannot be used.
+	breachedPasswords map[string]struct{}
+	// Password must not contain the login.
+	noLoginInPassword bool
+	// Number of recent passwords, including the current one, which cannot be reused.
+	passwordHistory int
+	// Password must be reset after this period.
+	maxPasswordAge time.Duration
 }
 
 func (a *authenticator) checkLoginPolicy(uname string) error {
@@ -45,14 +73,91 @@ func (a *authenticator) checkLoginPolicy(uname string) error {
 	return nil
 }
 
-func (a *authenticator) checkPasswordPolicy(password string) error {
+func (a *authenticator) checkPasswordPolicy(uname, password string) error {
 	if len([]rune(password)) < a.minPasswordLength {
 		return types.ErrPolicy
 	}
 
+	for _, class := range a.passwordCharClasses {
+		if strings.IndexFunc(password, passwordCharClasses[class]) < 0 {
+			return types.ErrPolicy
+		}
+	}
+
+	lower := strings.ToLower(password)
+	if a.noLoginInPassword && strings.Contains(lower, uname) {
+		return types.ErrPolicy
+	}
+
+	if _, breached := a.breachedPasswords[lower]; breached {
+		return types.ErrPolicy
+	}
+
 	return nil
 }
 
+// checkPasswordHistory rejects the password if it matches the current or one of the previous passwords.
+// Returns hashes of the previous passwords, the most recent first.
+func (a *authenticator) checkPasswordHistory(uid types.Uid, passhash []byte, password string) ([]string, error) {
+	_, _, secret, _, err := store.Users.GetAuthRecord(uid, a.historyScheme())
+	if err != nil && err != types.ErrNotFound {
+		return nil, err
+	}
+	var history []string
+	if len(secret) > 0 {
+		history = strings.Split(string(secret), ",")
+	}
+	if len(history) >= a.passwordHistory {
+		// The current password is kept in the main record.
+		history = history[:a.passwordHistory-1]
+	}
+
+	for _, hash := range append([]string{string(passhash)}, history...) {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return nil, types.ErrPolicy
+		}
+	}
+	return history, nil
+}
+
+// savePasswordHistory adds the hash of the password being replaced to the history.
+func (a *authenticator) savePasswordHistory(uid types.Uid, passhash []byte, history []string) error {
+	history = append([]string{string(passhash)}, history...)
+	if len(history) >= a.passwordHistory {
+		history = history[:a.passwordHistory-1]
+	}
+
+	secret := []byte(strings.Join(history, ","))
+	err := store.Users.UpdateAuthRecord(uid, auth.LevelAuth, a.historyScheme(), uid.String(), secret, time.Time{})
+	if err == types.ErrNotFound {
+		err = store.Users.AddAuthRecord(uid, auth.LevelAuth, a.historyScheme(), uid.String(), secret, time.Time{})
+	}
+	return err
+}
+
+// Previous passwords are stored in a separate auth record.
+func (a *authenticator) historyScheme() string {
+	return a.name + "_ph"
+}
+
+// loadPasswordList reads a list of passwords, one per line.
+func loadPasswordList(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			list[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return list, scanner.Err()
+}
+
 func parseSecret(bsecret []byte) (uname, password string, err error) {
 	secret := string(bsecret)
 
@@ -82,6 +187,16 @@ func (a *authenticator) Init(jsonconf json.RawMessage, name string) error {
 		AddToTags         bool `json:"add_to_tags"`
 		MinPasswordLength int  `json:"min_password_length"`
 		MinLoginLength    int  `json:"min_login_length"`
+		// Password must contain characters of each listed class: "lower", "upper", "digit", "symbol".
+		PasswordCharClasses []string `json:"password_char_classes"`
+		// Path to a file with breached or common passwords, one per line.
+		BreachedPasswords string `json:"breached_passwords"`
+		// Reject passwords which contain the login.
+		NoLoginInPassword bool `json:"no_login_in_password"`
+		// Number of recent passwords, including the current one, which cannot be reused.
+		PasswordHistory int `json:"password_history"`
+		// Maximum age of a password in days.
+		MaxPasswordAge int `json:"max_password_age"`
 	}
 
 	var config configType
@@ -102,6 +217,28 @@ func (a *authenticator) Init(jsonconf json.RawMessage, name string) error {
 		a.minLoginLength = defaultMinLoginLength
 	}
 
+	for _, class := range config.PasswordCharClasses {
+		if passwordCharClasses[class] == nil {
+			return errors.New("auth_basic: unknown password character class '" + class + "'")
+		}
+	}
+	a.passwordCharClasses = config.PasswordCharClasses
+	if config.BreachedPasswords != "" {
+		var err error
+		if a.breachedPasswords, err = loadPasswordList(config.BreachedPasswords); err != nil {
+			return errors.New("auth_basic: failed to load breached passwords: " + err.Error())
+		}
+	}
+	a.noLoginInPassword = config.NoLoginInPassword
+	if config.PasswordHistory < 0 || config.PasswordHistory > maxPasswordHistory {
+		return errors.New("auth_basic: password_history exceeds the limit")
+	}
+	a.passwordHistory = config.PasswordHistory
+	if config.MaxPasswordAge < 0 {
+		return errors.New("auth_basic: invalid max_password_age")
+	}
+	a.maxPasswordAge = time.Duration(config.MaxPasswordAge) * 24 * time.Hour
+
 	return nil
 }
 
@@ -121,7 +258,7 @@ func (a *authenticator) AddRecord(rec *auth.Rec, secret []byte, remoteAddr strin
 		return nil, err
 	}
 
-	if err = a.checkPasswordPolicy(password); err != nil {
+	if err = a.checkPasswordPolicy(uname, password); err != nil {
 		return nil, err
 	}
 
@@ -131,7 +268,7 @@ func (a *authenticator) AddRecord(rec *auth.Rec, secret []byte, remoteAddr strin
 	}
 	var expires time.Time
 	if rec.Lifetime > 0 {
-		expires = time.Now().Add(time.Duration(rec.Lifetime)).UTC().Round(time.Millisecond)
+		expires = types.TimeNow().Add(time.Duration(rec.Lifetime))
 	}
 
 	authLevel := rec.AuthLevel
@@ -158,7 +295,7 @@ func (a *authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr st
 		return nil, err
 	}
 
-	login, authLevel, _, _, err := store.Users.GetAuthRecord(rec.Uid, a.name)
+	login, authLevel, oldhash, _, err := store.Users.GetAuthRecord(rec.Uid, a.name)
 	if err != nil {
 		return nil, err
 	}
@@ -179,10 +316,23 @@ func (a *authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr st
 		return nil, types.ErrDuplicate
 	}
 
-	if err = a.checkPasswordPolicy(password); err != nil {
+	if err = a.checkPasswordPolicy(uname, password); err != nil {
 		return nil, err
 	}
 
+	if a.passwordHistory > 0 {
+		history, err := a.checkPasswordHistory(rec.Uid, oldhash, password)
+		if err != nil {
+			return nil, err
+		}
+		// Save the history first: an extra entry is harmless if the update fails.
+		if a.passwordHistory > 1 {
+			if err = a.savePasswordHistory(rec.Uid, oldhash, history); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	passhash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, types.ErrInternal
@@ -227,10 +377,6 @@ func (a *authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Re
 		// Invalid login.
 		return nil, nil, types.ErrFailed
 	}
-	if !expires.IsZero() && expires.Before(time.Now()) {
-		// The record has expired
-		return nil, nil, types.ErrExpired
-	}
 
 	err = bcrypt.CompareHashAndPassword(passhash, []byte(password))
 	if err != nil {
@@ -238,6 +384,23 @@ func (a *authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Re
 		return nil, nil, types.ErrFailed
 	}
 
+	if !expires.IsZero() && expires.Before(time.Now()) {
+		// The record has expired
+		return nil, nil, types.ErrExpired
+	}
+
+	if a.maxPasswordAge > 0 {
+		// The age is counted from the last change of the password, so the limit can be changed or removed
+		// at any time.
+		updated, err := store.Users.GetAuthUpdated(uid, a.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !updated.IsZero() && updated.Add(a.maxPasswordAge).Before(time.Now()) {
+			return nil, nil, types.ErrResetRequired
+		}
+	}
+
 	var lifetime time.Duration
 	if !expires.IsZero() {
 		lifetime = time.Until(expires)
@@ -250,6 +413,15 @@ func (a *authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Re
 		State:     types.StateUndefined}, nil, nil
 }
 
+// LoginName returns the user name from the secret.
+func (a *authenticator) LoginName(secret []byte) string {
+	uname, _, err := parseSecret(secret)
+	if err != nil {
+		return ""
+	}
+	return uname
+}
+
 // AsTag convert search token into a prefixed tag, if possible.
 func (a *authenticator) AsTag(token string) string {
 	if !a.addToTags {
@@ -292,6 +464,9 @@ func (authenticator) GenSecret(rec *auth.Rec) ([]byte, time.Time, error) {
 
 // DelRecords deletes saved authentication records of the given user.
 func (a *authenticator) DelRecords(uid types.Uid) error {
+	if err := store.Users.DelAuthRecords(uid, a.historyScheme()); err != nil {
+		return err
+	}
 	return store.Users.DelAuthRecords(uid, a.name)
 }
 
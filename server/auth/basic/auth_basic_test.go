@@ -0,0 +1,158 @@
+package basic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAuthenticator(t *testing.T, config map[string]any) *authenticator {
+	jsconf, _ := json.Marshal(config)
+	a := &authenticator{}
+	if err := a.Init(jsconf, "basic"); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func setupUsersMock(t *testing.T) *mock_store.MockUsersPersistenceInterface {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	t.Cleanup(func() {
+		store.Users = nil
+		ctrl.Finish()
+	})
+	return uu
+}
+
+func testHash(password string) []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	return hash
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(breached, []byte("Password1!\n  qwerty123  \n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuthenticator(t, map[string]any{
+		"min_password_length":   8,
+		"password_char_classes": []string{"lower", "upper", "digit"},
+		"breached_passwords":    breached,
+		"no_login_in_password":  true,
+	})
+
+	for password, expected := range map[string]error{
+		"Xy7-pq2Lm":    nil,
+		"Пароль123":    nil,
+		"Xy7pq":        types.ErrPolicy, // Too short.
+		"xy7-pq2lm":    types.ErrPolicy, // No uppercase.
+		"Xyz-pqrLm":    types.ErrPolicy, // No digits.
+		"PASSWORD1!":   types.ErrPolicy, // Breached, case-insensitive.
+		"QWERTY123":    types.ErrPolicy, // Breached, whitespace is trimmed.
+		"My1ALICEpass": types.ErrPolicy, // Contains the login.
+	} {
+		if err := a.checkPasswordPolicy("alice", password); err != expected {
+			t.Errorf("%q: expected %v, got %v", password, expected, err)
+		}
+	}
+
+	if err := (&authenticator{}).Init([]byte(`{"password_char_classes":["emoji"]}`), "basic"); err == nil {
+		t.Error("Unknown character class must be rejected")
+	}
+	if err := (&authenticator{}).Init([]byte(`{"password_history":6}`), "basic"); err == nil {
+		t.Error("Password history over the limit must be rejected")
+	}
+}
+
+func TestUpdateRecordHistory(t *testing.T) {
+	a := newTestAuthenticator(t, map[string]any{"password_history": 2, "max_password_age": 90})
+	uu := setupUsersMock(t)
+
+	uid := types.Uid(1234)
+	current, previous, oldest := testHash("current"), testHash("previous"), testHash("oldest")
+	uu.EXPECT().GetAuthRecord(uid, "basic").Return("alice", auth.LevelAuth, current, time.Time{}, nil).AnyTimes()
+	uu.EXPECT().GetAuthRecord(uid, "basic_ph").
+		Return(uid.String(), auth.LevelAuth, []byte(string(previous)+","+string(oldest)), time.Time{}, nil).AnyTimes()
+
+	// The current and the previous passwords cannot be reused.
+	for _, password := range []string{"current", "previous"} {
+		if _, err := a.UpdateRecord(&auth.Rec{Uid: uid}, []byte(":"+password), ""); err != types.ErrPolicy {
+			t.Errorf("%q: expected ErrPolicy, got %v", password, err)
+		}
+	}
+
+	// The oldest password is beyond the history of 2 passwords.
+	uu.EXPECT().UpdateAuthRecord(uid, auth.LevelAuth, "basic_ph", uid.String(), current, time.Time{}).Return(nil)
+	uu.EXPECT().UpdateAuthRecord(uid, auth.LevelAuth, "basic", "alice", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ types.Uid, _ auth.Level, _, _ string, secret []byte, expires time.Time) error {
+			if bcrypt.CompareHashAndPassword(secret, []byte("oldest")) != nil {
+				t.Error("New password hash does not match")
+			}
+			// The age of the password is not recorded as the expiration time.
+			if !expires.IsZero() {
+				t.Errorf("Password expiration: expected none, got %v", expires)
+			}
+			return nil
+		})
+	if _, err := a.UpdateRecord(&auth.Rec{Uid: uid}, []byte(":oldest"), ""); err != nil {
+		t.Errorf("Update failed: %v", err)
+	}
+}
+
+func TestAuthenticateMaxPasswordAge(t *testing.T) {
+	a := newTestAuthenticator(t, map[string]any{"max_password_age": 90})
+	uu := setupUsersMock(t)
+
+	uid := types.Uid(1234)
+	expired := time.Now().Add(-time.Hour)
+	uu.EXPECT().GetAuthUniqueRecord("basic", "alice").Return(uid, auth.LevelAuth, testHash("secret"), expired, nil).Times(2)
+
+	// Expiration is not disclosed without the valid password.
+	if _, _, err := a.Authenticate([]byte("alice:wrong"), ""); err != types.ErrFailed {
+		t.Errorf("Wrong password: expected ErrFailed, got %v", err)
+	}
+	// The lifetime of the record is unrelated to the age of the password.
+	if _, _, err := a.Authenticate([]byte("Alice:secret"), ""); err != types.ErrExpired {
+		t.Errorf("Expired record: expected ErrExpired, got %v", err)
+	}
+
+	// The age is counted from the last change of the password.
+	uu.EXPECT().GetAuthUniqueRecord("basic", "bob").Return(uid, auth.LevelAuth, testHash("secret"), time.Time{}, nil).Times(2)
+	uu.EXPECT().GetAuthUpdated(uid, "basic").Return(time.Now().Add(-91*24*time.Hour), nil)
+	if _, _, err := a.Authenticate([]byte("bob:secret"), ""); err != types.ErrResetRequired {
+		t.Errorf("Old password: expected ErrResetRequired, got %v", err)
+	}
+	uu.EXPECT().GetAuthUpdated(uid, "basic").Return(time.Now().Add(-89*24*time.Hour), nil)
+	if rec, _, err := a.Authenticate([]byte("bob:secret"), ""); err != nil || rec.Uid != uid {
+		t.Errorf("Recent password: expected success, got %v", err)
+	}
+}
+
+func TestDelRecordsHistory(t *testing.T) {
+	a := newTestAuthenticator(t, map[string]any{"password_history": 2})
+	uu := setupUsersMock(t)
+
+	uid := types.Uid(1234)
+	uu.EXPECT().DelAuthRecords(uid, "basic_ph").Return(nil)
+	uu.EXPECT().DelAuthRecords(uid, "basic").Return(nil)
+	if err := a.DelRecords(uid); err != nil {
+		t.Error(err)
+	}
+
+	if name := a.LoginName([]byte("Alice:" + strings.Repeat("x", 8))); name != "alice" {
+		t.Errorf("LoginName: expected 'alice', got %q", name)
+	}
+}
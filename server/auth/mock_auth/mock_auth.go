@@ -213,3 +213,92 @@ func (mr *MockAuthHandlerMockRecorder) UpdateRecord(rec, secret, remoteAddr inte
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRecord", reflect.TypeOf((*MockAuthHandler)(nil).UpdateRecord), rec, secret, remoteAddr)
 }
+
+// MockSecondFactor is a mock of SecondFactor interface.
+type MockSecondFactor struct {
+	ctrl     *gomock.Controller
+	recorder *MockSecondFactorMockRecorder
+}
+
+// MockSecondFactorMockRecorder is the mock recorder for MockSecondFactor.
+type MockSecondFactorMockRecorder struct {
+	mock *MockSecondFactor
+}
+
+// NewMockSecondFactor creates a new mock instance.
+func NewMockSecondFactor(ctrl *gomock.Controller) *MockSecondFactor {
+	mock := &MockSecondFactor{ctrl: ctrl}
+	mock.recorder = &MockSecondFactorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSecondFactor) EXPECT() *MockSecondFactorMockRecorder {
+	return m.recorder
+}
+
+// IsEnrolled mocks base method.
+func (m *MockSecondFactor) IsEnrolled(uid types.Uid) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsEnrolled", uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsEnrolled indicates an expected call of IsEnrolled.
+func (mr *MockSecondFactorMockRecorder) IsEnrolled(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsEnrolled", reflect.TypeOf((*MockSecondFactor)(nil).IsEnrolled), uid)
+}
+
+// Verify mocks base method.
+func (m *MockSecondFactor) Verify(uid types.Uid, resp []byte, remoteAddr string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", uid, resp, remoteAddr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockSecondFactorMockRecorder) Verify(uid, resp, remoteAddr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockSecondFactor)(nil).Verify), uid, resp, remoteAddr)
+}
+
+// MockLoginNamer is a mock of LoginNamer interface.
+type MockLoginNamer struct {
+	ctrl     *gomock.Controller
+	recorder *MockLoginNamerMockRecorder
+}
+
+// MockLoginNamerMockRecorder is the mock recorder for MockLoginNamer.
+type MockLoginNamerMockRecorder struct {
+	mock *MockLoginNamer
+}
+
+// NewMockLoginNamer creates a new mock instance.
+func NewMockLoginNamer(ctrl *gomock.Controller) *MockLoginNamer {
+	mock := &MockLoginNamer{ctrl: ctrl}
+	mock.recorder = &MockLoginNamerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLoginNamer) EXPECT() *MockLoginNamerMockRecorder {
+	return m.recorder
+}
+
+// LoginName mocks base method.
+func (m *MockLoginNamer) LoginName(secret []byte) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoginName", secret)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// LoginName indicates an expected call of LoginName.
+func (mr *MockLoginNamerMockRecorder) LoginName(secret interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoginName", reflect.TypeOf((*MockLoginNamer)(nil).LoginName), secret)
+}
@@ -0,0 +1,755 @@
+// Package oidc implements authentication by OpenID Connect ID tokens issued by trusted identity providers.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Default lifetime of cached signing keys.
+	defaultJWKSRefresh = time.Hour
+	// Keys are not re-fetched more often than this when a token is signed by an unknown key.
+	minJWKSRefresh = time.Minute
+	// Default allowance for clock skew between the server and the identity provider.
+	defaultLeeway = time.Minute
+	// Timeout of requests to the identity provider.
+	httpTimeout = 10 * time.Second
+	// Length of the hashed issuer and subject used as the unique ID of the auth record.
+	uniqueLength = 16
+)
+
+// issuer is a trusted identity provider.
+type issuer struct {
+	// Value of the 'iss' claim.
+	Issuer string `json:"issuer"`
+	// Value which must be present in the 'aud' claim.
+	ClientID string `json:"client_id"`
+	// URL of the JSON Web Key Set. If missing, it's discovered from the OpenID configuration of the issuer.
+	JWKSURL string `json:"jwks_url"`
+
+	// Cached signing keys by key ID.
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// authenticator is the type to map authentication methods to.
+type authenticator struct {
+	// Logical name of this authenticator.
+	name string
+	// Trusted identity providers by issuer ID.
+	issuers map[string]*issuer
+	// Lifetime of cached signing keys.
+	jwksRefresh time.Duration
+	// Allowance for clock skew.
+	leeway time.Duration
+	// Create accounts for new users.
+	allowNewAccounts bool
+	// Default access of new accounts.
+	accessAuth types.AccessMode
+	accessAnon types.AccessMode
+	// Tag namespaces managed by the identity provider mapped to the claims they are taken from.
+	tagClaims map[string]string
+	// Users with rootValue in the rootClaim are authenticated as root.
+	rootClaim string
+	rootValue string
+
+	client *http.Client
+}
+
+// Init initializes the handler.
+func (a *authenticator) Init(jsonconf json.RawMessage, name string) error {
+	if name == "" {
+		return errors.New("auth_oidc: authenticator name cannot be blank")
+	}
+
+	if a.name != "" {
+		return errors.New("auth_oidc: already initialized as " + a.name + "; " + name)
+	}
+
+	type configType struct {
+		// Trusted identity providers.
+		Issuers []*issuer `json:"issuers"`
+		// Lifetime of cached signing keys in seconds.
+		JWKSRefresh int `json:"jwks_refresh"`
+		// Allowance for clock skew in seconds.
+		Leeway int `json:"leeway"`
+		// Create accounts for users who log in for the first time.
+		AllowNewAccounts bool `json:"allow_new_accounts"`
+		// Default access mode of new accounts.
+		DefaultAccess struct {
+			Auth string `json:"auth"`
+			Anon string `json:"anon"`
+		} `json:"default_access"`
+		// Tag namespaces mapped to claims, e.g. {"corp": "preferred_username"}.
+		TagClaims map[string]string `json:"tag_claims"`
+		// Claim and its value which grant root access, e.g. "groups" and "chat-admins".
+		RootClaim string `json:"root_claim"`
+		RootValue string `json:"root_value"`
+	}
+
+	var config configType
+	if err := json.Unmarshal(jsonconf, &config); err != nil {
+		return errors.New("auth_oidc: failed to parse config: " + err.Error() + "(" + string(jsonconf) + ")")
+	}
+
+	if len(config.Issuers) == 0 {
+		return errors.New("auth_oidc: no issuers configured")
+	}
+	a.issuers = make(map[string]*issuer, len(config.Issuers))
+	for _, iss := range config.Issuers {
+		if iss.Issuer == "" || iss.ClientID == "" {
+			return errors.New("auth_oidc: issuer and client_id are required")
+		}
+		if iss.JWKSURL != "" {
+			if u, err := url.Parse(iss.JWKSURL); err != nil || !u.IsAbs() {
+				return errors.New("auth_oidc: invalid jwks_url '" + iss.JWKSURL + "'")
+			}
+		}
+		a.issuers[iss.Issuer] = iss
+	}
+
+	a.accessAuth = types.ModeCAuth
+	if config.DefaultAccess.Auth != "" {
+		if err := a.accessAuth.UnmarshalText([]byte(config.DefaultAccess.Auth)); err != nil {
+			return errors.New("auth_oidc: invalid default_access: " + err.Error())
+		}
+	}
+	a.accessAnon = types.ModeNone
+	if config.DefaultAccess.Anon != "" {
+		if err := a.accessAnon.UnmarshalText([]byte(config.DefaultAccess.Anon)); err != nil {
+			return errors.New("auth_oidc: invalid default_access: " + err.Error())
+		}
+	}
+
+	for ns := range config.TagClaims {
+		if ns == "" || strings.Contains(ns, ":") {
+			return errors.New("auth_oidc: invalid tag namespace '" + ns + "'")
+		}
+	}
+
+	a.name = name
+	a.jwksRefresh = time.Duration(config.JWKSRefresh) * time.Second
+	if a.jwksRefresh <= 0 {
+		a.jwksRefresh = defaultJWKSRefresh
+	}
+	a.leeway = time.Duration(config.Leeway) * time.Second
+	if a.leeway <= 0 {
+		a.leeway = defaultLeeway
+	}
+	a.allowNewAccounts = config.AllowNewAccounts
+	a.tagClaims = config.TagClaims
+	a.rootClaim = config.RootClaim
+	a.rootValue = config.RootValue
+	a.client = &http.Client{Timeout: httpTimeout}
+
+	return nil
+}
+
+// IsInitialized returns true if the handler is initialized.
+func (a *authenticator) IsInitialized() bool {
+	return a.name != ""
+}
+
+// AddRecord links the identity from the ID token to a new account.
+func (a *authenticator) AddRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	claims, err := a.verify(string(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	authLevel := a.authLevel(claims)
+	if err = store.Users.AddAuthRecord(rec.Uid, authLevel, a.name, uniqueID(claims),
+		[]byte(claims.Subject), time.Time{}); err != nil {
+		return nil, err
+	}
+
+	rec.AuthLevel = authLevel
+	rec.Tags = append(rec.Tags, a.tags(claims)...)
+	return rec, nil
+}
+
+// UpdateRecord links the account to a different identity.
+func (a *authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	claims, err := a.verify(string(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	unique := uniqueID(claims)
+	uid, _, _, _, err := store.Users.GetAuthUniqueRecord(a.name, unique)
+	if err != nil {
+		return nil, err
+	}
+	if uid == rec.Uid {
+		// Already linked.
+		return rec, nil
+	}
+	if !uid.IsZero() {
+		// The identity is linked to another account.
+		return nil, types.ErrDuplicate
+	}
+
+	authLevel := a.authLevel(claims)
+	err = store.Users.UpdateAuthRecord(rec.Uid, authLevel, a.name, unique, []byte(claims.Subject), time.Time{})
+	if err == types.ErrNotFound {
+		err = store.Users.AddAuthRecord(rec.Uid, authLevel, a.name, unique, []byte(claims.Subject), time.Time{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec.Tags = a.replaceTags(rec.Tags, a.tags(claims))
+	return rec, nil
+}
+
+// Authenticate checks the ID token and returns the linked account, creating it if allowed.
+func (a *authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Rec, []byte, error) {
+	claims, err := a.verify(string(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authLevel := a.authLevel(claims)
+	tags := a.tags(claims)
+	unique := uniqueID(claims)
+
+	uid, _, _, _, err := store.Users.GetAuthUniqueRecord(a.name, unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if uid.IsZero() {
+		if !a.allowNewAccounts {
+			// The identity is not linked to any account.
+			return nil, nil, types.ErrFailed
+		}
+		if uid, err = a.newAccount(claims, authLevel, tags); err != nil {
+			return nil, nil, err
+		}
+	} else if len(a.tagClaims) > 0 {
+		// Tags are managed by the identity provider, update them.
+		if err = a.syncTags(uid, tags); err != nil {
+			logs.Warn.Println("oidc_auth: failed to update tags", uid, err)
+		}
+	}
+
+	return &auth.Rec{
+		Uid:       uid,
+		AuthLevel: authLevel,
+		// The identity provider is trusted to have verified the user.
+		Features: auth.FeatureValidated,
+		Tags:     tags,
+		State:    types.StateUndefined}, nil, nil
+}
+
+// AsTag is not supported, will produce an empty string.
+func (authenticator) AsTag(token string) string {
+	return ""
+}
+
+// IsUnique checks if the identity from the ID token is not linked to an account yet.
+func (a *authenticator) IsUnique(secret []byte, remoteAddr string) (bool, error) {
+	claims, err := a.verify(string(secret))
+	if err != nil {
+		return false, err
+	}
+
+	uid, _, _, _, err := store.Users.GetAuthUniqueRecord(a.name, uniqueID(claims))
+	if err != nil {
+		return false, err
+	}
+	if uid.IsZero() {
+		return true, nil
+	}
+	return false, types.ErrDuplicate
+}
+
+// GenSecret is not supported, generates an error.
+func (authenticator) GenSecret(rec *auth.Rec) ([]byte, time.Time, error) {
+	return nil, time.Time{}, types.ErrUnsupported
+}
+
+// DelRecords deletes saved authentication records of the given user.
+func (a *authenticator) DelRecords(uid types.Uid) error {
+	return store.Users.DelAuthRecords(uid, a.name)
+}
+
+// RestrictedTags returns tag namespaces managed by the identity provider.
+func (a *authenticator) RestrictedTags() ([]string, error) {
+	var prefixes []string
+	for ns := range a.tagClaims {
+		prefixes = append(prefixes, ns)
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+// GetResetParams is not supported: secrets are managed by the identity provider.
+func (authenticator) GetResetParams(uid types.Uid) (map[string]any, error) {
+	return nil, types.ErrUnsupported
+}
+
+const realName = "oidc"
+
+// GetRealName returns the hardcoded name of the authenticator.
+func (authenticator) GetRealName() string {
+	return realName
+}
+
+// newAccount creates an account for the user who logs in for the first time.
+func (a *authenticator) newAccount(claims *idClaims, authLevel auth.Level, tags []string) (types.Uid, error) {
+	user := types.User{
+		State: types.StateOK,
+		Tags:  tags,
+	}
+	if claims.Name != "" {
+		user.Public = map[string]any{"fn": claims.Name}
+	}
+	user.Access.Auth = a.accessAuth
+	user.Access.Anon = a.accessAnon
+	if _, err := store.Users.Create(&user, nil); err != nil {
+		return types.ZeroUid, err
+	}
+
+	if err := store.Users.AddAuthRecord(user.Uid(), authLevel, a.name, uniqueID(claims),
+		[]byte(claims.Subject), time.Time{}); err != nil {
+		// Best effort to delete the incomplete account.
+		store.Users.Delete(user.Uid(), true)
+		return types.ZeroUid, err
+	}
+	return user.Uid(), nil
+}
+
+// authLevel returns the authentication level granted by the claims.
+func (a *authenticator) authLevel(claims *idClaims) auth.Level {
+	if a.rootClaim != "" {
+		for _, val := range claims.values(a.rootClaim) {
+			if val == a.rootValue {
+				return auth.LevelRoot
+			}
+		}
+	}
+	return auth.LevelAuth
+}
+
+// tags converts claims to tags in the namespaces managed by the identity provider.
+func (a *authenticator) tags(claims *idClaims) []string {
+	var tags []string
+	for ns, claim := range a.tagClaims {
+		for _, val := range claims.values(claim) {
+			if val = strings.ToLower(strings.TrimSpace(val)); val != "" {
+				tags = append(tags, ns+":"+val)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// isManagedTag checks if the tag belongs to a namespace managed by the identity provider.
+func (a *authenticator) isManagedTag(tag string) bool {
+	if ns, _, ok := strings.Cut(tag, ":"); ok {
+		_, ok = a.tagClaims[ns]
+		return ok
+	}
+	return false
+}
+
+// replaceTags replaces managed tags in the list with the new ones.
+func (a *authenticator) replaceTags(current, managed []string) []string {
+	var tags []string
+	for _, tag := range current {
+		if !a.isManagedTag(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return append(tags, managed...)
+}
+
+// syncTags updates user's managed tags to match the claims.
+func (a *authenticator) syncTags(uid types.Uid, tags []string) error {
+	user, err := store.Users.Get(uid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return types.ErrUserNotFound
+	}
+
+	want := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		want[tag] = true
+	}
+	var remove []string
+	for _, tag := range user.Tags {
+		if !a.isManagedTag(tag) {
+			continue
+		}
+		if want[tag] {
+			delete(want, tag)
+		} else {
+			remove = append(remove, tag)
+		}
+	}
+	var add []string
+	for tag := range want {
+		add = append(add, tag)
+	}
+	if len(add)+len(remove) == 0 {
+		return nil
+	}
+	sort.Strings(add)
+	_, err = store.Users.UpdateTags(uid, add, remove, nil)
+	return err
+}
+
+// uniqueID converts issuer and subject into a short ID which fits into the auth record.
+func uniqueID(claims *idClaims) string {
+	sum := sha256.Sum256([]byte(claims.Issuer + " " + claims.Subject))
+	return base64.RawURLEncoding.EncodeToString(sum[:uniqueLength])
+}
+
+// idClaims are the claims of an ID token.
+type idClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expires   float64  `json:"exp"`
+	NotBefore float64  `json:"nbf"`
+	Name      string   `json:"name"`
+
+	// All claims.
+	raw map[string]any
+}
+
+// values returns the values of a string or a string array claim.
+func (c *idClaims) values(claim string) []string {
+	switch val := c.raw[claim].(type) {
+	case string:
+		return []string{val}
+	case []any:
+		var vals []string
+		for _, v := range val {
+			if s, ok := v.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	}
+	return nil
+}
+
+// audience is the 'aud' claim which is either a string or an array of strings.
+type audience []string
+
+func (aud *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+func (aud audience) contains(clientID string) bool {
+	for _, a := range aud {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signature and validity of the ID token and returns its claims.
+func (a *authenticator) verify(token string) (*idClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, types.ErrMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, types.ErrMalformed
+	}
+	var claims idClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, types.ErrMalformed
+	}
+	if err := decodeSegment(parts[1], &claims.raw); err != nil {
+		return nil, types.ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, types.ErrMalformed
+	}
+
+	iss := a.issuers[claims.Issuer]
+	if iss == nil {
+		return nil, types.ErrFailed
+	}
+	key, err := a.signingKey(iss, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, types.ErrFailed
+	}
+
+	now := time.Now()
+	if claims.Expires == 0 || now.After(time.Unix(int64(claims.Expires), 0).Add(a.leeway)) {
+		return nil, types.ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(a.leeway).Before(time.Unix(int64(claims.NotBefore), 0)) {
+		return nil, types.ErrFailed
+	}
+	if !claims.Audience.contains(iss.ClientID) || claims.Subject == "" {
+		return nil, types.ErrFailed
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks JWS signature of the RS* and ES* algorithms.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported algorithm " + alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			return errors.New("algorithm does not match the key")
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			return errors.New("algorithm does not match the key")
+		}
+		// The signature is a concatenation of R and S of the same length.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key type")
+}
+
+// signingKey returns the issuer's key with the given ID, fetching the keys if needed.
+func (a *authenticator) signingKey(iss *issuer, kid string) (crypto.PublicKey, error) {
+	iss.lock.Lock()
+	defer iss.lock.Unlock()
+
+	key, ok := iss.findKey(kid)
+	age := time.Since(iss.fetched)
+	// Keys are rotated by the identity provider: fetch them periodically and when an unknown key is used.
+	if age > a.jwksRefresh || (!ok && age > minJWKSRefresh) {
+		if err := a.fetchKeys(iss); err != nil {
+			logs.Warn.Println("oidc_auth: failed to fetch keys of", iss.Issuer, err)
+			if !ok {
+				return nil, types.ErrInternal
+			}
+			// Keep using the cached key.
+		} else {
+			key, ok = iss.findKey(kid)
+		}
+	}
+	if !ok {
+		return nil, types.ErrFailed
+	}
+	return key, nil
+}
+
+// findKey finds the key by ID. A token without key ID can be checked if the issuer has only one key.
+func (iss *issuer) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(iss.keys) == 1 {
+		for _, key := range iss.keys {
+			return key, true
+		}
+	}
+	key, ok := iss.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the JSON Web Key Set of the issuer. Must be called with iss.lock held.
+func (a *authenticator) fetchKeys(iss *issuer) error {
+	// The attempt is counted even if it fails to avoid hammering the identity provider.
+	iss.fetched = time.Now()
+
+	if iss.JWKSURL == "" {
+		// Discover the JWKS URL from the OpenID configuration.
+		var config struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := a.getJSON(strings.TrimSuffix(iss.Issuer, "/")+"/.well-known/openid-configuration", &config); err != nil {
+			return err
+		}
+		if config.JWKSURI == "" {
+			return errors.New("missing jwks_uri in OpenID configuration")
+		}
+		iss.JWKSURL = config.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJSON(iss.JWKSURL, &jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for i := range jwks.Keys {
+		k := &jwks.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip unsupported keys.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	iss.keys = keys
+	return nil
+}
+
+func (a *authenticator) getJSON(url string, v any) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected HTTP response " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwk is a JSON Web Key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		// Make sure the point is on the curve.
+		if _, err = key.ECDH(); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	return nil, errors.New("unsupported key type " + k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func init() {
+	store.RegisterAuthScheme(realName, &authenticator{})
+}
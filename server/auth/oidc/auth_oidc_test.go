@@ -0,0 +1,281 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const testClientID = "tinode"
+
+// testIdP is a local stand-in for an identity provider.
+type testIdP struct {
+	server *httptest.Server
+	keys   map[string]*rsa.PrivateKey
+	// Number of JWKS requests.
+	fetches atomic.Int32
+}
+
+func newTestIdP(t *testing.T, kids ...string) *testIdP {
+	idp := &testIdP{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		idp.addKey(t, kid)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   idp.server.URL,
+			"jwks_uri": idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		var keys []map[string]string
+		for kid, key := range idp.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *testIdP) addKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.keys[kid] = key
+}
+
+// token issues an RS256-signed ID token.
+func (idp *testIdP) token(t *testing.T, kid string, claims map[string]any) []byte {
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + enc(claims)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.keys[kid], crypto.SHA256, digest.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(signed + "." + base64.RawURLEncoding.EncodeToString(sig))
+}
+
+func (idp *testIdP) claims(sub string) map[string]any {
+	now := time.Now().Unix()
+	return map[string]any{
+		"iss":    idp.server.URL,
+		"sub":    sub,
+		"aud":    testClientID,
+		"iat":    now,
+		"exp":    now + 300,
+		"name":   "Alice Johnson",
+		"email":  "Alice@Example.com",
+		"groups": []string{"staff", "chat-admins"},
+	}
+}
+
+func newTestAuthenticator(t *testing.T, idp *testIdP, newAccounts bool) *authenticator {
+	config, _ := json.Marshal(map[string]any{
+		"issuers":            []map[string]string{{"issuer": idp.server.URL, "client_id": testClientID}},
+		"allow_new_accounts": newAccounts,
+		"tag_claims":         map[string]string{"corp": "email"},
+		"root_claim":         "groups",
+		"root_value":         "chat-admins",
+	})
+	a := &authenticator{}
+	if err := a.Init(config, "oidc"); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func setupUsersMock(t *testing.T) *mock_store.MockUsersPersistenceInterface {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	t.Cleanup(func() {
+		store.Users = nil
+		ctrl.Finish()
+	})
+	return uu
+}
+
+func TestAuthenticateExistingUser(t *testing.T) {
+	idp := newTestIdP(t, "k1")
+	a := newTestAuthenticator(t, idp, false)
+	uu := setupUsersMock(t)
+
+	uid := types.Uid(1234)
+	claims := idp.claims("alice")
+	uu.EXPECT().GetAuthUniqueRecord("oidc", uniqueID(&idClaims{Issuer: idp.server.URL, Subject: "alice"})).
+		Return(uid, auth.LevelAuth, []byte("alice"), time.Time{}, nil)
+	// Stale managed tag is replaced, other tags are kept.
+	uu.EXPECT().Get(uid).Return(&types.User{Tags: []string{"corp:old@example.com", "basic:alice"}}, nil)
+	uu.EXPECT().UpdateTags(uid, []string{"corp:alice@example.com"}, []string{"corp:old@example.com"}, nil).
+		Return(nil, nil)
+
+	rec, _, err := a.Authenticate(idp.token(t, "k1", claims), "")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if rec.Uid != uid {
+		t.Errorf("Uid: expected %s, got %s", uid, rec.Uid)
+	}
+	if rec.AuthLevel != auth.LevelRoot {
+		t.Errorf("Auth level: expected root, got %s", rec.AuthLevel)
+	}
+
+	// The keys are cached.
+	delete(claims, "groups")
+	uu.EXPECT().GetAuthUniqueRecord("oidc", gomock.Any()).Return(uid, auth.LevelAuth, nil, time.Time{}, nil)
+	uu.EXPECT().Get(uid).Return(&types.User{Tags: []string{"corp:alice@example.com"}}, nil)
+	if rec, _, err = a.Authenticate(idp.token(t, "k1", claims), ""); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if rec.AuthLevel != auth.LevelAuth {
+		t.Errorf("Auth level: expected auth, got %s", rec.AuthLevel)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("JWKS fetches: expected 1, got %d", n)
+	}
+}
+
+func TestAuthenticateNewAccount(t *testing.T) {
+	idp := newTestIdP(t, "k1")
+	a := newTestAuthenticator(t, idp, true)
+	uu := setupUsersMock(t)
+
+	uid := types.Uid(5678)
+	uu.EXPECT().GetAuthUniqueRecord("oidc", gomock.Any()).Return(types.ZeroUid, auth.LevelNone, nil, time.Time{}, nil)
+	uu.EXPECT().Create(gomock.Any(), nil).DoAndReturn(func(user *types.User, private any) (*types.User, error) {
+		if user.Access.Auth != types.ModeCAuth || user.Access.Anon != types.ModeNone {
+			t.Errorf("Default access: unexpected %+v", user.Access)
+		}
+		if fn := user.Public.(map[string]any)["fn"]; fn != "Alice Johnson" {
+			t.Errorf("Public name: expected 'Alice Johnson', got %v", fn)
+		}
+		if len(user.Tags) != 1 || user.Tags[0] != "corp:alice@example.com" {
+			t.Errorf("Tags: unexpected %v", user.Tags)
+		}
+		user.SetUid(uid)
+		return user, nil
+	})
+	uu.EXPECT().AddAuthRecord(uid, auth.LevelRoot, "oidc", gomock.Any(), []byte("alice"), time.Time{}).Return(nil)
+
+	rec, _, err := a.Authenticate(idp.token(t, "k1", idp.claims("alice")), "")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if rec.Uid != uid {
+		t.Errorf("Uid: expected %s, got %s", uid, rec.Uid)
+	}
+}
+
+func TestAuthenticateUnknownUser(t *testing.T) {
+	idp := newTestIdP(t, "k1")
+	a := newTestAuthenticator(t, idp, false)
+	uu := setupUsersMock(t)
+
+	uu.EXPECT().GetAuthUniqueRecord("oidc", gomock.Any()).Return(types.ZeroUid, auth.LevelNone, nil, time.Time{}, nil)
+
+	if _, _, err := a.Authenticate(idp.token(t, "k1", idp.claims("bob")), ""); err != types.ErrFailed {
+		t.Errorf("Expected ErrFailed, got %v", err)
+	}
+}
+
+func TestAuthenticateInvalidToken(t *testing.T) {
+	idp := newTestIdP(t, "k1")
+	a := newTestAuthenticator(t, idp, true)
+	// No store calls are expected.
+	setupUsersMock(t)
+
+	expired := idp.claims("alice")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAud := idp.claims("alice")
+	wrongAud["aud"] = []string{"someone-else"}
+	wrongIss := idp.claims("alice")
+	wrongIss["iss"] = "https://evil.example.com"
+	tampered := string(idp.token(t, "k1", idp.claims("alice")))
+	parts := strings.Split(tampered, ".")
+	forged, _ := json.Marshal(idp.claims("mallory"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+
+	for name, tc := range map[string]struct {
+		token []byte
+		err   error
+	}{
+		"expired":   {idp.token(t, "k1", expired), types.ErrExpired},
+		"audience":  {idp.token(t, "k1", wrongAud), types.ErrFailed},
+		"issuer":    {idp.token(t, "k1", wrongIss), types.ErrFailed},
+		"signature": {[]byte(strings.Join(parts, ".")), types.ErrFailed},
+		"malformed": {[]byte("not-a-token"), types.ErrMalformed},
+	} {
+		if _, _, err := a.Authenticate(tc.token, ""); err != tc.err {
+			t.Errorf("%s: expected %v, got %v", name, tc.err, err)
+		}
+	}
+}
+
+func TestAuthenticateKeyRotation(t *testing.T) {
+	idp := newTestIdP(t, "k1")
+	a := newTestAuthenticator(t, idp, false)
+	uu := setupUsersMock(t)
+	uid := types.Uid(1234)
+	uu.EXPECT().GetAuthUniqueRecord("oidc", gomock.Any()).Return(uid, auth.LevelAuth, nil, time.Time{}, nil).Times(2)
+	uu.EXPECT().Get(uid).Return(&types.User{Tags: []string{"corp:alice@example.com"}}, nil).Times(2)
+
+	if _, _, err := a.Authenticate(idp.token(t, "k1", idp.claims("alice")), ""); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	// The identity provider starts using a new key.
+	idp.addKey(t, "k2")
+	token := idp.token(t, "k2", idp.claims("alice"))
+	// Unknown keys are not fetched too often.
+	if _, _, err := a.Authenticate(token, ""); err != types.ErrFailed {
+		t.Errorf("Expected ErrFailed, got %v", err)
+	}
+	a.issuers[idp.server.URL].fetched = time.Now().Add(-minJWKSRefresh * 2)
+	if _, _, err := a.Authenticate(token, ""); err != nil {
+		t.Errorf("Authenticate with rotated key failed: %v", err)
+	}
+	if n := idp.fetches.Load(); n != 2 {
+		t.Errorf("JWKS fetches: expected 2, got %d", n)
+	}
+}
+
+func TestRestrictedTags(t *testing.T) {
+	idp := newTestIdP(t)
+	a := newTestAuthenticator(t, idp, false)
+	tags, err := a.RestrictedTags()
+	if err != nil || len(tags) != 1 || tags[0] != "corp" {
+		t.Errorf("Restricted tags: expected [corp], got %v, %v", tags, err)
+	}
+}
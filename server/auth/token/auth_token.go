@@ -24,7 +24,7 @@ type authenticator struct {
 }
 
 // tokenLayout defines positioning of various bytes in token.
-// [8:UID][4:expires][2:authLevel][2:serial-number][2:feature-bits][32:signature] = 50 bytes
+// [8:UID][4:expires][2:authLevel][2:serial-number][2:feature-bits][8:session][32:signature] = 58 bytes
 type tokenLayout struct {
 	// User ID.
 	Uid uint64
@@ -36,6 +36,18 @@ type tokenLayout struct {
 	SerialNumber uint16
 	// Bitmap with feature bits.
 	Features uint16
+	// ID of the authenticated session (login on a device) - to invalidate tokens of one device.
+	SessionId uint64
+}
+
+// legacyTokenLayout is the layout of tokens issued before sessions were introduced.
+// [8:UID][4:expires][2:authLevel][2:serial-number][2:feature-bits][32:signature] = 50 bytes
+type legacyTokenLayout struct {
+	Uid          uint64
+	Expires      uint32
+	AuthLevel    uint16
+	SerialNumber uint16
+	Features     uint16
 }
 
 // Init initializes the authenticator: parses the config and sets salt, serial number and lifetime.
@@ -95,23 +107,28 @@ func (authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr strin
 func (ta *authenticator) Authenticate(token []byte, remoteAddr string) (*auth.Rec, []byte, error) {
 	var tl tokenLayout
 	dataSize := binary.Size(&tl)
-	if len(token) < dataSize+sha256.Size {
+	if legacySize := binary.Size(&legacyTokenLayout{}); len(token) == legacySize+sha256.Size {
+		// Token issued by an older version of the server, not bound to a session.
+		var ltl legacyTokenLayout
+		binary.Read(bytes.NewReader(token), binary.LittleEndian, &ltl)
+		tl = tokenLayout{
+			Uid:          ltl.Uid,
+			Expires:      ltl.Expires,
+			AuthLevel:    ltl.AuthLevel,
+			SerialNumber: ltl.SerialNumber,
+			Features:     ltl.Features,
+		}
+		dataSize = legacySize
+	} else if len(token) < dataSize+sha256.Size {
 		// Token is too short
 		return nil, nil, types.ErrMalformed
-	}
-
-	buf := bytes.NewBuffer(token)
-	err := binary.Read(buf, binary.LittleEndian, &tl)
-	if err != nil {
+	} else if err := binary.Read(bytes.NewReader(token), binary.LittleEndian, &tl); err != nil {
 		return nil, nil, types.ErrMalformed
 	}
 
-	hbuf := new(bytes.Buffer)
-	binary.Write(hbuf, binary.LittleEndian, &tl)
-
 	// Check signature.
 	hasher := hmac.New(sha256.New, ta.hmacSalt)
-	hasher.Write(hbuf.Bytes())
+	hasher.Write(token[:dataSize])
 	if !hmac.Equal(token[dataSize:dataSize+sha256.Size], hasher.Sum(nil)) {
 		return nil, nil, types.ErrFailed
 	}
@@ -132,11 +149,25 @@ func (ta *authenticator) Authenticate(token []byte, remoteAddr string) (*auth.Re
 		return nil, nil, types.ErrExpired
 	}
 
+	uid := types.Uid(tl.Uid)
+	sessionId := types.Uid(tl.SessionId)
+	if !sessionId.IsZero() {
+		// Check if the session was revoked.
+		sess, err := store.AuthSessions.Get(sessionId.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		if sess == nil || sess.User != uid.String() {
+			return nil, nil, types.ErrFailed
+		}
+	}
+
 	return &auth.Rec{
-		Uid:       types.Uid(tl.Uid),
+		Uid:       uid,
 		AuthLevel: auth.Level(tl.AuthLevel),
 		Lifetime:  auth.Duration(time.Until(expires)),
 		Features:  auth.Feature(tl.Features),
+		SessionId: sessionId,
 		State:     types.StateUndefined}, nil, nil
 }
 
@@ -156,6 +187,7 @@ func (ta *authenticator) GenSecret(rec *auth.Rec) ([]byte, time.Time, error) {
 		AuthLevel:    uint16(rec.AuthLevel),
 		SerialNumber: uint16(ta.serialNumber),
 		Features:     uint16(rec.Features),
+		SessionId:    uint64(rec.SessionId),
 	}
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, &tl)
@@ -0,0 +1,110 @@
+package token
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+var testKey = []byte("la6YsO+bNX/+XIkOqc5Svw==la6YsO+bNX/+XIkOqc5Svw==")
+
+func newTestAuthenticator(t *testing.T) *authenticator {
+	config, _ := json.Marshal(map[string]any{"key": testKey, "serial_num": 1, "expire_in": 3600})
+	ta := &authenticator{}
+	if err := ta.Init(config, "token"); err != nil {
+		t.Fatal(err)
+	}
+	return ta
+}
+
+func setupSessionsMock(t *testing.T) *mock_store.MockAuthSessionPersistenceInterface {
+	ctrl := gomock.NewController(t)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+	store.AuthSessions = as
+	t.Cleanup(func() {
+		store.AuthSessions = nil
+		ctrl.Finish()
+	})
+	return as
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	ta := newTestAuthenticator(t)
+	as := setupSessionsMock(t)
+
+	uid := types.Uid(1234)
+	sessionId := types.Uid(5678)
+	token, _, err := ta.GenSecret(&auth.Rec{Uid: uid, AuthLevel: auth.LevelAuth, SessionId: sessionId})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess := &types.AuthSession{ObjHeader: types.ObjHeader{Id: sessionId.String()}, User: uid.String()}
+	as.EXPECT().Get(sessionId.String()).Return(sess, nil)
+	rec, _, err := ta.Authenticate(token, "")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if rec.Uid != uid || rec.SessionId != sessionId || rec.AuthLevel != auth.LevelAuth {
+		t.Errorf("Unexpected auth record %+v", rec)
+	}
+
+	// Revoked session.
+	as.EXPECT().Get(sessionId.String()).Return(nil, nil)
+	if _, _, err = ta.Authenticate(token, ""); err != types.ErrFailed {
+		t.Errorf("Revoked session: expected ErrFailed, got %v", err)
+	}
+
+	// Session of another user.
+	sess.User = types.Uid(1).String()
+	as.EXPECT().Get(sessionId.String()).Return(sess, nil)
+	if _, _, err = ta.Authenticate(token, ""); err != types.ErrFailed {
+		t.Errorf("Session of another user: expected ErrFailed, got %v", err)
+	}
+
+	// Tampered session ID.
+	token[len(token)-sha256.Size-1] ^= 1
+	if _, _, err = ta.Authenticate(token, ""); err != types.ErrFailed {
+		t.Errorf("Tampered token: expected ErrFailed, got %v", err)
+	}
+}
+
+func TestAuthenticateLegacyToken(t *testing.T) {
+	ta := newTestAuthenticator(t)
+	// No session lookups are expected.
+	setupSessionsMock(t)
+
+	uid := types.Uid(1234)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, &legacyTokenLayout{
+		Uid:          uint64(uid),
+		Expires:      uint32(time.Now().Add(time.Hour).Unix()),
+		AuthLevel:    uint16(auth.LevelAuth),
+		SerialNumber: 1,
+	})
+	hasher := hmac.New(sha256.New, testKey)
+	hasher.Write(buf.Bytes())
+	buf.Write(hasher.Sum(nil))
+
+	rec, _, err := ta.Authenticate(buf.Bytes(), "")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if rec.Uid != uid || !rec.SessionId.IsZero() {
+		t.Errorf("Unexpected auth record %+v", rec)
+	}
+
+	if _, _, err = ta.Authenticate(buf.Bytes()[:40], ""); err != types.ErrMalformed {
+		t.Errorf("Short token: expected ErrMalformed, got %v", err)
+	}
+}
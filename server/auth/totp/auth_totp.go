@@ -0,0 +1,425 @@
+// Package totp implements the second authentication factor by time-based one-time passwords (RFC 6238).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Length of the shared key in bytes.
+	keyLength = 20
+	// Length of a recovery code in bytes of randomness, encoded as 10 base32 characters.
+	recoveryCodeLength = 6
+	// Length of the stored hash of a recovery code in bytes.
+	recoveryHashLength = 8
+
+	defaultIssuer        = "Tinode"
+	defaultDigits        = 6
+	defaultPeriod        = 30
+	defaultSkew          = 1
+	defaultRecoveryCodes = 10
+	// Recovery codes must fit into the secret of one auth record.
+	maxRecoveryCodes = 16
+
+	// Prefix of the {acc} secret which requests to disable the second factor.
+	disablePrefix = "off:"
+)
+
+// Shared keys are encoded as base32 without padding, as expected by authenticator apps.
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// authenticator is a singleton instance of the authenticator.
+type authenticator struct {
+	name          string
+	issuer        string
+	digits        int
+	period        int64
+	skew          int64
+	recoveryCodes int
+}
+
+// record is the user's TOTP settings stored in the auth record as "<state>:<last step>:<key>".
+type record struct {
+	// Enrollment is completed: the user confirmed the key with a valid code.
+	enabled bool
+	// The last time step when a code was accepted. Codes cannot be reused.
+	lastStep int64
+	// Shared key.
+	key []byte
+}
+
+func (r *record) marshal() []byte {
+	state := "p"
+	if r.enabled {
+		state = "e"
+	}
+	return []byte(state + ":" + strconv.FormatInt(r.lastStep, 10) + ":" + keyEncoding.EncodeToString(r.key))
+}
+
+func parseRecord(secret []byte) (*record, error) {
+	parts := strings.SplitN(string(secret), ":", 3)
+	if len(parts) != 3 {
+		return nil, types.ErrInternal
+	}
+	step, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, types.ErrInternal
+	}
+	key, err := keyEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, types.ErrInternal
+	}
+	return &record{enabled: parts[0] == "e", lastStep: step, key: key}, nil
+}
+
+// Init initializes the authenticator: parses the config and sets internal state.
+func (ta *authenticator) Init(jsonconf json.RawMessage, name string) error {
+	if name == "" {
+		return errors.New("auth_totp: authenticator name cannot be blank")
+	}
+
+	if ta.name != "" {
+		return errors.New("auth_totp: already initialized as " + ta.name + "; " + name)
+	}
+
+	type configType struct {
+		// Name of the service shown in authenticator apps.
+		Issuer string `json:"issuer"`
+		// Number of digits in a code.
+		Digits int `json:"digits"`
+		// Validity period of a code in seconds.
+		Period int `json:"period"`
+		// Number of periods before and after the current one when the code is still accepted.
+		Skew int `json:"skew"`
+		// Number of recovery codes to generate.
+		RecoveryCodes int `json:"recovery_codes"`
+	}
+	var config configType
+	if err := json.Unmarshal(jsonconf, &config); err != nil {
+		return errors.New("auth_totp: failed to parse config: " + err.Error() + "(" + string(jsonconf) + ")")
+	}
+
+	if config.Issuer == "" {
+		config.Issuer = defaultIssuer
+	}
+	if config.Digits == 0 {
+		config.Digits = defaultDigits
+	} else if config.Digits < 6 || config.Digits > 8 {
+		return errors.New("auth_totp: invalid number of digits")
+	}
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	} else if config.Period < 0 {
+		return errors.New("auth_totp: invalid period")
+	}
+	if config.Skew < 0 {
+		return errors.New("auth_totp: invalid skew")
+	}
+	if config.RecoveryCodes == 0 {
+		config.RecoveryCodes = defaultRecoveryCodes
+	} else if config.RecoveryCodes < 0 || config.RecoveryCodes > maxRecoveryCodes {
+		return errors.New("auth_totp: invalid number of recovery codes")
+	}
+
+	ta.name = name
+	ta.issuer = config.Issuer
+	ta.digits = config.Digits
+	ta.period = int64(config.Period)
+	ta.skew = int64(config.Skew)
+	ta.recoveryCodes = config.RecoveryCodes
+
+	return nil
+}
+
+// IsInitialized returns true if the handler is initialized.
+func (ta *authenticator) IsInitialized() bool {
+	return ta.name != ""
+}
+
+// AddRecord is not supported: TOTP cannot be used to create an account.
+func (authenticator) AddRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	return nil, types.ErrUnsupported
+}
+
+// UpdateRecord enables, confirms or disables TOTP for the user. The secret is one of
+//
+//	"" to begin enrollment: a new key is returned in rec.Params;
+//	"<code>" to confirm enrollment or to replace recovery codes; new recovery codes are returned in rec.Params;
+//	"off:<code>" to disable TOTP; either a TOTP or a recovery code is accepted.
+func (ta *authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	current, err := ta.getRecord(rec.Uid)
+	if err != nil {
+		return nil, err
+	}
+
+	code := string(secret)
+	switch {
+	case code == "":
+		if current != nil && current.enabled {
+			return nil, types.ErrDuplicate
+		}
+		key := make([]byte, keyLength)
+		if _, err = rand.Read(key); err != nil {
+			return nil, types.ErrInternal
+		}
+		if err = ta.saveRecord(rec.Uid, &record{key: key}, current == nil); err != nil {
+			return nil, err
+		}
+		rec.Params = map[string]any{
+			"secret": keyEncoding.EncodeToString(key),
+			"uri":    ta.keyURI(rec.Uid, key),
+		}
+
+	case strings.HasPrefix(code, disablePrefix):
+		if current == nil || !current.enabled {
+			return nil, types.ErrNotFound
+		}
+		if err = ta.Verify(rec.Uid, []byte(strings.TrimPrefix(code, disablePrefix)), remoteAddr); err != nil {
+			return nil, err
+		}
+		if err = ta.DelRecords(rec.Uid); err != nil {
+			return nil, err
+		}
+
+	default:
+		if current == nil {
+			return nil, types.ErrNotFound
+		}
+		if !ta.checkCode(current, code) {
+			return nil, types.ErrFailed
+		}
+		current.enabled = true
+		if err = ta.saveRecord(rec.Uid, current, false); err != nil {
+			return nil, err
+		}
+		codes, err := ta.newRecoveryCodes(rec.Uid)
+		if err != nil {
+			return nil, err
+		}
+		rec.Params = map[string]any{"recovery": codes}
+	}
+
+	return rec, nil
+}
+
+// Authenticate is not supported: TOTP cannot be used as the first authentication factor.
+func (authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Rec, []byte, error) {
+	return nil, nil, types.ErrUnsupported
+}
+
+// IsEnrolled checks if the user has enabled TOTP.
+func (ta *authenticator) IsEnrolled(uid types.Uid) (bool, error) {
+	current, err := ta.getRecord(uid)
+	if err != nil {
+		return false, err
+	}
+	return current != nil && current.enabled, nil
+}
+
+// Verify checks a TOTP code or a recovery code. A recovery code can be used only once.
+func (ta *authenticator) Verify(uid types.Uid, resp []byte, remoteAddr string) error {
+	current, err := ta.getRecord(uid)
+	if err != nil {
+		return err
+	}
+	if current == nil || !current.enabled {
+		return types.ErrFailed
+	}
+
+	code := strings.TrimSpace(string(resp))
+	if len(code) == ta.digits {
+		if !ta.checkCode(current, code) {
+			return types.ErrFailed
+		}
+		// Save the time step of the code to prevent its reuse.
+		return ta.saveRecord(uid, current, false)
+	}
+
+	return ta.useRecoveryCode(uid, code)
+}
+
+// AsTag is not supported, will produce an empty string.
+func (authenticator) AsTag(token string) string {
+	return ""
+}
+
+// IsUnique is not supported, will produce an error.
+func (authenticator) IsUnique(secret []byte, remoteAddr string) (bool, error) {
+	return false, types.ErrUnsupported
+}
+
+// GenSecret is not supported, generates an error.
+func (authenticator) GenSecret(rec *auth.Rec) ([]byte, time.Time, error) {
+	return nil, time.Time{}, types.ErrUnsupported
+}
+
+// DelRecords deletes the TOTP key and recovery codes of the given user.
+func (ta *authenticator) DelRecords(uid types.Uid) error {
+	if err := store.Users.DelAuthRecords(uid, ta.recoveryScheme()); err != nil {
+		return err
+	}
+	return store.Users.DelAuthRecords(uid, ta.name)
+}
+
+// RestrictedTags returns tag namespaces restricted by this authenticator (none for TOTP).
+func (authenticator) RestrictedTags() ([]string, error) {
+	return nil, nil
+}
+
+// GetResetParams is not supported: TOTP keys cannot be reset by email.
+func (authenticator) GetResetParams(uid types.Uid) (map[string]any, error) {
+	return nil, types.ErrUnsupported
+}
+
+const realName = "totp"
+
+// GetRealName returns the hardcoded name of the authenticator.
+func (authenticator) GetRealName() string {
+	return realName
+}
+
+// Recovery codes are stored in a separate auth record.
+func (ta *authenticator) recoveryScheme() string {
+	return ta.name + "_rc"
+}
+
+// getRecord loads user's TOTP settings. Returns nil if the user has no TOTP key.
+func (ta *authenticator) getRecord(uid types.Uid) (*record, error) {
+	_, _, secret, _, err := store.Users.GetAuthRecord(uid, ta.name)
+	if err == types.ErrNotFound || (err == nil && len(secret) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRecord(secret)
+}
+
+func (ta *authenticator) saveRecord(uid types.Uid, rec *record, isNew bool) error {
+	if isNew {
+		return store.Users.AddAuthRecord(uid, auth.LevelAuth, ta.name, uid.String(), rec.marshal(), time.Time{})
+	}
+	return store.Users.UpdateAuthRecord(uid, auth.LevelAuth, ta.name, uid.String(), rec.marshal(), time.Time{})
+}
+
+// checkCode checks the code against the codes valid at the current time. On success rec.lastStep is updated.
+func (ta *authenticator) checkCode(rec *record, code string) bool {
+	if len(code) != ta.digits {
+		return false
+	}
+	now := time.Now().Unix() / ta.period
+	for step := now - ta.skew; step <= now+ta.skew; step++ {
+		if step <= rec.lastStep {
+			// The code was already used.
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(ta.code(rec.key, step)), []byte(code)) == 1 {
+			rec.lastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// code generates the TOTP code for the given time step as defined in RFC 4226 and RFC 6238.
+func (ta *authenticator) code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range ta.digits {
+		mod *= 10
+	}
+	code := strconv.FormatUint(uint64(value%mod), 10)
+	return strings.Repeat("0", ta.digits-len(code)) + code
+}
+
+// keyURI generates the key URI for provisioning authenticator apps, usually shown as a QR code.
+func (ta *authenticator) keyURI(uid types.Uid, key []byte) string {
+	params := url.Values{}
+	params.Set("secret", keyEncoding.EncodeToString(key))
+	params.Set("issuer", ta.issuer)
+	params.Set("digits", strconv.Itoa(ta.digits))
+	params.Set("period", strconv.FormatInt(ta.period, 10))
+	return "otpauth://totp/" + url.PathEscape(ta.issuer+":"+uid.UserId()) + "?" + params.Encode()
+}
+
+// newRecoveryCodes generates a new set of recovery codes replacing the old ones, if any.
+func (ta *authenticator) newRecoveryCodes(uid types.Uid) ([]string, error) {
+	codes := make([]string, ta.recoveryCodes)
+	hashes := make([]string, ta.recoveryCodes)
+	buf := make([]byte, recoveryCodeLength)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, types.ErrInternal
+		}
+		code := strings.ToLower(keyEncoding.EncodeToString(buf))
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	secret := []byte(strings.Join(hashes, ","))
+	err := store.Users.UpdateAuthRecord(uid, auth.LevelAuth, ta.recoveryScheme(), uid.String(), secret, time.Time{})
+	if err == types.ErrNotFound {
+		err = store.Users.AddAuthRecord(uid, auth.LevelAuth, ta.recoveryScheme(), uid.String(), secret, time.Time{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode checks the recovery code and removes it from the list of valid codes.
+func (ta *authenticator) useRecoveryCode(uid types.Uid, code string) error {
+	_, _, secret, _, err := store.Users.GetAuthRecord(uid, ta.recoveryScheme())
+	if err == types.ErrNotFound {
+		return types.ErrFailed
+	}
+	if err != nil {
+		return err
+	}
+
+	hash := hashRecoveryCode(strings.ToLower(strings.ReplaceAll(code, "-", "")))
+	hashes := strings.Split(string(secret), ",")
+	for i, h := range hashes {
+		if h != "" && subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			// The trailing separator keeps the secret non-empty when the last code is used.
+			return store.Users.UpdateAuthRecord(uid, auth.LevelAuth, ta.recoveryScheme(), uid.String(),
+				[]byte(strings.Join(hashes, ",")+","), time.Time{})
+		}
+	}
+	return types.ErrFailed
+}
+
+// Recovery codes are random, a fast hash is sufficient.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(sum[:recoveryHashLength])
+}
+
+func init() {
+	store.RegisterAuthScheme(realName, &authenticator{})
+}
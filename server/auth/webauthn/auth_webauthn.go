@@ -0,0 +1,630 @@
+// Package webauthn implements passwordless authentication by passkeys (W3C Web Authentication).
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	defaultTimeout     = 5 * time.Minute
+	defaultMaxPasskeys = 10
+
+	// Lengths of the challenge parts: random nonce, expiration time, truncated HMAC.
+	nonceLength = 16
+	expLength   = 8
+	macLength   = 16
+	// Length of the hashed credential ID used as the unique ID of the auth record.
+	credHashLength = 12
+
+	// Maximum lengths of auth.scheme and auth.uname columns.
+	maxSchemeLength = 16
+	maxUniqueLength = 32
+
+	// COSE algorithm identifiers (RFC 9053).
+	algES256 = -7
+	algEdDSA = -8
+
+	// Authenticator data flags.
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+
+	// Prefix of the {acc} secret which requests to remove a passkey.
+	deletePrefix = "del:"
+)
+
+// Purpose of a challenge.
+const (
+	purposeRegister byte = 'r'
+	purposeLogin    byte = 'l'
+)
+
+// authenticator is a singleton instance of the authenticator.
+type authenticator struct {
+	name string
+	// Relying party ID, i.e. the domain name of the web application.
+	rpID   string
+	rpName string
+	// Origins allowed to perform the ceremonies.
+	origins map[string]bool
+	// Maximum number of passkeys per user.
+	maxPasskeys int
+	// Lifetime of a challenge.
+	timeout time.Duration
+	// Key for signing challenges. Challenges are answered in the same session, no need to share it.
+	hmacKey []byte
+
+	// Challenges which have been answered, mapped to their expiration time.
+	lock sync.Mutex
+	used map[string]time.Time
+}
+
+// passkey is a stored credential public key in the format "<sign counter>:<COSE algorithm>:<key>".
+type passkey struct {
+	signCount uint32
+	alg       int64
+	// Compressed P-256 point or Ed25519 public key.
+	key []byte
+}
+
+func (p *passkey) marshal() []byte {
+	return []byte(strconv.FormatUint(uint64(p.signCount), 10) + ":" + strconv.FormatInt(p.alg, 10) + ":" +
+		base64.RawURLEncoding.EncodeToString(p.key))
+}
+
+func parsePasskey(secret []byte) (*passkey, error) {
+	parts := strings.SplitN(string(secret), ":", 3)
+	if len(parts) != 3 {
+		return nil, types.ErrInternal
+	}
+	count, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, types.ErrInternal
+	}
+	alg, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, types.ErrInternal
+	}
+	key, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, types.ErrInternal
+	}
+	return &passkey{signCount: uint32(count), alg: alg, key: key}, nil
+}
+
+// verify checks the assertion signature.
+func (p *passkey) verify(data, sig []byte) bool {
+	switch p.alg {
+	case algES256:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), p.key)
+		if x == nil {
+			return false
+		}
+		hash := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, hash[:], sig)
+	case algEdDSA:
+		return len(p.key) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(p.key), data, sig)
+	}
+	return false
+}
+
+// binaryURL is a byte slice encoded as unpadded base64url in JSON, as produced by PublicKeyCredential.toJSON().
+type binaryURL []byte
+
+func (b *binaryURL) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	val, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(str, "="))
+	if err != nil {
+		return err
+	}
+	*b = val
+	return nil
+}
+
+// credential is the response of the client to navigator.credentials.create() or navigator.credentials.get().
+type credential struct {
+	ID       binaryURL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON binaryURL `json:"clientDataJSON"`
+		// Registration.
+		AttestationObject binaryURL `json:"attestationObject"`
+		// Authentication.
+		AuthenticatorData binaryURL `json:"authenticatorData"`
+		Signature         binaryURL `json:"signature"`
+		UserHandle        binaryURL `json:"userHandle"`
+	} `json:"response"`
+}
+
+func parseCredential(secret []byte) (*credential, error) {
+	var cred credential
+	if err := json.Unmarshal(secret, &cred); err != nil || cred.Type != "public-key" || len(cred.ID) == 0 ||
+		len(cred.Response.ClientDataJSON) == 0 {
+		return nil, types.ErrMalformed
+	}
+	return &cred, nil
+}
+
+// Init initializes the authenticator: parses the config and sets internal state.
+func (wa *authenticator) Init(jsonconf json.RawMessage, name string) error {
+	if name == "" {
+		return errors.New("auth_webauthn: authenticator name cannot be blank")
+	}
+
+	if wa.name != "" {
+		return errors.New("auth_webauthn: already initialized as " + wa.name + "; " + name)
+	}
+
+	type configType struct {
+		// Relying party ID: domain name of the web application, e.g. "example.com".
+		RPID string `json:"rp_id"`
+		// Name of the service shown by the authenticator.
+		RPName string `json:"rp_name"`
+		// Origins of the web application, e.g. "https://web.example.com". Default "https://<rp_id>".
+		Origins []string `json:"origins"`
+		// Maximum number of passkeys per user.
+		MaxPasskeys int `json:"max_passkeys"`
+		// Lifetime of a challenge in seconds.
+		Timeout int `json:"timeout"`
+	}
+	var config configType
+	if err := json.Unmarshal(jsonconf, &config); err != nil {
+		return errors.New("auth_webauthn: failed to parse config: " + err.Error() + "(" + string(jsonconf) + ")")
+	}
+
+	if config.RPID == "" {
+		return errors.New("auth_webauthn: rp_id is required")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+	if len(config.Origins) == 0 {
+		config.Origins = []string{"https://" + config.RPID}
+	}
+	if config.MaxPasskeys == 0 {
+		config.MaxPasskeys = defaultMaxPasskeys
+	} else if config.MaxPasskeys < 0 {
+		return errors.New("auth_webauthn: invalid max_passkeys")
+	}
+	if config.Timeout < 0 {
+		return errors.New("auth_webauthn: invalid timeout")
+	}
+
+	wa.name = name
+	// Each passkey is stored in its own auth record: the scheme and the unique ID must fit into the columns.
+	last := wa.slotScheme(config.MaxPasskeys - 1)
+	if len(last) > maxSchemeLength ||
+		len(last)+1+base64.RawURLEncoding.EncodedLen(credHashLength) > maxUniqueLength {
+		wa.name = ""
+		return errors.New("auth_webauthn: authenticator name is too long or max_passkeys is too large")
+	}
+
+	wa.rpID = config.RPID
+	wa.rpName = config.RPName
+	wa.origins = make(map[string]bool, len(config.Origins))
+	for _, origin := range config.Origins {
+		wa.origins[origin] = true
+	}
+	wa.maxPasskeys = config.MaxPasskeys
+	wa.timeout = defaultTimeout
+	if config.Timeout > 0 {
+		wa.timeout = time.Duration(config.Timeout) * time.Second
+	}
+	wa.hmacKey = make([]byte, sha256.Size)
+	if _, err := rand.Read(wa.hmacKey); err != nil {
+		wa.name = ""
+		return err
+	}
+	wa.used = make(map[string]time.Time)
+
+	return nil
+}
+
+// IsInitialized returns true if the handler is initialized.
+func (wa *authenticator) IsInitialized() bool {
+	return wa.name != ""
+}
+
+// AddRecord is not supported: passkeys are added to existing accounts.
+func (*authenticator) AddRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	return nil, types.ErrUnsupported
+}
+
+// UpdateRecord registers or removes a passkey. The secret is one of
+//
+//	"" to begin registration: the challenge and options for navigator.credentials.create() are returned in rec.Params;
+//	"<JSON of PublicKeyCredential>" to complete registration;
+//	"del:<base64url credential ID>" to remove a passkey.
+func (wa *authenticator) UpdateRecord(rec *auth.Rec, secret []byte, remoteAddr string) (*auth.Rec, error) {
+	switch {
+	case len(secret) == 0:
+		userHandle, _ := rec.Uid.MarshalBinary()
+		rec.Params = map[string]any{
+			"challenge": wa.newChallenge(purposeRegister, rec.Uid),
+			"rp":        map[string]string{"id": wa.rpID, "name": wa.rpName},
+			"user": map[string]any{
+				"id":          userHandle,
+				"name":        rec.Uid.UserId(),
+				"displayName": rec.Uid.UserId(),
+			},
+			"pubKeyCredParams": []map[string]any{
+				{"type": "public-key", "alg": algES256},
+				{"type": "public-key", "alg": algEdDSA},
+			},
+			"timeout":     wa.timeout.Milliseconds(),
+			"attestation": "none",
+			"authenticatorSelection": map[string]string{
+				"residentKey":      "required",
+				"userVerification": "required",
+			},
+		}
+
+	case bytes.HasPrefix(secret, []byte(deletePrefix)):
+		credID, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(secret[len(deletePrefix):]), "="))
+		if err != nil {
+			return nil, types.ErrMalformed
+		}
+		slot, _, _, err := wa.findPasskey(rec.Uid, credID)
+		if err != nil {
+			return nil, err
+		}
+		if slot < 0 {
+			return nil, types.ErrNotFound
+		}
+		if err = store.Users.DelAuthRecords(rec.Uid, wa.slotScheme(slot)); err != nil {
+			return nil, err
+		}
+
+	default:
+		if err := wa.register(rec.Uid, secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+// register completes the registration ceremony.
+func (wa *authenticator) register(uid types.Uid, secret []byte) error {
+	cred, err := parseCredential(secret)
+	if err != nil {
+		return err
+	}
+	if err = wa.checkClientData(cred.Response.ClientDataJSON, "webauthn.create", purposeRegister, uid); err != nil {
+		return err
+	}
+
+	// Attestation statement is not verified: "none" attestation is requested.
+	att, _, err := cborDecode(cred.Response.AttestationObject)
+	if err != nil {
+		return types.ErrMalformed
+	}
+	attMap, _ := att.(map[any]any)
+	authData, _ := attMap["authData"].([]byte)
+	flags, _, rest, err := wa.parseAuthData(authData)
+	if err != nil {
+		return err
+	}
+	if flags&flagAttestedData == 0 || len(rest) < 18 {
+		return types.ErrMalformed
+	}
+	// Skip AAGUID.
+	rest = rest[16:]
+	idLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < idLen || !bytes.Equal(rest[:idLen], cred.ID) {
+		return types.ErrMalformed
+	}
+	coseKey, _, err := cborDecode(rest[idLen:])
+	if err != nil {
+		return types.ErrMalformed
+	}
+	pk, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	// Find a free slot, make sure the passkey is not registered yet.
+	unique := credentialHash(cred.ID)
+	free := -1
+	for i := range wa.maxPasskeys {
+		existing, _, _, _, err := store.Users.GetAuthRecord(uid, wa.slotScheme(i))
+		if err == types.ErrNotFound {
+			if free < 0 {
+				free = i
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if existing == unique {
+			return types.ErrDuplicate
+		}
+	}
+	if free < 0 {
+		return types.ErrPolicy
+	}
+
+	return store.Users.AddAuthRecord(uid, auth.LevelAuth, wa.slotScheme(free), unique, pk.marshal(), time.Time{})
+}
+
+// Authenticate performs the authentication ceremony. An empty secret requests a challenge.
+// Otherwise the secret is the JSON of the PublicKeyCredential returned by navigator.credentials.get().
+func (wa *authenticator) Authenticate(secret []byte, remoteAddr string) (*auth.Rec, []byte, error) {
+	if len(secret) == 0 {
+		return nil, wa.newChallenge(purposeLogin, types.ZeroUid), nil
+	}
+
+	cred, err := parseCredential(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = wa.checkClientData(cred.Response.ClientDataJSON, "webauthn.get", purposeLogin, types.ZeroUid); err != nil {
+		return nil, nil, err
+	}
+
+	// Passkeys are discoverable credentials: the user handle is always returned.
+	var uid types.Uid
+	if len(cred.Response.UserHandle) != 8 || uid.UnmarshalBinary(cred.Response.UserHandle) != nil || uid.IsZero() {
+		return nil, nil, types.ErrFailed
+	}
+
+	slot, authLvl, pk, err := wa.findPasskey(uid, cred.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if slot < 0 {
+		return nil, nil, types.ErrFailed
+	}
+
+	_, signCount, _, err := wa.parseAuthData(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientDataHash := sha256.Sum256(cred.Response.ClientDataJSON)
+	if !pk.verify(append(cred.Response.AuthenticatorData, clientDataHash[:]...), cred.Response.Signature) {
+		return nil, nil, types.ErrFailed
+	}
+
+	// Authenticators which don't implement counters always report 0.
+	if signCount != 0 || pk.signCount != 0 {
+		if signCount <= pk.signCount {
+			// The authenticator may have been cloned.
+			return nil, nil, types.ErrFailed
+		}
+		pk.signCount = signCount
+		if err = store.Users.UpdateAuthRecord(uid, authLvl, wa.slotScheme(slot), credentialHash(cred.ID),
+			pk.marshal(), time.Time{}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &auth.Rec{
+		Uid:       uid,
+		AuthLevel: authLvl,
+		Features:  auth.FeaturePasskey,
+		State:     types.StateUndefined,
+	}, nil, nil
+}
+
+// AsTag is not supported, will produce an empty string.
+func (*authenticator) AsTag(token string) string {
+	return ""
+}
+
+// IsUnique is not supported, will produce an error.
+func (*authenticator) IsUnique(secret []byte, remoteAddr string) (bool, error) {
+	return false, types.ErrUnsupported
+}
+
+// GenSecret is not supported, generates an error.
+func (*authenticator) GenSecret(rec *auth.Rec) ([]byte, time.Time, error) {
+	return nil, time.Time{}, types.ErrUnsupported
+}
+
+// DelRecords deletes all passkeys of the given user.
+func (wa *authenticator) DelRecords(uid types.Uid) error {
+	for i := range wa.maxPasskeys {
+		if err := store.Users.DelAuthRecords(uid, wa.slotScheme(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestrictedTags returns tag namespaces restricted by this authenticator (none for passkeys).
+func (*authenticator) RestrictedTags() ([]string, error) {
+	return nil, nil
+}
+
+// GetResetParams is not supported: passkeys cannot be reset.
+func (*authenticator) GetResetParams(uid types.Uid) (map[string]any, error) {
+	return nil, types.ErrUnsupported
+}
+
+const realName = "webauthn"
+
+// GetRealName returns the hardcoded name of the authenticator.
+func (*authenticator) GetRealName() string {
+	return realName
+}
+
+// Only one auth record per scheme is allowed for a user, each passkey uses its own scheme.
+func (wa *authenticator) slotScheme(slot int) string {
+	return wa.name + "_" + strconv.Itoa(slot)
+}
+
+// findPasskey finds the slot of the user's passkey. The slot is -1 if the passkey is not found.
+func (wa *authenticator) findPasskey(uid types.Uid, credID []byte) (int, auth.Level, *passkey, error) {
+	unique := credentialHash(credID)
+	for i := range wa.maxPasskeys {
+		existing, authLvl, secret, _, err := store.Users.GetAuthRecord(uid, wa.slotScheme(i))
+		if err == types.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return -1, auth.LevelNone, nil, err
+		}
+		if existing == unique {
+			pk, err := parsePasskey(secret)
+			if err != nil {
+				return -1, auth.LevelNone, nil, err
+			}
+			return i, authLvl, pk, nil
+		}
+	}
+	return -1, auth.LevelNone, nil, nil
+}
+
+// Credential IDs may be up to 1023 bytes long, the hash is used as the unique ID of the auth record.
+func credentialHash(credID []byte) string {
+	sum := sha256.Sum256(credID)
+	return base64.RawURLEncoding.EncodeToString(sum[:credHashLength])
+}
+
+// newChallenge creates a challenge signed by the server: "<nonce><expiration time><HMAC>".
+func (wa *authenticator) newChallenge(purpose byte, uid types.Uid) []byte {
+	challenge := make([]byte, nonceLength, nonceLength+expLength+macLength)
+	rand.Read(challenge)
+	challenge = binary.BigEndian.AppendUint64(challenge, uint64(time.Now().Add(wa.timeout).Unix()))
+	return append(challenge, wa.challengeMAC(challenge, purpose, uid)...)
+}
+
+func (wa *authenticator) challengeMAC(data []byte, purpose byte, uid types.Uid) []byte {
+	mac := hmac.New(sha256.New, wa.hmacKey)
+	mac.Write([]byte{purpose})
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(uid)))
+	mac.Write(data)
+	return mac.Sum(nil)[:macLength]
+}
+
+// checkChallenge verifies the challenge and makes sure it's answered only once.
+func (wa *authenticator) checkChallenge(challenge []byte, purpose byte, uid types.Uid) error {
+	if len(challenge) != nonceLength+expLength+macLength {
+		return types.ErrFailed
+	}
+	data := challenge[:nonceLength+expLength]
+	if !hmac.Equal(challenge[nonceLength+expLength:], wa.challengeMAC(data, purpose, uid)) {
+		return types.ErrFailed
+	}
+	now := time.Now()
+	expires := time.Unix(int64(binary.BigEndian.Uint64(data[nonceLength:])), 0)
+	if expires.Before(now) {
+		return types.ErrExpired
+	}
+
+	wa.lock.Lock()
+	defer wa.lock.Unlock()
+
+	key := string(challenge)
+	if _, used := wa.used[key]; used {
+		return types.ErrFailed
+	}
+	for k, exp := range wa.used {
+		if exp.Before(now) {
+			delete(wa.used, k)
+		}
+	}
+	wa.used[key] = expires
+	return nil
+}
+
+// checkClientData verifies the client data of a ceremony.
+func (wa *authenticator) checkClientData(raw []byte, ceremony string, purpose byte, uid types.Uid) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return types.ErrMalformed
+	}
+	if clientData.Type != ceremony || !wa.origins[clientData.Origin] || clientData.CrossOrigin {
+		return types.ErrFailed
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(clientData.Challenge, "="))
+	if err != nil {
+		return types.ErrMalformed
+	}
+	return wa.checkChallenge(challenge, purpose, uid)
+}
+
+// parseAuthData checks the authenticator data. It returns the flags, the signature counter and
+// the rest of the data.
+func (wa *authenticator) parseAuthData(data []byte) (byte, uint32, []byte, error) {
+	if len(data) < sha256.Size+5 {
+		return 0, 0, nil, types.ErrMalformed
+	}
+	rpIDHash := sha256.Sum256([]byte(wa.rpID))
+	if !bytes.Equal(data[:sha256.Size], rpIDHash[:]) {
+		return 0, 0, nil, types.ErrFailed
+	}
+	flags := data[sha256.Size]
+	// Passkeys replace passwords: the user must be verified by the authenticator, not just present.
+	if flags&(flagUserPresent|flagUserVerified) != flagUserPresent|flagUserVerified {
+		return 0, 0, nil, types.ErrFailed
+	}
+	return flags, binary.BigEndian.Uint32(data[sha256.Size+1:]), data[sha256.Size+5:], nil
+}
+
+// parseCOSEKey converts a COSE_Key (RFC 9052) to a passkey. ES256 and EdDSA (Ed25519) keys are supported.
+func parseCOSEKey(val any) (*passkey, error) {
+	key, ok := val.(map[any]any)
+	if !ok {
+		return nil, types.ErrMalformed
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	crv, _ := key[int64(-1)].(int64)
+	x, _ := key[int64(-2)].([]byte)
+
+	switch {
+	case alg == algES256 && kty == 2 && crv == 1:
+		y, _ := key[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, types.ErrMalformed
+		}
+		point := append(append([]byte{4}, x...), y...)
+		// Make sure the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, types.ErrMalformed
+		}
+		return &passkey{alg: alg, key: elliptic.MarshalCompressed(elliptic.P256(),
+			new(big.Int).SetBytes(x), new(big.Int).SetBytes(y))}, nil
+	case alg == algEdDSA && kty == 1 && crv == 6:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, types.ErrMalformed
+		}
+		return &passkey{alg: alg, key: x}, nil
+	}
+	return nil, types.ErrUnsupported
+}
+
+func init() {
+	store.RegisterAuthScheme(realName, &authenticator{})
+}
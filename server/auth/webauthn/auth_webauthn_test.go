@@ -0,0 +1,327 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// cborPairs is a CBOR map with keys in the given order.
+type cborPairs [][2]any
+
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+}
+
+func cborEncode(v any) []byte {
+	switch val := v.(type) {
+	case int:
+		if val < 0 {
+			return cborHead(1, -1-val)
+		}
+		return cborHead(0, val)
+	case []byte:
+		return append(cborHead(2, len(val)), val...)
+	case string:
+		return append(cborHead(3, len(val)), val...)
+	case cborPairs:
+		out := cborHead(5, len(val))
+		for _, kv := range val {
+			out = append(out, cborEncode(kv[0])...)
+			out = append(out, cborEncode(kv[1])...)
+		}
+		return out
+	}
+	panic("unsupported type")
+}
+
+// softAuthenticator is a software stand-in for a platform authenticator.
+type softAuthenticator struct {
+	credID    []byte
+	ecKey     *ecdsa.PrivateKey
+	edKey     ed25519.PrivateKey
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T, ed bool) *softAuthenticator {
+	sa := &softAuthenticator{credID: make([]byte, 20)}
+	rand.Read(sa.credID)
+	var err error
+	if ed {
+		_, sa.edKey, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		sa.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sa
+}
+
+func (sa *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, sa.signCount)
+	return append(data, attested...)
+}
+
+func clientData(typ string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	return data
+}
+
+func credentialJSON(credID []byte, response map[string][]byte) []byte {
+	resp := map[string]string{}
+	for k, v := range response {
+		resp[k] = base64.RawURLEncoding.EncodeToString(v)
+	}
+	data, _ := json.Marshal(map[string]any{
+		"id":       base64.RawURLEncoding.EncodeToString(credID),
+		"rawId":    base64.RawURLEncoding.EncodeToString(credID),
+		"type":     "public-key",
+		"response": resp,
+	})
+	return data
+}
+
+// create emulates navigator.credentials.create().
+func (sa *softAuthenticator) create(challenge []byte, origin string) []byte {
+	var coseKey cborPairs
+	if sa.edKey != nil {
+		coseKey = cborPairs{{1, 1}, {3, algEdDSA}, {-1, 6}, {-2, []byte(sa.edKey.Public().(ed25519.PublicKey))}}
+	} else {
+		coseKey = cborPairs{{1, 2}, {3, algES256}, {-1, 1},
+			{-2, sa.ecKey.X.FillBytes(make([]byte, 32))}, {-3, sa.ecKey.Y.FillBytes(make([]byte, 32))}}
+	}
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(sa.credID)))
+	attested = append(append(attested, sa.credID...), cborEncode(coseKey)...)
+	attObj := cborEncode(cborPairs{
+		{"fmt", "none"},
+		{"attStmt", cborPairs{}},
+		{"authData", sa.authData(flagUserPresent|flagUserVerified|flagAttestedData, attested)},
+	})
+	return credentialJSON(sa.credID, map[string][]byte{
+		"clientDataJSON":    clientData("webauthn.create", challenge, origin),
+		"attestationObject": attObj,
+	})
+}
+
+// get emulates navigator.credentials.get().
+func (sa *softAuthenticator) get(challenge []byte, origin string, uid types.Uid) []byte {
+	authData := sa.authData(flagUserPresent|flagUserVerified, nil)
+	cd := clientData("webauthn.get", challenge, origin)
+	hash := sha256.Sum256(cd)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+	var sig []byte
+	if sa.edKey != nil {
+		sig = ed25519.Sign(sa.edKey, signed)
+	} else {
+		digest := sha256.Sum256(signed)
+		sig, _ = ecdsa.SignASN1(rand.Reader, sa.ecKey, digest[:])
+	}
+	userHandle, _ := uid.MarshalBinary()
+	return credentialJSON(sa.credID, map[string][]byte{
+		"clientDataJSON":    cd,
+		"authenticatorData": authData,
+		"signature":         sig,
+		"userHandle":        userHandle,
+	})
+}
+
+func newTestAuthenticator(t *testing.T) *authenticator {
+	config, _ := json.Marshal(map[string]any{"rp_id": testRPID, "max_passkeys": 2})
+	wa := &authenticator{}
+	if err := wa.Init(config, "webauthn"); err != nil {
+		t.Fatal(err)
+	}
+	return wa
+}
+
+// fakeAuthStore keeps auth records of the given user in memory.
+func fakeAuthStore(t *testing.T, owner types.Uid) map[string][2]string {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	store.Users = uu
+	t.Cleanup(func() {
+		store.Users = nil
+		ctrl.Finish()
+	})
+
+	// scheme -> unique, secret.
+	records := map[string][2]string{}
+	uu.EXPECT().GetAuthRecord(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(uid types.Uid, scheme string) (string, auth.Level, []byte, time.Time, error) {
+			if rec, ok := records[scheme]; ok && uid == owner {
+				return rec[0], auth.LevelAuth, []byte(rec[1]), time.Time{}, nil
+			}
+			return "", auth.LevelNone, nil, time.Time{}, types.ErrNotFound
+		})
+	save := func(uid types.Uid, lvl auth.Level, scheme, unique string, secret []byte, exp time.Time) error {
+		records[scheme] = [2]string{unique, string(secret)}
+		return nil
+	}
+	uu.EXPECT().AddAuthRecord(gomock.Any(), auth.LevelAuth, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().DoAndReturn(save)
+	uu.EXPECT().UpdateAuthRecord(gomock.Any(), auth.LevelAuth, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		AnyTimes().DoAndReturn(save)
+	uu.EXPECT().DelAuthRecords(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(
+		func(uid types.Uid, scheme string) error {
+			delete(records, scheme)
+			return nil
+		})
+	return records
+}
+
+func register(t *testing.T, wa *authenticator, uid types.Uid, sa *softAuthenticator) error {
+	rec, err := wa.UpdateRecord(&auth.Rec{Uid: uid}, nil, "")
+	if err != nil {
+		t.Fatalf("Registration challenge failed: %v", err)
+	}
+	_, err = wa.UpdateRecord(&auth.Rec{Uid: uid}, sa.create(rec.Params["challenge"].([]byte), testOrigin), "")
+	return err
+}
+
+func login(t *testing.T, wa *authenticator, uid types.Uid, sa *softAuthenticator) (*auth.Rec, error) {
+	rec, challenge, err := wa.Authenticate(nil, "")
+	if err != nil || rec != nil || len(challenge) == 0 {
+		t.Fatalf("Login challenge failed: %v", err)
+	}
+	rec, _, err = wa.Authenticate(sa.get(challenge, testOrigin, uid), "")
+	return rec, err
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	wa := newTestAuthenticator(t)
+	uid := types.Uid(1234)
+	records := fakeAuthStore(t, uid)
+
+	ec := newSoftAuthenticator(t, false)
+	ed := newSoftAuthenticator(t, true)
+	for _, sa := range []*softAuthenticator{ec, ed} {
+		if err := register(t, wa, uid, sa); err != nil {
+			t.Fatalf("Registration failed: %v", err)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 passkeys, got %d", len(records))
+	}
+	// The same passkey cannot be registered twice, the number of passkeys is limited.
+	if err := register(t, wa, uid, ec); err != types.ErrDuplicate {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+	if err := register(t, wa, uid, newSoftAuthenticator(t, false)); err != types.ErrPolicy {
+		t.Errorf("Expected ErrPolicy, got %v", err)
+	}
+
+	for _, sa := range []*softAuthenticator{ec, ed} {
+		rec, err := login(t, wa, uid, sa)
+		if err != nil {
+			t.Fatalf("Login failed: %v", err)
+		}
+		if rec.Uid != uid || rec.AuthLevel != auth.LevelAuth || rec.Features != auth.FeaturePasskey {
+			t.Errorf("Unexpected auth record %+v", rec)
+		}
+	}
+
+	// Remove the passkey.
+	del := []byte(deletePrefix + base64.RawURLEncoding.EncodeToString(ec.credID))
+	if _, err := wa.UpdateRecord(&auth.Rec{Uid: uid}, del, ""); err != nil {
+		t.Fatalf("Removal failed: %v", err)
+	}
+	if _, err := login(t, wa, uid, ec); err != types.ErrFailed {
+		t.Errorf("Removed passkey: expected ErrFailed, got %v", err)
+	}
+}
+
+func TestLoginSignCount(t *testing.T) {
+	wa := newTestAuthenticator(t)
+	uid := types.Uid(1234)
+	fakeAuthStore(t, uid)
+	sa := newSoftAuthenticator(t, false)
+	sa.signCount = 5
+	if err := register(t, wa, uid, sa); err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+
+	sa.signCount = 6
+	if _, err := login(t, wa, uid, sa); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	// Counter did not increase: the authenticator may have been cloned.
+	if _, err := login(t, wa, uid, sa); err != types.ErrFailed {
+		t.Errorf("Expected ErrFailed, got %v", err)
+	}
+}
+
+func TestLoginInvalidResponse(t *testing.T) {
+	wa := newTestAuthenticator(t)
+	uid := types.Uid(1234)
+	fakeAuthStore(t, uid)
+	sa := newSoftAuthenticator(t, false)
+	if err := register(t, wa, uid, sa); err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+
+	_, challenge, _ := wa.Authenticate(nil, "")
+	if _, _, err := wa.Authenticate(sa.get(challenge, "https://evil.com", uid), ""); err != types.ErrFailed {
+		t.Errorf("Wrong origin: expected ErrFailed, got %v", err)
+	}
+
+	_, challenge, _ = wa.Authenticate(nil, "")
+	if _, _, err := wa.Authenticate(sa.get(challenge, testOrigin, types.Uid(5678)), ""); err != types.ErrFailed {
+		t.Errorf("Wrong user: expected ErrFailed, got %v", err)
+	}
+
+	// Challenges cannot be reused.
+	_, challenge, _ = wa.Authenticate(nil, "")
+	resp := sa.get(challenge, testOrigin, uid)
+	if _, _, err := wa.Authenticate(resp, ""); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if _, _, err := wa.Authenticate(resp, ""); err != types.ErrFailed {
+		t.Errorf("Replay: expected ErrFailed, got %v", err)
+	}
+
+	// Challenges issued by someone else are rejected.
+	forged := make([]byte, len(challenge))
+	rand.Read(forged)
+	if _, _, err := wa.Authenticate(sa.get(forged, testOrigin, uid), ""); err != types.ErrFailed {
+		t.Errorf("Forged challenge: expected ErrFailed, got %v", err)
+	}
+
+	// Registration challenge cannot be used for login.
+	rec, _ := wa.UpdateRecord(&auth.Rec{Uid: uid}, nil, "")
+	if _, _, err := wa.Authenticate(sa.get(rec.Params["challenge"].([]byte), testOrigin, uid), ""); err != types.ErrFailed {
+		t.Errorf("Registration challenge: expected ErrFailed, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Minimal CBOR (RFC 8949) decoder sufficient for parsing attestation objects and COSE keys.
+// Indefinite-length items, tags and floating point numbers are not supported.
+
+// Nesting limit of decoded items.
+const maxCborDepth = 8
+
+var errCbor = errors.New("cbor: malformed or unsupported data")
+
+// cborDecode decodes one item from the start of data. It returns the item and the remaining bytes.
+// Integers are decoded as int64, byte strings as []byte, text strings as string, arrays as []any,
+// maps as map[any]any, simple values as bool or nil.
+func cborDecode(data []byte) (any, []byte, error) {
+	return cborDecodeItem(data, 0)
+}
+
+func cborDecodeItem(data []byte, depth int) (any, []byte, error) {
+	if len(data) == 0 || depth > maxCborDepth {
+		return nil, nil, errCbor
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, errCbor
+		}
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(data) >= 1:
+		arg, data = uint64(data[0]), data[1:]
+	case info == 25 && len(data) >= 2:
+		arg, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26 && len(data) >= 4:
+		arg, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27 && len(data) >= 8:
+		arg, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, errCbor
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errCbor
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errCbor
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCbor
+		}
+		val := data[:arg]
+		if major == 3 {
+			return string(val), data[arg:], nil
+		}
+		return append([]byte(nil), val...), data[arg:], nil
+	case 4:
+		// Each item takes at least one byte.
+		if arg > uint64(len(data)) {
+			return nil, nil, errCbor
+		}
+		arr := make([]any, 0, arg)
+		for range arg {
+			var item any
+			var err error
+			if item, data, err = cborDecodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, data, nil
+	case 5:
+		if arg > uint64(len(data))/2 {
+			return nil, nil, errCbor
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			var key, val any
+			var err error
+			if key, data, err = cborDecodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				// Only integer and string keys are used by WebAuthn.
+				return nil, nil, errCbor
+			}
+			if val, data, err = cborDecodeItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = val
+		}
+		return m, data, nil
+	default:
+		return nil, nil, errCbor
+	}
+}
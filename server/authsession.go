@@ -0,0 +1,163 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Logins on devices: listing and revoking by the user.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// How often expired logins are deleted.
+const authSessionGcPeriod = time.Hour
+
+// replyGetSessions responds to {get what="sessions"} on 'me' with the list of user's logins.
+func replyGetSessions(s *Session, msg *ClientComMessage) {
+	now := types.TimeNow()
+
+	if msg.Original != "me" {
+		s.queueOut(ErrMalformedReply(msg, now))
+		return
+	}
+
+	uid := types.ParseUserId(msg.AsUser)
+	sessions, err := store.AuthSessions.GetAll(uid)
+	if err != nil {
+		logs.Warn.Println("replyGetSessions: failed to get sessions", err, s.sid)
+		s.queueOut(ErrUnknownReply(msg, now))
+		return
+	}
+
+	if len(sessions) == 0 {
+		s.queueOut(NoContentParamsReply(msg, now, map[string]string{"what": "sessions"}))
+		return
+	}
+
+	_, _, current := s.authInfo()
+	result := make([]MsgAuthSession, 0, len(sessions))
+	for i := range sessions {
+		sess := &sessions[i]
+		result = append(result, MsgAuthSession{
+			Id:         sess.Id,
+			Platform:   sess.Platform,
+			UserAgent:  sess.UserAgent,
+			RemoteAddr: sess.RemoteAddr,
+			Created:    sess.CreatedAt,
+			LastSeen:   sess.UpdatedAt,
+			Expires:    sess.Expires,
+			Current:    sess.Id == current.String(),
+		})
+	}
+	s.queueOut(&ServerComMessage{
+		Meta: &MsgServerMeta{
+			Id:        msg.Id,
+			Topic:     msg.Original,
+			Sessions:  result,
+			Timestamp: &now,
+		},
+	})
+}
+
+// replyDelSession responds to {del what="session"}: revokes one login of the user or, if the ID is
+// not given, all logins except the current one. Live sessions of revoked logins are terminated.
+func replyDelSession(s *Session, msg *ClientComMessage) {
+	now := types.TimeNow()
+
+	uid := types.ParseUserId(msg.AsUser)
+	sessions, err := store.AuthSessions.GetAll(uid)
+	if err != nil {
+		logs.Warn.Println("replyDelSession: failed to get sessions", err, s.sid)
+		s.queueOut(ErrUnknown(msg.Id, "", now))
+		return
+	}
+
+	_, _, current := s.authInfo()
+	var revoked []string
+	// Devices of revoked logins and devices still in use.
+	var devices, keep []string
+	for i := range sessions {
+		sess := &sessions[i]
+		var revoke bool
+		if msg.Del.Session != "" {
+			revoke = sess.Id == msg.Del.Session
+		} else {
+			revoke = sess.Id != current.String()
+		}
+		if revoke {
+			revoked = append(revoked, sess.Id)
+			devices = append(devices, sess.DeviceId)
+		} else {
+			keep = append(keep, sess.DeviceId)
+		}
+	}
+
+	if msg.Del.Session != "" && len(revoked) == 0 {
+		s.queueOut(ErrNotFound(msg.Id, "", now))
+		return
+	}
+
+	if err = store.AuthSessions.Delete(uid, revoked...); err != nil {
+		logs.Warn.Println("replyDelSession: failed to delete sessions", err, s.sid)
+		s.queueOut(decodeStoreError(err, msg.Id, now, nil))
+		return
+	}
+
+	// Stop sending push notifications to the devices which are no longer logged in.
+	for _, deviceId := range devices {
+		if deviceId == "" || slices.Contains(keep, deviceId) {
+			continue
+		}
+		if err = store.Devices.Delete(uid, deviceId); err != nil && err != types.ErrNotFound {
+			logs.Warn.Println("replyDelSession: failed to delete device", err, s.sid)
+		}
+	}
+
+	s.queueOut(NoErr(msg.Id, "", now))
+
+	if len(revoked) > 0 {
+		evictAuthSessions(uid, revoked)
+	}
+}
+
+// evictAuthSessions terminates live sessions authenticated by the revoked logins on all cluster nodes.
+func evictAuthSessions(uid types.Uid, ids []string) {
+	globals.sessionStore.EvictAuthSessions(uid, ids)
+
+	if globals.cluster != nil {
+		// The user could be connected to any node.
+		globals.cluster.routeUserReq(&UserCacheReq{UserId: uid, AuthSessions: ids})
+	}
+}
+
+// authSessionRunGarbageCollection runs every 'period' and deletes expired logins of all users.
+// Returns channel which can be used to stop the process.
+func authSessionRunGarbageCollection(period time.Duration) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the gc must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		// Add some randomness to the tick period to desynchronize runs on cluster nodes:
+		// 0.75 * period + rand(0, 0.5) * period.
+		period = (period >> 1) + (period >> 2) + time.Duration(rand.Intn(int(period>>1)))
+		gcTicker := time.Tick(period)
+		for {
+			select {
+			case <-gcTicker:
+				if err := store.AuthSessions.Expire(time.Now()); err != nil {
+					logs.Warn.Println("login gc:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
@@ -101,7 +101,7 @@ func callPartySession(sess *Session) *Session {
 			// Local parameters specific to this session.
 			sid:         sess.sid,
 			userAgent:   sess.userAgent,
-			remoteAddr:  sess.remoteAddr,
+			remoteAddr:  sess.getRemoteAddr(),
 			lang:        sess.lang,
 			countryCode: sess.countryCode,
 			proxyReq:    ProxyReqCall,
@@ -296,7 +296,7 @@ func (t *Topic) handleCallEvent(msg *ClientComMessage) {
 				origHead = msgCopy.Pub.Head
 			} // else fetch the original message from store and use its head.
 			head := t.currentCall.messageHead(origHead, replaceWith, 0)
-			if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil,
+			if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil, nil, 0,
 				head, t.currentCall.content); err != nil {
 				return
 			}
@@ -415,7 +415,7 @@ func (t *Topic) maybeEndCallInProgress(from string, msg *ClientComMessage, callD
 		origHead = msgCopy.Pub.Head
 	} // else fetch the original message from store and use its head.
 	head := t.currentCall.messageHead(origHead, replaceWith, int(callDuration))
-	if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil, head, t.currentCall.content); err != nil {
+	if err := t.saveAndBroadcastMessage(&msgCopy, originatorUid, false, nil, nil, 0, head, t.currentCall.content); err != nil {
 		logs.Err.Printf("topic[%s]: failed to write finalizing message for call seq id %d - '%s'", t.name, t.currentCall.seq, err)
 	}
 
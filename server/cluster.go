@@ -498,8 +498,9 @@ func (c *Cluster) TopicMaster(msg *ClusterReq, rejected *bool) error {
 		return nil
 	}
 
-	// Create a new multiplexing session if needed.
-	if msess == nil {
+	// Create a new multiplexing session if needed. Scheduled messages are delivered by the server
+	// without a session: there is nothing to multiplex.
+	if msess == nil && (msg.Sess != nil || msg.CliMsg == nil || msg.CliMsg.Scheduled == "") {
 		// If the session is not found, create it.
 		var count int
 		msess, count = globals.sessionStore.NewSession(node, msid)
@@ -651,6 +652,12 @@ func (c *Cluster) Route(msg *ClusterRoute, rejected *bool) error {
 
 // UserCacheUpdate endpoint receives updates to user's cached values as well as sends push notifications.
 func (c *Cluster) UserCacheUpdate(msg *UserCacheReq, rejected *bool) error {
+	if len(msg.AuthSessions) > 0 {
+		// User's logins are revoked.
+		globals.sessionStore.EvictAuthSessions(msg.UserId, msg.AuthSessions)
+		return nil
+	}
+
 	if msg.Gone {
 		// User is deleted. Evict all user's sessions.
 		globals.sessionStore.EvictUser(msg.UserId, "")
@@ -665,6 +672,12 @@ func (c *Cluster) UserCacheUpdate(msg *UserCacheReq, rejected *bool) error {
 	return nil
 }
 
+// APIKeyUpdate endpoint receives notifications that the API key was updated or revoked.
+func (c *Cluster) APIKeyUpdate(keyId types.Uid, unused *bool) error {
+	globals.apiKeyCache.invalidate(keyId)
+	return nil
+}
+
 // Ping is a gRPC endpoint which receives ping requests from peer nodes.Used to detect node restarts.
 func (c *Cluster) Ping(ping *ClusterPing, unused *bool) error {
 	node := c.nodes[ping.Node]
@@ -728,7 +741,13 @@ func (c *Cluster) routeUserReq(req *UserCacheReq) error {
 		}
 	} else if req.Gone {
 		// Message that the user is deleted is sent to all nodes.
-		r := &UserCacheReq{Node: c.thisNodeName, UserIdList: req.UserIdList, Gone: true}
+		r := &UserCacheReq{Node: c.thisNodeName, UserId: req.UserId, UserIdList: req.UserIdList, Gone: true}
+		for _, n := range c.nodes {
+			reqByNode[n.name] = r
+		}
+	} else if len(req.AuthSessions) > 0 {
+		// Sessions of the revoked logins could be connected to any node.
+		r := &UserCacheReq{Node: c.thisNodeName, UserId: req.UserId, AuthSessions: req.AuthSessions}
 		for _, n := range c.nodes {
 			reqByNode[n.name] = r
 		}
@@ -763,6 +782,17 @@ func (c *Cluster) routeUserReq(req *UserCacheReq) error {
 	return err
 }
 
+// apiKeyChanged tells all other nodes to drop the cached copy of the API key.
+func (c *Cluster) apiKeyChanged(keyId types.Uid) {
+	for _, n := range c.nodes {
+		var unused bool
+		if err := n.call("Cluster.APIKeyUpdate", keyId, &unused); err != nil {
+			// The node re-reads the key when the cache entry expires.
+			logs.Warn.Println("cluster: failed to notify of apikey change", n.name, keyId, err)
+		}
+	}
+}
+
 // Given topic name, find appropriate cluster node to route message to.
 func (c *Cluster) nodeForTopic(topic string) *ClusterNode {
 	key := c.ring.Get(topic)
@@ -842,7 +872,7 @@ func (c *Cluster) makeClusterReq(reqType ProxyReqType, msg *ClientComMessage, to
 		req.Sess = &ClusterSess{
 			Uid:         uid,
 			AuthLvl:     sess.authLvl,
-			RemoteAddr:  sess.remoteAddr,
+			RemoteAddr:  sess.getRemoteAddr(),
 			UserAgent:   sess.userAgent,
 			Ver:         sess.ver,
 			Lang:        sess.lang,
@@ -34,6 +34,10 @@ type MsgGetOpts struct {
 	Limit int `json:"limit,omitempty"`
 	// Fetch messages with IDs in these ranges.
 	IdRanges []MsgRange `json:"ranges,omitempty"`
+	// Fetch only messages with the text content containing this string (case-insensitive).
+	Search string `json:"search,omitempty"`
+	// Fetch only replies to the message with this SeqId.
+	Thread int `json:"thread,omitempty"`
 }
 
 // MsgGetQuery is a topic metadata or data query.
@@ -48,6 +52,10 @@ type MsgGetQuery struct {
 	Data *MsgGetOpts `json:"data,omitempty"`
 	// Parameters of "del" request: Since, Before, Limit.
 	Del *MsgGetOpts `json:"del,omitempty"`
+	// Parameters of "edits" request: Since, Before, Limit.
+	Edits *MsgGetOpts `json:"edits,omitempty"`
+	// Parameters of "scheduled" request: Limit.
+	Scheduled *MsgGetOpts `json:"scheduled,omitempty"`
 }
 
 // MsgSetSub is a payload in set.sub request to update current subscription or invite another user, {sub.what} == "sub".
@@ -69,6 +77,36 @@ type MsgSetDesc struct {
 	Trusted any `json:"trusted,omitempty"`
 	// Per-subscription private data.
 	Private any `json:"private,omitempty"`
+	// Lifetime of messages in seconds, 0 to keep messages forever.
+	MsgTTL *int `json:"msgttl,omitempty"`
+	// Slow mode settings of a group topic.
+	SlowMode *MsgSlowMode `json:"slow,omitempty"`
+}
+
+// MsgSlowMode limits the rate of messages posted to a group topic by each user.
+type MsgSlowMode struct {
+	// Minimum interval in seconds between messages from one user, 0 to disable slow mode.
+	Interval int `json:"interval,omitempty"`
+	// Number of messages which can be posted at once before the interval applies.
+	Burst int `json:"burst,omitempty"`
+}
+
+// MsgSetMsg is a payload in set.msg request to edit a previously published message.
+type MsgSetMsg struct {
+	// ID of the message to edit.
+	SeqId int `json:"seq"`
+	// New message headers.
+	Head map[string]any `json:"head,omitempty"`
+	// New message content.
+	Content any `json:"content"`
+}
+
+// MsgSetPin is a request to pin or unpin a message in a topic.
+type MsgSetPin struct {
+	// ID of the message to pin or unpin.
+	SeqId int `json:"seq"`
+	// Unpin the message instead of pinning it.
+	Unpin bool `json:"unpin,omitempty"`
 }
 
 // MsgCredClient is an account credential such as email or phone number.
@@ -95,6 +133,10 @@ type MsgSetQuery struct {
 	Cred *MsgCredClient `json:"cred,omitempty"`
 	// Update auxiliary data
 	Aux map[string]any
+	// Edit of a previously published message.
+	Msg *MsgSetMsg `json:"msg,omitempty"`
+	// Pin or unpin a message.
+	Pin *MsgSetPin `json:"pin,omitempty"`
 }
 
 // MsgRange is either an individual ID (HiId=0) or a randge of IDs, low end inclusive (closed),
@@ -195,6 +237,11 @@ const (
 	constMsgMetaDel
 	constMsgMetaCred
 	constMsgMetaAux
+	constMsgMetaMsg
+	constMsgMetaEdits
+	constMsgMetaPin
+	constMsgMetaScheduled
+	constMsgMetaSessions
 )
 
 const (
@@ -203,6 +250,8 @@ const (
 	constMsgDelSub
 	constMsgDelUser
 	constMsgDelCred
+	constMsgDelScheduled
+	constMsgDelSession
 )
 
 func parseMsgClientMeta(params string) int {
@@ -224,6 +273,12 @@ func parseMsgClientMeta(params string) int {
 			bits |= constMsgMetaCred
 		case "aux":
 			bits |= constMsgMetaAux
+		case "edits":
+			bits |= constMsgMetaEdits
+		case "scheduled":
+			bits |= constMsgMetaScheduled
+		case "sessions":
+			bits |= constMsgMetaSessions
 		default:
 			// ignore unknown
 		}
@@ -243,6 +298,10 @@ func parseMsgClientDel(params string) int {
 		return constMsgDelUser
 	case "cred":
 		return constMsgDelCred
+	case "scheduled":
+		return constMsgDelScheduled
+	case "session":
+		return constMsgDelSession
 	default:
 		// ignore
 	}
@@ -269,6 +328,20 @@ type MsgClientPub struct {
 	NoEcho  bool           `json:"noecho,omitempty"`
 	Head    map[string]any `json:"head,omitempty"`
 	Content any            `json:"content"`
+	// SeqId of the message this message is a reply to.
+	Thread int `json:"thread,omitempty"`
+	// Deliver the message at this time instead of immediately.
+	DeliverAt *time.Time `json:"deliver,omitempty"`
+	// Forward an existing message instead of sending new content.
+	Forward *MsgForward `json:"fwd,omitempty"`
+}
+
+// MsgForward identifies a message to forward.
+type MsgForward struct {
+	// Name of the topic which contains the message as seen by the sender, e.g. usrXXX for p2p.
+	Topic string `json:"topic"`
+	// SeqId of the message.
+	SeqId int `json:"seq"`
 }
 
 // MsgClientGet is a query of topic state {get}.
@@ -295,6 +368,8 @@ type MsgClientDel struct {
 	// * "sub" to delete a subscription to topic.
 	// * "user" to delete or disable user.
 	// * "cred" to delete credential (email or phone)
+	// * "scheduled" to cancel a scheduled message.
+	// * "session" to revoke a login on a device.
 	What string `json:"what"`
 	// Delete messages with these IDs (either one by one or a set of ranges)
 	DelSeq []MsgRange `json:"delseq,omitempty"`
@@ -304,6 +379,10 @@ type MsgClientDel struct {
 	Cred *MsgCredClient `json:"cred,omitempty"`
 	// Request to hard-delete objects (i.e. delete messages for all users), if such option is available.
 	Hard bool `json:"hard,omitempty"`
+	// ID of the scheduled message to cancel.
+	Sched string `json:"sched,omitempty"`
+	// ID of the login to revoke; if blank, all logins except the current one are revoked.
+	Session string `json:"session,omitempty"`
 }
 
 // MsgClientNote is a client-generated notification for topic subscribers {note}.
@@ -320,6 +399,10 @@ type MsgClientNote struct {
 	Event string `json:"event,omitempty"`
 	// Arbitrary json payload (used in video calls).
 	Payload json.RawMessage `json:"payload,omitempty"`
+	// Reaction to the message, such as an emoji; empty to remove the reaction.
+	Reaction string `json:"react,omitempty"`
+	// Indexes of the chosen poll options; empty to remove the vote.
+	Vote []int `json:"vote,omitempty"`
 }
 
 // MsgClientExtra is not a stand-alone message but extra data which augments the main payload.
@@ -363,6 +446,10 @@ type ClientComMessage struct {
 	MetaWhat int `json:"-"`
 	// Timestamp when this message was received by the server.
 	Timestamp time.Time `json:"-"`
+	// ID of the scheduled message being delivered by the server.
+	Scheduled string `json:"-"`
+	// Request from the server to delete messages which outlived the topic's message TTL.
+	MsgExpiry bool `json:"-"`
 
 	// Originating session to send an aknowledgement to.
 	sess *Session
@@ -453,6 +540,12 @@ type MsgTopicDesc struct {
 	Trusted any `json:"trusted,omitempty"`
 	// Per-subscription private data
 	Private any `json:"private,omitempty"`
+	// IDs of pinned messages.
+	Pinned []int `json:"pinned,omitempty"`
+	// Lifetime of messages in seconds.
+	MsgTTL int `json:"msgttl,omitempty"`
+	// Slow mode settings.
+	SlowMode *MsgSlowMode `json:"slow,omitempty"`
 }
 
 func (src *MsgTopicDesc) describe() string {
@@ -488,6 +581,15 @@ func (src *MsgTopicDesc) describe() string {
 	if src.Private != nil {
 		s += " priv='...'"
 	}
+	if len(src.Pinned) > 0 {
+		s += " pinned=" + strconv.Itoa(len(src.Pinned))
+	}
+	if src.MsgTTL != 0 {
+		s += " msgttl=" + strconv.Itoa(src.MsgTTL)
+	}
+	if src.SlowMode != nil {
+		s += " slow=" + strconv.Itoa(src.SlowMode.Interval) + "/" + strconv.Itoa(src.SlowMode.Burst)
+	}
 	return s
 }
 
@@ -580,6 +682,44 @@ type MsgDelValues struct {
 	DelSeq []MsgRange `json:"delseq,omitempty"`
 }
 
+// MsgEditRevision is a previous version of an edited message.
+type MsgEditRevision struct {
+	SeqId int `json:"seq"`
+	// Revision number, the original message is revision 1.
+	Rev       int            `json:"rev"`
+	Timestamp time.Time      `json:"ts"`
+	Head      map[string]any `json:"head,omitempty"`
+	Content   any            `json:"content"`
+}
+
+// MsgScheduled is a message scheduled for delivery at a later time.
+type MsgScheduled struct {
+	// ID of the scheduled message.
+	Id        string         `json:"id"`
+	DeliverAt time.Time      `json:"deliver"`
+	Timestamp time.Time      `json:"ts"`
+	Thread    int            `json:"thread,omitempty"`
+	Head      map[string]any `json:"head,omitempty"`
+	Content   any            `json:"content"`
+}
+
+// MsgAuthSession is a login on a device, 'me' only.
+type MsgAuthSession struct {
+	// ID of the login.
+	Id         string `json:"id"`
+	Platform   string `json:"platform,omitempty"`
+	UserAgent  string `json:"ua,omitempty"`
+	RemoteAddr string `json:"ip,omitempty"`
+	// Time of the login.
+	Created time.Time `json:"created"`
+	// Time when the device was last seen.
+	LastSeen time.Time `json:"seen"`
+	// Expiration time of the most recently issued token.
+	Expires time.Time `json:"expires"`
+	// The login used by the requesting session.
+	Current bool `json:"current,omitempty"`
+}
+
 // MsgServerCtrl is a server control message {ctrl}.
 type MsgServerCtrl struct {
 	Id     string `json:"id,omitempty"`
@@ -604,6 +744,26 @@ func (src *MsgServerCtrl) describe() string {
 	return src.Topic + " id=" + src.Id + " code=" + strconv.Itoa(src.Code) + " txt=" + src.Text
 }
 
+// MsgReaction is an aggregated count of identical reactions to a message.
+type MsgReaction struct {
+	// Reaction such as an emoji.
+	Value string `json:"val"`
+	// Number of users who reacted with this value.
+	Count int `json:"count"`
+	// The current user is one of the reacting users.
+	Mine bool `json:"mine,omitempty"`
+}
+
+// MsgPollVote is a count of votes for an option of a poll.
+type MsgPollVote struct {
+	// Zero-based index of the poll option.
+	Option int `json:"opt"`
+	// Number of users who chose this option.
+	Count int `json:"count"`
+	// The current user is one of the voters for this option.
+	Mine bool `json:"mine,omitempty"`
+}
+
 // MsgServerData is a server {data} message.
 type MsgServerData struct {
 	Topic string `json:"topic"`
@@ -614,6 +774,16 @@ type MsgServerData struct {
 	SeqId     int            `json:"seq"`
 	Head      map[string]any `json:"head,omitempty"`
 	Content   any            `json:"content"`
+	// Aggregated reactions to the message.
+	Reactions []MsgReaction `json:"react,omitempty"`
+	// Counts of votes if the message is a poll.
+	Votes []MsgPollVote `json:"poll,omitempty"`
+	// SeqId of the message this message is a reply to.
+	Thread int `json:"thread,omitempty"`
+	// Number of replies to this message.
+	Replies int `json:"replies,omitempty"`
+	// Timestamp of the latest reply to this message.
+	LastReply *time.Time `json:"lastreply,omitempty"`
 }
 
 // Deep-shallow copy.
@@ -736,6 +906,12 @@ type MsgServerMeta struct {
 	Cred []*MsgCredServer `json:"cred,omitempty"`
 	// Auxiliary data
 	Aux map[string]any `json:"aux,omitempty"`
+	// Previous versions of edited messages
+	Edits []MsgEditRevision `json:"edits,omitempty"`
+	// Messages scheduled for delivery at a later time
+	Scheduled []MsgScheduled `json:"scheduled,omitempty"`
+	// Logins on devices, 'me' only.
+	Sessions []MsgAuthSession `json:"sessions,omitempty"`
 }
 
 // Deep-shallow copy of meta message. Deep copy of Id and Topic fields, shallow copy of payload.
@@ -775,6 +951,15 @@ func (src *MsgServerMeta) describe() string {
 		x, _ := json.Marshal(src.Aux)
 		s += " aux=[" + string(x) + "]"
 	}
+	if src.Edits != nil {
+		s += " edits=[" + strconv.Itoa(len(src.Edits)) + "]"
+	}
+	if src.Scheduled != nil {
+		s += " scheduled=[" + strconv.Itoa(len(src.Scheduled)) + "]"
+	}
+	if src.Sessions != nil {
+		s += " sessions=[" + strconv.Itoa(len(src.Sessions)) + "]"
+	}
 	return s
 }
 
@@ -794,6 +979,10 @@ type MsgServerInfo struct {
 	Event string `json:"event,omitempty"`
 	// Arbitrary json payload (used by video calls).
 	Payload json.RawMessage `json:"payload,omitempty"`
+	// Aggregated reactions to the message, "react" event only.
+	Reactions []MsgReaction `json:"react,omitempty"`
+	// Counts of votes in a poll, "vote" event only.
+	Votes []MsgPollVote `json:"poll,omitempty"`
 
 	// UNroutable params. All marked with `json:"-"` to exclude from json marshaling.
 	// They are still serialized for intra-cluster communication.
@@ -1079,6 +1268,20 @@ func InfoChallenge(id string, ts time.Time, challenge []byte) *ServerComMessage
 	}
 }
 
+// InfoSecondFactor requires user to pass the second authentication factor before login can be completed (300).
+func InfoSecondFactor(id string, ts time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusMultipleChoices, // 300
+			Text:      "second factor required",
+			Timestamp: ts,
+		},
+		Id:        id,
+		Timestamp: ts,
+	}
+}
+
 // InfoAuthReset is sent in response to request to reset authentication when it was completed
 // but login was not performed (301).
 func InfoAuthReset(id string, ts time.Time) *ServerComMessage {
@@ -1273,6 +1476,39 @@ func ErrAuthFailed(id, topic string, serverTs, incomingReqTs time.Time) *ServerC
 	}
 }
 
+// ErrAuthResetRequired the secret is valid but must be reset, e.g. the password is too old,
+// with explicit server and incoming request timestamps (401).
+func ErrAuthResetRequired(id, topic string, serverTs, incomingReqTs time.Time) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusUnauthorized, // 401
+			Text:      "reset required",
+			Topic:     topic,
+			Timestamp: serverTs,
+		},
+		Id:        id,
+		Timestamp: incomingReqTs,
+	}
+}
+
+// ErrAuthLocked login is temporarily locked after too many failed attempts, with the number
+// of seconds to wait before retrying and explicit server and incoming request timestamps (423).
+func ErrAuthLocked(id, topic string, serverTs, incomingReqTs time.Time, retryAfter time.Duration) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusLocked, // 423
+			Text:      "login temporarily locked",
+			Params:    map[string]any{"retry-after": int((retryAfter + time.Second - 1) / time.Second)},
+			Topic:     topic,
+			Timestamp: serverTs,
+		},
+		Id:        id,
+		Timestamp: incomingReqTs,
+	}
+}
+
 // ErrAuthUnknownScheme authentication scheme is unrecognized or invalid (401).
 func ErrAuthUnknownScheme(id, topic string, ts time.Time) *ServerComMessage {
 	return &ServerComMessage{
@@ -1601,6 +1837,28 @@ func ErrPolicyReply(msg *ClientComMessage, ts time.Time) *ServerComMessage {
 	return ErrPolicyExplicitTs(msg.Id, msg.Original, ts, msg.Timestamp)
 }
 
+// ErrTooManyRequestsExplicitTs the user is sending messages too fast, with the number of seconds
+// to wait before retrying and explicit server and incoming request timestamps (429).
+func ErrTooManyRequestsExplicitTs(id, topic string, serverTs, incomingReqTs time.Time, retryAfter time.Duration) *ServerComMessage {
+	return &ServerComMessage{
+		Ctrl: &MsgServerCtrl{
+			Id:        id,
+			Code:      http.StatusTooManyRequests, // 429
+			Text:      "too many requests",
+			Params:    map[string]any{"retry-after": int((retryAfter + time.Second - 1) / time.Second)},
+			Topic:     topic,
+			Timestamp: serverTs,
+		},
+		Id:        id,
+		Timestamp: incomingReqTs,
+	}
+}
+
+// ErrTooManyRequestsReply the user is sending messages too fast in response to a client request (429).
+func ErrTooManyRequestsReply(msg *ClientComMessage, ts time.Time, retryAfter time.Duration) *ServerComMessage {
+	return ErrTooManyRequestsExplicitTs(msg.Id, msg.Original, ts, msg.Timestamp, retryAfter)
+}
+
 // ErrCallBusyExplicitTs indicates a "busy" reply to a video call request (486).
 func ErrCallBusyExplicitTs(id, topic string, serverTs, incomingReqTs time.Time) *ServerComMessage {
 	return &ServerComMessage{
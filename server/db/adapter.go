@@ -45,6 +45,9 @@ type Adapter interface {
 	UserGet(uid t.Uid) (*t.User, error)
 	// UserGetAll returns user records for a given list of user IDs
 	UserGetAll(ids ...t.Uid) ([]t.User, error)
+	// UserList returns up to limit users ordered by ID, starting after the user with the given ID.
+	// Suspended and soft-deleted users are included.
+	UserList(after t.Uid, limit int) ([]t.User, error)
 	// UserDelete deletes user record
 	UserDelete(uid t.Uid, hard bool) error
 	// UserUpdate updates user record
@@ -83,6 +86,10 @@ type Adapter interface {
 	AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error)
 	// AuthGetRecord returns authentication record given user ID and method.
 	AuthGetRecord(user t.Uid, scheme string) (string, auth.Level, []byte, time.Time, error)
+	// AuthGetUpdated returns the time when the secret of the authentication record was last changed.
+	AuthGetUpdated(user t.Uid, scheme string) (time.Time, error)
+	// AuthGetAllRecords returns all authentication records of the given user ordered by scheme.
+	AuthGetAllRecords(user t.Uid) ([]AuthRecord, error)
 	// AuthAddRecord creates new authentication record
 	AuthAddRecord(user t.Uid, scheme, unique string, authLvl auth.Level, secret []byte, expires time.Time) error
 	// AuthDelScheme deletes an existing authentication scheme for the user.
@@ -100,6 +107,9 @@ type Adapter interface {
 	TopicCreateP2P(initiator, invited *t.Subscription) error
 	// TopicGet loads a single topic by name, if it exists. If the topic does not exist the call returns (nil, nil)
 	TopicGet(topic string) (*t.Topic, error)
+	// TopicList returns up to limit topics ordered by name, starting after the topic with the given name.
+	// Deleted topics are included. Subscriber count is returned as stored.
+	TopicList(after string, limit int) ([]t.Topic, error)
 	// TopicsForUser loads subscriptions for a given user. Reads public value.
 	// When the 'opts.IfModifiedSince' query is not nil the subscriptions with UpdatedAt > opts.IfModifiedSince
 	// are returned, where UpdatedAt can be either a subscription, a topic, or a user update timestamp.
@@ -108,6 +118,7 @@ type Adapter interface {
 	// 1. Client already has subscriptions with the latest update timestamp X.
 	// 2. Client asks for N updated subscriptions since X. The server returns N with updates between X and Y.
 	// 3. Client goes to step 1 with X := Y.
+	// The result may come from a read replica and lag behind recent writes.
 	TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error)
 	// UsersForTopic loads users' subscriptions for a given topic. Public is loaded.
 	UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error)
@@ -127,10 +138,13 @@ type Adapter interface {
 	TopicUpdate(topic string, update map[string]any) error
 	// TopicOwnerChange updates topic's owner
 	TopicOwnerChange(topic string, newOwner t.Uid) error
+	// TopicsWithMsgTTL returns names and message TTLs of topics which have message TTL set.
+	TopicsWithMsgTTL() ([]t.Topic, error)
 
 	// Topic subscriptions
 
-	// SubscriptionGet reads a subscription of a user to a topic
+	// SubscriptionGet reads a subscription of a user to a topic. The result always reflects
+	// recent writes.
 	SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error)
 	// SubsForUser loads all subscriptions of a given user. Does NOT load Public or Private values,
 	// does not load deleted subscriptions.
@@ -151,6 +165,7 @@ type Adapter interface {
 	//   all users/topics which have at least one tag from each set.
 	// - opt is a list of optional tags; if present the result will rank higher.
 	// - activeOnly if true will return only active subscriptions.
+	// The result may come from a read replica and lag behind recent writes.
 	Find(caller, prefix string, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error)
 	// FindOne returns topic or user which matches the given tag.
 	FindOne(tag string) (string, error)
@@ -159,13 +174,100 @@ type Adapter interface {
 
 	// MessageSave saves message to database
 	MessageSave(msg *t.Message) error
-	// MessageGetAll returns messages matching the query
+	// MessageGetAll returns messages matching the query. If opts.Replica is true, the result may come
+	// from a read replica and lag behind recent writes.
 	MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error)
+	// MessageSearch returns messages matching the query with the plain text content containing
+	// the search string. The search is case-insensitive. If opts.Replica is true, the result may come
+	// from a read replica.
+	MessageSearch(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error)
 	// MessageDeleteList marks messages as deleted.
 	// Soft- or Hard- is defined by forUser value: forUser.IsZero == true is hard.
 	MessageDeleteList(topic string, toDel *t.DelMessage) error
 	// MessageGetDeleted returns a list of deleted message Ids.
 	MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error)
+	// MessageGetExpired returns up to limit sorted IDs of not yet deleted messages created before the given time.
+	MessageGetExpired(topic string, before time.Time, limit int) ([]int, error)
+	// MessageEdit saves the current version of the message as a revision then replaces message head,
+	// content and update time with the values from msg.
+	MessageEdit(msg *t.Message, rev *t.MessageRevision) error
+	// MessageGetEdits returns previous versions of messages matching the query.
+	MessageGetEdits(topic string, opts *t.QueryOpt) ([]t.MessageRevision, error)
+	// MessageEditSave saves a previous version of the message without changing the message.
+	// Returns ErrNotFound if the message does not exist or is deleted.
+	MessageEditSave(rev *t.MessageRevision) error
+	// MessageReact adds or replaces user's reaction to a message. Empty reaction removes it.
+	MessageReact(topic string, seqId int, user t.Uid, reaction string) error
+	// MessageGetReactions returns aggregated reactions to messages matching the query. The Mine flag
+	// is set for reactions by forUser.
+	MessageGetReactions(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageReaction, error)
+	// MessageGetUserReactions returns up to 'limit' reactions of individual users to messages in the topic
+	// ordered by SeqId and user, starting after the reaction 'after'. If 'after' is nil, starts from the beginning.
+	MessageGetUserReactions(topic string, after *UserReaction, limit int) ([]UserReaction, error)
+	// MessageVote replaces user's vote in a poll with the given options. Empty list of options removes the vote.
+	MessageVote(topic string, seqId int, user t.Uid, opts []int) error
+	// MessageGetVotes returns counts of votes for poll options in messages matching the query. The Mine flag
+	// is set for options chosen by forUser.
+	MessageGetVotes(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageVote, error)
+	// MessageGetUserVotes returns up to 'limit' poll options chosen by individual users in messages of the topic
+	// ordered by SeqId, user and option, starting after the vote 'after'. If 'after' is nil, starts from the beginning.
+	MessageGetUserVotes(topic string, after *UserVote, limit int) ([]UserVote, error)
+	// MessageGetThreads returns reply counts and timestamps of the latest replies to messages
+	// with SeqIds matching the query.
+	MessageGetThreads(topic string, opts *t.QueryOpt) ([]t.MessageThread, error)
+
+	// Scheduled messages
+
+	// ScheduledMessageSave saves a message for delivery at a later time.
+	ScheduledMessageSave(msg *t.ScheduledMessage) error
+	// ScheduledMessageGetAll returns messages scheduled by the given user in the given topic ordered by delivery time.
+	// If forUser is zero, messages scheduled by all users are returned up to the limit set by SetMaxResults.
+	ScheduledMessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.ScheduledMessage, error)
+	// ScheduledMessageGetDue returns up to limit messages due for delivery before the given time
+	// which are not claimed for delivery at that time.
+	ScheduledMessageGetDue(before time.Time, limit int) ([]t.ScheduledMessage, error)
+	// ScheduledMessageClaim claims the scheduled message for delivery until the given time. Returns
+	// ErrNotFound if the message does not exist or its claim has not expired by now.
+	ScheduledMessageClaim(id string, now, until time.Time) error
+	// ScheduledMessageDelete deletes a scheduled message. If forUser is not zero, only the message
+	// scheduled by that user is deleted. Returns ErrNotFound if no message was deleted.
+	ScheduledMessageDelete(topic string, forUser t.Uid, id string) error
+
+	// Authenticated sessions
+
+	// AuthSessionCreate saves a new authenticated session.
+	AuthSessionCreate(sess *t.AuthSession) error
+	// AuthSessionUpdate updates the last seen time, expiration time and client details of an authenticated session.
+	// Returns ErrNotFound if the session does not exist.
+	AuthSessionUpdate(sess *t.AuthSession) error
+	// AuthSessionGet returns the authenticated session with the given ID or nil if not found.
+	AuthSessionGet(id string) (*t.AuthSession, error)
+	// AuthSessionGetAll returns authenticated sessions of the user which have not expired yet ordered by creation time.
+	AuthSessionGetAll(uid t.Uid) ([]t.AuthSession, error)
+	// AuthSessionExpire deletes authenticated sessions of all users which expired before the given time.
+	AuthSessionExpire(olderThan time.Time) error
+	// AuthSessionDelete deletes authenticated sessions of the user with the given IDs.
+	AuthSessionDelete(uid t.Uid, ids []string) error
+
+	// API keys
+
+	// APIKeyCreate saves a new API key.
+	APIKeyCreate(key *t.APIKey) error
+	// APIKeyUpdate updates an API key. Returns ErrNotFound if the key does not exist.
+	APIKeyUpdate(key *t.APIKey) error
+	// APIKeyGet returns the API key with the given ID or nil if not found.
+	APIKeyGet(id string) (*t.APIKey, error)
+	// APIKeyGetAll returns all API keys ordered by creation time.
+	APIKeyGetAll() ([]t.APIKey, error)
+
+	// Audit log
+
+	// AuditAdd appends a record to the audit log.
+	AuditAdd(rec *t.AuditRecord) error
+	// AuditGetAll returns records of the audit log matching the query, newest first.
+	AuditGetAll(query *t.AuditQuery) ([]t.AuditRecord, error)
+	// AuditList returns up to limit records of the audit log ordered by ID, starting after the record with the given ID.
+	AuditList(after string, limit int) ([]t.AuditRecord, error)
 
 	// Devices (for push notifications)
 
@@ -184,12 +286,21 @@ type Adapter interface {
 	FileFinishUpload(fd *t.FileDef, success bool, size int64) (*t.FileDef, error)
 	// FileGet fetches a record of a specific file
 	FileGet(fid string) (*t.FileDef, error)
+	// FileGetAll returns up to 'limit' records of completed uploads by the given user ordered by ID,
+	// starting after the record 'after'.
+	FileGetAll(user, after t.Uid, limit int) ([]t.FileDef, error)
 	// FileDeleteUnused deletes records where UseCount is zero. If olderThan is non-zero, deletes
 	// unused records with UpdatedAt before olderThan.
 	// Returns array of FileDef.Location of deleted filerecords so actual files can be deleted too.
 	FileDeleteUnused(olderThan time.Time, limit int) ([]string, error)
+	// FileDelete deletes the record of the file unless the file is in use. Returns FileDef.Location
+	// of the deleted record so the actual file can be deleted too, or an empty string if the record
+	// is not found or the file is in use.
+	FileDelete(fid string) (string, error)
 	// FileLinkAttachments connects given topic or message to the file record IDs from the list.
 	FileLinkAttachments(topic string, userId, msgId t.Uid, fids []string) error
+	// FileGetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+	FileGetMessageAttachments(topic string, seqId int) ([]string, error)
 
 	// Persistent cache management.
 
@@ -197,13 +308,52 @@ type Adapter interface {
 	PCacheGet(key string) (string, error)
 	// PCacheUpsert creates or updates a persistent cache entry.
 	PCacheUpsert(key string, value string, failOnDuplicate bool) error
+	// PCacheSwap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+	// Returns false if the entry does not exist or has a different value.
+	PCacheSwap(key, oldValue, newValue string) (bool, error)
 	// PCacheDelete deletes a single persistent cache entry.
 	PCacheDelete(key string) error
 	// PCacheExpire expires older entries with the specified key prefix.
 	PCacheExpire(keyPrefix string, olderThan time.Time) error
+	// PCacheList returns up to limit persistent cache entries ordered by key, starting after the given key.
+	// Records internal to the adapter, such as the schema version, are not returned.
+	PCacheList(after string, limit int) ([]PCacheEntry, error)
 
 	// Testing
 
 	// GetTestDB returns a currently open database connection.
 	GetTestDB() any
 }
+
+// AuthRecord is an authentication record of a user.
+type AuthRecord struct {
+	Scheme string
+	// Unique value of the record, such as "basic:alice".
+	Unique  string
+	AuthLvl auth.Level
+	Secret  []byte
+	Expires time.Time
+}
+
+// UserReaction is a reaction of a user to a message.
+type UserReaction struct {
+	SeqId int
+	// UID as string of the reacting user.
+	User  string
+	Value string
+}
+
+// UserVote is a poll option chosen by a user.
+type UserVote struct {
+	SeqId int
+	// UID as string of the voter.
+	User   string
+	Option int
+}
+
+// PCacheEntry is a persistent cache entry.
+type PCacheEntry struct {
+	Key       string
+	Value     string
+	CreatedAt time.Time
+}
@@ -7,8 +7,10 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/drafty"
 	"github.com/tinode/chat/server/store"
 	t "github.com/tinode/chat/server/store/types"
 )
@@ -20,6 +22,8 @@ type AuthRecord struct {
 	AuthLvl auth.Level `json:"authLvl"`
 	Secret  []byte     `json:"secret"`
 	Expires time.Time  `json:"expires"`
+	// Time when the secret was changed.
+	UpdatedAt time.Time `json:"updatedat"`
 }
 
 // SelectEarliestUpdatedSubs selects no more than the given number of subscriptions from the
@@ -138,12 +142,50 @@ func FromJSON(src any) any {
 	if src == nil {
 		return nil
 	}
-	if bb, ok := src.([]byte); ok {
-		var out any
-		json.Unmarshal(bb, &out)
-		return out
+	var bb []byte
+	switch src := src.(type) {
+	case []byte:
+		bb = src
+	case string:
+		// Some drivers, e.g. SQLite, return JSON stored as text as a string.
+		bb = []byte(src)
+	default:
+		return nil
+	}
+	var out any
+	json.Unmarshal(bb, &out)
+	return out
+}
+
+// MessageSearchText converts message content to lowercase plain text to be stored alongside
+// the message and used for full-text search. The text of Drafty entities, such as poll options
+// and button labels, is included, URLs and other entity data are not.
+func MessageSearchText(content any) string {
+	txt, err := drafty.SearchText(content)
+	if err != nil {
+		// Not a Drafty document and not a string: nothing to index.
+		return ""
+	}
+	return strings.ToLower(txt)
+}
+
+// LikePattern converts a search string to a case-insensitive SQL LIKE pattern which matches
+// the string anywhere in the text. LIKE wildcards in the search string are escaped with '\'.
+func LikePattern(query string) string {
+	query = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(query))
+	return "%" + query + "%"
+}
+
+// TruncateString shortens the string to at most maxLen bytes without splitting UTF-8 characters.
+func TruncateString(str string, maxLen int) string {
+	if len(str) <= maxLen {
+		return str
+	}
+	str = str[:maxLen]
+	for len(str) > 0 && !utf8.ValidString(str) {
+		str = str[:len(str)-1]
 	}
-	return nil
+	return str
 }
 
 // Convert update to a list of columns and arguments.
@@ -393,3 +393,97 @@ func TestExtractTags(t *testing.T) {
 		t.Errorf("Expected %+v, got %+v", expected, tags)
 	}
 }
+
+func TestMessageSearchText(t *testing.T) {
+	// Test with plain string content
+	result := MessageSearchText("Hello World")
+	if result != "hello world" {
+		t.Errorf("Expected 'hello world', got '%s'", result)
+	}
+
+	// Test with Drafty content: only the text is indexed, not the entity data, text is lowercased
+	content := map[string]any{
+		"txt": "Visit Tinode",
+		"fmt": []any{map[string]any{"at": float64(6), "len": float64(6), "key": float64(0)}},
+		"ent": []any{map[string]any{"tp": "LN", "data": map[string]any{"url": "https://tinode.co"}}},
+	}
+	result = MessageSearchText(content)
+	if result != "visit tinode" {
+		t.Errorf("Expected 'visit tinode', got '%s'", result)
+	}
+
+	// Test with Drafty entities: the text of poll options and buttons is indexed, URLs and
+	// attachment names are not
+	content = map[string]any{
+		"txt": "Lunch? Vote Photo",
+		"fmt": []any{
+			map[string]any{"at": float64(0), "len": float64(6), "key": float64(0)},
+			map[string]any{"at": float64(7), "len": float64(4), "key": float64(1)},
+			map[string]any{"at": float64(12), "len": float64(5), "key": float64(2)},
+		},
+		"ent": []any{
+			map[string]any{"tp": "PL", "data": map[string]any{"opts": []any{"Pizza", "Sushi"}}},
+			map[string]any{"tp": "BN", "data": map[string]any{"act": "url", "ref": "https://example.com/vote"}},
+			map[string]any{"tp": "IM", "data": map[string]any{"name": "holiday.jpg", "ref": "https://example.com/img"}},
+		},
+	}
+	result = MessageSearchText(content)
+	if result != "lunch? pizza sushi vote" {
+		t.Errorf("Expected 'lunch? pizza sushi vote', got '%s'", result)
+	}
+	for _, excluded := range []string{"example.com", "holiday", "photo"} {
+		if strings.Contains(result, excluded) {
+			t.Errorf("Entity data '%s' must not be indexed, got '%s'", excluded, result)
+		}
+	}
+
+	// Test with Drafty content without text
+	result = MessageSearchText(map[string]any{
+		"ent": []any{map[string]any{"tp": "IM", "data": map[string]any{"name": "photo.jpg"}}},
+	})
+	if result != "" {
+		t.Errorf("Expected empty string for drafty without text, got '%s'", result)
+	}
+
+	// Test with unrecognized content
+	result = MessageSearchText(42)
+	if result != "" {
+		t.Errorf("Expected empty string for non-text content, got '%s'", result)
+	}
+
+	// Test with nil content
+	result = MessageSearchText(nil)
+	if result != "" {
+		t.Errorf("Expected empty string for nil content, got '%s'", result)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	// Test simple query
+	result := LikePattern("Hello")
+	if result != "%hello%" {
+		t.Errorf("Expected '%%hello%%', got '%s'", result)
+	}
+
+	// Test escaping of wildcards
+	result = LikePattern(`50%_off\`)
+	expected := `%50\%\_off\\%`
+	if result != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, result)
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	if result := TruncateString("Mozilla/5.0", 64); result != "Mozilla/5.0" {
+		t.Errorf("Expected string to be unchanged, got '%s'", result)
+	}
+
+	if result := TruncateString("Mozilla/5.0", 7); result != "Mozilla" {
+		t.Errorf("Expected 'Mozilla', got '%s'", result)
+	}
+
+	// Multibyte characters are not split.
+	if result := TruncateString("Привет", 5); result != "Пр" {
+		t.Errorf("Expected 'Пр', got '%s'", result)
+	}
+}
@@ -0,0 +1,162 @@
+package common
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+)
+
+// Default interval between checks of read replicas.
+const defaultReplicaCheckInterval = 5 * time.Second
+
+// Default maximum replication lag of read replicas.
+const defaultReplicaMaxLag = 5 * time.Second
+
+// ReplicaConfig is the configuration of read replicas of SQL adapters.
+type ReplicaConfig struct {
+	// Connection strings of the replicas in the same format as the DSN of the primary database.
+	DSNs []string `json:"dsns,omitempty"`
+	// Maximum replication lag in seconds, default 5. Replicas which fall further behind the primary are not
+	// used until they catch up. If negative, the lag is not checked, only availability.
+	MaxLag int `json:"max_lag,omitempty"`
+	// Interval between checks of the replicas in seconds, default 5.
+	CheckInterval int `json:"check_interval,omitempty"`
+}
+
+// ReplicaCheck checks availability of the replica with the given index. If withLag is true, it also
+// returns the replication lag of the replica.
+type ReplicaCheck func(ctx context.Context, idx int, withLag bool) (time.Duration, error)
+
+// ReplicaSet keeps track of read replicas which are available and not lagging too far behind the primary,
+// and distributes read queries between them. A nil *ReplicaSet has no replicas.
+type ReplicaSet struct {
+	// Name of the adapter for logging.
+	name     string
+	maxLag   time.Duration
+	interval time.Duration
+	check    ReplicaCheck
+
+	usable []atomic.Bool
+	next   atomic.Uint32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReplicaSet creates a set of count replicas. All replicas are unusable until checked by Start.
+func NewReplicaSet(name string, count int, config *ReplicaConfig, check ReplicaCheck) *ReplicaSet {
+	rs := &ReplicaSet{
+		name:     name,
+		maxLag:   time.Duration(config.MaxLag) * time.Second,
+		interval: time.Duration(config.CheckInterval) * time.Second,
+		check:    check,
+		usable:   make([]atomic.Bool, count),
+	}
+	if rs.interval <= 0 {
+		rs.interval = defaultReplicaCheckInterval
+	}
+	if config.MaxLag == 0 {
+		rs.maxLag = defaultReplicaMaxLag
+	}
+	return rs
+}
+
+// Start checks the replicas then keeps checking them in background until Stop is called.
+func (rs *ReplicaSet) Start() {
+	rs.CheckAll()
+
+	rs.stop = make(chan struct{})
+	rs.done = make(chan struct{})
+	go func() {
+		defer close(rs.done)
+		ticker := time.NewTicker(rs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rs.CheckAll()
+			case <-rs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop terminates background checks.
+func (rs *ReplicaSet) Stop() {
+	if rs == nil || rs.stop == nil {
+		return
+	}
+	close(rs.stop)
+	<-rs.done
+	rs.stop = nil
+}
+
+// CheckAll checks every replica and updates its state.
+func (rs *ReplicaSet) CheckAll() {
+	for i := range rs.usable {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.interval)
+		lag, err := rs.check(ctx, i, rs.maxLag > 0)
+		cancel()
+
+		usable := err == nil && (rs.maxLag <= 0 || lag <= rs.maxLag)
+		if rs.usable[i].Swap(usable) == usable {
+			continue
+		}
+		switch {
+		case usable:
+			rs.log(logs.Info, "replica %d is in use", i)
+		case err != nil:
+			rs.log(logs.Warn, "replica %d is unavailable: %s", i, err)
+		default:
+			rs.log(logs.Warn, "replica %d is lagging behind by %s", i, lag)
+		}
+	}
+}
+
+// Pick returns index of the next usable replica in round-robin order or -1 if no replica can be used
+// and the query must be sent to the primary.
+func (rs *ReplicaSet) Pick() int {
+	if rs == nil {
+		return -1
+	}
+	count := 0
+	for i := range rs.usable {
+		if rs.usable[i].Load() {
+			count++
+		}
+	}
+	if count == 0 {
+		return -1
+	}
+	// Pick the n-th usable replica.
+	n := int(rs.next.Add(1) % uint32(count))
+	for i := range rs.usable {
+		if rs.usable[i].Load() {
+			if n == 0 {
+				return i
+			}
+			n--
+		}
+	}
+	// The replica became unusable in the meantime.
+	return -1
+}
+
+// Failed marks the replica unusable until the next successful check.
+func (rs *ReplicaSet) Failed(idx int, err error) {
+	if rs.usable[idx].Swap(false) {
+		rs.log(logs.Warn, "replica %d failed, using primary: %s", idx, err)
+	}
+}
+
+func (rs *ReplicaSet) log(logger *log.Logger, format string, args ...any) {
+	// Loggers are not initialized when the adapter is used outside of the server, e.g. by tinode-db.
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(rs.name+": "+format, args...)
+}
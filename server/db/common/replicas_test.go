@@ -0,0 +1,128 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testReplica struct {
+	lag time.Duration
+	err error
+}
+
+func testReplicaCheck(replicas []testReplica, withLagCalls *int) ReplicaCheck {
+	return func(ctx context.Context, idx int, withLag bool) (time.Duration, error) {
+		if withLag {
+			*withLagCalls++
+		}
+		return replicas[idx].lag, replicas[idx].err
+	}
+}
+
+func TestReplicaSetNil(t *testing.T) {
+	var rs *ReplicaSet
+	if idx := rs.Pick(); idx != -1 {
+		t.Error("Nil replica set must pick the primary, got", idx)
+	}
+	rs.Stop()
+}
+
+func TestReplicaSetPick(t *testing.T) {
+	replicas := []testReplica{
+		{lag: time.Second},
+		{lag: 10 * time.Second},
+		{err: errors.New("connection refused")},
+		{},
+	}
+	var withLag int
+	rs := NewReplicaSet("test", len(replicas), &ReplicaConfig{MaxLag: 5}, testReplicaCheck(replicas, &withLag))
+
+	if idx := rs.Pick(); idx != -1 {
+		t.Error("Unchecked replicas must not be used, got", idx)
+	}
+
+	rs.CheckAll()
+	if withLag != len(replicas) {
+		t.Errorf("Lag must be checked for every replica, checked %d", withLag)
+	}
+
+	picked := make(map[int]int)
+	for range 10 {
+		picked[rs.Pick()]++
+	}
+	if len(picked) != 2 || picked[0] != 5 || picked[3] != 5 {
+		t.Error("Queries must be distributed between usable replicas 0 and 3, got", picked)
+	}
+
+	rs.Failed(0, errors.New("query failed"))
+	for range 3 {
+		if idx := rs.Pick(); idx != 3 {
+			t.Error("Failed replica must not be used, got", idx)
+		}
+	}
+
+	// Replica 1 catches up, replica 3 fails.
+	replicas[1].lag = 0
+	replicas[3].err = errors.New("timeout")
+	rs.CheckAll()
+	picked = make(map[int]int)
+	for range 10 {
+		picked[rs.Pick()]++
+	}
+	if len(picked) != 2 || picked[0] != 5 || picked[1] != 5 {
+		t.Error("Queries must be distributed between replicas 0 and 1, got", picked)
+	}
+
+	for i := range replicas {
+		rs.Failed(i, errors.New("query failed"))
+	}
+	if idx := rs.Pick(); idx != -1 {
+		t.Error("Primary must be used when no replica is usable, got", idx)
+	}
+}
+
+func TestReplicaSetDefaultLag(t *testing.T) {
+	replicas := []testReplica{{lag: 4 * time.Second}, {lag: 6 * time.Second}}
+	var withLag int
+	rs := NewReplicaSet("test", len(replicas), &ReplicaConfig{}, testReplicaCheck(replicas, &withLag))
+
+	rs.CheckAll()
+	if withLag != len(replicas) {
+		t.Error("Lag must be checked when max_lag is not set")
+	}
+	for range 3 {
+		if idx := rs.Pick(); idx != 0 {
+			t.Error("Replica lagging over the default limit must not be used, got", idx)
+		}
+	}
+}
+
+func TestReplicaSetNoLagCheck(t *testing.T) {
+	replicas := []testReplica{{lag: time.Hour}}
+	var withLag int
+	rs := NewReplicaSet("test", len(replicas), &ReplicaConfig{MaxLag: -1}, testReplicaCheck(replicas, &withLag))
+
+	rs.CheckAll()
+	if withLag != 0 {
+		t.Error("Lag must not be checked when max_lag is negative")
+	}
+	if idx := rs.Pick(); idx != 0 {
+		t.Error("Available replica must be used regardless of lag, got", idx)
+	}
+}
+
+func TestReplicaSetStartStop(t *testing.T) {
+	replicas := []testReplica{{}}
+	var withLag int
+	rs := NewReplicaSet("test", len(replicas), &ReplicaConfig{MaxLag: 1}, testReplicaCheck(replicas, &withLag))
+
+	rs.Start()
+	if idx := rs.Pick(); idx != 0 {
+		t.Error("Replicas must be checked on start, got", idx)
+	}
+	rs.Stop()
+	// Second call is a no-op.
+	rs.Stop()
+}
@@ -0,0 +1,3331 @@
+// Package memory is a database adapter which keeps all data in memory. It is intended for tests:
+// it lets the store and the rest of the server to be exercised without a database server. All data
+// is lost when the adapter is closed.
+//
+// The adapter is not linked into the server binary. Tests should import it for side effects and open the
+// store with "use_adapter": "memory".
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	adp "github.com/tinode/chat/server/db"
+	"github.com/tinode/chat/server/db/common"
+	"github.com/tinode/chat/server/store"
+	t "github.com/tinode/chat/server/store/types"
+)
+
+// adapter holds the in-memory database.
+type adapter struct {
+	// Guards db and its content.
+	mu sync.RWMutex
+	db *DB
+	// Maximum number of records to return
+	maxResults int
+	// Maximum number of message records to return
+	maxMessageResults int
+}
+
+const (
+	adpVersion  = 128
+	adapterName = "memory"
+
+	defaultMaxResults = 1024
+	// This is capped by the Session's send queue limit (128).
+	defaultMaxMessageResults = 100
+)
+
+// DB is the content of the in-memory database. It's returned by GetTestDB so tests can inspect records
+// which are not accessible through the adapter interface. The records must not be modified directly.
+type DB struct {
+	// Database version, zero if the database is not initialized.
+	Version int
+
+	Users        map[t.Uid]*t.User
+	Auth         []*AuthRecord
+	Creds        []*CredRecord
+	Topics       map[string]*t.Topic
+	Subs         []*t.Subscription
+	Messages     []*MessageRecord
+	DelLog       []*DelLogRecord
+	Edits        []*t.MessageRevision
+	Reactions    []*ReactionRecord
+	Votes        []*VoteRecord
+	Scheduled    []*ScheduledRecord
+	AuthSessions []*t.AuthSession
+	APIKeys      []*t.APIKey
+	Audit        []*t.AuditRecord
+	Devices      []*DeviceRecord
+	Files        []*t.FileDef
+	FileLinks    []*FileLinkRecord
+	KVMeta       map[string]*KVRecord
+
+	// Last assigned message ID.
+	lastMsgId t.Uid
+}
+
+// AuthRecord is a stored authentication record.
+type AuthRecord struct {
+	Unique  string
+	User    t.Uid
+	Scheme  string
+	AuthLvl auth.Level
+	Secret  []byte
+	Expires time.Time
+	// Time when the secret was changed.
+	UpdatedAt time.Time
+}
+
+// CredRecord is a stored credential.
+type CredRecord struct {
+	t.Credential
+	DeletedAt *time.Time
+	// "method:value" for validated credentials, "user:method:value" for unvalidated. Must be unique.
+	Synthetic string
+}
+
+// MessageRecord is a stored message.
+type MessageRecord struct {
+	t.Message
+	// Lowercase plain text of the message content for search.
+	SearchText string
+}
+
+// ScheduledRecord is a stored scheduled message.
+type ScheduledRecord struct {
+	t.ScheduledMessage
+	// The message is claimed for delivery until this time.
+	ClaimedUntil time.Time
+}
+
+// DelLogRecord is a log entry of a deleted range of messages [Low, Hi).
+type DelLogRecord struct {
+	Topic      string
+	DeletedFor t.Uid
+	DelId      int
+	Low        int
+	Hi         int
+}
+
+// ReactionRecord is a stored reaction of a user to a message.
+type ReactionRecord struct {
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	User      t.Uid
+	Value     string
+}
+
+// VoteRecord is a stored vote of a user for a poll option.
+type VoteRecord struct {
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	User      t.Uid
+	Option    int
+}
+
+// DeviceRecord is a stored device of a user.
+type DeviceRecord struct {
+	t.DeviceDef
+	User t.Uid
+}
+
+// FileLinkRecord connects a file record to a message, a topic or a user.
+type FileLinkRecord struct {
+	CreatedAt time.Time
+	FileId    t.Uid
+	MsgId     t.Uid
+	Topic     string
+	User      t.Uid
+}
+
+// KVRecord is a persistent cache entry.
+type KVRecord struct {
+	CreatedAt time.Time
+	Value     string
+}
+
+func newDB() *DB {
+	return &DB{
+		Users:  make(map[t.Uid]*t.User),
+		Topics: make(map[string]*t.Topic),
+		KVMeta: make(map[string]*KVRecord),
+	}
+}
+
+// Open initializes the in-memory database. The adapter has no configuration options.
+func (a *adapter) Open(jsonconfig json.RawMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.db != nil {
+		return errors.New("memory adapter is already open")
+	}
+
+	if a.maxResults <= 0 {
+		a.maxResults = defaultMaxResults
+	}
+
+	if a.maxMessageResults <= 0 {
+		a.maxMessageResults = defaultMaxMessageResults
+	}
+
+	a.db = newDB()
+	return nil
+}
+
+// Close discards the in-memory database.
+func (a *adapter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.db = nil
+	return nil
+}
+
+// IsOpen returns true if the adapter has been opened.
+func (a *adapter) IsOpen() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.db != nil
+}
+
+// GetDbVersion returns current database version.
+func (a *adapter) GetDbVersion() (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.db == nil || a.db.Version <= 0 {
+		return -1, errors.New("Database not initialized")
+	}
+	return a.db.Version, nil
+}
+
+// CheckDbVersion checks whether the actual DB version matches the expected version of this adapter.
+func (a *adapter) CheckDbVersion() error {
+	version, err := a.GetDbVersion()
+	if err != nil {
+		return err
+	}
+
+	if version != adpVersion {
+		return errors.New("Invalid database version " + strconv.Itoa(version) +
+			". Expected " + strconv.Itoa(adpVersion))
+	}
+
+	return nil
+}
+
+// Version returns adapter version.
+func (*adapter) Version() int {
+	return adpVersion
+}
+
+// Stats returns nil: there are no connections to report on.
+func (a *adapter) Stats() any {
+	return nil
+}
+
+// GetName returns string that adapter uses to register itself with store.
+func (a *adapter) GetName() string {
+	return adapterName
+}
+
+// SetMaxResults configures how many results can be returned in a single DB call.
+func (a *adapter) SetMaxResults(val int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if val <= 0 {
+		a.maxResults = defaultMaxResults
+	} else {
+		a.maxResults = val
+	}
+
+	return nil
+}
+
+// CreateDb initializes the storage. If reset is true, all existing data is discarded.
+func (a *adapter) CreateDb(reset bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.db == nil {
+		return errors.New("memory adapter is not open")
+	}
+
+	if a.db.Version > 0 && !reset {
+		return errors.New("Database already exists")
+	}
+
+	a.db = newDB()
+
+	// Create system topic 'sys'.
+	now := t.TimeNow()
+	a.db.Topics["sys"] = &t.Topic{
+		ObjHeader: t.ObjHeader{Id: "sys", CreatedAt: now, UpdatedAt: now},
+		TouchedAt: now,
+		Access:    t.DefaultAccess{Auth: t.ModeNone, Anon: t.ModeNone},
+		Public:    map[string]any{"fn": "System"},
+	}
+
+	a.db.Version = adpVersion
+	return nil
+}
+
+// UpgradeDb upgrades the database, if necessary.
+func (a *adapter) UpgradeDb() error {
+	if _, err := a.GetDbVersion(); err != nil {
+		return err
+	}
+
+	// The adapter was introduced at version 128, there is nothing to upgrade yet.
+	return nil
+}
+
+// Helpers for copying records in and out of the database so the callers cannot modify stored data.
+
+// copyJSON makes a deep copy of a value by converting it to JSON and back, same as storing it
+// in a JSON column of an SQL database.
+func copyJSON(src any) any {
+	return common.FromJSON(common.ToJSON(src))
+}
+
+func copyKVMap(src t.KVMap) t.KVMap {
+	if src == nil {
+		return nil
+	}
+	var dst t.KVMap
+	json.Unmarshal(common.ToJSON(src), &dst)
+	return dst
+}
+
+func copyTime(src *time.Time) *time.Time {
+	if src == nil {
+		return nil
+	}
+	dst := *src
+	return &dst
+}
+
+func copyUser(src *t.User) *t.User {
+	dst := *src
+	dst.StateAt = copyTime(src.StateAt)
+	dst.LastSeen = copyTime(src.LastSeen)
+	dst.Public = copyJSON(src.Public)
+	dst.Trusted = copyJSON(src.Trusted)
+	dst.Tags = slices.Clone(src.Tags)
+	dst.Devices = nil
+	dst.DeviceArray = nil
+	return &dst
+}
+
+func copyTopic(src *t.Topic) *t.Topic {
+	// Listing fields explicitly to skip the unexported ones.
+	return &t.Topic{
+		ObjHeader: t.ObjHeader{Id: src.Id, CreatedAt: src.CreatedAt, UpdatedAt: src.UpdatedAt},
+		State:     src.State,
+		StateAt:   copyTime(src.StateAt),
+		TouchedAt: src.TouchedAt,
+		UseBt:     src.UseBt,
+		Owner:     src.Owner,
+		Access:    src.Access,
+		SeqId:     src.SeqId,
+		DelId:     src.DelId,
+		SubCnt:    src.SubCnt,
+		Public:    copyJSON(src.Public),
+		Trusted:   copyJSON(src.Trusted),
+		Tags:      slices.Clone(src.Tags),
+		Aux:       copyKVMap(src.Aux),
+		Pinned:    slices.Clone(src.Pinned),
+		MsgTTL:    src.MsgTTL,
+		SlowMode:  src.SlowMode,
+		MsgBurst:  src.MsgBurst,
+	}
+}
+
+// copySub copies persistent fields of a subscription. Private is copied only if withPrivate is true.
+func copySub(src *t.Subscription, withPrivate bool) *t.Subscription {
+	dst := &t.Subscription{
+		ObjHeader: t.ObjHeader{CreatedAt: src.CreatedAt, UpdatedAt: src.UpdatedAt},
+		User:      src.User,
+		Topic:     src.Topic,
+		DeletedAt: copyTime(src.DeletedAt),
+		DelId:     src.DelId,
+		RecvSeqId: src.RecvSeqId,
+		ReadSeqId: src.ReadSeqId,
+		ModeWant:  src.ModeWant,
+		ModeGiven: src.ModeGiven,
+	}
+	if withPrivate {
+		dst.Private = copyJSON(src.Private)
+	}
+	return dst
+}
+
+func copyMessage(src *t.Message) *t.Message {
+	return &t.Message{
+		ObjHeader: t.ObjHeader{Id: src.Id, CreatedAt: src.CreatedAt, UpdatedAt: src.UpdatedAt},
+		DeletedAt: copyTime(src.DeletedAt),
+		DelId:     src.DelId,
+		SeqId:     src.SeqId,
+		Topic:     src.Topic,
+		Parent:    src.Parent,
+		From:      src.From,
+		Head:      copyKVMap(src.Head),
+		Content:   copyJSON(src.Content),
+	}
+}
+
+func copyScheduled(src *t.ScheduledMessage) *t.ScheduledMessage {
+	dst := *src
+	dst.Head = copyKVMap(src.Head)
+	dst.Content = copyJSON(src.Content)
+	return &dst
+}
+
+func copyAPIKey(src *t.APIKey) *t.APIKey {
+	dst := *src
+	dst.Origins = slices.Clone(src.Origins)
+	dst.Schemes = slices.Clone(src.Schemes)
+	dst.Messages = slices.Clone(src.Messages)
+	dst.RevokedAt = copyTime(src.RevokedAt)
+	return &dst
+}
+
+// applyUpdate assigns values from the update map to the fields of the record with the same names.
+// The names are case-insensitive, like column names in SQL.
+func applyUpdate(rec any, update map[string]any) error {
+	val := reflect.ValueOf(rec).Elem()
+	for name, value := range update {
+		field := val.FieldByNameFunc(func(fname string) bool {
+			return strings.EqualFold(fname, name)
+		})
+		if !field.IsValid() || !field.CanSet() {
+			return errors.New("memory adapter: unknown field '" + name + "'")
+		}
+		if err := setField(field, value); err != nil {
+			return errors.New("memory adapter: field '" + name + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value any) error {
+	if value == nil {
+		field.SetZero()
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Interface, reflect.Map, reflect.Slice:
+		// Deep copy of JSON-like values.
+		dst := reflect.New(field.Type())
+		if err := json.Unmarshal(common.ToJSON(value), dst.Interface()); err != nil {
+			return err
+		}
+		field.Set(dst.Elem())
+		return nil
+	}
+
+	src := reflect.ValueOf(value)
+	if field.Kind() == reflect.Pointer {
+		if src.Kind() == reflect.Pointer {
+			if src.IsNil() {
+				field.SetZero()
+				return nil
+			}
+			src = src.Elem()
+		}
+		if !src.Type().ConvertibleTo(field.Type().Elem()) {
+			return errors.New("incompatible type " + src.Type().String())
+		}
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(src.Convert(field.Type().Elem()))
+		field.Set(ptr)
+		return nil
+	}
+
+	if !src.Type().ConvertibleTo(field.Type()) {
+		return errors.New("incompatible type " + src.Type().String())
+	}
+	field.Set(src.Convert(field.Type()))
+	return nil
+}
+
+// decodeUid converts UID to a number which preserves order of creation.
+func decodeUid(uid t.Uid) int64 {
+	return store.DecodeUid(uid)
+}
+
+// inRanges checks if the ID is within one of the ranges.
+func inRanges(ranges []t.Range, id int) bool {
+	for _, r := range ranges {
+		if r.Hi == 0 {
+			if id == r.Low {
+				return true
+			}
+		} else if id >= r.Low && id < r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// seqIdFilter returns a function which checks if SeqId matches the query.
+func seqIdFilter(opts *t.QueryOpt) func(int) bool {
+	if opts == nil {
+		return func(int) bool { return true }
+	}
+	if len(opts.IdRanges) > 0 {
+		return func(id int) bool { return inRanges(opts.IdRanges, id) }
+	}
+	lower := max(opts.Since, 0)
+	upper := 1<<31 - 1
+	if opts.Before > 1 {
+		upper = opts.Before - 1
+	}
+	return func(id int) bool { return id >= lower && id <= upper }
+}
+
+// Remove records from the slice which match the condition. Returns the number of removed records.
+func deleteWhere[S ~[]E, E any](recs *S, match func(E) bool) int {
+	before := len(*recs)
+	*recs = slices.DeleteFunc(*recs, match)
+	return before - len(*recs)
+}
+
+// User management
+
+// UserCreate creates a new user.
+func (a *adapter) UserCreate(user *t.User) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	uid := user.Uid()
+	if _, ok := a.db.Users[uid]; ok {
+		return t.ErrDuplicate
+	}
+	if len(slices.Compact(slices.Sorted(slices.Values(user.Tags)))) != len(user.Tags) {
+		return t.ErrDuplicate
+	}
+
+	rec := copyUser(user)
+	rec.SetUid(uid)
+	a.db.Users[uid] = rec
+	return nil
+}
+
+// Add user's authentication record
+func (a *adapter) AuthAddRecord(uid t.Uid, scheme, unique string, authLvl auth.Level,
+	secret []byte, expires time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, rec := range a.db.Auth {
+		if rec.Unique == unique || (rec.User == uid && rec.Scheme == scheme) {
+			return t.ErrDuplicate
+		}
+	}
+
+	a.db.Auth = append(a.db.Auth, &AuthRecord{
+		Unique:  unique,
+		User:    uid,
+		Scheme:  scheme,
+		AuthLvl: authLvl,
+		Secret:  slices.Clone(secret),
+		Expires: expires,
+
+		UpdatedAt: t.TimeNow(),
+	})
+	return nil
+}
+
+// AuthDelScheme deletes an existing authentication scheme for the user.
+func (a *adapter) AuthDelScheme(user t.Uid, scheme string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	deleteWhere(&a.db.Auth, func(rec *AuthRecord) bool {
+		return rec.User == user && rec.Scheme == scheme
+	})
+	return nil
+}
+
+// AuthDelAllRecords deletes all authentication records for the user.
+func (a *adapter) AuthDelAllRecords(user t.Uid) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return deleteWhere(&a.db.Auth, func(rec *AuthRecord) bool {
+		return rec.User == user
+	}), nil
+}
+
+// AuthUpdRecord updates user's authentication record.
+func (a *adapter) AuthUpdRecord(uid t.Uid, scheme, unique string, authLvl auth.Level,
+	secret []byte, expires time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx := slices.IndexFunc(a.db.Auth, func(rec *AuthRecord) bool {
+		return rec.User == uid && rec.Scheme == scheme
+	})
+	if idx < 0 {
+		return t.ErrNotFound
+	}
+	rec := a.db.Auth[idx]
+
+	if unique != "" && unique != rec.Unique {
+		if slices.ContainsFunc(a.db.Auth, func(other *AuthRecord) bool {
+			return other.Unique == unique
+		}) {
+			return t.ErrDuplicate
+		}
+		rec.Unique = unique
+	}
+	rec.AuthLvl = authLvl
+	if len(secret) > 0 {
+		rec.Secret = slices.Clone(secret)
+		rec.UpdatedAt = t.TimeNow()
+	}
+	if !expires.IsZero() {
+		rec.Expires = expires
+	}
+	return nil
+}
+
+// AuthGetRecord retrieves user's authentication record.
+func (a *adapter) AuthGetRecord(uid t.Uid, scheme string) (string, auth.Level, []byte, time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, rec := range a.db.Auth {
+		if rec.User == uid && rec.Scheme == scheme {
+			return rec.Unique, rec.AuthLvl, slices.Clone(rec.Secret), rec.Expires, nil
+		}
+	}
+	return "", 0, nil, time.Time{}, t.ErrNotFound
+}
+
+// AuthGetUpdated returns the time when the secret of the authentication record was last changed.
+func (a *adapter) AuthGetUpdated(uid t.Uid, scheme string) (time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, rec := range a.db.Auth {
+		if rec.User == uid && rec.Scheme == scheme {
+			return rec.UpdatedAt, nil
+		}
+	}
+	return time.Time{}, t.ErrNotFound
+}
+
+// AuthGetAllRecords returns all authentication records of the given user ordered by scheme.
+func (a *adapter) AuthGetAllRecords(uid t.Uid) ([]adp.AuthRecord, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var records []adp.AuthRecord
+	for _, rec := range a.db.Auth {
+		if rec.User == uid {
+			records = append(records, adp.AuthRecord{
+				Scheme:  rec.Scheme,
+				Unique:  rec.Unique,
+				AuthLvl: rec.AuthLvl,
+				Secret:  slices.Clone(rec.Secret),
+				Expires: rec.Expires,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Scheme < records[j].Scheme })
+	return records, nil
+}
+
+// AuthGetUniqueRecord retrieves user's authentication record by unique value (e.g. by login).
+func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, rec := range a.db.Auth {
+		if rec.Unique == unique {
+			return rec.User, rec.AuthLvl, slices.Clone(rec.Secret), rec.Expires, nil
+		}
+	}
+	return t.ZeroUid, 0, nil, time.Time{}, nil
+}
+
+// UserGet fetches a single user by user id. If user is not found it returns (nil, nil)
+func (a *adapter) UserGet(uid t.Uid) (*t.User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if user := a.db.Users[uid]; user != nil && user.State != t.StateDeleted {
+		return copyUser(user), nil
+	}
+	return nil, nil
+}
+
+// sortedUsers returns stored users ordered by ID.
+func (db *DB) sortedUsers() []*t.User {
+	users := make([]*t.User, 0, len(db.Users))
+	for _, user := range db.Users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return decodeUid(users[i].Uid()) < decodeUid(users[j].Uid())
+	})
+	return users
+}
+
+// UserGetAll returns user records for a given list of user IDs
+func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	users := []t.User{}
+	for _, user := range a.db.sortedUsers() {
+		if user.State != t.StateDeleted && slices.Contains(ids, user.Uid()) {
+			users = append(users, *copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+// UserList returns up to limit users ordered by ID, starting after the user with the given ID.
+func (a *adapter) UserList(after t.Uid, limit int) ([]t.User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var users []t.User
+	for _, user := range a.db.sortedUsers() {
+		if len(users) >= limit {
+			break
+		}
+		if after.IsZero() || decodeUid(user.Uid()) > decodeUid(after) {
+			users = append(users, *copyUser(user))
+		}
+	}
+	return users, nil
+}
+
+// UserDelete deletes specified user: wipes completely (hard-delete) or marks as deleted.
+func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	db := a.db
+	owner := uid.String()
+	// Names of topics owned by the user as 'grp' and 'chn'.
+	var ownTopics []string
+	for name, topic := range db.Topics {
+		// In case of hard delete, delete all topics, even those which were soft-deleted previously.
+		if topic.Owner == owner && (hard || topic.State != t.StateDeleted) {
+			ownTopics = append(ownTopics, name)
+			if channel := t.GrpToChn(name); channel != "" {
+				ownTopics = append(ownTopics, channel)
+			}
+		}
+	}
+
+	now := t.TimeNow()
+	if hard {
+		// Delete user's devices.
+		deleteWhere(&db.Devices, func(dev *DeviceRecord) bool { return dev.User == uid })
+
+		// Delete user's subscriptions in all topics.
+		db.subsDelForUser(uid, true)
+
+		// Delete records of messages soft-deleted for the user, user's reactions, votes and scheduled messages.
+		deleteWhere(&db.DelLog, func(rec *DelLogRecord) bool { return rec.DeletedFor == uid })
+		deleteWhere(&db.Reactions, func(rec *ReactionRecord) bool { return rec.User == uid })
+		deleteWhere(&db.Votes, func(rec *VoteRecord) bool { return rec.User == uid })
+		deleteWhere(&db.Scheduled, func(msg *ScheduledRecord) bool { return msg.From == owner })
+
+		// Can't delete user's messages in all topics because we cannot notify topics of such deletion.
+		// Just leave the messages there marked as sent by "not found" user.
+
+		// Delete topics where the user is the owner.
+		for _, name := range ownTopics {
+			if db.Topics[name] != nil {
+				db.messageDeleteList(name, nil)
+			}
+			deleteWhere(&db.Subs, func(sub *t.Subscription) bool { return sub.Topic == name })
+			db.topicDeleted(name)
+		}
+
+		deleteWhere(&db.Auth, func(rec *AuthRecord) bool { return rec.User == uid })
+		deleteWhere(&db.AuthSessions, func(sess *t.AuthSession) bool { return sess.User == owner })
+		db.credDel(uid, "", "")
+		deleteWhere(&db.FileLinks, func(link *FileLinkRecord) bool { return link.User == uid })
+		delete(db.Users, uid)
+	} else {
+		// Disable all user's subscriptions. That includes p2p subscriptions. No need to delete them.
+		db.subsDelForUser(uid, false)
+
+		// Disable all subscriptions to topics where the user is the owner.
+		for _, sub := range db.Subs {
+			if slices.Contains(ownTopics, sub.Topic) {
+				sub.UpdatedAt = now
+				sub.DeletedAt = copyTime(&now)
+			}
+		}
+
+		// P2P topics with the user.
+		var p2pTopics []string
+		for _, sub := range db.Subs {
+			if sub.User == owner && strings.HasPrefix(sub.Topic, "p2p") {
+				p2pTopics = append(p2pTopics, sub.Topic)
+			}
+		}
+
+		// Disable group topics where the user is the owner and p2p topics with the user.
+		for name, topic := range db.Topics {
+			if topic.Owner == owner || (topic.Owner == "" && slices.Contains(p2pTopics, name)) {
+				topic.UpdatedAt = now
+				topic.TouchedAt = now
+				topic.State = t.StateDeleted
+				topic.StateAt = copyTime(&now)
+			}
+		}
+
+		// Disable the other user's subscription to a disabled p2p topic.
+		for _, sub := range db.Subs {
+			if slices.Contains(p2pTopics, sub.Topic) {
+				sub.UpdatedAt = now
+				sub.DeletedAt = copyTime(&now)
+			}
+		}
+
+		// Finally disable user.
+		if user := db.Users[uid]; user != nil {
+			user.UpdatedAt = now
+			user.State = t.StateDeleted
+			user.StateAt = copyTime(&now)
+		}
+	}
+
+	return nil
+}
+
+// topicStateForUser is called by UserUpdate when the update contains state change.
+// Soft-deleted topics remain soft-deleted.
+func (db *DB) topicStateForUser(uid t.Uid, now time.Time, update any) error {
+	state, ok := update.(t.ObjState)
+	if !ok {
+		return t.ErrMalformed
+	}
+
+	if now.IsZero() {
+		now = t.TimeNow()
+	}
+
+	owner := uid.String()
+	for name, topic := range db.Topics {
+		if topic.State == t.StateDeleted {
+			continue
+		}
+		// Change state of all topics where the user is the owner and p2p topics with the user.
+		if topic.Owner == owner || (topic.Owner == "" &&
+			slices.ContainsFunc(db.Subs, func(sub *t.Subscription) bool {
+				return sub.Topic == name && sub.User == owner
+			})) {
+			topic.State = state
+			topic.StateAt = copyTime(&now)
+		}
+	}
+
+	// Subscriptions don't need to be updated:
+	// subscriptions of a disabled user are not disabled and still can be manipulated.
+	return nil
+}
+
+// UserUpdate updates user object.
+func (a *adapter) UserUpdate(uid t.Uid, update map[string]any) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := a.db.Users[uid]
+	if user == nil {
+		// Same as updating zero rows.
+		return nil
+	}
+
+	// Update a copy to keep the record intact in case of an error.
+	upd := copyUser(user)
+	if err := applyUpdate(upd, update); err != nil {
+		return err
+	}
+	if tags := common.ExtractTags(update); tags != nil {
+		if len(slices.Compact(slices.Sorted(slices.Values(tags)))) != len(tags) {
+			return t.ErrDuplicate
+		}
+	}
+
+	if state, ok := update["State"]; ok {
+		now, _ := update["StateAt"].(time.Time)
+		if err := a.db.topicStateForUser(uid, now, state); err != nil {
+			return err
+		}
+	}
+
+	a.db.Users[uid] = upd
+	return nil
+}
+
+// UserUpdateTags adds or resets user's tags
+func (a *adapter) UserUpdateTags(uid t.Uid, add, remove, reset []string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := a.db.Users[uid]
+	if user == nil {
+		return nil, t.ErrNotFound
+	}
+
+	tags := slices.Clone(user.Tags)
+	if reset != nil {
+		// Delete all tags first if resetting.
+		tags = nil
+		add = reset
+		remove = nil
+	}
+
+	for _, tag := range add {
+		if slices.Contains(tags, tag) {
+			// Ignore duplicates unless resetting.
+			if reset == nil {
+				continue
+			}
+			return nil, t.ErrDuplicate
+		}
+		tags = append(tags, tag)
+	}
+
+	tags = slices.DeleteFunc(tags, func(tag string) bool {
+		return slices.Contains(remove, tag)
+	})
+	if len(tags) == 0 {
+		tags = nil
+	}
+	// Tags are returned in the index order.
+	slices.Sort(tags)
+
+	user.Tags = t.StringSlice(tags)
+	return slices.Clone(tags), nil
+}
+
+// UserGetByCred returns user ID for the given validated credential.
+func (a *adapter) UserGetByCred(method, value string) (t.Uid, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	synth := method + ":" + value
+	for _, cred := range a.db.Creds {
+		if cred.Synthetic == synth {
+			return t.ParseUid(cred.User), nil
+		}
+	}
+	return t.ZeroUid, nil
+}
+
+// UserUnreadCount returns the total number of unread messages in all topics with
+// the R permission. If read fails, the counts are still returned with the original
+// user IDs but with the unread count undefined and non-nil error.
+func (a *adapter) UserUnreadCount(ids ...t.Uid) (map[t.Uid]int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	counts := make(map[t.Uid]int, len(ids))
+	for _, id := range ids {
+		// Ensure all original uids are always present.
+		counts[id] = 0
+	}
+
+	// Channel subscriptions are skipped: for channels the subscription name is different from the topic name.
+	for _, sub := range a.db.Subs {
+		uid := t.ParseUid(sub.User)
+		if _, ok := counts[uid]; !ok || sub.DeletedAt != nil {
+			continue
+		}
+		topic := a.db.Topics[sub.Topic]
+		if topic == nil || topic.State == t.StateDeleted || !sub.ModeWant.IsReader() || !sub.ModeGiven.IsReader() {
+			continue
+		}
+		counts[uid] += topic.SeqId - sub.ReadSeqId
+	}
+
+	return counts, nil
+}
+
+// UserGetUnvalidated returns a list of uids which have never logged in, have no
+// validated credentials and haven't been updated since lastUpdatedBefore.
+func (a *adapter) UserGetUnvalidated(lastUpdatedBefore time.Time, limit int) ([]t.Uid, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var users []*t.User
+	for _, user := range a.db.sortedUsers() {
+		if user.LastSeen != nil || !user.UpdatedAt.Before(lastUpdatedBefore) {
+			continue
+		}
+		uid := user.Id
+		if slices.ContainsFunc(a.db.Creds, func(cred *CredRecord) bool {
+			return cred.User == uid && cred.Done
+		}) {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].UpdatedAt.Before(users[j].UpdatedAt)
+	})
+
+	var uids []t.Uid
+	for _, user := range users {
+		if len(uids) >= limit {
+			break
+		}
+		uids = append(uids, user.Uid())
+	}
+	return uids, nil
+}
+
+// Topic management
+
+// topicCreate saves a new topic. Only the fields which are set at creation are stored.
+func (db *DB) topicCreate(topic *t.Topic) error {
+	if _, ok := db.Topics[topic.Id]; ok {
+		return t.ErrDuplicate
+	}
+	if len(slices.Compact(slices.Sorted(slices.Values(topic.Tags)))) != len(topic.Tags) {
+		return t.ErrDuplicate
+	}
+
+	db.Topics[topic.Id] = &t.Topic{
+		ObjHeader: t.ObjHeader{Id: topic.Id, CreatedAt: topic.CreatedAt, UpdatedAt: topic.UpdatedAt},
+		TouchedAt: topic.TouchedAt,
+		State:     topic.State,
+		UseBt:     topic.UseBt,
+		Owner:     topic.Owner,
+		Access:    topic.Access,
+		Public:    copyJSON(topic.Public),
+		Trusted:   copyJSON(topic.Trusted),
+		Tags:      slices.Clone(topic.Tags),
+		Aux:       copyKVMap(topic.Aux),
+	}
+	return nil
+}
+
+// TopicCreate saves topic object to database.
+func (a *adapter) TopicCreate(topic *t.Topic) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.db.topicCreate(topic)
+}
+
+// If undelete = true - update subscription on duplicate key, otherwise ignore the duplicate.
+func (db *DB) createSubscription(sub *t.Subscription, undelete bool) {
+	idx := slices.IndexFunc(db.Subs, func(s *t.Subscription) bool {
+		return s.Topic == sub.Topic && s.User == sub.User
+	})
+	if idx < 0 {
+		rec := copySub(sub, true)
+		rec.DeletedAt = nil
+		rec.DelId, rec.RecvSeqId, rec.ReadSeqId = 0, 0, 0
+		db.Subs = append(db.Subs, rec)
+	} else {
+		rec := db.Subs[idx]
+		rec.CreatedAt = sub.CreatedAt
+		rec.UpdatedAt = sub.UpdatedAt
+		rec.DeletedAt = nil
+		rec.ModeWant = sub.ModeWant
+		rec.ModeGiven = sub.ModeGiven
+		rec.DelId, rec.RecvSeqId, rec.ReadSeqId = 0, 0, 0
+		if !undelete {
+			rec.Private = copyJSON(sub.Private)
+		}
+	}
+
+	if (sub.ModeGiven & sub.ModeWant).IsOwner() {
+		// Update topic owner if the subscription is with owner rights.
+		// Don't increment subscriber count here - it's done in TopicShare in bulk.
+		if topic := db.Topics[sub.Topic]; topic != nil {
+			topic.Owner = t.ParseUid(sub.User).String()
+		}
+	}
+}
+
+// TopicCreateP2P given two users creates a p2p topic
+func (a *adapter) TopicCreateP2P(initiator, invited *t.Subscription) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.db.Topics[initiator.Topic]; ok {
+		return t.ErrDuplicate
+	}
+
+	a.db.createSubscription(initiator, false)
+
+	// If the second subscription exists, don't overwrite it. Just make sure it's not deleted.
+	a.db.createSubscription(invited, true)
+
+	topic := &t.Topic{ObjHeader: t.ObjHeader{Id: initiator.Topic}}
+	topic.ObjHeader.MergeTimes(&initiator.ObjHeader)
+	topic.TouchedAt = initiator.GetTouchedAt()
+	return a.db.topicCreate(topic)
+}
+
+// countSubs returns the number of not deleted subscriptions to the topic, including channel readers.
+func (db *DB) countSubs(topic string) int {
+	channel := t.GrpToChn(topic)
+	count := 0
+	for _, sub := range db.Subs {
+		if (sub.Topic == topic || sub.Topic == channel) && sub.DeletedAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// TopicGet loads a single topic by name, if it exists. If the topic does not exist the call returns (nil, nil)
+func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec := a.db.Topics[topic]
+	if rec == nil {
+		return nil, nil
+	}
+
+	if t.GetTopicCat(topic) == t.TopicCatGrp {
+		// Refresh subscription count, both topic and channel subscriptions are counted.
+		rec.SubCnt = a.db.countSubs(topic)
+	}
+
+	return copyTopic(rec), nil
+}
+
+// TopicList returns up to limit topics ordered by name, starting after the topic with the given name.
+// Deleted topics are included.
+func (a *adapter) TopicList(after string, limit int) ([]t.Topic, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var names []string
+	for name := range a.db.Topics {
+		if name > after {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	var topics []t.Topic
+	for _, name := range names {
+		topics = append(topics, *copyTopic(a.db.Topics[name]))
+	}
+	return topics, nil
+}
+
+// TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
+// Reads and denormalizes Public value.
+func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := 0
+	ims := time.Time{}
+	var onlyTopic string
+	if opts != nil {
+		onlyTopic = opts.Topic
+		// Apply the limit only when the client does not manage the cache (or cold start).
+		// Otherwise have to get all subscriptions and do a manual join with users/topics.
+		if opts.IfModifiedSince == nil {
+			if opts.Limit > 0 && opts.Limit < a.maxResults {
+				limit = opts.Limit
+			} else {
+				limit = a.maxResults
+			}
+		} else {
+			ims = *opts.IfModifiedSince
+		}
+	} else {
+		limit = a.maxResults
+	}
+
+	user := uid.String()
+	var names []string
+	join := make(map[string]*t.Subscription)
+	count := 0
+	for _, rec := range a.db.Subs {
+		if rec.User != user || (!keepDeleted && rec.DeletedAt != nil) || (onlyTopic != "" && rec.Topic != onlyTopic) {
+			continue
+		}
+		if limit > 0 && count >= limit {
+			break
+		}
+		count++
+
+		sub := copySub(rec, true)
+		tname := sub.Topic
+		switch t.GetTopicCat(tname) {
+		case t.TopicCatMe, t.TopicCatFnd:
+			// One of 'me', 'fnd' subscriptions, skip.
+			continue
+		case t.TopicCatP2P:
+			// P2P subscription, find the other user to get user.Public and user.Trusted.
+			uid1, uid2, _ := t.ParseP2P(tname)
+			if uid1 == uid {
+				sub.SetWith(uid2.UserId())
+			} else {
+				sub.SetWith(uid1.UserId())
+			}
+			if usr2 := a.db.Users[t.ParseUserId(sub.GetWith())]; usr2 != nil &&
+				(keepDeleted || usr2.State != t.StateDeleted) {
+				// Ignoring ims: we need all users to get LastSeen and UserAgent.
+				sub.UpdatedAt = common.SelectLatestTime(sub.UpdatedAt, usr2.UpdatedAt)
+				sub.SetState(usr2.State)
+				sub.SetPublic(copyJSON(usr2.Public))
+				sub.SetTrusted(copyJSON(usr2.Trusted))
+				sub.SetDefaultAccess(usr2.Access.Auth, usr2.Access.Anon)
+				sub.SetLastSeenAndUA(usr2.LastSeen, usr2.UserAgent)
+			}
+		case t.TopicCatGrp:
+			// Maybe convert channel name to group topic name.
+			tname = t.ChnToGrp(tname)
+		}
+		// No special handling needed for 'slf', 'sys' subscriptions.
+
+		if !slices.Contains(names, tname) {
+			names = append(names, tname)
+		}
+		join[tname] = sub
+	}
+
+	var subs []t.Subscription
+	if len(join) == 0 {
+		return subs, nil
+	}
+
+	// Join topics to subscriptions.
+	for _, tname := range names {
+		sub := join[tname]
+		top := a.db.Topics[tname]
+		if top != nil && (keepDeleted || top.State != t.StateDeleted) && (ims.IsZero() || top.TouchedAt.After(ims)) {
+			// Check if sub.UpdatedAt needs to be adjusted to earlier or later time.
+			sub.UpdatedAt = common.SelectLatestTime(sub.UpdatedAt, top.UpdatedAt)
+			sub.SetState(top.State)
+			sub.SetTouchedAt(top.TouchedAt)
+			sub.SetSeqId(top.SeqId)
+			if t.GetTopicCat(sub.Topic) == t.TopicCatGrp {
+				sub.SetSubCnt(top.SubCnt)
+				sub.SetPublic(copyJSON(top.Public))
+				sub.SetTrusted(copyJSON(top.Trusted))
+			}
+		}
+		subs = append(subs, *sub)
+	}
+
+	return common.SelectEarliestUpdatedSubs(subs, opts, a.maxResults), nil
+}
+
+// UsersForTopic loads users subscribed to the given topic.
+// The difference between UsersForTopic vs SubsForTopic is that the former loads user.Public,
+// the latter does not.
+func (a *adapter) UsersForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	tcat := t.GetTopicCat(topic)
+
+	limit := a.maxResults
+	var oneUser t.Uid
+	if opts != nil {
+		// Ignore IfModifiedSince: loading all entries because a topic cannot have too many subscribers.
+		// Those unmodified will be stripped of Public & Private.
+		oneUser = opts.User
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	var subs []t.Subscription
+	for _, rec := range a.db.Subs {
+		if len(subs) >= limit {
+			break
+		}
+		if rec.Topic != topic {
+			continue
+		}
+		user := a.db.Users[t.ParseUid(rec.User)]
+		if user == nil {
+			continue
+		}
+		if !keepDeleted {
+			// Filter out rows with users deleted.
+			if user.State == t.StateDeleted {
+				continue
+			}
+			// For p2p topics we must load all subscriptions including deleted.
+			// Otherwise it will be impossible to swipe Public values.
+			if tcat != t.TopicCatP2P && rec.DeletedAt != nil {
+				continue
+			}
+		}
+		// For p2p topics we have to fetch both users otherwise public cannot be swapped.
+		if !oneUser.IsZero() && tcat != t.TopicCatP2P && rec.User != oneUser.String() {
+			continue
+		}
+
+		sub := copySub(rec, true)
+		sub.SetPublic(copyJSON(user.Public))
+		sub.SetTrusted(copyJSON(user.Trusted))
+		sub.SetLastSeenAndUA(user.LastSeen, user.UserAgent)
+		subs = append(subs, *sub)
+	}
+
+	if tcat == t.TopicCatP2P && len(subs) > 0 {
+		// Swap public & lastSeen values of P2P topics as expected.
+		if len(subs) == 1 {
+			// The other user is deleted, nothing we can do.
+			subs[0].SetPublic(nil)
+			subs[0].SetTrusted(nil)
+			subs[0].SetLastSeenAndUA(nil, "")
+		} else {
+			tmp := subs[0].GetPublic()
+			subs[0].SetPublic(subs[1].GetPublic())
+			subs[1].SetPublic(tmp)
+
+			tmp = subs[0].GetTrusted()
+			subs[0].SetTrusted(subs[1].GetTrusted())
+			subs[1].SetTrusted(tmp)
+
+			lastSeen := subs[0].GetLastSeen()
+			userAgent := subs[0].GetUserAgent()
+			subs[0].SetLastSeenAndUA(subs[1].GetLastSeen(), subs[1].GetUserAgent())
+			subs[1].SetLastSeenAndUA(lastSeen, userAgent)
+		}
+
+		// Remove deleted and unneeded subscriptions
+		if !keepDeleted || !oneUser.IsZero() {
+			subs = slices.DeleteFunc(subs, func(sub t.Subscription) bool {
+				return (sub.DeletedAt != nil && !keepDeleted) || (!oneUser.IsZero() && sub.User != oneUser.String())
+			})
+		}
+	}
+
+	return subs, nil
+}
+
+// OwnTopics loads a slice of topic names where the user is the owner.
+func (a *adapter) OwnTopics(uid t.Uid) ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	owner := uid.String()
+	var names []string
+	for name, topic := range a.db.Topics {
+		if topic.Owner == owner && topic.State != t.StateDeleted {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ChannelsForUser loads a slice of topic names where the user is a channel reader and notifications (P) are enabled.
+func (a *adapter) ChannelsForUser(uid t.Uid) ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := uid.String()
+	var names []string
+	for _, sub := range a.db.Subs {
+		if sub.User == user && t.IsChannel(sub.Topic) && sub.DeletedAt == nil &&
+			sub.ModeWant.IsPresencer() && sub.ModeGiven.IsPresencer() {
+			names = append(names, sub.Topic)
+		}
+	}
+	return names, nil
+}
+
+// TopicShare creates topic subscriptions.
+func (a *adapter) TopicShare(topic string, shares []*t.Subscription) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, sub := range shares {
+		a.db.createSubscription(sub, true)
+	}
+
+	if rec := a.db.Topics[topic]; rec != nil {
+		// Update topic's subscription count.
+		rec.SubCnt += len(shares)
+	}
+
+	return nil
+}
+
+// topicDeleted removes the topic record and the records which refer to it.
+func (db *DB) topicDeleted(topic string) {
+	delete(db.Topics, topic)
+	deleteWhere(&db.FileLinks, func(link *FileLinkRecord) bool { return link.Topic == topic })
+	deleteWhere(&db.Scheduled, func(msg *ScheduledRecord) bool { return msg.Topic == topic })
+}
+
+// TopicDelete deletes specified topic.
+func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// If the topic is a channel, must try to delete subscriptions under both grpXXX and chnXXX names.
+	names := []string{topic}
+	if isChan {
+		names = append(names, t.GrpToChn(topic))
+	}
+
+	if hard {
+		// Delete subscriptions. If this is a channel, delete both group subscriptions and channel subscriptions.
+		deleteWhere(&a.db.Subs, func(sub *t.Subscription) bool { return slices.Contains(names, sub.Topic) })
+		a.db.messageDeleteList(topic, nil)
+		a.db.topicDeleted(topic)
+	} else {
+		now := t.TimeNow()
+		for _, sub := range a.db.Subs {
+			if slices.Contains(names, sub.Topic) {
+				sub.UpdatedAt = now
+				sub.DeletedAt = copyTime(&now)
+			}
+		}
+		if rec := a.db.Topics[topic]; rec != nil {
+			rec.UpdatedAt = now
+			rec.TouchedAt = now
+			rec.State = t.StateDeleted
+			rec.StateAt = copyTime(&now)
+		}
+	}
+	return nil
+}
+
+// TopicUpdateOnMessage increments Topic's or User's SeqId value and updates TouchedAt timestamp.
+func (a *adapter) TopicUpdateOnMessage(topic string, msg *t.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rec := a.db.Topics[topic]; rec != nil {
+		rec.SeqId = msg.SeqId
+		rec.TouchedAt = msg.CreatedAt
+	}
+	return nil
+}
+
+// TopicUpdateSubCnt updates subscriber count denormalized in topic.
+func (a *adapter) TopicUpdateSubCnt(topic string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rec := a.db.Topics[topic]; rec != nil {
+		rec.SubCnt = a.db.countSubs(topic)
+	}
+	return nil
+}
+
+// TopicUpdate updates topic record.
+func (a *adapter) TopicUpdate(topic string, update map[string]any) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, u := update["TouchedAt"], update["UpdatedAt"]; t == nil && u != nil {
+		update["TouchedAt"] = u
+	}
+
+	rec := a.db.Topics[topic]
+	if rec == nil {
+		// Same as updating zero rows.
+		return nil
+	}
+
+	// Update a copy to keep the record intact in case of an error.
+	upd := copyTopic(rec)
+	if err := applyUpdate(upd, update); err != nil {
+		return err
+	}
+	if len(slices.Compact(slices.Sorted(slices.Values(upd.Tags)))) != len(upd.Tags) {
+		return t.ErrDuplicate
+	}
+	a.db.Topics[topic] = upd
+	return nil
+}
+
+// TopicOwnerChange updates topic's owner
+func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rec := a.db.Topics[topic]; rec != nil {
+		rec.Owner = newOwner.String()
+	}
+	return nil
+}
+
+// TopicsWithMsgTTL returns names and message TTLs of topics which have message TTL set.
+func (a *adapter) TopicsWithMsgTTL() ([]t.Topic, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var topics []t.Topic
+	for name, rec := range a.db.Topics {
+		if rec.MsgTTL > 0 && rec.State != t.StateDeleted {
+			topics = append(topics, t.Topic{ObjHeader: t.ObjHeader{Id: name}, MsgTTL: rec.MsgTTL})
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Id < topics[j].Id
+	})
+	return topics, nil
+}
+
+// findSub returns the subscription of the user to the topic or nil if not found.
+func (db *DB) findSub(topic string, user t.Uid) *t.Subscription {
+	uid := user.String()
+	for _, sub := range db.Subs {
+		if sub.Topic == topic && sub.User == uid {
+			return sub
+		}
+	}
+	return nil
+}
+
+// SubscriptionGet reads a subscription of a user to a topic.
+func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	sub := a.db.findSub(topic, user)
+	if sub == nil || (!keepDeleted && sub.DeletedAt != nil) {
+		return nil, nil
+	}
+	return copySub(sub, true), nil
+}
+
+// SubsForUser loads all user's subscriptions. Does NOT load Public or Private values and does
+// not load deleted subscriptions.
+func (a *adapter) SubsForUser(forUser t.Uid) ([]t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := forUser.String()
+	var subs []t.Subscription
+	for _, sub := range a.db.Subs {
+		if sub.User == user && sub.DeletedAt == nil {
+			subs = append(subs, *copySub(sub, false))
+		}
+	}
+	return subs, nil
+}
+
+// SubsForTopic fetches all subsciptions for a topic. Does NOT load Public value.
+// The difference between UsersForTopic vs SubsForTopic is that the former loads user.public,
+// the latter does not.
+func (a *adapter) SubsForTopic(topic string, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxResults
+	var oneUser string
+	if opts != nil {
+		// Ignore IfModifiedSince - we must return all entries
+		// Those unmodified will be stripped of Public & Private.
+		if !opts.User.IsZero() {
+			oneUser = opts.User.String()
+		}
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	var subs []t.Subscription
+	for _, sub := range a.db.Subs {
+		if len(subs) >= limit {
+			break
+		}
+		if sub.Topic != topic || (!keepDeleted && sub.DeletedAt != nil) || (oneUser != "" && sub.User != oneUser) {
+			continue
+		}
+		subs = append(subs, *copySub(sub, true))
+	}
+	return subs, nil
+}
+
+// SubsUpdate updates part of a subscription object. Pass nil for fields which don't need to be updated
+func (a *adapter) SubsUpdate(topic string, user t.Uid, update map[string]any) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	uid := user.String()
+	var updated []*t.Subscription
+	for _, sub := range a.db.Subs {
+		if sub.Topic != topic || (!user.IsZero() && sub.User != uid) {
+			continue
+		}
+		// Update a copy to keep the record intact in case of an error.
+		upd := copySub(sub, true)
+		if err := applyUpdate(upd, update); err != nil {
+			return err
+		}
+		updated = append(updated, upd)
+	}
+
+	for _, upd := range updated {
+		idx := slices.IndexFunc(a.db.Subs, func(sub *t.Subscription) bool {
+			return sub.Topic == upd.Topic && sub.User == upd.User
+		})
+		a.db.Subs[idx] = upd
+	}
+	return nil
+}
+
+// SubsDelete marks subscription as deleted.
+func (a *adapter) SubsDelete(topic string, user t.Uid) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sub := a.db.findSub(topic, user)
+	if sub == nil || sub.DeletedAt != nil {
+		return t.ErrNotFound
+	}
+
+	now := t.TimeNow()
+	sub.UpdatedAt = now
+	sub.DeletedAt = copyTime(&now)
+
+	// Channel readers cannot delete messages.
+	if !t.IsChannel(topic) {
+		// Remove records of messages soft-deleted by this user.
+		deleteWhere(&a.db.DelLog, func(rec *DelLogRecord) bool {
+			return rec.Topic == topic && rec.DeletedFor == user
+		})
+	}
+
+	if t.GetTopicCat(topic) == t.TopicCatGrp {
+		// Decrement topic subscription count (only one subscription is deleted).
+		if rec := a.db.Topics[t.ChnToGrp(topic)]; rec != nil {
+			rec.SubCnt--
+		}
+	}
+
+	return nil
+}
+
+// subsDelForUser deletes or marks as deleted all subscriptions of the given user.
+func (db *DB) subsDelForUser(user t.Uid, hard bool) {
+	uid := user.String()
+	now := t.TimeNow()
+	for _, sub := range db.Subs {
+		if sub.User != uid || sub.DeletedAt != nil {
+			continue
+		}
+		// Decrement subscription count for all topics the user is subscribed to.
+		if rec := db.Topics[t.ChnToGrp(sub.Topic)]; rec != nil {
+			rec.SubCnt--
+		} else if rec := db.Topics[sub.Topic]; rec != nil {
+			rec.SubCnt--
+		}
+		if !hard {
+			sub.UpdatedAt = now
+			sub.DeletedAt = copyTime(&now)
+		}
+	}
+
+	if hard {
+		deleteWhere(&db.Subs, func(sub *t.Subscription) bool { return sub.User == uid })
+	}
+}
+
+// Find returns a list of users and topics who match given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
+func (a *adapter) Find(caller, promoPrefix string, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	index := make(map[string]struct{})
+	for _, tag := range append(t.FlattenDoubleSlice(req), opt...) {
+		index[tag] = struct{}{}
+	}
+
+	// Number of matched tags. The max number of tags is 16. Using 20 to make sure one prefix match is
+	// greater than all non-prefix matches.
+	rank := func(tags []string) int {
+		matches := 0
+		for _, tag := range tags {
+			if _, ok := index[tag]; !ok {
+				continue
+			}
+			if promoPrefix != "" && strings.HasPrefix(tag, promoPrefix) {
+				matches += 20
+			} else {
+				matches++
+			}
+		}
+		if matches == 0 {
+			return 0
+		}
+		// At least one of the tags must be present in each set of required tags.
+		for _, reqDisjunction := range req {
+			if len(reqDisjunction) > 0 && !slices.ContainsFunc(tags, func(tag string) bool {
+				return slices.Contains(reqDisjunction, tag)
+			}) {
+				return 0
+			}
+		}
+		return matches
+	}
+
+	type found struct {
+		sub     t.Subscription
+		matches int
+		tags    t.StringSlice
+	}
+	var results []found
+	for _, user := range a.db.sortedUsers() {
+		if activeOnly && user.State != t.StateOK {
+			continue
+		}
+		if matches := rank(user.Tags); matches > 0 {
+			var sub t.Subscription
+			sub.Topic = user.Uid().UserId()
+			sub.CreatedAt = user.CreatedAt
+			sub.UpdatedAt = user.UpdatedAt
+			sub.SetPublic(copyJSON(user.Public))
+			sub.SetTrusted(copyJSON(user.Trusted))
+			sub.SetDefaultAccess(user.Access.Auth, user.Access.Anon)
+			results = append(results, found{sub: sub, matches: matches, tags: user.Tags})
+		}
+	}
+
+	names := slices.Sorted(maps.Keys(a.db.Topics))
+	for _, name := range names {
+		topic := a.db.Topics[name]
+		if activeOnly && topic.State != t.StateOK {
+			continue
+		}
+		if matches := rank(topic.Tags); matches > 0 {
+			var sub t.Subscription
+			sub.Topic = name
+			if topic.UseBt {
+				// This is a channel, convert grp to chn name: all channel-capable
+				// topics should appear as channels in search results.
+				sub.Topic = t.GrpToChn(name)
+			}
+			sub.CreatedAt = topic.CreatedAt
+			sub.UpdatedAt = topic.UpdatedAt
+			sub.SetSubCnt(topic.SubCnt)
+			sub.SetPublic(copyJSON(topic.Public))
+			sub.SetTrusted(copyJSON(topic.Trusted))
+			sub.SetDefaultAccess(topic.Access.Auth, topic.Access.Anon)
+			results = append(results, found{sub: sub, matches: matches, tags: topic.Tags})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].matches != results[j].matches {
+			return results[i].matches > results[j].matches
+		}
+		return results[i].sub.GetSubCnt() > results[j].sub.GetSubCnt()
+	})
+	if len(results) > a.maxResults {
+		results = results[:a.maxResults]
+	}
+
+	var subs []t.Subscription
+	for _, res := range results {
+		if res.sub.Topic == caller {
+			// Skip the caller.
+			continue
+		}
+		sub := res.sub
+		// Indicating that the mode is not set, not 'N'.
+		sub.ModeGiven = t.ModeUnset
+		sub.ModeWant = t.ModeUnset
+		sub.Private = common.FilterFoundTags(res.tags, index)
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+// FindOne returns the first topic or user which matches the given tag.
+func (a *adapter) FindOne(tag string) (string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, name := range slices.Sorted(maps.Keys(a.db.Topics)) {
+		if slices.Contains(a.db.Topics[name].Tags, tag) {
+			return name, nil
+		}
+	}
+	for _, user := range a.db.sortedUsers() {
+		if slices.Contains(user.Tags, tag) {
+			return user.Uid().UserId(), nil
+		}
+	}
+	return "", nil
+}
+
+// Messages
+
+// MessageSave saves message to database.
+func (a *adapter) MessageSave(msg *t.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// store assignes message ID, but we don't use it. Using a sequential ID like SQL databases do.
+	a.db.lastMsgId++
+	msg.SetUid(a.db.lastMsgId)
+
+	rec := &MessageRecord{Message: *copyMessage(msg), SearchText: common.MessageSearchText(msg.Content)}
+	rec.From = t.ParseUid(msg.From).String()
+	rec.DeletedAt = nil
+	rec.DelId = 0
+	rec.DeletedFor = nil
+	a.db.Messages = append(a.db.Messages, rec)
+	return nil
+}
+
+// findMessage returns a message which is not hard-deleted or nil if not found.
+func (db *DB) findMessage(topic string, seqId int) *MessageRecord {
+	for _, msg := range db.Messages {
+		if msg.Topic == topic && msg.SeqId == seqId && msg.DelId == 0 {
+			return msg
+		}
+	}
+	return nil
+}
+
+// MessageGetAll returns messages matching the query.
+func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, "", opts)
+}
+
+// MessageSearch returns messages matching the query with the plain text content containing the search string.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, search, opts)
+}
+
+func (a *adapter) messagesGet(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxMessageResults
+	thread := 0
+	if opts != nil {
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+		thread = opts.Thread
+	}
+	matchSeqId := seqIdFilter(opts)
+	search = strings.ToLower(search)
+
+	// Ranges of messages soft-deleted for the user.
+	var deleted []t.Range
+	for _, rec := range a.db.DelLog {
+		if rec.Topic == topic && rec.DeletedFor == forUser && !forUser.IsZero() {
+			deleted = append(deleted, t.Range{Low: rec.Low, Hi: rec.Hi})
+		}
+	}
+
+	var found []*MessageRecord
+	for _, msg := range a.db.Messages {
+		if msg.Topic != topic || msg.DelId != 0 || !matchSeqId(msg.SeqId) || inRanges(deleted, msg.SeqId) {
+			continue
+		}
+		if thread > 0 && msg.Parent != thread {
+			continue
+		}
+		if search != "" && !strings.Contains(msg.SearchText, search) {
+			continue
+		}
+		found = append(found, msg)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].SeqId > found[j].SeqId
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	msgs := make([]t.Message, 0, len(found))
+	for _, msg := range found {
+		msgs = append(msgs, *copyMessage(&msg.Message))
+	}
+	return msgs, nil
+}
+
+// MessageEdit saves the current version of the message as a revision and replaces message head and content.
+func (a *adapter) MessageEdit(msg *t.Message, rev *t.MessageRevision) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.db.addEdit(rev); err != nil {
+		return err
+	}
+
+	if rec := a.db.findMessage(msg.Topic, msg.SeqId); rec != nil {
+		rec.UpdatedAt = msg.UpdatedAt
+		rec.Head = copyKVMap(msg.Head)
+		rec.Content = copyJSON(msg.Content)
+		rec.SearchText = common.MessageSearchText(msg.Content)
+	}
+	return nil
+}
+
+// MessageEditSave saves a previous version of the message without changing the message.
+func (a *adapter) MessageEditSave(rev *t.MessageRevision) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.db.addEdit(rev)
+}
+
+// addEdit saves a copy of the revision of an existing message.
+func (db *DB) addEdit(rev *t.MessageRevision) error {
+	if db.findMessage(rev.Topic, rev.SeqId) == nil {
+		return t.ErrNotFound
+	}
+	if slices.ContainsFunc(db.Edits, func(e *t.MessageRevision) bool {
+		return e.Topic == rev.Topic && e.SeqId == rev.SeqId && e.Rev == rev.Rev
+	}) {
+		return t.ErrDuplicate
+	}
+
+	db.Edits = append(db.Edits, &t.MessageRevision{
+		CreatedAt: rev.CreatedAt,
+		Topic:     rev.Topic,
+		SeqId:     rev.SeqId,
+		Rev:       rev.Rev,
+		Head:      copyKVMap(rev.Head),
+		Content:   copyJSON(rev.Content),
+	})
+	return nil
+}
+
+// MessageGetEdits returns previous versions of messages matching the query.
+func (a *adapter) MessageGetEdits(topic string, opts *t.QueryOpt) ([]t.MessageRevision, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxResults
+	if opts != nil && opts.Limit > 0 && opts.Limit < limit {
+		limit = opts.Limit
+	}
+	matchSeqId := seqIdFilter(opts)
+
+	var revs []t.MessageRevision
+	for _, rev := range a.db.Edits {
+		if rev.Topic == topic && matchSeqId(rev.SeqId) {
+			revs = append(revs, t.MessageRevision{
+				CreatedAt: rev.CreatedAt,
+				Topic:     rev.Topic,
+				SeqId:     rev.SeqId,
+				Rev:       rev.Rev,
+				Head:      copyKVMap(rev.Head),
+				Content:   copyJSON(rev.Content),
+			})
+		}
+	}
+
+	sort.Slice(revs, func(i, j int) bool {
+		if revs[i].SeqId != revs[j].SeqId {
+			return revs[i].SeqId > revs[j].SeqId
+		}
+		return revs[i].Rev > revs[j].Rev
+	})
+	if len(revs) > limit {
+		revs = revs[:limit]
+	}
+	return revs, nil
+}
+
+// MessageReact adds or replaces user's reaction to a message. Empty reaction removes it.
+func (a *adapter) MessageReact(topic string, seqId int, user t.Uid, reaction string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if reaction != "" && a.db.findMessage(topic, seqId) == nil {
+		return t.ErrNotFound
+	}
+
+	deleteWhere(&a.db.Reactions, func(rec *ReactionRecord) bool {
+		return rec.Topic == topic && rec.SeqId == seqId && rec.User == user
+	})
+
+	if reaction != "" {
+		a.db.Reactions = append(a.db.Reactions, &ReactionRecord{
+			CreatedAt: t.TimeNow(),
+			Topic:     topic,
+			SeqId:     seqId,
+			User:      user,
+			Value:     reaction,
+		})
+	}
+	return nil
+}
+
+// optionalSeqIdFilter is like seqIdFilter but matches all IDs when the query has neither IdRanges nor Since/Before.
+func optionalSeqIdFilter(opts *t.QueryOpt) func(int) bool {
+	if opts != nil && len(opts.IdRanges) == 0 && opts.Since <= 0 && opts.Before <= 0 {
+		return func(int) bool { return true }
+	}
+	return seqIdFilter(opts)
+}
+
+// MessageGetReactions returns aggregated reactions to messages matching the query.
+func (a *adapter) MessageGetReactions(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageReaction, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matchSeqId := optionalSeqIdFilter(opts)
+
+	var reacts []t.MessageReaction
+	for _, rec := range a.db.Reactions {
+		if rec.Topic != topic || !matchSeqId(rec.SeqId) {
+			continue
+		}
+		idx := slices.IndexFunc(reacts, func(r t.MessageReaction) bool {
+			return r.SeqId == rec.SeqId && r.Value == rec.Value
+		})
+		if idx < 0 {
+			reacts = append(reacts, t.MessageReaction{SeqId: rec.SeqId, Value: rec.Value})
+			idx = len(reacts) - 1
+		}
+		reacts[idx].Count++
+		reacts[idx].Mine = reacts[idx].Mine || rec.User == forUser
+	}
+
+	sort.Slice(reacts, func(i, j int) bool {
+		if reacts[i].SeqId != reacts[j].SeqId {
+			return reacts[i].SeqId < reacts[j].SeqId
+		}
+		if reacts[i].Count != reacts[j].Count {
+			return reacts[i].Count > reacts[j].Count
+		}
+		return reacts[i].Value < reacts[j].Value
+	})
+	return reacts, nil
+}
+
+// MessageGetUserReactions returns up to 'limit' reactions of individual users to messages in the topic
+// ordered by SeqId and user, starting after the reaction 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserReactions(topic string, after *adp.UserReaction, limit int) ([]adp.UserReaction, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	less := func(x, y *adp.UserReaction) bool {
+		if x.SeqId != y.SeqId {
+			return x.SeqId < y.SeqId
+		}
+		return decodeUid(t.ParseUid(x.User)) < decodeUid(t.ParseUid(y.User))
+	}
+
+	var reacts []adp.UserReaction
+	for _, rec := range a.db.Reactions {
+		if rec.Topic != topic {
+			continue
+		}
+		react := adp.UserReaction{SeqId: rec.SeqId, User: rec.User.String(), Value: rec.Value}
+		if after == nil || less(after, &react) {
+			reacts = append(reacts, react)
+		}
+	}
+	sort.Slice(reacts, func(i, j int) bool {
+		return less(&reacts[i], &reacts[j])
+	})
+	if len(reacts) > limit {
+		reacts = reacts[:limit]
+	}
+	return reacts, nil
+}
+
+// MessageVote replaces user's vote in a poll with the given options. Empty list of options removes the vote.
+func (a *adapter) MessageVote(topic string, seqId int, user t.Uid, opts []int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(opts) > 0 && a.db.findMessage(topic, seqId) == nil {
+		return t.ErrNotFound
+	}
+
+	deleteWhere(&a.db.Votes, func(rec *VoteRecord) bool {
+		return rec.Topic == topic && rec.SeqId == seqId && rec.User == user
+	})
+
+	now := t.TimeNow()
+	for _, opt := range opts {
+		if slices.ContainsFunc(a.db.Votes, func(rec *VoteRecord) bool {
+			return rec.Topic == topic && rec.SeqId == seqId && rec.User == user && rec.Option == opt
+		}) {
+			return t.ErrDuplicate
+		}
+		a.db.Votes = append(a.db.Votes, &VoteRecord{
+			CreatedAt: now,
+			Topic:     topic,
+			SeqId:     seqId,
+			User:      user,
+			Option:    opt,
+		})
+	}
+	return nil
+}
+
+// MessageGetVotes returns counts of votes for poll options in messages matching the query.
+func (a *adapter) MessageGetVotes(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageVote, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matchSeqId := optionalSeqIdFilter(opts)
+
+	var votes []t.MessageVote
+	for _, rec := range a.db.Votes {
+		if rec.Topic != topic || !matchSeqId(rec.SeqId) {
+			continue
+		}
+		idx := slices.IndexFunc(votes, func(v t.MessageVote) bool {
+			return v.SeqId == rec.SeqId && v.Option == rec.Option
+		})
+		if idx < 0 {
+			votes = append(votes, t.MessageVote{SeqId: rec.SeqId, Option: rec.Option})
+			idx = len(votes) - 1
+		}
+		votes[idx].Count++
+		votes[idx].Mine = votes[idx].Mine || rec.User == forUser
+	}
+
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].SeqId != votes[j].SeqId {
+			return votes[i].SeqId < votes[j].SeqId
+		}
+		return votes[i].Option < votes[j].Option
+	})
+	return votes, nil
+}
+
+// MessageGetUserVotes returns up to 'limit' poll options chosen by individual users in messages of the topic
+// ordered by SeqId, user and option, starting after the vote 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserVotes(topic string, after *adp.UserVote, limit int) ([]adp.UserVote, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	less := func(x, y *adp.UserVote) bool {
+		if x.SeqId != y.SeqId {
+			return x.SeqId < y.SeqId
+		}
+		if x.User != y.User {
+			return decodeUid(t.ParseUid(x.User)) < decodeUid(t.ParseUid(y.User))
+		}
+		return x.Option < y.Option
+	}
+
+	var votes []adp.UserVote
+	for _, rec := range a.db.Votes {
+		if rec.Topic != topic {
+			continue
+		}
+		vote := adp.UserVote{SeqId: rec.SeqId, User: rec.User.String(), Option: rec.Option}
+		if after == nil || less(after, &vote) {
+			votes = append(votes, vote)
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return less(&votes[i], &votes[j])
+	})
+	if len(votes) > limit {
+		votes = votes[:limit]
+	}
+	return votes, nil
+}
+
+// MessageGetThreads returns reply counts and timestamps of the latest replies to messages
+// with SeqIds matching the query.
+func (a *adapter) MessageGetThreads(topic string, opts *t.QueryOpt) ([]t.MessageThread, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	matchParent := optionalSeqIdFilter(opts)
+
+	var threads []t.MessageThread
+	for _, msg := range a.db.Messages {
+		if msg.Topic != topic || msg.DelId != 0 || msg.Parent <= 0 || !matchParent(msg.Parent) {
+			continue
+		}
+		idx := slices.IndexFunc(threads, func(th t.MessageThread) bool {
+			return th.SeqId == msg.Parent
+		})
+		if idx < 0 {
+			threads = append(threads, t.MessageThread{SeqId: msg.Parent})
+			idx = len(threads) - 1
+		}
+		threads[idx].Replies++
+		if msg.CreatedAt.After(threads[idx].LastReplyAt) {
+			threads[idx].LastReplyAt = msg.CreatedAt
+		}
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].SeqId < threads[j].SeqId
+	})
+	return threads, nil
+}
+
+// Scheduled messages
+
+// ScheduledMessageSave saves a message for delivery at a later time.
+func (a *adapter) ScheduledMessageSave(msg *t.ScheduledMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := msg.Uid()
+	if slices.ContainsFunc(a.db.Scheduled, func(rec *ScheduledRecord) bool { return rec.Uid() == id }) {
+		return t.ErrDuplicate
+	}
+
+	rec := &ScheduledRecord{ScheduledMessage: *copyScheduled(msg)}
+	rec.SetUid(id)
+	rec.From = t.ParseUid(msg.From).String()
+	a.db.Scheduled = append(a.db.Scheduled, rec)
+	return nil
+}
+
+// scheduledGet returns up to limit scheduled messages which match the condition, ordered by delivery time.
+func (db *DB) scheduledGet(match func(*ScheduledRecord) bool, limit int) []t.ScheduledMessage {
+	var found []*ScheduledRecord
+	for _, msg := range db.Scheduled {
+		if match(msg) {
+			found = append(found, msg)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].DeliverAt.Equal(found[j].DeliverAt) {
+			return found[i].DeliverAt.Before(found[j].DeliverAt)
+		}
+		return decodeUid(found[i].Uid()) < decodeUid(found[j].Uid())
+	})
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	var msgs []t.ScheduledMessage
+	for _, msg := range found {
+		msgs = append(msgs, *copyScheduled(&msg.ScheduledMessage))
+	}
+	return msgs
+}
+
+// ScheduledMessageGetAll returns messages scheduled by the given user in the given topic ordered by delivery time.
+// If forUser is zero, up to maxResults messages scheduled by all users are returned.
+func (a *adapter) ScheduledMessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.ScheduledMessage, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxMessageResults
+	if forUser.IsZero() {
+		limit = a.maxResults
+	}
+	if opts != nil && opts.Limit > 0 && opts.Limit < limit {
+		limit = opts.Limit
+	}
+	from := forUser.String()
+	return a.db.scheduledGet(func(msg *ScheduledRecord) bool {
+		return msg.Topic == topic && (forUser.IsZero() || msg.From == from)
+	}, limit), nil
+}
+
+// ScheduledMessageGetDue returns up to limit messages due for delivery before the given time
+// which are not claimed for delivery at that time.
+func (a *adapter) ScheduledMessageGetDue(before time.Time, limit int) ([]t.ScheduledMessage, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.db.scheduledGet(func(msg *ScheduledRecord) bool {
+		return msg.DeliverAt.Before(before) && msg.ClaimedUntil.Before(before)
+	}, limit), nil
+}
+
+// ScheduledMessageClaim claims the scheduled message for delivery until the given time.
+func (a *adapter) ScheduledMessageClaim(id string, now, until time.Time) error {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return t.ErrMalformed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, msg := range a.db.Scheduled {
+		if msg.Uid() == uid && msg.ClaimedUntil.Before(now) {
+			msg.ClaimedUntil = until
+			return nil
+		}
+	}
+	return t.ErrNotFound
+}
+
+// ScheduledMessageDelete deletes a scheduled message. If forUser is not zero, only the message
+// scheduled by that user is deleted.
+func (a *adapter) ScheduledMessageDelete(topic string, forUser t.Uid, id string) error {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return t.ErrMalformed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	from := forUser.String()
+	if deleteWhere(&a.db.Scheduled, func(msg *ScheduledRecord) bool {
+		return msg.Uid() == uid && msg.Topic == topic && (forUser.IsZero() || msg.From == from)
+	}) == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// messageDeleteList deletes messages in the topic or marks them as deleted. If toDel is nil,
+// all messages are deleted.
+func (db *DB) messageDeleteList(topic string, toDel *t.DelMessage) {
+	// Drop records which refer to the deleted messages.
+	cascade := func(deleted func(*MessageRecord) bool) {
+		var ids []t.Uid
+		for _, msg := range db.Messages {
+			if deleted(msg) {
+				ids = append(ids, msg.Uid())
+			}
+		}
+		hit := func(tpc string, seqId int) bool {
+			return slices.ContainsFunc(db.Messages, func(msg *MessageRecord) bool {
+				return msg.Topic == tpc && msg.SeqId == seqId && deleted(msg)
+			})
+		}
+		deleteWhere(&db.FileLinks, func(link *FileLinkRecord) bool {
+			return !link.MsgId.IsZero() && slices.Contains(ids, link.MsgId)
+		})
+		deleteWhere(&db.Edits, func(rec *t.MessageRevision) bool { return hit(rec.Topic, rec.SeqId) })
+		deleteWhere(&db.Reactions, func(rec *ReactionRecord) bool { return hit(rec.Topic, rec.SeqId) })
+		deleteWhere(&db.Votes, func(rec *VoteRecord) bool { return hit(rec.Topic, rec.SeqId) })
+	}
+
+	if toDel == nil {
+		// Whole topic is being deleted, thus also deleting all messages.
+		deleteWhere(&db.DelLog, func(rec *DelLogRecord) bool { return rec.Topic == topic })
+		inTopic := func(msg *MessageRecord) bool { return msg.Topic == topic }
+		cascade(inTopic)
+		deleteWhere(&db.Messages, inTopic)
+		return
+	}
+
+	// Only some messages are being deleted.
+
+	delRanges := toDel.SeqIdRanges
+
+	if toDel.DeletedFor == "" {
+		// Hard-deleting messages requires updates to the messages table.
+
+		// Find the actual IDs still present in the database.
+		newerThan := toDel.GetNewerThan()
+		var seqIds []int
+		for _, msg := range db.Messages {
+			if msg.Topic == topic && msg.DeletedAt == nil &&
+				(len(delRanges) == 0 || inRanges(delRanges, msg.SeqId)) &&
+				(newerThan == nil || msg.CreatedAt.After(*newerThan)) {
+				seqIds = append(seqIds, msg.SeqId)
+			}
+		}
+
+		if len(seqIds) == 0 {
+			// Nothing to delete. No need to make a log entry. All done.
+			return
+		}
+
+		// Recalculate the actual ranges to delete.
+		sort.Ints(seqIds)
+		delRanges = t.SliceToRanges(seqIds)
+
+		deleted := func(msg *MessageRecord) bool {
+			return msg.Topic == topic && inRanges(delRanges, msg.SeqId)
+		}
+		cascade(deleted)
+
+		// Instead of deleting messages, clear all content.
+		now := t.TimeNow()
+		for _, msg := range db.Messages {
+			if deleted(msg) {
+				msg.DeletedAt = copyTime(&now)
+				msg.DelId = toDel.DelId
+				msg.From = ""
+				msg.Head = nil
+				msg.Content = nil
+				msg.SearchText = ""
+			}
+		}
+	}
+
+	// Now make log entries. Needed for both hard- and soft-deleting.
+	forUser := t.ParseUid(toDel.DeletedFor)
+	for _, rng := range delRanges {
+		if rng.Hi == 0 {
+			// Dellog must contain valid Low and *Hi*.
+			rng.Hi = rng.Low + 1
+		}
+		db.DelLog = append(db.DelLog, &DelLogRecord{
+			Topic:      topic,
+			DeletedFor: forUser,
+			DelId:      toDel.DelId,
+			Low:        rng.Low,
+			Hi:         rng.Hi,
+		})
+	}
+}
+
+// MessageDeleteList deletes messages in the given topic with seqIds from the list
+func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.db.messageDeleteList(topic, toDel)
+	return nil
+}
+
+// MessageGetDeleted returns a list of deleted message Ids.
+func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxResults
+	lower := 0
+	upper := 1<<31 - 1
+	if opts != nil {
+		if opts.Since > 0 {
+			lower = opts.Since
+		}
+		if opts.Before > 1 {
+			// DelRange is inclusive-exclusive.
+			upper = opts.Before - 1
+		}
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	// Fetch log of deletions
+	var log []*DelLogRecord
+	for _, rec := range a.db.DelLog {
+		if rec.Topic == topic && rec.DelId >= lower && rec.DelId <= upper &&
+			(rec.DeletedFor.IsZero() || rec.DeletedFor == forUser) {
+			log = append(log, rec)
+		}
+	}
+	sort.SliceStable(log, func(i, j int) bool {
+		return log[i].DelId < log[j].DelId
+	})
+	if len(log) > limit {
+		log = log[:limit]
+	}
+
+	var dmsgs []t.DelMessage
+	var dmsg t.DelMessage
+	for _, rec := range log {
+		if rec.DelId != dmsg.DelId {
+			if dmsg.DelId > 0 {
+				dmsgs = append(dmsgs, dmsg)
+			}
+			dmsg.DelId = rec.DelId
+			dmsg.Topic = rec.Topic
+			dmsg.DeletedFor = rec.DeletedFor.String()
+			dmsg.SeqIdRanges = nil
+		}
+		hi := rec.Hi
+		if hi <= rec.Low+1 {
+			hi = 0
+		}
+		dmsg.SeqIdRanges = append(dmsg.SeqIdRanges, t.Range{Low: rec.Low, Hi: hi})
+	}
+	if dmsg.DelId > 0 {
+		dmsgs = append(dmsgs, dmsg)
+	}
+
+	return dmsgs, nil
+}
+
+// MessageGetExpired returns up to limit sorted IDs of not yet deleted messages created before the given time.
+func (a *adapter) MessageGetExpired(topic string, before time.Time, limit int) ([]int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var seqIds []int
+	for _, msg := range a.db.Messages {
+		if msg.Topic == topic && msg.DeletedAt == nil && msg.CreatedAt.Before(before) {
+			seqIds = append(seqIds, msg.SeqId)
+		}
+	}
+	sort.Ints(seqIds)
+	if len(seqIds) > limit {
+		seqIds = seqIds[:limit]
+	}
+	return seqIds, nil
+}
+
+func deviceHasher(deviceID string) string {
+	// Generate custom key as [64-bit hash of device id] to ensure predictable
+	// length of the key
+	hasher := fnv.New64()
+	hasher.Write([]byte(deviceID))
+	return strconv.FormatUint(uint64(hasher.Sum64()), 16)
+}
+
+// Authenticated sessions.
+
+// Maximum length of the user agent stored in authsessions.
+const maxUserAgentLength = 255
+
+// AuthSessionCreate saves a new authenticated session.
+func (a *adapter) AuthSessionCreate(sess *t.AuthSession) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := sess.Uid()
+	if slices.ContainsFunc(a.db.AuthSessions, func(rec *t.AuthSession) bool { return rec.Uid() == id }) {
+		return t.ErrDuplicate
+	}
+
+	rec := *sess
+	rec.SetUid(id)
+	rec.UserAgent = common.TruncateString(sess.UserAgent, maxUserAgentLength)
+	a.db.AuthSessions = append(a.db.AuthSessions, &rec)
+	return nil
+}
+
+// AuthSessionUpdate updates the last seen time, expiration time and client details of an authenticated session.
+func (a *adapter) AuthSessionUpdate(sess *t.AuthSession) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := sess.Uid()
+	idx := slices.IndexFunc(a.db.AuthSessions, func(rec *t.AuthSession) bool { return rec.Uid() == id })
+	if idx < 0 {
+		return t.ErrNotFound
+	}
+	rec := a.db.AuthSessions[idx]
+	rec.UpdatedAt = sess.UpdatedAt
+	rec.Expires = sess.Expires
+	rec.DeviceId = sess.DeviceId
+	rec.Platform = sess.Platform
+	rec.UserAgent = common.TruncateString(sess.UserAgent, maxUserAgentLength)
+	rec.RemoteAddr = sess.RemoteAddr
+	return nil
+}
+
+// AuthSessionGet returns the authenticated session with the given ID or nil if not found.
+func (a *adapter) AuthSessionGet(id string) (*t.AuthSession, error) {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return nil, t.ErrMalformed
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	idx := slices.IndexFunc(a.db.AuthSessions, func(rec *t.AuthSession) bool { return rec.Uid() == uid })
+	if idx < 0 {
+		return nil, nil
+	}
+	sess := *a.db.AuthSessions[idx]
+	return &sess, nil
+}
+
+// AuthSessionGetAll returns authenticated sessions of the user which have not expired yet ordered by creation time.
+func (a *adapter) AuthSessionGetAll(uid t.Uid) ([]t.AuthSession, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := uid.String()
+	now := t.TimeNow()
+	var sessions []t.AuthSession
+	for _, sess := range a.db.AuthSessions {
+		if sess.User == user && sess.Expires.After(now) {
+			sessions = append(sessions, *sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+		return decodeUid(sessions[i].Uid()) < decodeUid(sessions[j].Uid())
+	})
+	return sessions, nil
+}
+
+// AuthSessionExpire deletes authenticated sessions of all users which expired before the given time.
+func (a *adapter) AuthSessionExpire(olderThan time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	deleteWhere(&a.db.AuthSessions, func(sess *t.AuthSession) bool {
+		return sess.Expires.Before(olderThan)
+	})
+	return nil
+}
+
+// AuthSessionDelete deletes authenticated sessions of the user with the given IDs.
+func (a *adapter) AuthSessionDelete(uid t.Uid, ids []string) error {
+	var sids []t.Uid
+	for _, id := range ids {
+		sid := t.ParseUid(id)
+		if sid.IsZero() {
+			return t.ErrMalformed
+		}
+		sids = append(sids, sid)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := uid.String()
+	deleteWhere(&a.db.AuthSessions, func(sess *t.AuthSession) bool {
+		return sess.User == user && slices.Contains(sids, sess.Uid())
+	})
+	return nil
+}
+
+// API keys.
+
+// APIKeyCreate saves a new API key.
+func (a *adapter) APIKeyCreate(key *t.APIKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := key.Uid()
+	if slices.ContainsFunc(a.db.APIKeys, func(rec *t.APIKey) bool { return rec.Uid() == id }) {
+		return t.ErrDuplicate
+	}
+	a.db.APIKeys = append(a.db.APIKeys, copyAPIKey(key))
+	return nil
+}
+
+// APIKeyUpdate updates an API key.
+func (a *adapter) APIKeyUpdate(key *t.APIKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := key.Uid()
+	idx := slices.IndexFunc(a.db.APIKeys, func(rec *t.APIKey) bool { return rec.Uid() == id })
+	if idx < 0 {
+		return t.ErrNotFound
+	}
+	rec := copyAPIKey(key)
+	rec.CreatedAt = a.db.APIKeys[idx].CreatedAt
+	a.db.APIKeys[idx] = rec
+	return nil
+}
+
+// APIKeyGet returns the API key with the given ID or nil if not found.
+func (a *adapter) APIKeyGet(id string) (*t.APIKey, error) {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return nil, t.ErrMalformed
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	idx := slices.IndexFunc(a.db.APIKeys, func(rec *t.APIKey) bool { return rec.Uid() == uid })
+	if idx < 0 {
+		return nil, nil
+	}
+	return copyAPIKey(a.db.APIKeys[idx]), nil
+}
+
+// APIKeyGetAll returns all API keys ordered by creation time.
+func (a *adapter) APIKeyGetAll() ([]t.APIKey, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var keys []t.APIKey
+	for _, key := range a.db.APIKeys {
+		keys = append(keys, *copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return decodeUid(keys[i].Uid()) < decodeUid(keys[j].Uid())
+	})
+	return keys, nil
+}
+
+// Audit log.
+
+// AuditAdd appends a record to the audit log.
+func (a *adapter) AuditAdd(rec *t.AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if slices.ContainsFunc(a.db.Audit, func(r *t.AuditRecord) bool { return r.Id == rec.Id }) {
+		return t.ErrDuplicate
+	}
+	dst := *rec
+	dst.Params = copyKVMap(rec.Params)
+	a.db.Audit = append(a.db.Audit, &dst)
+	return nil
+}
+
+// AuditGetAll returns records of the audit log matching the query, newest first.
+func (a *adapter) AuditGetAll(query *t.AuditQuery) ([]t.AuditRecord, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	limit := a.maxResults
+	match := func(rec *t.AuditRecord) bool { return true }
+	if query != nil {
+		user := query.User.String()
+		match = func(rec *t.AuditRecord) bool {
+			return (user == "" || rec.Actor == user || rec.User == user) &&
+				(query.Action == "" || rec.Action == query.Action) &&
+				(query.Since == nil || !rec.CreatedAt.Before(*query.Since)) &&
+				(query.Before == nil || rec.CreatedAt.Before(*query.Before))
+		}
+		if query.Limit > 0 && query.Limit < limit {
+			limit = query.Limit
+		}
+	}
+
+	var records []t.AuditRecord
+	for _, rec := range a.db.Audit {
+		if match(rec) {
+			dst := *rec
+			dst.Params = copyKVMap(rec.Params)
+			records = append(records, dst)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.After(records[j].CreatedAt)
+		}
+		return decodeUid(t.ParseUid(records[i].Id)) > decodeUid(t.ParseUid(records[j].Id))
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// AuditList returns up to limit records of the audit log ordered by ID, starting after the record with the given ID.
+func (a *adapter) AuditList(after string, limit int) ([]t.AuditRecord, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var afterId int64
+	if after != "" {
+		uid := t.ParseUid(after)
+		if uid.IsZero() {
+			return nil, t.ErrMalformed
+		}
+		afterId = decodeUid(uid)
+	}
+
+	var records []t.AuditRecord
+	for _, rec := range a.db.Audit {
+		if after == "" || decodeUid(t.ParseUid(rec.Id)) > afterId {
+			dst := *rec
+			dst.Params = copyKVMap(rec.Params)
+			records = append(records, dst)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return decodeUid(t.ParseUid(records[i].Id)) < decodeUid(t.ParseUid(records[j].Id))
+	})
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// Device management for push notifications.
+
+// DeviceUpsert creates or updates a device record.
+func (a *adapter) DeviceUpsert(uid t.Uid, def *t.DeviceDef) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Ensure uniqueness of the device ID: delete all records of the device ID
+	hash := deviceHasher(def.DeviceId)
+	deleteWhere(&a.db.Devices, func(dev *DeviceRecord) bool { return deviceHasher(dev.DeviceId) == hash })
+
+	a.db.Devices = append(a.db.Devices, &DeviceRecord{DeviceDef: *def, User: uid})
+	return nil
+}
+
+// DeviceGetAll returns all devices for a given set of users.
+func (a *adapter) DeviceGetAll(uids ...t.Uid) (map[t.Uid][]t.DeviceDef, int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := make(map[t.Uid][]t.DeviceDef)
+	count := 0
+	for _, dev := range a.db.Devices {
+		if slices.Contains(uids, dev.User) {
+			result[dev.User] = append(result[dev.User], dev.DeviceDef)
+			count++
+		}
+	}
+	return result, count, nil
+}
+
+// DeviceDelete deletes a device record (push token).
+func (a *adapter) DeviceDelete(uid t.Uid, deviceID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	hash := deviceHasher(deviceID)
+	if deleteWhere(&a.db.Devices, func(dev *DeviceRecord) bool {
+		return dev.User == uid && (deviceID == "" || deviceHasher(dev.DeviceId) == hash)
+	}) == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// Credential management
+
+// CredUpsert adds or updates a validation record. Returns true if inserted, false if updated.
+// 1. if credential is validated:
+// 1.1 Hard-delete unconfirmed equivalent record, if exists.
+// 1.2 Insert new. Report error if duplicate.
+// 2. if credential is not validated:
+// 2.1 Check if validated equivalent exist. If so, report an error.
+// 2.2 Soft-delete all unvalidated records of the same method.
+// 2.3 Undelete existing credential. Return if successful.
+// 2.4 Insert new credential record.
+func (a *adapter) CredUpsert(cred *t.Credential) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := t.TimeNow()
+
+	// Enforce uniqueness: if credential is confirmed, "method:value" must be unique.
+	// if credential is not yet confirmed, "userid:method:value" is unique.
+	synth := cred.Method + ":" + cred.Value
+
+	if !cred.Done {
+		// Check if this credential is already validated.
+		if slices.ContainsFunc(a.db.Creds, func(rec *CredRecord) bool { return rec.Synthetic == synth }) {
+			return false, t.ErrDuplicate
+		}
+		// We are going to insert new record.
+		synth = cred.User + ":" + synth
+
+		// Adding new unvalidated credential. Deactivate all unvalidated records of this user and method.
+		for _, rec := range a.db.Creds {
+			if rec.User == cred.User && rec.Method == cred.Method && !rec.Done {
+				rec.DeletedAt = copyTime(&now)
+			}
+		}
+		// Assume that the record exists and try to update it: undelete, update timestamp and response value.
+		if idx := slices.IndexFunc(a.db.Creds, func(rec *CredRecord) bool { return rec.Synthetic == synth }); idx >= 0 {
+			rec := a.db.Creds[idx]
+			rec.UpdatedAt = cred.UpdatedAt
+			rec.DeletedAt = nil
+			rec.Resp = cred.Resp
+			rec.Done = false
+			return false, nil
+		}
+	} else {
+		// Hard-deleting unconformed record if it exists.
+		unconfirmed := cred.User + ":" + synth
+		deleteWhere(&a.db.Creds, func(rec *CredRecord) bool { return rec.Synthetic == unconfirmed })
+	}
+
+	// Add new record.
+	if slices.ContainsFunc(a.db.Creds, func(rec *CredRecord) bool { return rec.Synthetic == synth }) {
+		return true, t.ErrDuplicate
+	}
+	a.db.Creds = append(a.db.Creds, &CredRecord{
+		Credential: t.Credential{
+			ObjHeader: t.ObjHeader{CreatedAt: cred.CreatedAt, UpdatedAt: cred.UpdatedAt},
+			User:      cred.User,
+			Method:    cred.Method,
+			Value:     cred.Value,
+			Resp:      cred.Resp,
+			Done:      cred.Done,
+		},
+		Synthetic: synth,
+	})
+	return true, nil
+}
+
+// credDel deletes given validation method or all methods of the given user.
+// 1. If user is being deleted, hard-delete all records (method == "")
+// 2. If one value is being deleted:
+// 2.1 Delete it if it's valiated or if there were no attempts at validation
+// (otherwise it could be used to circumvent the limit on validation attempts).
+// 2.2 In that case mark it as soft-deleted.
+func (db *DB) credDel(uid t.Uid, method, value string) error {
+	user := uid.String()
+	match := func(rec *CredRecord) bool {
+		return rec.User == user && (method == "" || rec.Method == method) &&
+			(method == "" || value == "" || rec.Value == value)
+	}
+
+	if method == "" {
+		// Case 1
+		if deleteWhere(&db.Creds, match) == 0 {
+			return t.ErrNotFound
+		}
+		return nil
+	}
+
+	// Case 2.1
+	if deleteWhere(&db.Creds, func(rec *CredRecord) bool {
+		return match(rec) && (rec.Done || rec.Retries == 0)
+	}) > 0 {
+		return nil
+	}
+
+	// Case 2.2
+	now := t.TimeNow()
+	for _, rec := range db.Creds {
+		if match(rec) {
+			rec.DeletedAt = copyTime(&now)
+		}
+	}
+	return t.ErrNotFound
+}
+
+// CredDel deletes either credentials of the given user. If method is blank all
+// credentials are removed. If value is blank all credentials of the given the
+// method are removed.
+func (a *adapter) CredDel(uid t.Uid, method, value string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.db.credDel(uid, method, value)
+}
+
+// CredConfirm marks given credential method as confirmed.
+func (a *adapter) CredConfirm(uid t.Uid, method string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := uid.String()
+	var found []*CredRecord
+	for _, rec := range a.db.Creds {
+		if rec.User == user && rec.Method == method && rec.DeletedAt == nil && !rec.Done {
+			synth := rec.Method + ":" + rec.Value
+			if slices.ContainsFunc(a.db.Creds, func(other *CredRecord) bool { return other.Synthetic == synth }) {
+				return t.ErrDuplicate
+			}
+			found = append(found, rec)
+		}
+	}
+	if len(found) == 0 {
+		return t.ErrNotFound
+	}
+
+	now := t.TimeNow()
+	for _, rec := range found {
+		rec.UpdatedAt = now
+		rec.Done = true
+		rec.Synthetic = rec.Method + ":" + rec.Value
+	}
+	return nil
+}
+
+// CredFail increments failure count of the given validation method.
+func (a *adapter) CredFail(uid t.Uid, method string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := uid.String()
+	now := t.TimeNow()
+	for _, rec := range a.db.Creds {
+		if rec.User == user && rec.Method == method && !rec.Done {
+			rec.UpdatedAt = now
+			rec.Retries++
+		}
+	}
+	return nil
+}
+
+// CredGetActive returns currently active unvalidated credential of the given user and method.
+func (a *adapter) CredGetActive(uid t.Uid, method string) (*t.Credential, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := uid.String()
+	for _, rec := range a.db.Creds {
+		if rec.User == user && rec.DeletedAt == nil && rec.Method == method && !rec.Done {
+			cred := rec.Credential
+			return &cred, nil
+		}
+	}
+	return nil, nil
+}
+
+// CredGetAll returns credential records for the given user and method, all or validated only.
+func (a *adapter) CredGetAll(uid t.Uid, method string, validatedOnly bool) ([]t.Credential, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user := uid.String()
+	var credentials []t.Credential
+	for _, rec := range a.db.Creds {
+		if rec.User == user && rec.DeletedAt == nil && (method == "" || rec.Method == method) &&
+			(!validatedOnly || rec.Done) {
+			credentials = append(credentials, rec.Credential)
+		}
+	}
+	return credentials, nil
+}
+
+// FileUploads
+
+// FileStartUpload initializes a file upload
+func (a *adapter) FileStartUpload(fd *t.FileDef) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := fd.Uid()
+	if slices.ContainsFunc(a.db.Files, func(rec *t.FileDef) bool { return rec.Uid() == id }) {
+		return t.ErrDuplicate
+	}
+	rec := *fd
+	a.db.Files = append(a.db.Files, &rec)
+	return nil
+}
+
+// FileFinishUpload marks file upload as completed, successfully or otherwise
+func (a *adapter) FileFinishUpload(fd *t.FileDef, success bool, size int64) (*t.FileDef, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := t.TimeNow()
+	id := fd.Uid()
+	if success {
+		for _, rec := range a.db.Files {
+			if rec.Uid() == id {
+				rec.UpdatedAt = now
+				rec.Status = t.UploadCompleted
+				rec.Size = size
+				rec.ETag = fd.ETag
+				rec.Location = fd.Location
+			}
+		}
+
+		fd.Status = t.UploadCompleted
+		fd.Size = size
+	} else {
+		// Deleting the record: there is no value in keeping it in the DB.
+		deleteWhere(&a.db.Files, func(rec *t.FileDef) bool { return rec.Uid() == id })
+
+		fd.Status = t.UploadFailed
+		fd.Size = 0
+	}
+	fd.UpdatedAt = now
+
+	return fd, nil
+}
+
+// FileGet fetches a record of a specific file
+func (a *adapter) FileGet(fid string) (*t.FileDef, error) {
+	id := t.ParseUid(fid)
+	if id.IsZero() {
+		return nil, t.ErrMalformed
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	idx := slices.IndexFunc(a.db.Files, func(rec *t.FileDef) bool { return rec.Uid() == id })
+	if idx < 0 {
+		return nil, nil
+	}
+	fd := *a.db.Files[idx]
+	return &fd, nil
+}
+
+// FileGetAll returns records of completed uploads by the given user ordered by ID.
+func (a *adapter) FileGetAll(user, after t.Uid, limit int) ([]t.FileDef, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	owner := user.String()
+	var files []t.FileDef
+	for _, fd := range a.db.Files {
+		if fd.User == owner && fd.Status == t.UploadCompleted &&
+			(after.IsZero() || decodeUid(fd.Uid()) > decodeUid(after)) {
+			files = append(files, *fd)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return decodeUid(files[i].Uid()) < decodeUid(files[j].Uid())
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+// FileDeleteUnused deletes records where UseCount is zero. If olderThan is non-zero, deletes
+// unused records with UpdatedAt before olderThan.
+// Returns array of FileDef.Location of deleted filerecords so actual files can be deleted too.
+func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var locations []string
+	count := 0
+	deleteWhere(&a.db.Files, func(fd *t.FileDef) bool {
+		if limit > 0 && count >= limit {
+			return false
+		}
+		if !olderThan.IsZero() && !fd.UpdatedAt.Before(olderThan) {
+			return false
+		}
+		id := fd.Uid()
+		if slices.ContainsFunc(a.db.FileLinks, func(link *FileLinkRecord) bool { return link.FileId == id }) {
+			return false
+		}
+		if fd.Location != "" {
+			locations = append(locations, fd.Location)
+		}
+		count++
+		return true
+	})
+	return locations, nil
+}
+
+// FileDelete deletes the record of the file unless the file is in use. Returns FileDef.Location
+// of the deleted record so the actual file can be deleted too.
+func (a *adapter) FileDelete(fid string) (string, error) {
+	id := t.ParseUid(fid)
+	if id.IsZero() {
+		return "", t.ErrMalformed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if slices.ContainsFunc(a.db.FileLinks, func(link *FileLinkRecord) bool { return link.FileId == id }) {
+		return "", nil
+	}
+	var location string
+	deleteWhere(&a.db.Files, func(fd *t.FileDef) bool {
+		if fd.Uid() != id {
+			return false
+		}
+		location = fd.Location
+		return true
+	})
+	return location, nil
+}
+
+// FileLinkAttachments connects given topic or message to the file record IDs from the list.
+func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []string) error {
+	if len(fids) == 0 || (topic == "" && msgId.IsZero() && userId.IsZero()) {
+		return t.ErrMalformed
+	}
+	now := t.TimeNow()
+
+	var link FileLinkRecord
+	if !msgId.IsZero() {
+		link.MsgId = msgId
+	} else if topic != "" {
+		link.Topic = topic
+		// Only one attachment per topic is permitted at this time.
+		fids = fids[0:1]
+	} else {
+		link.User = userId
+		// Only one attachment per user is permitted at this time.
+		fids = fids[0:1]
+	}
+
+	var ids []t.Uid
+	for _, fid := range fids {
+		id := t.ParseUid(fid)
+		if id.IsZero() {
+			return t.ErrMalformed
+		}
+		ids = append(ids, id)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range ids {
+		if !slices.ContainsFunc(a.db.Files, func(fd *t.FileDef) bool { return fd.Uid() == id }) {
+			// Mirror the foreign key constraint on the file ID.
+			return t.ErrNotFound
+		}
+	}
+
+	// Unlink earlier uploads on the same topic or user allowing them to be garbage-collected.
+	if msgId.IsZero() {
+		deleteWhere(&a.db.FileLinks, func(rec *FileLinkRecord) bool {
+			return rec.MsgId.IsZero() && rec.Topic == link.Topic && rec.User == link.User
+		})
+	}
+
+	for _, id := range ids {
+		rec := link
+		rec.CreatedAt = now
+		rec.FileId = id
+		a.db.FileLinks = append(a.db.FileLinks, &rec)
+	}
+	return nil
+}
+
+// FileGetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+func (a *adapter) FileGetMessageAttachments(topic string, seqId int) ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var fids []string
+	for _, msg := range a.db.Messages {
+		if msg.Topic != topic || msg.SeqId != seqId {
+			continue
+		}
+		msgId := msg.Uid()
+		for _, link := range a.db.FileLinks {
+			if link.MsgId == msgId {
+				fids = append(fids, link.FileId.String())
+			}
+		}
+	}
+	return fids, nil
+}
+
+// PCacheGet reads a persistet cache entry.
+func (a *adapter) PCacheGet(key string) (string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rec, ok := a.db.KVMeta[key]
+	if !ok {
+		return "", t.ErrNotFound
+	}
+	return rec.Value, nil
+}
+
+// PCacheUpsert creates or updates a persistent cache entry.
+func (a *adapter) PCacheUpsert(key string, value string, failOnDuplicate bool) error {
+	if strings.Contains(key, "%") {
+		// Do not allow % in keys: other adapters use LIKE queries.
+		return t.ErrMalformed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.db.KVMeta[key]; ok && failOnDuplicate {
+		return t.ErrDuplicate
+	}
+	a.db.KVMeta[key] = &KVRecord{CreatedAt: t.TimeNow(), Value: value}
+	return nil
+}
+
+// PCacheSwap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+// Returns false if the entry does not exist or has a different value.
+func (a *adapter) PCacheSwap(key, oldValue, newValue string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec, ok := a.db.KVMeta[key]
+	if !ok || rec.Value != oldValue {
+		return false, nil
+	}
+	a.db.KVMeta[key] = &KVRecord{CreatedAt: t.TimeNow(), Value: newValue}
+	return true, nil
+}
+
+// PCacheDelete deletes one persistent cache entry.
+func (a *adapter) PCacheDelete(key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.db.KVMeta, key)
+	return nil
+}
+
+// PCacheExpire expires old entries with the given key prefix.
+func (a *adapter) PCacheExpire(keyPrefix string, olderThan time.Time) error {
+	if keyPrefix == "" {
+		return t.ErrMalformed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	maps.DeleteFunc(a.db.KVMeta, func(key string, rec *KVRecord) bool {
+		return strings.HasPrefix(key, keyPrefix) && rec.CreatedAt.Before(olderThan)
+	})
+	return nil
+}
+
+// PCacheList returns up to limit persistent cache entries ordered by key, starting after the given key.
+func (a *adapter) PCacheList(after string, limit int) ([]adp.PCacheEntry, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var keys []string
+	for key := range a.db.KVMeta {
+		if key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	var entries []adp.PCacheEntry
+	for _, key := range keys {
+		rec := a.db.KVMeta[key]
+		entries = append(entries, adp.PCacheEntry{Key: key, Value: rec.Value, CreatedAt: rec.CreatedAt})
+	}
+	return entries, nil
+}
+
+// GetTestDB returns the in-memory database.
+func (a *adapter) GetTestDB() any {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.db
+}
+
+// GetTestAdapter returns an adapter object. Useful for running tests.
+func GetTestAdapter() *adapter {
+	return &adapter{}
+}
+
+func init() {
+	store.RegisterAdapter(&adapter{})
+}
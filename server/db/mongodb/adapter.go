@@ -9,6 +9,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"regexp"
 	"slices"
 	"sort"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 	"time"
 
 	"github.com/tinode/chat/server/auth"
+	adp "github.com/tinode/chat/server/db"
 	"github.com/tinode/chat/server/db/common"
 	"github.com/tinode/chat/server/store"
 	t "github.com/tinode/chat/server/store/types"
@@ -40,7 +42,7 @@ type adapter struct {
 }
 
 const (
-	adpVersion  = 116
+	adpVersion  = 129
 	adapterName = "mongodb"
 
 	defaultHost     = "localhost:27017"
@@ -400,6 +402,11 @@ func (a *adapter) CreateDb(reset bool) error {
 			Collection: "messages",
 			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"delid", 1}}},
 		},
+		// Compound index of replies to messages
+		{
+			Collection: "messages",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"parent", 1}}},
+		},
 		// Compound multi-index of soft-deleted messages: each message gets multiple compound index entries like
 		// 		 [topic, user1, delid1], [topic, user2, delid2],...
 		{
@@ -407,6 +414,71 @@ func (a *adapter) CreateDb(reset bool) error {
 			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"deletedfor.user", 1}, {"deletedfor.delid", 1}}},
 		},
 
+		// Previous versions of edited messages
+		// Compound index of 'topic - seqid' for selecting revisions of messages in a topic.
+		{
+			Collection: "msgedits",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+		},
+
+		// Reactions to messages
+		// Compound index of 'topic - seqid' for selecting reactions to messages in a topic.
+		{
+			Collection: "reactions",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+		},
+		// Index on 'user' for deleting reactions of a user.
+		{
+			Collection: "reactions",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"user": 1}},
+		},
+
+		// Votes in polls
+		// Compound index of 'topic - seqid' for counting votes in polls in a topic.
+		{
+			Collection: "pollvotes",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+		},
+		// Index on 'user' for deleting votes of a user.
+		{
+			Collection: "pollvotes",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"user": 1}},
+		},
+
+		// Messages scheduled for delivery at a later time
+		// Index on 'deliverat' for finding messages due for delivery.
+		{
+			Collection: "scheduled",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"deliverat": 1}},
+		},
+		// Compound index of 'topic - from' for listing messages scheduled by a user in a topic.
+		{
+			Collection: "scheduled",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"topic", 1}, {"from", 1}}},
+		},
+
+		// Logins on devices
+		// Index on 'user' for listing and deleting sessions of a user.
+		{
+			Collection: "authsessions",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"user": 1}},
+		},
+
+		// Audit log
+		// Indexes on 'createdat', 'actor - createdat' and 'user - createdat' for reading the log.
+		{
+			Collection: "auditlog",
+			IndexOpts:  mdb.IndexModel{Keys: b.M{"createdat": -1}},
+		},
+		{
+			Collection: "auditlog",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"actor", 1}, {"createdat", -1}}},
+		},
+		{
+			Collection: "auditlog",
+			IndexOpts:  mdb.IndexModel{Keys: b.D{{"user", 1}, {"createdat", -1}}},
+		},
+
 		// Log of deleted messages
 		// Compound index of 'topic - delid'
 		{
@@ -565,6 +637,154 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 116 {
+		// Perform database upgrade from version 116 to version 117.
+
+		// Index existing messages for search.
+		if err := a.messagesFillSearchText(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Create compound index on msgedits(topic,seqid) for revisions of edited messages.
+		if _, err = a.db.Collection("msgedits").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"seqid", 1}}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Create indexes on reactions(topic,seqid) and reactions(user) for reactions to messages.
+		if _, err = a.db.Collection("reactions").Indexes().CreateMany(a.ctx, []mdb.IndexModel{
+			{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+			{Keys: b.M{"user": 1}},
+		}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Create compound index on messages(topic,parent) for threaded replies.
+		if _, err = a.db.Collection("messages").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.D{{"topic", 1}, {"parent", 1}}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 120 {
+		// Just bump the version to keep in line with MySQL.
+		if err := bumpVersion(a, 121); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 121 {
+		// Create indexes on scheduled(deliverat) and scheduled(topic,from) for scheduled messages.
+		if _, err = a.db.Collection("scheduled").Indexes().CreateMany(a.ctx, []mdb.IndexModel{
+			{Keys: b.M{"deliverat": 1}},
+			{Keys: b.D{{"topic", 1}, {"from", 1}}},
+		}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 122); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 122 {
+		// Just bump the version to keep in line with MySQL.
+		if err := bumpVersion(a, 123); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 123 {
+		// Create indexes on pollvotes(topic,seqid) and pollvotes(user) for votes in polls.
+		if _, err = a.db.Collection("pollvotes").Indexes().CreateMany(a.ctx, []mdb.IndexModel{
+			{Keys: b.D{{"topic", 1}, {"seqid", 1}}},
+			{Keys: b.M{"user": 1}},
+		}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 124); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 124 {
+		// Just bump the version to keep in line with MySQL.
+		if err := bumpVersion(a, 125); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 125 {
+		// Create index on authsessions(user) for authenticated sessions.
+		if _, err = a.db.Collection("authsessions").Indexes().CreateOne(a.ctx,
+			mdb.IndexModel{Keys: b.M{"user": 1}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 126); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 126 {
+		// Collection of API keys is created on first insert, no indexes needed.
+		if err := bumpVersion(a, 127); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 127 {
+		// Create indexes of the audit log.
+		if _, err = a.db.Collection("auditlog").Indexes().CreateMany(a.ctx, []mdb.IndexModel{
+			{Keys: b.M{"createdat": -1}},
+			{Keys: b.D{{"actor", 1}, {"createdat", -1}}},
+			{Keys: b.D{{"user", 1}, {"createdat", -1}}},
+		}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 128); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 128 {
+		// Time when the secret was changed, for expiring old passwords. The time of existing
+		// secrets is unknown, the upgrade time is used instead.
+		if _, err = a.db.Collection("auth").UpdateMany(a.ctx,
+			b.M{"updatedat": b.M{"$exists": false}},
+			b.M{"$set": b.M{"updatedat": t.TimeNow()}}); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 129); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -572,6 +792,30 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// messagesFillSearchText populates searchtext field of messages saved before the field was added.
+func (a *adapter) messagesFillSearchText() error {
+	filter := b.M{"delid": b.M{"$exists": false}, "searchtext": b.M{"$exists": false}}
+	cur, err := a.db.Collection("messages").Find(a.ctx, filter,
+		mdbopts.Find().SetProjection(b.M{"_id": 1, "content": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(a.ctx)
+
+	for cur.Next(a.ctx) {
+		var msg t.Message
+		if err = cur.Decode(&msg); err != nil {
+			return err
+		}
+		if _, err = a.db.Collection("messages").UpdateOne(a.ctx, b.M{"_id": msg.Id},
+			b.M{"$set": b.M{"searchtext": common.MessageSearchText(unmarshalBsonD(msg.Content))}}); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
 // Create system topic 'sys'.
 func createSystemTopic(a *adapter) error {
 	now := t.TimeNow()
@@ -644,6 +888,35 @@ func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
 	return users, nil
 }
 
+// UserList returns up to limit users ordered by ID, starting after the user with the given ID.
+// Suspended and soft-deleted users are included.
+func (a *adapter) UserList(after t.Uid, limit int) ([]t.User, error) {
+	filter := b.M{}
+	if !after.IsZero() {
+		filter["_id"] = b.M{"$gt": after.String()}
+	}
+	findOpts := mdbopts.Find().SetSort(b.D{{"_id", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("users").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var users []t.User
+	for cur.Next(a.ctx) {
+		var user t.User
+		if err := cur.Decode(&user); err != nil {
+			return nil, err
+		}
+		user.Public = unmarshalBsonD(user.Public)
+		user.Trusted = unmarshalBsonD(user.Trusted)
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
 // UserDelete deletes specified user: wipes completely (hard-delete) or marks as deleted.
 func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	ownFilter := b.M{"owner": uid.String()}
@@ -687,6 +960,24 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 				return err
 			}
 
+			// Delete user's reactions to messages in all topics.
+			_, err = a.db.Collection("reactions").DeleteMany(sc, b.M{"user": forUser})
+			if err != nil {
+				return err
+			}
+
+			// Delete user's votes in polls in all topics.
+			_, err = a.db.Collection("pollvotes").DeleteMany(sc, b.M{"user": forUser})
+			if err != nil {
+				return err
+			}
+
+			// Delete messages scheduled by the user in all topics.
+			_, err = a.db.Collection("scheduled").DeleteMany(sc, b.M{"from": forUser})
+			if err != nil {
+				return err
+			}
+
 			// Can't delete user's messages in all topics because we cannot notify topics of such deletion.
 			// Just leave the messages there marked as sent by "not found" user.
 
@@ -724,6 +1015,30 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 					return err
 				}
 
+				// Delete previous versions of edited messages.
+				_, err = a.db.Collection("msgedits").DeleteMany(sc, ownTopicsFilter)
+				if err != nil {
+					return err
+				}
+
+				// Delete reactions to messages.
+				_, err = a.db.Collection("reactions").DeleteMany(sc, ownTopicsFilter)
+				if err != nil {
+					return err
+				}
+
+				// Delete votes in polls.
+				_, err = a.db.Collection("pollvotes").DeleteMany(sc, ownTopicsFilter)
+				if err != nil {
+					return err
+				}
+
+				// Delete scheduled messages.
+				_, err = a.db.Collection("scheduled").DeleteMany(sc, ownTopicsFilter)
+				if err != nil {
+					return err
+				}
+
 				// Delete subscriptions for all users where the user is the owner of the topic.
 				_, err = a.db.Collection("subscriptions").DeleteMany(sc, ownTopicsFilter)
 				if err != nil {
@@ -743,6 +1058,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 				return err
 			}
 
+			// Delete authenticated sessions.
+			if _, err = a.db.Collection("authsessions").DeleteMany(sc, b.M{"user": forUser}); err != nil {
+				return err
+			}
+
 			// Delete credentials.
 			if err = a.credDel(sc, uid, "", ""); err != nil && err != t.ErrNotFound {
 				return err
@@ -1312,6 +1632,23 @@ func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte,
 	return t.ParseUid(record.UserId), record.AuthLvl, record.Secret, record.Expires, nil
 }
 
+// AuthGetUpdated returns the time when the secret of the authentication record was last changed.
+func (a *adapter) AuthGetUpdated(uid t.Uid, scheme string) (time.Time, error) {
+	var record struct {
+		UpdatedAt time.Time
+	}
+
+	filter := b.M{"userid": uid.String(), "scheme": scheme}
+	findOpts := mdbopts.FindOne().SetProjection(b.M{"updatedat": 1})
+	if err := a.db.Collection("auth").FindOne(a.ctx, filter, findOpts).Decode(&record); err != nil {
+		if err == mdb.ErrNoDocuments {
+			err = t.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return record.UpdatedAt, nil
+}
+
 // AuthGetRecord returns authentication record given user ID and method.
 func (a *adapter) AuthGetRecord(uid t.Uid, scheme string) (string, auth.Level, []byte, time.Time, error) {
 	var record struct {
@@ -1338,6 +1675,39 @@ func (a *adapter) AuthGetRecord(uid t.Uid, scheme string) (string, auth.Level, [
 	return record.Id, record.AuthLvl, record.Secret, record.Expires, nil
 }
 
+// AuthGetAllRecords returns all authentication records of the given user ordered by scheme.
+func (a *adapter) AuthGetAllRecords(uid t.Uid) ([]adp.AuthRecord, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"scheme", 1}})
+	cur, err := a.db.Collection("auth").Find(a.ctx, b.M{"userid": uid.String()}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var records []adp.AuthRecord
+	for cur.Next(a.ctx) {
+		var record struct {
+			Id      string `bson:"_id"`
+			Scheme  string
+			AuthLvl auth.Level
+			Secret  []byte
+			Expires time.Time
+		}
+		if err := cur.Decode(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, adp.AuthRecord{
+			Scheme:  record.Scheme,
+			Unique:  record.Id,
+			AuthLvl: record.AuthLvl,
+			Secret:  record.Secret,
+			Expires: record.Expires,
+		})
+	}
+
+	return records, cur.Err()
+}
+
 // AuthAddRecord creates new authentication record
 func (a *adapter) AuthAddRecord(uid t.Uid, scheme, unique string, authLvl auth.Level, secret []byte, expires time.Time) error {
 	authRecord := b.M{
@@ -1346,7 +1716,9 @@ func (a *adapter) AuthAddRecord(uid t.Uid, scheme, unique string, authLvl auth.L
 		"scheme":  scheme,
 		"authlvl": authLvl,
 		"secret":  secret,
-		"expires": expires}
+		"expires": expires,
+
+		"updatedat": t.TimeNow()}
 	if _, err := a.db.Collection("auth").InsertOne(a.ctx, authRecord); err != nil {
 		if isDuplicateErr(err) {
 			return t.ErrDuplicate
@@ -1400,6 +1772,7 @@ func (a *adapter) AuthUpdRecord(uid t.Uid, scheme, unique string,
 		}
 		if len(secret) > 0 {
 			upd["secret"] = secret
+			upd["updatedat"] = t.TimeNow()
 		}
 		if !expires.IsZero() {
 			upd["expires"] = expires
@@ -1518,6 +1891,31 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, nil
 }
 
+// TopicList returns up to limit topics ordered by name, starting after the topic with the given name.
+// Deleted topics are included.
+func (a *adapter) TopicList(after string, limit int) ([]t.Topic, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"_id", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("topics").Find(a.ctx, b.M{"_id": b.M{"$gt": after}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var topics []t.Topic
+	for cur.Next(a.ctx) {
+		var tt t.Topic
+		if err := cur.Decode(&tt); err != nil {
+			return nil, err
+		}
+		tt.Public = unmarshalBsonD(tt.Public)
+		tt.Trusted = unmarshalBsonD(tt.Trusted)
+
+		topics = append(topics, tt)
+	}
+
+	return topics, cur.Err()
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public & Trusted values.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
@@ -1941,6 +2339,9 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 		if err = a.MessageDeleteList(topic, nil); err != nil {
 			return err
 		}
+		if _, err = a.db.Collection("scheduled").DeleteMany(a.ctx, b.M{"topic": topic}); err != nil {
+			return err
+		}
 		_, err = a.db.Collection("topics").DeleteOne(a.ctx, filter)
 	} else {
 		_, err = a.db.Collection("topics").UpdateOne(a.ctx, filter, b.M{"$set": b.M{
@@ -1989,6 +2390,24 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return a.topicUpdate(topic, map[string]any{"owner": newOwner.String()})
 }
 
+// TopicsWithMsgTTL returns names and message TTLs of topics which have message TTL set.
+func (a *adapter) TopicsWithMsgTTL() ([]t.Topic, error) {
+	findOpts := mdbopts.Find().SetProjection(b.M{"_id": 1, "msgttl": 1})
+	cur, err := a.db.Collection("topics").Find(a.ctx,
+		b.M{"msgttl": b.M{"$gt": 0}, "state": b.M{"$ne": t.StateDeleted}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var topics []t.Topic
+	if err = cur.All(a.ctx, &topics); err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
 func (a *adapter) topicUpdate(topic string, update map[string]any) error {
 	_, err := a.db.Collection("topics").UpdateOne(a.ctx,
 		b.M{"_id": topic},
@@ -2464,14 +2883,34 @@ func (a *adapter) FindOne(tag string) (string, error) {
 
 // Messages
 
+// messageRecord is a message as stored in the database: the message itself and its
+// plain text used for search.
+type messageRecord struct {
+	t.Message  `bson:",inline"`
+	SearchText string `bson:"searchtext,omitempty"`
+}
+
 // MessageSave saves message to database
 func (a *adapter) MessageSave(msg *t.Message) error {
-	_, err := a.db.Collection("messages").InsertOne(a.ctx, msg)
+	_, err := a.db.Collection("messages").InsertOne(a.ctx, &messageRecord{
+		Message:    *msg,
+		SearchText: common.MessageSearchText(msg.Content),
+	})
 	return err
 }
 
 // MessageGetAll returns messages matching the query.
 func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, "", opts)
+}
+
+// MessageSearch returns messages matching the query which contain the search string.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, search, opts)
+}
+
+// messagesGet returns messages matching the query, optionally constrained by the search string.
+func (a *adapter) messagesGet(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
 	var limit = a.maxMessageResults
 	var lower, upper int
 	requester := forUser.String()
@@ -2497,6 +2936,12 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	} else {
 		filter["seqid"] = b.M{"$gte": lower, "$lt": upper}
 	}
+	if opts != nil && opts.Thread > 0 {
+		filter["parent"] = opts.Thread
+	}
+	if search != "" {
+		filter["searchtext"] = b.M{"$regex": regexp.QuoteMeta(strings.ToLower(search))}
+	}
 	findOpts := mdbopts.Find().SetSort(b.D{{"topic", -1}, {"seqid", -1}})
 	findOpts.SetLimit(int64(limit))
 
@@ -2519,12 +2964,478 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, nil
 }
 
-func (a *adapter) messagesHardDelete(topic string) error {
-	var err error
-
-	// TODO: handle file uploads
-	filter := b.M{"topic": topic}
-	if _, err = a.db.Collection("dellog").DeleteMany(a.ctx, filter); err != nil {
+// MessageEdit saves the current version of the message as a revision and replaces message head and content.
+func (a *adapter) MessageEdit(msg *t.Message, rev *t.MessageRevision) error {
+	res, err := a.db.Collection("messages").UpdateOne(a.ctx,
+		b.M{"topic": msg.Topic, "seqid": msg.SeqId, "delid": b.M{"$exists": false}},
+		b.M{"$set": b.M{
+			"updatedat":  msg.UpdatedAt,
+			"head":       msg.Head,
+			"content":    msg.Content,
+			"searchtext": common.MessageSearchText(msg.Content),
+		}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return t.ErrNotFound
+	}
+
+	_, err = a.db.Collection("msgedits").InsertOne(a.ctx, rev)
+	return err
+}
+
+// MessageEditSave saves a previous version of the message without changing the message.
+func (a *adapter) MessageEditSave(rev *t.MessageRevision) error {
+	count, err := a.db.Collection("messages").CountDocuments(a.ctx,
+		b.M{"topic": rev.Topic, "seqid": rev.SeqId, "delid": b.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return t.ErrNotFound
+	}
+
+	_, err = a.db.Collection("msgedits").InsertOne(a.ctx, rev)
+	return err
+}
+
+// MessageGetEdits returns previous versions of messages matching the query.
+func (a *adapter) MessageGetEdits(topic string, opts *t.QueryOpt) ([]t.MessageRevision, error) {
+	var limit = a.maxResults
+	filter := b.M{"topic": topic}
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			rangeToFilter(opts.IdRanges, filter)
+		} else if opts.Before > 0 {
+			filter["seqid"] = b.M{"$gte": opts.Since, "$lt": opts.Before}
+		} else {
+			filter["seqid"] = b.M{"$gte": opts.Since}
+		}
+
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+	findOpts := mdbopts.Find().SetSort(b.D{{"seqid", -1}, {"rev", -1}}).SetLimit(int64(limit))
+
+	cur, err := a.db.Collection("msgedits").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var revs []t.MessageRevision
+	for cur.Next(a.ctx) {
+		var rev t.MessageRevision
+		if err = cur.Decode(&rev); err != nil {
+			return nil, err
+		}
+		rev.Content = unmarshalBsonD(rev.Content)
+		revs = append(revs, rev)
+	}
+
+	return revs, cur.Err()
+}
+
+// MessageReact adds or replaces user's reaction to a message. Empty reaction removes it.
+func (a *adapter) MessageReact(topic string, seqId int, user t.Uid, reaction string) error {
+	id := topic + ":" + strconv.Itoa(seqId) + ":" + user.String()
+	if reaction == "" {
+		_, err := a.db.Collection("reactions").DeleteOne(a.ctx, b.M{"_id": id})
+		return err
+	}
+
+	count, err := a.db.Collection("messages").CountDocuments(a.ctx,
+		b.M{"topic": topic, "seqid": seqId, "delid": b.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return t.ErrNotFound
+	}
+
+	_, err = a.db.Collection("reactions").ReplaceOne(a.ctx, b.M{"_id": id},
+		b.M{
+			"_id":       id,
+			"createdat": t.TimeNow(),
+			"topic":     topic,
+			"seqid":     seqId,
+			"user":      user.String(),
+			"value":     reaction,
+		}, mdbopts.Replace().SetUpsert(true))
+	return err
+}
+
+// MessageGetReactions returns aggregated reactions to messages matching the query.
+func (a *adapter) MessageGetReactions(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageReaction, error) {
+	filter := b.M{"topic": topic}
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			rangeToFilter(opts.IdRanges, filter)
+		} else if opts.Before > 0 {
+			filter["seqid"] = b.M{"$gte": opts.Since, "$lt": opts.Before}
+		} else if opts.Since > 0 {
+			filter["seqid"] = b.M{"$gte": opts.Since}
+		}
+	}
+
+	pipeline := b.A{
+		b.M{"$match": filter},
+		// GROUP BY seqid, value.
+		b.M{"$group": b.M{
+			"_id":   b.M{"seqid": "$seqid", "value": "$value"},
+			"count": b.M{"$sum": 1},
+			"mine":  b.M{"$max": b.M{"$eq": b.A{"$user", forUser.String()}}},
+		}},
+		b.M{"$sort": b.D{{"_id.seqid", 1}, {"count", -1}, {"_id.value", 1}}},
+	}
+	cur, err := a.db.Collection("reactions").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var reacts []t.MessageReaction
+	for cur.Next(a.ctx) {
+		var react struct {
+			Id struct {
+				SeqId int    `bson:"seqid"`
+				Value string `bson:"value"`
+			} `bson:"_id"`
+			Count int  `bson:"count"`
+			Mine  bool `bson:"mine"`
+		}
+		if err = cur.Decode(&react); err != nil {
+			return nil, err
+		}
+		reacts = append(reacts, t.MessageReaction{
+			SeqId: react.Id.SeqId,
+			Value: react.Id.Value,
+			Count: react.Count,
+			Mine:  react.Mine,
+		})
+	}
+
+	return reacts, cur.Err()
+}
+
+// MessageGetUserReactions returns up to 'limit' reactions of individual users to messages in the topic
+// ordered by SeqId and user, starting after the reaction 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserReactions(topic string, after *adp.UserReaction, limit int) ([]adp.UserReaction, error) {
+	filter := b.M{"topic": topic}
+	if after != nil {
+		filter["$or"] = b.A{
+			b.M{"seqid": b.M{"$gt": after.SeqId}},
+			b.M{"seqid": after.SeqId, "user": b.M{"$gt": after.User}},
+		}
+	}
+	findOpts := mdbopts.Find().SetSort(b.D{{"seqid", 1}, {"user", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("reactions").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var reacts []adp.UserReaction
+	for cur.Next(a.ctx) {
+		var react struct {
+			SeqId int    `bson:"seqid"`
+			User  string `bson:"user"`
+			Value string `bson:"value"`
+		}
+		if err = cur.Decode(&react); err != nil {
+			return nil, err
+		}
+		reacts = append(reacts, adp.UserReaction{SeqId: react.SeqId, User: react.User, Value: react.Value})
+	}
+
+	return reacts, cur.Err()
+}
+
+// MessageVote replaces user's vote in a poll with the given options. Empty list of options removes the vote.
+// The vote of a user is stored as a single document, so it's replaced atomically.
+func (a *adapter) MessageVote(topic string, seqId int, user t.Uid, opts []int) error {
+	id := topic + ":" + strconv.Itoa(seqId) + ":" + user.String()
+	if len(opts) == 0 {
+		_, err := a.db.Collection("pollvotes").DeleteOne(a.ctx, b.M{"_id": id})
+		return err
+	}
+
+	count, err := a.db.Collection("messages").CountDocuments(a.ctx,
+		b.M{"topic": topic, "seqid": seqId, "delid": b.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return t.ErrNotFound
+	}
+
+	_, err = a.db.Collection("pollvotes").ReplaceOne(a.ctx, b.M{"_id": id},
+		b.M{
+			"_id":       id,
+			"createdat": t.TimeNow(),
+			"topic":     topic,
+			"seqid":     seqId,
+			"user":      user.String(),
+			"opts":      opts,
+		}, mdbopts.Replace().SetUpsert(true))
+	return err
+}
+
+// MessageGetVotes returns counts of votes for poll options in messages matching the query.
+func (a *adapter) MessageGetVotes(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageVote, error) {
+	filter := b.M{"topic": topic}
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			rangeToFilter(opts.IdRanges, filter)
+		} else if opts.Before > 0 {
+			filter["seqid"] = b.M{"$gte": opts.Since, "$lt": opts.Before}
+		} else if opts.Since > 0 {
+			filter["seqid"] = b.M{"$gte": opts.Since}
+		}
+	}
+
+	pipeline := b.A{
+		b.M{"$match": filter},
+		b.M{"$unwind": "$opts"},
+		// GROUP BY seqid, opt.
+		b.M{"$group": b.M{
+			"_id":   b.M{"seqid": "$seqid", "opt": "$opts"},
+			"count": b.M{"$sum": 1},
+			"mine":  b.M{"$max": b.M{"$eq": b.A{"$user", forUser.String()}}},
+		}},
+		b.M{"$sort": b.D{{"_id.seqid", 1}, {"_id.opt", 1}}},
+	}
+	cur, err := a.db.Collection("pollvotes").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var votes []t.MessageVote
+	for cur.Next(a.ctx) {
+		var vote struct {
+			Id struct {
+				SeqId  int `bson:"seqid"`
+				Option int `bson:"opt"`
+			} `bson:"_id"`
+			Count int  `bson:"count"`
+			Mine  bool `bson:"mine"`
+		}
+		if err = cur.Decode(&vote); err != nil {
+			return nil, err
+		}
+		votes = append(votes, t.MessageVote{
+			SeqId:  vote.Id.SeqId,
+			Option: vote.Id.Option,
+			Count:  vote.Count,
+			Mine:   vote.Mine,
+		})
+	}
+
+	return votes, cur.Err()
+}
+
+// MessageGetUserVotes returns up to 'limit' poll options chosen by individual users in messages of the topic
+// ordered by SeqId, user and option, starting after the vote 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserVotes(topic string, after *adp.UserVote, limit int) ([]adp.UserVote, error) {
+	filter := b.M{"topic": topic}
+	if after != nil {
+		// The document of the 'after' vote is included: it may have more options after the given one.
+		filter["$or"] = b.A{
+			b.M{"seqid": b.M{"$gt": after.SeqId}},
+			b.M{"seqid": after.SeqId, "user": b.M{"$gte": after.User}},
+		}
+	}
+	// Every document except the one of the 'after' vote has at least one option, so limit+1 documents
+	// are enough to fill the page.
+	findOpts := mdbopts.Find().SetSort(b.D{{"seqid", 1}, {"user", 1}}).SetLimit(int64(limit) + 1)
+	cur, err := a.db.Collection("pollvotes").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var votes []adp.UserVote
+	for len(votes) < limit && cur.Next(a.ctx) {
+		// All options chosen by the user are stored in one document.
+		var vote struct {
+			SeqId int    `bson:"seqid"`
+			User  string `bson:"user"`
+			Opts  []int  `bson:"opts"`
+		}
+		if err = cur.Decode(&vote); err != nil {
+			return nil, err
+		}
+		sort.Ints(vote.Opts)
+		for _, opt := range vote.Opts {
+			if after != nil && vote.SeqId == after.SeqId && vote.User == after.User && opt <= after.Option {
+				continue
+			}
+			votes = append(votes, adp.UserVote{SeqId: vote.SeqId, User: vote.User, Option: opt})
+		}
+	}
+	if len(votes) > limit {
+		votes = votes[:limit]
+	}
+
+	return votes, cur.Err()
+}
+
+// MessageGetThreads returns reply counts and timestamps of the latest replies to messages matching the query.
+func (a *adapter) MessageGetThreads(topic string, opts *t.QueryOpt) ([]t.MessageThread, error) {
+	filter := b.M{
+		"topic":  topic,
+		"delid":  b.M{"$exists": false},
+		"parent": b.M{"$gt": 0},
+	}
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			parentFilter := b.A{}
+			for _, rng := range opts.IdRanges {
+				if rng.Hi == 0 {
+					parentFilter = append(parentFilter, b.M{"parent": rng.Low})
+				} else {
+					parentFilter = append(parentFilter, b.M{"parent": b.M{"$gte": rng.Low, "$lt": rng.Hi}})
+				}
+			}
+			filter["$or"] = parentFilter
+		} else if opts.Before > 0 {
+			filter["parent"] = b.M{"$gte": max(opts.Since, 1), "$lt": opts.Before}
+		} else if opts.Since > 0 {
+			filter["parent"] = b.M{"$gte": opts.Since}
+		}
+	}
+
+	pipeline := b.A{
+		b.M{"$match": filter},
+		// GROUP BY parent.
+		b.M{"$group": b.M{
+			"_id":       "$parent",
+			"replies":   b.M{"$sum": 1},
+			"lastreply": b.M{"$max": "$createdat"},
+		}},
+		b.M{"$sort": b.M{"_id": 1}},
+	}
+	cur, err := a.db.Collection("messages").Aggregate(a.ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var threads []t.MessageThread
+	for cur.Next(a.ctx) {
+		var thread struct {
+			SeqId     int       `bson:"_id"`
+			Replies   int       `bson:"replies"`
+			LastReply time.Time `bson:"lastreply"`
+		}
+		if err = cur.Decode(&thread); err != nil {
+			return nil, err
+		}
+		threads = append(threads, t.MessageThread{
+			SeqId:       thread.SeqId,
+			Replies:     thread.Replies,
+			LastReplyAt: thread.LastReply,
+		})
+	}
+
+	return threads, cur.Err()
+}
+
+// ScheduledMessageSave saves a message for delivery at a later time.
+func (a *adapter) ScheduledMessageSave(msg *t.ScheduledMessage) error {
+	_, err := a.db.Collection("scheduled").InsertOne(a.ctx, msg)
+	return err
+}
+
+// ScheduledMessageGetAll returns messages scheduled by the user in the topic ordered by delivery time.
+// If forUser is zero, up to maxResults messages scheduled by all users are returned.
+func (a *adapter) ScheduledMessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.ScheduledMessage, error) {
+	limit := a.maxMessageResults
+	if forUser.IsZero() {
+		limit = a.maxResults
+	}
+	if opts != nil && opts.Limit > 0 && opts.Limit < limit {
+		limit = opts.Limit
+	}
+	filter := b.M{"topic": topic}
+	if !forUser.IsZero() {
+		filter["from"] = forUser.String()
+	}
+	return a.scheduledGet(filter, limit)
+}
+
+// ScheduledMessageGetDue returns up to 'limit' messages due for delivery before the given time
+// which are not claimed for delivery at that time.
+func (a *adapter) ScheduledMessageGetDue(before time.Time, limit int) ([]t.ScheduledMessage, error) {
+	return a.scheduledGet(b.M{
+		"deliverat": b.M{"$lt": before},
+		// Matches messages which were never claimed too.
+		"claimeduntil": b.M{"$not": b.M{"$gte": before}},
+	}, limit)
+}
+
+// ScheduledMessageClaim claims the scheduled message for delivery until the given time.
+func (a *adapter) ScheduledMessageClaim(id string, now, until time.Time) error {
+	res, err := a.db.Collection("scheduled").UpdateOne(a.ctx,
+		b.M{"_id": id, "claimeduntil": b.M{"$not": b.M{"$gte": now}}},
+		b.M{"$set": b.M{"claimeduntil": until}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// scheduledGet fetches scheduled messages matching the filter ordered by delivery time.
+func (a *adapter) scheduledGet(filter b.M, limit int) ([]t.ScheduledMessage, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"deliverat", 1}, {"_id", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("scheduled").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var msgs []t.ScheduledMessage
+	for cur.Next(a.ctx) {
+		var msg t.ScheduledMessage
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		msg.Content = unmarshalBsonD(msg.Content)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// ScheduledMessageDelete deletes a scheduled message. If forUser is not zero, the message must be
+// scheduled by that user.
+func (a *adapter) ScheduledMessageDelete(topic string, forUser t.Uid, id string) error {
+	filter := b.M{"_id": id, "topic": topic}
+	if !forUser.IsZero() {
+		filter["from"] = forUser.String()
+	}
+	res, err := a.db.Collection("scheduled").DeleteOne(a.ctx, filter)
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+func (a *adapter) messagesHardDelete(topic string) error {
+	var err error
+
+	// TODO: handle file uploads
+	filter := b.M{"topic": topic}
+	if _, err = a.db.Collection("dellog").DeleteMany(a.ctx, filter); err != nil {
 		return err
 	}
 
@@ -2536,6 +3447,18 @@ func (a *adapter) messagesHardDelete(topic string) error {
 		return err
 	}
 
+	if _, err = a.db.Collection("msgedits").DeleteMany(a.ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err = a.db.Collection("reactions").DeleteMany(a.ctx, filter); err != nil {
+		return err
+	}
+
+	if _, err = a.db.Collection("pollvotes").DeleteMany(a.ctx, filter); err != nil {
+		return err
+	}
+
 	return err
 }
 
@@ -2628,6 +3551,18 @@ func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) error {
 		if err = a.decFileUseCounter(a.ctx, "messages", filter); err != nil {
 			return err
 		}
+		// Delete previous versions of edited messages.
+		if _, err = a.db.Collection("msgedits").DeleteMany(a.ctx, filter); err != nil {
+			return err
+		}
+		// Delete reactions to messages.
+		if _, err = a.db.Collection("reactions").DeleteMany(a.ctx, filter); err != nil {
+			return err
+		}
+		// Delete votes in polls.
+		if _, err = a.db.Collection("pollvotes").DeleteMany(a.ctx, filter); err != nil {
+			return err
+		}
 		// Hard-delete individual messages. Message is not deleted but all fields with content
 		// are replaced with nulls.
 		_, err = a.db.Collection("messages").UpdateMany(a.ctx, filter, b.M{"$set": b.M{
@@ -2636,6 +3571,7 @@ func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) error {
 			"from":        "",
 			"head":        nil,
 			"content":     nil,
+			"searchtext":  nil,
 			"attachments": nil}})
 	} else {
 		// Soft-deleting: adding DelId to DeletedFor
@@ -2700,6 +3636,221 @@ func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOp
 	return dmsgs, nil
 }
 
+// MessageGetExpired returns up to limit sorted IDs of not yet deleted messages created before the given time.
+func (a *adapter) MessageGetExpired(topic string, before time.Time, limit int) ([]int, error) {
+	findOpts := mdbopts.Find().SetProjection(b.M{"seqid": 1}).SetSort(b.D{{"seqid", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("messages").Find(a.ctx, b.M{
+		"topic":     topic,
+		"createdat": b.M{"$lt": before},
+		// Skip already hard-deleted messages.
+		"delid": b.M{"$exists": false},
+	}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var seqIDs []int
+	for cur.Next(a.ctx) {
+		var msg struct {
+			SeqID int `bson:"seqid"`
+		}
+		if err = cur.Decode(&msg); err != nil {
+			return nil, err
+		}
+		seqIDs = append(seqIDs, msg.SeqID)
+	}
+
+	return seqIDs, nil
+}
+
+// Authenticated sessions.
+
+// AuthSessionCreate saves a new authenticated session.
+func (a *adapter) AuthSessionCreate(sess *t.AuthSession) error {
+	_, err := a.db.Collection("authsessions").InsertOne(a.ctx, sess)
+	return err
+}
+
+// AuthSessionUpdate updates the last seen time, expiration time and client details of an authenticated session.
+func (a *adapter) AuthSessionUpdate(sess *t.AuthSession) error {
+	res, err := a.db.Collection("authsessions").UpdateOne(a.ctx, b.M{"_id": sess.Id}, b.M{"$set": b.M{
+		"updatedat":  sess.UpdatedAt,
+		"expires":    sess.Expires,
+		"deviceid":   sess.DeviceId,
+		"platform":   sess.Platform,
+		"useragent":  sess.UserAgent,
+		"remoteaddr": sess.RemoteAddr,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// AuthSessionGet returns the authenticated session with the given ID or nil if not found.
+func (a *adapter) AuthSessionGet(id string) (*t.AuthSession, error) {
+	var sess t.AuthSession
+	if err := a.db.Collection("authsessions").FindOne(a.ctx, b.M{"_id": id}).Decode(&sess); err != nil {
+		if err == mdb.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// AuthSessionGetAll returns authenticated sessions of the user which have not expired yet ordered by creation time.
+func (a *adapter) AuthSessionGetAll(uid t.Uid) ([]t.AuthSession, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"createdat", 1}, {"_id", 1}})
+	cur, err := a.db.Collection("authsessions").Find(a.ctx,
+		b.M{"user": uid.String(), "expires": b.M{"$gt": t.TimeNow()}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var sessions []t.AuthSession
+	if err = cur.All(a.ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// AuthSessionExpire deletes authenticated sessions of all users which expired before the given time.
+func (a *adapter) AuthSessionExpire(olderThan time.Time) error {
+	_, err := a.db.Collection("authsessions").DeleteMany(a.ctx, b.M{"expires": b.M{"$lt": olderThan}})
+	return err
+}
+
+// AuthSessionDelete deletes authenticated sessions of the user with the given IDs.
+func (a *adapter) AuthSessionDelete(uid t.Uid, ids []string) error {
+	_, err := a.db.Collection("authsessions").DeleteMany(a.ctx,
+		b.M{"_id": b.M{"$in": ids}, "user": uid.String()})
+	return err
+}
+
+// API keys.
+
+// APIKeyCreate saves a new API key.
+func (a *adapter) APIKeyCreate(key *t.APIKey) error {
+	_, err := a.db.Collection("apikeys").InsertOne(a.ctx, key)
+	return err
+}
+
+// APIKeyUpdate updates an API key.
+func (a *adapter) APIKeyUpdate(key *t.APIKey) error {
+	res, err := a.db.Collection("apikeys").UpdateOne(a.ctx, b.M{"_id": key.Id}, b.M{"$set": b.M{
+		"updatedat": key.UpdatedAt,
+		"revokedat": key.RevokedAt,
+		"name":      key.Name,
+		"origins":   key.Origins,
+		"schemes":   key.Schemes,
+		"messages":  key.Messages,
+		"ratelimit": key.RateLimit,
+		"rateburst": key.RateBurst,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// APIKeyGet returns the API key with the given ID or nil if not found.
+func (a *adapter) APIKeyGet(id string) (*t.APIKey, error) {
+	var key t.APIKey
+	if err := a.db.Collection("apikeys").FindOne(a.ctx, b.M{"_id": id}).Decode(&key); err != nil {
+		if err == mdb.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// APIKeyGetAll returns all API keys ordered by creation time.
+func (a *adapter) APIKeyGetAll() ([]t.APIKey, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"createdat", 1}, {"_id", 1}})
+	cur, err := a.db.Collection("apikeys").Find(a.ctx, b.M{}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var keys []t.APIKey
+	if err = cur.All(a.ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Audit log.
+
+// AuditAdd appends a record to the audit log.
+func (a *adapter) AuditAdd(rec *t.AuditRecord) error {
+	_, err := a.db.Collection("auditlog").InsertOne(a.ctx, rec)
+	return err
+}
+
+// AuditGetAll returns records of the audit log matching the query, newest first.
+func (a *adapter) AuditGetAll(query *t.AuditQuery) ([]t.AuditRecord, error) {
+	filter := b.M{}
+	limit := a.maxResults
+	if query != nil {
+		if !query.User.IsZero() {
+			filter["$or"] = b.A{b.M{"actor": query.User.String()}, b.M{"user": query.User.String()}}
+		}
+		if query.Action != "" {
+			filter["action"] = query.Action
+		}
+		createdAt := b.M{}
+		if query.Since != nil {
+			createdAt["$gte"] = *query.Since
+		}
+		if query.Before != nil {
+			createdAt["$lt"] = *query.Before
+		}
+		if len(createdAt) > 0 {
+			filter["createdat"] = createdAt
+		}
+		if query.Limit > 0 && query.Limit < limit {
+			limit = query.Limit
+		}
+	}
+
+	findOpts := mdbopts.Find().SetSort(b.D{{"createdat", -1}, {"_id", -1}}).SetLimit(int64(limit))
+	return a.auditGet(filter, findOpts)
+}
+
+// AuditList returns up to limit records of the audit log ordered by ID, starting after the record with the given ID.
+func (a *adapter) AuditList(after string, limit int) ([]t.AuditRecord, error) {
+	filter := b.M{}
+	if after != "" {
+		filter["_id"] = b.M{"$gt": after}
+	}
+	return a.auditGet(filter, mdbopts.Find().SetSort(b.D{{"_id", 1}}).SetLimit(int64(limit)))
+}
+
+func (a *adapter) auditGet(filter b.M, findOpts *mdbopts.FindOptions) ([]t.AuditRecord, error) {
+	cur, err := a.db.Collection("auditlog").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var records []t.AuditRecord
+	if err = cur.All(a.ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // Devices (for push notifications).
 
 // DeviceUpsert creates or updates a device record.
@@ -2867,6 +4018,27 @@ func (a *adapter) FileGet(fid string) (*t.FileDef, error) {
 	return &fd, nil
 }
 
+// FileGetAll returns records of completed uploads by the given user ordered by ID.
+func (a *adapter) FileGetAll(user, after t.Uid, limit int) ([]t.FileDef, error) {
+	filter := b.M{"user": user.String(), "status": t.UploadCompleted}
+	if !after.IsZero() {
+		filter["_id"] = b.M{"$gt": after.String()}
+	}
+	findOpts := mdbopts.Find().SetSort(b.D{{"_id", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("fileuploads").Find(a.ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var files []t.FileDef
+	if err = cur.All(a.ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
 // FileDeleteUnused deletes records where UseCount is zero. If olderThan is non-zero, deletes
 // unused records with UpdatedAt before olderThan.
 // Returns array of FileDef.Location of deleted filerecords so actual files can be deleted too.
@@ -2902,6 +4074,26 @@ func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, er
 	return locations, err
 }
 
+// FileDelete deletes the record of the file unless the file is in use. Returns FileDef.Location
+// of the deleted record so the actual file can be deleted too.
+func (a *adapter) FileDelete(fid string) (string, error) {
+	var fd t.FileDef
+	err := a.db.Collection("fileuploads").FindOneAndDelete(a.ctx, b.M{
+		"_id": fid,
+		"$or": b.A{
+			b.M{"usecount": 0},
+			b.M{"usecount": b.M{"$exists": false}}},
+	}).Decode(&fd)
+	if err == mdb.ErrNoDocuments {
+		// Not found or in use.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fd.Location, nil
+}
+
 // Given a filter query against 'messages' collection, decrement corresponding use counter in 'fileuploads' table.
 func (a *adapter) decFileUseCounter(ctx context.Context, collection string, msgFilter b.M) error {
 	// Copy msgFilter
@@ -2999,6 +4191,23 @@ func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []
 	return err
 }
 
+// FileGetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+func (a *adapter) FileGetMessageAttachments(topic string, seqId int) ([]string, error) {
+	var msg struct {
+		Attachments []string `bson:"attachments"`
+	}
+	findOpts := mdbopts.FindOne().SetProjection(b.M{"attachments": 1, "_id": 0})
+	err := a.db.Collection("messages").FindOne(a.ctx, b.M{"topic": topic, "seqid": seqId}, findOpts).Decode(&msg)
+	if err != nil {
+		if err == mdb.ErrNoDocuments {
+			err = nil
+		}
+		return nil, err
+	}
+
+	return msg.Attachments, nil
+}
+
 // PCacheGet reads a persistet cache entry.
 func (a *adapter) PCacheGet(key string) (string, error) {
 	var value map[string]string
@@ -3039,6 +4248,17 @@ func (a *adapter) PCacheUpsert(key string, value string, failOnDuplicate bool) e
 	return res.Err()
 }
 
+// PCacheSwap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+// Returns false if the entry does not exist or has a different value.
+func (a *adapter) PCacheSwap(key, oldValue, newValue string) (bool, error) {
+	res, err := a.db.Collection("kvmeta").UpdateOne(a.ctx, b.M{"_id": key, "value": oldValue},
+		b.M{"$set": b.M{"value": newValue, "createdat": t.TimeNow()}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
 // PCacheDelete deletes one persistent cache entry.
 func (a *adapter) PCacheDelete(key string) error {
 	_, err := a.db.Collection("kvmeta").DeleteOne(a.ctx, b.M{"_id": key})
@@ -3056,6 +4276,31 @@ func (a *adapter) PCacheExpire(keyPrefix string, olderThan time.Time) error {
 	return err
 }
 
+// PCacheList returns up to limit persistent cache entries ordered by key, starting after the given key.
+func (a *adapter) PCacheList(after string, limit int) ([]adp.PCacheEntry, error) {
+	findOpts := mdbopts.Find().SetSort(b.D{{"_id", 1}}).SetLimit(int64(limit))
+	cur, err := a.db.Collection("kvmeta").Find(a.ctx, b.M{"_id": b.M{"$gt": after, "$ne": "version"}}, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(a.ctx)
+
+	var entries []adp.PCacheEntry
+	for cur.Next(a.ctx) {
+		var entry struct {
+			Key       string `bson:"_id"`
+			Value     string
+			CreatedAt time.Time
+		}
+		if err := cur.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, adp.PCacheEntry{Key: entry.Key, Value: entry.Value, CreatedAt: entry.CreatedAt})
+	}
+
+	return entries, cur.Err()
+}
+
 // GetTestDB returns a currently open database connection.
 func (a *adapter) GetTestDB() any {
 	return a.db
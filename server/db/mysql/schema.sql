@@ -86,6 +86,70 @@ CREATE TABLE auth(
 );
 
 
+# Logins on devices which can be revoked by the user.
+CREATE TABLE authsessions(
+	id			BIGINT NOT NULL,
+	createdat	DATETIME(3) NOT NULL,
+	# Time when the session was last seen.
+	updatedat	DATETIME(3) NOT NULL,
+	# Expiration time of the most recently issued token.
+	expires		DATETIME(3) NOT NULL,
+	userid		BIGINT NOT NULL,
+	deviceid	TEXT,
+	platform	VARCHAR(32),
+	useragent	VARCHAR(255),
+	remoteaddr	VARCHAR(64),
+
+	PRIMARY KEY(id),
+	FOREIGN KEY(userid) REFERENCES users(id),
+	INDEX authsessions_userid(userid)
+);
+
+
+# API keys which can be restricted and revoked by the root user.
+CREATE TABLE apikeys(
+	id			BIGINT NOT NULL,
+	createdat	DATETIME(3) NOT NULL,
+	updatedat	DATETIME(3) NOT NULL,
+	# Time when the key was revoked.
+	revokedat	DATETIME(3),
+	name		VARCHAR(255) NOT NULL,
+	# Allowed values of the Origin header, JSON array.
+	origins		JSON,
+	# Allowed authentication schemes, JSON array.
+	schemes		JSON,
+	# Allowed client messages, JSON array.
+	messages	JSON,
+	# Client messages per minute per session.
+	ratelimit	INT NOT NULL DEFAULT 0,
+	rateburst	INT NOT NULL DEFAULT 0,
+
+	PRIMARY KEY(id)
+);
+
+
+# Append-only audit log of privileged and security-relevant actions.
+CREATE TABLE auditlog(
+	id			BIGINT NOT NULL,
+	createdat	DATETIME(3) NOT NULL,
+	# Action performed, e.g. 'login'.
+	action		VARCHAR(32) NOT NULL,
+	# User who performed the action, 0 if not known.
+	actor		BIGINT NOT NULL DEFAULT 0,
+	# User affected by the action, 0 if none.
+	userid		BIGINT NOT NULL DEFAULT 0,
+	topic		CHAR(25) NOT NULL DEFAULT '',
+	remoteaddr	VARCHAR(64) NOT NULL DEFAULT '',
+	# Action-specific details, JSON object.
+	params		JSON,
+
+	PRIMARY KEY(id),
+	INDEX auditlog_createdat(createdat),
+	INDEX auditlog_actor_createdat(actor,createdat),
+	INDEX auditlog_userid_createdat(userid,createdat)
+);
+
+
 # Topics
 CREATE TABLE topics(
 	id			INT NOT NULL AUTO_INCREMENT,
@@ -105,6 +169,10 @@ CREATE TABLE topics(
 	trusted	JSON,
 	tags		JSON, -- Denormalized array of tags
 	aux			JSON,
+	pinned		JSON, -- Array of SeqIds of pinned messages
+	msgttl		INT NOT NULL DEFAULT 0, -- Lifetime of messages in seconds, 0 means forever
+	slowmode	INT NOT NULL DEFAULT 0, -- Minimum interval between messages of a user in seconds, 0 means no limit
+	msgburst	INT NOT NULL DEFAULT 0, -- Number of messages a user may send at once in slow mode
 
 	PRIMARY KEY(id),
 	UNIQUE INDEX topics_name (name),
@@ -157,13 +225,88 @@ CREATE TABLE messages(
 	delid 		INT DEFAULT 0,
 	seqid 		INT NOT NULL,
 	topic 		CHAR(25) NOT NULL,
+	# SeqId of the message this one is a reply to, 0 if not a reply.
+	parent 		INT NOT NULL DEFAULT 0,
 	`from` 		BIGINT NOT NULL,
 	head 		JSON,
 	content 	JSON,
+	# Lowercase plain text extracted from content for full-text search.
+	searchtext	TEXT,
 
 	PRIMARY KEY(id),
 	FOREIGN KEY(topic) REFERENCES topics(name),
-	UNIQUE INDEX messages_topic_seqid (topic, seqid)
+	UNIQUE INDEX messages_topic_seqid (topic, seqid),
+	INDEX messages_topic_parent (topic, parent)
+);
+
+# Previous versions of edited messages
+CREATE TABLE msgedits(
+	id			INT NOT NULL AUTO_INCREMENT,
+	createdat	DATETIME(3) NOT NULL,
+	msgid		INT NOT NULL,
+	topic		CHAR(25) NOT NULL,
+	seqid		INT NOT NULL,
+	rev			INT NOT NULL,
+	head		JSON,
+	content		JSON,
+
+	PRIMARY KEY(id),
+	FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE,
+	UNIQUE INDEX msgedits_topic_seqid_rev(topic, seqid, rev)
+);
+
+# Reactions to messages
+CREATE TABLE reactions(
+	id			INT NOT NULL AUTO_INCREMENT,
+	createdat	DATETIME(3) NOT NULL,
+	msgid		INT NOT NULL,
+	topic		CHAR(25) NOT NULL,
+	seqid		INT NOT NULL,
+	userid		BIGINT NOT NULL,
+	value		VARCHAR(32) COLLATE utf8mb4_bin NOT NULL,
+
+	PRIMARY KEY(id),
+	FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE,
+	UNIQUE INDEX reactions_topic_seqid_userid(topic, seqid, userid),
+	INDEX reactions_userid(userid)
+);
+
+# Votes in polls
+CREATE TABLE pollvotes(
+	id			INT NOT NULL AUTO_INCREMENT,
+	createdat	DATETIME(3) NOT NULL,
+	msgid		INT NOT NULL,
+	topic		CHAR(25) NOT NULL,
+	seqid		INT NOT NULL,
+	userid		BIGINT NOT NULL,
+	opt			INT NOT NULL,
+
+	PRIMARY KEY(id),
+	FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE,
+	UNIQUE INDEX pollvotes_topic_seqid_userid_opt(topic, seqid, userid, opt),
+	INDEX pollvotes_userid(userid)
+);
+
+# Messages scheduled for delivery at a later time
+CREATE TABLE scheduled(
+	id			BIGINT NOT NULL,
+	createdat	DATETIME(3) NOT NULL,
+	updatedat	DATETIME(3) NOT NULL,
+	# Time when the message should be delivered.
+	deliverat	DATETIME(3) NOT NULL,
+	topic		CHAR(25) NOT NULL,
+	# User who scheduled the message.
+	userid		BIGINT NOT NULL,
+	parent		INT NOT NULL DEFAULT 0,
+	head		JSON,
+	content		JSON,
+	# The message is claimed for delivery by a cluster node until this time.
+	claimeduntil	DATETIME(3),
+
+	PRIMARY KEY(id),
+	FOREIGN KEY(topic) REFERENCES topics(name) ON DELETE CASCADE,
+	INDEX scheduled_deliverat(deliverat),
+	INDEX scheduled_topic_userid(topic, userid)
 );
 
 # Deletion log
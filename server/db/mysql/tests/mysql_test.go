@@ -392,6 +392,47 @@ func TestAuthGetRecord(t *testing.T) {
 	}
 }
 
+func TestAuthGetUpdated(t *testing.T) {
+	updated, err := adp.AuthGetUpdated(types.ParseUserId("usr"+testData.Recs[0].UserId), "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.IsZero() || time.Since(updated) > time.Hour {
+		t.Error(mismatchErrorString("Updated", updated, "recent time"))
+	}
+
+	// Test not found
+	if _, err = adp.AuthGetUpdated(types.Uid(123), "scheme"); err != types.ErrNotFound {
+		t.Error("Auth record found but shouldn't:", err)
+	}
+}
+
+func TestAuthGetAllRecords(t *testing.T) {
+	recs, err := adp.AuthGetAllRecords(types.ParseUserId("usr" + testData.Recs[0].UserId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatal(mismatchErrorString("Auth records length", len(recs), 1))
+	}
+	if recs[0].Scheme != testData.Recs[0].Scheme ||
+		recs[0].Unique != testData.Recs[0].Unique ||
+		recs[0].AuthLvl != testData.Recs[0].AuthLvl ||
+		!reflect.DeepEqual(recs[0].Secret, testData.Recs[0].Secret) ||
+		recs[0].Expires != testData.Recs[0].Expires {
+		t.Error(mismatchErrorString("Auth record", recs[0], testData.Recs[0]))
+	}
+
+	// User without records
+	recs, err = adp.AuthGetAllRecords(types.Uid(123))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Error(mismatchErrorString("Auth records length", len(recs), 0))
+	}
+}
+
 func TestTopicGet(t *testing.T) {
 	got, err := adp.TopicGet(testData.Topics[0].Id)
 	if err != nil {
@@ -410,6 +451,44 @@ func TestTopicGet(t *testing.T) {
 	}
 }
 
+func TestTopicList(t *testing.T) {
+	all, err := adp.TopicList("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < len(testData.Topics) {
+		t.Fatal(mismatchErrorString("Topics length", len(all), len(testData.Topics)))
+	}
+
+	// Read the same topics page by page.
+	var paged []string
+	after := ""
+	for range len(all) {
+		topics, err := adp.TopicList(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(topics) == 0 {
+			break
+		}
+		for i := range topics {
+			paged = append(paged, topics[i].Id)
+		}
+		after = topics[len(topics)-1].Id
+	}
+	if len(paged) != len(all) {
+		t.Fatal(mismatchErrorString("Paged topics length", len(paged), len(all)))
+	}
+	for i := range all {
+		if all[i].Id != paged[i] {
+			t.Error(mismatchErrorString("Topic", paged[i], all[i].Id))
+		}
+		if i > 0 && all[i-1].Id >= all[i].Id {
+			t.Error("Topics are not sorted by name:", all[i-1].Id, all[i].Id)
+		}
+	}
+}
+
 func TestTopicsForUser(t *testing.T) {
 	qOpts := types.QueryOpt{
 		Topic: "p2p9AVDamaNCRbfKzGSh3mE0w",
@@ -591,6 +670,33 @@ func TestMessageGetAll(t *testing.T) {
 	}
 }
 
+func TestMessageSearch(t *testing.T) {
+	gotMsgs, err := adp.MessageSearch(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), "MSG3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotMsgs) != 1 {
+		t.Error(mismatchErrorString("Messages length search", len(gotMsgs), 1))
+	}
+	// Message 2 is soft-deleted for user 0.
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), "msg", nil)
+	if len(gotMsgs) != 2 {
+		t.Error(mismatchErrorString("Messages length soft-deleted", len(gotMsgs), 2))
+	}
+	opts := types.QueryOpt{
+		Before: 3,
+		Limit:  999,
+	}
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ZeroUid, "msg", &opts)
+	if len(gotMsgs) != 2 {
+		t.Error(mismatchErrorString("Messages length search opts", len(gotMsgs), 2))
+	}
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ZeroUid, "%", nil)
+	if len(gotMsgs) != 0 {
+		t.Error(mismatchErrorString("Messages length wildcard", len(gotMsgs), 0))
+	}
+}
+
 func TestFileGet(t *testing.T) {
 	// General test done during TestFileFinishUpload().
 
@@ -813,6 +919,54 @@ func TestTopicUpdate(t *testing.T) {
 	}
 }
 
+func TestTopicUpdatePinned(t *testing.T) {
+	pinned := types.IntSlice{3, 1}
+	err := adp.TopicUpdate(testData.Topics[0].Id, map[string]any{"Pinned": pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.TopicGet(testData.Topics[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Pinned, pinned) {
+		t.Error(mismatchErrorString("Pinned", got.Pinned, pinned))
+	}
+}
+
+func TestTopicUpdateMsgTTL(t *testing.T) {
+	topic := testData.Topics[1].Id
+	if err := adp.TopicUpdate(topic, map[string]any{"MsgTTL": 3600}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.TopicGet(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MsgTTL != 3600 {
+		t.Error(mismatchErrorString("MsgTTL", got.MsgTTL, 3600))
+	}
+
+	topics, err := adp.TopicsWithMsgTTL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0].Id != topic || topics[0].MsgTTL != 3600 {
+		t.Error("Wrong topics with message TTL", topics)
+	}
+
+	if err = adp.TopicUpdate(topic, map[string]any{"MsgTTL": 0}); err != nil {
+		t.Fatal(err)
+	}
+	topics, err = adp.TopicsWithMsgTTL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 0 {
+		t.Error(mismatchErrorString("Topics with message TTL", len(topics), 0))
+	}
+}
+
 func TestTopicOwnerChange(t *testing.T) {
 	err := adp.TopicOwnerChange(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[1].Id))
 	if err != nil {
@@ -930,6 +1084,717 @@ func TestDeviceUpsert(t *testing.T) {
 	}
 }
 
+func TestMessageEdit(t *testing.T) {
+	msg := *testData.Msgs[5]
+	rev := &types.MessageRevision{
+		CreatedAt: msg.UpdatedAt,
+		Topic:     msg.Topic,
+		SeqId:     msg.SeqId,
+		Rev:       1,
+		Head:      msg.Head,
+		Content:   msg.Content,
+	}
+	msg.Head = types.KVMap{"rev": 2}
+	msg.Content = "msg3 edited"
+	msg.UpdatedAt = types.TimeNow()
+	if err := adp.MessageEdit(&msg, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	gotMsgs, err := adp.MessageGetAll(msg.Topic, types.ZeroUid, &types.QueryOpt{Since: msg.SeqId, Before: msg.SeqId + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotMsgs) != 1 || gotMsgs[0].Content != "msg3 edited" {
+		t.Error("Message content not updated", gotMsgs)
+	}
+	gotMsgs, _ = adp.MessageSearch(msg.Topic, types.ZeroUid, "edited", nil)
+	if len(gotMsgs) != 1 {
+		t.Error(mismatchErrorString("Messages length search edited", len(gotMsgs), 1))
+	}
+
+	revs, err := adp.MessageGetEdits(msg.Topic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 1 {
+		t.Fatal(mismatchErrorString("Revisions length", len(revs), 1))
+	}
+	if revs[0].SeqId != msg.SeqId || revs[0].Rev != 1 || revs[0].Content != "msg3" {
+		t.Error("Wrong revision", revs[0])
+	}
+	revs, _ = adp.MessageGetEdits(msg.Topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 1, Hi: 5}}})
+	if len(revs) != 0 {
+		t.Error(mismatchErrorString("Revisions length ranges", len(revs), 0))
+	}
+
+	// Edit of a non-existent message.
+	msg.SeqId = 999
+	rev.SeqId = msg.SeqId
+	if err = adp.MessageEdit(&msg, rev); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	// Revision saved without changing the message.
+	msg = *testData.Msgs[5]
+	rev = &types.MessageRevision{
+		CreatedAt: msg.CreatedAt,
+		Topic:     msg.Topic,
+		SeqId:     msg.SeqId,
+		Rev:       2,
+		Content:   "msg3 restored",
+	}
+	if err = adp.MessageEditSave(rev); err != nil {
+		t.Fatal(err)
+	}
+	revs, _ = adp.MessageGetEdits(msg.Topic, nil)
+	if len(revs) != 2 || revs[0].Rev != 2 || revs[0].Content != "msg3 restored" {
+		t.Error("Wrong saved revision", revs)
+	}
+	gotMsgs, _ = adp.MessageGetAll(msg.Topic, types.ZeroUid, &types.QueryOpt{Since: msg.SeqId, Before: msg.SeqId + 1})
+	if len(gotMsgs) != 1 || gotMsgs[0].Content != "msg3 edited" {
+		t.Error("Message changed by saving a revision", gotMsgs)
+	}
+	rev.SeqId = 999
+	if err = adp.MessageEditSave(rev); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestMessageReact(t *testing.T) {
+	topic := testData.Topics[0].Id
+	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
+	uid1 := types.ParseUserId("usr" + testData.Users[1].Id)
+	uid2 := types.ParseUserId("usr" + testData.Users[2].Id)
+	for _, r := range []struct {
+		uid   types.Uid
+		value string
+	}{{uid0, "+1"}, {uid1, "+1"}, {uid2, "heart"}, {uid0, "heart"}} {
+		if err := adp.MessageReact(topic, 1, r.uid, r.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reacts, err := adp.MessageGetReactions(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reacts) != 2 {
+		t.Fatal(mismatchErrorString("Reactions length", len(reacts), 2))
+	}
+	if reacts[0].Value != "heart" || reacts[0].Count != 2 || !reacts[0].Mine {
+		t.Error("Wrong first reaction", reacts[0])
+	}
+	if reacts[1].Value != "+1" || reacts[1].Count != 1 || reacts[1].Mine {
+		t.Error("Wrong second reaction", reacts[1])
+	}
+
+	// Remove reaction.
+	if err = adp.MessageReact(topic, 1, uid2, ""); err != nil {
+		t.Fatal(err)
+	}
+	reacts, _ = adp.MessageGetReactions(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 1}}})
+	if len(reacts) != 2 || reacts[0].Count != 1 || reacts[1].Count != 1 {
+		t.Error("Reaction not removed", reacts)
+	}
+	reacts, _ = adp.MessageGetReactions(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 2, Hi: 4}}})
+	if len(reacts) != 0 {
+		t.Error(mismatchErrorString("Reactions length ranges", len(reacts), 0))
+	}
+
+	// Reaction to a non-existent message.
+	if err = adp.MessageReact(topic, 999, uid0, "+1"); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	userReacts, err := adp.MessageGetUserReactions(topic, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading one reaction at a time returns the same reactions.
+	var pagedReacts []adapter.UserReaction
+	var afterReact *adapter.UserReaction
+	for {
+		page, err := adp.MessageGetUserReactions(topic, afterReact, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pagedReacts = append(pagedReacts, page...)
+		afterReact = &page[0]
+	}
+	if !reflect.DeepEqual(pagedReacts, userReacts) {
+		t.Error(mismatchErrorString("Paged user reactions", pagedReacts, userReacts))
+	}
+	byUser := map[string]string{}
+	for _, r := range userReacts {
+		if r.SeqId == 1 {
+			byUser[r.User] = r.Value
+		}
+	}
+	expect := map[string]string{uid0.String(): "heart", uid1.String(): "+1"}
+	if !reflect.DeepEqual(byUser, expect) {
+		t.Error(mismatchErrorString("User reactions", byUser, expect))
+	}
+}
+
+func TestMessageVote(t *testing.T) {
+	topic := testData.Topics[0].Id
+	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
+	uid1 := types.ParseUserId("usr" + testData.Users[1].Id)
+	uid2 := types.ParseUserId("usr" + testData.Users[2].Id)
+	for _, v := range []struct {
+		uid  types.Uid
+		opts []int
+	}{{uid0, []int{1}}, {uid1, []int{0, 1}}, {uid2, []int{2}}, {uid0, []int{0, 2}}} {
+		if err := adp.MessageVote(topic, 2, v.uid, v.opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second vote of uid0 replaced the first one.
+	votes, err := adp.MessageGetVotes(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []types.MessageVote{
+		{SeqId: 2, Option: 0, Count: 2, Mine: true},
+		{SeqId: 2, Option: 1, Count: 1},
+		{SeqId: 2, Option: 2, Count: 2, Mine: true},
+	}
+	if !reflect.DeepEqual(votes, expect) {
+		t.Error(mismatchErrorString("Votes", votes, expect))
+	}
+
+	// Remove vote.
+	if err = adp.MessageVote(topic, 2, uid1, nil); err != nil {
+		t.Fatal(err)
+	}
+	votes, _ = adp.MessageGetVotes(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 2}}})
+	if len(votes) != 2 || votes[0].Count != 1 || votes[1].Option != 2 || votes[1].Count != 2 {
+		t.Error("Vote not removed", votes)
+	}
+	votes, _ = adp.MessageGetVotes(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 3, Hi: 5}}})
+	if len(votes) != 0 {
+		t.Error(mismatchErrorString("Votes length ranges", len(votes), 0))
+	}
+
+	// Vote in a non-existent message.
+	if err = adp.MessageVote(topic, 999, uid0, []int{0}); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	userVotes, err := adp.MessageGetUserVotes(topic, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading one option at a time returns the same votes.
+	var pagedVotes []adapter.UserVote
+	var afterVote *adapter.UserVote
+	for {
+		page, err := adp.MessageGetUserVotes(topic, afterVote, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pagedVotes = append(pagedVotes, page...)
+		afterVote = &page[0]
+	}
+	if !reflect.DeepEqual(pagedVotes, userVotes) {
+		t.Error(mismatchErrorString("Paged user votes", pagedVotes, userVotes))
+	}
+	byUser := map[string][]int{}
+	for _, v := range userVotes {
+		if v.SeqId == 2 {
+			byUser[v.User] = append(byUser[v.User], v.Option)
+		}
+	}
+	expectByUser := map[string][]int{uid0.String(): {0, 2}, uid2.String(): {2}}
+	if !reflect.DeepEqual(byUser, expectByUser) {
+		t.Error(mismatchErrorString("User votes", byUser, expectByUser))
+	}
+}
+
+func TestMessageThreads(t *testing.T) {
+	topic := testData.Topics[1].Id
+	for i, parent := range []int{5, 5, 1} {
+		ts := testData.Now.Add(time.Duration(i+1) * time.Minute)
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: ts, UpdatedAt: ts},
+			SeqId:     20 + i,
+			Topic:     topic,
+			Parent:    parent,
+			From:      testData.Users[0].Id,
+			Content:   "reply",
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replies, err := adp.MessageGetAll(topic, types.ZeroUid, &types.QueryOpt{Thread: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 {
+		t.Fatal(mismatchErrorString("Replies length", len(replies), 2))
+	}
+	if replies[0].SeqId != 21 || replies[0].Parent != 5 || replies[1].SeqId != 20 {
+		t.Error("Wrong replies", replies)
+	}
+
+	threads, err := adp.MessageGetThreads(topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 1}, {Low: 5}, {Low: 11}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 {
+		t.Fatal(mismatchErrorString("Threads length", len(threads), 2))
+	}
+	if threads[0].SeqId != 1 || threads[0].Replies != 1 {
+		t.Error("Wrong first thread", threads[0])
+	}
+	if threads[1].SeqId != 5 || threads[1].Replies != 2 ||
+		!threads[1].LastReplyAt.Equal(testData.Now.Add(2*time.Minute)) {
+		t.Error("Wrong second thread", threads[1])
+	}
+
+	threads, _ = adp.MessageGetThreads(topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 11}}})
+	if len(threads) != 0 {
+		t.Error(mismatchErrorString("Threads length ranges", len(threads), 0))
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	topic := testData.Topics[1].Id
+	var ids []string
+	for i := range 3 {
+		smsg := &types.ScheduledMessage{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: testData.Now, UpdatedAt: testData.Now},
+			DeliverAt: testData.Now.Add(time.Duration(3-i) * time.Hour),
+			Topic:     topic,
+			From:      testData.Users[i%2].Id,
+			Parent:    i,
+			Content:   fmt.Sprint("later ", i),
+		}
+		if err := adp.ScheduledMessageSave(smsg); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, smsg.Id)
+	}
+
+	uid0 := types.ParseUid(testData.Users[0].Id)
+	scheduled, err := adp.ScheduledMessageGetAll(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 2 {
+		t.Fatal(mismatchErrorString("Scheduled length", len(scheduled), 2))
+	}
+	if scheduled[0].Id != ids[2] || scheduled[0].Parent != 2 || scheduled[0].Content != "later 2" ||
+		scheduled[0].From != testData.Users[0].Id || !scheduled[0].DeliverAt.Equal(testData.Now.Add(time.Hour)) {
+		t.Error("Wrong first scheduled message", scheduled[0])
+	}
+	if scheduled[1].Id != ids[0] {
+		t.Error(mismatchErrorString("Second scheduled message", scheduled[1].Id, ids[0]))
+	}
+
+	all, err := adp.ScheduledMessageGetAll(topic, types.ZeroUid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Id != ids[2] || all[2].Id != ids[0] {
+		t.Error("Wrong messages scheduled by all users", all)
+	}
+
+	due, err := adp.ScheduledMessageGetDue(testData.Now.Add(150*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].Id != ids[2] || due[1].Id != ids[1] {
+		t.Error("Wrong due messages", due)
+	}
+	due, _ = adp.ScheduledMessageGetDue(testData.Now.Add(150*time.Minute), 1)
+	if len(due) != 1 {
+		t.Error(mismatchErrorString("Due length limited", len(due), 1))
+	}
+
+	// Claimed messages are not due until the claim expires.
+	now := testData.Now.Add(150 * time.Minute)
+	if err = adp.ScheduledMessageClaim(ids[2], now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err = adp.ScheduledMessageClaim(ids[2], now, now.Add(time.Minute)); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Repeated claim", err, types.ErrNotFound))
+	}
+	due, _ = adp.ScheduledMessageGetDue(now, 10)
+	if len(due) != 1 || due[0].Id != ids[1] {
+		t.Error("Wrong due messages with a claim", due)
+	}
+	later := now.Add(2 * time.Minute)
+	due, _ = adp.ScheduledMessageGetDue(later, 10)
+	if len(due) != 2 {
+		t.Error(mismatchErrorString("Due length after the claim expired", len(due), 2))
+	}
+	if err = adp.ScheduledMessageClaim(ids[2], later, later.Add(time.Minute)); err != nil {
+		t.Error("Claim after expiration:", err)
+	}
+
+	// Messages scheduled by another user cannot be deleted.
+	if err = adp.ScheduledMessageDelete(topic, types.ParseUid(testData.Users[1].Id), ids[0]); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Delete by another user", err, types.ErrNotFound))
+	}
+	if err = adp.ScheduledMessageDelete(topic, uid0, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Already deleted.
+	if err = adp.ScheduledMessageDelete(topic, types.ZeroUid, ids[0]); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Repeated delete", err, types.ErrNotFound))
+	}
+	for _, id := range ids[1:] {
+		if err = adp.ScheduledMessageDelete(topic, types.ZeroUid, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, _ = adp.ScheduledMessageGetDue(testData.Now.Add(24*time.Hour), 10)
+	if len(due) != 0 {
+		t.Error(mismatchErrorString("Due length after delete", len(due), 0))
+	}
+}
+
+func TestAuthSessions(t *testing.T) {
+	uid := types.ParseUid(testData.Users[0].Id)
+	// Expired sessions are not returned, so the expiration time is relative to the real time.
+	expires := types.TimeNow().Add(24 * time.Hour)
+	var ids []string
+	for i := range 3 {
+		sess := &types.AuthSession{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(),
+				CreatedAt: testData.Now.Add(time.Duration(i) * time.Minute),
+				UpdatedAt: testData.Now.Add(time.Duration(i) * time.Minute)},
+			User:      testData.Users[i/2].Id,
+			Expires:   expires,
+			DeviceId:  fmt.Sprint("device-", i),
+			Platform:  "web",
+			UserAgent: "TinodeWeb/1.0",
+		}
+		if err := adp.AuthSessionCreate(sess); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, sess.Id)
+	}
+
+	sessions, err := adp.AuthSessionGetAll(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatal(mismatchErrorString("Sessions length", len(sessions), 2))
+	}
+	if sessions[0].Id != ids[0] || sessions[1].Id != ids[1] {
+		t.Error("Wrong order of sessions", sessions)
+	}
+	if sessions[0].DeviceId != "device-0" || sessions[0].User != testData.Users[0].Id ||
+		!sessions[0].Expires.Equal(expires) {
+		t.Error("Wrong first session", sessions[0])
+	}
+
+	// Expired sessions are not returned and are deleted by AuthSessionExpire.
+	expired := &types.AuthSession{
+		ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: testData.Now, UpdatedAt: testData.Now},
+		User:      testData.Users[0].Id,
+		Expires:   types.TimeNow().Add(-time.Hour),
+	}
+	if err = adp.AuthSessionCreate(expired); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ = adp.AuthSessionGetAll(uid); len(sessions) != 2 {
+		t.Error(mismatchErrorString("Sessions length with expired", len(sessions), 2))
+	}
+	if err = adp.AuthSessionExpire(types.TimeNow()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := adp.AuthSessionGet(expired.Id); got != nil {
+		t.Error("Expired session not deleted", got)
+	}
+	if got, _ := adp.AuthSessionGet(ids[0]); got == nil {
+		t.Error("Live session deleted")
+	}
+
+	update := sessions[1]
+	update.UpdatedAt = testData.Now.Add(time.Hour)
+	update.UserAgent = "TinodeWeb/2.0"
+	update.RemoteAddr = "192.0.2.1"
+	if err = adp.AuthSessionUpdate(&update); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.AuthSessionGet(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.UserAgent != "TinodeWeb/2.0" || got.RemoteAddr != "192.0.2.1" ||
+		!got.UpdatedAt.Equal(testData.Now.Add(time.Hour)) {
+		t.Error("Session not updated", got)
+	}
+
+	// Sessions of another user are not deleted.
+	if err = adp.AuthSessionDelete(uid, []string{ids[1], ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = adp.AuthSessionGet(ids[1]); got != nil {
+		t.Error("Session not deleted", got)
+	}
+	if got, _ = adp.AuthSessionGet(ids[2]); got == nil {
+		t.Error("Session of another user deleted")
+	}
+	update.Id = ids[1]
+	if err = adp.AuthSessionUpdate(&update); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Update deleted session", err, types.ErrNotFound))
+	}
+
+	if err = adp.AuthSessionDelete(uid, []string{ids[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if err = adp.AuthSessionDelete(types.ParseUid(testData.Users[1].Id), []string{ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ = adp.AuthSessionGetAll(uid); len(sessions) != 0 {
+		t.Error(mismatchErrorString("Sessions length after delete", len(sessions), 0))
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	var ids []string
+	for i := range 2 {
+		key := &types.APIKey{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(),
+				CreatedAt: testData.Now.Add(time.Duration(i) * time.Minute),
+				UpdatedAt: testData.Now.Add(time.Duration(i) * time.Minute)},
+			Name:      fmt.Sprint("key-", i),
+			Origins:   types.StringSlice{"https://example.com"},
+			RateLimit: 60 * i,
+		}
+		if err := adp.APIKeyCreate(key); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, key.Id)
+	}
+
+	keys, err := adp.APIKeyGetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatal(mismatchErrorString("Keys length", len(keys), 2))
+	}
+	if keys[0].Id != ids[0] || keys[1].Id != ids[1] {
+		t.Error("Wrong order of keys", keys)
+	}
+	if keys[1].Name != "key-1" || keys[1].RateLimit != 60 || len(keys[1].Origins) != 1 ||
+		len(keys[1].Messages) != 0 || keys[1].RevokedAt != nil {
+		t.Error("Wrong second key", keys[1])
+	}
+
+	update := keys[0]
+	revokedAt := testData.Now.Add(time.Hour)
+	update.UpdatedAt = revokedAt
+	update.RevokedAt = &revokedAt
+	update.Messages = types.StringSlice{"hi", "login"}
+	if err = adp.APIKeyUpdate(&update); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.APIKeyGet(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) || len(got.Messages) != 2 {
+		t.Error("Key not updated", got)
+	}
+
+	if got, _ = adp.APIKeyGet(testData.UGen.GetStr()); got != nil {
+		t.Error("Unknown key found", got)
+	}
+	update.SetUid(testData.UGen.Get())
+	if err = adp.APIKeyUpdate(&update); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Update unknown key", err, types.ErrNotFound))
+	}
+}
+
+func TestUserList(t *testing.T) {
+	all, err := adp.UserList(types.ZeroUid, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 2 {
+		t.Fatal(mismatchErrorString("Users length", len(all), len(testData.Users)))
+	}
+
+	// Read the same users page by page.
+	var paged []string
+	after := types.ZeroUid
+	for range len(all) {
+		users, err := adp.UserList(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) == 0 {
+			break
+		}
+		for i := range users {
+			paged = append(paged, users[i].Id)
+		}
+		after = users[len(users)-1].Uid()
+	}
+	if len(paged) != len(all) {
+		t.Fatal(mismatchErrorString("Paged users length", len(paged), len(all)))
+	}
+	for i := range all {
+		if all[i].Id != paged[i] {
+			t.Error(mismatchErrorString("User", paged[i], all[i].Id))
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	alice, bob := testData.Users[0].Id, testData.Users[1].Id
+	for i, rec := range []*types.AuditRecord{
+		{Action: "login", Actor: alice, User: alice, RemoteAddr: "10.0.0.1"},
+		{Action: "obo", Actor: alice, User: bob, Topic: "grpAbc", Params: types.KVMap{"what": "pub"}},
+		{Action: "login_failed", Params: types.KVMap{"scheme": "basic"}},
+	} {
+		rec.Id = testData.UGen.GetStr()
+		rec.CreatedAt = testData.Now.Add(time.Duration(i) * time.Minute)
+		if err := adp.AuditAdd(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := adp.AuditGetAll(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Action != "login_failed" || all[2].Action != "login" {
+		t.Fatal("Wrong records or order", all)
+	}
+	if all[0].Actor != "" || all[0].User != "" || all[0].Params["scheme"] != "basic" {
+		t.Error("Wrong record without users", all[0])
+	}
+	if all[1].Actor != alice || all[1].User != bob || all[1].Topic != "grpAbc" || all[1].Params["what"] != "pub" {
+		t.Error("Wrong obo record", all[1])
+	}
+
+	for name, tc := range map[string]struct {
+		query    *types.AuditQuery
+		expected []string
+	}{
+		"user":   {&types.AuditQuery{User: types.ParseUid(bob)}, []string{"obo"}},
+		"actor":  {&types.AuditQuery{User: types.ParseUid(alice)}, []string{"obo", "login"}},
+		"action": {&types.AuditQuery{Action: "login"}, []string{"login"}},
+		"time":   {&types.AuditQuery{Since: &all[1].CreatedAt, Before: &all[0].CreatedAt}, []string{"obo"}},
+		"limit":  {&types.AuditQuery{Limit: 1}, []string{"login_failed"}},
+	} {
+		got, err := adp.AuditGetAll(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for i := range got {
+			actions = append(actions, got[i].Action)
+		}
+		if !reflect.DeepEqual(actions, tc.expected) {
+			t.Error(mismatchErrorString(name, actions, tc.expected))
+		}
+	}
+
+	// Paging by ID returns every record once.
+	page, err := adp.AuditList("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 {
+		t.Fatal(mismatchErrorString("First page length", len(page), 2))
+	}
+	rest, err := adp.AuditList(page[1].Id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, rec := range append(page, rest...) {
+		seen[rec.Id] = true
+	}
+	if len(rest) != 1 || len(seen) != 3 {
+		t.Error("Wrong pages", page, rest)
+	}
+}
+
+func TestMessageGetExpired(t *testing.T) {
+	// Messages are saved to a separate topic so that messages saved by other tests don't interfere.
+	topic := &types.Topic{
+		ObjHeader: types.ObjHeader{
+			Id:        "grpExpiredMsgs",
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		TouchedAt: testData.Now,
+		Owner:     testData.Users[0].Id,
+		SeqId:     4,
+	}
+	if err := adp.TopicCreate(topic); err != nil {
+		t.Fatal(err)
+	}
+	defer adp.TopicDelete(topic.Id, false, true)
+
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, -time.Hour} {
+		ts := testData.Now.Add(-age)
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: ts, UpdatedAt: ts},
+			SeqId:     i + 1,
+			Topic:     topic.Id,
+			From:      testData.Users[0].Id,
+			Content:   "expiring",
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seqIDs, err := adp.MessageGetExpired(topic.Id, testData.Now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1, 2, 3}) {
+		t.Error(mismatchErrorString("Expired messages", seqIDs, []int{1, 2, 3}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1, 2}) {
+		t.Error(mismatchErrorString("Expired messages limited", seqIDs, []int{1, 2}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now.Add(-150*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1}) {
+		t.Error(mismatchErrorString("Messages expired 2.5 hours ago", seqIDs, []int{1}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now.Add(-4*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqIDs) != 0 {
+		t.Error(mismatchErrorString("Messages expired 4 hours ago", len(seqIDs), 0))
+	}
+}
+
 func TestMessageAttachments(t *testing.T) {
 	fids := []string{testData.Files[0].Id, testData.Files[1].Id}
 	err := adp.FileLinkAttachments("", types.ZeroUid, types.ParseUid(testData.Msgs[1].Id), fids)
@@ -948,6 +1813,80 @@ func TestMessageAttachments(t *testing.T) {
 	}
 }
 
+func TestFileGetMessageAttachments(t *testing.T) {
+	got, err := adp.FileGetMessageAttachments(testData.Msgs[1].Topic, testData.Msgs[1].SeqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testData.Files[0].Id, testData.Files[1].Id}
+	if len(got) != len(want) {
+		t.Fatal(mismatchErrorString("Attachments count", len(got), len(want)))
+	}
+	for _, fid := range want {
+		found := false
+		for _, id := range got {
+			if id == fid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Error(mismatchErrorString("Attachments", got, want))
+		}
+	}
+
+	// Message without attachments.
+	got, err = adp.FileGetMessageAttachments(testData.Msgs[0].Topic, testData.Msgs[0].SeqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Error(mismatchErrorString("Attachments count", len(got), 0))
+	}
+}
+
+func TestFileDelete(t *testing.T) {
+	// The file is linked to a message.
+	loc, err := adp.FileDelete(testData.Files[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "" {
+		t.Error(mismatchErrorString("Location of linked file", loc, ""))
+	}
+	if got, _ := adp.FileGet(testData.Files[0].Id); got == nil {
+		t.Error("Linked file must not be deleted")
+	}
+
+	fd := &types.FileDef{
+		ObjHeader: types.ObjHeader{
+			Id:        types.Uid(10001).String(),
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		Status:   types.UploadCompleted,
+		MimeType: "application/zip",
+		Location: "uploads/export.zip",
+	}
+	if err = adp.FileStartUpload(fd); err != nil {
+		t.Fatal(err)
+	}
+	loc, err = adp.FileDelete(fd.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != fd.Location {
+		t.Error(mismatchErrorString("Location", loc, fd.Location))
+	}
+	if got, _ := adp.FileGet(fd.Id); got != nil {
+		t.Error("File record must be deleted")
+	}
+	// Already deleted.
+	if loc, err = adp.FileDelete(fd.Id); err != nil || loc != "" {
+		t.Error(mismatchErrorString("Location of deleted file", loc, ""), err)
+	}
+}
+
 func TestFileFinishUpload(t *testing.T) {
 	got, err := adp.FileFinishUpload(testData.Files[0], true, 22222)
 	if err != nil {
@@ -961,6 +1900,29 @@ func TestFileFinishUpload(t *testing.T) {
 	}
 }
 
+func TestFileGetAll(t *testing.T) {
+	uid := types.ParseUserId("usr" + testData.Users[0].Id)
+	// Only completed uploads are returned.
+	got, err := adp.FileGetAll(uid, types.ZeroUid, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatal(mismatchErrorString("Files length", len(got), 1))
+	}
+	if got[0].Id != testData.Files[0].Id || got[0].User != uid.String() || got[0].Size != 22222 {
+		t.Error(mismatchErrorString("File", got[0], testData.Files[0]))
+	}
+
+	got, err = adp.FileGetAll(uid, got[0].Uid(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Error(mismatchErrorString("Files after the last", len(got), 0))
+	}
+}
+
 // ================== Other tests =================================
 func TestDeviceGetAll(t *testing.T) {
 	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
@@ -1043,6 +2005,55 @@ func TestPCacheGet(t *testing.T) {
 	}
 }
 
+func TestPCacheList(t *testing.T) {
+	entries, err := adp.PCacheList("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+	if values["test_key"] != "test_value" || values["test_key2"] != "test_value2" {
+		t.Error(mismatchErrorString("Cache entries", values, "test_key, test_key2"))
+	}
+	if _, ok := values["version"]; ok {
+		t.Error("Schema version should not be listed")
+	}
+
+	// Paging
+	entries, err = adp.PCacheList("test_key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "test_key2" {
+		t.Error(mismatchErrorString("Cache entries", entries, "test_key2"))
+	}
+}
+
+func TestPCacheSwap(t *testing.T) {
+	if err := adp.PCacheUpsert("swap_key", "1", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := adp.PCacheSwap("swap_key", "1", "2"); err != nil || !ok {
+		t.Fatal("Swap failed", ok, err)
+	}
+	// Stale value.
+	if ok, err := adp.PCacheSwap("swap_key", "1", "3"); err != nil || ok {
+		t.Error("Swap of a stale value must fail", ok, err)
+	}
+	if value, _ := adp.PCacheGet("swap_key"); value != "2" {
+		t.Error(mismatchErrorString("Swapped value", value, "2"))
+	}
+	// Missing entry.
+	if ok, err := adp.PCacheSwap("nonexistent", "", "1"); err != nil || ok {
+		t.Error("Swap of a missing entry must fail", ok, err)
+	}
+	if err := adp.PCacheDelete("swap_key"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPCacheDelete(t *testing.T) {
 	err := adp.PCacheDelete("test_key")
 	if err != nil {
@@ -23,6 +23,7 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jmoiron/sqlx"
 	"github.com/tinode/chat/server/auth"
+	adp "github.com/tinode/chat/server/db"
 	"github.com/tinode/chat/server/db/common"
 	"github.com/tinode/chat/server/store"
 	t "github.com/tinode/chat/server/store/types"
@@ -44,10 +45,14 @@ type adapter struct {
 	sqlTimeout time.Duration
 	// DB transaction timeout.
 	txTimeout time.Duration
+
+	// Read replicas.
+	replicas   []*pgxpool.Pool
+	replicaSet *common.ReplicaSet
 }
 
 const (
-	adpVersion  = 116
+	adpVersion  = 130
 	adapterName = "postgres"
 
 	defaultMaxResults = 1024
@@ -92,6 +97,10 @@ type configType struct {
 	// DB request timeout (in seconds).
 	// If 0 (or negative), no timeout is applied.
 	SqlTimeout int `json:"sql_timeout,omitempty"`
+
+	// Read replicas. Heavy read-only queries are sent to replicas which are available
+	// and not lagging behind, the rest go to the primary database.
+	Replicas common.ReplicaConfig `json:"replicas,omitempty"`
 }
 
 func (a *adapter) getContext() (context.Context, context.CancelFunc) {
@@ -183,12 +192,92 @@ func (a *adapter) Open(jsonconfig json.RawMessage) error {
 			// We allocate txTimeoutMultiplier times sqlTimeout for transactions.
 			a.txTimeout = time.Duration(float64(config.SqlTimeout)*txTimeoutMultiplier) * time.Second
 		}
+		if len(config.Replicas.DSNs) > 0 {
+			err = a.openReplicas(&config)
+		}
 	}
 	return err
 }
 
+// openReplicas initializes connections to read replicas and starts checking their state.
+// Replicas which cannot be reached are not used until they become available.
+func (a *adapter) openReplicas(config *configType) error {
+	for _, dsn := range config.Replicas.DSNs {
+		poolConfig, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			a.closeReplicas()
+			return errors.New("postgres adapter failed to parse replica DSN: " + err.Error())
+		}
+		// Don't fail if the replica is down: it will be used when it's back.
+		poolConfig.LazyConnect = true
+		if config.MaxOpenConns > 0 {
+			poolConfig.MaxConns = int32(config.MaxOpenConns)
+		}
+		if config.MaxIdleConns > 0 {
+			poolConfig.MinConns = int32(config.MaxIdleConns)
+		}
+		if config.ConnMaxLifetime > 0 {
+			poolConfig.MaxConnLifetime = time.Duration(config.ConnMaxLifetime) * time.Second
+		}
+		db, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
+		if err != nil {
+			a.closeReplicas()
+			return err
+		}
+		a.replicas = append(a.replicas, db)
+	}
+
+	a.replicaSet = common.NewReplicaSet(adapterName, len(a.replicas), &config.Replicas, a.checkReplica)
+	a.replicaSet.Start()
+	return nil
+}
+
+func (a *adapter) closeReplicas() {
+	a.replicaSet.Stop()
+	a.replicaSet = nil
+	for _, db := range a.replicas {
+		db.Close()
+	}
+	a.replicas = nil
+}
+
+// checkReplica checks that the replica is reachable and optionally returns its replication lag.
+func (a *adapter) checkReplica(ctx context.Context, idx int, withLag bool) (time.Duration, error) {
+	db := a.replicas[idx]
+	if !withLag {
+		return 0, db.Ping(ctx)
+	}
+
+	// The lag is zero if the replica has replayed everything it received, otherwise it's the age
+	// of the last replayed transaction.
+	var secs float64
+	err := db.QueryRow(ctx, `SELECT CASE
+			WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn()=pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now()-pg_last_xact_replay_timestamp()), 0)
+		END::float8`).Scan(&secs)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// withReader runs a read-only query on one of the replicas or on the primary if no replica is usable.
+// The query may see data which lags behind the primary. If the query fails on a replica, it's retried
+// on the primary.
+func (a *adapter) withReader(query func(db *pgxpool.Pool) error) error {
+	if idx := a.replicaSet.Pick(); idx >= 0 {
+		err := query(a.replicas[idx])
+		if err == nil {
+			return nil
+		}
+		a.replicaSet.Failed(idx, err)
+	}
+	return query(a.db)
+}
+
 // Close closes the underlying database connection
 func (a *adapter) Close() error {
+	a.closeReplicas()
 	if a.db != nil {
 		a.db.Close()
 		a.db = nil
@@ -390,13 +479,14 @@ func (a *adapter) CreateDb(reset bool) error {
 	// Authentication records for the basic authentication scheme.
 	if _, err = tx.Exec(ctx,
 		`CREATE TABLE auth(
-			id      SERIAL NOT NULL,
-			uname   VARCHAR(32) NOT NULL,
-			userid  BIGINT NOT NULL,
-			scheme  VARCHAR(16) NOT NULL,
-			authlvl INT NOT NULL,
-			secret  VARCHAR(255) NOT NULL,
-			expires TIMESTAMP,
+			id        SERIAL NOT NULL,
+			uname     VARCHAR(32) NOT NULL,
+			userid    BIGINT NOT NULL,
+			scheme    VARCHAR(16) NOT NULL,
+			authlvl   INT NOT NULL,
+			secret    VARCHAR(255) NOT NULL,
+			expires   TIMESTAMP,
+			updatedat TIMESTAMP(3),
 			PRIMARY KEY(id),
 			FOREIGN KEY(userid) REFERENCES users(id)
 		);
@@ -405,6 +495,62 @@ func (a *adapter) CreateDb(reset bool) error {
 		return err
 	}
 
+	// Logins on devices which can be revoked by the user.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE authsessions(
+			id         BIGINT NOT NULL,
+			createdat  TIMESTAMP(3) NOT NULL,
+			updatedat  TIMESTAMP(3) NOT NULL,
+			expires    TIMESTAMP(3) NOT NULL,
+			userid     BIGINT NOT NULL,
+			deviceid   TEXT,
+			platform   VARCHAR(32),
+			useragent  VARCHAR(255),
+			remoteaddr VARCHAR(64),
+			PRIMARY KEY(id),
+			FOREIGN KEY(userid) REFERENCES users(id)
+		);
+		CREATE INDEX authsessions_userid ON authsessions(userid);`); err != nil {
+		return err
+	}
+
+	// API keys which can be restricted and revoked by the root user.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE apikeys(
+			id         BIGINT NOT NULL,
+			createdat  TIMESTAMP(3) NOT NULL,
+			updatedat  TIMESTAMP(3) NOT NULL,
+			revokedat  TIMESTAMP(3),
+			name       VARCHAR(255) NOT NULL,
+			origins    JSON,
+			schemes    JSON,
+			messages   JSON,
+			ratelimit  INT NOT NULL DEFAULT 0,
+			rateburst  INT NOT NULL DEFAULT 0,
+			PRIMARY KEY(id)
+		);`); err != nil {
+		return err
+	}
+
+	// Append-only audit log of privileged and security-relevant actions.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE auditlog(
+			id         BIGINT NOT NULL,
+			createdat  TIMESTAMP(3) NOT NULL,
+			action     VARCHAR(32) NOT NULL,
+			actor      BIGINT NOT NULL DEFAULT 0,
+			userid     BIGINT NOT NULL DEFAULT 0,
+			topic      VARCHAR(25) NOT NULL DEFAULT '',
+			remoteaddr VARCHAR(64) NOT NULL DEFAULT '',
+			params     JSON,
+			PRIMARY KEY(id)
+		);
+		CREATE INDEX auditlog_createdat ON auditlog(createdat);
+		CREATE INDEX auditlog_actor_createdat ON auditlog(actor,createdat);
+		CREATE INDEX auditlog_userid_createdat ON auditlog(userid,createdat);`); err != nil {
+		return err
+	}
+
 	// Topics
 	if _, err = tx.Exec(ctx,
 		`CREATE TABLE topics(
@@ -425,6 +571,10 @@ func (a *adapter) CreateDb(reset bool) error {
 			trusted   JSON,
 			tags      JSON,
 			aux				JSON,
+			pinned    JSON,
+			msgttl    INT NOT NULL DEFAULT 0,
+			slowmode  INT NOT NULL DEFAULT 0,
+			msgburst  INT NOT NULL DEFAULT 0,
 			PRIMARY KEY(id)
 		);
 		CREATE UNIQUE INDEX topics_name ON topics(name);
@@ -488,13 +638,91 @@ func (a *adapter) CreateDb(reset bool) error {
 			delid     INT DEFAULT 0,
 			seqid     INT NOT NULL,
 			topic     VARCHAR(25) NOT NULL,
+			parent    INT NOT NULL DEFAULT 0,
 			"from"    BIGINT NOT NULL,
 			head      JSON,
 			content   JSON,
+			searchtext TEXT,
 			PRIMARY KEY(id),
 			FOREIGN KEY(topic) REFERENCES topics(name)
 		);
-		CREATE UNIQUE INDEX messages_topic_seqid ON messages(topic, seqid);`); err != nil {
+		CREATE UNIQUE INDEX messages_topic_seqid ON messages(topic, seqid);
+		CREATE INDEX messages_topic_parent ON messages(topic, parent);`); err != nil {
+		return err
+	}
+
+	// Previous versions of edited messages.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE msgedits(
+			id        SERIAL NOT NULL,
+			createdat TIMESTAMP(3) NOT NULL,
+			msgid     INT NOT NULL,
+			topic     VARCHAR(25) NOT NULL,
+			seqid     INT NOT NULL,
+			rev       INT NOT NULL,
+			head      JSON,
+			content   JSON,
+			PRIMARY KEY(id),
+			FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX msgedits_topic_seqid_rev ON msgedits(topic, seqid, rev);`); err != nil {
+		return err
+	}
+
+	// Reactions to messages.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE reactions(
+			id        SERIAL NOT NULL,
+			createdat TIMESTAMP(3) NOT NULL,
+			msgid     INT NOT NULL,
+			topic     VARCHAR(25) NOT NULL,
+			seqid     INT NOT NULL,
+			userid    BIGINT NOT NULL,
+			value     VARCHAR(32) NOT NULL,
+			PRIMARY KEY(id),
+			FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX reactions_topic_seqid_userid ON reactions(topic, seqid, userid);
+		CREATE INDEX reactions_userid ON reactions(userid);`); err != nil {
+		return err
+	}
+
+	// Votes in polls.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE pollvotes(
+			id        SERIAL NOT NULL,
+			createdat TIMESTAMP(3) NOT NULL,
+			msgid     INT NOT NULL,
+			topic     VARCHAR(25) NOT NULL,
+			seqid     INT NOT NULL,
+			userid    BIGINT NOT NULL,
+			opt       INT NOT NULL,
+			PRIMARY KEY(id),
+			FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX pollvotes_topic_seqid_userid_opt ON pollvotes(topic, seqid, userid, opt);
+		CREATE INDEX pollvotes_userid ON pollvotes(userid);`); err != nil {
+		return err
+	}
+
+	// Messages scheduled for delivery at a later time.
+	if _, err = tx.Exec(ctx,
+		`CREATE TABLE scheduled(
+			id           BIGINT NOT NULL,
+			createdat    TIMESTAMP(3) NOT NULL,
+			updatedat    TIMESTAMP(3) NOT NULL,
+			deliverat    TIMESTAMP(3) NOT NULL,
+			topic        VARCHAR(25) NOT NULL,
+			userid       BIGINT NOT NULL,
+			parent       INT NOT NULL DEFAULT 0,
+			head         JSON,
+			content      JSON,
+			claimeduntil TIMESTAMP(3),
+			PRIMARY KEY(id),
+			FOREIGN KEY(topic) REFERENCES topics(name) ON DELETE CASCADE
+		);
+		CREATE INDEX scheduled_deliverat ON scheduled(deliverat);
+		CREATE INDEX scheduled_topic_userid ON scheduled(topic, userid);`); err != nil {
 		return err
 	}
 
@@ -689,6 +917,297 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 116 {
+		// Perform database upgrade from version 116 to version 117.
+
+		// Add plain text column for message search.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE messages ADD searchtext TEXT"); err != nil {
+			return err
+		}
+
+		// Index existing messages.
+		if err := a.messagesFillSearchText(ctx); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Perform database upgrade from version 117 to version 118.
+
+		// Add table for previous versions of edited messages.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE msgedits(
+				id        SERIAL NOT NULL,
+				createdat TIMESTAMP(3) NOT NULL,
+				msgid     INT NOT NULL,
+				topic     VARCHAR(25) NOT NULL,
+				seqid     INT NOT NULL,
+				rev       INT NOT NULL,
+				head      JSON,
+				content   JSON,
+				PRIMARY KEY(id),
+				FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+			);
+			CREATE UNIQUE INDEX msgedits_topic_seqid_rev ON msgedits(topic, seqid, rev);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Perform database upgrade from version 118 to version 119.
+
+		// Add table for reactions to messages.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE reactions(
+				id        SERIAL NOT NULL,
+				createdat TIMESTAMP(3) NOT NULL,
+				msgid     INT NOT NULL,
+				topic     VARCHAR(25) NOT NULL,
+				seqid     INT NOT NULL,
+				userid    BIGINT NOT NULL,
+				value     VARCHAR(32) NOT NULL,
+				PRIMARY KEY(id),
+				FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+			);
+			CREATE UNIQUE INDEX reactions_topic_seqid_userid ON reactions(topic, seqid, userid);
+			CREATE INDEX reactions_userid ON reactions(userid);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Perform database upgrade from version 119 to version 120.
+
+		// Add parent SeqId to messages for threaded replies.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE messages ADD parent INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if _, err := a.db.Exec(ctx, "CREATE INDEX messages_topic_parent ON messages(topic, parent)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 120 {
+		// Perform database upgrade from version 120 to version 121.
+
+		// Add list of pinned messages to topics.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD COLUMN pinned JSON"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 121); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 121 {
+		// Perform database upgrade from version 121 to version 122.
+
+		// Add table for messages scheduled for delivery at a later time.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE scheduled(
+				id        BIGINT NOT NULL,
+				createdat TIMESTAMP(3) NOT NULL,
+				updatedat TIMESTAMP(3) NOT NULL,
+				deliverat TIMESTAMP(3) NOT NULL,
+				topic     VARCHAR(25) NOT NULL,
+				userid    BIGINT NOT NULL,
+				parent    INT NOT NULL DEFAULT 0,
+				head      JSON,
+				content   JSON,
+				PRIMARY KEY(id),
+				FOREIGN KEY(topic) REFERENCES topics(name) ON DELETE CASCADE
+			);
+			CREATE INDEX scheduled_deliverat ON scheduled(deliverat);
+			CREATE INDEX scheduled_topic_userid ON scheduled(topic, userid);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 122); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 122 {
+		// Perform database upgrade from version 122 to version 123.
+
+		// Add message TTL to topics.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD COLUMN msgttl INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 123); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 123 {
+		// Perform database upgrade from version 123 to version 124.
+
+		// Add table for votes in polls.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE pollvotes(
+				id        SERIAL NOT NULL,
+				createdat TIMESTAMP(3) NOT NULL,
+				msgid     INT NOT NULL,
+				topic     VARCHAR(25) NOT NULL,
+				seqid     INT NOT NULL,
+				userid    BIGINT NOT NULL,
+				opt       INT NOT NULL,
+				PRIMARY KEY(id),
+				FOREIGN KEY(msgid) REFERENCES messages(id) ON DELETE CASCADE
+			);
+			CREATE UNIQUE INDEX pollvotes_topic_seqid_userid_opt ON pollvotes(topic, seqid, userid, opt);
+			CREATE INDEX pollvotes_userid ON pollvotes(userid);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 124); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 124 {
+		// Perform database upgrade from version 124 to version 125.
+
+		// Add slow mode settings to topics.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE topics ADD COLUMN slowmode INT NOT NULL DEFAULT 0, "+
+			"ADD COLUMN msgburst INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 125); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 125 {
+		// Perform database upgrade from version 125 to version 126.
+
+		// Add table for authenticated sessions.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE authsessions(
+				id         BIGINT NOT NULL,
+				createdat  TIMESTAMP(3) NOT NULL,
+				updatedat  TIMESTAMP(3) NOT NULL,
+				expires    TIMESTAMP(3) NOT NULL,
+				userid     BIGINT NOT NULL,
+				deviceid   TEXT,
+				platform   VARCHAR(32),
+				useragent  VARCHAR(255),
+				remoteaddr VARCHAR(64),
+				PRIMARY KEY(id),
+				FOREIGN KEY(userid) REFERENCES users(id)
+			);
+			CREATE INDEX authsessions_userid ON authsessions(userid);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 126); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 126 {
+		// Perform database upgrade from version 126 to version 127.
+
+		// Add table for API keys.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE apikeys(
+				id         BIGINT NOT NULL,
+				createdat  TIMESTAMP(3) NOT NULL,
+				updatedat  TIMESTAMP(3) NOT NULL,
+				revokedat  TIMESTAMP(3),
+				name       VARCHAR(255) NOT NULL,
+				origins    JSON,
+				schemes    JSON,
+				messages   JSON,
+				ratelimit  INT NOT NULL DEFAULT 0,
+				rateburst  INT NOT NULL DEFAULT 0,
+				PRIMARY KEY(id)
+			);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 127); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 127 {
+		// Perform database upgrade from version 127 to version 128.
+
+		// Add table for the audit log.
+		if _, err := a.db.Exec(ctx,
+			`CREATE TABLE auditlog(
+				id         BIGINT NOT NULL,
+				createdat  TIMESTAMP(3) NOT NULL,
+				action     VARCHAR(32) NOT NULL,
+				actor      BIGINT NOT NULL DEFAULT 0,
+				userid     BIGINT NOT NULL DEFAULT 0,
+				topic      VARCHAR(25) NOT NULL DEFAULT '',
+				remoteaddr VARCHAR(64) NOT NULL DEFAULT '',
+				params     JSON,
+				PRIMARY KEY(id)
+			);
+			CREATE INDEX auditlog_createdat ON auditlog(createdat);
+			CREATE INDEX auditlog_actor_createdat ON auditlog(actor,createdat);
+			CREATE INDEX auditlog_userid_createdat ON auditlog(userid,createdat);`); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 128); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 128 {
+		// Perform database upgrade from version 128 to version 129.
+
+		// Scheduled messages are claimed for delivery for a limited time.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE scheduled ADD COLUMN claimeduntil TIMESTAMP(3)"); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 129); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 129 {
+		// Perform database upgrade from version 129 to version 130.
+
+		// Time when the secret was changed, for expiring old passwords. The time of existing
+		// secrets is unknown, the upgrade time is used instead.
+		if _, err := a.db.Exec(ctx, "ALTER TABLE auth ADD COLUMN updatedat TIMESTAMP(3)"); err != nil {
+			return err
+		}
+		if _, err := a.db.Exec(ctx, "UPDATE auth SET updatedat=$1", t.TimeNow()); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 130); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -696,6 +1215,50 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// messagesFillSearchText populates searchtext column of messages saved before the column was added.
+func (a *adapter) messagesFillSearchText(ctx context.Context) error {
+	const batchSize = 1000
+
+	lastId := 0
+	for {
+		rows, err := a.db.Query(ctx, "SELECT id,content FROM messages WHERE id>$1 AND delid=0 ORDER BY id LIMIT $2",
+			lastId, batchSize)
+		if err != nil {
+			return err
+		}
+
+		var ids []int
+		var texts []string
+		for rows.Next() {
+			var id int
+			var content any
+			if err = rows.Scan(&id, &content); err != nil {
+				break
+			}
+			ids = append(ids, id)
+			texts = append(texts, common.MessageSearchText(content))
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			if _, err = a.db.Exec(ctx, "UPDATE messages SET searchtext=$1 WHERE id=$2", texts[i], id); err != nil {
+				return err
+			}
+		}
+
+		if len(ids) < batchSize {
+			return nil
+		}
+		lastId = ids[len(ids)-1]
+	}
+}
+
 func createSystemTopic(tx pgx.Tx) error {
 	now := t.TimeNow()
 	query := `INSERT INTO topics(createdat,updatedat,state,touchedat,name,access,public)
@@ -790,8 +1353,8 @@ func (a *adapter) AuthAddRecord(uid t.Uid, scheme, unique string, authLvl auth.L
 		defer cancel()
 	}
 
-	if _, err := a.db.Exec(ctx, "INSERT INTO auth(uname,userid,scheme,authLvl,secret,expires) VALUES($1,$2,$3,$4,$5,$6)",
-		unique, store.DecodeUid(uid), scheme, authLvl, secret, exp); err != nil {
+	if _, err := a.db.Exec(ctx, "INSERT INTO auth(uname,userid,scheme,authLvl,secret,expires,updatedat) VALUES($1,$2,$3,$4,$5,$6,$7)",
+		unique, store.DecodeUid(uid), scheme, authLvl, secret, exp, t.TimeNow()); err != nil {
 		if isDupe(err) {
 			return t.ErrDuplicate
 		}
@@ -837,8 +1400,8 @@ func (a *adapter) AuthUpdRecord(uid t.Uid, scheme, unique string, authLvl auth.L
 		args = append(args, unique)
 	}
 	if len(secret) > 0 {
-		parapg = append(parapg, "secret=?")
-		args = append(args, secret)
+		parapg = append(parapg, "secret=?", "updatedat=?")
+		args = append(args, secret, t.TimeNow())
 	}
 	if !expires.IsZero() {
 		parapg = append(parapg, "expires=?")
@@ -895,32 +1458,84 @@ func (a *adapter) AuthGetRecord(uid t.Uid, scheme string) (string, auth.Level, [
 	return record.Uname, record.Authlvl, record.Secret, expires, nil
 }
 
-// Retrieve user's authentication record
-func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
-	var expires time.Time
-
-	var record struct {
-		Userid  int64
-		Authlvl auth.Level
-		Secret  []byte
-		Expires *time.Time
-	}
-
+// AuthGetUpdated returns the time when the secret of the authentication record was last changed.
+func (a *adapter) AuthGetUpdated(uid t.Uid, scheme string) (time.Time, error) {
 	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
-	if err := a.db.QueryRow(ctx, "SELECT userid,secret,expires,authlvl FROM auth WHERE uname=$1", unique).Scan(
-		&record.Userid, &record.Secret, &record.Expires, &record.Authlvl); err != nil {
+	var updated *time.Time
+	if err := a.db.QueryRow(ctx, "SELECT updatedat FROM auth WHERE userid=$1 AND scheme=$2",
+		store.DecodeUid(uid), scheme).Scan(&updated); err != nil {
 		if err == pgx.ErrNoRows {
-			// Nothing found - clear the error
-			err = nil
+			err = t.ErrNotFound
 		}
-		return t.ZeroUid, 0, nil, expires, err
+		return time.Time{}, err
 	}
-
-	if record.Expires != nil {
-		expires = *record.Expires
+	if updated == nil {
+		return time.Time{}, nil
+	}
+	return *updated, nil
+}
+
+// AuthGetAllRecords returns all authentication records of the given user ordered by scheme.
+func (a *adapter) AuthGetAllRecords(uid t.Uid) ([]adp.AuthRecord, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, "SELECT scheme,uname,authlvl,secret,expires FROM auth WHERE userid=$1 ORDER BY scheme",
+		store.DecodeUid(uid))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []adp.AuthRecord
+	for rows.Next() {
+		var rec adp.AuthRecord
+		var expires *time.Time
+		if err = rows.Scan(&rec.Scheme, &rec.Unique, &rec.AuthLvl, &rec.Secret, &expires); err != nil {
+			break
+		}
+		if expires != nil {
+			rec.Expires = *expires
+		}
+		records = append(records, rec)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return records, err
+}
+
+// Retrieve user's authentication record
+func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
+	var expires time.Time
+
+	var record struct {
+		Userid  int64
+		Authlvl auth.Level
+		Secret  []byte
+		Expires *time.Time
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	if err := a.db.QueryRow(ctx, "SELECT userid,secret,expires,authlvl FROM auth WHERE uname=$1", unique).Scan(
+		&record.Userid, &record.Secret, &record.Expires, &record.Authlvl); err != nil {
+		if err == pgx.ErrNoRows {
+			// Nothing found - clear the error
+			err = nil
+		}
+		return t.ZeroUid, 0, nil, expires, err
+	}
+
+	if record.Expires != nil {
+		expires = *record.Expires
 	}
 
 	return store.EncodeUid(record.Userid), record.Authlvl, record.Secret, expires, nil
@@ -991,6 +1606,48 @@ func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
 	return users, err
 }
 
+// UserList returns up to limit users ordered by ID, starting after the user with the given ID.
+// Suspended and soft-deleted users are included.
+func (a *adapter) UserList(after t.Uid, limit int) ([]t.User, error) {
+	query := "SELECT * FROM users "
+	var args []any
+	if !after.IsZero() {
+		query += "WHERE id>$1 ORDER BY id LIMIT $2"
+		args = append(args, store.DecodeUid(after))
+	} else {
+		query += "ORDER BY id LIMIT $1"
+	}
+	args = append(args, limit)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []t.User
+	for rows.Next() {
+		var user t.User
+		var id int64
+		if err = rows.Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.State, &user.StateAt, &user.Access, &user.LastSeen, &user.UserAgent, &user.Public, &user.Trusted, &user.Tags); err != nil {
+			users = nil
+			break
+		}
+		user.SetUid(store.EncodeUid(id))
+
+		users = append(users, user)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return users, err
+}
+
 // UserDelete deletes specified user: wipes completely (hard-delete) or marks as deleted.
 // TODO: report when the user is not found.
 func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
@@ -1043,6 +1700,21 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 			return err
 		}
 
+		// Delete user's reactions to messages in all topics.
+		if _, err = tx.Exec(ctx, "DELETE FROM reactions WHERE userid=$1", decoded_uid); err != nil {
+			return err
+		}
+
+		// Delete user's votes in polls in all topics.
+		if _, err = tx.Exec(ctx, "DELETE FROM pollvotes WHERE userid=$1", decoded_uid); err != nil {
+			return err
+		}
+
+		// Delete messages scheduled by the user in all topics.
+		if _, err = tx.Exec(ctx, "DELETE FROM scheduled WHERE userid=$1", decoded_uid); err != nil {
+			return err
+		}
+
 		// Can't delete user's messages in all topics because we cannot notify topics of such deletion.
 		// Just leave the messages there marked as sent by "not found" user.
 
@@ -1083,6 +1755,11 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 			return err
 		}
 
+		// Delete authenticated sessions.
+		if _, err = tx.Exec(ctx, "DELETE FROM authsessions WHERE userid=$1", decoded_uid); err != nil {
+			return err
+		}
+
 		// Delete all credentials.
 		if err = credDel(ctx, tx, uid, "", ""); err != nil && err != t.ErrNotFound {
 			return err
@@ -1532,10 +2209,11 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	var tt = new(t.Topic)
 	var owner int64
 	err := a.db.QueryRow(ctx,
-		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,subcnt,public,trusted,tags,aux "+
+		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,subcnt,public,trusted,tags,aux,pinned,msgttl,slowmode,msgburst "+
 			"FROM topics WHERE name=$1",
 		topic).Scan(&tt.CreatedAt, &tt.UpdatedAt, &tt.State, &tt.StateAt, &tt.TouchedAt, &tt.Id,
-		&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.SubCnt, &tt.Public, &tt.Trusted, &tt.Tags, &tt.Aux)
+		&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.SubCnt, &tt.Public, &tt.Trusted, &tt.Tags, &tt.Aux,
+		&tt.Pinned, &tt.MsgTTL, &tt.SlowMode, &tt.MsgBurst)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			// Nothing found - clear the error
@@ -1567,9 +2245,55 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, err
 }
 
+// TopicList returns up to limit topics ordered by name, starting after the topic with the given name.
+// Deleted topics are included.
+func (a *adapter) TopicList(after string, limit int) ([]t.Topic, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx,
+		"SELECT createdat,updatedat,state,stateat,touchedat,name AS id,usebt,access,owner,seqid,delid,subcnt,public,trusted,tags,aux,pinned,msgttl,slowmode,msgburst "+
+			"FROM topics WHERE name>$1 ORDER BY name LIMIT $2", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		var owner int64
+		if err = rows.Scan(&tt.CreatedAt, &tt.UpdatedAt, &tt.State, &tt.StateAt, &tt.TouchedAt, &tt.Id,
+			&tt.UseBt, &tt.Access, &owner, &tt.SeqId, &tt.DelId, &tt.SubCnt, &tt.Public, &tt.Trusted, &tt.Tags, &tt.Aux,
+			&tt.Pinned, &tt.MsgTTL, &tt.SlowMode, &tt.MsgBurst); err != nil {
+			break
+		}
+		tt.Owner = store.EncodeUid(owner).String()
+		topics = append(topics, tt)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return topics, err
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public value.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
+	var subs []t.Subscription
+	err := a.withReader(func(db *pgxpool.Pool) error {
+		var err error
+		subs, err = a.topicsForUser(db, uid, keepDeleted, opts)
+		return err
+	})
+	return subs, err
+}
+
+// topicsForUser implements TopicsForUser using the given connection.
+func (a *adapter) topicsForUser(db *pgxpool.Pool, uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
 	// Fetch ALL user's subscriptions, even those which has not been modified recently.
 	// We are going to use these subscriptions to fetch topics and users which may have been modified recently.
 	q := `SELECT createdat,updatedat,deletedat,topic,delid,recvseqid,
@@ -1614,7 +2338,7 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 	if cancel != nil {
 		defer cancel()
 	}
-	rows, err := a.db.Query(ctx, q, args...)
+	rows, err := db.Query(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1705,7 +2429,7 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		if cancel2 != nil {
 			defer cancel2()
 		}
-		rows, err = a.db.Query(ctx2, q, newargs...)
+		rows, err = db.Query(ctx2, q, newargs...)
 		if err != nil {
 			return nil, err
 		}
@@ -1760,7 +2484,7 @@ func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) (
 		if cancel3 != nil {
 			defer cancel3()
 		}
-		rows, err = a.db.Query(ctx3, q, newargs...)
+		rows, err = db.Query(ctx3, q, newargs...)
 		if err != nil {
 			return nil, err
 		}
@@ -2136,6 +2860,33 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return err
 }
 
+// TopicsWithMsgTTL returns names and message TTLs of topics which have message TTL set.
+func (a *adapter) TopicsWithMsgTTL() ([]t.Topic, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, "SELECT name,msgttl FROM topics WHERE msgttl>0 AND state!=$1", t.StateDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []t.Topic
+	for rows.Next() {
+		var tt t.Topic
+		if err = rows.Scan(&tt.Id, &tt.MsgTTL); err != nil {
+			break
+		}
+		topics = append(topics, tt)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return topics, err
+}
+
 // Get a subscription of a user to a topic.
 func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
 	ctx, cancel := a.getContext()
@@ -2430,6 +3181,17 @@ func (a *adapter) SubsDelForUser(user t.Uid, hard bool) error {
 
 // Find returns a list of users and group topics which match the given tags, such as "email:jdoe@example.com" or "tel:+18003287448".
 func (a *adapter) Find(caller, promoPrefix string, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
+	var subs []t.Subscription
+	err := a.withReader(func(db *pgxpool.Pool) error {
+		var err error
+		subs, err = a.find(db, caller, promoPrefix, req, opt, activeOnly)
+		return err
+	})
+	return subs, err
+}
+
+// find implements Find using the given connection.
+func (a *adapter) find(db *pgxpool.Pool, caller, promoPrefix string, req [][]string, opt []string, activeOnly bool) ([]t.Subscription, error) {
 	index := make(map[string]struct{})
 	var args []any
 	constraint := ""
@@ -2495,7 +3257,7 @@ func (a *adapter) Find(caller, promoPrefix string, req [][]string, opt []string,
 	}
 
 	// Get users matched by tags, sort by number of matches from high to low.
-	rows, err := a.db.Query(ctx, query, args...)
+	rows, err := db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -2606,9 +3368,11 @@ func (a *adapter) MessageSave(msg *t.Message) error {
 	// Using a sequential ID provided by the database.
 	var id int
 	err := a.db.QueryRow(ctx,
-		`INSERT INTO messages(createdAt,updatedAt,seqid,topic,"from",head,content) VALUES($1,$2,$3,$4,$5,$6,$7) RETURNING id`,
-		msg.CreatedAt, msg.UpdatedAt, msg.SeqId, msg.Topic,
-		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, common.ToJSON(msg.Content)).Scan(&id)
+		`INSERT INTO messages(createdAt,updatedAt,seqid,topic,parent,"from",head,content,searchtext)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING id`,
+		msg.CreatedAt, msg.UpdatedAt, msg.SeqId, msg.Topic, msg.Parent,
+		store.DecodeUid(t.ParseUid(msg.From)), msg.Head, common.ToJSON(msg.Content),
+		common.MessageSearchText(msg.Content)).Scan(&id)
 	if err == nil {
 		// Replacing ID given by store by ID given by the DB.
 		msg.SetUid(t.Uid(id))
@@ -2616,7 +3380,34 @@ func (a *adapter) MessageSave(msg *t.Message) error {
 	return err
 }
 
+// MessageGetAll returns messages matching the query.
 func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, "", opts)
+}
+
+// MessageSearch returns messages matching the query which contain the search string.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, search, opts)
+}
+
+// messagesGet returns messages matching the query, optionally constrained by the search string.
+// Messages are read from the primary unless opts.Replica is true.
+func (a *adapter) messagesGet(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	if opts == nil || !opts.Replica {
+		return a.messagesGetFrom(a.db, topic, forUser, search, opts)
+	}
+
+	var msgs []t.Message
+	err := a.withReader(func(db *pgxpool.Pool) error {
+		var err error
+		msgs, err = a.messagesGetFrom(db, topic, forUser, search, opts)
+		return err
+	})
+	return msgs, err
+}
+
+// messagesGetFrom implements messagesGet using the given connection.
+func (a *adapter) messagesGetFrom(db *pgxpool.Pool, topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
 	var limit = a.maxMessageResults
 
 	args := []any{store.DecodeUid(forUser), topic}
@@ -2647,6 +3438,18 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 		}
 	}
 
+	threadConstraint := ""
+	if opts != nil && opts.Thread > 0 {
+		threadConstraint = " AND m.parent=?"
+		args = append(args, opts.Thread)
+	}
+
+	searchConstraint := ""
+	if search != "" {
+		searchConstraint = " AND m.searchtext LIKE ?"
+		args = append(args, common.LikePattern(search))
+	}
+
 	args = append(args, limit)
 
 	ctx, cancel := a.getContext()
@@ -2654,12 +3457,12 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 		defer cancel()
 	}
 
-	query, args := expandQuery(`SELECT m.createdat,m.updatedat,m.deletedat,m.delid,m.seqid,m.topic,m."from",m.head,m.content`+
+	query, args := expandQuery(`SELECT m.createdat,m.updatedat,m.deletedat,m.delid,m.seqid,m.topic,m.parent,m."from",m.head,m.content`+
 		" FROM messages AS m LEFT JOIN dellog AS d"+
 		" ON d.topic=m.topic AND m.seqid BETWEEN d.low AND d.hi-1 AND d.deletedfor=?"+
-		" WHERE m.delid=0 AND m.topic=? "+seqIdConstraint+" AND d.deletedfor IS NULL"+
+		" WHERE m.delid=0 AND m.topic=? "+seqIdConstraint+threadConstraint+searchConstraint+" AND d.deletedfor IS NULL"+
 		" ORDER BY m.seqid DESC LIMIT ?", args...)
-	rows, err := a.db.Query(ctx, query, args...)
+	rows, err := db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -2670,7 +3473,7 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 		var msg t.Message
 		var from int64
 		if err = rows.Scan(&msg.CreatedAt, &msg.UpdatedAt, &msg.DeletedAt, &msg.DelId, &msg.SeqId,
-			&msg.Topic, &from, &msg.Head, &msg.Content); err != nil {
+			&msg.Topic, &msg.Parent, &from, &msg.Head, &msg.Content); err != nil {
 			break
 		}
 		msg.From = store.EncodeUid(from).String()
@@ -2683,19 +3486,69 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 	return msgs, err
 }
 
-// Get ranges of deleted messages
-func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
+// MessageEdit saves the current version of the message as a revision and replaces message head and content.
+func (a *adapter) MessageEdit(msg *t.Message, rev *t.MessageRevision) (err error) {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	res, err := tx.Exec(ctx, "INSERT INTO msgedits(createdat,msgid,topic,seqid,rev,head,content) "+
+		"SELECT $1,id,topic,seqid,$2,$3,$4 FROM messages WHERE topic=$5 AND seqid=$6 AND delid=0",
+		rev.CreatedAt, rev.Rev, rev.Head, common.ToJSON(rev.Content), rev.Topic, rev.SeqId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		err = t.ErrNotFound
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE messages SET updatedat=$1,head=$2,content=$3,searchtext=$4 WHERE topic=$5 AND seqid=$6",
+		msg.UpdatedAt, msg.Head, common.ToJSON(msg.Content), common.MessageSearchText(msg.Content),
+		msg.Topic, msg.SeqId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// MessageGetEdits returns previous versions of messages matching the query.
+func (a *adapter) MessageGetEdits(topic string, opts *t.QueryOpt) ([]t.MessageRevision, error) {
 	var limit = a.maxResults
-	var lower = 0
-	var upper = 1<<31 - 1
 
+	args := []any{topic}
+	seqIdConstraint := ""
 	if opts != nil {
-		if opts.Since > 0 {
-			lower = opts.Since
-		}
-		if opts.Before > 1 {
-			// DelRange is inclusive-exclusive, while BETWEEN is inclusive-inclisive.
-			upper = opts.Before - 1
+		seqIdConstraint = "AND seqid "
+		if len(opts.IdRanges) > 0 {
+			constr, newargs := common.RangesToSql(opts.IdRanges)
+			seqIdConstraint += constr
+			args = append(args, newargs...)
+		} else {
+			seqIdConstraint += "BETWEEN ? AND ?"
+			if opts.Since > 0 {
+				args = append(args, opts.Since)
+			} else {
+				args = append(args, 0)
+			}
+			if opts.Before > 0 {
+				// BETWEEN is inclusive-inclusive, Tinode API requires inclusive-exclusive, thus -1
+				args = append(args, opts.Before-1)
+			} else {
+				args = append(args, 1<<31-1)
+			}
 		}
 
 		if opts.Limit > 0 && opts.Limit < limit {
@@ -2703,203 +3556,1075 @@ func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOp
 		}
 	}
 
-	// Fetch log of deletions
+	args = append(args, limit)
+
 	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
-	rows, err := a.db.Query(ctx, "SELECT topic,deletedfor,delid,low,hi FROM dellog WHERE topic=$1 AND delid BETWEEN $2 AND $3"+
-		" AND (deletedFor=0 OR deletedFor=$4) ORDER BY delid LIMIT $5",
-		topic, lower, upper, store.DecodeUid(forUser), limit)
+
+	query, args := expandQuery("SELECT createdat,topic,seqid,rev,head,content FROM msgedits WHERE topic=? "+
+		seqIdConstraint+" ORDER BY seqid DESC, rev DESC LIMIT ?", args...)
+	rows, err := a.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var dellog struct {
-		Topic      string
-		Deletedfor int64
-		Delid      int
-		Low        int
-		Hi         int
-	}
-	var dmsgs []t.DelMessage
-	var dmsg t.DelMessage
+	var revs []t.MessageRevision
 	for rows.Next() {
-		if err = rows.Scan(&dellog.Topic, &dellog.Deletedfor, &dellog.Delid, &dellog.Low, &dellog.Hi); err != nil {
-			dmsgs = nil
+		var rev t.MessageRevision
+		if err = rows.Scan(&rev.CreatedAt, &rev.Topic, &rev.SeqId, &rev.Rev, &rev.Head, &rev.Content); err != nil {
 			break
 		}
-
-		if dellog.Delid != dmsg.DelId {
-			if dmsg.DelId > 0 {
-				dmsgs = append(dmsgs, dmsg)
-			}
-			dmsg.DelId = dellog.Delid
-			dmsg.Topic = dellog.Topic
-			if dellog.Deletedfor > 0 {
-				dmsg.DeletedFor = store.EncodeUid(dellog.Deletedfor).String()
-			} else {
-				dmsg.DeletedFor = ""
-			}
-			dmsg.SeqIdRanges = nil
-		}
-		if dellog.Hi <= dellog.Low+1 {
-			dellog.Hi = 0
-		}
-		dmsg.SeqIdRanges = append(dmsg.SeqIdRanges, t.Range{Low: dellog.Low, Hi: dellog.Hi})
+		revs = append(revs, rev)
 	}
 	if err == nil {
 		err = rows.Err()
 	}
 
-	if err == nil {
+	return revs, err
+}
+
+// MessageEditSave saves a previous version of the message without changing the message.
+func (a *adapter) MessageEditSave(rev *t.MessageRevision) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	res, err := a.db.Exec(ctx, "INSERT INTO msgedits(createdat,msgid,topic,seqid,rev,head,content) "+
+		"SELECT $1,id,topic,seqid,$2,$3,$4 FROM messages WHERE topic=$5 AND seqid=$6 AND delid=0",
+		rev.CreatedAt, rev.Rev, rev.Head, common.ToJSON(rev.Content), rev.Topic, rev.SeqId)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// MessageReact adds or replaces user's reaction to a message. Empty reaction removes it.
+func (a *adapter) MessageReact(topic string, seqId int, user t.Uid, reaction string) (err error) {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	decodedUid := store.DecodeUid(user)
+	if _, err = tx.Exec(ctx, "DELETE FROM reactions WHERE topic=$1 AND seqid=$2 AND userid=$3",
+		topic, seqId, decodedUid); err != nil {
+		return err
+	}
+
+	if reaction != "" {
+		var res pgconn.CommandTag
+		res, err = tx.Exec(ctx, "INSERT INTO reactions(createdat,msgid,topic,seqid,userid,value) "+
+			"SELECT $1,id,topic,seqid,$2,$3 FROM messages WHERE topic=$4 AND seqid=$5 AND delid=0",
+			t.TimeNow(), decodedUid, reaction, topic, seqId)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			err = t.ErrNotFound
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// MessageGetReactions returns aggregated reactions to messages matching the query.
+func (a *adapter) MessageGetReactions(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageReaction, error) {
+	args := []any{store.DecodeUid(forUser), topic}
+	seqIdConstraint := ""
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			constr, newargs := common.RangesToSql(opts.IdRanges)
+			seqIdConstraint = " AND seqid " + constr
+			args = append(args, newargs...)
+		} else if opts.Since > 0 || opts.Before > 0 {
+			seqIdConstraint = " AND seqid BETWEEN ? AND ?"
+			args = append(args, opts.Since)
+			if opts.Before > 1 {
+				// BETWEEN is inclusive-inclusive, Tinode API requires inclusive-exclusive, thus -1
+				args = append(args, opts.Before-1)
+			} else {
+				args = append(args, 1<<31-1)
+			}
+		}
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	query, args := expandQuery("SELECT seqid,value,COUNT(*) AS count,BOOL_OR(userid=?) AS mine FROM reactions WHERE topic=?"+
+		seqIdConstraint+" GROUP BY seqid,value ORDER BY seqid,count DESC,value", args...)
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reacts []t.MessageReaction
+	for rows.Next() {
+		var react t.MessageReaction
+		if err = rows.Scan(&react.SeqId, &react.Value, &react.Count, &react.Mine); err != nil {
+			break
+		}
+		reacts = append(reacts, react)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return reacts, err
+}
+
+// MessageGetUserReactions returns up to 'limit' reactions of individual users to messages in the topic
+// ordered by SeqId and user, starting after the reaction 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserReactions(topic string, after *adp.UserReaction, limit int) ([]adp.UserReaction, error) {
+	query := "SELECT seqid,userid,value FROM reactions WHERE topic=? "
+	args := []any{topic}
+	if after != nil {
+		query += "AND (seqid>? OR (seqid=? AND userid>?)) "
+		args = append(args, after.SeqId, after.SeqId, store.DecodeUid(t.ParseUid(after.User)))
+	}
+	query += "ORDER BY seqid,userid LIMIT ?"
+	args = append(args, limit)
+	query, args = expandQuery(query, args...)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reacts []adp.UserReaction
+	for rows.Next() {
+		var react adp.UserReaction
+		var userId int64
+		if err = rows.Scan(&react.SeqId, &userId, &react.Value); err != nil {
+			break
+		}
+		react.User = store.EncodeUid(userId).String()
+		reacts = append(reacts, react)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return reacts, err
+}
+
+// MessageVote replaces user's vote in a poll with the given options. Empty list of options removes the vote.
+func (a *adapter) MessageVote(topic string, seqId int, user t.Uid, opts []int) (err error) {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	decodedUid := store.DecodeUid(user)
+	if _, err = tx.Exec(ctx, "DELETE FROM pollvotes WHERE topic=$1 AND seqid=$2 AND userid=$3",
+		topic, seqId, decodedUid); err != nil {
+		return err
+	}
+
+	now := t.TimeNow()
+	for _, opt := range opts {
+		var res pgconn.CommandTag
+		res, err = tx.Exec(ctx, "INSERT INTO pollvotes(createdat,msgid,topic,seqid,userid,opt) "+
+			"SELECT $1,id,topic,seqid,$2,$3 FROM messages WHERE topic=$4 AND seqid=$5 AND delid=0",
+			now, decodedUid, opt, topic, seqId)
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			err = t.ErrNotFound
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// MessageGetVotes returns counts of votes for poll options in messages matching the query.
+func (a *adapter) MessageGetVotes(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageVote, error) {
+	args := []any{store.DecodeUid(forUser), topic}
+	seqIdConstraint := ""
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			constr, newargs := common.RangesToSql(opts.IdRanges)
+			seqIdConstraint = " AND seqid " + constr
+			args = append(args, newargs...)
+		} else if opts.Since > 0 || opts.Before > 0 {
+			seqIdConstraint = " AND seqid BETWEEN ? AND ?"
+			args = append(args, opts.Since)
+			if opts.Before > 1 {
+				// BETWEEN is inclusive-inclusive, Tinode API requires inclusive-exclusive, thus -1
+				args = append(args, opts.Before-1)
+			} else {
+				args = append(args, 1<<31-1)
+			}
+		}
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	query, args := expandQuery("SELECT seqid,opt,COUNT(*) AS count,BOOL_OR(userid=?) AS mine FROM pollvotes WHERE topic=?"+
+		seqIdConstraint+" GROUP BY seqid,opt ORDER BY seqid,opt", args...)
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []t.MessageVote
+	for rows.Next() {
+		var vote t.MessageVote
+		if err = rows.Scan(&vote.SeqId, &vote.Option, &vote.Count, &vote.Mine); err != nil {
+			break
+		}
+		votes = append(votes, vote)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return votes, err
+}
+
+// MessageGetUserVotes returns up to 'limit' poll options chosen by individual users in messages of the topic
+// ordered by SeqId, user and option, starting after the vote 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserVotes(topic string, after *adp.UserVote, limit int) ([]adp.UserVote, error) {
+	query := "SELECT seqid,userid,opt FROM pollvotes WHERE topic=? "
+	args := []any{topic}
+	if after != nil {
+		userId := store.DecodeUid(t.ParseUid(after.User))
+		query += "AND (seqid>? OR (seqid=? AND (userid>? OR (userid=? AND opt>?)))) "
+		args = append(args, after.SeqId, after.SeqId, userId, userId, after.Option)
+	}
+	query += "ORDER BY seqid,userid,opt LIMIT ?"
+	args = append(args, limit)
+	query, args = expandQuery(query, args...)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []adp.UserVote
+	for rows.Next() {
+		var vote adp.UserVote
+		var userId int64
+		if err = rows.Scan(&vote.SeqId, &userId, &vote.Option); err != nil {
+			break
+		}
+		vote.User = store.EncodeUid(userId).String()
+		votes = append(votes, vote)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return votes, err
+}
+
+// MessageGetThreads returns reply counts and timestamps of the latest replies to messages matching the query.
+func (a *adapter) MessageGetThreads(topic string, opts *t.QueryOpt) ([]t.MessageThread, error) {
+	args := []any{topic}
+	parentConstraint := " AND parent>0"
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			constr, newargs := common.RangesToSql(opts.IdRanges)
+			parentConstraint = " AND parent " + constr
+			args = append(args, newargs...)
+		} else if opts.Since > 0 || opts.Before > 0 {
+			parentConstraint = " AND parent BETWEEN ? AND ?"
+			args = append(args, max(opts.Since, 1))
+			if opts.Before > 1 {
+				// BETWEEN is inclusive-inclusive, Tinode API requires inclusive-exclusive, thus -1
+				args = append(args, opts.Before-1)
+			} else {
+				args = append(args, 1<<31-1)
+			}
+		}
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	query, args := expandQuery("SELECT parent,COUNT(*),MAX(createdat) FROM messages WHERE topic=? AND delid=0"+
+		parentConstraint+" GROUP BY parent ORDER BY parent", args...)
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []t.MessageThread
+	for rows.Next() {
+		var thread t.MessageThread
+		if err = rows.Scan(&thread.SeqId, &thread.Replies, &thread.LastReplyAt); err != nil {
+			break
+		}
+		threads = append(threads, thread)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return threads, err
+}
+
+// ScheduledMessageSave saves a message for delivery at a later time.
+func (a *adapter) ScheduledMessageSave(msg *t.ScheduledMessage) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		`INSERT INTO scheduled(id,createdat,updatedat,deliverat,topic,userid,parent,head,content)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		store.DecodeUid(msg.Uid()), msg.CreatedAt, msg.UpdatedAt, msg.DeliverAt, msg.Topic,
+		store.DecodeUid(t.ParseUid(msg.From)), msg.Parent, msg.Head, common.ToJSON(msg.Content))
+	return err
+}
+
+// ScheduledMessageGetAll returns messages scheduled by the user in the topic ordered by delivery time.
+// If forUser is zero, up to maxResults messages scheduled by all users are returned.
+func (a *adapter) ScheduledMessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.ScheduledMessage, error) {
+	limit := a.maxMessageResults
+	if forUser.IsZero() {
+		limit = a.maxResults
+	}
+	if opts != nil && opts.Limit > 0 && opts.Limit < limit {
+		limit = opts.Limit
+	}
+	if forUser.IsZero() {
+		return a.scheduledGet("WHERE topic=?", []any{topic}, limit)
+	}
+	return a.scheduledGet("WHERE topic=? AND userid=?", []any{topic, store.DecodeUid(forUser)}, limit)
+}
+
+// ScheduledMessageGetDue returns up to 'limit' messages due for delivery before the given time
+// which are not claimed for delivery at that time.
+func (a *adapter) ScheduledMessageGetDue(before time.Time, limit int) ([]t.ScheduledMessage, error) {
+	return a.scheduledGet("WHERE deliverat<? AND (claimeduntil IS NULL OR claimeduntil<?)", []any{before, before}, limit)
+}
+
+// ScheduledMessageClaim claims the scheduled message for delivery until the given time.
+func (a *adapter) ScheduledMessageClaim(id string, now, until time.Time) error {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return t.ErrMalformed
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	res, err := a.db.Exec(ctx,
+		"UPDATE scheduled SET claimeduntil=$1 WHERE id=$2 AND (claimeduntil IS NULL OR claimeduntil<$3)",
+		until, store.DecodeUid(uid), now)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// scheduledGet fetches scheduled messages matching the condition ordered by delivery time.
+func (a *adapter) scheduledGet(where string, args []any, limit int) ([]t.ScheduledMessage, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	query, args := expandQuery("SELECT id,createdat,updatedat,deliverat,topic,userid,parent,head,content FROM scheduled "+
+		where+" ORDER BY deliverat,id LIMIT ?", append(args, limit)...)
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []t.ScheduledMessage
+	for rows.Next() {
+		var msg t.ScheduledMessage
+		var id, userId int64
+		if err = rows.Scan(&id, &msg.CreatedAt, &msg.UpdatedAt, &msg.DeliverAt, &msg.Topic, &userId,
+			&msg.Parent, &msg.Head, &msg.Content); err != nil {
+			break
+		}
+		msg.Id = store.EncodeUid(id).String()
+		msg.From = store.EncodeUid(userId).String()
+		msgs = append(msgs, msg)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return msgs, err
+}
+
+// ScheduledMessageDelete deletes a scheduled message. If forUser is not zero, the message must be
+// scheduled by that user.
+func (a *adapter) ScheduledMessageDelete(topic string, forUser t.Uid, id string) error {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return t.ErrMalformed
+	}
+
+	query := "DELETE FROM scheduled WHERE id=? AND topic=?"
+	args := []any{store.DecodeUid(uid), topic}
+	if !forUser.IsZero() {
+		query += " AND userid=?"
+		args = append(args, store.DecodeUid(forUser))
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	query, args = expandQuery(query, args...)
+	res, err := a.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// Get ranges of deleted messages
+func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
+	var limit = a.maxResults
+	var lower = 0
+	var upper = 1<<31 - 1
+
+	if opts != nil {
+		if opts.Since > 0 {
+			lower = opts.Since
+		}
+		if opts.Before > 1 {
+			// DelRange is inclusive-exclusive, while BETWEEN is inclusive-inclisive.
+			upper = opts.Before - 1
+		}
+
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	// Fetch log of deletions
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, "SELECT topic,deletedfor,delid,low,hi FROM dellog WHERE topic=$1 AND delid BETWEEN $2 AND $3"+
+		" AND (deletedFor=0 OR deletedFor=$4) ORDER BY delid LIMIT $5",
+		topic, lower, upper, store.DecodeUid(forUser), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dellog struct {
+		Topic      string
+		Deletedfor int64
+		Delid      int
+		Low        int
+		Hi         int
+	}
+	var dmsgs []t.DelMessage
+	var dmsg t.DelMessage
+	for rows.Next() {
+		if err = rows.Scan(&dellog.Topic, &dellog.Deletedfor, &dellog.Delid, &dellog.Low, &dellog.Hi); err != nil {
+			dmsgs = nil
+			break
+		}
+
+		if dellog.Delid != dmsg.DelId {
+			if dmsg.DelId > 0 {
+				dmsgs = append(dmsgs, dmsg)
+			}
+			dmsg.DelId = dellog.Delid
+			dmsg.Topic = dellog.Topic
+			if dellog.Deletedfor > 0 {
+				dmsg.DeletedFor = store.EncodeUid(dellog.Deletedfor).String()
+			} else {
+				dmsg.DeletedFor = ""
+			}
+			dmsg.SeqIdRanges = nil
+		}
+		if dellog.Hi <= dellog.Low+1 {
+			dellog.Hi = 0
+		}
+		dmsg.SeqIdRanges = append(dmsg.SeqIdRanges, t.Range{Low: dellog.Low, Hi: dellog.Hi})
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err == nil {
 		if dmsg.DelId > 0 {
 			dmsgs = append(dmsgs, dmsg)
 		}
 	}
-
-	return dmsgs, err
+
+	return dmsgs, err
+}
+
+// MessageGetExpired returns up to limit sorted IDs of not yet deleted messages created before the given time.
+func (a *adapter) MessageGetExpired(topic string, before time.Time, limit int) ([]int, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, "SELECT seqid FROM messages WHERE topic=$1 AND createdat<$2 AND deletedat IS NULL"+
+		" ORDER BY seqid LIMIT $3", topic, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seqIDs []int
+	for rows.Next() {
+		var seqID int
+		if err = rows.Scan(&seqID); err != nil {
+			break
+		}
+		seqIDs = append(seqIDs, seqID)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return seqIDs, err
+}
+
+func messageDeleteList(ctx context.Context, tx pgx.Tx, topic string, toDel *t.DelMessage) error {
+	var err error
+
+	if toDel == nil {
+		// Whole topic is being deleted, thus also deleting all messages.
+		_, err = tx.Exec(ctx, "DELETE FROM dellog WHERE topic=$1", topic)
+		if err == nil {
+			_, err = tx.Exec(ctx, "DELETE FROM messages WHERE topic=$1", topic)
+		}
+		// filemsglinks will be deleted because of ON DELETE CASCADE
+		return err
+	}
+
+	// Only some messages are being deleted
+
+	delRanges := toDel.SeqIdRanges
+
+	if toDel.DeletedFor == "" {
+		// Hard-deleting messages requires updates to the messages table.
+		where := "m.topic=? "
+		args := []any{topic}
+
+		if len(delRanges) > 0 {
+			rSql, rArgs := common.RangesToSql(delRanges)
+			where += " AND m.seqid " + rSql
+			args = append(args, rArgs...)
+		}
+
+		where += " AND m.deletedat IS NULL"
+
+		// We are asked to delete messages no older than newerThan.
+		if newerThan := toDel.GetNewerThan(); newerThan != nil {
+			where += " AND m.createdat>?"
+			args = append(args, newerThan)
+		}
+
+		// Find the actual IDs still present in the database.
+		var seqIDs []int
+		query, newargs := expandQuery("SELECT seqid FROM messages AS m WHERE "+where, args)
+		rows, err := tx.Query(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var seqID int
+			if err := rows.Scan(&seqID); err != nil {
+				return err
+			}
+			seqIDs = append(seqIDs, seqID)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if len(seqIDs) == 0 {
+			// Nothing to delete. No need to make a log entry. All done.
+			return nil
+		}
+
+		// Recalculate the actual ranges to delete.
+		sort.Ints(seqIDs)
+		delRanges = t.SliceToRanges(seqIDs)
+
+		// Compose a new query with the new ranges.
+		where = "m.topic=?"
+		args = []any{topic}
+		rSql, rArgs := common.RangesToSql(delRanges)
+		where += " AND m.seqid " + rSql
+		args = append(args, rArgs...)
+
+		// No need to add anything else: deletedat etc is already accounted for.
+
+		query, newargs = expandQuery("DELETE FROM filemsglinks AS fml USING messages AS m WHERE m.id=fml.msgid AND "+
+			where, args...)
+		_, err = tx.Exec(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+
+		// Delete previous versions of edited messages.
+		query, newargs = expandQuery("DELETE FROM msgedits AS e USING messages AS m WHERE m.id=e.msgid AND "+
+			where, args...)
+		_, err = tx.Exec(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+
+		// Delete reactions to messages.
+		query, newargs = expandQuery("DELETE FROM reactions AS r USING messages AS m WHERE m.id=r.msgid AND "+
+			where, args...)
+		_, err = tx.Exec(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+
+		// Delete votes in polls.
+		query, newargs = expandQuery("DELETE FROM pollvotes AS v USING messages AS m WHERE m.id=v.msgid AND "+
+			where, args...)
+		_, err = tx.Exec(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+
+		query, newargs = expandQuery(`UPDATE messages AS m SET deletedat=?,delid=?,"from"=0,head=NULL,content=NULL,searchtext=NULL WHERE `+
+			where, t.TimeNow(), toDel.DelId, args)
+		_, err = tx.Exec(ctx, query, newargs...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Now make log entries. Needed for both hard- and soft-deleting.
+
+	// Prepare statement is not needed because the driver prepares the statement on first use then caches it.
+	forUser := common.DecodeUidString(toDel.DeletedFor)
+	for _, rng := range toDel.SeqIdRanges {
+		if rng.Hi == 0 {
+			// Dellog must contain valid Low and *Hi*.
+			rng.Hi = rng.Low + 1
+		}
+
+		if _, err = tx.Exec(ctx, "INSERT INTO dellog(topic,deletedfor,delid,low,hi) VALUES($1,$2,$3,$4,$5)",
+			topic, forUser, toDel.DelId, rng.Low, rng.Hi); err != nil {
+			break
+		}
+	}
+
+	return err
+}
+
+// MessageDeleteList deletes messages in the given topic with seqIds from the list.
+func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) (err error) {
+	ctx, cancel := a.getContextForTx()
+	if cancel != nil {
+		defer cancel()
+	}
+	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err = messageDeleteList(ctx, tx, topic, toDel); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func deviceHasher(deviceID string) string {
+	// Generate custom key as [64-bit hash of device id] to ensure predictable
+	// length of the key
+	hasher := fnv.New64()
+	hasher.Write([]byte(deviceID))
+	return strconv.FormatUint(uint64(hasher.Sum64()), 16)
+}
+
+// Authenticated sessions.
+
+// Maximum length of the user agent stored in authsessions.
+const maxUserAgentLength = 255
+
+// AuthSessionCreate saves a new authenticated session.
+func (a *adapter) AuthSessionCreate(sess *t.AuthSession) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		`INSERT INTO authsessions(id,createdat,updatedat,expires,userid,deviceid,platform,useragent,remoteaddr)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		store.DecodeUid(sess.Uid()), sess.CreatedAt, sess.UpdatedAt, sess.Expires,
+		store.DecodeUid(t.ParseUid(sess.User)), sess.DeviceId, sess.Platform,
+		common.TruncateString(sess.UserAgent, maxUserAgentLength), sess.RemoteAddr)
+	return err
+}
+
+// AuthSessionUpdate updates the last seen time, expiration time and client details of an authenticated session.
+func (a *adapter) AuthSessionUpdate(sess *t.AuthSession) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	res, err := a.db.Exec(ctx,
+		"UPDATE authsessions SET updatedat=$1,expires=$2,deviceid=$3,platform=$4,useragent=$5,remoteaddr=$6 WHERE id=$7",
+		sess.UpdatedAt, sess.Expires, sess.DeviceId, sess.Platform,
+		common.TruncateString(sess.UserAgent, maxUserAgentLength), sess.RemoteAddr, store.DecodeUid(sess.Uid()))
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// AuthSessionGet returns the authenticated session with the given ID or nil if not found.
+func (a *adapter) AuthSessionGet(id string) (*t.AuthSession, error) {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return nil, t.ErrMalformed
+	}
+	sessions, err := a.authSessionGet("WHERE id=$1", store.DecodeUid(uid))
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+// AuthSessionGetAll returns authenticated sessions of the user which have not expired yet ordered by creation time.
+func (a *adapter) AuthSessionGetAll(uid t.Uid) ([]t.AuthSession, error) {
+	return a.authSessionGet("WHERE userid=$1 AND expires>$2 ORDER BY createdat,id", store.DecodeUid(uid), t.TimeNow())
+}
+
+func (a *adapter) authSessionGet(where string, args ...any) ([]t.AuthSession, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx,
+		"SELECT id,createdat,updatedat,expires,userid,deviceid,platform,useragent,remoteaddr FROM authsessions "+
+			where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []t.AuthSession
+	for rows.Next() {
+		var sess t.AuthSession
+		var id, userId int64
+		var deviceId, platform, userAgent, remoteAddr *string
+		if err = rows.Scan(&id, &sess.CreatedAt, &sess.UpdatedAt, &sess.Expires, &userId,
+			&deviceId, &platform, &userAgent, &remoteAddr); err != nil {
+			break
+		}
+		sess.Id = store.EncodeUid(id).String()
+		sess.User = store.EncodeUid(userId).String()
+		if deviceId != nil {
+			sess.DeviceId = *deviceId
+		}
+		if platform != nil {
+			sess.Platform = *platform
+		}
+		if userAgent != nil {
+			sess.UserAgent = *userAgent
+		}
+		if remoteAddr != nil {
+			sess.RemoteAddr = *remoteAddr
+		}
+		sessions = append(sessions, sess)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return sessions, err
+}
+
+// AuthSessionExpire deletes authenticated sessions of all users which expired before the given time.
+func (a *adapter) AuthSessionExpire(olderThan time.Time) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx, "DELETE FROM authsessions WHERE expires<$1", olderThan)
+	return err
+}
+
+// AuthSessionDelete deletes authenticated sessions of the user with the given IDs.
+func (a *adapter) AuthSessionDelete(uid t.Uid, ids []string) error {
+	var sids []any
+	for _, id := range ids {
+		sid := t.ParseUid(id)
+		if sid.IsZero() {
+			return t.ErrMalformed
+		}
+		sids = append(sids, store.DecodeUid(sid))
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	query, args := expandQuery("DELETE FROM authsessions WHERE userid=? AND id IN (?)", store.DecodeUid(uid), sids)
+	_, err := a.db.Exec(ctx, query, args...)
+	return err
+}
+
+// API keys.
+
+// APIKeyCreate saves a new API key.
+func (a *adapter) APIKeyCreate(key *t.APIKey) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		`INSERT INTO apikeys(id,createdat,updatedat,revokedat,name,origins,schemes,messages,ratelimit,rateburst)
+			VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		store.DecodeUid(key.Uid()), key.CreatedAt, key.UpdatedAt, key.RevokedAt, key.Name,
+		key.Origins, key.Schemes, key.Messages, key.RateLimit, key.RateBurst)
+	return err
+}
+
+// APIKeyUpdate updates an API key.
+func (a *adapter) APIKeyUpdate(key *t.APIKey) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	res, err := a.db.Exec(ctx,
+		`UPDATE apikeys SET updatedat=$1,revokedat=$2,name=$3,origins=$4,schemes=$5,messages=$6,ratelimit=$7,rateburst=$8
+			WHERE id=$9`,
+		key.UpdatedAt, key.RevokedAt, key.Name, key.Origins, key.Schemes, key.Messages, key.RateLimit, key.RateBurst,
+		store.DecodeUid(key.Uid()))
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return t.ErrNotFound
+	}
+	return nil
 }
 
-func messageDeleteList(ctx context.Context, tx pgx.Tx, topic string, toDel *t.DelMessage) error {
-	var err error
-
-	if toDel == nil {
-		// Whole topic is being deleted, thus also deleting all messages.
-		_, err = tx.Exec(ctx, "DELETE FROM dellog WHERE topic=$1", topic)
-		if err == nil {
-			_, err = tx.Exec(ctx, "DELETE FROM messages WHERE topic=$1", topic)
-		}
-		// filemsglinks will be deleted because of ON DELETE CASCADE
-		return err
+// APIKeyGet returns the API key with the given ID or nil if not found.
+func (a *adapter) APIKeyGet(id string) (*t.APIKey, error) {
+	uid := t.ParseUid(id)
+	if uid.IsZero() {
+		return nil, t.ErrMalformed
+	}
+	keys, err := a.apiKeyGet("WHERE id=$1", store.DecodeUid(uid))
+	if err != nil || len(keys) == 0 {
+		return nil, err
 	}
+	return &keys[0], nil
+}
 
-	// Only some messages are being deleted
+// APIKeyGetAll returns all API keys ordered by creation time.
+func (a *adapter) APIKeyGetAll() ([]t.APIKey, error) {
+	return a.apiKeyGet("ORDER BY createdat,id")
+}
 
-	delRanges := toDel.SeqIdRanges
+func (a *adapter) apiKeyGet(where string, args ...any) ([]t.APIKey, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
 
-	if toDel.DeletedFor == "" {
-		// Hard-deleting messages requires updates to the messages table.
-		where := "m.topic=? "
-		args := []any{topic}
+	rows, err := a.db.Query(ctx,
+		"SELECT id,createdat,updatedat,revokedat,name,origins,schemes,messages,ratelimit,rateburst FROM apikeys "+
+			where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		if len(delRanges) > 0 {
-			rSql, rArgs := common.RangesToSql(delRanges)
-			where += " AND m.seqid " + rSql
-			args = append(args, rArgs...)
+	var keys []t.APIKey
+	for rows.Next() {
+		var key t.APIKey
+		var id int64
+		if err = rows.Scan(&id, &key.CreatedAt, &key.UpdatedAt, &key.RevokedAt, &key.Name,
+			&key.Origins, &key.Schemes, &key.Messages, &key.RateLimit, &key.RateBurst); err != nil {
+			break
 		}
+		key.Id = store.EncodeUid(id).String()
+		keys = append(keys, key)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
 
-		where += " AND m.deletedat IS NULL"
+	return keys, err
+}
 
-		// We are asked to delete messages no older than newerThan.
-		if newerThan := toDel.GetNewerThan(); newerThan != nil {
-			where += " AND m.createdat>?"
-			args = append(args, newerThan)
-		}
+// Audit log.
 
-		// Find the actual IDs still present in the database.
-		var seqIDs []int
-		query, newargs := expandQuery("SELECT seqid FROM messages AS m WHERE "+where, args)
-		rows, err := tx.Query(ctx, query, newargs...)
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
+// AuditAdd appends a record to the audit log.
+func (a *adapter) AuditAdd(rec *t.AuditRecord) error {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	_, err := a.db.Exec(ctx,
+		"INSERT INTO auditlog(id,createdat,action,actor,userid,topic,remoteaddr,params) VALUES($1,$2,$3,$4,$5,$6,$7,$8)",
+		store.DecodeUid(t.ParseUid(rec.Id)), rec.CreatedAt, rec.Action, store.DecodeUid(t.ParseUid(rec.Actor)),
+		store.DecodeUid(t.ParseUid(rec.User)), rec.Topic, rec.RemoteAddr, rec.Params)
+	return err
+}
 
-		for rows.Next() {
-			var seqID int
-			if err := rows.Scan(&seqID); err != nil {
-				return err
-			}
-			seqIDs = append(seqIDs, seqID)
+// AuditGetAll returns records of the audit log matching the query, newest first.
+func (a *adapter) AuditGetAll(query *t.AuditQuery) ([]t.AuditRecord, error) {
+	var where []string
+	var args []any
+	limit := a.maxResults
+	if query != nil {
+		if !query.User.IsZero() {
+			uid := store.DecodeUid(query.User)
+			where = append(where, "(actor=? OR userid=?)")
+			args = append(args, uid, uid)
 		}
-		if err = rows.Err(); err != nil {
-			return err
+		if query.Action != "" {
+			where = append(where, "action=?")
+			args = append(args, query.Action)
 		}
-
-		if len(seqIDs) == 0 {
-			// Nothing to delete. No need to make a log entry. All done.
-			return nil
+		if query.Since != nil {
+			where = append(where, "createdat>=?")
+			args = append(args, *query.Since)
 		}
-
-		// Recalculate the actual ranges to delete.
-		sort.Ints(seqIDs)
-		delRanges = t.SliceToRanges(seqIDs)
-
-		// Compose a new query with the new ranges.
-		where = "m.topic=?"
-		args = []any{topic}
-		rSql, rArgs := common.RangesToSql(delRanges)
-		where += " AND m.seqid " + rSql
-		args = append(args, rArgs...)
-
-		// No need to add anything else: deletedat etc is already accounted for.
-
-		query, newargs = expandQuery("DELETE FROM filemsglinks AS fml USING messages AS m WHERE m.id=fml.msgid AND "+
-			where, args...)
-		_, err = tx.Exec(ctx, query, newargs...)
-		if err != nil {
-			return err
+		if query.Before != nil {
+			where = append(where, "createdat<?")
+			args = append(args, *query.Before)
 		}
-
-		query, newargs = expandQuery(`UPDATE messages AS m SET deletedat=?,delid=?,"from"=0,head=NULL,content=NULL WHERE `+
-			where, t.TimeNow(), toDel.DelId, args)
-		_, err = tx.Exec(ctx, query, newargs...)
-		if err != nil {
-			return err
+		if query.Limit > 0 && query.Limit < limit {
+			limit = query.Limit
 		}
 	}
 
-	// Now make log entries. Needed for both hard- and soft-deleting.
-
-	// Prepare statement is not needed because the driver prepares the statement on first use then caches it.
-	forUser := common.DecodeUidString(toDel.DeletedFor)
-	for _, rng := range toDel.SeqIdRanges {
-		if rng.Hi == 0 {
-			// Dellog must contain valid Low and *Hi*.
-			rng.Hi = rng.Low + 1
-		}
-
-		if _, err = tx.Exec(ctx, "INSERT INTO dellog(topic,deletedfor,delid,low,hi) VALUES($1,$2,$3,$4,$5)",
-			topic, forUser, toDel.DelId, rng.Low, rng.Hi); err != nil {
-			break
-		}
+	q := ""
+	if len(where) > 0 {
+		q = "WHERE " + strings.Join(where, " AND ")
 	}
+	return a.auditGet(q+" ORDER BY createdat DESC,id DESC LIMIT ?", append(args, limit)...)
+}
 
-	return err
+// AuditList returns up to limit records of the audit log ordered by ID, starting after the record with the given ID.
+func (a *adapter) AuditList(after string, limit int) ([]t.AuditRecord, error) {
+	if after == "" {
+		return a.auditGet("ORDER BY id LIMIT ?", limit)
+	}
+	uid := t.ParseUid(after)
+	if uid.IsZero() {
+		return nil, t.ErrMalformed
+	}
+	return a.auditGet("WHERE id>? ORDER BY id LIMIT ?", store.DecodeUid(uid), limit)
 }
 
-// MessageDeleteList deletes messages in the given topic with seqIds from the list.
-func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) (err error) {
-	ctx, cancel := a.getContextForTx()
+func (a *adapter) auditGet(where string, args ...any) ([]t.AuditRecord, error) {
+	ctx, cancel := a.getContext()
 	if cancel != nil {
 		defer cancel()
 	}
-	tx, err := a.db.BeginTx(ctx, pgx.TxOptions{})
+	query, args := expandQuery("SELECT id,createdat,action,actor,userid,topic,remoteaddr,params FROM auditlog "+
+		where, args...)
+	rows, err := a.db.Query(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	defer func() {
-		if err != nil {
-			tx.Rollback(ctx)
+	var records []t.AuditRecord
+	for rows.Next() {
+		var rec t.AuditRecord
+		var id, actor, user int64
+		if err = rows.Scan(&id, &rec.CreatedAt, &rec.Action, &actor, &user, &rec.Topic, &rec.RemoteAddr,
+			&rec.Params); err != nil {
+			break
 		}
-	}()
-
-	if err = messageDeleteList(ctx, tx, topic, toDel); err != nil {
-		return err
+		rec.Id = store.EncodeUid(id).String()
+		if actor != 0 {
+			rec.Actor = store.EncodeUid(actor).String()
+		}
+		if user != 0 {
+			rec.User = store.EncodeUid(user).String()
+		}
+		records = append(records, rec)
+	}
+	if err == nil {
+		err = rows.Err()
 	}
 
-	return tx.Commit(ctx)
-}
-
-func deviceHasher(deviceID string) string {
-	// Generate custom key as [64-bit hash of device id] to ensure predictable
-	// length of the key
-	hasher := fnv.New64()
-	hasher.Write([]byte(deviceID))
-	return strconv.FormatUint(uint64(hasher.Sum64()), 16)
+	return records, err
 }
 
 // Device management for push notifications
@@ -3381,6 +5106,49 @@ func (a *adapter) FileGet(fid string) (*t.FileDef, error) {
 	return &fd, nil
 }
 
+// FileGetAll returns records of completed uploads by the given user ordered by ID.
+func (a *adapter) FileGetAll(user, after t.Uid, limit int) ([]t.FileDef, error) {
+	query := "SELECT id,createdat,updatedat,status,mimetype,size,etag,location " +
+		"FROM fileuploads WHERE userid=? AND status=? "
+	args := []any{store.DecodeUid(user), t.UploadCompleted}
+	if !after.IsZero() {
+		query += "AND id>? "
+		args = append(args, store.DecodeUid(after))
+	}
+	query += "ORDER BY id LIMIT ?"
+	args = append(args, limit)
+	query, args = expandQuery(query, args...)
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []t.FileDef
+	for rows.Next() {
+		var fd t.FileDef
+		var id int64
+		if err = rows.Scan(&id, &fd.CreatedAt, &fd.UpdatedAt, &fd.Status, &fd.MimeType, &fd.Size,
+			&fd.ETag, &fd.Location); err != nil {
+			files = nil
+			break
+		}
+		fd.Id = store.EncodeUid(id).String()
+		fd.User = user.String()
+		files = append(files, fd)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return files, err
+}
+
 // FileDeleteUnused deletes file upload records.
 func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, error) {
 	ctx, cancel := a.getContextForTx()
@@ -3449,6 +5217,39 @@ func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, er
 	return locations, tx.Commit(ctx)
 }
 
+// FileDelete deletes the record of the file unless the file is in use. Returns FileDef.Location
+// of the deleted record so the actual file can be deleted too.
+func (a *adapter) FileDelete(fid string) (string, error) {
+	id := t.ParseUid(fid)
+	if id.IsZero() {
+		return "", t.ErrMalformed
+	}
+
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	var location string
+	err := a.db.QueryRow(ctx, "SELECT location FROM fileuploads WHERE id=$1", store.DecodeUid(id)).Scan(&location)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	res, err := a.db.Exec(ctx, "DELETE FROM fileuploads WHERE id=$1 AND "+
+		"NOT EXISTS (SELECT 1 FROM filemsglinks WHERE fileid=$1)", store.DecodeUid(id))
+	if err != nil {
+		return "", err
+	}
+	if res.RowsAffected() == 0 {
+		// The file is linked to a message, a topic or a user.
+		return "", nil
+	}
+	return location, nil
+}
+
 // FileLinkAttachments connects given topic or message to the file record IDs from the list.
 func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []string) error {
 	if len(fids) == 0 || (topic == "" && msgId.IsZero() && userId.IsZero()) {
@@ -3522,6 +5323,34 @@ func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []
 	return tx.Commit(ctx)
 }
 
+// FileGetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+func (a *adapter) FileGetMessageAttachments(topic string, seqId int) ([]string, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+	rows, err := a.db.Query(ctx, "SELECT fml.fileid FROM filemsglinks AS fml "+
+		"INNER JOIN messages AS m ON m.id=fml.msgid WHERE m.topic=$1 AND m.seqid=$2", topic, seqId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fids []string
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+		fids = append(fids, store.EncodeUid(id).String())
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return fids, err
+}
+
 // PCacheGet reads a persistet cache entry.
 func (a *adapter) PCacheGet(key string) (string, error) {
 	ctx, cancel := a.getContext()
@@ -3564,6 +5393,22 @@ func (a *adapter) PCacheUpsert(key string, value string, failOnDuplicate bool) e
 	return err
 }
 
+// PCacheSwap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+// Returns false if the entry does not exist or has a different value.
+func (a *adapter) PCacheSwap(key, oldValue, newValue string) (bool, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	res, err := a.db.Exec(ctx, `UPDATE kvmeta SET createdat=$1,"value"=$2 WHERE "key"=$3 AND "value"=$4`,
+		t.TimeNow(), newValue, key, oldValue)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() > 0, nil
+}
+
 // PCacheDelete deletes one persistent cache entry.
 func (a *adapter) PCacheDelete(key string) error {
 	ctx, cancel := a.getContext()
@@ -3590,6 +5435,35 @@ func (a *adapter) PCacheExpire(keyPrefix string, olderThan time.Time) error {
 	return err
 }
 
+// PCacheList returns up to limit persistent cache entries ordered by key, starting after the given key.
+func (a *adapter) PCacheList(after string, limit int) ([]adp.PCacheEntry, error) {
+	ctx, cancel := a.getContext()
+	if cancel != nil {
+		defer cancel()
+	}
+
+	rows, err := a.db.Query(ctx,
+		`SELECT "key","value",createdat FROM kvmeta WHERE "key">$1 AND "key"<>'version' ORDER BY "key" LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []adp.PCacheEntry
+	for rows.Next() {
+		var entry adp.PCacheEntry
+		if err = rows.Scan(&entry.Key, &entry.Value, &entry.CreatedAt); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return entries, err
+}
+
 // GetTestDB returns a currently open database connection.
 func (a *adapter) GetTestDB() any {
 	return a.db
@@ -8,12 +8,14 @@ import (
 	"encoding/json"
 	"errors"
 	"hash/fnv"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tinode/chat/server/auth"
+	adp "github.com/tinode/chat/server/db"
 	"github.com/tinode/chat/server/db/common"
 	"github.com/tinode/chat/server/logs"
 	"github.com/tinode/chat/server/store"
@@ -33,7 +35,7 @@ type adapter struct {
 }
 
 const (
-	adpVersion  = 116
+	adpVersion  = 129
 	adapterName = "rethinkdb"
 
 	defaultHost     = "localhost:28015"
@@ -351,6 +353,10 @@ func (a *adapter) CreateDb(reset bool) error {
 		}).RunWrite(a.conn); err != nil {
 		return err
 	}
+	// Compound index of replies to messages.
+	if err := a.createParentIndex(); err != nil {
+		return err
+	}
 	// Compound multi-index of soft-deleted messages: each message gets multiple compound index entries like
 	// [Topic, User1, DelId1], [Topic, User2, DelId2],...
 	if _, err := rdb.DB(a.dbName).Table("messages").IndexCreateFunc("Topic_DeletedFor",
@@ -362,6 +368,41 @@ func (a *adapter) CreateDb(reset bool) error {
 		return err
 	}
 
+	// Previous versions of edited messages
+	if err := a.createMsgEditsTable(); err != nil {
+		return err
+	}
+
+	// Reactions to messages
+	if err := a.createReactionsTable(); err != nil {
+		return err
+	}
+
+	// Votes in polls
+	if err := a.createPollVotesTable(); err != nil {
+		return err
+	}
+
+	// Messages scheduled for delivery at a later time
+	if err := a.createScheduledTable(); err != nil {
+		return err
+	}
+
+	// Logins on devices
+	if err := a.createAuthSessionsTable(); err != nil {
+		return err
+	}
+
+	// API keys
+	if err := a.createAPIKeysTable(); err != nil {
+		return err
+	}
+
+	// Audit log
+	if err := a.createAuditLogTable(); err != nil {
+		return err
+	}
+
 	// Log of deleted messages
 	if _, err := rdb.DB(a.dbName).TableCreate("dellog", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn); err != nil {
 		return err
@@ -560,6 +601,159 @@ func (a *adapter) UpgradeDb() error {
 		}
 	}
 
+	if a.version == 116 {
+		// Perform database upgrade from version 116 to version 117.
+
+		// Index existing messages for search.
+		if err := a.messagesFillSearchText(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 117); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 117 {
+		// Perform database upgrade from version 117 to version 118.
+
+		// Add table for previous versions of edited messages.
+		if err := a.createMsgEditsTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 118); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 118 {
+		// Perform database upgrade from version 118 to version 119.
+
+		// Add table for reactions to messages.
+		if err := a.createReactionsTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 119); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 119 {
+		// Perform database upgrade from version 119 to version 120.
+
+		// Add index of replies to messages.
+		if err := a.createParentIndex(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 120); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 120 {
+		// Just bump the version to keep up with MySQL.
+		if err := bumpVersion(a, 121); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 121 {
+		// Perform database upgrade from version 121 to version 122.
+
+		// Add table for messages scheduled for delivery at a later time.
+		if err := a.createScheduledTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 122); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 122 {
+		// Just bump the version to keep up with MySQL.
+		if err := bumpVersion(a, 123); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 123 {
+		// Perform database upgrade from version 123 to version 124.
+
+		// Add table for votes in polls.
+		if err := a.createPollVotesTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 124); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 124 {
+		// Just bump the version to keep up with MySQL.
+		if err := bumpVersion(a, 125); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 125 {
+		// Perform database upgrade from version 125 to version 126.
+
+		// Add table for authenticated sessions.
+		if err := a.createAuthSessionsTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 126); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 126 {
+		// Perform database upgrade from version 126 to version 127.
+
+		// Add table for API keys.
+		if err := a.createAPIKeysTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 127); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 127 {
+		// Perform database upgrade from version 127 to version 128.
+
+		// Add table for the audit log.
+		if err := a.createAuditLogTable(); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 128); err != nil {
+			return err
+		}
+	}
+
+	if a.version == 128 {
+		// Perform database upgrade from version 128 to version 129.
+
+		// Time when the secret was changed, for expiring old passwords. The time of existing
+		// secrets is unknown, the upgrade time is used instead.
+		if _, err := rdb.DB(a.dbName).Table("auth").Filter(rdb.Row.HasFields("updatedat").Not()).
+			Update(map[string]any{"updatedat": t.TimeNow()}).RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		if err := bumpVersion(a, 129); err != nil {
+			return err
+		}
+	}
+
 	if a.version != adpVersion {
 		return errors.New("Failed to perform database upgrade to version " + strconv.Itoa(adpVersion) +
 			". DB is still at " + strconv.Itoa(a.version))
@@ -567,6 +761,149 @@ func (a *adapter) UpgradeDb() error {
 	return nil
 }
 
+// createParentIndex creates a compound index of topic - parent SeqId for selecting replies to messages.
+func (a *adapter) createParentIndex() error {
+	_, err := rdb.DB(a.dbName).Table("messages").IndexCreateFunc("Topic_Parent",
+		func(row rdb.Term) any {
+			return []any{row.Field("Topic"), row.Field("Parent").Default(0)}
+		}).RunWrite(a.conn)
+	return err
+}
+
+// createMsgEditsTable creates a table for previous versions of edited messages.
+func (a *adapter) createMsgEditsTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("msgedits").RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Compound index of topic - seqID for selecting revisions of messages in a topic.
+	if _, err := rdb.DB(a.dbName).Table("msgedits").IndexCreateFunc("Topic_SeqId",
+		func(row rdb.Term) any {
+			return []any{row.Field("Topic"), row.Field("SeqId")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createReactionsTable creates a table for reactions to messages.
+func (a *adapter) createReactionsTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("reactions", rdb.TableCreateOpts{PrimaryKey: "Id"}).
+		RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Compound index of topic - seqID for selecting reactions to messages in a topic.
+	if _, err := rdb.DB(a.dbName).Table("reactions").IndexCreateFunc("Topic_SeqId",
+		func(row rdb.Term) any {
+			return []any{row.Field("Topic"), row.Field("SeqId")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for deleting reactions of a user.
+	if _, err := rdb.DB(a.dbName).Table("reactions").IndexCreate("User").RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createPollVotesTable creates a table for votes in polls.
+func (a *adapter) createPollVotesTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("pollvotes", rdb.TableCreateOpts{PrimaryKey: "Id"}).
+		RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Compound index of topic - seqID for counting votes in polls in a topic.
+	if _, err := rdb.DB(a.dbName).Table("pollvotes").IndexCreateFunc("Topic_SeqId",
+		func(row rdb.Term) any {
+			return []any{row.Field("Topic"), row.Field("SeqId")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for deleting votes of a user.
+	if _, err := rdb.DB(a.dbName).Table("pollvotes").IndexCreate("User").RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createScheduledTable creates a table for messages scheduled for delivery at a later time.
+func (a *adapter) createScheduledTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("scheduled", rdb.TableCreateOpts{PrimaryKey: "Id"}).
+		RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for finding messages due for delivery.
+	if _, err := rdb.DB(a.dbName).Table("scheduled").IndexCreate("DeliverAt").RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Compound index of topic - user for listing messages scheduled by a user in a topic.
+	if _, err := rdb.DB(a.dbName).Table("scheduled").IndexCreateFunc("Topic_From",
+		func(row rdb.Term) any {
+			return []any{row.Field("Topic"), row.Field("From")}
+		}).RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for deleting messages scheduled by a user.
+	if _, err := rdb.DB(a.dbName).Table("scheduled").IndexCreate("From").RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createAuthSessionsTable creates a table for logins on devices.
+func (a *adapter) createAuthSessionsTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("authsessions", rdb.TableCreateOpts{PrimaryKey: "Id"}).
+		RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for listing and deleting sessions of a user.
+	if _, err := rdb.DB(a.dbName).Table("authsessions").IndexCreate("User").RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createAPIKeysTable creates a table for API keys.
+func (a *adapter) createAPIKeysTable() error {
+	_, err := rdb.DB(a.dbName).TableCreate("apikeys", rdb.TableCreateOpts{PrimaryKey: "Id"}).RunWrite(a.conn)
+	return err
+}
+
+// createAuditLogTable creates a table for the audit log.
+func (a *adapter) createAuditLogTable() error {
+	if _, err := rdb.DB(a.dbName).TableCreate("auditlog", rdb.TableCreateOpts{PrimaryKey: "Id"}).
+		RunWrite(a.conn); err != nil {
+		return err
+	}
+	// Index for reading the log in chronological order.
+	if _, err := rdb.DB(a.dbName).Table("auditlog").IndexCreate("CreatedAt").RunWrite(a.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// messagesFillSearchText populates SearchText field of messages saved before the field was added.
+func (a *adapter) messagesFillSearchText() error {
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Filter(rdb.Row.HasFields("DelId").Not().And(rdb.Row.HasFields("SearchText").Not())).
+		Pluck("Id", "Content").Run(a.conn)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var msg t.Message
+	for cursor.Next(&msg) {
+		if _, err = rdb.DB(a.dbName).Table("messages").Get(msg.Id).
+			Update(map[string]any{"SearchText": common.MessageSearchText(msg.Content)}).
+			RunWrite(a.conn); err != nil {
+			return err
+		}
+		msg = t.Message{}
+	}
+
+	return cursor.Err()
+}
+
 // Create system topic 'sys'.
 func createSystemTopic(a *adapter) error {
 	now := t.TimeNow()
@@ -599,7 +936,9 @@ func (a *adapter) AuthAddRecord(uid t.Uid, scheme, unique string, authLvl auth.L
 			Scheme:  scheme,
 			AuthLvl: authLvl,
 			Secret:  secret,
-			Expires: expires}).RunWrite(a.conn)
+			Expires: expires,
+
+			UpdatedAt: t.TimeNow()}).RunWrite(a.conn)
 	if err != nil {
 		if rdb.IsConflictErr(err) {
 			return t.ErrDuplicate
@@ -661,6 +1000,7 @@ func (a *adapter) AuthUpdRecord(uid t.Uid, scheme, unique string, authLvl auth.L
 		}
 		if len(secret) > 0 {
 			upd["secret"] = secret
+			upd["updatedat"] = t.TimeNow()
 		}
 		if !expires.IsZero() {
 			upd["expires"] = expires
@@ -714,6 +1054,56 @@ func (a *adapter) AuthGetRecord(uid t.Uid, scheme string) (string, auth.Level, [
 	return record.Unique, record.AuthLvl, record.Secret, record.Expires, nil
 }
 
+// AuthGetUpdated returns the time when the secret of the authentication record was last changed.
+func (a *adapter) AuthGetUpdated(uid t.Uid, scheme string) (time.Time, error) {
+	// Default() is needed to prevent Pluck from returning an error
+	cursor, err := rdb.DB(a.dbName).Table("auth").GetAllByIndex("userid", uid.String()).
+		Filter(map[string]any{"scheme": scheme}).
+		Pluck("updatedat").Default(nil).Run(a.conn)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cursor.Close()
+
+	if cursor.IsNil() {
+		return time.Time{}, t.ErrNotFound
+	}
+
+	var record struct {
+		UpdatedAt time.Time `json:"updatedat"`
+	}
+	if err = cursor.One(&record); err != nil {
+		return time.Time{}, err
+	}
+	return record.UpdatedAt.UTC(), nil
+}
+
+// AuthGetAllRecords returns all authentication records of the given user ordered by scheme.
+func (a *adapter) AuthGetAllRecords(uid t.Uid) ([]adp.AuthRecord, error) {
+	cursor, err := rdb.DB(a.dbName).Table("auth").GetAllByIndex("userid", uid.String()).
+		OrderBy("scheme").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var records []adp.AuthRecord
+	var record common.AuthRecord
+	for cursor.Next(&record) {
+		records = append(records, adp.AuthRecord{
+			Scheme:  record.Scheme,
+			Unique:  record.Unique,
+			AuthLvl: record.AuthLvl,
+			Secret:  record.Secret,
+			// Convert to UTC (bug? in gorethink).
+			Expires: record.Expires.UTC(),
+		})
+		record = common.AuthRecord{}
+	}
+
+	return records, cursor.Err()
+}
+
 // AuthGetUniqueRecord retrieve user's authentication record by unique value (e.g. by login).
 func (a *adapter) AuthGetUniqueRecord(unique string) (t.Uid, auth.Level, []byte, time.Time, error) {
 	// Default() is needed to prevent Pluck from returning an error
@@ -792,6 +1182,38 @@ func (a *adapter) UserGetAll(ids ...t.Uid) ([]t.User, error) {
 	return users, cursor.Err()
 }
 
+// UserList returns up to limit users ordered by ID, starting after the user with the given ID.
+// Suspended and soft-deleted users are included.
+func (a *adapter) UserList(after t.Uid, limit int) ([]t.User, error) {
+	var lower any = rdb.MinVal
+	if !after.IsZero() {
+		lower = after.String()
+	}
+	cursor, err := rdb.DB(a.dbName).Table("users").
+		Between(lower, rdb.MaxVal, rdb.BetweenOpts{LeftBound: "open"}).
+		OrderBy(rdb.OrderByOpts{Index: "Id"}).Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var users []t.User
+	var user t.User
+	for cursor.Next(&user) {
+		// Convert timestamps to UTC (gorethink returns them as +0000)
+		user.CreatedAt = user.CreatedAt.UTC()
+		user.UpdatedAt = user.UpdatedAt.UTC()
+		if user.StateAt != nil {
+			stateAt := user.StateAt.UTC()
+			user.StateAt = &stateAt
+		}
+		users = append(users, user)
+		user = t.User{}
+	}
+
+	return users, cursor.Err()
+}
+
 // UserDelete deletes user record.
 func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 	// Get a list of topic names owned by the user (as 'grp' and 'chn').
@@ -817,6 +1239,24 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 			return err
 		}
 
+		// Delete user's reactions to messages in all topics.
+		if _, err = rdb.DB(a.dbName).Table("reactions").GetAllByIndex("User", uid.String()).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		// Delete user's votes in polls in all topics.
+		if _, err = rdb.DB(a.dbName).Table("pollvotes").GetAllByIndex("User", uid.String()).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		// Delete messages scheduled by the user in all topics.
+		if _, err = rdb.DB(a.dbName).Table("scheduled").GetAllByIndex("From", uid.String()).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
 		// Can't delete user's messages in all topics because we cannot notify topics of such deletion.
 		// Just leave the messages marked as sent by "not found" user.
 
@@ -862,6 +1302,26 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 							[]any{topic.Field("Id"), rdb.MinVal},
 							[]any{topic.Field("Id"), rdb.MaxVal},
 							rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete(),
+						// Delete previous versions of edited messages
+						rdb.DB(a.dbName).Table("msgedits").Between(
+							[]any{topic.Field("Id"), rdb.MinVal},
+							[]any{topic.Field("Id"), rdb.MaxVal},
+							rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete(),
+						// Delete reactions to messages
+						rdb.DB(a.dbName).Table("reactions").Between(
+							[]any{topic.Field("Id"), rdb.MinVal},
+							[]any{topic.Field("Id"), rdb.MaxVal},
+							rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete(),
+						// Delete votes in polls
+						rdb.DB(a.dbName).Table("pollvotes").Between(
+							[]any{topic.Field("Id"), rdb.MinVal},
+							[]any{topic.Field("Id"), rdb.MaxVal},
+							rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete(),
+						// Delete scheduled messages
+						rdb.DB(a.dbName).Table("scheduled").Between(
+							[]any{topic.Field("Id"), rdb.MinVal},
+							[]any{topic.Field("Id"), rdb.MaxVal},
+							rdb.BetweenOpts{Index: "Topic_From"}).Delete(),
 						// Delete subscriptions
 						rdb.DB(a.dbName).Table("subscriptions").
 							GetAllByIndex("Topic", topic.Field("Id")).Delete(),
@@ -882,6 +1342,12 @@ func (a *adapter) UserDelete(uid t.Uid, hard bool) error {
 			return err
 		}
 
+		// Delete authenticated sessions.
+		if _, err = rdb.DB(a.dbName).Table("authsessions").GetAllByIndex("User", uid.String()).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
 		// Delete credentials.
 		if err = a.CredDel(uid, "", ""); err != nil && err != t.ErrNotFound {
 			return err
@@ -1393,6 +1859,39 @@ func (a *adapter) TopicGet(topic string) (*t.Topic, error) {
 	return tt, nil
 }
 
+// TopicList returns up to limit topics ordered by name, starting after the topic with the given name.
+// Deleted topics are included.
+func (a *adapter) TopicList(after string, limit int) ([]t.Topic, error) {
+	var lower any = rdb.MinVal
+	if after != "" {
+		lower = after
+	}
+	cursor, err := rdb.DB(a.dbName).Table("topics").
+		Between(lower, rdb.MaxVal, rdb.BetweenOpts{LeftBound: "open"}).
+		OrderBy(rdb.OrderByOpts{Index: "Id"}).Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var topics []t.Topic
+	var tt t.Topic
+	for cursor.Next(&tt) {
+		// RethinkDB go driver incorrectly converts UTC timezone to +0000
+		tt.CreatedAt = tt.CreatedAt.UTC()
+		tt.UpdatedAt = tt.UpdatedAt.UTC()
+		tt.TouchedAt = tt.TouchedAt.UTC()
+		if tt.StateAt != nil {
+			stateAt := tt.StateAt.UTC()
+			tt.StateAt = &stateAt
+		}
+		topics = append(topics, tt)
+		tt = t.Topic{}
+	}
+
+	return topics, cursor.Err()
+}
+
 // TopicsForUser loads user's contact list: p2p and grp topics, except for 'me' & 'fnd' subscriptions.
 // Reads and denormalizes Public value.
 func (a *adapter) TopicsForUser(uid t.Uid, keepDeleted bool, opts *t.QueryOpt) ([]t.Subscription, error) {
@@ -1761,6 +2260,12 @@ func (a *adapter) TopicDelete(topic string, isChan, hard bool) error {
 		if err = a.MessageDeleteList(topic, nil); err != nil {
 			return err
 		}
+		if _, err = rdb.DB(a.dbName).Table("scheduled").Between(
+			[]any{topic, rdb.MinVal},
+			[]any{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_From"}).Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
 	}
 
 	// Must use GetAll to produce array result expected by decFileUseCounter.
@@ -1835,6 +2340,24 @@ func (a *adapter) TopicOwnerChange(topic string, newOwner t.Uid) error {
 	return err
 }
 
+// TopicsWithMsgTTL returns names and message TTLs of topics which have message TTL set.
+func (a *adapter) TopicsWithMsgTTL() ([]t.Topic, error) {
+	cursor, err := rdb.DB(a.dbName).Table("topics").
+		Filter(rdb.Row.Field("MsgTTL").Default(0).Gt(0).And(rdb.Row.Field("State").Eq(t.StateDeleted).Not())).
+		Pluck("Id", "MsgTTL").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var topics []t.Topic
+	if err = cursor.All(&topics); err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
 // SubscriptionGet returns a subscription of a user to a topic
 func (a *adapter) SubscriptionGet(topic string, user t.Uid, keepDeleted bool) (*t.Subscription, error) {
 
@@ -2218,15 +2741,34 @@ func (a *adapter) FindOne(tag string) (string, error) {
 
 // Messages
 
+// messageRecord is a message as stored in the database: the message itself and its
+// plain text used for search.
+type messageRecord struct {
+	t.Message
+	SearchText string `rethinkdb:"SearchText,omitempty"`
+}
+
 // MessageSave saves message to DB.
 func (a *adapter) MessageSave(msg *t.Message) error {
-	_, err := rdb.DB(a.dbName).Table("messages").Insert(msg).RunWrite(a.conn)
+	_, err := rdb.DB(a.dbName).Table("messages").Insert(&messageRecord{
+		Message:    *msg,
+		SearchText: common.MessageSearchText(msg.Content),
+	}).RunWrite(a.conn)
 	return err
 }
 
 // MessageGetAll retrieves all messages available to the given user.
 func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, "", opts)
+}
+
+// MessageSearch retrieves messages available to the given user which contain the search string.
+func (a *adapter) MessageSearch(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
+	return a.messagesGet(topic, forUser, search, opts)
+}
 
+// messagesGet retrieves messages matching the query, optionally constrained by the search string.
+func (a *adapter) messagesGet(topic string, forUser t.Uid, search string, opts *t.QueryOpt) ([]t.Message, error) {
 	var limit = a.maxMessageResults
 	var lower, upper any
 
@@ -2241,42 +2783,684 @@ func (a *adapter) MessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) (
 			upper = opts.Before
 		}
 
-		if opts.Limit > 0 && opts.Limit < limit {
-			limit = opts.Limit
-		}
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	lower = []any{topic, lower}
+	upper = []any{topic, upper}
+
+	requester := forUser.String()
+	query := rdb.DB(a.dbName).Table("messages").
+		Between(lower, upper, rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		// Ordering by index must come before filtering
+		OrderBy(rdb.OrderByOpts{Index: rdb.Desc("Topic_SeqId")}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		// Skip messages soft-deleted for the current user
+		Filter(func(row rdb.Term) any {
+			return rdb.Not(row.Field("DeletedFor").Default([]any{}).Contains(
+				func(df rdb.Term) any {
+					return df.Field("User").Eq(requester)
+				}))
+		})
+	if opts != nil && opts.Thread > 0 {
+		// Keep only replies to the given message.
+		query = query.Filter(func(row rdb.Term) any {
+			return row.Field("Parent").Default(0).Eq(opts.Thread)
+		})
+	}
+	if search != "" {
+		// Keep only messages which contain the search string.
+		pattern := regexp.QuoteMeta(strings.ToLower(search))
+		query = query.Filter(func(row rdb.Term) any {
+			return row.Field("SearchText").Default("").Match(pattern)
+		})
+	}
+	cursor, err := query.Without("SearchText").Limit(limit).Run(a.conn)
+
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var msgs []t.Message
+	if err = cursor.All(&msgs); err != nil {
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MessageEdit saves the current version of the message as a revision and replaces message head and content.
+func (a *adapter) MessageEdit(msg *t.Message, rev *t.MessageRevision) error {
+	resp, err := rdb.DB(a.dbName).Table("messages").
+		GetAllByIndex("Topic_SeqId", []any{msg.Topic, msg.SeqId}).
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		Update(map[string]any{
+			"UpdatedAt":  msg.UpdatedAt,
+			"Head":       msg.Head,
+			"Content":    msg.Content,
+			"SearchText": common.MessageSearchText(msg.Content),
+		}).RunWrite(a.conn)
+	if err != nil {
+		return err
+	}
+	if resp.Replaced+resp.Unchanged == 0 {
+		return t.ErrNotFound
+	}
+
+	_, err = rdb.DB(a.dbName).Table("msgedits").Insert(rev).RunWrite(a.conn)
+	return err
+}
+
+// MessageEditSave saves a previous version of the message without changing the message.
+func (a *adapter) MessageEditSave(rev *t.MessageRevision) error {
+	if err := a.messageExists(rev.Topic, rev.SeqId); err != nil {
+		return err
+	}
+	_, err := rdb.DB(a.dbName).Table("msgedits").Insert(rev).RunWrite(a.conn)
+	return err
+}
+
+// messageExists returns ErrNotFound if the message does not exist or is deleted.
+func (a *adapter) messageExists(topic string, seqId int) error {
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		GetAllByIndex("Topic_SeqId", []any{topic, seqId}).
+		Filter(rdb.Row.HasFields("DelId").Not()).Count().Run(a.conn)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var count int
+	if err = cursor.One(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// MessageGetEdits returns previous versions of messages matching the query.
+func (a *adapter) MessageGetEdits(topic string, opts *t.QueryOpt) ([]t.MessageRevision, error) {
+	var limit = a.maxResults
+	var lower, upper any = rdb.MinVal, rdb.MaxVal
+	var ranges []t.Range
+
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			// Select the overall range, then filter by individual ranges.
+			ranges = opts.IdRanges
+			lower = ranges[0].Low
+			if last := ranges[len(ranges)-1]; last.Hi > 0 {
+				upper = last.Hi
+			} else {
+				upper = last.Low + 1
+			}
+		} else {
+			if opts.Since > 0 {
+				lower = opts.Since
+			}
+			if opts.Before > 0 {
+				upper = opts.Before
+			}
+		}
+
+		if opts.Limit > 0 && opts.Limit < limit {
+			limit = opts.Limit
+		}
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("msgedits").
+		Between([]any{topic, lower}, []any{topic, upper}, rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		OrderBy(rdb.Desc("SeqId"), rdb.Desc("Rev")).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var revs []t.MessageRevision
+	var rev t.MessageRevision
+	for len(revs) < limit && cursor.Next(&rev) {
+		if ranges == nil || rangesContain(ranges, rev.SeqId) {
+			revs = append(revs, rev)
+		}
+		rev = t.MessageRevision{}
+	}
+
+	return revs, cursor.Err()
+}
+
+// reactionRecord is a reaction to a message as stored in the 'reactions' table.
+type reactionRecord struct {
+	// Primary key composed as "topic:seqid:user".
+	Id        string
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	User      string
+	Value     string
+}
+
+// MessageReact adds or replaces user's reaction to a message. Empty reaction removes it.
+func (a *adapter) MessageReact(topic string, seqId int, user t.Uid, reaction string) error {
+	id := topic + ":" + strconv.Itoa(seqId) + ":" + user.String()
+	if reaction == "" {
+		_, err := rdb.DB(a.dbName).Table("reactions").Get(id).Delete().RunWrite(a.conn)
+		return err
+	}
+
+	if err := a.messageExists(topic, seqId); err != nil {
+		return err
+	}
+
+	_, err := rdb.DB(a.dbName).Table("reactions").Insert(&reactionRecord{
+		Id:        id,
+		CreatedAt: t.TimeNow(),
+		Topic:     topic,
+		SeqId:     seqId,
+		User:      user.String(),
+		Value:     reaction,
+	}, rdb.InsertOpts{Conflict: "replace"}).RunWrite(a.conn)
+	return err
+}
+
+// MessageGetReactions returns aggregated reactions to messages matching the query.
+func (a *adapter) MessageGetReactions(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageReaction, error) {
+	var lower, upper any = rdb.MinVal, rdb.MaxVal
+	var ranges []t.Range
+
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			// Select the overall range, then filter by individual ranges.
+			ranges = opts.IdRanges
+			lower = ranges[0].Low
+			if last := ranges[len(ranges)-1]; last.Hi > 0 {
+				upper = last.Hi
+			} else {
+				upper = last.Low + 1
+			}
+		} else {
+			if opts.Since > 0 {
+				lower = opts.Since
+			}
+			if opts.Before > 0 {
+				upper = opts.Before
+			}
+		}
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("reactions").
+		Between([]any{topic, lower}, []any{topic, upper}, rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	// Aggregate reactions by message and value.
+	type reactKey struct {
+		seqId int
+		value string
+	}
+	counts := make(map[reactKey]*t.MessageReaction)
+	var reacts []*t.MessageReaction
+	var rec reactionRecord
+	forUserStr := forUser.String()
+	for cursor.Next(&rec) {
+		if ranges == nil || rangesContain(ranges, rec.SeqId) {
+			key := reactKey{rec.SeqId, rec.Value}
+			react := counts[key]
+			if react == nil {
+				react = &t.MessageReaction{SeqId: rec.SeqId, Value: rec.Value}
+				counts[key] = react
+				reacts = append(reacts, react)
+			}
+			react.Count++
+			react.Mine = react.Mine || (forUserStr != "" && rec.User == forUserStr)
+		}
+		rec = reactionRecord{}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reacts, func(i, j int) bool {
+		if reacts[i].SeqId != reacts[j].SeqId {
+			return reacts[i].SeqId < reacts[j].SeqId
+		}
+		if reacts[i].Count != reacts[j].Count {
+			return reacts[i].Count > reacts[j].Count
+		}
+		return reacts[i].Value < reacts[j].Value
+	})
+
+	var result []t.MessageReaction
+	for _, react := range reacts {
+		result = append(result, *react)
+	}
+	return result, nil
+}
+
+// getBySeqId reads records of the given table with the 'Topic_SeqId' index for messages of the topic with
+// SeqIds greater than 'afterSeq'. Reads up to 'limit' records, plus the rest of the records of the last message:
+// records of the same message are not ordered by the index, so they are read in full.
+func getBySeqId[T any](a *adapter, table, topic string, afterSeq, limit int, seqId func(*T) int) ([]T, error) {
+	cursor, err := rdb.DB(a.dbName).Table(table).
+		Between([]any{topic, afterSeq + 1}, []any{topic, rdb.MaxVal}, rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_SeqId"}).Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var recs []T
+	if err = cursor.All(&recs); err != nil {
+		return nil, err
+	}
+
+	if len(recs) < limit {
+		return recs, nil
+	}
+
+	last := seqId(&recs[len(recs)-1])
+	for len(recs) > 0 && seqId(&recs[len(recs)-1]) == last {
+		recs = recs[:len(recs)-1]
+	}
+	rest, err := getForSeqId[T](a, table, topic, last)
+	if err != nil {
+		return nil, err
+	}
+	return append(recs, rest...), nil
+}
+
+// getForSeqId reads all records of the given table with the 'Topic_SeqId' index for one message of the topic.
+func getForSeqId[T any](a *adapter, table, topic string, seqId int) ([]T, error) {
+	cursor, err := rdb.DB(a.dbName).Table(table).
+		GetAllByIndex("Topic_SeqId", []any{topic, seqId}).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var recs []T
+	if err = cursor.All(&recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// MessageGetUserReactions returns up to 'limit' reactions of individual users to messages in the topic
+// ordered by SeqId and user, starting after the reaction 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserReactions(topic string, after *adp.UserReaction, limit int) ([]adp.UserReaction, error) {
+	var recs []reactionRecord
+	afterSeq := 0
+	if after != nil {
+		// Reactions to the message of the 'after' reaction which are not yet returned.
+		var err error
+		if recs, err = getForSeqId[reactionRecord](a, "reactions", topic, after.SeqId); err != nil {
+			return nil, err
+		}
+		afterSeq = after.SeqId
+	}
+	more, err := getBySeqId(a, "reactions", topic, afterSeq, limit,
+		func(rec *reactionRecord) int { return rec.SeqId })
+	if err != nil {
+		return nil, err
+	}
+	recs = append(recs, more...)
+
+	var reacts []adp.UserReaction
+	for _, rec := range recs {
+		if after != nil && rec.SeqId == after.SeqId && rec.User <= after.User {
+			continue
+		}
+		reacts = append(reacts, adp.UserReaction{SeqId: rec.SeqId, User: rec.User, Value: rec.Value})
+	}
+	sort.Slice(reacts, func(i, j int) bool {
+		if reacts[i].SeqId != reacts[j].SeqId {
+			return reacts[i].SeqId < reacts[j].SeqId
+		}
+		return reacts[i].User < reacts[j].User
+	})
+	if len(reacts) > limit {
+		reacts = reacts[:limit]
+	}
+	return reacts, nil
+}
+
+// pollVoteRecord is a vote in a poll as stored in the 'pollvotes' table.
+type pollVoteRecord struct {
+	// Primary key composed as "topic:seqid:user".
+	Id        string
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	User      string
+	// Indexes of the chosen options.
+	Opts []int
+}
+
+// MessageVote replaces user's vote in a poll with the given options. Empty list of options removes the vote.
+// The vote of a user is stored as a single document, so it's replaced atomically.
+func (a *adapter) MessageVote(topic string, seqId int, user t.Uid, opts []int) error {
+	id := topic + ":" + strconv.Itoa(seqId) + ":" + user.String()
+	if len(opts) == 0 {
+		_, err := rdb.DB(a.dbName).Table("pollvotes").Get(id).Delete().RunWrite(a.conn)
+		return err
+	}
+
+	if err := a.messageExists(topic, seqId); err != nil {
+		return err
+	}
+
+	_, err := rdb.DB(a.dbName).Table("pollvotes").Insert(&pollVoteRecord{
+		Id:        id,
+		CreatedAt: t.TimeNow(),
+		Topic:     topic,
+		SeqId:     seqId,
+		User:      user.String(),
+		Opts:      opts,
+	}, rdb.InsertOpts{Conflict: "replace"}).RunWrite(a.conn)
+	return err
+}
+
+// MessageGetUserVotes returns up to 'limit' poll options chosen by individual users in messages of the topic
+// ordered by SeqId, user and option, starting after the vote 'after'. If 'after' is nil, starts from the beginning.
+func (a *adapter) MessageGetUserVotes(topic string, after *adp.UserVote, limit int) ([]adp.UserVote, error) {
+	var recs []pollVoteRecord
+	afterSeq := 0
+	if after != nil {
+		// Votes in the poll of the 'after' vote which are not yet returned.
+		var err error
+		if recs, err = getForSeqId[pollVoteRecord](a, "pollvotes", topic, after.SeqId); err != nil {
+			return nil, err
+		}
+		afterSeq = after.SeqId
+	}
+	more, err := getBySeqId(a, "pollvotes", topic, afterSeq, limit,
+		func(rec *pollVoteRecord) int { return rec.SeqId })
+	if err != nil {
+		return nil, err
+	}
+	recs = append(recs, more...)
+
+	var votes []adp.UserVote
+	for _, rec := range recs {
+		for _, opt := range rec.Opts {
+			if after != nil && rec.SeqId == after.SeqId &&
+				(rec.User < after.User || (rec.User == after.User && opt <= after.Option)) {
+				continue
+			}
+			votes = append(votes, adp.UserVote{SeqId: rec.SeqId, User: rec.User, Option: opt})
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].SeqId != votes[j].SeqId {
+			return votes[i].SeqId < votes[j].SeqId
+		}
+		if votes[i].User != votes[j].User {
+			return votes[i].User < votes[j].User
+		}
+		return votes[i].Option < votes[j].Option
+	})
+	if len(votes) > limit {
+		votes = votes[:limit]
+	}
+	return votes, nil
+}
+
+// MessageGetVotes returns counts of votes for poll options in messages matching the query.
+func (a *adapter) MessageGetVotes(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.MessageVote, error) {
+	var lower, upper any = rdb.MinVal, rdb.MaxVal
+	var ranges []t.Range
+
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			// Select the overall range, then filter by individual ranges.
+			ranges = opts.IdRanges
+			lower = ranges[0].Low
+			if last := ranges[len(ranges)-1]; last.Hi > 0 {
+				upper = last.Hi
+			} else {
+				upper = last.Low + 1
+			}
+		} else {
+			if opts.Since > 0 {
+				lower = opts.Since
+			}
+			if opts.Before > 0 {
+				upper = opts.Before
+			}
+		}
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("pollvotes").
+		Between([]any{topic, lower}, []any{topic, upper}, rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	// Count votes by message and option.
+	type voteKey struct {
+		seqId  int
+		option int
+	}
+	counts := make(map[voteKey]*t.MessageVote)
+	var votes []*t.MessageVote
+	var rec pollVoteRecord
+	forUserStr := forUser.String()
+	for cursor.Next(&rec) {
+		if ranges == nil || rangesContain(ranges, rec.SeqId) {
+			for _, opt := range rec.Opts {
+				key := voteKey{rec.SeqId, opt}
+				vote := counts[key]
+				if vote == nil {
+					vote = &t.MessageVote{SeqId: rec.SeqId, Option: opt}
+					counts[key] = vote
+					votes = append(votes, vote)
+				}
+				vote.Count++
+				vote.Mine = vote.Mine || (forUserStr != "" && rec.User == forUserStr)
+			}
+		}
+		rec = pollVoteRecord{}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(votes, func(i, j int) bool {
+		if votes[i].SeqId != votes[j].SeqId {
+			return votes[i].SeqId < votes[j].SeqId
+		}
+		return votes[i].Option < votes[j].Option
+	})
+
+	result := make([]t.MessageVote, len(votes))
+	for i, vote := range votes {
+		result[i] = *vote
+	}
+	return result, nil
+}
+
+// MessageGetThreads returns reply counts and timestamps of the latest replies to messages matching the query.
+func (a *adapter) MessageGetThreads(topic string, opts *t.QueryOpt) ([]t.MessageThread, error) {
+	var lower, upper any = 1, rdb.MaxVal
+	var ranges []t.Range
+
+	if opts != nil {
+		if len(opts.IdRanges) > 0 {
+			// Select the overall range, then filter by individual ranges.
+			ranges = opts.IdRanges
+			lower = ranges[0].Low
+			if last := ranges[len(ranges)-1]; last.Hi > 0 {
+				upper = last.Hi
+			} else {
+				upper = last.Low + 1
+			}
+		} else {
+			if opts.Since > 0 {
+				lower = opts.Since
+			}
+			if opts.Before > 0 {
+				upper = opts.Before
+			}
+		}
+	}
+
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]any{topic, lower}, []any{topic, upper}, rdb.BetweenOpts{Index: "Topic_Parent"}).
+		// Skip hard-deleted messages
+		Filter(rdb.Row.HasFields("DelId").Not()).
+		Pluck("Parent", "CreatedAt").
+		Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	// Aggregate replies by parent message.
+	stats := make(map[int]*t.MessageThread)
+	var threads []*t.MessageThread
+	var rec struct {
+		Parent    int
+		CreatedAt time.Time
+	}
+	for cursor.Next(&rec) {
+		if rec.Parent > 0 && (ranges == nil || rangesContain(ranges, rec.Parent)) {
+			thread := stats[rec.Parent]
+			if thread == nil {
+				thread = &t.MessageThread{SeqId: rec.Parent}
+				stats[rec.Parent] = thread
+				threads = append(threads, thread)
+			}
+			thread.Replies++
+			if rec.CreatedAt.After(thread.LastReplyAt) {
+				thread.LastReplyAt = rec.CreatedAt
+			}
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].SeqId < threads[j].SeqId
+	})
+
+	var result []t.MessageThread
+	for _, thread := range threads {
+		result = append(result, *thread)
 	}
+	return result, nil
+}
 
-	lower = []any{topic, lower}
-	upper = []any{topic, upper}
+// ScheduledMessageSave saves a message for delivery at a later time.
+func (a *adapter) ScheduledMessageSave(msg *t.ScheduledMessage) error {
+	_, err := rdb.DB(a.dbName).Table("scheduled").Insert(msg).RunWrite(a.conn)
+	return err
+}
 
-	requester := forUser.String()
-	cursor, err := rdb.DB(a.dbName).Table("messages").
-		Between(lower, upper, rdb.BetweenOpts{Index: "Topic_SeqId"}).
-		// Ordering by index must come before filtering
-		OrderBy(rdb.OrderByOpts{Index: rdb.Desc("Topic_SeqId")}).
-		// Skip hard-deleted messages
-		Filter(rdb.Row.HasFields("DelId").Not()).
-		// Skip messages soft-deleted for the current user
-		Filter(func(row rdb.Term) any {
-			return rdb.Not(row.Field("DeletedFor").Default([]any{}).Contains(
-				func(df rdb.Term) any {
-					return df.Field("User").Eq(requester)
-				}))
-		}).Limit(limit).Run(a.conn)
+// ScheduledMessageGetAll returns messages scheduled by the user in the topic ordered by delivery time.
+// If forUser is zero, up to maxResults messages scheduled by all users are returned.
+func (a *adapter) ScheduledMessageGetAll(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.ScheduledMessage, error) {
+	limit := a.maxMessageResults
+	if forUser.IsZero() {
+		limit = a.maxResults
+	}
+	if opts != nil && opts.Limit > 0 && opts.Limit < limit {
+		limit = opts.Limit
+	}
+	q := rdb.DB(a.dbName).Table("scheduled")
+	if forUser.IsZero() {
+		q = q.Between([]any{topic, rdb.MinVal}, []any{topic, rdb.MaxVal}, rdb.BetweenOpts{Index: "Topic_From"})
+	} else {
+		q = q.GetAllByIndex("Topic_From", []any{topic, forUser.String()})
+	}
+	return a.scheduledGet(q.OrderBy("DeliverAt", "Id").Limit(limit))
+}
 
+// ScheduledMessageGetDue returns up to 'limit' messages due for delivery before the given time
+// which are not claimed for delivery at that time.
+func (a *adapter) ScheduledMessageGetDue(before time.Time, limit int) ([]t.ScheduledMessage, error) {
+	return a.scheduledGet(rdb.DB(a.dbName).Table("scheduled").
+		Between(rdb.MinVal, before, rdb.BetweenOpts{Index: "DeliverAt"}).
+		OrderBy(rdb.OrderByOpts{Index: "DeliverAt"}).
+		Filter(scheduledUnclaimed(before)).
+		Limit(limit))
+}
+
+// scheduledUnclaimed returns a predicate which selects scheduled messages not claimed for delivery at
+// the given time.
+func scheduledUnclaimed(now time.Time) func(rdb.Term) rdb.Term {
+	return func(row rdb.Term) rdb.Term {
+		return row.HasFields("ClaimedUntil").Not().Or(row.Field("ClaimedUntil").Lt(now))
+	}
+}
+
+// ScheduledMessageClaim claims the scheduled message for delivery until the given time.
+func (a *adapter) ScheduledMessageClaim(id string, now, until time.Time) error {
+	res, err := rdb.DB(a.dbName).Table("scheduled").Get(id).
+		Update(func(row rdb.Term) any {
+			return rdb.Branch(scheduledUnclaimed(now)(row), map[string]any{"ClaimedUntil": until}, map[string]any{})
+		}).RunWrite(a.conn)
+	if err != nil {
+		return err
+	}
+	if res.Replaced == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// scheduledGet fetches scheduled messages selected by the query.
+func (a *adapter) scheduledGet(q rdb.Term) ([]t.ScheduledMessage, error) {
+	cursor, err := q.Run(a.conn)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close()
 
-	var msgs []t.Message
+	var msgs []t.ScheduledMessage
 	if err = cursor.All(&msgs); err != nil {
 		return nil, err
 	}
-
 	return msgs, nil
 }
 
+// ScheduledMessageDelete deletes a scheduled message. If forUser is not zero, the message must be
+// scheduled by that user.
+func (a *adapter) ScheduledMessageDelete(topic string, forUser t.Uid, id string) error {
+	filter := map[string]any{"Topic": topic}
+	if !forUser.IsZero() {
+		filter["From"] = forUser.String()
+	}
+	res, err := rdb.DB(a.dbName).Table("scheduled").GetAll(id).Filter(filter).Delete().RunWrite(a.conn)
+	if err != nil {
+		return err
+	}
+	if res.Deleted == 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// rangesContain checks if the ID is inside one of the sorted ranges.
+func rangesContain(ranges []t.Range, id int) bool {
+	for _, r := range ranges {
+		if id == r.Low || (id > r.Low && id < r.Hi) {
+			return true
+		}
+	}
+	return false
+}
+
 // MessageGetDeleted returns ranges of deleted messages.
 func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOpt) ([]t.DelMessage, error) {
 	/*
@@ -2338,6 +3522,28 @@ func (a *adapter) MessageGetDeleted(topic string, forUser t.Uid, opts *t.QueryOp
 	return dmsgs, nil
 }
 
+// MessageGetExpired returns up to limit sorted IDs of not yet deleted messages created before the given time.
+func (a *adapter) MessageGetExpired(topic string, before time.Time, limit int) ([]int, error) {
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		Between([]any{topic, rdb.MinVal}, []any{topic, rdb.MaxVal},
+			rdb.BetweenOpts{Index: "Topic_SeqId"}).
+		OrderBy(rdb.OrderByOpts{Index: "Topic_SeqId"}).
+		// Skip already hard-deleted messages.
+		Filter(rdb.Row.HasFields("DelId").Not().And(rdb.Row.Field("CreatedAt").Lt(before))).
+		Limit(limit).Field("SeqId").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var seqIDs []int
+	if err = cursor.All(&seqIDs); err != nil {
+		return nil, err
+	}
+
+	return seqIDs, nil
+}
+
 // messagesHardDelete deletes all messages in the topic.
 func (a *adapter) messagesHardDelete(topic string) error {
 	var err error
@@ -2360,7 +3566,28 @@ func (a *adapter) messagesHardDelete(topic string) error {
 		return err
 	}
 
-	_, err = q.Delete().RunWrite(a.conn)
+	if _, err = q.Delete().RunWrite(a.conn); err != nil {
+		return err
+	}
+
+	if _, err = rdb.DB(a.dbName).Table("msgedits").Between(
+		[]any{topic, rdb.MinVal},
+		[]any{topic, rdb.MaxVal},
+		rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete().RunWrite(a.conn); err != nil {
+		return err
+	}
+
+	if _, err = rdb.DB(a.dbName).Table("reactions").Between(
+		[]any{topic, rdb.MinVal},
+		[]any{topic, rdb.MaxVal},
+		rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete().RunWrite(a.conn); err != nil {
+		return err
+	}
+
+	_, err = rdb.DB(a.dbName).Table("pollvotes").Between(
+		[]any{topic, rdb.MinVal},
+		[]any{topic, rdb.MaxVal},
+		rdb.BetweenOpts{Index: "Topic_SeqId"}).Delete().RunWrite(a.conn)
 
 	return err
 }
@@ -2442,9 +3669,27 @@ func (a *adapter) MessageDeleteList(topic string, toDel *t.DelMessage) error {
 			return err
 		}
 
+		// Delete previous versions of edited messages.
+		if _, err = rangeToQuery(delRanges, topic, rdb.DB(a.dbName).Table("msgedits")).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		// Delete reactions to messages.
+		if _, err = rangeToQuery(delRanges, topic, rdb.DB(a.dbName).Table("reactions")).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
+		// Delete votes in polls.
+		if _, err = rangeToQuery(delRanges, topic, rdb.DB(a.dbName).Table("pollvotes")).
+			Delete().RunWrite(a.conn); err != nil {
+			return err
+		}
+
 		// Hard-delete individual messages. The messages are not deleted but all fields with personal content
 		// are removed.
-		if _, err = query.Replace(rdb.Row.Without("Head", "From", "Content", "Attachments").Merge(
+		if _, err = query.Replace(rdb.Row.Without("Head", "From", "Content", "SearchText", "Attachments").Merge(
 			map[string]any{
 				"DeletedAt": t.TimeNow(), "DelId": toDel.DelId})).
 			RunWrite(a.conn); err != nil {
@@ -2484,6 +3729,224 @@ func deviceHasher(deviceID string) string {
 	return strconv.FormatUint(uint64(hasher.Sum64()), 16)
 }
 
+// Authenticated sessions.
+
+// AuthSessionCreate saves a new authenticated session.
+func (a *adapter) AuthSessionCreate(sess *t.AuthSession) error {
+	_, err := rdb.DB(a.dbName).Table("authsessions").Insert(sess).RunWrite(a.conn)
+	return err
+}
+
+// AuthSessionUpdate updates the last seen time, expiration time and client details of an authenticated session.
+func (a *adapter) AuthSessionUpdate(sess *t.AuthSession) error {
+	res, err := rdb.DB(a.dbName).Table("authsessions").Get(sess.Id).Update(map[string]any{
+		"UpdatedAt":  sess.UpdatedAt,
+		"Expires":    sess.Expires,
+		"DeviceId":   sess.DeviceId,
+		"Platform":   sess.Platform,
+		"UserAgent":  sess.UserAgent,
+		"RemoteAddr": sess.RemoteAddr,
+	}).RunWrite(a.conn)
+	if err != nil {
+		return err
+	}
+	if res.Skipped > 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// AuthSessionGet returns the authenticated session with the given ID or nil if not found.
+func (a *adapter) AuthSessionGet(id string) (*t.AuthSession, error) {
+	cursor, err := rdb.DB(a.dbName).Table("authsessions").GetAll(id).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	if cursor.IsNil() {
+		return nil, nil
+	}
+
+	var sess t.AuthSession
+	if err = cursor.One(&sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// AuthSessionGetAll returns authenticated sessions of the user which have not expired yet ordered by creation time.
+func (a *adapter) AuthSessionGetAll(uid t.Uid) ([]t.AuthSession, error) {
+	cursor, err := rdb.DB(a.dbName).Table("authsessions").GetAllByIndex("User", uid.String()).
+		Filter(rdb.Row.Field("Expires").Gt(t.TimeNow())).
+		OrderBy("CreatedAt", "Id").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var sessions []t.AuthSession
+	if err = cursor.All(&sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// AuthSessionExpire deletes authenticated sessions of all users which expired before the given time.
+func (a *adapter) AuthSessionExpire(olderThan time.Time) error {
+	_, err := rdb.DB(a.dbName).Table("authsessions").
+		Filter(rdb.Row.Field("Expires").Lt(olderThan)).Delete().RunWrite(a.conn)
+	return err
+}
+
+// AuthSessionDelete deletes authenticated sessions of the user with the given IDs.
+func (a *adapter) AuthSessionDelete(uid t.Uid, ids []string) error {
+	sids := make([]any, len(ids))
+	for i, id := range ids {
+		sids[i] = id
+	}
+	_, err := rdb.DB(a.dbName).Table("authsessions").GetAll(sids...).
+		Filter(map[string]any{"User": uid.String()}).Delete().RunWrite(a.conn)
+	return err
+}
+
+// API keys.
+
+// APIKeyCreate saves a new API key.
+func (a *adapter) APIKeyCreate(key *t.APIKey) error {
+	_, err := rdb.DB(a.dbName).Table("apikeys").Insert(key).RunWrite(a.conn)
+	return err
+}
+
+// APIKeyUpdate updates an API key.
+func (a *adapter) APIKeyUpdate(key *t.APIKey) error {
+	res, err := rdb.DB(a.dbName).Table("apikeys").Get(key.Id).Update(map[string]any{
+		"UpdatedAt": key.UpdatedAt,
+		"RevokedAt": key.RevokedAt,
+		"Name":      key.Name,
+		"Origins":   key.Origins,
+		"Schemes":   key.Schemes,
+		"Messages":  key.Messages,
+		"RateLimit": key.RateLimit,
+		"RateBurst": key.RateBurst,
+	}).RunWrite(a.conn)
+	if err != nil {
+		return err
+	}
+	if res.Skipped > 0 {
+		return t.ErrNotFound
+	}
+	return nil
+}
+
+// APIKeyGet returns the API key with the given ID or nil if not found.
+func (a *adapter) APIKeyGet(id string) (*t.APIKey, error) {
+	cursor, err := rdb.DB(a.dbName).Table("apikeys").GetAll(id).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	if cursor.IsNil() {
+		return nil, nil
+	}
+
+	var key t.APIKey
+	if err = cursor.One(&key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// APIKeyGetAll returns all API keys ordered by creation time.
+func (a *adapter) APIKeyGetAll() ([]t.APIKey, error) {
+	cursor, err := rdb.DB(a.dbName).Table("apikeys").OrderBy("CreatedAt", "Id").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var keys []t.APIKey
+	if err = cursor.All(&keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Audit log.
+
+// AuditAdd appends a record to the audit log.
+func (a *adapter) AuditAdd(rec *t.AuditRecord) error {
+	_, err := rdb.DB(a.dbName).Table("auditlog").Insert(rec).RunWrite(a.conn)
+	return err
+}
+
+// AuditGetAll returns records of the audit log matching the query, newest first.
+func (a *adapter) AuditGetAll(query *t.AuditQuery) ([]t.AuditRecord, error) {
+	var lower, upper any = rdb.MinVal, rdb.MaxVal
+	limit := a.maxResults
+	var filter rdb.Term
+	var hasFilter bool
+	if query != nil {
+		if query.Since != nil {
+			lower = *query.Since
+		}
+		if query.Before != nil {
+			upper = *query.Before
+		}
+		if !query.User.IsZero() {
+			filter = rdb.Row.Field("Actor").Default("").Eq(query.User.String()).
+				Or(rdb.Row.Field("User").Default("").Eq(query.User.String()))
+			hasFilter = true
+		}
+		if query.Action != "" {
+			action := rdb.Row.Field("Action").Eq(query.Action)
+			if hasFilter {
+				filter = filter.And(action)
+			} else {
+				filter = action
+			}
+			hasFilter = true
+		}
+		if query.Limit > 0 && query.Limit < limit {
+			limit = query.Limit
+		}
+	}
+
+	q := rdb.DB(a.dbName).Table("auditlog").
+		Between(lower, upper, rdb.BetweenOpts{Index: "CreatedAt"}).
+		OrderBy(rdb.OrderByOpts{Index: rdb.Desc("CreatedAt")})
+	if hasFilter {
+		q = q.Filter(filter)
+	}
+	return a.auditGet(q.Limit(limit))
+}
+
+// AuditList returns up to limit records of the audit log ordered by ID, starting after the record with the given ID.
+func (a *adapter) AuditList(after string, limit int) ([]t.AuditRecord, error) {
+	var lower any = rdb.MinVal
+	if after != "" {
+		lower = after
+	}
+	return a.auditGet(rdb.DB(a.dbName).Table("auditlog").
+		Between(lower, rdb.MaxVal, rdb.BetweenOpts{LeftBound: "open"}).
+		OrderBy(rdb.OrderByOpts{Index: "Id"}).Limit(limit))
+}
+
+func (a *adapter) auditGet(q rdb.Term) ([]t.AuditRecord, error) {
+	cursor, err := q.Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var records []t.AuditRecord
+	if err = cursor.All(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // Device management for push notifications
 
 // DeviceUpsert adds or updates a user's device FCM push token.
@@ -2856,6 +4319,34 @@ func (a *adapter) FileGet(fid string) (*t.FileDef, error) {
 
 }
 
+// FileGetAll returns records of completed uploads by the given user ordered by ID.
+func (a *adapter) FileGetAll(user, after t.Uid, limit int) ([]t.FileDef, error) {
+	var lower any = rdb.MinVal
+	if !after.IsZero() {
+		lower = after.String()
+	}
+	cursor, err := rdb.DB(a.dbName).Table("fileuploads").
+		Between(lower, rdb.MaxVal, rdb.BetweenOpts{LeftBound: "open"}).
+		OrderBy(rdb.OrderByOpts{Index: "Id"}).
+		Filter(map[string]any{"User": user.String(), "Status": t.UploadCompleted}).
+		Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var files []t.FileDef
+	var fd t.FileDef
+	for cursor.Next(&fd) {
+		fd.CreatedAt = fd.CreatedAt.UTC()
+		fd.UpdatedAt = fd.UpdatedAt.UTC()
+		files = append(files, fd)
+		fd = t.FileDef{}
+	}
+
+	return files, cursor.Err()
+}
+
 // FileLinkAttachments connects given topic or message to the file record IDs from the list.
 func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []string) error {
 	if len(fids) == 0 || (topic == "" && userId.IsZero() && msgId.IsZero()) {
@@ -2944,6 +4435,26 @@ func (a *adapter) FileLinkAttachments(topic string, userId, msgId t.Uid, fids []
 	return err
 }
 
+// FileGetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+func (a *adapter) FileGetMessageAttachments(topic string, seqId int) ([]string, error) {
+	cursor, err := rdb.DB(a.dbName).Table("messages").
+		GetAllByIndex("Topic_SeqId", []any{topic, seqId}).
+		Pluck("Attachments").Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var msgs []struct {
+		Attachments []string
+	}
+	if err = cursor.All(&msgs); err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+
+	return msgs[0].Attachments, nil
+}
+
 // FileDeleteUnused deletes orphaned file uploads.
 func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, error) {
 	q := rdb.DB(a.dbName).Table("fileuploads").GetAllByIndex("UseCount", 0)
@@ -2975,6 +4486,25 @@ func (a *adapter) FileDeleteUnused(olderThan time.Time, limit int) ([]string, er
 	return locations, err
 }
 
+// FileDelete deletes the record of the file unless the file is in use. Returns FileDef.Location
+// of the deleted record so the actual file can be deleted too.
+func (a *adapter) FileDelete(fid string) (string, error) {
+	res, err := rdb.DB(a.dbName).Table("fileuploads").GetAll(fid).
+		Filter(rdb.Row.Field("UseCount").Default(0).Eq(0)).
+		Delete(rdb.DeleteOpts{ReturnChanges: true}).
+		RunWrite(a.conn)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Changes) == 0 {
+		// Not found or in use.
+		return "", nil
+	}
+	old, _ := res.Changes[0].OldValue.(map[string]any)
+	location, _ := old["Location"].(string)
+	return location, nil
+}
+
 // Given a select query, decrement corresponding use counter in 'fileuploads' table.
 // The 'query' must return an array, i.e. GetAll, not Get.
 func (a *adapter) decFileUseCounter(query rdb.Term) error {
@@ -3055,6 +4585,19 @@ func (a *adapter) PCacheUpsert(key string, value string, failOnDuplicate bool) e
 	return err
 }
 
+// PCacheSwap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+// Returns false if the entry does not exist or has a different value.
+func (a *adapter) PCacheSwap(key, oldValue, newValue string) (bool, error) {
+	res, err := rdb.DB(a.dbName).Table("kvmeta").Get(key).Update(func(row rdb.Term) any {
+		return rdb.Branch(row.Field("value").Eq(oldValue),
+			map[string]any{"value": newValue, "CreatedAt": t.TimeNow()}, map[string]any{})
+	}).RunWrite(a.conn)
+	if err != nil {
+		return false, err
+	}
+	return res.Replaced > 0, nil
+}
+
 // PCacheDelete deletes one persistent cache entry.
 func (a *adapter) PCacheDelete(key string) error {
 	_, err := rdb.DB(a.dbName).Table("kvmeta").Get(key).Delete().RunWrite(a.conn)
@@ -3075,6 +4618,36 @@ func (a *adapter) PCacheExpire(keyPrefix string, olderThan time.Time) error {
 	return err
 }
 
+// PCacheList returns up to limit persistent cache entries ordered by key, starting after the given key.
+func (a *adapter) PCacheList(after string, limit int) ([]adp.PCacheEntry, error) {
+	var lower any = rdb.MinVal
+	if after != "" {
+		lower = after
+	}
+	cursor, err := rdb.DB(a.dbName).Table("kvmeta").
+		Between(lower, rdb.MaxVal, rdb.BetweenOpts{LeftBound: "open"}).
+		OrderBy(rdb.OrderByOpts{Index: "key"}).
+		Filter(rdb.Row.Field("key").Ne("version")).
+		Limit(limit).Run(a.conn)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var entries []adp.PCacheEntry
+	var entry struct {
+		Key       string    `json:"key"`
+		Value     string    `json:"value"`
+		CreatedAt time.Time `json:"CreatedAt"`
+	}
+	for cursor.Next(&entry) {
+		entries = append(entries, adp.PCacheEntry{Key: entry.Key, Value: entry.Value, CreatedAt: entry.CreatedAt.UTC()})
+		entry.CreatedAt = time.Time{}
+	}
+
+	return entries, cursor.Err()
+}
+
 // GetTestDB returns a currently open database connection.
 func (a *adapter) GetTestDB() any {
 	return a.conn
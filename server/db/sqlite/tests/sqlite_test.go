@@ -0,0 +1,2353 @@
+// To test another db backend:
+// 1) Create GetAdapter function inside your db backend adapter package (like one inside sqlite adapter)
+// 2) Uncomment your db backend package ('backend' named package)
+// 3) Write own initConnectionToDb and 'db' variable
+// 4) Replace sqlite specific db queries inside test to your own queries.
+// 5) Run.
+
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	adapter "github.com/tinode/chat/server/db"
+	"github.com/tinode/chat/server/store"
+	jcr "github.com/tinode/jsonco"
+
+	"github.com/tinode/chat/server/db/common/test_data"
+	backend "github.com/tinode/chat/server/db/sqlite"
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store/types"
+)
+
+type configType struct {
+	// If Reset=true test will recreate database every time it runs
+	Reset bool `json:"reset_db_data"`
+	// Configurations for individual adapters.
+	Adapters map[string]json.RawMessage `json:"adapters"`
+}
+
+var config configType
+var adp adapter.Adapter
+var db *sqlx.DB
+var testData *test_data.TestData
+
+var dummyUid1 = types.Uid(12345)
+var dummyUid2 = types.Uid(54321)
+
+func TestCreateDb(t *testing.T) {
+	if err := adp.CreateDb(config.Reset); err != nil {
+		t.Fatal(err)
+	}
+	// Saved db is closed, get a fresh one.
+	db = adp.GetTestDB().(*sqlx.DB)
+}
+
+// ================== Create tests ================================
+func TestUserCreate(t *testing.T) {
+	for _, user := range testData.Users {
+		if err := adp.UserCreate(user); err != nil {
+			t.Error(err)
+		}
+	}
+	var count int
+
+	if err := db.Ping(); err != nil {
+		logs.Err.Println("Database ping failed:", err)
+	}
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	if err != nil {
+		t.Error(err)
+	}
+	if count == 0 {
+		t.Error("No users created!")
+	}
+}
+
+func TestCredUpsert(t *testing.T) {
+	// Test just inserts:
+	for i := 0; i < 2; i++ {
+		inserted, err := adp.CredUpsert(testData.Creds[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !inserted {
+			t.Error("Should be inserted, but updated")
+		}
+	}
+
+	// Test duplicate:
+	_, err := adp.CredUpsert(testData.Creds[1])
+	if err != types.ErrDuplicate {
+		t.Error("Should return duplicate error but got", err)
+	}
+	_, err = adp.CredUpsert(testData.Creds[2])
+	if err != types.ErrDuplicate {
+		t.Error("Should return duplicate error but got", err)
+	}
+
+	// Test add new unvalidated credentials
+	inserted, err := adp.CredUpsert(testData.Creds[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inserted {
+		t.Error("Should be inserted, but updated")
+	}
+	inserted, err = adp.CredUpsert(testData.Creds[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted {
+		t.Error("Should be updated, but inserted")
+	}
+
+	// Just insert other creds (used in other tests)
+	for _, cred := range testData.Creds[4:] {
+		_, err = adp.CredUpsert(cred)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuthAddRecord(t *testing.T) {
+	for _, rec := range testData.Recs {
+		err := adp.AuthAddRecord(types.ParseUserId("usr"+rec.UserId), rec.Scheme, rec.Unique,
+			rec.AuthLvl, rec.Secret, rec.Expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//Test duplicate
+	err := adp.AuthAddRecord(types.ParseUserId("usr"+testData.Users[0].Id), testData.Recs[0].Scheme,
+		testData.Recs[0].Unique, testData.Recs[0].AuthLvl, testData.Recs[0].Secret, testData.Recs[0].Expires)
+	if err != types.ErrDuplicate {
+		t.Fatal("Should be duplicate error but got", err)
+	}
+}
+
+func TestTopicCreate(t *testing.T) {
+	err := adp.TopicCreate(testData.Topics[0])
+	if err != nil {
+		t.Error(err)
+	}
+	for _, tpc := range testData.Topics[3:] {
+		err = adp.TopicCreate(tpc)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func decodeUid(u string) int64 {
+	return store.DecodeUid(types.ParseUid(u))
+}
+
+func TestTopicCreateP2P(t *testing.T) {
+	err := adp.TopicCreateP2P(testData.Subs[2], testData.Subs[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldModeGiven := testData.Subs[2].ModeGiven
+	testData.Subs[2].ModeGiven = 255
+	err = adp.TopicCreateP2P(testData.Subs[4], testData.Subs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got types.Subscription
+	err = db.QueryRow("SELECT createdat,updatedat,deletedat,userid,topic,delid,recvseqid,readseqid,modewant,modegiven,private FROM subscriptions WHERE topic=? AND userid=?",
+		testData.Subs[2].Topic, decodeUid(testData.Subs[2].User)).Scan(&got.CreatedAt,
+		&got.UpdatedAt, &got.DeletedAt, &got.User, &got.Topic, &got.DelId, &got.RecvSeqId, &got.ReadSeqId, &got.ModeWant, &got.ModeGiven, &got.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ModeGiven == oldModeGiven {
+		t.Error("ModeGiven update failed")
+	}
+}
+
+func TestTopicShare(t *testing.T) {
+	if err := adp.TopicShare(testData.Subs[0].Topic, testData.Subs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Must save recvseqid and readseqid separately because TopicShare
+	// ignores them.
+	for _, sub := range testData.Subs {
+		adp.SubsUpdate(sub.Topic, types.ParseUid(sub.User), map[string]any{
+			"delid":     sub.DelId,
+			"recvseqid": sub.RecvSeqId,
+			"readseqid": sub.ReadSeqId,
+		})
+	}
+
+	// Update topic SeqId because it's not saved at creation time but used by the tests.
+	for _, tpc := range testData.Topics {
+		err := adp.TopicUpdate(tpc.Id, map[string]any{
+			"seqid": tpc.SeqId,
+			"delid": tpc.DelId,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestMessageSave(t *testing.T) {
+	for _, msg := range testData.Msgs {
+		err := adp.MessageSave(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Some messages are soft deleted, but it's ignored by adp.MessageSave
+	for _, msg := range testData.Msgs {
+		if len(msg.DeletedFor) > 0 {
+			for _, del := range msg.DeletedFor {
+				toDel := types.DelMessage{
+					Topic:       msg.Topic,
+					DeletedFor:  del.User,
+					DelId:       del.DelId,
+					SeqIdRanges: []types.Range{{Low: msg.SeqId}},
+				}
+				adp.MessageDeleteList(msg.Topic, &toDel)
+			}
+		}
+	}
+}
+
+func TestFileStartUpload(t *testing.T) {
+	for _, f := range testData.Files {
+		err := adp.FileStartUpload(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ================== Read tests ==================================
+func TestUserGet(t *testing.T) {
+	// Test not found
+	got, err := adp.UserGet(dummyUid1)
+	if err == nil && got != nil {
+		t.Error("user should be nil.")
+	}
+
+	got, err = adp.UserGet(types.ParseUserId("usr" + testData.Users[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User agent is not stored when creating a user. Make sure it's the same.
+	got.UserAgent = testData.Users[0].UserAgent
+
+	if !reflect.DeepEqual(got, testData.Users[0]) {
+		t.Error(mismatchErrorString("User", got, testData.Users[0]))
+	}
+}
+
+func TestUserGetAll(t *testing.T) {
+	// Test not found (dummy UIDs).
+	got, err := adp.UserGetAll(dummyUid1, dummyUid2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Error("result users should be zero length, got", len(got))
+	}
+
+	got, err = adp.UserGetAll(types.ParseUserId("usr"+testData.Users[0].Id), types.ParseUserId("usr"+testData.Users[1].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatal(mismatchErrorString("resultUsers length", len(got), 2))
+	}
+	for i, usr := range got {
+		// User agent is not compared.
+		usr.UserAgent = testData.Users[i].UserAgent
+		if !reflect.DeepEqual(&usr, testData.Users[i]) {
+			t.Error(mismatchErrorString("User", &usr, testData.Users[i]))
+		}
+	}
+}
+
+func TestUserGetByCred(t *testing.T) {
+	// Test not found
+	got, err := adp.UserGetByCred("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != types.ZeroUid {
+		t.Error("result uid should be ZeroUid")
+	}
+
+	got, _ = adp.UserGetByCred(testData.Creds[0].Method, testData.Creds[0].Value)
+	if got != types.ParseUserId("usr"+testData.Creds[0].User) {
+		t.Error(mismatchErrorString("Uid", got, types.ParseUserId("usr"+testData.Creds[0].User)))
+	}
+}
+
+func TestCredGetActive(t *testing.T) {
+	got, err := adp.CredGetActive(types.ParseUserId("usr"+testData.Users[2].Id), "tel")
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(got, testData.Creds[3]) {
+		t.Error(mismatchErrorString("Credential", got, testData.Creds[3]))
+	}
+
+	// Test not found
+	got, err = adp.CredGetActive(dummyUid1, "")
+	if err != nil {
+		t.Error(err)
+	}
+	if got != nil {
+		t.Error("result should be nil, but got", got)
+	}
+}
+
+func TestCredGetAll(t *testing.T) {
+	got, err := adp.CredGetAll(types.ParseUserId("usr"+testData.Users[2].Id), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Error(mismatchErrorString("Credentials length", len(got), 3))
+	}
+
+	got, _ = adp.CredGetAll(types.ParseUserId("usr"+testData.Users[2].Id), "tel", false)
+	if len(got) != 2 {
+		t.Error(mismatchErrorString("Credentials length", len(got), 2))
+	}
+
+	got, _ = adp.CredGetAll(types.ParseUserId("usr"+testData.Users[2].Id), "", true)
+	if len(got) != 1 {
+		t.Error(mismatchErrorString("Credentials length", len(got), 1))
+	}
+
+	got, _ = adp.CredGetAll(types.ParseUserId("usr"+testData.Users[2].Id), "tel", true)
+	if len(got) != 1 {
+		t.Error(mismatchErrorString("Credentials length", len(got), 1))
+	}
+}
+
+func TestAuthGetUniqueRecord(t *testing.T) {
+	uid, authLvl, secret, expires, err := adp.AuthGetUniqueRecord("basic:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != types.ParseUserId("usr"+testData.Recs[0].UserId) ||
+		authLvl != testData.Recs[0].AuthLvl ||
+		!reflect.DeepEqual(secret, testData.Recs[0].Secret) ||
+		expires != testData.Recs[0].Expires {
+
+		got := fmt.Sprintf("%v %v %v %v", uid, authLvl, secret, expires)
+		want := fmt.Sprintf("%v %v %v %v", testData.Recs[0].UserId, testData.Recs[0].AuthLvl, testData.Recs[0].Secret, testData.Recs[0].Expires)
+		t.Error(mismatchErrorString("Auth record", got, want))
+	}
+
+	// Test not found
+	uid, _, _, _, err = adp.AuthGetUniqueRecord("qwert:asdfg")
+	if err == nil && !uid.IsZero() {
+		t.Error("Auth record found but shouldn't. Uid:", uid.String())
+	}
+}
+
+func TestAuthGetRecord(t *testing.T) {
+	recId, authLvl, secret, expires, err := adp.AuthGetRecord(types.ParseUserId("usr"+testData.Recs[0].UserId), "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recId != testData.Recs[0].Unique ||
+		authLvl != testData.Recs[0].AuthLvl ||
+		!reflect.DeepEqual(secret, testData.Recs[0].Secret) ||
+		expires != testData.Recs[0].Expires {
+
+		got := fmt.Sprintf("%v %v %v %v", recId, authLvl, secret, expires)
+		want := fmt.Sprintf("%v %v %v %v", testData.Recs[0].Unique, testData.Recs[0].AuthLvl, testData.Recs[0].Secret, testData.Recs[0].Expires)
+		t.Error(mismatchErrorString("Auth record", got, want))
+	}
+
+	// Test not found
+	recId, _, _, _, err = adp.AuthGetRecord(types.Uid(123), "scheme")
+	if err != types.ErrNotFound {
+		t.Error("Auth record found but shouldn't. recId:", recId)
+	}
+}
+
+func TestAuthGetUpdated(t *testing.T) {
+	updated, err := adp.AuthGetUpdated(types.ParseUserId("usr"+testData.Recs[0].UserId), "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.IsZero() || time.Since(updated) > time.Hour {
+		t.Error(mismatchErrorString("Updated", updated, "recent time"))
+	}
+
+	// Test not found
+	if _, err = adp.AuthGetUpdated(types.Uid(123), "scheme"); err != types.ErrNotFound {
+		t.Error("Auth record found but shouldn't:", err)
+	}
+}
+
+func TestAuthGetAllRecords(t *testing.T) {
+	recs, err := adp.AuthGetAllRecords(types.ParseUserId("usr" + testData.Recs[0].UserId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatal(mismatchErrorString("Auth records length", len(recs), 1))
+	}
+	if recs[0].Scheme != testData.Recs[0].Scheme ||
+		recs[0].Unique != testData.Recs[0].Unique ||
+		recs[0].AuthLvl != testData.Recs[0].AuthLvl ||
+		!reflect.DeepEqual(recs[0].Secret, testData.Recs[0].Secret) ||
+		recs[0].Expires != testData.Recs[0].Expires {
+		t.Error(mismatchErrorString("Auth record", recs[0], testData.Recs[0]))
+	}
+
+	// User without records
+	recs, err = adp.AuthGetAllRecords(types.Uid(123))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Error(mismatchErrorString("Auth records length", len(recs), 0))
+	}
+}
+
+func TestTopicGet(t *testing.T) {
+	got, err := adp.TopicGet(testData.Topics[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testData.Topics[0]) {
+		t.Error(mismatchErrorString("Topic", got, testData.Topics[0]))
+	}
+	// Test not found
+	got, err = adp.TopicGet("asdfasdfasdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Error("Topic should be nil but got:", got)
+	}
+}
+
+func TestTopicList(t *testing.T) {
+	all, err := adp.TopicList("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < len(testData.Topics) {
+		t.Fatal(mismatchErrorString("Topics length", len(all), len(testData.Topics)))
+	}
+
+	// Read the same topics page by page.
+	var paged []string
+	after := ""
+	for range len(all) {
+		topics, err := adp.TopicList(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(topics) == 0 {
+			break
+		}
+		for i := range topics {
+			paged = append(paged, topics[i].Id)
+		}
+		after = topics[len(topics)-1].Id
+	}
+	if len(paged) != len(all) {
+		t.Fatal(mismatchErrorString("Paged topics length", len(paged), len(all)))
+	}
+	for i := range all {
+		if all[i].Id != paged[i] {
+			t.Error(mismatchErrorString("Topic", paged[i], all[i].Id))
+		}
+		if i > 0 && all[i-1].Id >= all[i].Id {
+			t.Error("Topics are not sorted by name:", all[i-1].Id, all[i].Id)
+		}
+	}
+}
+
+func TestTopicsForUser(t *testing.T) {
+	qOpts := types.QueryOpt{
+		Topic: "p2p9AVDamaNCRbfKzGSh3mE0w",
+		Limit: 999,
+	}
+	gotSubs, err := adp.TopicsForUser(types.ParseUserId("usr"+testData.Users[0].Id), false, &qOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 1 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 1))
+	}
+
+	gotSubs, err = adp.TopicsForUser(types.ParseUserId("usr"+testData.Users[1].Id), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 2 {
+		t.Error(mismatchErrorString("Subs length (2)", len(gotSubs), 2))
+	}
+
+	qOpts.Topic = ""
+	ims := testData.Now.Add(15 * time.Minute)
+	qOpts.IfModifiedSince = &ims
+	gotSubs, err = adp.TopicsForUser(types.ParseUserId("usr"+testData.Users[0].Id), false, &qOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 1 {
+		t.Error(mismatchErrorString("Subs length (IMS)", len(gotSubs), 1))
+	}
+
+	ims = time.Now().Add(15 * time.Minute)
+	gotSubs, err = adp.TopicsForUser(types.ParseUserId("usr"+testData.Users[0].Id), false, &qOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 0 {
+		t.Error(mismatchErrorString("Subs length (IMS 2)", len(gotSubs), 0))
+	}
+}
+
+func TestUsersForTopic(t *testing.T) {
+	qOpts := types.QueryOpt{
+		User:  types.ParseUserId("usr" + testData.Users[0].Id),
+		Limit: 999,
+	}
+	gotSubs, err := adp.UsersForTopic("grpgRXf0rU4uR4", false, &qOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 1 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 1))
+	}
+
+	gotSubs, err = adp.UsersForTopic("grpgRXf0rU4uR4", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 2 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 2))
+	}
+
+	gotSubs, err = adp.UsersForTopic("p2p9AVDamaNCRbfKzGSh3mE0w", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 2 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 2))
+	}
+}
+
+func TestOwnTopics(t *testing.T) {
+	gotSubs, err := adp.OwnTopics(types.ParseUserId("usr" + testData.Users[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotSubs) != 1 {
+		t.Fatalf("Got topic length %v instead of %v", len(gotSubs), 1)
+	}
+	if gotSubs[0] != testData.Topics[0].Id {
+		t.Errorf("Got topic %v instead of %v", gotSubs[0], testData.Topics[0].Id)
+	}
+}
+
+func TestSubscriptionGet(t *testing.T) {
+	got, err := adp.SubscriptionGet(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if diff := cmp.Diff(got, testData.Subs[0],
+		cmpopts.IgnoreUnexported(types.Subscription{}, types.ObjHeader{})); diff != "" {
+		t.Error(mismatchErrorString("Subs", diff, ""))
+	}
+	// Test not found
+	got, err = adp.SubscriptionGet("dummytopic", dummyUid1, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if got != nil {
+		t.Error("result sub should be nil.")
+	}
+}
+
+func TestSubsForUser(t *testing.T) {
+	gotSubs, err := adp.SubsForUser(types.ParseUserId("usr" + testData.Users[0].Id))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(gotSubs) != 2 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 1))
+	}
+
+	// Test not found
+	gotSubs, err = adp.SubsForUser(types.ParseUserId("usr12345678"))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(gotSubs) != 0 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 0))
+	}
+}
+
+func TestSubsForTopic(t *testing.T) {
+	qOpts := types.QueryOpt{
+		User:  types.ParseUserId("usr" + testData.Users[0].Id),
+		Limit: 999,
+	}
+	gotSubs, err := adp.SubsForTopic(testData.Topics[0].Id, false, &qOpts)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(gotSubs) != 1 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 1))
+	}
+	// Test not found
+	gotSubs, err = adp.SubsForTopic("dummytopicid", false, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(gotSubs) != 0 {
+		t.Error(mismatchErrorString("Subs length", len(gotSubs), 0))
+	}
+}
+
+func TestFind(t *testing.T) {
+	reqTags := [][]string{{"alice", "bob", "carol", "travel", "qwer", "asdf", "zxcv"}}
+	got, err := adp.Find("usr"+testData.Users[2].Id, "", reqTags, nil, true)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(got) != 3 {
+		t.Error(mismatchErrorString("result length", len(got), 3))
+	}
+}
+
+func TestMessageGetAll(t *testing.T) {
+	opts := types.QueryOpt{
+		Since:  1,
+		Before: 2,
+		Limit:  999,
+	}
+	gotMsgs, err := adp.MessageGetAll(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotMsgs) != 1 {
+		t.Error(mismatchErrorString("Messages length opts", len(gotMsgs), 1))
+	}
+	gotMsgs, _ = adp.MessageGetAll(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), nil)
+	if len(gotMsgs) != 2 {
+		t.Fatalf("%+v", gotMsgs)
+		t.Error(mismatchErrorString("Messages length no opts", len(gotMsgs), 2))
+	}
+	gotMsgs, _ = adp.MessageGetAll(testData.Topics[0].Id, types.ZeroUid, nil)
+	if len(gotMsgs) != 3 {
+		t.Error(mismatchErrorString("Messages length zero uid", len(gotMsgs), 3))
+	}
+}
+
+func TestMessageSearch(t *testing.T) {
+	gotMsgs, err := adp.MessageSearch(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), "MSG3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotMsgs) != 1 {
+		t.Error(mismatchErrorString("Messages length search", len(gotMsgs), 1))
+	}
+	// Message 2 is soft-deleted for user 0.
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), "msg", nil)
+	if len(gotMsgs) != 2 {
+		t.Error(mismatchErrorString("Messages length soft-deleted", len(gotMsgs), 2))
+	}
+	opts := types.QueryOpt{
+		Before: 3,
+		Limit:  999,
+	}
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ZeroUid, "msg", &opts)
+	if len(gotMsgs) != 2 {
+		t.Error(mismatchErrorString("Messages length search opts", len(gotMsgs), 2))
+	}
+	gotMsgs, _ = adp.MessageSearch(testData.Topics[0].Id, types.ZeroUid, "%", nil)
+	if len(gotMsgs) != 0 {
+		t.Error(mismatchErrorString("Messages length wildcard", len(gotMsgs), 0))
+	}
+}
+
+func TestFileGet(t *testing.T) {
+	// General test done during TestFileFinishUpload().
+
+	// Test not found
+	got, err := adp.FileGet("dummyfileid")
+	if err != nil {
+		if got != nil {
+			t.Error("File found but shouldn't:", got)
+		}
+	}
+}
+
+// ================== Update tests ================================
+func TestUserUpdate(t *testing.T) {
+	update := map[string]any{
+		"UserAgent": "Test Agent v0.11",
+		"UpdatedAt": testData.Now.Add(30 * time.Minute),
+	}
+	err := adp.UserUpdate(types.ParseUserId("usr"+testData.Users[0].Id), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		UserAgent string
+		UpdatedAt time.Time
+		CreatedAt time.Time
+	}
+	err = db.QueryRow("SELECT useragent, updatedat, createdat FROM users WHERE id=?", decodeUid(testData.Users[0].Id)).
+		Scan(&got.UserAgent, &got.UpdatedAt, &got.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserAgent != "Test Agent v0.11" {
+		t.Error(mismatchErrorString("UserAgent", got.UserAgent, "Test Agent v0.11"))
+	}
+	if got.UpdatedAt == got.CreatedAt {
+		t.Error("UpdatedAt field not updated")
+	}
+}
+
+func TestUserUpdateTags(t *testing.T) {
+	addTags := testData.Tags[0]
+	removeTags := testData.Tags[1]
+	resetTags := testData.Tags[2]
+	uid := types.ParseUserId("usr" + testData.Users[0].Id)
+
+	got, err := adp.UserUpdateTags(uid, addTags, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"alice", "tag1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Tags", got, want))
+
+	}
+	got, err = adp.UserUpdateTags(uid, nil, removeTags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Tags", got, want))
+
+	}
+	got, err = adp.UserUpdateTags(uid, nil, nil, resetTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"alice", "tag111", "tag333"}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Tags", got, want))
+
+	}
+	got, err = adp.UserUpdateTags(uid, addTags, removeTags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"tag111", "tag333"}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Tags", got, want))
+
+	}
+	got, err = adp.UserUpdateTags(uid, addTags, removeTags, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"tag111", "tag333"}
+	if !reflect.DeepEqual(got, want) {
+		t.Error(mismatchErrorString("Tags", got, want))
+	}
+}
+
+func TestCredFail(t *testing.T) {
+	err := adp.CredFail(types.ParseUserId("usr"+testData.Creds[3].User), "tel")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Check if fields updated
+	var got struct {
+		Retries   int
+		UpdatedAt time.Time
+		CreatedAt time.Time
+	}
+	err = db.QueryRow("SELECT retries, updatedat, createdat FROM credentials WHERE userid=? AND method=? AND value=?",
+		decodeUid(testData.Creds[3].User), "tel", testData.Creds[3].Value).Scan(&got.Retries, &got.UpdatedAt, &got.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Retries != 1 {
+		t.Error(mismatchErrorString("Retries count", got.Retries, 1))
+	}
+	if got.UpdatedAt == got.CreatedAt {
+		t.Error("UpdatedAt field not updated")
+	}
+}
+
+func TestCredConfirm(t *testing.T) {
+	err := adp.CredConfirm(types.ParseUserId("usr"+testData.Creds[3].User), "tel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test fields are updated
+	var got struct {
+		UpdatedAt time.Time
+		CreatedAt time.Time
+		Done      bool
+	}
+	err = db.QueryRow("SELECT updatedat, createdat, done FROM credentials WHERE userid=? AND method=? AND value=?",
+		decodeUid(testData.Creds[3].User), "tel", testData.Creds[3].Value).Scan(&got.UpdatedAt, &got.CreatedAt, &got.Done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UpdatedAt == got.CreatedAt {
+		t.Error("Credential not updated correctly")
+	}
+	if !got.Done {
+		t.Error("Credential should be marked as done")
+	}
+}
+
+func TestAuthUpdRecord(t *testing.T) {
+	rec := testData.Recs[1]
+	newSecret := []byte{'s', 'e', 'c', 'r', 'e', 't'}
+	err := adp.AuthUpdRecord(types.ParseUserId("usr"+rec.UserId), rec.Scheme, rec.Unique,
+		rec.AuthLvl, newSecret, rec.Expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	err = db.QueryRow("SELECT secret FROM auth WHERE uname=?", rec.Unique).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(got, rec.Secret) {
+		t.Error(mismatchErrorString("Secret", got, rec.Secret))
+	}
+
+	// Test with auth ID (unique) change
+	newId := "basic:bob12345"
+	err = adp.AuthUpdRecord(types.ParseUserId("usr"+rec.UserId), rec.Scheme, newId,
+		rec.AuthLvl, newSecret, rec.Expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Test if old ID deleted
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM auth WHERE uname=?", rec.Unique).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Old auth record not deleted")
+	}
+}
+
+func TestTopicUpdateOnMessage(t *testing.T) {
+	msg := types.Message{
+		ObjHeader: types.ObjHeader{
+			CreatedAt: testData.Now.Add(33 * time.Minute),
+		},
+		SeqId: 66,
+	}
+	err := adp.TopicUpdateOnMessage(testData.Topics[2].Id, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		TouchedAt time.Time
+		SeqId     int
+	}
+	err = db.QueryRow("SELECT touchedat, seqid FROM topics WHERE name=?", testData.Topics[2].Id).
+		Scan(&got.TouchedAt, &got.SeqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TouchedAt != msg.CreatedAt || got.SeqId != msg.SeqId {
+		t.Error(mismatchErrorString("TouchedAt", got.TouchedAt, msg.CreatedAt))
+		t.Error(mismatchErrorString("SeqId", got.SeqId, msg.SeqId))
+	}
+}
+
+func TestTopicUpdate(t *testing.T) {
+	update := map[string]any{
+		"UpdatedAt": testData.Now.Add(55 * time.Minute),
+	}
+	err := adp.TopicUpdate(testData.Topics[0].Id, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got time.Time
+	err = db.QueryRow("SELECT updatedat FROM topics WHERE name=?", testData.Topics[0].Id).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != update["UpdatedAt"] {
+		t.Error(mismatchErrorString("UpdatedAt", got, update["UpdatedAt"]))
+	}
+}
+
+func TestTopicUpdatePinned(t *testing.T) {
+	pinned := types.IntSlice{3, 1}
+	err := adp.TopicUpdate(testData.Topics[0].Id, map[string]any{"Pinned": pinned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.TopicGet(testData.Topics[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Pinned, pinned) {
+		t.Error(mismatchErrorString("Pinned", got.Pinned, pinned))
+	}
+}
+
+func TestTopicUpdateMsgTTL(t *testing.T) {
+	topic := testData.Topics[1].Id
+	if err := adp.TopicUpdate(topic, map[string]any{"MsgTTL": 3600}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.TopicGet(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MsgTTL != 3600 {
+		t.Error(mismatchErrorString("MsgTTL", got.MsgTTL, 3600))
+	}
+
+	topics, err := adp.TopicsWithMsgTTL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0].Id != topic || topics[0].MsgTTL != 3600 {
+		t.Error("Wrong topics with message TTL", topics)
+	}
+
+	if err = adp.TopicUpdate(topic, map[string]any{"MsgTTL": 0}); err != nil {
+		t.Fatal(err)
+	}
+	topics, err = adp.TopicsWithMsgTTL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 0 {
+		t.Error(mismatchErrorString("Topics with message TTL", len(topics), 0))
+	}
+}
+
+func TestTopicOwnerChange(t *testing.T) {
+	err := adp.TopicOwnerChange(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[1].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got int64
+	err = db.QueryRow("SELECT owner FROM topics WHERE name=?", testData.Topics[0].Id).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOwner := decodeUid(testData.Users[1].Id) // Assuming user ID conversion
+	if got != expectedOwner {
+		t.Error(mismatchErrorString("Owner", got, expectedOwner))
+	}
+}
+
+func TestSubsUpdate(t *testing.T) {
+	update := map[string]any{
+		"UpdatedAt": testData.Now.Add(22 * time.Minute),
+	}
+	err := adp.SubsUpdate(testData.Topics[0].Id, types.ParseUserId("usr"+testData.Users[0].Id), update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got time.Time
+	err = db.QueryRow("SELECT updatedat FROM subscriptions WHERE topic=? AND userid=?",
+		testData.Topics[0].Id, decodeUid(testData.Users[0].Id)).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != update["UpdatedAt"] {
+		t.Error(mismatchErrorString("UpdatedAt", got, update["UpdatedAt"]))
+	}
+
+	err = adp.SubsUpdate(testData.Topics[1].Id, types.ZeroUid, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT updatedat FROM subscriptions WHERE topic=? LIMIT 1",
+		testData.Topics[1].Id).Scan(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != update["UpdatedAt"] {
+		t.Error(mismatchErrorString("UpdatedAt", got, update["UpdatedAt"]))
+	}
+}
+
+func TestSubsDelete(t *testing.T) {
+	err := adp.SubsDelete(testData.Topics[1].Id, types.ParseUserId("usr"+testData.Users[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deletedat sql.NullTime
+	err = db.QueryRow("SELECT deletedat FROM subscriptions WHERE topic=? AND userid=?",
+		testData.Topics[1].Id, decodeUid(testData.Users[0].Id)).Scan(&deletedat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deletedat.Valid {
+		t.Error("DeletedAt should not be null")
+	}
+}
+
+func TestDeviceUpsert(t *testing.T) {
+	err := adp.DeviceUpsert(types.ParseUserId("usr"+testData.Users[0].Id), testData.Devs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		DeviceId string
+		Platform string
+	}
+	err = db.QueryRow("SELECT deviceid, platform FROM devices WHERE userid=? LIMIT 1",
+		decodeUid(testData.Users[0].Id)).Scan(&got.DeviceId, &got.Platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DeviceId != testData.Devs[0].DeviceId || got.Platform != testData.Devs[0].Platform {
+		t.Error(mismatchErrorString("Device", got, testData.Devs[0]))
+	}
+
+	// Test update
+	testData.Devs[0].Platform = "Web"
+	err = adp.DeviceUpsert(types.ParseUserId("usr"+testData.Users[0].Id), testData.Devs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT platform FROM devices WHERE userid=? AND deviceid=?",
+		decodeUid(testData.Users[0].Id), testData.Devs[0].DeviceId).Scan(&got.Platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Platform != "Web" {
+		t.Error("Device not updated.", got.Platform)
+	}
+
+	// Test add same device to another user
+	err = adp.DeviceUpsert(types.ParseUserId("usr"+testData.Users[1].Id), testData.Devs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT platform FROM devices WHERE userid=? AND deviceid=?",
+		decodeUid(testData.Users[1].Id), testData.Devs[0].DeviceId).Scan(&got.Platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Platform != "Web" {
+		t.Error("Device not updated.", got.Platform)
+	}
+
+	err = adp.DeviceUpsert(types.ParseUserId("usr"+testData.Users[2].Id), testData.Devs[1])
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMessageEdit(t *testing.T) {
+	msg := *testData.Msgs[5]
+	rev := &types.MessageRevision{
+		CreatedAt: msg.UpdatedAt,
+		Topic:     msg.Topic,
+		SeqId:     msg.SeqId,
+		Rev:       1,
+		Head:      msg.Head,
+		Content:   msg.Content,
+	}
+	msg.Head = types.KVMap{"rev": 2}
+	msg.Content = "msg3 edited"
+	msg.UpdatedAt = types.TimeNow()
+	if err := adp.MessageEdit(&msg, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	gotMsgs, err := adp.MessageGetAll(msg.Topic, types.ZeroUid, &types.QueryOpt{Since: msg.SeqId, Before: msg.SeqId + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotMsgs) != 1 || gotMsgs[0].Content != "msg3 edited" {
+		t.Error("Message content not updated", gotMsgs)
+	}
+	gotMsgs, _ = adp.MessageSearch(msg.Topic, types.ZeroUid, "edited", nil)
+	if len(gotMsgs) != 1 {
+		t.Error(mismatchErrorString("Messages length search edited", len(gotMsgs), 1))
+	}
+
+	revs, err := adp.MessageGetEdits(msg.Topic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 1 {
+		t.Fatal(mismatchErrorString("Revisions length", len(revs), 1))
+	}
+	if revs[0].SeqId != msg.SeqId || revs[0].Rev != 1 || revs[0].Content != "msg3" {
+		t.Error("Wrong revision", revs[0])
+	}
+	revs, _ = adp.MessageGetEdits(msg.Topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 1, Hi: 5}}})
+	if len(revs) != 0 {
+		t.Error(mismatchErrorString("Revisions length ranges", len(revs), 0))
+	}
+
+	// Edit of a non-existent message.
+	msg.SeqId = 999
+	rev.SeqId = msg.SeqId
+	if err = adp.MessageEdit(&msg, rev); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	// Revision saved without changing the message.
+	msg = *testData.Msgs[5]
+	rev = &types.MessageRevision{
+		CreatedAt: msg.CreatedAt,
+		Topic:     msg.Topic,
+		SeqId:     msg.SeqId,
+		Rev:       2,
+		Content:   "msg3 restored",
+	}
+	if err = adp.MessageEditSave(rev); err != nil {
+		t.Fatal(err)
+	}
+	revs, _ = adp.MessageGetEdits(msg.Topic, nil)
+	if len(revs) != 2 || revs[0].Rev != 2 || revs[0].Content != "msg3 restored" {
+		t.Error("Wrong saved revision", revs)
+	}
+	gotMsgs, _ = adp.MessageGetAll(msg.Topic, types.ZeroUid, &types.QueryOpt{Since: msg.SeqId, Before: msg.SeqId + 1})
+	if len(gotMsgs) != 1 || gotMsgs[0].Content != "msg3 edited" {
+		t.Error("Message changed by saving a revision", gotMsgs)
+	}
+	rev.SeqId = 999
+	if err = adp.MessageEditSave(rev); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+}
+
+func TestMessageReact(t *testing.T) {
+	topic := testData.Topics[0].Id
+	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
+	uid1 := types.ParseUserId("usr" + testData.Users[1].Id)
+	uid2 := types.ParseUserId("usr" + testData.Users[2].Id)
+	for _, r := range []struct {
+		uid   types.Uid
+		value string
+	}{{uid0, "+1"}, {uid1, "+1"}, {uid2, "heart"}, {uid0, "heart"}} {
+		if err := adp.MessageReact(topic, 1, r.uid, r.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reacts, err := adp.MessageGetReactions(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reacts) != 2 {
+		t.Fatal(mismatchErrorString("Reactions length", len(reacts), 2))
+	}
+	if reacts[0].Value != "heart" || reacts[0].Count != 2 || !reacts[0].Mine {
+		t.Error("Wrong first reaction", reacts[0])
+	}
+	if reacts[1].Value != "+1" || reacts[1].Count != 1 || reacts[1].Mine {
+		t.Error("Wrong second reaction", reacts[1])
+	}
+
+	// Remove reaction.
+	if err = adp.MessageReact(topic, 1, uid2, ""); err != nil {
+		t.Fatal(err)
+	}
+	reacts, _ = adp.MessageGetReactions(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 1}}})
+	if len(reacts) != 2 || reacts[0].Count != 1 || reacts[1].Count != 1 {
+		t.Error("Reaction not removed", reacts)
+	}
+	reacts, _ = adp.MessageGetReactions(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 2, Hi: 4}}})
+	if len(reacts) != 0 {
+		t.Error(mismatchErrorString("Reactions length ranges", len(reacts), 0))
+	}
+
+	// Reaction to a non-existent message.
+	if err = adp.MessageReact(topic, 999, uid0, "+1"); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	userReacts, err := adp.MessageGetUserReactions(topic, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading one reaction at a time returns the same reactions.
+	var pagedReacts []adapter.UserReaction
+	var afterReact *adapter.UserReaction
+	for {
+		page, err := adp.MessageGetUserReactions(topic, afterReact, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pagedReacts = append(pagedReacts, page...)
+		afterReact = &page[0]
+	}
+	if !reflect.DeepEqual(pagedReacts, userReacts) {
+		t.Error(mismatchErrorString("Paged user reactions", pagedReacts, userReacts))
+	}
+	byUser := map[string]string{}
+	for _, r := range userReacts {
+		if r.SeqId == 1 {
+			byUser[r.User] = r.Value
+		}
+	}
+	expect := map[string]string{uid0.String(): "heart", uid1.String(): "+1"}
+	if !reflect.DeepEqual(byUser, expect) {
+		t.Error(mismatchErrorString("User reactions", byUser, expect))
+	}
+}
+
+func TestMessageVote(t *testing.T) {
+	topic := testData.Topics[0].Id
+	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
+	uid1 := types.ParseUserId("usr" + testData.Users[1].Id)
+	uid2 := types.ParseUserId("usr" + testData.Users[2].Id)
+	for _, v := range []struct {
+		uid  types.Uid
+		opts []int
+	}{{uid0, []int{1}}, {uid1, []int{0, 1}}, {uid2, []int{2}}, {uid0, []int{0, 2}}} {
+		if err := adp.MessageVote(topic, 2, v.uid, v.opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second vote of uid0 replaced the first one.
+	votes, err := adp.MessageGetVotes(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []types.MessageVote{
+		{SeqId: 2, Option: 0, Count: 2, Mine: true},
+		{SeqId: 2, Option: 1, Count: 1},
+		{SeqId: 2, Option: 2, Count: 2, Mine: true},
+	}
+	if !reflect.DeepEqual(votes, expect) {
+		t.Error(mismatchErrorString("Votes", votes, expect))
+	}
+
+	// Remove vote.
+	if err = adp.MessageVote(topic, 2, uid1, nil); err != nil {
+		t.Fatal(err)
+	}
+	votes, _ = adp.MessageGetVotes(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 2}}})
+	if len(votes) != 2 || votes[0].Count != 1 || votes[1].Option != 2 || votes[1].Count != 2 {
+		t.Error("Vote not removed", votes)
+	}
+	votes, _ = adp.MessageGetVotes(topic, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 3, Hi: 5}}})
+	if len(votes) != 0 {
+		t.Error(mismatchErrorString("Votes length ranges", len(votes), 0))
+	}
+
+	// Vote in a non-existent message.
+	if err = adp.MessageVote(topic, 999, uid0, []int{0}); err != types.ErrNotFound {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+
+	userVotes, err := adp.MessageGetUserVotes(topic, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reading one option at a time returns the same votes.
+	var pagedVotes []adapter.UserVote
+	var afterVote *adapter.UserVote
+	for {
+		page, err := adp.MessageGetUserVotes(topic, afterVote, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pagedVotes = append(pagedVotes, page...)
+		afterVote = &page[0]
+	}
+	if !reflect.DeepEqual(pagedVotes, userVotes) {
+		t.Error(mismatchErrorString("Paged user votes", pagedVotes, userVotes))
+	}
+	byUser := map[string][]int{}
+	for _, v := range userVotes {
+		if v.SeqId == 2 {
+			byUser[v.User] = append(byUser[v.User], v.Option)
+		}
+	}
+	expectByUser := map[string][]int{uid0.String(): {0, 2}, uid2.String(): {2}}
+	if !reflect.DeepEqual(byUser, expectByUser) {
+		t.Error(mismatchErrorString("User votes", byUser, expectByUser))
+	}
+}
+
+func TestMessageThreads(t *testing.T) {
+	topic := testData.Topics[1].Id
+	for i, parent := range []int{5, 5, 1} {
+		ts := testData.Now.Add(time.Duration(i+1) * time.Minute)
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: ts, UpdatedAt: ts},
+			SeqId:     20 + i,
+			Topic:     topic,
+			Parent:    parent,
+			From:      testData.Users[0].Id,
+			Content:   "reply",
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replies, err := adp.MessageGetAll(topic, types.ZeroUid, &types.QueryOpt{Thread: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 {
+		t.Fatal(mismatchErrorString("Replies length", len(replies), 2))
+	}
+	if replies[0].SeqId != 21 || replies[0].Parent != 5 || replies[1].SeqId != 20 {
+		t.Error("Wrong replies", replies)
+	}
+
+	threads, err := adp.MessageGetThreads(topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 1}, {Low: 5}, {Low: 11}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 2 {
+		t.Fatal(mismatchErrorString("Threads length", len(threads), 2))
+	}
+	if threads[0].SeqId != 1 || threads[0].Replies != 1 {
+		t.Error("Wrong first thread", threads[0])
+	}
+	if threads[1].SeqId != 5 || threads[1].Replies != 2 ||
+		!threads[1].LastReplyAt.Equal(testData.Now.Add(2*time.Minute)) {
+		t.Error("Wrong second thread", threads[1])
+	}
+
+	threads, _ = adp.MessageGetThreads(topic, &types.QueryOpt{IdRanges: []types.Range{{Low: 11}}})
+	if len(threads) != 0 {
+		t.Error(mismatchErrorString("Threads length ranges", len(threads), 0))
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	topic := testData.Topics[1].Id
+	var ids []string
+	for i := range 3 {
+		smsg := &types.ScheduledMessage{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: testData.Now, UpdatedAt: testData.Now},
+			DeliverAt: testData.Now.Add(time.Duration(3-i) * time.Hour),
+			Topic:     topic,
+			From:      testData.Users[i%2].Id,
+			Parent:    i,
+			Content:   fmt.Sprint("later ", i),
+		}
+		if err := adp.ScheduledMessageSave(smsg); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, smsg.Id)
+	}
+
+	uid0 := types.ParseUid(testData.Users[0].Id)
+	scheduled, err := adp.ScheduledMessageGetAll(topic, uid0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 2 {
+		t.Fatal(mismatchErrorString("Scheduled length", len(scheduled), 2))
+	}
+	if scheduled[0].Id != ids[2] || scheduled[0].Parent != 2 || scheduled[0].Content != "later 2" ||
+		scheduled[0].From != testData.Users[0].Id || !scheduled[0].DeliverAt.Equal(testData.Now.Add(time.Hour)) {
+		t.Error("Wrong first scheduled message", scheduled[0])
+	}
+	if scheduled[1].Id != ids[0] {
+		t.Error(mismatchErrorString("Second scheduled message", scheduled[1].Id, ids[0]))
+	}
+
+	all, err := adp.ScheduledMessageGetAll(topic, types.ZeroUid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Id != ids[2] || all[2].Id != ids[0] {
+		t.Error("Wrong messages scheduled by all users", all)
+	}
+
+	due, err := adp.ScheduledMessageGetDue(testData.Now.Add(150*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].Id != ids[2] || due[1].Id != ids[1] {
+		t.Error("Wrong due messages", due)
+	}
+	due, _ = adp.ScheduledMessageGetDue(testData.Now.Add(150*time.Minute), 1)
+	if len(due) != 1 {
+		t.Error(mismatchErrorString("Due length limited", len(due), 1))
+	}
+
+	// Claimed messages are not due until the claim expires.
+	now := testData.Now.Add(150 * time.Minute)
+	if err = adp.ScheduledMessageClaim(ids[2], now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err = adp.ScheduledMessageClaim(ids[2], now, now.Add(time.Minute)); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Repeated claim", err, types.ErrNotFound))
+	}
+	due, _ = adp.ScheduledMessageGetDue(now, 10)
+	if len(due) != 1 || due[0].Id != ids[1] {
+		t.Error("Wrong due messages with a claim", due)
+	}
+	later := now.Add(2 * time.Minute)
+	due, _ = adp.ScheduledMessageGetDue(later, 10)
+	if len(due) != 2 {
+		t.Error(mismatchErrorString("Due length after the claim expired", len(due), 2))
+	}
+	if err = adp.ScheduledMessageClaim(ids[2], later, later.Add(time.Minute)); err != nil {
+		t.Error("Claim after expiration:", err)
+	}
+
+	// Messages scheduled by another user cannot be deleted.
+	if err = adp.ScheduledMessageDelete(topic, types.ParseUid(testData.Users[1].Id), ids[0]); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Delete by another user", err, types.ErrNotFound))
+	}
+	if err = adp.ScheduledMessageDelete(topic, uid0, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Already deleted.
+	if err = adp.ScheduledMessageDelete(topic, types.ZeroUid, ids[0]); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Repeated delete", err, types.ErrNotFound))
+	}
+	for _, id := range ids[1:] {
+		if err = adp.ScheduledMessageDelete(topic, types.ZeroUid, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, _ = adp.ScheduledMessageGetDue(testData.Now.Add(24*time.Hour), 10)
+	if len(due) != 0 {
+		t.Error(mismatchErrorString("Due length after delete", len(due), 0))
+	}
+}
+
+func TestAuthSessions(t *testing.T) {
+	uid := types.ParseUid(testData.Users[0].Id)
+	// Expired sessions are not returned, so the expiration time is relative to the real time.
+	expires := types.TimeNow().Add(24 * time.Hour)
+	var ids []string
+	for i := range 3 {
+		sess := &types.AuthSession{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(),
+				CreatedAt: testData.Now.Add(time.Duration(i) * time.Minute),
+				UpdatedAt: testData.Now.Add(time.Duration(i) * time.Minute)},
+			User:      testData.Users[i/2].Id,
+			Expires:   expires,
+			DeviceId:  fmt.Sprint("device-", i),
+			Platform:  "web",
+			UserAgent: "TinodeWeb/1.0",
+		}
+		if err := adp.AuthSessionCreate(sess); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, sess.Id)
+	}
+
+	sessions, err := adp.AuthSessionGetAll(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatal(mismatchErrorString("Sessions length", len(sessions), 2))
+	}
+	if sessions[0].Id != ids[0] || sessions[1].Id != ids[1] {
+		t.Error("Wrong order of sessions", sessions)
+	}
+	if sessions[0].DeviceId != "device-0" || sessions[0].User != testData.Users[0].Id ||
+		!sessions[0].Expires.Equal(expires) {
+		t.Error("Wrong first session", sessions[0])
+	}
+
+	// Expired sessions are not returned and are deleted by AuthSessionExpire.
+	expired := &types.AuthSession{
+		ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: testData.Now, UpdatedAt: testData.Now},
+		User:      testData.Users[0].Id,
+		Expires:   types.TimeNow().Add(-time.Hour),
+	}
+	if err = adp.AuthSessionCreate(expired); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ = adp.AuthSessionGetAll(uid); len(sessions) != 2 {
+		t.Error(mismatchErrorString("Sessions length with expired", len(sessions), 2))
+	}
+	if err = adp.AuthSessionExpire(types.TimeNow()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := adp.AuthSessionGet(expired.Id); got != nil {
+		t.Error("Expired session not deleted", got)
+	}
+	if got, _ := adp.AuthSessionGet(ids[0]); got == nil {
+		t.Error("Live session deleted")
+	}
+
+	update := sessions[1]
+	update.UpdatedAt = testData.Now.Add(time.Hour)
+	update.UserAgent = "TinodeWeb/2.0"
+	update.RemoteAddr = "192.0.2.1"
+	if err = adp.AuthSessionUpdate(&update); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.AuthSessionGet(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.UserAgent != "TinodeWeb/2.0" || got.RemoteAddr != "192.0.2.1" ||
+		!got.UpdatedAt.Equal(testData.Now.Add(time.Hour)) {
+		t.Error("Session not updated", got)
+	}
+
+	// Sessions of another user are not deleted.
+	if err = adp.AuthSessionDelete(uid, []string{ids[1], ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = adp.AuthSessionGet(ids[1]); got != nil {
+		t.Error("Session not deleted", got)
+	}
+	if got, _ = adp.AuthSessionGet(ids[2]); got == nil {
+		t.Error("Session of another user deleted")
+	}
+	update.Id = ids[1]
+	if err = adp.AuthSessionUpdate(&update); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Update deleted session", err, types.ErrNotFound))
+	}
+
+	if err = adp.AuthSessionDelete(uid, []string{ids[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if err = adp.AuthSessionDelete(types.ParseUid(testData.Users[1].Id), []string{ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ = adp.AuthSessionGetAll(uid); len(sessions) != 0 {
+		t.Error(mismatchErrorString("Sessions length after delete", len(sessions), 0))
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	var ids []string
+	for i := range 2 {
+		key := &types.APIKey{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(),
+				CreatedAt: testData.Now.Add(time.Duration(i) * time.Minute),
+				UpdatedAt: testData.Now.Add(time.Duration(i) * time.Minute)},
+			Name:      fmt.Sprint("key-", i),
+			Origins:   types.StringSlice{"https://example.com"},
+			RateLimit: 60 * i,
+		}
+		if err := adp.APIKeyCreate(key); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, key.Id)
+	}
+
+	keys, err := adp.APIKeyGetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatal(mismatchErrorString("Keys length", len(keys), 2))
+	}
+	if keys[0].Id != ids[0] || keys[1].Id != ids[1] {
+		t.Error("Wrong order of keys", keys)
+	}
+	if keys[1].Name != "key-1" || keys[1].RateLimit != 60 || len(keys[1].Origins) != 1 ||
+		len(keys[1].Messages) != 0 || keys[1].RevokedAt != nil {
+		t.Error("Wrong second key", keys[1])
+	}
+
+	update := keys[0]
+	revokedAt := testData.Now.Add(time.Hour)
+	update.UpdatedAt = revokedAt
+	update.RevokedAt = &revokedAt
+	update.Messages = types.StringSlice{"hi", "login"}
+	if err = adp.APIKeyUpdate(&update); err != nil {
+		t.Fatal(err)
+	}
+	got, err := adp.APIKeyGet(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.RevokedAt == nil || !got.RevokedAt.Equal(revokedAt) || len(got.Messages) != 2 {
+		t.Error("Key not updated", got)
+	}
+
+	if got, _ = adp.APIKeyGet(testData.UGen.GetStr()); got != nil {
+		t.Error("Unknown key found", got)
+	}
+	update.SetUid(testData.UGen.Get())
+	if err = adp.APIKeyUpdate(&update); err != types.ErrNotFound {
+		t.Error(mismatchErrorString("Update unknown key", err, types.ErrNotFound))
+	}
+}
+
+func TestUserList(t *testing.T) {
+	all, err := adp.UserList(types.ZeroUid, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) < 2 {
+		t.Fatal(mismatchErrorString("Users length", len(all), len(testData.Users)))
+	}
+
+	// Read the same users page by page.
+	var paged []string
+	after := types.ZeroUid
+	for range len(all) {
+		users, err := adp.UserList(after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) == 0 {
+			break
+		}
+		for i := range users {
+			paged = append(paged, users[i].Id)
+		}
+		after = users[len(users)-1].Uid()
+	}
+	if len(paged) != len(all) {
+		t.Fatal(mismatchErrorString("Paged users length", len(paged), len(all)))
+	}
+	for i := range all {
+		if all[i].Id != paged[i] {
+			t.Error(mismatchErrorString("User", paged[i], all[i].Id))
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	alice, bob := testData.Users[0].Id, testData.Users[1].Id
+	for i, rec := range []*types.AuditRecord{
+		{Action: "login", Actor: alice, User: alice, RemoteAddr: "10.0.0.1"},
+		{Action: "obo", Actor: alice, User: bob, Topic: "grpAbc", Params: types.KVMap{"what": "pub"}},
+		{Action: "login_failed", Params: types.KVMap{"scheme": "basic"}},
+	} {
+		rec.Id = testData.UGen.GetStr()
+		rec.CreatedAt = testData.Now.Add(time.Duration(i) * time.Minute)
+		if err := adp.AuditAdd(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := adp.AuditGetAll(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Action != "login_failed" || all[2].Action != "login" {
+		t.Fatal("Wrong records or order", all)
+	}
+	if all[0].Actor != "" || all[0].User != "" || all[0].Params["scheme"] != "basic" {
+		t.Error("Wrong record without users", all[0])
+	}
+	if all[1].Actor != alice || all[1].User != bob || all[1].Topic != "grpAbc" || all[1].Params["what"] != "pub" {
+		t.Error("Wrong obo record", all[1])
+	}
+
+	for name, tc := range map[string]struct {
+		query    *types.AuditQuery
+		expected []string
+	}{
+		"user":   {&types.AuditQuery{User: types.ParseUid(bob)}, []string{"obo"}},
+		"actor":  {&types.AuditQuery{User: types.ParseUid(alice)}, []string{"obo", "login"}},
+		"action": {&types.AuditQuery{Action: "login"}, []string{"login"}},
+		"time":   {&types.AuditQuery{Since: &all[1].CreatedAt, Before: &all[0].CreatedAt}, []string{"obo"}},
+		"limit":  {&types.AuditQuery{Limit: 1}, []string{"login_failed"}},
+	} {
+		got, err := adp.AuditGetAll(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for i := range got {
+			actions = append(actions, got[i].Action)
+		}
+		if !reflect.DeepEqual(actions, tc.expected) {
+			t.Error(mismatchErrorString(name, actions, tc.expected))
+		}
+	}
+
+	// Paging by ID returns every record once.
+	page, err := adp.AuditList("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 {
+		t.Fatal(mismatchErrorString("First page length", len(page), 2))
+	}
+	rest, err := adp.AuditList(page[1].Id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, rec := range append(page, rest...) {
+		seen[rec.Id] = true
+	}
+	if len(rest) != 1 || len(seen) != 3 {
+		t.Error("Wrong pages", page, rest)
+	}
+}
+
+func TestMessageGetExpired(t *testing.T) {
+	// Messages are saved to a separate topic so that messages saved by other tests don't interfere.
+	topic := &types.Topic{
+		ObjHeader: types.ObjHeader{
+			Id:        "grpExpiredMsgs",
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		TouchedAt: testData.Now,
+		Owner:     testData.Users[0].Id,
+		SeqId:     4,
+	}
+	if err := adp.TopicCreate(topic); err != nil {
+		t.Fatal(err)
+	}
+	defer adp.TopicDelete(topic.Id, false, true)
+
+	for i, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, -time.Hour} {
+		ts := testData.Now.Add(-age)
+		msg := &types.Message{
+			ObjHeader: types.ObjHeader{Id: testData.UGen.GetStr(), CreatedAt: ts, UpdatedAt: ts},
+			SeqId:     i + 1,
+			Topic:     topic.Id,
+			From:      testData.Users[0].Id,
+			Content:   "expiring",
+		}
+		if err := adp.MessageSave(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seqIDs, err := adp.MessageGetExpired(topic.Id, testData.Now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1, 2, 3}) {
+		t.Error(mismatchErrorString("Expired messages", seqIDs, []int{1, 2, 3}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1, 2}) {
+		t.Error(mismatchErrorString("Expired messages limited", seqIDs, []int{1, 2}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now.Add(-150*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(seqIDs, []int{1}) {
+		t.Error(mismatchErrorString("Messages expired 2.5 hours ago", seqIDs, []int{1}))
+	}
+
+	seqIDs, err = adp.MessageGetExpired(topic.Id, testData.Now.Add(-4*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqIDs) != 0 {
+		t.Error(mismatchErrorString("Messages expired 4 hours ago", len(seqIDs), 0))
+	}
+}
+
+func TestMessageAttachments(t *testing.T) {
+	fids := []string{testData.Files[0].Id, testData.Files[1].Id}
+	err := adp.FileLinkAttachments("", types.ZeroUid, types.ParseUid(testData.Msgs[1].Id), fids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Check if attachments were linked (this would require checking filemsglinks table)
+	var count int
+	if err = db.QueryRow("SELECT COUNT(*) FROM filemsglinks WHERE msgid=?",
+		types.ParseUid(testData.Msgs[1].Id)).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != len(fids) {
+		t.Error(mismatchErrorString("Attachments count", count, len(fids)))
+	}
+}
+
+func TestFileGetMessageAttachments(t *testing.T) {
+	got, err := adp.FileGetMessageAttachments(testData.Msgs[1].Topic, testData.Msgs[1].SeqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testData.Files[0].Id, testData.Files[1].Id}
+	if len(got) != len(want) {
+		t.Fatal(mismatchErrorString("Attachments count", len(got), len(want)))
+	}
+	for _, fid := range want {
+		found := false
+		for _, id := range got {
+			if id == fid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Error(mismatchErrorString("Attachments", got, want))
+		}
+	}
+
+	// Message without attachments.
+	got, err = adp.FileGetMessageAttachments(testData.Msgs[0].Topic, testData.Msgs[0].SeqId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Error(mismatchErrorString("Attachments count", len(got), 0))
+	}
+}
+
+func TestFileDelete(t *testing.T) {
+	// The file is linked to a message.
+	loc, err := adp.FileDelete(testData.Files[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "" {
+		t.Error(mismatchErrorString("Location of linked file", loc, ""))
+	}
+	if got, _ := adp.FileGet(testData.Files[0].Id); got == nil {
+		t.Error("Linked file must not be deleted")
+	}
+
+	fd := &types.FileDef{
+		ObjHeader: types.ObjHeader{
+			Id:        types.Uid(10001).String(),
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		Status:   types.UploadCompleted,
+		MimeType: "application/zip",
+		Location: "uploads/export.zip",
+	}
+	if err = adp.FileStartUpload(fd); err != nil {
+		t.Fatal(err)
+	}
+	loc, err = adp.FileDelete(fd.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != fd.Location {
+		t.Error(mismatchErrorString("Location", loc, fd.Location))
+	}
+	if got, _ := adp.FileGet(fd.Id); got != nil {
+		t.Error("File record must be deleted")
+	}
+	// Already deleted.
+	if loc, err = adp.FileDelete(fd.Id); err != nil || loc != "" {
+		t.Error(mismatchErrorString("Location of deleted file", loc, ""), err)
+	}
+}
+
+func TestFileFinishUpload(t *testing.T) {
+	got, err := adp.FileFinishUpload(testData.Files[0], true, 22222)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != types.UploadCompleted {
+		t.Error(mismatchErrorString("Status", got.Status, types.UploadCompleted))
+	}
+	if got.Size != 22222 {
+		t.Error(mismatchErrorString("Size", got.Size, 22222))
+	}
+}
+
+func TestFileGetAll(t *testing.T) {
+	uid := types.ParseUserId("usr" + testData.Users[0].Id)
+	// Only completed uploads are returned.
+	got, err := adp.FileGetAll(uid, types.ZeroUid, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatal(mismatchErrorString("Files length", len(got), 1))
+	}
+	if got[0].Id != testData.Files[0].Id || got[0].User != uid.String() || got[0].Size != 22222 {
+		t.Error(mismatchErrorString("File", got[0], testData.Files[0]))
+	}
+
+	got, err = adp.FileGetAll(uid, got[0].Uid(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Error(mismatchErrorString("Files after the last", len(got), 0))
+	}
+}
+
+// ================== Other tests =================================
+func TestDeviceGetAll(t *testing.T) {
+	uid0 := types.ParseUserId("usr" + testData.Users[0].Id)
+	uid1 := types.ParseUserId("usr" + testData.Users[1].Id)
+	uid2 := types.ParseUserId("usr" + testData.Users[2].Id)
+	gotDevs, count, err := adp.DeviceGetAll(uid0, uid1, uid2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatal(mismatchErrorString("count", count, 2))
+	}
+	if !reflect.DeepEqual(gotDevs[uid1][0], *testData.Devs[0]) {
+		t.Error(mismatchErrorString("Device", gotDevs[uid1][0], *testData.Devs[0]))
+	}
+	if !reflect.DeepEqual(gotDevs[uid2][0], *testData.Devs[1]) {
+		t.Error(mismatchErrorString("Device", gotDevs[uid2][0], *testData.Devs[1]))
+	}
+}
+
+func TestDeviceDelete(t *testing.T) {
+	err := adp.DeviceDelete(types.ParseUserId("usr"+testData.Users[1].Id), testData.Devs[0].DeviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM devices WHERE userid=?", testData.Users[1].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Device not deleted:", count)
+	}
+
+	err = adp.DeviceDelete(types.ParseUserId("usr"+testData.Users[2].Id), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM devices WHERE userid=?", testData.Users[2].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Device not deleted:", count)
+	}
+}
+
+// ================== Persistent Cache tests ======================
+func TestPCacheUpsert(t *testing.T) {
+	err := adp.PCacheUpsert("test_key", "test_value", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test duplicate with failOnDuplicate = true
+	err = adp.PCacheUpsert("test_key2", "test_value2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = adp.PCacheUpsert("test_key2", "new_value", true)
+	if err != types.ErrDuplicate {
+		t.Error("Expected duplicate error")
+	}
+}
+
+func TestPCacheGet(t *testing.T) {
+	value, err := adp.PCacheGet("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "test_value" {
+		t.Error(mismatchErrorString("Cache value", value, "test_value"))
+	}
+
+	// Test not found
+	_, err = adp.PCacheGet("nonexistent")
+	if err != types.ErrNotFound {
+		t.Error("Expected not found error")
+	}
+}
+
+func TestPCacheList(t *testing.T) {
+	entries, err := adp.PCacheList("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+	if values["test_key"] != "test_value" || values["test_key2"] != "test_value2" {
+		t.Error(mismatchErrorString("Cache entries", values, "test_key, test_key2"))
+	}
+	if _, ok := values["version"]; ok {
+		t.Error("Schema version should not be listed")
+	}
+
+	// Paging
+	entries, err = adp.PCacheList("test_key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "test_key2" {
+		t.Error(mismatchErrorString("Cache entries", entries, "test_key2"))
+	}
+}
+
+func TestPCacheSwap(t *testing.T) {
+	if err := adp.PCacheUpsert("swap_key", "1", true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := adp.PCacheSwap("swap_key", "1", "2"); err != nil || !ok {
+		t.Fatal("Swap failed", ok, err)
+	}
+	// Stale value.
+	if ok, err := adp.PCacheSwap("swap_key", "1", "3"); err != nil || ok {
+		t.Error("Swap of a stale value must fail", ok, err)
+	}
+	if value, _ := adp.PCacheGet("swap_key"); value != "2" {
+		t.Error(mismatchErrorString("Swapped value", value, "2"))
+	}
+	// Missing entry.
+	if ok, err := adp.PCacheSwap("nonexistent", "", "1"); err != nil || ok {
+		t.Error("Swap of a missing entry must fail", ok, err)
+	}
+	if err := adp.PCacheDelete("swap_key"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPCacheDelete(t *testing.T) {
+	err := adp.PCacheDelete("test_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify deleted
+	_, err = adp.PCacheGet("test_key")
+	if err != types.ErrNotFound {
+		t.Error("Key should be deleted")
+	}
+}
+
+func TestPCacheExpire(t *testing.T) {
+	// Insert some test keys with prefix
+	adp.PCacheUpsert("prefix_key1", "value1", false)
+	adp.PCacheUpsert("prefix_key2", "value2", false)
+
+	// Expire keys older than now (should delete all test keys)
+	err := adp.PCacheExpire("prefix_", time.Now().Add(1*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ================== Delete tests ================================
+func TestCredDel(t *testing.T) {
+	err := adp.CredDel(types.ParseUserId("usr"+testData.Users[0].Id), "email", "alice@test.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM credentials WHERE method='email' AND value='alice@test.example.com'").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Got result but shouldn't", count)
+	}
+
+	err = adp.CredDel(types.ParseUserId("usr"+testData.Users[1].Id), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM credentials WHERE userid=?", testData.Users[1].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Got result but shouldn't", count)
+	}
+}
+
+func TestAuthDelScheme(t *testing.T) {
+	// tested during TestAuthUpdRecord
+}
+
+func TestAuthDelAllRecords(t *testing.T) {
+	delCount, err := adp.AuthDelAllRecords(types.ParseUserId("usr" + testData.Recs[0].UserId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delCount != 1 {
+		t.Error(mismatchErrorString("delCount", delCount, 1))
+	}
+
+	// With dummy user
+	delCount, _ = adp.AuthDelAllRecords(dummyUid1)
+	if delCount != 0 {
+		t.Error(mismatchErrorString("delCount", delCount, 0))
+	}
+}
+
+func TestSubsDelForUser(t *testing.T) {
+	// Tested during TestUserDelete (both hard and soft deletions)
+}
+
+func TestMessageDeleteList(t *testing.T) {
+	toDel := types.DelMessage{
+		ObjHeader: types.ObjHeader{
+			Id:        testData.UGen.GetStr(),
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		Topic:       testData.Topics[1].Id,
+		DeletedFor:  testData.Users[2].Id,
+		DelId:       1,
+		SeqIdRanges: []types.Range{{Low: 9}, {Low: 3, Hi: 7}},
+	}
+	err := adp.MessageDeleteList(toDel.Topic, &toDel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check messages in dellog
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM dellog WHERE topic=? AND deletedfor=?",
+		toDel.Topic, decodeUid(toDel.DeletedFor)).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Error("No dellog entries created")
+	}
+
+	// Hard delete test
+	toDel = types.DelMessage{
+		ObjHeader: types.ObjHeader{
+			Id:        testData.UGen.GetStr(),
+			CreatedAt: testData.Now,
+			UpdatedAt: testData.Now,
+		},
+		Topic:       testData.Topics[0].Id,
+		DelId:       3,
+		SeqIdRanges: []types.Range{{Low: 1, Hi: 3}},
+	}
+	err = adp.MessageDeleteList(toDel.Topic, &toDel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check if messages content was cleared
+	err = db.QueryRow("SELECT COUNT(*) FROM messages WHERE topic=? AND content IS NOT NULL",
+		toDel.Topic).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count > 1 {
+		t.Errorf("Messages not properly deleted %d, %s", count, toDel.Topic)
+	}
+
+	err = adp.MessageDeleteList(testData.Topics[0].Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM messages WHERE topic=?", testData.Topics[0].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Result should be empty:", count)
+	}
+}
+
+func TestTopicDelete(t *testing.T) {
+	err := adp.TopicDelete(testData.Topics[1].Id, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state int
+	err = db.QueryRow("SELECT state FROM topics WHERE name=?", testData.Topics[1].Id).Scan(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != int(types.StateDeleted) {
+		t.Error("Soft delete failed:", state)
+	}
+
+	err = adp.TopicDelete(testData.Topics[0].Id, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM topics WHERE name=?", testData.Topics[0].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Hard delete failed:", count)
+	}
+}
+
+func TestFileDeleteUnused(t *testing.T) {
+	locs, err := adp.FileDeleteUnused(time.Now().Add(1*time.Minute), 999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 2 {
+		t.Error(mismatchErrorString("Locations length", len(locs), 2))
+	}
+}
+
+func TestUserDelete(t *testing.T) {
+	err := adp.UserDelete(types.ParseUserId("usr"+testData.Users[0].Id), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state int
+	err = db.QueryRow("SELECT state FROM users WHERE id=?",
+		decodeUid(testData.Users[0].Id)).Scan(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != int(types.StateDeleted) {
+		t.Error("User soft delete failed", state)
+	}
+
+	err = adp.UserDelete(types.ParseUserId("usr"+testData.Users[1].Id), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE id=?", testData.Users[1].Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("User hard delete failed")
+	}
+}
+
+// ================== Other tests =================================
+
+func TestUserUnreadCount(t *testing.T) {
+	uids := []types.Uid{
+		types.ParseUserId("usr" + testData.Users[1].Id),
+		types.ParseUserId("usr" + testData.Users[2].Id),
+	}
+	expected := map[types.Uid]int{uids[0]: 0, uids[1]: 166}
+	counts, err := adp.UserUnreadCount(uids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 {
+		t.Error(mismatchErrorString("UnreadCount length", len(counts), 2))
+	}
+
+	for uid, unread := range counts {
+		if expected[uid] != unread {
+			t.Error(mismatchErrorString("UnreadCount", unread, expected[uid]))
+		}
+	}
+
+	// Test not found (even if the account is not found, the call must return one record).
+	counts, err = adp.UserUnreadCount(dummyUid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 {
+		t.Error(mismatchErrorString("UnreadCount length (dummy)", len(counts), 1))
+	}
+	if counts[dummyUid1] != 0 {
+		t.Error(mismatchErrorString("Non-zero UnreadCount (dummy)", counts[dummyUid1], 0))
+	}
+}
+
+func TestMessageGetDeleted(t *testing.T) {
+	qOpts := types.QueryOpt{
+		Since:  1,
+		Before: 10,
+		Limit:  999,
+	}
+	got, err := adp.MessageGetDeleted(testData.Topics[1].Id, types.ParseUserId("usr"+testData.Users[2].Id), &qOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Error(mismatchErrorString("result length", len(got), 1))
+	}
+}
+
+// ================================================================
+func mismatchErrorString(key string, got, want any) string {
+	return fmt.Sprintf("%s mismatch:\nGot  = %+v\nWant = %+v", key, got, want)
+}
+
+func init() {
+	logs.Init(os.Stderr, "stdFlags")
+	adp = backend.GetTestAdapter()
+	conffile := flag.String("config", "./test.conf", "config of the database connection")
+
+	if file, err := os.Open(*conffile); err != nil {
+		log.Fatal("Failed to read config file:", err)
+	} else if err = json.NewDecoder(jcr.New(file)).Decode(&config); err != nil {
+		log.Fatal("Failed to parse config file:", err)
+	}
+
+	if adp == nil {
+		log.Fatal("Database adapter is missing")
+	}
+	if adp.IsOpen() {
+		log.Print("Connection is already opened")
+	}
+
+	err := adp.Open(config.Adapters[adp.GetName()])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db = adp.GetTestDB().(*sqlx.DB)
+	testData = test_data.InitTestData()
+	if testData == nil {
+		log.Fatal("Failed to initialize test data")
+	}
+	store.SetTestUidGenerator(*testData.UGen)
+}
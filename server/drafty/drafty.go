@@ -102,6 +102,8 @@ func Preview(content any, length int) (string, error) {
 
 type plainTextState struct {
 	txt string
+	// Produce text for full-text search: no formatting, URLs or attachment placeholders.
+	search bool
 }
 
 // PlainText converts drafty document to plain text with some basic markdown-like formatting.
@@ -130,6 +132,55 @@ func PlainText(content any) (string, error) {
 	return strings.TrimSpace(string(state.txt)), nil
 }
 
+// SearchText converts drafty document to plain text suitable for full-text search: the text of
+// the document with the text of entities, such as poll options and button labels, but without
+// formatting, URLs and other entity data.
+func SearchText(content any) (string, error) {
+	doc, err := decodeAsDrafty(content)
+	if err != nil {
+		return "", err
+	}
+	if doc == nil {
+		return "", nil
+	}
+
+	tree, err := toTree(doc)
+	if err != nil {
+		return "", err
+	}
+
+	state := plainTextState{search: true}
+	if err = plainTextFormatter(tree, &state); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(state.txt), nil
+}
+
+// PollOptions finds the poll declared in a Drafty document as an entity of type PL, such as
+// {"tp":"PL","data":{"opts":["Yes","No"],"multi":false}}, and returns the number of poll options
+// and whether more than one option can be chosen. The count is 0 if the document contains no poll.
+func PollOptions(content any) (int, bool, error) {
+	doc, err := decodeAsDrafty(content)
+	if err != nil || doc == nil {
+		return 0, false, err
+	}
+
+	for i := range doc.Ent {
+		if doc.Ent[i].Tp != "PL" {
+			continue
+		}
+		opts := pollOptionNames(doc.Ent[i].Data)
+		if len(opts) < 2 {
+			// A poll must have at least two options.
+			return 0, false, errInvalidContent
+		}
+		multi, _ := doc.Ent[i].Data["multi"].(bool)
+		return len(opts), multi, nil
+	}
+	return 0, false, nil
+}
+
 // styleToSpan converts Drafty style to internal representation.
 func (s *span) styleToSpan(in *style) error {
 	s.tp = in.Tp
@@ -285,26 +336,40 @@ func plainTextFormatter(n *node, ctx any) error {
 		return nil
 	}
 
+	state := ctx.(*plainTextState)
+
 	var text string
 	if len(n.children) > 0 {
-		state := &plainTextState{}
+		childState := &plainTextState{search: state.search}
 		for _, c := range n.children {
-			if err := plainTextFormatter(c, state); err != nil {
+			if err := plainTextFormatter(c, childState); err != nil {
 				return err
 			}
 		}
-		text = string(state.txt)
+		text = string(childState.txt)
 	} else {
 		text = n.gc.string()
 	}
 
-	state := ctx.(*plainTextState)
-
 	if n.sp == nil {
 		state.txt += text
 		return nil
 	}
 
+	if state.search {
+		switch n.sp.tp {
+		case "BR":
+			state.txt += "\n"
+		case "PL":
+			state.txt += strings.TrimSpace(text + " " + strings.Join(pollOptionNames(n.sp.data), " "))
+		case "AU", "EX", "IM", "VD", "VC":
+			// Attachments and calls have no searchable text.
+		default:
+			state.txt += text
+		}
+		return nil
+	}
+
 	switch n.sp.tp {
 	case "ST", "EM", "DL", "CO":
 		state.txt += tags[n.sp.tp].dec + text + tags[n.sp.tp].dec
@@ -329,6 +394,16 @@ func plainTextFormatter(n *node, ctx any) error {
 		state.txt += "[" + expand[n.sp.tp] + " '" + name + "']"
 	case "VC":
 		state.txt += "[CALL]"
+	case "PL":
+		// Poll: the question followed by the list of options.
+		if text != "" {
+			state.txt += text + " "
+		}
+		state.txt += "[POLL"
+		if opts := pollOptionNames(n.sp.data); len(opts) > 0 {
+			state.txt += ": " + strings.Join(opts, " / ")
+		}
+		state.txt += "]"
 	default:
 		state.txt += text
 	}
@@ -393,7 +468,12 @@ func previewFormatter(n *node, ctx any) error {
 			key, ok := state.keymap[n.sp.key]
 			if !ok {
 				// Payload not found, add it.
-				ent := entity{Tp: n.sp.tp, Data: copyLight(n.sp.data)}
+				ent := entity{Tp: n.sp.tp}
+				if n.sp.tp == "PL" {
+					ent.Data = copyPoll(n.sp.data)
+				} else {
+					ent.Data = copyLight(n.sp.data)
+				}
 				key = len(state.drafty.Ent)
 				state.keymap[n.sp.key] = key
 				state.drafty.Ent = append(state.drafty.Ent, ent)
@@ -566,6 +646,43 @@ func copyLight(in any) map[string]any {
 	return result
 }
 
+// copyPoll makes a copy of a poll entity retaining the multiple choice flag and the options
+// if the options are short enough to fit into a preview.
+func copyPoll(data map[string]any) map[string]any {
+	result := map[string]any{}
+	if multi, ok := data["multi"].(bool); ok {
+		result["multi"] = multi
+	}
+	if opts := pollOptionNames(data); len(opts) > 0 && len(opts) <= maxDataCount {
+		size := 0
+		for _, opt := range opts {
+			size += len(opt)
+		}
+		if size < maxDataSize {
+			result["opts"] = opts
+		}
+	}
+	if len(result) == 0 {
+		result = nil
+	}
+	return result
+}
+
+// pollOptionNames returns the options of a poll entity or nil if the options are missing or invalid.
+func pollOptionNames(data map[string]any) []string {
+	list, ok := data["opts"].([]any)
+	if !ok {
+		return nil
+	}
+	opts := make([]string, len(list))
+	for i := range list {
+		if opts[i], ok = list[i].(string); !ok {
+			return nil
+		}
+	}
+	return opts
+}
+
 // intFromNumeric is a helper methjod to get an integer from a value of any numeric type.
 func intFromNumeric(num any) (int, error) {
 	if num == nil {
@@ -61,6 +61,11 @@ var validInputs = []string{
 		"txt": "Hi 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿",
 		"fmt":[{"at":3,"len":4,"tp":"ST"},{"at":8,"len":4,"tp":"ST"}]
 	}`,
+	`{
+		"txt":"Where to have lunch?",
+		"fmt":[{"len":20}],
+		"ent":[{"tp":"PL","data":{"opts":["Pizza","Sushi","Tacos"],"multi":true}}]
+	}`,
 }
 
 var invalidInputs = []string{
@@ -110,6 +115,7 @@ func TestPlainText(t *testing.T) {
 		"This is a test",
 		"Hello 😀, *o😀k* https://google.com",
 		"Hi *🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿* *🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿* 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿",
+		"Where to have lunch? [POLL: Pizza / Sushi / Tacos]",
 	}
 
 	for i := range validInputs {
@@ -138,6 +144,38 @@ func TestPlainText(t *testing.T) {
 	}
 }
 
+func TestSearchText(t *testing.T) {
+	expect := []string{
+		"This is a plain text string.",
+		"This is a\n string with a line break.",
+		"",
+		"https://api.tinode.co/",
+		"https://api.tinode.co/",
+		"Url one, two",
+		"",
+		"This text has staggered formats",
+		"This text is formatted and deleted too",
+		"мультибайтовый юникод",
+		"This is a test",
+		"Hello 😀, o😀k https://google.com",
+		"Hi 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿",
+		"Where to have lunch? Pizza Sushi Tacos",
+	}
+
+	for i := range validInputs {
+		var val any
+		if err := json.Unmarshal([]byte(validInputs[i]), &val); err != nil {
+			t.Errorf("Failed to parse input %d '%s': %s", i, validInputs[i], err)
+		}
+		res, err := SearchText(val)
+		if err != nil {
+			t.Errorf("%d failed with error: %s", i, err)
+		} else if res != expect[i] {
+			t.Errorf("%d output '%s' does not match '%s'", i, res, expect[i])
+		}
+	}
+}
+
 func TestPreview(t *testing.T) {
 	expect := []string{
 		`{"txt":"This is a plain"}`,
@@ -153,6 +191,7 @@ func TestPreview(t *testing.T) {
 		`{"txt":"This is a test"}`,
 		`{"txt":"Hello 😀, o😀k ht","fmt":[{"tp":"ST","at":9,"len":3},{"at":13,"len":2}],"ent":[{"tp":"LN","data":{"url":"https://google.com"}}]}`,
 		`{"txt":"Hi 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿 🏴󠁧󠁢󠁳󠁣󠁴󠁿🏴󠁧󠁢󠁳󠁣󠁴󠁿","fmt":[{"tp":"ST","at":3,"len":4},{"tp":"ST","at":8,"len":4}]}`,
+		`{"txt":"Where to have l","fmt":[{"len":15}],"ent":[{"tp":"PL","data":{"multi":true,"opts":["Pizza","Sushi","Tacos"]}}]}`,
 	}
 	for i := range validInputs {
 		var val any
@@ -181,3 +220,39 @@ func TestPreview(t *testing.T) {
 		}
 	}
 }
+
+func TestPollOptions(t *testing.T) {
+	inputs := []string{
+		validInputs[len(validInputs)-1],
+		`{
+			"txt":"Yes or no?",
+			"fmt":[{"len":10}],
+			"ent":[{"tp":"PL","data":{"opts":["Yes","No"]}}]
+		}`,
+		validInputs[0],
+	}
+	expect := []struct {
+		count int
+		multi bool
+	}{{3, true}, {2, false}, {0, false}}
+
+	for i := range inputs {
+		var val any
+		if err := json.Unmarshal([]byte(inputs[i]), &val); err != nil {
+			t.Fatalf("Failed to parse input %d '%s': %s", i, inputs[i], err)
+		}
+		count, multi, err := PollOptions(val)
+		if err != nil {
+			t.Errorf("%d failed with error: %s", i, err)
+		} else if count != expect[i].count || multi != expect[i].multi {
+			t.Errorf("%d output %d, %t does not match %d, %t", i, count, multi, expect[i].count, expect[i].multi)
+		}
+	}
+
+	// A poll with fewer than two options is invalid.
+	var val any
+	json.Unmarshal([]byte(`{"txt":"?","fmt":[{"len":1}],"ent":[{"tp":"PL","data":{"opts":["Yes"]}}]}`), &val)
+	if _, _, err := PollOptions(val); err == nil {
+		t.Error("poll with a single option did not cause an error")
+	}
+}
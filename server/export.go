@@ -0,0 +1,493 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Export of user's data: profile, credentials, subscriptions, messages
+ *    and uploaded files packaged as a ZIP archive.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/media"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Version of the archive layout.
+	exportVersion = 1
+	// Number of messages or files fetched from the database at once.
+	exportPageSize = 500
+	// Minimum length of the key for signing download links.
+	exportMinKeyLength = 32
+	// Default lifetime of download links. It cannot be longer than unusedFileMaxAge, otherwise
+	// the media garbage collector deletes archives before links expire.
+	exportDefaultLinkTTL = time.Hour
+	// Prefix of persistent cache keys with the state of exports.
+	exportKeyPrefix = "export_"
+	// An export running longer than this is considered failed, e.g. the server was restarted.
+	exportJobTimeout = time.Hour
+)
+
+// Status of an export job.
+const (
+	exportRunning = "running"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// exportConfig is the configuration of user data exports.
+type exportConfig struct {
+	Enabled bool `json:"enabled"`
+	// Key for signing download links, base64-encoded, at least 32 bytes.
+	LinkKey []byte `json:"link_key"`
+	// Lifetime of download links in seconds.
+	LinkTTL int `json:"link_ttl"`
+}
+
+// exportLinker signs and verifies time-limited links to download exports.
+type exportLinker struct {
+	key []byte
+	ttl time.Duration
+}
+
+// exportManifest describes the content of the archive. It's written to manifest.json.
+type exportManifest struct {
+	Version     int                `json:"version"`
+	User        string             `json:"user"`
+	Created     time.Time          `json:"created"`
+	Profile     string             `json:"profile"`
+	Credentials string             `json:"credentials"`
+	Subs        string             `json:"subscriptions"`
+	Topics      []exportTopicEntry `json:"topics"`
+	Files       []exportFileEntry  `json:"files"`
+}
+
+// exportTopicEntry lists the file with messages the user sent to the topic.
+type exportTopicEntry struct {
+	Topic string `json:"topic"`
+	File  string `json:"file"`
+	Count int    `json:"count"`
+}
+
+// exportFileEntry describes an uploaded file. The File is empty if the content
+// could not be included, e.g. the media handler does not support downloads.
+type exportFileEntry struct {
+	Id       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	MimeType string    `json:"mime"`
+	Size     int64     `json:"size"`
+	File     string    `json:"file,omitempty"`
+}
+
+// exportCred is a credential in the archive. Verification responses are not included.
+type exportCred struct {
+	Method string `json:"method"`
+	Value  string `json:"value"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+// exportMessage is a message in the archive.
+type exportMessage struct {
+	SeqId   int       `json:"seq"`
+	Ts      time.Time `json:"ts"`
+	Parent  int       `json:"reply_to,omitempty"`
+	Head    any       `json:"head,omitempty"`
+	Content any       `json:"content"`
+}
+
+// exportJob is the state of the export of user's data. It's kept in the persistent cache
+// so it's visible to all cluster nodes.
+type exportJob struct {
+	Status  string    `json:"status"`
+	Started time.Time `json:"started"`
+	// Time-limited URL to download the archive when the export is ready.
+	URL     string     `json:"url,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	// ID of the uploaded archive.
+	File string `json:"file,omitempty"`
+}
+
+// exportResult is the packaged archive.
+type exportResult struct {
+	// Time-limited URL to download the archive.
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+	Size    int64     `json:"size"`
+	// ID of the uploaded archive.
+	fid types.Uid
+}
+
+func newExportLinker(config *exportConfig) (*exportLinker, error) {
+	if len(config.LinkKey) < exportMinKeyLength {
+		return nil, errors.New("export: link_key must be at least 32 bytes long")
+	}
+	ttl := time.Duration(config.LinkTTL) * time.Second
+	if ttl <= 0 {
+		ttl = exportDefaultLinkTTL
+	} else if ttl > unusedFileMaxAge {
+		return nil, errors.New("export: link_ttl must not exceed " + strconv.Itoa(int(unusedFileMaxAge/time.Second)) + " seconds")
+	}
+	return &exportLinker{key: config.LinkKey, ttl: ttl}, nil
+}
+
+func (el *exportLinker) signature(fid types.Uid, expires string) string {
+	mac := hmac.New(sha256.New, el.key)
+	mac.Write([]byte(fid.String() + ":" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// link adds expiration time and signature to the URL of the file.
+func (el *exportLinker) link(url string, fid types.Uid, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url + "?exp=" + exp + "&sig=" + el.signature(fid, exp)
+}
+
+// verify checks the signature of the link to the file. Returns types.ErrPermissionDenied
+// if the signature is invalid, types.ErrExpired if the link is no longer valid.
+func (el *exportLinker) verify(fid types.Uid, exp, sig string, now time.Time) error {
+	if fid.IsZero() || !hmac.Equal([]byte(sig), []byte(el.signature(fid, exp))) {
+		return types.ErrPermissionDenied
+	}
+	// The expiration time is covered by the signature, no need to validate it further.
+	expires, _ := strconv.ParseInt(exp, 10, 64)
+	if now.Unix() > expires {
+		return types.ErrExpired
+	}
+	return nil
+}
+
+// exportUserData packages the data of the user into a ZIP archive, uploads it with the
+// media handler and returns a time-limited link to download it.
+func exportUserData(user *types.User) (*exportResult, error) {
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if err = writeExportArchive(tmp, user); err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	mh := store.Store.GetMediaHandler()
+	fdef := &types.FileDef{
+		ObjHeader: types.ObjHeader{
+			Id: store.Store.GetUidString(),
+		},
+		// The archive is not attributed to the user, otherwise it would be included in later exports.
+		MimeType: "application/zip",
+	}
+	fdef.InitTimes()
+
+	url, size, err := mh.Upload(fdef, tmp)
+	if err != nil {
+		store.Files.FinishUpload(fdef, false, 0)
+		return nil, err
+	}
+	if _, err = store.Files.FinishUpload(fdef, true, size); err != nil {
+		// Best effort cleanup.
+		mh.Delete([]string{fdef.Location})
+		return nil, err
+	}
+
+	// The archive is deleted when the link expires, see runExport.
+	expires := types.TimeNow().Add(globals.exportLinks.ttl)
+	return &exportResult{
+		URL:     globals.exportLinks.link(url, fdef.Uid(), expires),
+		Expires: expires,
+		Size:    size,
+		fid:     fdef.Uid(),
+	}, nil
+}
+
+// startExport starts the export of user's data in the background unless an export is already running.
+// The archive of the previous export is deleted. Returns the state of the export.
+func startExport(user *types.User, admin types.Uid, remoteAddr string) (*exportJob, error) {
+	uid := user.Uid()
+	job, err := exportStatus(uid)
+	if err != nil {
+		return nil, err
+	}
+	if job != nil {
+		if job.Status == exportRunning {
+			return job, nil
+		}
+		deleteExport(uid, job.File)
+	}
+
+	job = &exportJob{Status: exportRunning, Started: types.TimeNow()}
+	if err = putExportJob(uid, job, true); err != nil {
+		if err == types.ErrDuplicate {
+			// Another export was started concurrently.
+			return exportStatus(uid)
+		}
+		return nil, err
+	}
+	go runExport(user, admin, remoteAddr, *job)
+	return job, nil
+}
+
+// runExport packages user's data and saves the result to the persistent cache. The archive
+// is deleted when the download link expires: the media garbage collector may be disabled.
+func runExport(user *types.User, admin types.Uid, remoteAddr string, job exportJob) {
+	uid := user.Uid()
+	result, err := exportUserData(user)
+	if err != nil {
+		logs.Warn.Println("export: failed", uid.UserId(), err)
+		job.Status = exportFailed
+	} else {
+		job.Status = exportReady
+		job.URL = result.URL
+		job.Expires = &result.Expires
+		job.Size = result.Size
+		job.File = result.fid.String()
+		auditRecord(auditExport, admin, uid, "", remoteAddr, map[string]any{"file": job.File})
+		logs.Info.Println("export: exported user data", uid.UserId(), job.File, job.Size)
+		time.AfterFunc(time.Until(result.Expires), func() {
+			deleteExport(uid, job.File)
+		})
+	}
+	if err = putExportJob(uid, &job, false); err != nil {
+		logs.Warn.Println("export: failed to save state", uid.UserId(), err)
+	}
+}
+
+// exportStatus returns the state of the last export of user's data or nil if there is none.
+// An expired archive is deleted in case the server was restarted before deleting it.
+func exportStatus(uid types.Uid) (*exportJob, error) {
+	value, err := store.PCache.Get(exportKeyPrefix + uid.UserId())
+	if err != nil {
+		if err == types.ErrNotFound {
+			err = nil
+		}
+		return nil, err
+	}
+	var job exportJob
+	if err = json.Unmarshal([]byte(value), &job); err != nil {
+		logs.Warn.Println("export: invalid state", uid.UserId(), value)
+		return nil, nil
+	}
+
+	switch job.Status {
+	case exportReady:
+		if job.Expires == nil || !job.Expires.After(time.Now()) {
+			deleteExport(uid, job.File)
+			return nil, nil
+		}
+	case exportRunning:
+		if time.Since(job.Started) > exportJobTimeout {
+			job.Status = exportFailed
+		}
+	}
+	return &job, nil
+}
+
+// deleteExport deletes the archive and the state of the export unless the state belongs to
+// a newer export.
+func deleteExport(uid types.Uid, fid string) {
+	if fid != "" {
+		if err := store.Files.Delete(fid); err != nil {
+			logs.Warn.Println("export: failed to delete archive", fid, err)
+		}
+	}
+	key := exportKeyPrefix + uid.UserId()
+	if value, err := store.PCache.Get(key); err == nil {
+		var job exportJob
+		json.Unmarshal([]byte(value), &job)
+		if job.File != fid || (job.Status == exportRunning && time.Since(job.Started) <= exportJobTimeout) {
+			// The state belongs to a newer export.
+			return
+		}
+		if err = store.PCache.Delete(key); err != nil && err != types.ErrNotFound {
+			logs.Warn.Println("export: failed to delete state", uid.UserId(), err)
+		}
+	}
+}
+
+// putExportJob saves the state of the export to the persistent cache.
+func putExportJob(uid types.Uid, job *exportJob, failOnDuplicate bool) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return store.PCache.Upsert(exportKeyPrefix+uid.UserId(), string(value), failOnDuplicate)
+}
+
+// writeExportArchive writes the data of the user to w as a ZIP archive.
+func writeExportArchive(w io.Writer, user *types.User) error {
+	uid := user.Uid()
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{
+		Version:     exportVersion,
+		User:        uid.UserId(),
+		Created:     types.TimeNow(),
+		Profile:     "profile.json",
+		Credentials: "credentials.json",
+		Subs:        "subscriptions.json",
+	}
+
+	if err := writeExportJSON(zw, manifest.Profile, adminUserFromStore(user)); err != nil {
+		return err
+	}
+
+	creds, err := store.Users.GetAllCreds(uid, "", false)
+	if err != nil {
+		return err
+	}
+	outCreds := make([]exportCred, 0, len(creds))
+	for i := range creds {
+		outCreds = append(outCreds, exportCred{Method: creds[i].Method, Value: creds[i].Value, Done: creds[i].Done})
+	}
+	if err = writeExportJSON(zw, manifest.Credentials, outCreds); err != nil {
+		return err
+	}
+
+	subs, err := store.Users.GetTopicsAny(uid, nil)
+	if err != nil {
+		return err
+	}
+	if err = writeExportJSON(zw, manifest.Subs, adminSubsFromStore(subs, uid)); err != nil {
+		return err
+	}
+
+	for i := range subs {
+		topic := subs[i].Topic
+		if types.IsChannel(topic) {
+			// Channel readers cannot publish.
+			continue
+		}
+		entry, err := writeExportMessages(zw, topic, uid)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			manifest.Topics = append(manifest.Topics, *entry)
+		}
+	}
+
+	if manifest.Files, err = writeExportFiles(zw, uid); err != nil {
+		return err
+	}
+
+	if err = writeExportJSON(zw, "manifest.json", &manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeExportMessages writes messages sent by the user to the topic, newest first.
+// Returns nil if the user sent no messages to the topic.
+func writeExportMessages(zw *zip.Writer, topic string, uid types.Uid) (*exportTopicEntry, error) {
+	var messages []exportMessage
+	opt := &types.QueryOpt{Limit: exportPageSize}
+	for {
+		page, err := store.Messages.GetAll(topic, uid, opt)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			msg := &page[i]
+			if msg.From != uid.String() {
+				continue
+			}
+			messages = append(messages, exportMessage{
+				SeqId:   msg.SeqId,
+				Ts:      msg.CreatedAt,
+				Parent:  msg.Parent,
+				Head:    msg.Head,
+				Content: msg.Content,
+			})
+		}
+		if len(page) < opt.Limit {
+			break
+		}
+		opt.Before = page[len(page)-1].SeqId
+	}
+
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	entry := &exportTopicEntry{Topic: topic, File: "messages/" + topic + ".json", Count: len(messages)}
+	return entry, writeExportJSON(zw, entry.File, messages)
+}
+
+// writeExportFiles writes the content of files uploaded by the user.
+func writeExportFiles(zw *zip.Writer, uid types.Uid) ([]exportFileEntry, error) {
+	mh := store.Store.GetMediaHandler()
+	var entries []exportFileEntry
+	after := types.ZeroUid
+	for {
+		files, err := store.Files.GetAll(uid, after, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for i := range files {
+			fd := &files[i]
+			entry := exportFileEntry{Id: fd.Id, Created: fd.CreatedAt, MimeType: fd.MimeType, Size: fd.Size}
+			fname := "files/" + fd.Id
+			if ext, _ := mime.ExtensionsByType(fd.MimeType); len(ext) > 0 {
+				fname += ext[0]
+			}
+			if err = writeExportFile(zw, fname, mh, fd.Id); err == nil {
+				entry.File = fname
+			} else if err == types.ErrUnsupported || err == types.ErrNotFound {
+				logs.Warn.Println("export: file content not included", fd.Id, err)
+			} else {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if len(files) < exportPageSize {
+			break
+		}
+		after = files[len(files)-1].Uid()
+	}
+	return entries, nil
+}
+
+// writeExportFile copies the content of the file with the given ID from the media handler to the archive.
+func writeExportFile(zw *zip.Writer, name string, mh media.Handler, fid string) error {
+	_, rsc, err := mh.Download(fid)
+	if err != nil {
+		return err
+	}
+	defer rsc.Close()
+
+	out, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, rsc)
+	return err
+}
+
+func writeExportJSON(zw *zip.Writer, name string, v any) error {
+	out, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", " ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/media"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const testServeURL = "/v0/file/s/"
+
+// testMediaHandler keeps files in memory.
+type testMediaHandler struct {
+	files map[string][]byte
+}
+
+type testMediaFile struct {
+	*bytes.Reader
+}
+
+func (testMediaFile) Close() error {
+	return nil
+}
+
+func (mh *testMediaHandler) Init(jsconf string) error {
+	return nil
+}
+
+func (mh *testMediaHandler) Headers(method string, url *url.URL, headers http.Header, serve bool) (http.Header, int, error) {
+	return nil, 0, nil
+}
+
+func (mh *testMediaHandler) Upload(fdef *types.FileDef, file io.Reader) (string, int64, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", 0, err
+	}
+	mh.files[fdef.Id] = data
+	fdef.Location = fdef.Id
+	return testServeURL + fdef.Id + ".zip", int64(len(data)), nil
+}
+
+func (mh *testMediaHandler) Download(url string) (*types.FileDef, media.ReadSeekCloser, error) {
+	fid := mh.GetIdFromUrl(url).String()
+	data, ok := mh.files[fid]
+	if !ok {
+		return nil, nil, types.ErrNotFound
+	}
+	fd := &types.FileDef{ObjHeader: types.ObjHeader{Id: fid}, MimeType: "application/zip"}
+	return fd, testMediaFile{bytes.NewReader(data)}, nil
+}
+
+func (mh *testMediaHandler) Delete(locations []string) error {
+	for _, loc := range locations {
+		delete(mh.files, loc)
+	}
+	return nil
+}
+
+func (mh *testMediaHandler) GetIdFromUrl(url string) types.Uid {
+	return media.GetIdFromUrl(url, testServeURL)
+}
+
+func setupTestExport(t *testing.T) *testMediaHandler {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	mh := &testMediaHandler{files: map[string][]byte{}}
+	ss.EXPECT().GetMediaHandler().Return(mh).AnyTimes()
+	saved := store.Store
+	store.Store = ss
+	links, err := newExportLinker(&exportConfig{Enabled: true, LinkKey: bytes.Repeat([]byte{7}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals.exportLinks = links
+	t.Cleanup(func() {
+		store.Store = saved
+		globals.exportLinks = nil
+		ctrl.Finish()
+	})
+	return mh
+}
+
+func TestExportLinker(t *testing.T) {
+	if _, err := newExportLinker(&exportConfig{Enabled: true, LinkKey: []byte("short")}); err == nil {
+		t.Error("Short key must be rejected")
+	}
+
+	el, _ := newExportLinker(&exportConfig{Enabled: true, LinkKey: bytes.Repeat([]byte{1}, 32)})
+	if el.ttl != exportDefaultLinkTTL {
+		t.Errorf("Expected default TTL %s, got %s", exportDefaultLinkTTL, el.ttl)
+	}
+	// Archives are deleted by the media garbage collector before such links expire.
+	if _, err := newExportLinker(&exportConfig{Enabled: true, LinkKey: bytes.Repeat([]byte{1}, 32),
+		LinkTTL: int(unusedFileMaxAge/time.Second) + 1}); err == nil {
+		t.Error("TTL longer than the age of unused files must be rejected")
+	}
+	now := time.Now()
+	link, _ := url.Parse(el.link(testServeURL+"file.zip", types.Uid(5), now.Add(time.Minute)))
+	exp, sig := link.Query().Get("exp"), link.Query().Get("sig")
+
+	for i, tc := range []struct {
+		fid      types.Uid
+		exp, sig string
+		now      time.Time
+		expected error
+	}{
+		{types.Uid(5), exp, sig, now, nil},
+		{types.Uid(5), exp, sig, now.Add(2 * time.Minute), types.ErrExpired},
+		{types.Uid(6), exp, sig, now, types.ErrPermissionDenied},
+		{types.Uid(5), strconv.FormatInt(now.Add(time.Hour).Unix(), 10), sig, now, types.ErrPermissionDenied},
+		{types.ZeroUid, exp, sig, now, types.ErrPermissionDenied},
+	} {
+		if err := el.verify(tc.fid, tc.exp, tc.sig, tc.now); err != tc.expected {
+			t.Errorf("%d: expected %v, got %v", i, tc.expected, err)
+		}
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	mh := setupTestExport(t)
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	mm := mock_store.NewMockMessagesPersistenceInterface(ctrl)
+	ff := mock_store.NewMockFilePersistenceInterface(ctrl)
+	store.Users, store.Messages, store.Files = uu, mm, ff
+	t.Cleanup(func() {
+		store.Users, store.Messages, store.Files = nil, nil, nil
+		ctrl.Finish()
+	})
+
+	uid, other := types.Uid(1), types.Uid(2)
+	user := &types.User{ObjHeader: types.ObjHeader{Id: uid.String()}, Public: map[string]any{"fn": "Alice"}}
+	mh.files[types.Uid(10).String()] = []byte("picture")
+	store.Store.(*mock_store.MockPersistentStorageInterface).EXPECT().GetUidString().Return(types.Uid(100).String())
+
+	uu.EXPECT().GetAllCreds(uid, "", false).Return([]types.Credential{
+		{Method: "email", Value: "alice@example.com", Resp: "123456", Done: true}}, nil)
+	uu.EXPECT().GetTopicsAny(uid, nil).Return([]types.Subscription{
+		{Topic: "grpAAAAAAAAAAA", User: uid.String()},
+		{Topic: "chnAAAAAAAAAAA", User: uid.String()},
+		{Topic: uid.P2PName(other), User: uid.String()},
+	}, nil)
+	mm.EXPECT().GetAll("grpAAAAAAAAAAA", uid, gomock.Any()).Return([]types.Message{
+		{SeqId: 3, From: other.String(), Content: "hi"},
+		{SeqId: 2, From: uid.String(), Content: "hello"},
+	}, nil)
+	mm.EXPECT().GetAll(uid.P2PName(other), uid, gomock.Any()).Return([]types.Message{
+		{SeqId: 1, From: other.String(), Content: "ping"},
+	}, nil)
+	ff.EXPECT().GetAll(uid, types.ZeroUid, exportPageSize).Return([]types.FileDef{
+		{ObjHeader: types.ObjHeader{Id: types.Uid(10).String()}, User: uid.String(), MimeType: "image/png", Size: 7},
+		{ObjHeader: types.ObjHeader{Id: types.Uid(11).String()}, User: uid.String(), MimeType: "image/png", Size: 5},
+	}, nil)
+	ff.EXPECT().FinishUpload(gomock.Any(), true, gomock.Any()).DoAndReturn(
+		func(fd *types.FileDef, success bool, size int64) (*types.FileDef, error) {
+			if fd.User != "" {
+				t.Error("Export must not be attributed to the user")
+			}
+			fd.Status = types.UploadCompleted
+			return fd, nil
+		})
+
+	result, err := exportUserData(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.fid != types.Uid(100) || result.Size != int64(len(mh.files[types.Uid(100).String()])) {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	data := mh.files[types.Uid(100).String()]
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+
+	var manifest exportManifest
+	if err = json.Unmarshal(content["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.User != uid.UserId() || len(manifest.Topics) != 1 || manifest.Topics[0].Count != 1 ||
+		len(manifest.Files) != 2 || manifest.Files[0].File == "" || manifest.Files[1].File != "" {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+	var messages []exportMessage
+	if err = json.Unmarshal(content[manifest.Topics[0].File], &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].SeqId != 2 || messages[0].Content != "hello" {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+	if string(content[manifest.Files[0].File]) != "picture" {
+		t.Errorf("Unexpected file content '%s'", content[manifest.Files[0].File])
+	}
+	if bytes.Contains(content[manifest.Credentials], []byte("123456")) {
+		t.Error("Credential responses must not be exported")
+	}
+
+	// The archive is served by the link without authentication.
+	req := httptest.NewRequest(http.MethodGet, result.URL, nil)
+	wrt := httptest.NewRecorder()
+	largeFileServeHTTP(wrt, req)
+	if wrt.Code != http.StatusOK || !bytes.Equal(wrt.Body.Bytes(), data) {
+		t.Errorf("Download: expected %d, got %d", http.StatusOK, wrt.Code)
+	}
+}
+
+func TestExportJob(t *testing.T) {
+	setupTestExport(t)
+	ctrl := gomock.NewController(t)
+	ff := mock_store.NewMockFilePersistenceInterface(ctrl)
+	store.Files = ff
+	cache := testPCache{}
+	store.PCache = cache
+	t.Cleanup(func() {
+		store.Files = nil
+		store.PCache = nil
+		ctrl.Finish()
+	})
+
+	uid := types.Uid(1)
+	user := &types.User{ObjHeader: types.ObjHeader{Id: uid.String()}}
+	if job, err := exportStatus(uid); job != nil || err != nil {
+		t.Errorf("No export: expected nil, got %+v %v", job, err)
+	}
+
+	// Export which is already running is not started again.
+	running := &exportJob{Status: exportRunning, Started: time.Now()}
+	putExportJob(uid, running, false)
+	if job, err := startExport(user, types.Uid(2), ""); err != nil || job.Status != exportRunning {
+		t.Errorf("Running: expected running job, got %+v %v", job, err)
+	}
+
+	// Export running for too long has failed.
+	running.Started = time.Now().Add(-2 * exportJobTimeout)
+	putExportJob(uid, running, false)
+	if job, _ := exportStatus(uid); job == nil || job.Status != exportFailed {
+		t.Errorf("Stale: expected failed job, got %+v", job)
+	}
+
+	// Archive of the newer export is not deleted with the state of the older one.
+	expires := time.Now().Add(time.Minute)
+	ready := &exportJob{Status: exportReady, Started: time.Now(), Expires: &expires, File: "new"}
+	putExportJob(uid, ready, false)
+	ff.EXPECT().Delete("old").Return(nil)
+	deleteExport(uid, "old")
+	if job, _ := exportStatus(uid); job == nil || job.File != "new" {
+		t.Errorf("Ready: expected the newer job, got %+v", job)
+	}
+
+	// Expired archive is deleted together with the state.
+	expires = time.Now().Add(-time.Minute)
+	putExportJob(uid, ready, false)
+	ff.EXPECT().Delete("new").Return(nil)
+	if job, err := exportStatus(uid); job != nil || err != nil {
+		t.Errorf("Expired: expected nil, got %+v %v", job, err)
+	}
+	if len(cache) != 0 {
+		t.Errorf("Expired: state must be deleted, got %v", cache)
+	}
+
+	// Admin API reports the state of the export.
+	uu, _ := setupTestAdmin(t)
+	path := "users/" + uid.UserId() + "/export"
+	uu.EXPECT().Get(uid).Return(user, nil)
+	if resp := adminRequest(t, http.MethodGet, path, "root", ""); resp.Ctrl.Code != http.StatusNotFound {
+		t.Errorf("Admin, no export: expected %d, got %d", http.StatusNotFound, resp.Ctrl.Code)
+	}
+	running.Started = time.Now()
+	putExportJob(uid, running, false)
+	uu.EXPECT().Get(uid).Return(user, nil)
+	resp := adminRequest(t, http.MethodPost, path, "root", "")
+	var params struct {
+		Export exportJob `json:"export"`
+	}
+	json.Unmarshal(resp.Ctrl.Params, &params)
+	if resp.Ctrl.Code != http.StatusAccepted || params.Export.Status != exportRunning {
+		t.Errorf("Admin, running: expected %d running, got %d %s", http.StatusAccepted, resp.Ctrl.Code, resp.Ctrl.Params)
+	}
+}
+
+func TestExportLinkServe(t *testing.T) {
+	mh := setupTestExport(t)
+	fid := types.Uid(100)
+	mh.files[fid.String()] = []byte("archive")
+	base := testServeURL + fid.String() + ".zip"
+
+	for i, tc := range []struct {
+		link     string
+		expected int
+	}{
+		{globals.exportLinks.link(base, fid, time.Now().Add(time.Minute)), http.StatusOK},
+		{globals.exportLinks.link(base, fid, time.Now().Add(-time.Minute)), http.StatusGone},
+		{globals.exportLinks.link(testServeURL+types.Uid(101).String()+".zip", fid, time.Now().Add(time.Minute)),
+			http.StatusForbidden},
+		// Without the signature the API key is required.
+		{base, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.link, nil)
+		wrt := httptest.NewRecorder()
+		largeFileServeHTTP(wrt, req)
+		if wrt.Code != tc.expected {
+			t.Errorf("%d: expected %d, got %d", i, tc.expected, wrt.Code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Server-side forwarding of existing messages.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// forwardSource converts the name of the topic with the forwarded message as seen by the user
+// into the name of the topic which stores the message and the name of the topic which holds user's subscription.
+func forwardSource(name string, asUid types.Uid) (msgTopic, subTopic string) {
+	if name == "slf" {
+		return asUid.SlfName(), asUid.SlfName()
+	}
+	if strings.HasPrefix(name, "usr") {
+		// 'usrXXX' is a p2p topic.
+		if uid2 := types.ParseUserId(name); !uid2.IsZero() && uid2 != asUid {
+			p2p := asUid.P2PName(uid2)
+			return p2p, p2p
+		}
+		return "", ""
+	}
+	if grp := types.ChnToGrp(name); grp != "" {
+		// Channel readers are subscribed to 'chnXXX' while the messages are stored in 'grpXXX'.
+		return grp, name
+	}
+	if strings.HasPrefix(name, "grp") {
+		return name, name
+	}
+	return "", ""
+}
+
+// resolveForward checks that the user is permitted to read the forwarded message and returns head and content
+// of the new message together with IDs of files attached to the original.
+// On error a reply is sent to the client.
+func (t *Topic) resolveForward(msg *ClientComMessage, asUid types.Uid) (map[string]any, any, []string, error) {
+	now := types.TimeNow()
+	fwd := msg.Pub.Forward
+
+	msgTopic, subTopic := forwardSource(fwd.Topic, asUid)
+	if msgTopic == "" || fwd.SeqId <= 0 {
+		msg.sess.queueOut(ErrMalformedReply(msg, now))
+		return nil, nil, nil, types.ErrMalformed
+	}
+
+	// The user must be able to read the original message.
+	sub, err := store.Subs.Get(subTopic, asUid, false)
+	if err != nil {
+		msg.sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, now, msg.Timestamp, nil))
+		return nil, nil, nil, err
+	}
+	if sub == nil || !(sub.ModeGiven & sub.ModeWant).IsReader() {
+		msg.sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return nil, nil, nil, types.ErrPermissionDenied
+	}
+
+	// Messages deleted for the user are not returned.
+	orig, err := store.Messages.Get(msgTopic, asUid, fwd.SeqId)
+	if err != nil {
+		msg.sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, msg.Original, now, msg.Timestamp, nil))
+		return nil, nil, nil, err
+	}
+	if orig == nil || orig.DeletedAt != nil || orig.Content == nil {
+		msg.sess.queueOut(ErrNotFoundReply(msg, now))
+		return nil, nil, nil, types.ErrNotFound
+	}
+
+	fids, err := store.Files.GetMessageAttachments(msgTopic, fwd.SeqId)
+	if err != nil {
+		logs.Warn.Printf("topic[%s]: failed to get attachments of %s:%d: %v", t.name, msgTopic, fwd.SeqId, err)
+		msg.sess.queueOut(ErrUnknownReply(msg, now))
+		return nil, nil, nil, err
+	}
+
+	head := map[string]any{}
+	for key, val := range msg.Pub.Head {
+		head[key] = val
+	}
+	if mime, ok := orig.Head["mime"]; ok {
+		// Content cannot be interpreted without its type.
+		head["mime"] = mime
+	}
+	if types.GetTopicCat(msgTopic) == types.TopicCatGrp {
+		// Origin of the forwarded message: the name of the group or the channel is the same for all users.
+		head["forwarded"] = subTopic + ":" + strconv.Itoa(fwd.SeqId)
+	} else {
+		// Names of p2p and 'slf' topics reveal the users, the origin is omitted.
+		head["forwarded"] = true
+	}
+
+	return head, orig.Content, fids, nil
+}
@@ -0,0 +1,896 @@
+/******************************************************************************
+ *
+ *  Description :
+ *
+ *    Handler of REST requests of the root user for managing the server.
+ *
+ *****************************************************************************/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Maximum size of the request body of the admin API.
+	adminMaxRequestSize = 1 << 16
+	// Default and maximum number of items returned in one page.
+	adminDefaultLimit = 50
+	adminMaxLimit     = 500
+)
+
+// Names of client messages which can be allowed by the API key.
+var apiKeyMessages = []string{"hi", "acc", "login", "sub", "leave", "pub", "get", "set", "del", "note"}
+
+// adminUser is a user in responses of the admin API.
+type adminUser struct {
+	Id        string     `json:"id"`
+	Created   time.Time  `json:"created"`
+	Updated   time.Time  `json:"updated"`
+	State     string     `json:"state"`
+	StateAt   *time.Time `json:"state_at,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	Public    any        `json:"public,omitempty"`
+	Trusted   any        `json:"trusted,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+}
+
+// adminTopic is a topic in responses of the admin API.
+type adminTopic struct {
+	Name     string     `json:"name"`
+	Created  time.Time  `json:"created"`
+	Updated  time.Time  `json:"updated"`
+	Touched  time.Time  `json:"touched"`
+	State    string     `json:"state"`
+	StateAt  *time.Time `json:"state_at,omitempty"`
+	Channel  bool       `json:"channel,omitempty"`
+	Owner    string     `json:"owner,omitempty"`
+	Auth     string     `json:"auth,omitempty"`
+	Anon     string     `json:"anon,omitempty"`
+	SeqId    int        `json:"seq"`
+	DelId    int        `json:"clear,omitempty"`
+	SubCount int        `json:"sub_count,omitempty"`
+	Public   any        `json:"public,omitempty"`
+	Trusted  any        `json:"trusted,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+}
+
+// adminSub is a subscription in responses of the admin API.
+type adminSub struct {
+	Topic   string     `json:"topic"`
+	User    string     `json:"user"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+	Deleted *time.Time `json:"deleted,omitempty"`
+	Want    string     `json:"want"`
+	Given   string     `json:"given"`
+	Read    int        `json:"read,omitempty"`
+	Recv    int        `json:"recv,omitempty"`
+	DelId   int        `json:"clear,omitempty"`
+}
+
+// adminSession is a live session in responses of the admin API.
+type adminSession struct {
+	Sid        string   `json:"sid"`
+	User       string   `json:"user,omitempty"`
+	AuthLevel  string   `json:"auth_level,omitempty"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+	Platform   string   `json:"platform,omitempty"`
+	Proto      string   `json:"proto,omitempty"`
+	Subs       []string `json:"subs,omitempty"`
+}
+
+// adminAPIKey is an API key in requests and responses of the admin API.
+type adminAPIKey struct {
+	Id        string     `json:"id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Origins   []string   `json:"origins,omitempty"`
+	Schemes   []string   `json:"schemes,omitempty"`
+	Messages  []string   `json:"messages,omitempty"`
+	RateLimit int        `json:"rate_limit,omitempty"`
+	RateBurst int        `json:"rate_burst,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Updated   *time.Time `json:"updated,omitempty"`
+	Revoked   *time.Time `json:"revoked,omitempty"`
+	// The key to use in clients.
+	Key string `json:"key,omitempty"`
+}
+
+// serveAdmin handles requests to <api path>/v0/admin/<resource>[/<id>[/<sub-resource>]].
+func serveAdmin(wrt http.ResponseWriter, req *http.Request) {
+	now := types.TimeNow()
+	enc := json.NewEncoder(wrt)
+
+	writeHttpResponse := func(msg *ServerComMessage, err error) {
+		wrt.Header().Set("Content-Type", "application/json; charset=utf-8")
+		wrt.WriteHeader(msg.Ctrl.Code)
+		enc.Encode(msg)
+		if err != nil {
+			logs.Warn.Println("admin:", req.Method, req.URL.Path, err)
+		}
+	}
+
+	if getAPIKey(req) == nil {
+		writeHttpResponse(ErrAPIKeyRequired(now), errors.New("invalid or missing API key"))
+		return
+	}
+
+	uid, authLvl, lockedUntil, err := authAdminRequest(req)
+	if !lockedUntil.IsZero() {
+		writeHttpResponse(ErrAuthLocked("", "", now, now, time.Until(lockedUntil)),
+			errors.New("too many failed attempts from "+getRemoteAddr(req)))
+		return
+	}
+	if err != nil {
+		writeHttpResponse(decodeStoreError(err, "", now, nil), err)
+		return
+	}
+	if uid.IsZero() {
+		writeHttpResponse(ErrAuthRequired("", "", now, now), errors.New("authentication required"))
+		return
+	}
+	if authLvl != auth.LevelRoot {
+		writeHttpResponse(ErrPermissionDenied("", "", now), errors.New("non-root user "+uid.UserId()))
+		return
+	}
+
+	// Path is <resource>[/<id>[/<sub-resource>]].
+	_, path, _ := strings.Cut(req.URL.Path, "/v0/admin/")
+	resource, id, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	id, sub, _ := strings.Cut(id, "/")
+
+	req.Body = http.MaxBytesReader(wrt, req.Body, adminMaxRequestSize)
+
+	var resp *ServerComMessage
+	switch {
+	case resource == "apikeys" && sub == "":
+		resp, err = adminAPIKeys(req, id, now)
+	case resource == "users":
+		resp, err = adminUsers(req, uid, id, sub, now)
+	case resource == "topics":
+		resp, err = adminTopics(req, id, sub, now)
+	case resource == "sessions" && sub == "":
+		resp, err = adminSessions(req, uid, id, now)
+	case resource == "audit" && id == "":
+		resp, err = adminAudit(req, now)
+	default:
+		resp, err = ErrNotFound("", "", now), errors.New("unknown resource '"+path+"'")
+	}
+	writeHttpResponse(resp, err)
+}
+
+// authAdminRequest authenticates the HTTP request to the admin API. Returns the ID and the auth level
+// of the user or zero ID if the request has no credentials. If the address of the client is locked out
+// after too many failed attempts, returns the time when the lock expires.
+//
+// The request is authenticated either by the ID of a session where the user is logged in or by
+// a token issued at the end of a completed login. Passwords and other primary credentials are not
+// accepted: they would bypass the second authentication factor.
+func authAdminRequest(req *http.Request) (types.Uid, auth.Level, time.Time, error) {
+	authMethod, secret := getHttpAuth(req)
+	if authMethod == "" {
+		// Find the session, make sure it's appropriately authenticated.
+		if sess := globals.sessionStore.Get(req.FormValue("sid")); sess != nil {
+			uid, authLvl, _ := sess.authInfo()
+			return uid, authLvl, time.Time{}, nil
+		}
+		return types.ZeroUid, auth.LevelNone, time.Time{}, nil
+	}
+
+	if !strings.EqualFold(authMethod, "token") {
+		return types.ZeroUid, auth.LevelNone, time.Time{}, types.ErrFailed
+	}
+
+	decodedSecret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return types.ZeroUid, auth.LevelNone, time.Time{}, types.ErrMalformed
+	}
+
+	addr := loginLimitAddr(getRemoteAddr(req))
+	if globals.loginLimiter != nil {
+		until, err := globals.loginLimiter.Check("", addr)
+		if err != nil {
+			return types.ZeroUid, auth.LevelNone, time.Time{}, err
+		}
+		if !until.IsZero() {
+			return types.ZeroUid, auth.LevelNone, until, nil
+		}
+	}
+
+	rec, _, err := store.Store.GetLogicalAuthHandler("token").Authenticate(decodedSecret, getRemoteAddr(req))
+	if err != nil {
+		if err == types.ErrFailed && globals.loginLimiter != nil {
+			if _, _, err := globals.loginLimiter.Fail("", addr); err != nil {
+				logs.Warn.Println("admin: failed to record failed attempt", err)
+			}
+		}
+		return types.ZeroUid, auth.LevelNone, time.Time{}, err
+	}
+
+	// The token must be issued at the end of a login, not while waiting for validation of credentials.
+	if rec.Features&auth.FeatureNoLogin != 0 || rec.Features&auth.FeatureValidated == 0 {
+		return types.ZeroUid, auth.LevelNone, time.Time{}, types.ErrPermissionDenied
+	}
+	if rec.Features&(auth.FeatureSecondFactor|auth.FeaturePasskey) == 0 {
+		// The user may have enabled the second factor after the token was issued.
+		scheme, err := secondFactorScheme(rec.Uid)
+		if err != nil {
+			return types.ZeroUid, auth.LevelNone, time.Time{}, err
+		}
+		if scheme != "" {
+			return types.ZeroUid, auth.LevelNone, time.Time{}, types.ErrPermissionDenied
+		}
+	}
+
+	if rec.State == types.StateUndefined {
+		if rec.State, err = userGetState(rec.Uid); err != nil {
+			return types.ZeroUid, auth.LevelNone, time.Time{}, err
+		}
+	}
+	if rec.State != types.StateOK {
+		return types.ZeroUid, auth.LevelNone, time.Time{}, types.ErrPermissionDenied
+	}
+	return rec.Uid, rec.AuthLevel, time.Time{}, nil
+}
+
+// adminAPIKeys handles requests to manage API keys:
+//
+//	GET apikeys: list all keys
+//	POST apikeys: create a new key
+//	GET apikeys/<id>: get one key
+//	PUT apikeys/<id>: update restrictions of the key
+//	DELETE apikeys/<id>: revoke the key
+func adminAPIKeys(req *http.Request, id string, now time.Time) (*ServerComMessage, error) {
+	if id == "" {
+		switch req.Method {
+		case http.MethodGet:
+			keys, err := store.APIKeys.GetAll()
+			if err != nil {
+				return decodeStoreError(err, "", now, nil), err
+			}
+			result := make([]*adminAPIKey, 0, len(keys))
+			for i := range keys {
+				result = append(result, adminAPIKeyFromStore(&keys[i]))
+			}
+			return NoErrParams("", "", now, map[string]any{"apikeys": result}), nil
+
+		case http.MethodPost:
+			var key types.APIKey
+			if err := adminReadAPIKey(req, &key); err != nil {
+				return ErrMalformed("", "", now), err
+			}
+			if err := store.APIKeys.Create(&key); err != nil {
+				return decodeStoreError(err, "", now, nil), err
+			}
+			logs.Info.Println("admin: created apikey", key.Id, key.Name)
+			resp := NoErrCreated("", "", now)
+			resp.Ctrl.Params = map[string]any{"apikey": adminAPIKeyFromStore(&key)}
+			return resp, nil
+		}
+		return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+	}
+
+	keyId := types.ParseUid(id)
+	if keyId.IsZero() {
+		return ErrMalformed("", "", now), errors.New("invalid apikey id '" + id + "'")
+	}
+	key, err := store.APIKeys.Get(keyId.String())
+	if err != nil {
+		return decodeStoreError(err, "", now, nil), err
+	}
+	if key == nil {
+		return ErrNotFound("", "", now), errors.New("apikey not found " + id)
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if key.RevokedAt != nil {
+			return ErrOperationNotAllowed("", "", now), errors.New("apikey is revoked " + id)
+		}
+		if err = adminReadAPIKey(req, key); err != nil {
+			return ErrMalformed("", "", now), err
+		}
+		if err = store.APIKeys.Update(key); err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		apiKeyChanged(keyId)
+		logs.Info.Println("admin: updated apikey", key.Id)
+	case http.MethodDelete:
+		if key.RevokedAt == nil {
+			revokedAt := now
+			key.RevokedAt = &revokedAt
+			if err = store.APIKeys.Update(key); err != nil {
+				return decodeStoreError(err, "", now, nil), err
+			}
+			apiKeyChanged(keyId)
+			logs.Info.Println("admin: revoked apikey", key.Id)
+		}
+	default:
+		return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+	}
+	return NoErrParams("", "", now, map[string]any{"apikey": adminAPIKeyFromStore(key)}), nil
+}
+
+// adminReadAPIKey reads and validates the API key from the request body and copies its name and
+// restrictions to the stored key.
+func adminReadAPIKey(req *http.Request, key *types.APIKey) error {
+	var in adminAPIKey
+	if err := adminReadJSON(req, &in); err != nil {
+		return err
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" || len(in.Name) > 255 {
+		return errors.New("invalid apikey name")
+	}
+	if in.RateLimit < 0 || in.RateBurst < 0 {
+		return errors.New("invalid apikey rate limit")
+	}
+	for _, what := range in.Messages {
+		if !slices.Contains(apiKeyMessages, what) {
+			return errors.New("unknown message '" + what + "'")
+		}
+	}
+
+	key.Name = in.Name
+	key.Origins = in.Origins
+	key.Schemes = in.Schemes
+	key.Messages = in.Messages
+	key.RateLimit = in.RateLimit
+	key.RateBurst = in.RateBurst
+	return nil
+}
+
+// adminAPIKeyFromStore converts the stored API key to the admin API representation.
+func adminAPIKeyFromStore(key *types.APIKey) *adminAPIKey {
+	return &adminAPIKey{
+		Id:        key.Id,
+		Name:      key.Name,
+		Origins:   key.Origins,
+		Schemes:   key.Schemes,
+		Messages:  key.Messages,
+		RateLimit: key.RateLimit,
+		RateBurst: key.RateBurst,
+		Created:   &key.CreatedAt,
+		Updated:   &key.UpdatedAt,
+		Revoked:   key.RevokedAt,
+		Key:       makeAPIKey(key.Uid()),
+	}
+}
+
+// adminUsers handles requests to manage users:
+//
+//	GET users?after=<id>&limit=<n>: list users page by page
+//	GET users?q=<query>: find users by tags, same query as in the 'fnd' topic
+//	GET users/<id>: get the user with credentials and logins on devices
+//	DELETE users/<id>?hard=true: delete the user
+//	PUT users/<id>/state: suspend or restore the user, {"state": "susp"}
+//	POST users/<id>/reset: replace the secret of the authentication scheme and log the user out,
+//	  {"scheme": "basic", "secret": "<base64-encoded secret>"}
+//	GET users/<id>/subs?limit=<n>: list user's subscriptions, including deleted
+//	DELETE users/<id>/sessions: terminate user's sessions and revoke logins on devices
+//	POST users/<id>/export: start export of user's data in the background
+//	GET users/<id>/export: get the state of the export and a time-limited link to download it
+//
+// The admin is the root user making the request.
+func adminUsers(req *http.Request, admin types.Uid, id, sub string, now time.Time) (*ServerComMessage, error) {
+	query := req.URL.Query()
+	if id == "" {
+		if req.Method != http.MethodGet {
+			return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+		}
+
+		var users []types.User
+		var err error
+		if q := query.Get("q"); q != "" {
+			var uids []types.Uid
+			if uids, err = adminFindUsers(q); err == nil && len(uids) > 0 {
+				users, err = store.Users.GetAll(uids...)
+			}
+		} else {
+			after := types.ParseUserId(query.Get("after"))
+			users, err = store.Users.List(after, adminLimit(query.Get("limit")))
+		}
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		result := make([]*adminUser, 0, len(users))
+		for i := range users {
+			result = append(result, adminUserFromStore(&users[i]))
+		}
+		return NoErrParams("", "", now, map[string]any{"users": result}), nil
+	}
+
+	if !slices.Contains([]string{"", "state", "reset", "subs", "sessions", "export"}, sub) {
+		return ErrNotFound("", "", now), errors.New("unknown resource '" + sub + "'")
+	}
+	uid := types.ParseUserId(id)
+	if uid.IsZero() {
+		return ErrMalformed("", "", now), errors.New("invalid user id '" + id + "'")
+	}
+	user, err := store.Users.Get(uid)
+	if err != nil {
+		return decodeStoreError(err, "", now, nil), err
+	}
+	if user == nil {
+		return ErrUserNotFound("", "", now, now), errors.New("user not found " + id)
+	}
+
+	switch {
+	case sub == "" && req.Method == http.MethodGet:
+		creds, err := store.Users.GetAllCreds(uid, "", false)
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		sessions, err := store.AuthSessions.GetAll(uid)
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		var outCreds []*MsgCredServer
+		for i := range creds {
+			outCreds = append(outCreds, &MsgCredServer{Method: creds[i].Method, Value: creds[i].Value, Done: creds[i].Done})
+		}
+		var outSessions []MsgAuthSession
+		for i := range sessions {
+			sess := &sessions[i]
+			outSessions = append(outSessions, MsgAuthSession{
+				Id:         sess.Id,
+				Platform:   sess.Platform,
+				UserAgent:  sess.UserAgent,
+				RemoteAddr: sess.RemoteAddr,
+				Created:    sess.CreatedAt,
+				LastSeen:   sess.UpdatedAt,
+				Expires:    sess.Expires,
+			})
+		}
+		return NoErrParams("", "", now, map[string]any{
+			"user":     adminUserFromStore(user),
+			"cred":     outCreds,
+			"sessions": outSessions,
+		}), nil
+
+	case sub == "" && req.Method == http.MethodDelete:
+		if err = deleteUser(uid, query.Get("hard") == "true", ""); err != nil {
+			if err == types.ErrUnsupported {
+				return ErrOperationNotAllowed("", "", now), err
+			}
+			return decodeStoreError(err, "", now, nil), err
+		}
+		auditRecord(auditUserDelete, admin, uid, "", getRemoteAddr(req), map[string]any{"hard": query.Get("hard") == "true"})
+		logs.Info.Println("admin: deleted user", uid.UserId())
+		return NoErr("", "", now), nil
+
+	case sub == "state" && req.Method == http.MethodPut:
+		var in struct {
+			State string `json:"state"`
+		}
+		if err = adminReadJSON(req, &in); err != nil {
+			return ErrMalformed("", "", now), err
+		}
+		changed, err := changeUserState(uid, user, in.State)
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		if !changed {
+			return InfoNotModified("", "", now), nil
+		}
+		auditRecord(auditStateChange, admin, uid, "", getRemoteAddr(req), map[string]any{"state": in.State})
+		logs.Info.Println("admin: changed user state", uid.UserId(), in.State)
+		return NoErrParams("", "", now, map[string]any{"user": adminUserFromStore(user)}), nil
+
+	case sub == "reset" && req.Method == http.MethodPost:
+		var in struct {
+			Scheme string `json:"scheme"`
+			Secret []byte `json:"secret"`
+		}
+		if err = adminReadJSON(req, &in); err != nil || in.Scheme == "" || len(in.Secret) == 0 {
+			return ErrMalformed("", "", now), errors.New("invalid reset request")
+		}
+		if _, err = updateUserAuth(in.Scheme, in.Secret, user, getRemoteAddr(req)); err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		if err = adminLogoutUser(uid); err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		auditRecord(auditAuthChange, admin, uid, "", getRemoteAddr(req), map[string]any{"scheme": in.Scheme})
+		logs.Info.Println("admin: reset auth", in.Scheme, uid.UserId())
+		return NoErr("", "", now), nil
+
+	case sub == "subs" && req.Method == http.MethodGet:
+		subs, err := store.Users.GetTopicsAny(uid, &types.QueryOpt{Limit: adminLimit(query.Get("limit"))})
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		return NoErrParams("", "", now, map[string]any{"subs": adminSubsFromStore(subs, uid)}), nil
+
+	case sub == "sessions" && req.Method == http.MethodDelete:
+		if err = adminLogoutUser(uid); err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		auditRecord(auditLogout, admin, uid, "", getRemoteAddr(req), nil)
+		logs.Info.Println("admin: evicted sessions", uid.UserId())
+		return NoErr("", "", now), nil
+
+	case sub == "export" && (req.Method == http.MethodPost || req.Method == http.MethodGet):
+		if globals.exportLinks == nil {
+			return ErrNotImplemented("", "", now, now), errors.New("export disabled")
+		}
+		if req.Method == http.MethodGet {
+			job, err := exportStatus(uid)
+			if err != nil {
+				return decodeStoreError(err, "", now, nil), err
+			}
+			if job == nil {
+				return ErrNotFound("", "", now), errors.New("no export of " + uid.UserId())
+			}
+			return NoErrParams("", "", now, map[string]any{"export": job}), nil
+		}
+		job, err := startExport(user, admin, getRemoteAddr(req))
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		logs.Info.Println("admin: export", job.Status, uid.UserId())
+		resp := NoErrAccepted("", "", now)
+		resp.Ctrl.Params = map[string]any{"export": job}
+		return resp, nil
+	}
+	return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+}
+
+// adminTopics handles requests to inspect topics:
+//
+//	GET topics?q=<query>: find group topics by tags, same query as in the 'fnd' topic
+//	GET topics/<name>: get the topic
+//	GET topics/<name>/subs?limit=<n>: list subscriptions to the topic, including deleted
+func adminTopics(req *http.Request, name, sub string, now time.Time) (*ServerComMessage, error) {
+	if req.Method != http.MethodGet {
+		return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+	}
+	query := req.URL.Query()
+
+	if name == "" {
+		q := query.Get("q")
+		if q == "" {
+			return ErrMalformed("", "", now), errors.New("missing topic query")
+		}
+		names, err := adminFindTopics(q)
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		result := make([]*adminTopic, 0, len(names))
+		for _, name := range names {
+			topic, err := store.Topics.Get(name)
+			if err != nil {
+				return decodeStoreError(err, "", now, nil), err
+			}
+			if topic != nil {
+				result = append(result, adminTopicFromStore(topic))
+			}
+		}
+		return NoErrParams("", "", now, map[string]any{"topics": result}), nil
+	}
+
+	// Channel readers use chnXXX names, the topic is stored as grpXXX.
+	name = types.ChnToGrp(name)
+	switch sub {
+	case "":
+		topic, err := store.Topics.Get(name)
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		if topic == nil {
+			return ErrTopicNotFound("", "", now, now), errors.New("topic not found " + name)
+		}
+		return NoErrParams("", "", now, map[string]any{"topic": adminTopicFromStore(topic)}), nil
+
+	case "subs":
+		subs, err := store.Topics.GetSubsAny(name, &types.QueryOpt{Limit: adminLimit(query.Get("limit"))})
+		if err != nil {
+			return decodeStoreError(err, "", now, nil), err
+		}
+		return NoErrParams("", "", now, map[string]any{"subs": adminSubsFromStore(subs, types.ZeroUid)}), nil
+	}
+	return ErrNotFound("", "", now), errors.New("unknown resource '" + sub + "'")
+}
+
+// adminSessions handles requests to manage live sessions on this cluster node:
+//
+//	GET sessions?user=<id>: list sessions, optionally of the given user only
+//	DELETE sessions/<sid>: terminate the session
+func adminSessions(req *http.Request, admin types.Uid, sid string, now time.Time) (*ServerComMessage, error) {
+	switch {
+	case sid == "" && req.Method == http.MethodGet:
+		var uid types.Uid
+		if user := req.URL.Query().Get("user"); user != "" {
+			if uid = types.ParseUserId(user); uid.IsZero() {
+				return ErrMalformed("", "", now), errors.New("invalid user id '" + user + "'")
+			}
+		}
+		result := []*adminSession{}
+		globals.sessionStore.Range(func(_ string, s *Session) bool {
+			if s.isMultiplex() {
+				return true
+			}
+			if suid, _, _ := s.authInfo(); !uid.IsZero() && suid != uid {
+				return true
+			}
+			result = append(result, adminSessionFromLive(s))
+			return true
+		})
+		return NoErrParams("", "", now, map[string]any{"sessions": result}), nil
+
+	case sid != "" && req.Method == http.MethodDelete:
+		var uid types.Uid
+		if s := globals.sessionStore.Get(sid); s != nil {
+			uid, _, _ = s.authInfo()
+		}
+		if !globals.sessionStore.Evict(sid) {
+			return ErrNotFound("", "", now), errors.New("session not found " + sid)
+		}
+		auditRecord(auditLogout, admin, uid, "", getRemoteAddr(req), map[string]any{"sid": sid})
+		logs.Info.Println("admin: evicted session", sid)
+		return NoErr("", "", now), nil
+	}
+	return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+}
+
+// adminAudit handles requests to read the audit log stored in the database:
+//
+//	GET audit?user=<id>&action=<action>&since=<time>&before=<time>&limit=<n>: list records, newest first;
+//	  the times are in RFC 3339 format
+func adminAudit(req *http.Request, now time.Time) (*ServerComMessage, error) {
+	if req.Method != http.MethodGet {
+		return ErrOperationNotAllowed("", "", now), errors.New("method '" + req.Method + "' not allowed")
+	}
+
+	query := req.URL.Query()
+	opts := &types.AuditQuery{
+		Action: query.Get("action"),
+		Limit:  adminLimit(query.Get("limit")),
+	}
+	if user := query.Get("user"); user != "" {
+		if opts.User = types.ParseUserId(user); opts.User.IsZero() {
+			return ErrMalformed("", "", now), errors.New("invalid user id '" + user + "'")
+		}
+	}
+	var err error
+	if opts.Since, err = adminParseTime(query.Get("since")); err != nil {
+		return ErrMalformed("", "", now), err
+	}
+	if opts.Before, err = adminParseTime(query.Get("before")); err != nil {
+		return ErrMalformed("", "", now), err
+	}
+
+	records, err := store.Audit.GetAll(opts)
+	if err != nil {
+		return decodeStoreError(err, "", now, nil), err
+	}
+	result := make([]*auditEntry, 0, len(records))
+	for i := range records {
+		result = append(result, auditEntryFromStore(&records[i]))
+	}
+	return NoErrParams("", "", now, map[string]any{"audit": result}), nil
+}
+
+// adminLogoutUser terminates all user's sessions and revokes logins on devices so the tokens
+// issued to them can no longer be used.
+func adminLogoutUser(uid types.Uid) error {
+	sessions, err := store.AuthSessions.GetAll(uid)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(sessions))
+	for i := range sessions {
+		ids = append(ids, sessions[i].Id)
+	}
+	if err = store.AuthSessions.Delete(uid, ids...); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		evictAuthSessions(uid, ids)
+	}
+	globals.sessionStore.EvictUser(uid, "")
+	return nil
+}
+
+// adminFindUsers finds users by tags.
+func adminFindUsers(q string) ([]types.Uid, error) {
+	subs, err := adminFindSubs(q)
+	if err != nil {
+		return nil, err
+	}
+	var uids []types.Uid
+	for i := range subs {
+		if uid := types.ParseUserId(subs[i].Topic); !uid.IsZero() {
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// adminFindTopics finds group topics by tags.
+func adminFindTopics(q string) ([]string, error) {
+	subs, err := adminFindSubs(q)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := range subs {
+		if types.GetTopicCat(subs[i].Topic) == types.TopicCatGrp {
+			names = append(names, subs[i].Topic)
+		}
+	}
+	return names, nil
+}
+
+// adminFindSubs searches users and topics by tags, including suspended and deleted.
+func adminFindSubs(q string) ([]types.Subscription, error) {
+	and, opt, err := parseSearchQuery(q)
+	if err != nil {
+		return nil, types.ErrMalformed
+	}
+	var req [][]string
+	for _, tag := range and {
+		req = append(req, []string{tag})
+	}
+	return store.Users.FindSubs(types.ZeroUid, "", req, opt, false)
+}
+
+// adminReadJSON reads JSON request body into the given object.
+func adminReadJSON(req *http.Request, v any) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// adminParseTime parses the optional time in RFC 3339 format.
+func adminParseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("invalid time '" + value + "'")
+	}
+	return &ts, nil
+}
+
+// adminLimit parses the limit on the number of returned items.
+func adminLimit(limit string) int {
+	if n, err := strconv.Atoi(limit); err == nil && n > 0 {
+		return min(n, adminMaxLimit)
+	}
+	return adminDefaultLimit
+}
+
+func adminUserFromStore(user *types.User) *adminUser {
+	return &adminUser{
+		Id:        user.Uid().UserId(),
+		Created:   user.CreatedAt,
+		Updated:   user.UpdatedAt,
+		State:     user.State.String(),
+		StateAt:   user.StateAt,
+		LastSeen:  user.LastSeen,
+		UserAgent: user.UserAgent,
+		Public:    user.Public,
+		Trusted:   user.Trusted,
+		Tags:      user.Tags,
+	}
+}
+
+func adminTopicFromStore(topic *types.Topic) *adminTopic {
+	var owner string
+	if uid := types.ParseUid(topic.Owner); !uid.IsZero() {
+		owner = uid.UserId()
+	}
+	return &adminTopic{
+		Name:     topic.Id,
+		Created:  topic.CreatedAt,
+		Updated:  topic.UpdatedAt,
+		Touched:  topic.TouchedAt,
+		State:    topic.State.String(),
+		StateAt:  topic.StateAt,
+		Channel:  topic.UseBt,
+		Owner:    owner,
+		Auth:     topic.Access.Auth.String(),
+		Anon:     topic.Access.Anon.String(),
+		SeqId:    topic.SeqId,
+		DelId:    topic.DelId,
+		SubCount: topic.SubCnt,
+		Public:   topic.Public,
+		Trusted:  topic.Trusted,
+		Tags:     topic.Tags,
+	}
+}
+
+// adminSubsFromStore converts subscriptions to the admin API representation. If forUser is not zero,
+// subscriptions are of that user.
+func adminSubsFromStore(subs []types.Subscription, forUser types.Uid) []*adminSub {
+	result := make([]*adminSub, 0, len(subs))
+	for i := range subs {
+		sub := &subs[i]
+		user := forUser
+		if user.IsZero() {
+			user = types.ParseUid(sub.User)
+		}
+		result = append(result, &adminSub{
+			Topic:   sub.Topic,
+			User:    user.UserId(),
+			Created: sub.CreatedAt,
+			Updated: sub.UpdatedAt,
+			Deleted: sub.DeletedAt,
+			Want:    sub.ModeWant.String(),
+			Given:   sub.ModeGiven.String(),
+			Read:    sub.ReadSeqId,
+			Recv:    sub.RecvSeqId,
+			DelId:   sub.DelId,
+		})
+	}
+	return result
+}
+
+func adminSessionFromLive(s *Session) *adminSession {
+	s.subsLock.RLock()
+	subs := make([]string, 0, len(s.subs))
+	for topic := range s.subs {
+		subs = append(subs, topic)
+	}
+	s.subsLock.RUnlock()
+	sort.Strings(subs)
+
+	// The fields are changed by the session's own goroutines.
+	s.infoLock.RLock()
+	uid, authLvl := s.uid, s.authLvl
+	remoteAddr, userAgent, platf := s.remoteAddr, s.userAgent, s.platf
+	s.infoLock.RUnlock()
+
+	var user string
+	if !uid.IsZero() {
+		user = uid.UserId()
+	}
+	var authLevel string
+	if authLvl != auth.LevelNone {
+		authLevel = authLvl.String()
+	}
+	var proto string
+	switch s.proto {
+	case WEBSOCK:
+		proto = "ws"
+	case LPOLL:
+		proto = "lp"
+	case GRPC:
+		proto = "grpc"
+	case PROXY:
+		proto = "proxy"
+	}
+	return &adminSession{
+		Sid:        s.sid,
+		User:       user,
+		AuthLevel:  authLevel,
+		RemoteAddr: remoteAddr,
+		UserAgent:  userAgent,
+		Platform:   platf,
+		Proto:      proto,
+		Subs:       subs,
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"container/list"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/mock_store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Key of version 1 generated by keygen with the salt from its README.
+const testAdminAPIKey = "AQAAAAABAACGOIyP2vh5avSff5oVvMpk"
+
+// adminResponse is the response of the admin API.
+type adminResponse struct {
+	Ctrl struct {
+		Code   int             `json:"code"`
+		Params json.RawMessage `json:"params"`
+	} `json:"ctrl"`
+}
+
+func setupTestAdmin(t *testing.T, sessions ...*Session) (*mock_store.MockUsersPersistenceInterface,
+	*mock_store.MockTopicsPersistenceInterface) {
+	ctrl := gomock.NewController(t)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	tt := mock_store.NewMockTopicsPersistenceInterface(ctrl)
+	store.Users = uu
+	store.Topics = tt
+	globals.apiKeySalt, _ = base64.StdEncoding.DecodeString("TC0Jzr8f28kAspXrb4UYccJUJ63b7CSA16n1qMxxGpw=")
+	globals.hub = &Hub{userStatus: make(chan *userStatusReq, 10)}
+
+	root := &Session{sid: "root", uid: types.Uid(1), authLvl: auth.LevelRoot, proto: WEBSOCK}
+	user := &Session{sid: "user", uid: types.Uid(2), authLvl: auth.LevelAuth, proto: WEBSOCK}
+	cache := map[string]*Session{root.sid: root, user.sid: user}
+	for _, s := range sessions {
+		cache[s.sid] = s
+	}
+	globals.sessionStore = &SessionStore{lru: list.New(), sessCache: cache}
+	t.Cleanup(func() {
+		store.Users = nil
+		store.Topics = nil
+		globals.apiKeySalt = nil
+		globals.hub = nil
+		globals.sessionStore = nil
+		ctrl.Finish()
+	})
+	return uu, tt
+}
+
+// adminRequest sends the request to the admin API on behalf of the session with the given ID.
+func adminRequest(t *testing.T, method, path, sid, body string) *adminResponse {
+	req := httptest.NewRequest(method, "/v0/admin/"+path, strings.NewReader(body))
+	req.Header.Set("X-Tinode-APIKey", testAdminAPIKey)
+	if sid != "" {
+		q := req.URL.Query()
+		q.Set("sid", sid)
+		req.URL.RawQuery = q.Encode()
+	}
+	wrt := httptest.NewRecorder()
+	serveAdmin(wrt, req)
+
+	var resp adminResponse
+	if err := json.Unmarshal(wrt.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: invalid response %v", method, path, err)
+	}
+	if resp.Ctrl.Code != wrt.Code {
+		t.Errorf("%s %s: HTTP status %d does not match code %d", method, path, wrt.Code, resp.Ctrl.Code)
+	}
+	return &resp
+}
+
+func TestServeAdminAccess(t *testing.T) {
+	setupTestAdmin(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v0/admin/users?sid=root", nil)
+	wrt := httptest.NewRecorder()
+	serveAdmin(wrt, req)
+	if wrt.Code != http.StatusForbidden {
+		t.Errorf("Missing API key: expected %d, got %d", http.StatusForbidden, wrt.Code)
+	}
+
+	for i, tc := range []struct {
+		sid, path string
+		expected  int
+	}{
+		{"", "users", http.StatusUnauthorized},
+		{"user", "users", http.StatusForbidden},
+		{"root", "groups", http.StatusNotFound},
+		{"root", "users/usrAQAAAAAAAAA/unknown", http.StatusNotFound},
+		{"root", "users/invalid", http.StatusBadRequest},
+	} {
+		if resp := adminRequest(t, http.MethodGet, tc.path, tc.sid, ""); resp.Ctrl.Code != tc.expected {
+			t.Errorf("%d: expected %d, got %d", i, tc.expected, resp.Ctrl.Code)
+		}
+	}
+
+	// Password of the root user is not accepted: it would bypass the second factor.
+	req = httptest.NewRequest(http.MethodGet, "/v0/admin/users", nil)
+	req.Header.Set("X-Tinode-APIKey", testAdminAPIKey)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("root:secret")))
+	wrt = httptest.NewRecorder()
+	serveAdmin(wrt, req)
+	if wrt.Code != http.StatusUnauthorized {
+		t.Errorf("Basic auth: expected %d, got %d", http.StatusUnauthorized, wrt.Code)
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	// Live session of the user to suspend.
+	target := &Session{sid: "target", uid: types.Uid(3), proto: WEBSOCK, stop: make(chan any, 1)}
+	uu, _ := setupTestAdmin(t, target)
+
+	users := []types.User{
+		{ObjHeader: types.ObjHeader{Id: types.Uid(3).String()}},
+		{ObjHeader: types.ObjHeader{Id: types.Uid(4).String()}, State: types.StateSuspended},
+	}
+	uu.EXPECT().List(types.Uid(2), 2).Return(users, nil)
+	resp := adminRequest(t, http.MethodGet, "users?limit=2&after="+types.Uid(2).UserId(), "root", "")
+	var list struct {
+		Users []adminUser `json:"users"`
+	}
+	if err := json.Unmarshal(resp.Ctrl.Params, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Users) != 2 || list.Users[0].Id != types.Uid(3).UserId() || list.Users[1].State != "susp" {
+		t.Errorf("List: unexpected result %+v", list.Users)
+	}
+
+	// Suspending evicts live sessions, repeated request changes nothing.
+	uu.EXPECT().Get(types.Uid(3)).Return(&users[0], nil)
+	uu.EXPECT().UpdateState(types.Uid(3), types.StateSuspended).Return(nil)
+	path := "users/" + types.Uid(3).UserId() + "/state"
+	if resp = adminRequest(t, http.MethodPut, path, "root", `{"state":"susp"}`); resp.Ctrl.Code != http.StatusOK {
+		t.Errorf("Suspend: expected %d, got %d", http.StatusOK, resp.Ctrl.Code)
+	}
+	if len(target.stop) != 1 {
+		t.Error("Session of the suspended user must be evicted")
+	}
+	if req := <-globals.hub.userStatus; req.forUser != types.Uid(3) || req.state != types.StateSuspended {
+		t.Errorf("Hub must be notified, got %+v", req)
+	}
+	uu.EXPECT().Get(types.Uid(3)).Return(&users[0], nil)
+	if resp = adminRequest(t, http.MethodPut, path, "root", `{"state":"susp"}`); resp.Ctrl.Code != http.StatusNotModified {
+		t.Errorf("Suspend again: expected %d, got %d", http.StatusNotModified, resp.Ctrl.Code)
+	}
+	uu.EXPECT().Get(types.Uid(3)).Return(&users[0], nil)
+	if resp = adminRequest(t, http.MethodPut, path, "root", `{"state":"gone"}`); resp.Ctrl.Code != http.StatusBadRequest {
+		t.Errorf("Invalid state: expected %d, got %d", http.StatusBadRequest, resp.Ctrl.Code)
+	}
+
+	uu.EXPECT().Get(types.Uid(3)).Return(&users[0], nil)
+	path = "users/" + types.Uid(3).UserId() + "/export"
+	if resp = adminRequest(t, http.MethodPost, path, "root", ""); resp.Ctrl.Code != http.StatusNotImplemented {
+		t.Errorf("Export disabled: expected %d, got %d", http.StatusNotImplemented, resp.Ctrl.Code)
+	}
+
+	uu.EXPECT().Get(types.Uid(5)).Return(nil, nil)
+	if resp = adminRequest(t, http.MethodDelete, "users/"+types.Uid(5).UserId(), "root", ""); resp.Ctrl.Code != http.StatusNotFound {
+		t.Errorf("Unknown user: expected %d, got %d", http.StatusNotFound, resp.Ctrl.Code)
+	}
+}
+
+func TestAdminTopicsAndSessions(t *testing.T) {
+	_, tt := setupTestAdmin(t)
+
+	// Channel name is converted to the group topic name.
+	tt.EXPECT().Get("grpAQAAAAAAAAA").Return(&types.Topic{ObjHeader: types.ObjHeader{Id: "grpAQAAAAAAAAA"},
+		UseBt: true, SeqId: 10}, nil)
+	resp := adminRequest(t, http.MethodGet, "topics/chnAQAAAAAAAAA", "root", "")
+	var topic struct {
+		Topic adminTopic `json:"topic"`
+	}
+	if err := json.Unmarshal(resp.Ctrl.Params, &topic); err != nil {
+		t.Fatal(err)
+	}
+	if topic.Topic.Name != "grpAQAAAAAAAAA" || !topic.Topic.Channel || topic.Topic.SeqId != 10 {
+		t.Errorf("Topic: unexpected result %+v", topic.Topic)
+	}
+	if resp = adminRequest(t, http.MethodDelete, "topics/grpAQAAAAAAAAA", "root", ""); resp.Ctrl.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Delete topic: expected %d, got %d", http.StatusMethodNotAllowed, resp.Ctrl.Code)
+	}
+
+	resp = adminRequest(t, http.MethodGet, "sessions?user="+types.Uid(2).UserId(), "root", "")
+	var sessions struct {
+		Sessions []adminSession `json:"sessions"`
+	}
+	if err := json.Unmarshal(resp.Ctrl.Params, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].Sid != "user" || sessions.Sessions[0].Proto != "ws" {
+		t.Errorf("Sessions: unexpected result %+v", sessions.Sessions)
+	}
+
+	if resp = adminRequest(t, http.MethodDelete, "sessions/unknown", "root", ""); resp.Ctrl.Code != http.StatusNotFound {
+		t.Errorf("Evict unknown: expected %d, got %d", http.StatusNotFound, resp.Ctrl.Code)
+	}
+}
+
+// Sessions are listed while they are being authenticated. Run with -race.
+func TestAdminSessionsConcurrentLogin(t *testing.T) {
+	target := &Session{sid: "target", proto: WEBSOCK}
+	setupTestAdmin(t, target)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			target.setUid(types.Uid(3 + i%2))
+			target.setRemoteAddr("10.0.0." + strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if resp := adminRequest(t, http.MethodGet, "sessions?user="+types.Uid(3).UserId(), "root", ""); resp.Ctrl.Code != http.StatusOK {
+			t.Errorf("%d: expected %d, got %d", i, http.StatusOK, resp.Ctrl.Code)
+		}
+	}
+	<-done
+}
+
+func TestAdminAudit(t *testing.T) {
+	setupTestAdmin(t)
+	ctrl := gomock.NewController(t)
+	aa := mock_store.NewMockAuditPersistenceInterface(ctrl)
+	store.Audit = aa
+	t.Cleanup(func() {
+		store.Audit = nil
+		ctrl.Finish()
+	})
+
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	aa.EXPECT().GetAll(&types.AuditQuery{User: types.Uid(3), Action: auditStateChange, Since: &since, Limit: 5}).
+		Return([]types.AuditRecord{{Id: "rec1", CreatedAt: since, Action: auditStateChange,
+			Actor: types.Uid(1).String(), User: types.Uid(3).String(), Params: types.KVMap{"state": "susp"}}}, nil)
+	resp := adminRequest(t, http.MethodGet, "audit?limit=5&action=state_change&since=2024-01-02T03:04:05Z&user="+
+		types.Uid(3).UserId(), "root", "")
+	var audit struct {
+		Audit []auditEntry `json:"audit"`
+	}
+	if err := json.Unmarshal(resp.Ctrl.Params, &audit); err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Audit) != 1 || audit.Audit[0].Actor != types.Uid(1).UserId() ||
+		audit.Audit[0].User != types.Uid(3).UserId() || audit.Audit[0].Params["state"] != "susp" {
+		t.Errorf("Audit: unexpected result %+v", audit.Audit)
+	}
+
+	for _, path := range []string{"audit?since=yesterday", "audit?user=invalid"} {
+		if resp = adminRequest(t, http.MethodGet, path, "root", ""); resp.Ctrl.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %d", path, http.StatusBadRequest, resp.Ctrl.Code)
+		}
+	}
+}
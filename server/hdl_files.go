@@ -34,6 +34,9 @@ import (
 // See https://www.iana.org/assignments/media-types/media-types.xhtml
 var allowedMimeTypes = []string{"application/", "audio/", "font/", "image/", "text/", "video/"}
 
+// Uploaded files not linked to anything are deleted by the garbage collector after this time.
+const unusedFileMaxAge = time.Hour
+
 func largeFileServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 	now := types.TimeNow()
 	enc := json.NewEncoder(wrt)
@@ -76,29 +79,45 @@ func largeFileServeHTTP(wrt http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Check for API key presence
-	if isValid, _ := checkAPIKey(getAPIKey(req)); !isValid {
-		writeHttpResponse(ErrAPIKeyRequired(now), errors.New("invalid or missing API key"))
-		return
-	}
+	var uid types.Uid
+	if sig := req.FormValue("sig"); sig != "" && globals.exportLinks != nil {
+		// Time-limited link to an export of user's data. The link is signed by the server,
+		// the signature replaces the API key and authentication.
+		if err := globals.exportLinks.verify(mh.GetIdFromUrl(req.URL.String()), req.FormValue("exp"), sig, now); err != nil {
+			if err == types.ErrExpired {
+				writeHttpResponse(ErrGone("", "", now), errors.New("download link expired"))
+			} else {
+				writeHttpResponse(ErrPermissionDenied("", "", now), errors.New("invalid download link"))
+			}
+			return
+		}
+	} else {
+		// Check for API key presence
+		if getAPIKey(req) == nil {
+			writeHttpResponse(ErrAPIKeyRequired(now), errors.New("invalid or missing API key"))
+			return
+		}
 
-	// Check authorization: either auth information or SID must be present
-	authMethod, secret := getHttpAuth(req)
-	uid, challenge, err := authFileRequest(authMethod, secret, req.FormValue("sid"), getRemoteAddr(req))
-	if err != nil {
-		writeHttpResponse(decodeStoreError(err, "", now, nil), err)
-		return
-	}
+		// Check authorization: either auth information or SID must be present
+		authMethod, secret := getHttpAuth(req)
+		var challenge []byte
+		var err error
+		uid, challenge, err = authFileRequest(authMethod, secret, req.FormValue("sid"), getRemoteAddr(req))
+		if err != nil {
+			writeHttpResponse(decodeStoreError(err, "", now, nil), err)
+			return
+		}
 
-	if challenge != nil {
-		writeHttpResponse(InfoChallenge("", now, challenge), nil)
-		return
-	}
+		if challenge != nil {
+			writeHttpResponse(InfoChallenge("", now, challenge), nil)
+			return
+		}
 
-	if uid.IsZero() {
-		// Not authenticated
-		writeHttpResponse(ErrAuthRequired("", "", now, now), errors.New("user not authenticated"))
-		return
+		if uid.IsZero() {
+			// Not authenticated
+			writeHttpResponse(ErrAuthRequired("", "", now, now), errors.New("user not authenticated"))
+			return
+		}
 	}
 
 	// Check if media handler redirects or adds headers.
@@ -216,7 +235,7 @@ func largeFileReceiveHTTP(wrt http.ResponseWriter, req *http.Request) {
 	}
 
 	// Check for API key presence
-	if isValid, _ := checkAPIKey(getAPIKey(req)); !isValid {
+	if getAPIKey(req) == nil {
 		writeHttpResponse(ErrAPIKeyRequired(now), nil)
 		return
 	}
@@ -588,7 +607,7 @@ func largeFileRunGarbageCollection(period time.Duration, blockSize int) chan<- b
 		for {
 			select {
 			case <-gcTicker:
-				if err := store.Files.DeleteUnused(time.Now().Add(-time.Hour), blockSize); err != nil {
+				if err := store.Files.DeleteUnused(time.Now().Add(-unusedFileMaxAge), blockSize); err != nil {
 					logs.Warn.Println("media gc:", err)
 				}
 			case <-stop:
@@ -38,9 +38,9 @@ func (sess *Session) closeGrpc() {
 func (*grpcNodeServer) MessageLoop(stream pbx.Node_MessageLoopServer) error {
 	sess, count := globals.sessionStore.NewSession(stream, "")
 	if p, ok := peer.FromContext(stream.Context()); ok {
-		sess.remoteAddr = p.Addr.String()
+		sess.setRemoteAddr(p.Addr.String())
 	}
-	logs.Info.Println("grpc: session started", sess.sid, sess.remoteAddr, count)
+	logs.Info.Println("grpc: session started", sess.sid, sess.getRemoteAddr(), count)
 
 	defer func() {
 		sess.closeGrpc()
@@ -132,7 +132,8 @@ func serveLongPoll(wrt http.ResponseWriter, req *http.Request) {
 
 	enc := json.NewEncoder(wrt)
 
-	if isValid, _ := checkAPIKey(getAPIKey(req)); !isValid {
+	apiKey := getAPIKey(req)
+	if apiKey == nil {
 		wrt.WriteHeader(http.StatusForbidden)
 		enc.Encode(ErrAPIKeyRequired(now))
 		return
@@ -159,8 +160,9 @@ func serveLongPoll(wrt http.ResponseWriter, req *http.Request) {
 		// New session
 		var count int
 		sess, count = globals.sessionStore.NewSession(wrt, "")
-		sess.remoteAddr = getRemoteAddr(req)
-		logs.Info.Println("longPoll: session started", sess.sid, sess.remoteAddr, count)
+		sess.apiKey = apiKey
+		sess.setRemoteAddr(getRemoteAddr(req))
+		logs.Info.Println("longPoll: session started", sess.sid, sess.getRemoteAddr(), count)
 
 		wrt.WriteHeader(http.StatusCreated)
 		pkt := NoErrCreated(req.FormValue("id"), "", now)
@@ -181,8 +183,8 @@ func serveLongPoll(wrt http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if addr := getRemoteAddr(req); sess.remoteAddr != addr {
-		sess.remoteAddr = addr
+	if addr := getRemoteAddr(req); sess.getRemoteAddr() != addr {
+		sess.setRemoteAddr(addr)
 		logs.Warn.Println("longPoll: remote address changed", sid, addr)
 	}
 
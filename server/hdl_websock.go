@@ -168,7 +168,8 @@ var upgrader = websocket.Upgrader{
 func serveWebSocket(wrt http.ResponseWriter, req *http.Request) {
 	now := types.TimeNow()
 
-	if isValid, _ := checkAPIKey(getAPIKey(req)); !isValid {
+	apiKey := getAPIKey(req)
+	if apiKey == nil {
 		wrt.WriteHeader(http.StatusForbidden)
 		json.NewEncoder(wrt).Encode(ErrAPIKeyRequired(now))
 		logs.Err.Println("ws: Missing, invalid or expired API key")
@@ -192,17 +193,20 @@ func serveWebSocket(wrt http.ResponseWriter, req *http.Request) {
 	}
 
 	sess, count := globals.sessionStore.NewSession(ws, "")
+	sess.apiKey = apiKey
+	var addr string
 	if globals.useXForwardedFor {
-		sess.remoteAddr = req.Header.Get("X-Forwarded-For")
-		if !isRoutableIP(sess.remoteAddr) {
-			sess.remoteAddr = ""
+		addr = req.Header.Get("X-Forwarded-For")
+		if !isRoutableIP(addr) {
+			addr = ""
 		}
 	}
-	if sess.remoteAddr == "" {
-		sess.remoteAddr = req.RemoteAddr
+	if addr == "" {
+		addr = req.RemoteAddr
 	}
+	sess.setRemoteAddr(addr)
 
-	logs.Info.Println("ws: session started", sess.sid, sess.remoteAddr, count)
+	logs.Info.Println("ws: session started", sess.sid, sess.getRemoteAddr(), count)
 
 	// Do work in goroutines to return from serveWebSocket() to release file pointers.
 	// Otherwise "too many open files" will happen.
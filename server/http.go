@@ -142,6 +142,11 @@ Loop:
 			// Stop updating users cache
 			usersShutdown()
 
+			// Close the audit log after all actions are completed.
+			if globals.audit != nil {
+				globals.audit.close()
+			}
+
 			break Loop
 
 		case <-httpdone:
@@ -297,8 +302,22 @@ func cacheControlHandler(maxAge int, handler http.Handler) http.Handler {
 	return handler
 }
 
-// Get API key from an HTTP request.
-func getAPIKey(req *http.Request) string {
+// Get API key from an HTTP request and resolve it against the stored keys. Returns nil if the key is
+// missing, invalid, revoked or not allowed for the Origin of the request.
+func getAPIKey(req *http.Request) *types.APIKey {
+	key := resolveAPIKey(getAPIKeyString(req))
+	if key == nil {
+		return nil
+	}
+	if len(key.Origins) > 0 && !apiKeyAllows(key.Origins, req.Header.Get("Origin")) {
+		logs.Warn.Println("apikey not allowed for origin", key.Id, req.Header.Get("Origin"))
+		return nil
+	}
+	return key
+}
+
+// Get API key string from an HTTP request.
+func getAPIKeyString(req *http.Request) string {
 	// Check header.
 	apikey := req.Header.Get("X-Tinode-APIKey")
 	if apikey != "" {
@@ -439,7 +458,7 @@ func serveStatus(wrt http.ResponseWriter, req *http.Request) {
 			clnode = s.clnode.name
 		}
 		result.Sessions = append(result.Sessions, debugSession{
-			RemoteAddr: s.remoteAddr,
+			RemoteAddr: s.getRemoteAddr(),
 			Ua:         s.userAgent,
 			Uid:        s.uid.String(),
 			Sid:        sid,
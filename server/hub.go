@@ -103,6 +103,41 @@ func (h *Hub) topicDel(name string) {
 	h.topics.Delete(name)
 }
 
+// topicNew creates a topic for the request which caused it to be loaded and saves it in suspended state.
+// The topic must be configured by topicInit.
+func (h *Hub) topicNew(msg *ClientComMessage) *Topic {
+	t := &Topic{
+		name:      msg.RcptTo,
+		xoriginal: msg.Original,
+		// Indicates a proxy topic.
+		isProxy:   globals.cluster.isRemoteTopic(msg.RcptTo),
+		sessions:  make(map[*Session]perSessionData),
+		clientMsg: make(chan *ClientComMessage, 192),
+		serverMsg: make(chan *ServerComMessage, 64),
+		reg:       make(chan *ClientComMessage, 256),
+		unreg:     make(chan *ClientComMessage, 256),
+		meta:      make(chan *ClientComMessage, 64),
+		perUser:   make(map[types.Uid]perUserData),
+		exit:      make(chan *shutDown, 1),
+	}
+	if globals.cluster != nil {
+		if t.isProxy {
+			t.proxy = make(chan *ClusterResp, 32)
+			t.masterNode = globals.cluster.ring.Get(t.name)
+		} else {
+			// It's a master topic. Make a channel for handling
+			// direct messages from the proxy.
+			t.master = make(chan *ClusterSessUpdate, 8)
+		}
+	}
+	// Topic is created in suspended state because it's not yet configured.
+	t.markPaused(true)
+	// Save topic now to prevent race condition.
+	h.topicPut(msg.RcptTo, t)
+
+	return t
+}
+
 func newHub() *Hub {
 	h := &Hub{
 		topics: &sync.Map{},
@@ -165,34 +200,7 @@ func (h *Hub) run() {
 			t := h.topicGet(join.RcptTo)
 			if t == nil {
 				// Topic does not exist or not loaded.
-				t = &Topic{
-					name:      join.RcptTo,
-					xoriginal: join.Original,
-					// Indicates a proxy topic.
-					isProxy:   globals.cluster.isRemoteTopic(join.RcptTo),
-					sessions:  make(map[*Session]perSessionData),
-					clientMsg: make(chan *ClientComMessage, 192),
-					serverMsg: make(chan *ServerComMessage, 64),
-					reg:       make(chan *ClientComMessage, 256),
-					unreg:     make(chan *ClientComMessage, 256),
-					meta:      make(chan *ClientComMessage, 64),
-					perUser:   make(map[types.Uid]perUserData),
-					exit:      make(chan *shutDown, 1),
-				}
-				if globals.cluster != nil {
-					if t.isProxy {
-						t.proxy = make(chan *ClusterResp, 32)
-						t.masterNode = globals.cluster.ring.Get(t.name)
-					} else {
-						// It's a master topic. Make a channel for handling
-						// direct messages from the proxy.
-						t.master = make(chan *ClusterSessUpdate, 8)
-					}
-				}
-				// Topic is created in suspended state because it's not yet configured.
-				t.markPaused(true)
-				// Save topic now to prevent race condition.
-				h.topicPut(join.RcptTo, t)
+				t = h.topicNew(join)
 
 				// Configure the topic.
 				go topicInit(t, join, h)
@@ -233,6 +241,12 @@ func (h *Hub) run() {
 				} else {
 					logs.Warn.Println("hub: invalid topic category for broadcast", dst.name)
 				}
+			} else if msg.Scheduled != "" || msg.MsgExpiry {
+				// Delivery of a scheduled message or deletion of expired messages: load the topic
+				// then let it handle the message.
+				t := h.topicNew(msg)
+				t.clientMsg <- msg
+				go topicInit(t, msg, h)
 			} else if msg.Note == nil {
 				// Topic is unknown or offline.
 				// Note is silently ignored, all other messages are reported as accepted to prevent
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	_ "github.com/tinode/chat/server/db/memory"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Store objects backed by a database. Captured at startup because other tests replace them with mocks.
+var (
+	dbStore    = store.Store
+	dbUsers    = store.Users
+	dbTopics   = store.Topics
+	dbSubs     = store.Subs
+	dbMessages = store.Messages
+	dbFiles    = store.Files
+)
+
+// setUpMemoryHub opens the store with the in-memory adapter and starts the hub.
+func setUpMemoryHub(t *testing.T) {
+	t.Helper()
+	store.Store = dbStore
+	store.Users = dbUsers
+	store.Topics = dbTopics
+	store.Subs = dbSubs
+	store.Messages = dbMessages
+	store.Files = dbFiles
+
+	config := `{"uid_key":"la6YsO+bNX/+XIkOqc5Svw==","use_adapter":"memory","adapters":{"memory":{}}}`
+	if err := store.Store.InitDb(json.RawMessage(config), true); err != nil {
+		t.Fatal("Failed to open memory store:", err)
+	}
+	// Same as newHub but without registering the stats which can be registered only once.
+	globals.hub = &Hub{
+		topics:     &sync.Map{},
+		routeCli:   make(chan *ClientComMessage, 64),
+		routeSrv:   make(chan *ServerComMessage, 64),
+		join:       make(chan *ClientComMessage, 64),
+		unreg:      make(chan *topicUnreg, 64),
+		rehash:     make(chan bool),
+		meta:       make(chan *ClientComMessage, 64),
+		userStatus: make(chan *userStatusReq, 64),
+		shutdown:   make(chan chan<- bool),
+	}
+	go globals.hub.run()
+
+	t.Cleanup(func() {
+		done := make(chan bool)
+		globals.hub.shutdown <- done
+		<-done
+		globals.hub = nil
+		store.Store.Close()
+	})
+}
+
+// newMemoryHubUser creates a user in the store.
+func newMemoryHubUser(t *testing.T) types.Uid {
+	t.Helper()
+	user, err := store.Users.Create(&types.User{
+		Access: types.DefaultAccess{Auth: types.ModeCAuth, Anon: types.ModeNone},
+	}, nil)
+	if err != nil {
+		t.Fatal("Failed to create user:", err)
+	}
+	return user.Uid()
+}
+
+// newMemoryHubSession creates a session authenticated as the given user.
+func newMemoryHubSession(sid string, uid types.Uid) *Session {
+	s := &Session{
+		sid:          sid,
+		uid:          uid,
+		authLvl:      auth.LevelAuth,
+		ver:          16,
+		subs:         make(map[string]*Subscription),
+		send:         make(chan any, 64),
+		stop:         make(chan any, 1),
+		detach:       make(chan string, 64),
+		inflightReqs: newBoundedWaitGroup(1),
+	}
+	s.bkgTimer = time.NewTimer(time.Hour)
+	s.bkgTimer.Stop()
+	return s
+}
+
+// expectMessage waits for the message sent to the session which satisfies the condition.
+// Other messages are skipped.
+func expectMessage(t *testing.T, s *Session, what string, match func(*ServerComMessage) bool) *ServerComMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-s.send:
+			if srv, ok := msg.(*ServerComMessage); ok && match(srv) {
+				return srv
+			}
+		case <-timeout:
+			t.Fatalf("Session %s: timed out waiting for %s", s.sid, what)
+			return nil
+		}
+	}
+}
+
+// expectCtrl waits for the {ctrl} response to the request with the given ID and checks the response code.
+func expectCtrl(t *testing.T, s *Session, id string, code int) *MsgServerCtrl {
+	t.Helper()
+	msg := expectMessage(t, s, "ctrl "+id, func(m *ServerComMessage) bool {
+		return m.Ctrl != nil && m.Ctrl.Id == id
+	})
+	if msg.Ctrl.Code != code {
+		t.Fatalf("Request %s: expected response code %d, got %d %s", id, code, msg.Ctrl.Code, msg.Ctrl.Text)
+	}
+	return msg.Ctrl
+}
+
+func TestHubGroupTopicMemoryStore(t *testing.T) {
+	setUpMemoryHub(t)
+
+	alice := newMemoryHubUser(t)
+	bob := newMemoryHubUser(t)
+	sa := newMemoryHubSession("alice", alice)
+	sb := newMemoryHubSession("bob", bob)
+
+	// Alice creates a group topic.
+	sa.dispatch(&ClientComMessage{Sub: &MsgClientSub{
+		Id:    "1",
+		Topic: "new",
+		Set:   &MsgSetQuery{Desc: &MsgSetDesc{Public: map[string]any{"fn": "Test group"}}},
+	}})
+	topic := expectCtrl(t, sa, "1", http.StatusOK).Topic
+	if types.GetTopicCat(topic) != types.TopicCatGrp {
+		t.Fatalf("Expected a group topic, got '%s'", topic)
+	}
+
+	// Bob joins the topic with the default access.
+	sb.dispatch(&ClientComMessage{Sub: &MsgClientSub{Id: "2", Topic: topic}})
+	expectCtrl(t, sb, "2", http.StatusOK)
+
+	// Alice publishes a message, Bob receives it.
+	sa.dispatch(&ClientComMessage{Pub: &MsgClientPub{Id: "3", Topic: topic, Content: "hello", NoEcho: true}})
+	if params, _ := expectCtrl(t, sa, "3", http.StatusAccepted).Params.(map[string]any); params["seq"] != 1 {
+		t.Errorf("Publish: expected seq 1, got %v", params["seq"])
+	}
+	data := expectMessage(t, sb, "data", func(m *ServerComMessage) bool { return m.Data != nil }).Data
+	if data.Topic != topic || data.From != alice.UserId() || data.SeqId != 1 || data.Content != "hello" {
+		t.Errorf("Data: unexpected message %+v", data)
+	}
+
+	// The topic, the subscriptions and the message are saved to the store.
+	stored, err := store.Topics.Get(topic)
+	if err != nil || stored == nil {
+		t.Fatal("Topic not saved:", err)
+	}
+	if stored.Owner != alice.String() || stored.SeqId != 1 {
+		t.Errorf("Topic: expected owner %s and seq 1, got %s and %d", alice.UserId(), stored.Owner, stored.SeqId)
+	}
+	subs, err := store.Topics.GetSubs(topic, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 {
+		t.Errorf("Subscriptions: expected 2, got %d", len(subs))
+	}
+	msgs, err := store.Messages.GetAll(topic, bob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "hello" || msgs[0].From != alice.String() {
+		t.Errorf("Messages: expected one message from %s, got %+v", alice.UserId(), msgs)
+	}
+}
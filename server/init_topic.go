@@ -141,11 +141,11 @@ func initTopicMe(t *Topic, sreg *ClientComMessage) error {
 	user, err := store.Users.Get(types.ParseUserId(t.name))
 	if err != nil {
 		// Log out the session
-		sreg.sess.uid = types.ZeroUid
+		sreg.sess.setUid(types.ZeroUid)
 		return err
 	} else if user == nil {
 		// Log out the session
-		sreg.sess.uid = types.ZeroUid
+		sreg.sess.setUid(types.ZeroUid)
 		return types.ErrUserNotFound
 	}
 
@@ -198,7 +198,7 @@ func initTopicFnd(t *Topic, sreg *ClientComMessage) error {
 		return err
 	} else if user == nil {
 		if !sreg.sess.isMultiplex() {
-			sreg.sess.uid = types.ZeroUid
+			sreg.sess.setUid(types.ZeroUid)
 		}
 		return types.ErrNotFound
 	}
@@ -263,6 +263,8 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 			t.touched = stopic.TouchedAt
 		}
 		t.aux = stopic.Aux
+		t.pinned = stopic.Pinned
+		t.msgTTL = stopic.MsgTTL
 		t.lastID = stopic.SeqId
 		t.delID = stopic.DelId
 	}
@@ -299,6 +301,10 @@ func initTopicP2P(t *Topic, sreg *ClientComMessage) error {
 	} else {
 		// Cases 1 (new topic), 2 (one of the two subscriptions is missing: either it's a new request
 		// or the subscription was deleted)
+		if pktsub == nil {
+			// The topic is being loaded to deliver a message, not to subscribe to it.
+			return types.ErrTopicNotFound
+		}
 		var userData perUserData
 
 		// Fetching records for both users.
@@ -647,9 +653,13 @@ func initTopicGrp(t *Topic) error {
 	t.accessAuth = stopic.Access.Auth
 	t.accessAnon = stopic.Access.Anon
 
-	// Assign tags & auxiliary data.
+	// Assign tags, auxiliary data, pinned messages, message TTL & slow mode.
 	t.tags = stopic.Tags
 	t.aux = stopic.Aux
+	t.pinned = stopic.Pinned
+	t.msgTTL = stopic.MsgTTL
+	t.slowMode = stopic.SlowMode
+	t.msgBurst = stopic.MsgBurst
 
 	t.public = stopic.Public
 	t.trusted = stopic.Trusted
@@ -734,10 +744,17 @@ func initTopicSlf(t *Topic, sreg *ClientComMessage) error {
 			t.touched = stopic.TouchedAt
 		}
 		t.aux = stopic.Aux
+		t.pinned = stopic.Pinned
+		t.msgTTL = stopic.MsgTTL
 		t.lastID = stopic.SeqId
 		t.delID = stopic.DelId
 
 	} else {
+		if sreg.Sub == nil {
+			// The topic is being loaded to deliver a message, not to subscribe to it.
+			return types.ErrTopicNotFound
+		}
+
 		// Get topic owner.
 		userID := types.ParseUserId(sreg.AsUser)
 		user, err := store.Users.Get(userID)
@@ -0,0 +1,273 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Limiting failed login attempts per account and per IP address.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// Prefix of the persistent cache keys of the login limiter.
+const loginLimitKeyPrefix = "loginfail_"
+
+// How many times to retry updating a counter which is concurrently updated by other sessions.
+const loginLimitMaxRetries = 10
+
+// loginLimiter counts failed login attempts and temporarily locks out accounts and IP addresses
+// with too many failures.
+type loginLimiter interface {
+	// Check returns the time when the lockout of the account or the IP address ends, or zero time
+	// if neither is locked out. Blank account or address is not checked.
+	Check(account, addr string) (time.Time, error)
+	// Fail records a failed login attempt. Returns the times when the lockouts of the account and
+	// of the IP address end if the failure caused them to be locked out, zero times otherwise.
+	Fail(account, addr string) (accountUntil, addrUntil time.Time, err error)
+	// Reset clears failed attempts of the account after a successful login.
+	Reset(account string) error
+}
+
+// pcacheLoginLimiter is a loginLimiter which keeps the counters in the persistent cache
+// shared by all cluster nodes.
+type pcacheLoginLimiter struct {
+	maxFailures     int
+	maxAddrFailures int
+	lockout         time.Duration
+	maxLockout      time.Duration
+	resetAfter      time.Duration
+}
+
+func newLoginLimiter(config *loginLimitConfig) (loginLimiter, error) {
+	if config.MaxFailures <= 0 || config.MaxAddrFailures <= 0 || config.Lockout <= 0 ||
+		config.MaxLockout < config.Lockout || config.ResetAfter <= 0 {
+		return nil, errors.New("invalid login limit config")
+	}
+	return &pcacheLoginLimiter{
+		maxFailures:     config.MaxFailures,
+		maxAddrFailures: config.MaxAddrFailures,
+		lockout:         time.Duration(config.Lockout) * time.Second,
+		maxLockout:      time.Duration(config.MaxLockout) * time.Second,
+		resetAfter:      time.Duration(config.ResetAfter) * time.Second,
+	}, nil
+}
+
+// Check returns the end of the current lockout of the account or the IP address.
+func (ll *pcacheLoginLimiter) Check(account, addr string) (time.Time, error) {
+	var until time.Time
+	now := time.Now()
+	for _, key := range []string{loginLimitAccountKey(account), loginLimitAddrKey(addr)} {
+		if key == "" {
+			continue
+		}
+		_, lockedUntil, _, err := ll.get(key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if lockedUntil.After(now) && lockedUntil.After(until) {
+			until = lockedUntil
+		}
+	}
+	return until, nil
+}
+
+// Fail records a failed login attempt for the account and the IP address.
+func (ll *pcacheLoginLimiter) Fail(account, addr string) (time.Time, time.Time, error) {
+	// Remove counters which are no longer needed.
+	store.PCache.Expire(loginLimitKeyPrefix, time.Now().UTC().Add(-ll.resetAfter-ll.maxLockout))
+
+	accountUntil, err := ll.fail(loginLimitAccountKey(account), ll.maxFailures)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	addrUntil, err := ll.fail(loginLimitAddrKey(addr), ll.maxAddrFailures)
+	return accountUntil, addrUntil, err
+}
+
+// Reset clears failed attempts of the account.
+func (ll *pcacheLoginLimiter) Reset(account string) error {
+	key := loginLimitAccountKey(account)
+	if key == "" {
+		return nil
+	}
+	if err := store.PCache.Delete(key); err != nil && err != types.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// fail increments the counter of failures and starts the lockout once the counter reaches the limit.
+// The lockout doubles with every subsequent failure. The counter is updated only if it was not changed
+// since it was read, otherwise the update is retried: concurrent failures are all counted.
+func (ll *pcacheLoginLimiter) fail(key string, maxFailures int) (time.Time, error) {
+	if key == "" {
+		return time.Time{}, nil
+	}
+
+	for range loginLimitMaxRetries {
+		old, err := store.PCache.Get(key)
+		if err != nil && err != types.ErrNotFound {
+			return time.Time{}, err
+		}
+		missing := err == types.ErrNotFound
+
+		count, lockedUntil, lastFailed := parseLoginLimitCounter(key, old)
+		until, value := ll.nextCounter(count, lockedUntil, lastFailed, maxFailures)
+
+		var ok bool
+		if missing {
+			if err = store.PCache.Upsert(key, value, true); err == nil {
+				ok = true
+			} else if err != types.ErrDuplicate {
+				return time.Time{}, err
+			}
+		} else if ok, err = store.PCache.Swap(key, old, value); err != nil {
+			return time.Time{}, err
+		}
+		if ok {
+			return until, nil
+		}
+	}
+	return time.Time{}, errors.New("login limit: too many concurrent updates of " + key)
+}
+
+// nextCounter counts one more failure. Returns the end of the lockout if the failure starts one and
+// the new value of the counter.
+func (ll *pcacheLoginLimiter) nextCounter(count int, lockedUntil, lastFailed time.Time,
+	maxFailures int) (time.Time, string) {
+	now := time.Now()
+	if lockedUntil.After(lastFailed) {
+		lastFailed = lockedUntil
+	}
+	if now.Sub(lastFailed) > ll.resetAfter {
+		count = 0
+	}
+	count++
+
+	var until time.Time
+	var untilMs int64
+	if count >= maxFailures {
+		lockout := ll.maxLockout
+		// Avoid overflow of the shift.
+		if shift := count - maxFailures; shift < 32 {
+			lockout = min(ll.lockout<<shift, ll.maxLockout)
+		}
+		// Stored with millisecond precision.
+		until = now.Add(lockout).Truncate(time.Millisecond)
+		untilMs = until.UnixMilli()
+	}
+
+	value := strconv.Itoa(count) + ":" + strconv.FormatInt(untilMs, 10) + ":" +
+		strconv.FormatInt(now.UnixMilli(), 10)
+	return until, value
+}
+
+// get reads the counter: number of failures, end of the lockout, the time of the last failure.
+func (ll *pcacheLoginLimiter) get(key string) (int, time.Time, time.Time, error) {
+	value, err := store.PCache.Get(key)
+	if err != nil {
+		if err == types.ErrNotFound {
+			err = nil
+		}
+		return 0, time.Time{}, time.Time{}, err
+	}
+	count, lockedUntil, lastFailed := parseLoginLimitCounter(key, value)
+	return count, lockedUntil, lastFailed, nil
+}
+
+// parseLoginLimitCounter parses the value of the counter. Blank or invalid value is an empty counter.
+func parseLoginLimitCounter(key, value string) (int, time.Time, time.Time) {
+	if value == "" {
+		return 0, time.Time{}, time.Time{}
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		logs.Warn.Println("login limit: invalid counter", key, value)
+		return 0, time.Time{}, time.Time{}
+	}
+	count, _ := strconv.Atoi(parts[0])
+	var lockedUntil time.Time
+	if until, _ := strconv.ParseInt(parts[1], 10, 64); until > 0 {
+		lockedUntil = time.UnixMilli(until)
+	}
+	last, _ := strconv.ParseInt(parts[2], 10, 64)
+	return count, lockedUntil, time.UnixMilli(last)
+}
+
+// loginLimitAccountKey returns the persistent cache key of the account's counter. The account is
+// hashed to keep the key short.
+func loginLimitAccountKey(account string) string {
+	if account == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(account))
+	return loginLimitKeyPrefix + "usr_" + hex.EncodeToString(hash[:16])
+}
+
+// loginLimitAddrKey returns the persistent cache key of the IP address counter. IPv6 addresses are counted
+// by /64 network: a client usually gets the whole network and can use any address in it.
+func loginLimitAddrKey(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	// IPv6 zone is irrelevant.
+	host, _, _ := strings.Cut(addr, "%")
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		addr = ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	// Do not allow % in keys.
+	return loginLimitKeyPrefix + "ip_" + strings.ReplaceAll(addr, "%", "/")
+}
+
+// loginLimitAddr strips the port from the remote address of the session.
+func loginLimitAddr(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// loginLimitAccount returns the account identifier for limiting login attempts and the login,
+// or empty strings if the authenticator does not identify accounts by login.
+func loginLimitAccount(handler auth.AuthHandler, secret []byte) (string, string) {
+	namer, ok := handler.(auth.LoginNamer)
+	if !ok {
+		return "", ""
+	}
+	login := namer.LoginName(secret)
+	if login == "" {
+		return "", ""
+	}
+	return handler.GetRealName() + ":" + login, login
+}
+
+// loginLimitLocked notifies plugins that the account with the given login is locked out.
+func loginLimitLocked(handler auth.AuthHandler, login string, until time.Time) {
+	uid, _, _, _, err := store.Users.GetAuthUniqueRecord(handler.GetRealName(), login)
+	if err != nil {
+		logs.Warn.Println("login limit: failed to find user", err)
+		return
+	}
+	if uid.IsZero() {
+		// Unknown login.
+		return
+	}
+	user, err := store.Users.Get(uid)
+	if err != nil || user == nil {
+		logs.Warn.Println("login limit: failed to get user", uid, err)
+		return
+	}
+	pluginAccountLocked(user, until)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// testPCache is an in-memory persistent cache.
+type testPCache map[string]string
+
+func (c testPCache) Get(key string) (string, error) {
+	if value, ok := c[key]; ok {
+		return value, nil
+	}
+	return "", types.ErrNotFound
+}
+
+func (c testPCache) Upsert(key string, value string, failOnDuplicate bool) error {
+	if _, ok := c[key]; ok && failOnDuplicate {
+		return types.ErrDuplicate
+	}
+	c[key] = value
+	return nil
+}
+
+func (c testPCache) Swap(key, oldValue, newValue string) (bool, error) {
+	if value, ok := c[key]; !ok || value != oldValue {
+		return false, nil
+	}
+	c[key] = newValue
+	return true, nil
+}
+
+func (c testPCache) Delete(key string) error {
+	delete(c, key)
+	return nil
+}
+
+func (c testPCache) Expire(keyPrefix string, olderThan time.Time) error {
+	return nil
+}
+
+func setupTestLoginLimiter(t *testing.T) (*pcacheLoginLimiter, testPCache) {
+	cache := testPCache{}
+	store.PCache = cache
+	t.Cleanup(func() {
+		store.PCache = nil
+	})
+	ll, err := newLoginLimiter(&loginLimitConfig{
+		Enabled:         true,
+		MaxFailures:     3,
+		MaxAddrFailures: 5,
+		Lockout:         10,
+		MaxLockout:      30,
+		ResetAfter:      600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ll.(*pcacheLoginLimiter), cache
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	ll, cache := setupTestLoginLimiter(t)
+
+	account, addr := "basic:alice", "10.0.0.1"
+	for i := 1; i < 3; i++ {
+		accountUntil, addrUntil, err := ll.Fail(account, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !accountUntil.IsZero() || !addrUntil.IsZero() {
+			t.Fatalf("Failure %d: unexpected lockout %v %v", i, accountUntil, addrUntil)
+		}
+	}
+	if until, _ := ll.Check(account, addr); !until.IsZero() {
+		t.Errorf("Unexpected lockout before the limit %v", until)
+	}
+
+	// Third failure locks the account but not the IP address.
+	accountUntil, addrUntil, _ := ll.Fail(account, addr)
+	if d := time.Until(accountUntil); d <= 9*time.Second || d > 10*time.Second {
+		t.Errorf("First lockout: expected 10s, got %v", d)
+	}
+	if !addrUntil.IsZero() {
+		t.Errorf("IP address must not be locked out yet")
+	}
+	if until, _ := ll.Check(account, ""); !until.Equal(accountUntil) {
+		t.Errorf("Check: expected %v, got %v", accountUntil, until)
+	}
+	if until, _ := ll.Check("basic:bob", addr); !until.IsZero() {
+		t.Errorf("Other account must not be locked out %v", until)
+	}
+
+	// Lockout ends, the next failure doubles it, then it's capped.
+	key := loginLimitAccountKey(account)
+	for _, expected := range []time.Duration{20 * time.Second, 30 * time.Second} {
+		parts := strings.Split(cache[key], ":")
+		cache[key] = parts[0] + ":1:" + parts[2]
+		accountUntil, _, _ = ll.Fail(account, "")
+		if d := time.Until(accountUntil); d <= expected-time.Second || d > expected {
+			t.Errorf("Lockout: expected %v, got %v", expected, d)
+		}
+	}
+
+	// Successful login resets the account.
+	if err := ll.Reset(account); err != nil {
+		t.Fatal(err)
+	}
+	if until, _ := ll.Check(account, ""); !until.IsZero() {
+		t.Errorf("Lockout must be reset, got %v", until)
+	}
+	if _, ok := cache[loginLimitAddrKey(addr)]; !ok {
+		t.Errorf("IP address counter must not be reset")
+	}
+}
+
+func TestLoginLimiterForget(t *testing.T) {
+	ll, cache := setupTestLoginLimiter(t)
+
+	// Failures long ago, one short of the limits.
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10)
+	cache[loginLimitAccountKey("basic:alice")] = "2:0:" + old
+	cache[loginLimitAddrKey("10.0.0.1")] = "4:0:" + old
+
+	accountUntil, addrUntil, err := ll.Fail("basic:alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accountUntil.IsZero() || !addrUntil.IsZero() {
+		t.Errorf("Old failures must be forgotten, got lockout %v %v", accountUntil, addrUntil)
+	}
+	if !strings.HasPrefix(cache[loginLimitAccountKey("basic:alice")], "1:") {
+		t.Errorf("Counter must restart, got %s", cache[loginLimitAccountKey("basic:alice")])
+	}
+}
+
+// racingPCache records a failure by another session between reading and updating a counter.
+type racingPCache struct {
+	testPCache
+	raced bool
+}
+
+func (c *racingPCache) Get(key string) (string, error) {
+	value, err := c.testPCache.Get(key)
+	if !c.raced {
+		c.raced = true
+		c.testPCache[key] = "1:0:" + strconv.FormatInt(time.Now().UnixMilli(), 10)
+	}
+	return value, err
+}
+
+func TestLoginLimiterConcurrentFailures(t *testing.T) {
+	ll, _ := setupTestLoginLimiter(t)
+	cache := &racingPCache{testPCache: testPCache{}}
+	store.PCache = cache
+
+	if _, _, err := ll.Fail("basic:alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	key := loginLimitAccountKey("basic:alice")
+	if !strings.HasPrefix(cache.testPCache[key], "2:") {
+		t.Errorf("Both failures must be counted, got %s", cache.testPCache[key])
+	}
+}
+
+func TestLoginLimitAddrKey(t *testing.T) {
+	// Addresses in the same IPv6 /64 network share the counter.
+	if loginLimitAddrKey("2001:db8:1:2::1") != loginLimitAddrKey("2001:db8:1:2:ffff::5%eth0") {
+		t.Error("Addresses in the same /64 network must have the same key")
+	}
+	if loginLimitAddrKey("2001:db8:1:2::1") == loginLimitAddrKey("2001:db8:1:3::1") {
+		t.Error("Addresses in different /64 networks must have different keys")
+	}
+	if loginLimitAddrKey("10.0.0.1") == loginLimitAddrKey("10.0.0.2") {
+		t.Error("IPv4 addresses must have different keys")
+	}
+}
+
+func TestLoginLimitAddr(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"10.0.0.1:1234":      "10.0.0.1",
+		"10.0.0.1":           "10.0.0.1",
+		"[fe80::1%eth0]:443": "fe80::1%eth0",
+		"2001:db8::1":        "2001:db8::1",
+		"":                   "",
+	} {
+		if host := loginLimitAddr(addr); host != expected {
+			t.Errorf("%q: expected %q, got %q", addr, expected, host)
+		}
+	}
+	if key := loginLimitAddrKey("fe80::1%eth0"); strings.Contains(key, "%") || len(key) > 64 {
+		t.Errorf("Invalid key %q", key)
+	}
+}
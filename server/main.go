@@ -30,8 +30,11 @@ import (
 	_ "github.com/tinode/chat/server/auth/anon"
 	_ "github.com/tinode/chat/server/auth/basic"
 	_ "github.com/tinode/chat/server/auth/code"
+	_ "github.com/tinode/chat/server/auth/oidc"
 	_ "github.com/tinode/chat/server/auth/rest"
 	_ "github.com/tinode/chat/server/auth/token"
+	_ "github.com/tinode/chat/server/auth/totp"
+	_ "github.com/tinode/chat/server/auth/webauthn"
 	"github.com/tinode/chat/server/store/types"
 
 	// Database backends
@@ -39,6 +42,7 @@ import (
 	_ "github.com/tinode/chat/server/db/mysql"
 	_ "github.com/tinode/chat/server/db/postgres"
 	_ "github.com/tinode/chat/server/db/rethinkdb"
+	_ "github.com/tinode/chat/server/db/sqlite"
 
 	"github.com/tinode/chat/server/logs"
 
@@ -94,6 +98,32 @@ const (
 	// maxDeleteCount is the maximum allowed number of messages to delete in one call.
 	defaultMaxDeleteCount = 1024
 
+	// Maximum length of a reaction to a message in bytes.
+	maxReactionLength = 32
+
+	// Maximum number of options a user may choose in a poll at once.
+	maxPollVotes = 32
+
+	// Maximum number of pinned messages per topic.
+	maxPinnedMessages = 10
+
+	// Maximum number of messages one user may schedule for delivery in a topic.
+	maxScheduledMessages = 100
+	// Maximum delay of delivery of a scheduled message.
+	maxScheduleDelay = time.Hour * 24 * 366
+	// How often to check for scheduled messages due for delivery.
+	scheduledDeliveryPeriod = time.Second * 10
+	// Maximum number of scheduled messages to deliver in one pass.
+	scheduledDeliveryBlockSize = 256
+	// How long a scheduled message is claimed for delivery. If the message is not saved
+	// by then, e.g. the server crashed, it's delivered again.
+	scheduledClaimTimeout = time.Minute * 2
+
+	// How often to delete messages which outlived the message TTL of their topics.
+	msgExpiryPeriod = time.Minute
+	// Maximum number of expired messages to delete from one topic in one pass.
+	msgExpiryBlockSize = defaultMaxDeleteCount
+
 	// Base URL path for serving the streaming API.
 	defaultApiPath = "/"
 
@@ -109,6 +139,11 @@ const (
 
 	// Default timeout to drop an unanswered call, seconds.
 	defaultCallEstablishmentTimeout = 30
+
+	// Time to complete the second step of a two-factor login.
+	secondFactorTimeout = time.Minute * 5
+	// Maximum number of attempts to pass the second authentication factor per login.
+	maxSecondFactorAttempts = 5
 )
 
 // Build version number defined by the compiler:
@@ -160,8 +195,16 @@ var globals struct {
 
 	// Salt used for signing API key.
 	apiKeySalt []byte
+	// Cache of stored API keys.
+	apiKeyCache *apiKeyCache
 	// Tag namespaces (prefixes) which are immutable to the client.
 	immutableTagNS map[string]bool
+	// Logical names of initialized second factor authenticators.
+	secondFactors []string
+	// Limiter of failed login attempts, nil if disabled.
+	loginLimiter loginLimiter
+	// Audit log of privileged and security-relevant actions, nil if disabled.
+	audit *auditLog
 	// Tag namespaces which are immutable on User and partially mutable on Topic:
 	// user can only mutate tags he owns.
 	maskedTagNS map[string]bool
@@ -186,6 +229,8 @@ var globals struct {
 	maxFileUploadSize int64
 	// Periodicity of a garbage collector for abandoned media uploads.
 	mediaGcPeriod time.Duration
+	// Signer of download links to exports of user data, nil if exports are disabled.
+	exportLinks *exportLinker
 
 	// Prioritize X-Forwarded-For header as the source of IP address of the client.
 	useXForwardedFor bool
@@ -237,6 +282,21 @@ type accountGcConfig struct {
 	GcMinAccountAge int `json:"gc_min_account_age"`
 }
 
+// Failed login attempts limiter config.
+type loginLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Number of failed attempts per account before it's locked out.
+	MaxFailures int `json:"max_failures"`
+	// Number of failed attempts from one IP address before it's locked out.
+	MaxAddrFailures int `json:"max_ip_failures"`
+	// Duration of the first lockout in seconds. Every subsequent failure doubles it.
+	Lockout int `json:"lockout"`
+	// Maximum duration of the lockout in seconds.
+	MaxLockout int `json:"max_lockout"`
+	// Failed attempts are forgotten after this many seconds without failures.
+	ResetAfter int `json:"reset_after"`
+}
+
 // Large file handler config.
 type mediaConfig struct {
 	// The name of the handler to use for file uploads.
@@ -319,16 +379,19 @@ type configType struct {
 	MsgDeleteAge int `json:"msg_delete_age"`
 
 	// Configs for subsystems
-	Cluster   json.RawMessage             `json:"cluster_config"`
-	Plugin    json.RawMessage             `json:"plugins"`
-	Store     json.RawMessage             `json:"store_config"`
-	Push      json.RawMessage             `json:"push"`
-	TLS       json.RawMessage             `json:"tls"`
-	Auth      map[string]json.RawMessage  `json:"auth_config"`
-	Validator map[string]*validatorConfig `json:"acc_validation"`
-	AccountGC *accountGcConfig            `json:"acc_gc_config"`
-	Media     *mediaConfig                `json:"media"`
-	WebRTC    json.RawMessage             `json:"webrtc"`
+	Cluster    json.RawMessage             `json:"cluster_config"`
+	Plugin     json.RawMessage             `json:"plugins"`
+	Store      json.RawMessage             `json:"store_config"`
+	Push       json.RawMessage             `json:"push"`
+	TLS        json.RawMessage             `json:"tls"`
+	Auth       map[string]json.RawMessage  `json:"auth_config"`
+	Validator  map[string]*validatorConfig `json:"acc_validation"`
+	AccountGC  *accountGcConfig            `json:"acc_gc_config"`
+	LoginLimit *loginLimitConfig           `json:"login_limit"`
+	Audit      *auditConfig                `json:"audit"`
+	Export     *exportConfig               `json:"export"`
+	Media      *mediaConfig                `json:"media"`
+	WebRTC     json.RawMessage             `json:"webrtc"`
 }
 
 func main() {
@@ -450,6 +513,7 @@ func main() {
 
 	// API key signing secret
 	globals.apiKeySalt = config.APIKeySalt
+	globals.apiKeyCache = newAPIKeyCache(apiKeyCacheTTL)
 
 	err = store.InitAuthLogicalNames(config.Auth["logical_names"])
 	if err != nil {
@@ -468,6 +532,9 @@ func main() {
 			if err := authhdl.Init(jsconf, name); err != nil {
 				logs.Err.Fatalln("Failed to init auth scheme", name+":", err)
 			}
+			if _, ok := authhdl.(auth.SecondFactor); ok {
+				globals.secondFactors = append(globals.secondFactors, name)
+			}
 			tags, err := authhdl.RestrictedTags()
 			if err != nil {
 				logs.Err.Fatalln("Failed get restricted tag namespaces (prefixes)", name+":", err)
@@ -481,6 +548,18 @@ func main() {
 		}
 	}
 
+	if config.LoginLimit != nil && config.LoginLimit.Enabled {
+		if globals.loginLimiter, err = newLoginLimiter(config.LoginLimit); err != nil {
+			logs.Err.Fatalln(err)
+		}
+	}
+
+	if config.Audit != nil && config.Audit.Enabled {
+		if globals.audit, err = newAuditLog(config.Audit); err != nil {
+			logs.Err.Fatalln(err)
+		}
+	}
+
 	// Process validators.
 	for name, vconf := range config.Validator {
 		// Check if validator is restrictive. If so, add validator name to the list of restricted tags.
@@ -641,6 +720,22 @@ func main() {
 		}
 	}
 
+	if config.Export != nil && config.Export.Enabled {
+		if config.Media == nil {
+			logs.Err.Fatalln("Export of user data requires a media handler")
+		}
+		if globals.exportLinks, err = newExportLinker(config.Export); err != nil {
+			logs.Err.Fatalln(err)
+		}
+	}
+
+	// Expired logins on devices garbage collection.
+	stopAuthSessionGc := authSessionRunGarbageCollection(authSessionGcPeriod)
+	defer func() {
+		stopAuthSessionGc <- true
+		logs.Info.Println("Stopped login garbage collector")
+	}()
+
 	// Stale unvalidated user account garbage collection.
 	if config.AccountGC != nil && config.AccountGC.Enabled {
 		if config.AccountGC.GcPeriod <= 0 || config.AccountGC.GcBlockSize <= 0 ||
@@ -691,6 +786,20 @@ func main() {
 	// Initialize users cache
 	usersInit()
 
+	// Start delivering scheduled messages.
+	stopScheduled := scheduledMessagesRun(scheduledDeliveryPeriod, scheduledDeliveryBlockSize)
+	defer func() {
+		stopScheduled <- true
+		logs.Info.Println("Stopped delivery of scheduled messages")
+	}()
+
+	// Start deleting expired messages.
+	stopMsgExpiry := msgExpiryRun(msgExpiryPeriod, msgExpiryBlockSize)
+	defer func() {
+		stopMsgExpiry <- true
+		logs.Info.Println("Stopped deletion of expired messages")
+	}()
+
 	// Set up gRPC server, if one is configured
 	if *listenGrpc == "" {
 		*listenGrpc = config.GrpcListen
@@ -774,6 +883,9 @@ func main() {
 		mux.HandleFunc(sspath, serveStatus)
 	}
 
+	// Handle requests of the root user to manage the server.
+	mux.HandleFunc(config.ApiPath+"v0/admin/", serveAdmin)
+
 	// Handle websocket clients.
 	mux.HandleFunc(config.ApiPath+"v0/channels", serveWebSocket)
 	// Handle long polling clients. Enable compression.
@@ -0,0 +1,131 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Deletion of messages which outlived the message TTL of their topic.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// msgExpiryRun runs every 'period' and asks master topics which have expired messages to delete them.
+// Returns channel which can be used to stop the process.
+func msgExpiryRun(period time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the process must wait for it to finish.
+	stop := make(chan bool)
+	go func() {
+		// Add some randomness to the tick period to desynchronize runs on cluster nodes:
+		// 0.75 * period + rand(0, 0.5) * period.
+		period = (period >> 1) + (period >> 2) + time.Duration(rand.Intn(int(period>>1)))
+		ticker := time.Tick(period)
+		for {
+			select {
+			case <-ticker:
+				topics, err := store.Topics.GetWithMsgTTL()
+				if err != nil {
+					logs.Warn.Println("message expiry:", err)
+					continue
+				}
+				for i := range topics {
+					expireMessages(topics[i].Id, topics[i].MsgTTL, blockSize)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// expireMessages checks if the topic has messages older than ttl seconds and if so routes a request
+// to delete them to the master topic.
+func expireMessages(topic string, ttl, limit int) {
+	if globals.cluster.isRemoteTopic(topic) {
+		// Messages are deleted by the cluster node which hosts the master topic.
+		return
+	}
+
+	// Check for expired messages first to avoid loading topics with nothing to delete.
+	ranges, err := store.Messages.GetExpired(topic, types.TimeNow().Add(-time.Duration(ttl)*time.Second), limit)
+	if err != nil {
+		logs.Warn.Println("message expiry: failed to find expired messages", topic, err)
+		return
+	}
+	if len(ranges) == 0 {
+		return
+	}
+
+	// Name of the topic to use if it has to be loaded.
+	original := topic
+	if types.GetTopicCat(topic) == types.TopicCatSlf {
+		original = "slf"
+	}
+
+	msg := &ClientComMessage{
+		Original:  original,
+		RcptTo:    topic,
+		Timestamp: types.TimeNow(),
+		MsgExpiry: true,
+		init:      true,
+	}
+
+	select {
+	case globals.hub.routeCli <- msg:
+	default:
+		logs.Err.Println("message expiry: hub.routeCli queue full", topic)
+	}
+}
+
+// deleteExpiredMessages hard-deletes messages which outlived the message TTL of the topic,
+// then notifies subscribers. The messages are deleted for everyone regardless of msgDeleteAge.
+func (t *Topic) deleteExpiredMessages() {
+	if t.isInactive() || t.msgTTL <= 0 {
+		// TTL may have been cleared after the request was issued.
+		return
+	}
+
+	// Look up expired messages again: the TTL may have changed since the request was issued.
+	ranges, err := store.Messages.GetExpired(t.name, types.TimeNow().Add(-time.Duration(t.msgTTL)*time.Second),
+		msgExpiryBlockSize)
+	if err != nil {
+		logs.Warn.Printf("topic[%s] failed to find expired messages: %v", t.name, err)
+		return
+	}
+	if len(ranges) == 0 {
+		return
+	}
+
+	// Hard-deleting messages also unlinks their attachments, so the files are eventually garbage collected.
+	if err = store.Messages.DeleteList(t.name, t.delID+1, types.ZeroUid, 0, ranges); err != nil {
+		logs.Warn.Printf("topic[%s] failed to delete expired messages: %v", t.name, err)
+		return
+	}
+
+	// Increment Delete transaction ID
+	t.delID++
+	t.unpinDeleted(ranges)
+	dr := rangeDeserialize(ranges)
+	for uid, pud := range t.perUser {
+		pud.delID = t.delID
+		t.perUser[uid] = pud
+
+		mode := pud.modeGiven & pud.modeWant
+		if mode.IsReader() {
+			// Update unread counters of users who may have had these messages as unread.
+			if unreadDeleted := calculateUnreadInRanges(pud.readID, t.lastID, ranges); unreadDeleted > 0 {
+				usersUpdateUnread(uid, -unreadDeleted, true)
+			}
+		}
+
+		// Let the user's sessions know that the messages are gone.
+		t.presPubMessageDelete(uid, mode, t.delID, dr, "")
+	}
+}
@@ -343,7 +343,7 @@ func pluginGenerateClientReq(sess *Session, msg *ClientComMessage) *pbx.ClientRe
 			UserId:     sess.uid.UserId(),
 			AuthLevel:  pbx.AuthLevel(sess.authLvl),
 			UserAgent:  sess.userAgent,
-			RemoteAddr: sess.remoteAddr,
+			RemoteAddr: sess.getRemoteAddr(),
 			DeviceId:   sess.deviceID,
 			Language:   sess.lang,
 		},
@@ -474,6 +474,16 @@ func pluginFind(user types.Uid, query string) (string, []types.Subscription, err
 }
 
 func pluginAccount(user *types.User, action int) {
+	pluginAccountEvent(user, action, time.Time{})
+}
+
+// pluginAccountLocked notifies plugins that the account is temporarily locked out after too many
+// failed login attempts.
+func pluginAccountLocked(user *types.User, until time.Time) {
+	pluginAccountEvent(user, plgActUpd, until)
+}
+
+func pluginAccountEvent(user *types.User, action int, lockedUntil time.Time) {
 	if globals.plugins == nil {
 		return
 	}
@@ -497,6 +507,9 @@ func pluginAccount(user *types.User, action int) {
 				Public: interfaceToBytes(user.Public),
 				Tags:   user.Tags,
 			}
+			if !lockedUntil.IsZero() {
+				event.LockedUntil = lockedUntil.UnixMilli()
+			}
 		}
 
 		var ctx context.Context
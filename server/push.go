@@ -41,6 +41,7 @@ func (t *Topic) pushForData(fromUid types.Uid, data *MsgServerData, msgMarkedAsR
 			SeqId:       data.SeqId,
 			ContentType: contentType,
 			Content:     data.Content,
+			Thread:      data.Thread,
 		},
 	}
 	if webrtc, found := data.Head["webrtc"].(string); found {
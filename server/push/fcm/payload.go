@@ -74,6 +74,9 @@ func payloadToData(pl *push.Payload) (map[string]string, error) {
 			data["silent"] = "true"
 			data["replace"] = pl.Replace
 		}
+		if pl.Thread > 0 {
+			data["thread"] = strconv.Itoa(pl.Thread)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -85,6 +85,8 @@ type Payload struct {
 	AudioOnly bool `json:"aonly,omitempty"`
 	// Seq id the message is supposed to replace.
 	Replace string `json:"replace,omitempty"`
+	// Seq id of the message this message is a reply to.
+	Thread int `json:"thread,omitempty"`
 
 	// Subscription change notification.
 
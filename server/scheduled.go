@@ -0,0 +1,242 @@
+/******************************************************************************
+ *
+ *  Description :
+ *    Messages scheduled for delivery at a later time.
+ *
+ *****************************************************************************/
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/logs"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// scheduleMessage saves a {pub} for delivery at msg.Pub.DeliverAt and replies with the ID of the scheduled message.
+// The message is validated again when it's delivered.
+func (t *Topic) scheduleMessage(msg *ClientComMessage, asUid types.Uid, isCall bool) error {
+	now := types.TimeNow()
+
+	if isCall {
+		// Calls cannot be scheduled.
+		msg.sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("call cannot be scheduled")
+	}
+
+	if msg.Pub.Forward != nil {
+		// Forwarded messages cannot be scheduled: the original may be gone by the time of delivery.
+		msg.sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("forwarded message cannot be scheduled")
+	}
+
+	if t.cat == types.TopicCatSys || (msg.sess != nil && msg.sess.uid != asUid) {
+		// Scheduling messages on behalf of another user or to 'sys' topic is not supported.
+		msg.sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return types.ErrPermissionDenied
+	}
+
+	if pud := t.perUser[asUid]; !(pud.modeGiven & pud.modeWant).IsWriter() {
+		msg.sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return types.ErrPermissionDenied
+	}
+
+	// Out-of-band attachments are garbage collected if they are not linked to a message soon after the upload.
+	if (msg.Extra != nil && len(msg.Extra.Attachments) > 0) || msg.Pub.DeliverAt.Sub(now) > maxScheduleDelay {
+		msg.sess.queueOut(ErrPolicyReply(msg, now))
+		return types.ErrPolicy
+	}
+
+	if scheduled, err := store.Messages.GetScheduled(t.name, asUid,
+		&types.QueryOpt{Limit: maxScheduledMessages}); err != nil {
+		msg.sess.queueOut(ErrUnknownReply(msg, now))
+		return err
+	} else if len(scheduled) >= maxScheduledMessages {
+		msg.sess.queueOut(ErrPolicyReply(msg, now))
+		return types.ErrPolicy
+	}
+
+	smsg := &types.ScheduledMessage{
+		DeliverAt: msg.Pub.DeliverAt.UTC().Round(time.Millisecond),
+		Topic:     t.name,
+		From:      asUid.String(),
+		Parent:    msg.Pub.Thread,
+		Head:      msg.Pub.Head,
+		Content:   msg.Pub.Content,
+	}
+	if err := store.Messages.Schedule(smsg); err != nil {
+		msg.sess.queueOut(ErrUnknownReply(msg, now))
+		return err
+	}
+
+	reply := NoErrAccepted(msg.Id, t.original(asUid), msg.Timestamp)
+	reply.Ctrl.Params = map[string]any{"sched": smsg.Id, "deliver": smsg.DeliverAt}
+	msg.sess.queueOut(reply)
+
+	return nil
+}
+
+// replyGetScheduled is a response to a get[what=scheduled] request: send the list of messages scheduled
+// by the user in the topic as {meta}.
+func (t *Topic) replyGetScheduled(sess *Session, asUid types.Uid, req *MsgGetOpts, msg *ClientComMessage) error {
+	now := types.TimeNow()
+	toriginal := t.original(asUid)
+
+	if req != nil && (req.IfModifiedSince != nil || req.User != "" || req.Topic != "") {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("invalid MsgGetOpts query")
+	}
+
+	smsgs, err := store.Messages.GetScheduled(t.name, asUid, msgOpts2storeOpts(req))
+	if err != nil {
+		sess.queueOut(ErrUnknownReply(msg, now))
+		return err
+	}
+
+	if len(smsgs) > 0 {
+		scheduled := make([]MsgScheduled, 0, len(smsgs))
+		for i := range smsgs {
+			smsg := &smsgs[i]
+			scheduled = append(scheduled, MsgScheduled{
+				Id:        smsg.Id,
+				DeliverAt: smsg.DeliverAt,
+				Timestamp: smsg.CreatedAt,
+				Thread:    smsg.Parent,
+				Head:      smsg.Head,
+				Content:   smsg.Content,
+			})
+		}
+		sess.queueOut(&ServerComMessage{
+			Meta: &MsgServerMeta{
+				Id:        msg.Id,
+				Topic:     toriginal,
+				Scheduled: scheduled,
+				Timestamp: &now,
+			},
+		})
+		return nil
+	}
+
+	sess.queueOut(NoContentParams(msg.Id, toriginal, now, msg.Timestamp, map[string]string{"what": "scheduled"}))
+
+	return nil
+}
+
+// replyDelScheduled cancels delivery of a message scheduled by the user.
+func (t *Topic) replyDelScheduled(sess *Session, asUid types.Uid, msg *ClientComMessage) error {
+	now := types.TimeNow()
+
+	if msg.Del.Sched == "" {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("missing ID of the scheduled message")
+	}
+
+	if err := store.Messages.DeleteScheduled(t.name, asUid, msg.Del.Sched); err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, t.original(asUid), now, msg.Timestamp, nil))
+		return err
+	}
+
+	sess.queueOut(NoErrReply(msg, now))
+
+	return nil
+}
+
+// unscheduleMessage deletes the scheduled message after it was saved or rejected by the topic.
+func (t *Topic) unscheduleMessage(id string) {
+	if err := store.Messages.DeleteScheduled(t.name, types.ZeroUid, id); err != nil && err != types.ErrNotFound {
+		// The message will be delivered again.
+		logs.Warn.Printf("topic[%s]: failed to delete delivered scheduled message %s: %v", t.name, id, err)
+	}
+}
+
+// scheduledMessagesRun runs every 'period' and delivers up to 'blockSize' scheduled messages which are due.
+// Returns channel which can be used to stop the process.
+func scheduledMessagesRun(period time.Duration, blockSize int) chan<- bool {
+	// Unbuffered stop channel. Whomever stops the delivery must wait for the process to finish.
+	stop := make(chan bool)
+	go func() {
+		// Add some randomness to the tick period to desynchronize runs on cluster nodes:
+		// 0.75 * period + rand(0, 0.5) * period.
+		period = (period >> 1) + (period >> 2) + time.Duration(rand.Intn(int(period>>1)))
+		ticker := time.Tick(period)
+		for {
+			select {
+			case <-ticker:
+				smsgs, err := store.Messages.GetDueScheduled(types.TimeNow(), blockSize)
+				if err != nil {
+					logs.Warn.Println("scheduled messages:", err)
+					continue
+				}
+				for i := range smsgs {
+					deliverScheduledMessage(&smsgs[i])
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// deliverScheduledMessage claims the scheduled message and routes it to the master topic as a {pub}
+// from the user who scheduled it. The master topic deletes the scheduled message once it's saved.
+// If the message is lost on the way, it's delivered again when the claim expires.
+func deliverScheduledMessage(smsg *types.ScheduledMessage) {
+	// The message may be claimed by another cluster node.
+	now := types.TimeNow()
+	if err := store.Messages.ClaimScheduled(smsg.Id, now, now.Add(scheduledClaimTimeout)); err != nil {
+		if err != types.ErrNotFound {
+			logs.Warn.Println("scheduled messages: failed to claim", smsg.Id, err)
+		}
+		return
+	}
+
+	asUid := types.ParseUid(smsg.From)
+	// Topic name as seen by the sender.
+	original := smsg.Topic
+	switch types.GetTopicCat(smsg.Topic) {
+	case types.TopicCatP2P:
+		uid1, uid2, _ := types.ParseP2P(smsg.Topic)
+		if uid1 == asUid {
+			original = uid2.UserId()
+		} else {
+			original = uid1.UserId()
+		}
+	case types.TopicCatSlf:
+		original = "slf"
+	}
+
+	msg := &ClientComMessage{
+		Pub: &MsgClientPub{
+			Topic:   original,
+			Head:    smsg.Head,
+			Content: smsg.Content,
+			Thread:  smsg.Parent,
+		},
+		Original:  original,
+		RcptTo:    smsg.Topic,
+		AsUser:    asUid.UserId(),
+		AuthLvl:   int(auth.LevelAuth),
+		Timestamp: types.TimeNow(),
+		Scheduled: smsg.Id,
+		init:      true,
+	}
+
+	if globals.cluster.isRemoteTopic(smsg.Topic) {
+		if err := globals.cluster.routeToTopicMaster(ProxyReqBroadcast, msg, smsg.Topic, nil); err != nil {
+			logs.Warn.Println("scheduled messages: failed to route to master", smsg.Id, smsg.Topic, err)
+		}
+		return
+	}
+
+	select {
+	case globals.hub.routeCli <- msg:
+	default:
+		logs.Err.Println("scheduled messages: hub.routeCli queue full", smsg.Id, smsg.Topic)
+	}
+}
@@ -57,6 +57,22 @@ const (
 	MULTIPLEX
 )
 
+// pendingLogin is a login waiting for the second authentication factor.
+type pendingLogin struct {
+	// Authentication record produced by the first step.
+	rec *auth.Rec
+	// Logical name of the second factor authenticator.
+	scheme string
+	// Deadline for passing the second factor.
+	expires time.Time
+	// Number of failed attempts.
+	attempts int
+	// Authenticator of the first factor, the account and the login for counting failed attempts.
+	handler auth.AuthHandler
+	account string
+	login   string
+}
+
 // Session represents a single WS connection or a long polling session. A user may have multiple
 // sessions.
 type Session struct {
@@ -107,6 +123,15 @@ type Session struct {
 	// Authentication level - NONE (unset), ANON, AUTH, ROOT.
 	authLvl auth.Level
 
+	// ID of the login on the device which authenticated the session.
+	authSession types.Uid
+
+	// Login which passed the first authentication step and waits for the second factor.
+	mfa *pendingLogin
+
+	// API key the session was created with. Nil for sessions without a key, e.g. gRPC.
+	apiKey *types.APIKey
+
 	// Time when the long polling session was last refreshed
 	lastTouched time.Time
 
@@ -154,6 +179,10 @@ type Session struct {
 	// Needed for long polling and grpc.
 	lock sync.Mutex
 
+	// Guards uid, authLvl, authSession, userAgent, platf and remoteAddr which are written by the session
+	// and read by other goroutines, e.g. by the admin API.
+	infoLock sync.RWMutex
+
 	// Field used only in cluster mode by topic master node.
 
 	// Type of proxy to master request being handled.
@@ -233,6 +262,35 @@ func (s *Session) unsubAll() {
 	}
 }
 
+// authInfo returns the user ID, the authentication level of the session and the ID of the login on the device
+// which authenticated it. Safe to call from any goroutine.
+func (s *Session) authInfo() (types.Uid, auth.Level, types.Uid) {
+	s.infoLock.RLock()
+	defer s.infoLock.RUnlock()
+	return s.uid, s.authLvl, s.authSession
+}
+
+// setUid changes the user ID of the session, zero ID logs the session out.
+func (s *Session) setUid(uid types.Uid) {
+	s.infoLock.Lock()
+	s.uid = uid
+	s.infoLock.Unlock()
+}
+
+// getRemoteAddr returns the IP address of the client.
+func (s *Session) getRemoteAddr() string {
+	s.infoLock.RLock()
+	defer s.infoLock.RUnlock()
+	return s.remoteAddr
+}
+
+// setRemoteAddr changes the IP address of the client.
+func (s *Session) setRemoteAddr(addr string) {
+	s.infoLock.Lock()
+	s.remoteAddr = addr
+	s.infoLock.Unlock()
+}
+
 // Indicates whether this session is a local interface for a remote proxy topic.
 // It multiplexes multiple sessions.
 func (s *Session) isMultiplex() bool {
@@ -477,6 +535,8 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 		return
 	}
 
+	// The user on whose behalf the root user is acting.
+	var obo types.Uid
 	if msg.Extra == nil || (msg.Extra.AsUser == "" && msg.Extra.AuthLevel == "") {
 		// Use current user's ID and auth level.
 		msg.AsUser = s.uid.UserId()
@@ -494,6 +554,7 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 	} else {
 		// Use provided msg.Extra.AsUser
 		msg.AsUser = msg.Extra.AsUser
+		obo = fromUid
 
 		// Assign auth level, if one is provided. Ignore invalid strings.
 		if authLvl := auth.ParseAuthLevel(msg.Extra.AuthLevel); authLvl == auth.LevelNone {
@@ -508,6 +569,7 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 
 	var handler func(*ClientComMessage)
 	var uaRefresh bool
+	var what string
 
 	// Check if s.ver is defined
 	checkVers := func(handler func(*ClientComMessage)) func(*ClientComMessage) {
@@ -535,52 +597,62 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 
 	switch {
 	case msg.Pub != nil:
+		what = "pub"
 		handler = checkVers(checkUser(s.publish))
 		msg.Id = msg.Pub.Id
 		msg.Original = msg.Pub.Topic
 		uaRefresh = true
 
 	case msg.Sub != nil:
+		what = "sub"
 		handler = checkVers(checkUser(s.subscribe))
 		msg.Id = msg.Sub.Id
 		msg.Original = msg.Sub.Topic
 		uaRefresh = true
 
 	case msg.Leave != nil:
+		what = "leave"
 		handler = checkVers(checkUser(s.leave))
 		msg.Id = msg.Leave.Id
 		msg.Original = msg.Leave.Topic
 
 	case msg.Hi != nil:
+		what = "hi"
 		handler = s.hello
 		msg.Id = msg.Hi.Id
 
 	case msg.Login != nil:
+		what = "login"
 		handler = checkVers(s.login)
 		msg.Id = msg.Login.Id
 
 	case msg.Get != nil:
+		what = "get"
 		handler = checkVers(checkUser(s.get))
 		msg.Id = msg.Get.Id
 		msg.Original = msg.Get.Topic
 		uaRefresh = true
 
 	case msg.Set != nil:
+		what = "set"
 		handler = checkVers(checkUser(s.set))
 		msg.Id = msg.Set.Id
 		msg.Original = msg.Set.Topic
 		uaRefresh = true
 
 	case msg.Del != nil:
+		what = "del"
 		handler = checkVers(checkUser(s.del))
 		msg.Id = msg.Del.Id
 		msg.Original = msg.Del.Topic
 
 	case msg.Acc != nil:
+		what = "acc"
 		handler = checkVers(s.acc)
 		msg.Id = msg.Acc.Id
 
 	case msg.Note != nil:
+		what = "note"
 		// If user is not authenticated or version not set the {note} is silently ignored.
 		handler = s.note
 		msg.Original = msg.Note.Topic
@@ -593,6 +665,11 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 		return
 	}
 
+	if resp := s.apiKeyCheck(msg, what, msg.Timestamp); resp != nil {
+		s.queueOut(resp)
+		return
+	}
+
 	if globals.cluster.isPartitioned() {
 		// The cluster is partitioned due to network or other failure and this node is a part of the smaller partition.
 		// In order to avoid data inconsistency across the cluster we must reject all requests.
@@ -600,6 +677,11 @@ func (s *Session) dispatch(msg *ClientComMessage) {
 		return
 	}
 
+	if !obo.IsZero() {
+		auditRecord(auditObo, s.uid, obo, msg.Original, s.getRemoteAddr(),
+			map[string]any{"what": what, "authlvl": auth.Level(msg.AuthLvl).String()})
+	}
+
 	msg.sess = s
 	msg.init = true
 	handler(msg)
@@ -783,11 +865,14 @@ func (s *Session) hello(msg *ClientComMessage) {
 
 		// Set ua & platform in the beginning of the session.
 		// Don't change them later.
-		s.userAgent = msg.Hi.UserAgent
-		s.platf = msg.Hi.Platform
-		if s.platf == "" {
-			s.platf = platformFromUA(msg.Hi.UserAgent)
+		platf := msg.Hi.Platform
+		if platf == "" {
+			platf = platformFromUA(msg.Hi.UserAgent)
 		}
+		s.infoLock.Lock()
+		s.userAgent = msg.Hi.UserAgent
+		s.platf = platf
+		s.infoLock.Unlock()
 		// This is a background session. Start a timer.
 		if msg.Hi.Background {
 			s.bkgTimer.Reset(deferredNotificationsTimeout)
@@ -888,7 +973,7 @@ func (s *Session) acc(msg *ClientComMessage) {
 		}
 
 		var err error
-		rec, _, err = authHdl.Authenticate(msg.Acc.TmpSecret, s.remoteAddr)
+		rec, _, err = authHdl.Authenticate(msg.Acc.TmpSecret, s.getRemoteAddr())
 		if err != nil {
 			s.queueOut(decodeStoreError(err, msg.Acc.Id, msg.Timestamp,
 				map[string]any{"what": "auth"}))
@@ -933,8 +1018,50 @@ func (s *Session) login(msg *ClientComMessage) {
 		return
 	}
 
-	rec, challenge, err := handler.Authenticate(msg.Login.Secret, s.remoteAddr)
+	if sf, ok := handler.(auth.SecondFactor); ok {
+		// The second step of a two-factor login.
+		s.loginSecondFactor(msg, sf)
+		return
+	}
+
+	// The address may be changed by the long polling handler.
+	remoteAddr := s.getRemoteAddr()
+	var account, login, addr string
+	if globals.loginLimiter != nil {
+		account, login = loginLimitAccount(handler, msg.Login.Secret)
+		addr = loginLimitAddr(remoteAddr)
+		until, err := globals.loginLimiter.Check(account, addr)
+		if err != nil {
+			logs.Warn.Println("s.login: failed to check login limits", err, s.sid)
+			s.queueOut(ErrUnknown(msg.Id, "", msg.Timestamp))
+			return
+		}
+		if !until.IsZero() {
+			s.queueOut(ErrAuthLocked(msg.Id, "", msg.Timestamp, msg.Timestamp, time.Until(until)))
+			return
+		}
+	}
+
+	rec, challenge, err := handler.Authenticate(msg.Login.Secret, remoteAddr)
 	if err != nil {
+		if err == types.ErrFailed {
+			s.loginFailed(handler, account, login, addr)
+			if globals.audit != nil {
+				if ok, suppressed := globals.audit.allowLoginFailed(remoteAddr); ok {
+					if login == "" {
+						_, login = loginLimitAccount(handler, msg.Login.Secret)
+					}
+					params := map[string]any{"scheme": handler.GetRealName()}
+					if login != "" {
+						params["login"] = login
+					}
+					if suppressed > 0 {
+						params["repeated"] = suppressed
+					}
+					auditRecord(auditLoginFailed, types.ZeroUid, types.ZeroUid, "", remoteAddr, params)
+				}
+			}
+		}
 		resp := decodeStoreError(err, msg.Id, msg.Timestamp, nil)
 		if resp.Ctrl.Code >= 500 {
 			// Log internal errors
@@ -944,6 +1071,12 @@ func (s *Session) login(msg *ClientComMessage) {
 		return
 	}
 
+	if rec == nil && challenge != nil {
+		// The user is not identified until the client responds to the challenge.
+		s.queueOut(InfoChallenge(msg.Id, msg.Timestamp, challenge))
+		return
+	}
+
 	// If authenticator did not check user state, it returns state "undef". If so, check user state here.
 	if rec.State == types.StateUndefined {
 		rec.State, err = userGetState(rec.Uid)
@@ -976,8 +1109,96 @@ func (s *Session) login(msg *ClientComMessage) {
 	if err != nil {
 		logs.Warn.Println("s.login: failed to validate credentials:", err, s.sid)
 		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
+		return
+	}
+
+	// A new login replaces the one waiting for the second factor.
+	s.mfa = nil
+	reply := s.onLogin(msg.Id, msg.Timestamp, rec, missing)
+	if s.mfa != nil {
+		// Failures of the second factor are counted against the same account.
+		s.mfa.handler, s.mfa.account, s.mfa.login = handler, account, login
 	} else {
-		s.queueOut(s.onLogin(msg.Id, msg.Timestamp, rec, missing))
+		s.loginLimitReset(account)
+	}
+	s.queueOut(reply)
+}
+
+// loginSecondFactor completes the login which is waiting for the second authentication factor.
+func (s *Session) loginSecondFactor(msg *ClientComMessage, sf auth.SecondFactor) {
+	pending := s.mfa
+	if pending == nil || !strings.EqualFold(pending.scheme, msg.Login.Scheme) || pending.expires.Before(time.Now()) {
+		// The first step was not completed or has expired.
+		s.mfa = nil
+		s.queueOut(ErrAuthFailed(msg.Id, "", msg.Timestamp, msg.Timestamp))
+		return
+	}
+
+	remoteAddr := s.getRemoteAddr()
+	var addr string
+	if globals.loginLimiter != nil {
+		addr = loginLimitAddr(remoteAddr)
+		until, err := globals.loginLimiter.Check(pending.account, addr)
+		if err != nil {
+			logs.Warn.Println("s.login: failed to check login limits", err, s.sid)
+			s.queueOut(ErrUnknown(msg.Id, "", msg.Timestamp))
+			return
+		}
+		if !until.IsZero() {
+			s.queueOut(ErrAuthLocked(msg.Id, "", msg.Timestamp, msg.Timestamp, time.Until(until)))
+			return
+		}
+	}
+
+	if err := sf.Verify(pending.rec.Uid, msg.Login.Secret, remoteAddr); err != nil {
+		if err == types.ErrFailed {
+			s.loginFailed(pending.handler, pending.account, pending.login, addr)
+			auditRecord(auditLoginFailed, types.ZeroUid, pending.rec.Uid, "", remoteAddr,
+				map[string]any{"scheme": pending.scheme})
+		}
+		pending.attempts++
+		if pending.attempts >= maxSecondFactorAttempts {
+			// Too many failures, the user must start over.
+			s.mfa = nil
+		}
+		resp := decodeStoreError(err, msg.Id, msg.Timestamp, nil)
+		if resp.Ctrl.Code >= 500 {
+			logs.Warn.Println("s.login: second factor", err, s.sid)
+		}
+		s.queueOut(resp)
+		return
+	}
+
+	s.mfa = nil
+	pending.rec.Features |= auth.FeatureSecondFactor
+	reply := s.onLogin(msg.Id, msg.Timestamp, pending.rec, nil)
+	s.loginLimitReset(pending.account)
+	s.queueOut(reply)
+}
+
+// loginFailed records a failed login attempt with the login limiter, if enabled.
+func (s *Session) loginFailed(handler auth.AuthHandler, account, login, addr string) {
+	if globals.loginLimiter == nil {
+		return
+	}
+	accountUntil, _, err := globals.loginLimiter.Fail(account, addr)
+	if err != nil {
+		logs.Warn.Println("s.login: failed to record failed attempt", err, s.sid)
+		return
+	}
+	if !accountUntil.IsZero() {
+		logs.Info.Println("s.login: account locked out until", accountUntil, s.sid)
+		loginLimitLocked(handler, login, accountUntil)
+	}
+}
+
+// loginLimitReset clears failed login attempts of the account once the session is authenticated.
+func (s *Session) loginLimitReset(account string) {
+	if account == "" || s.uid.IsZero() {
+		return
+	}
+	if err := globals.loginLimiter.Reset(account); err != nil {
+		logs.Warn.Println("s.login: failed to reset login limits", err, s.sid)
 	}
 }
 
@@ -1043,6 +1264,8 @@ func (s *Session) authSecretReset(params []byte) error {
 func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, missing []string) *ServerComMessage {
 	var reply *ServerComMessage
 	var params map[string]any
+	// The session becomes authenticated by this login.
+	var authenticate, newSession bool
 
 	features := rec.Features
 
@@ -1050,6 +1273,24 @@ func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, miss
 		"user":    rec.Uid.UserId(),
 		"authlvl": rec.AuthLevel.String(),
 	}
+	if len(missing) == 0 && features&(auth.FeatureNoLogin|auth.FeatureSecondFactor|auth.FeaturePasskey) == 0 {
+		// Check if the user has enabled the second authentication factor.
+		// Passkeys verify the user on the device, they are multi-factor already.
+		scheme, err := secondFactorScheme(rec.Uid)
+		if err != nil {
+			logs.Warn.Println("s.login: failed to check second factor", err, s.sid)
+			return decodeStoreError(err, msgID, timestamp, nil)
+		}
+		if scheme != "" {
+			// The session is authenticated after the user passes the second factor.
+			s.mfa = &pendingLogin{rec: rec, scheme: scheme, expires: time.Now().Add(secondFactorTimeout)}
+			params["scheme"] = scheme
+			reply = InfoSecondFactor(msgID, timestamp)
+			reply.Ctrl.Params = params
+			return reply
+		}
+	}
+
 	if len(missing) > 0 {
 		// Some credentials are not validated yet. Respond with request for validation.
 		reply = InfoValidateCredentials(msgID, timestamp)
@@ -1062,11 +1303,15 @@ func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, miss
 
 		// Check if the token is suitable for session authentication.
 		if features&auth.FeatureNoLogin == 0 {
-			// Authenticate the session.
-			s.uid = rec.Uid
-			s.authLvl = rec.AuthLevel
+			authenticate = true
 			// Reset expiration time.
 			rec.Lifetime = 0
+			if rec.SessionId.IsZero() {
+				// Login with primary credentials or with a token issued before sessions were recorded:
+				// start a new session on the device.
+				newSession = true
+				rec.SessionId = store.Store.GetUid()
+			}
 		}
 		features |= auth.FeatureValidated
 
@@ -1086,12 +1331,54 @@ func (s *Session) onLogin(msgID string, timestamp time.Time, rec *auth.Rec, miss
 	// GenSecret fails only if tokenLifetime is < 0. It can't be < 0 here,
 	// otherwise login would have failed earlier.
 	rec.Features = features
-	params["token"], params["expires"], _ = store.Store.GetLogicalAuthHandler("token").GenSecret(rec)
+	token, expires, _ := store.Store.GetLogicalAuthHandler("token").GenSecret(rec)
+	params["token"], params["expires"] = token, expires
+
+	if authenticate {
+		if err := s.saveAuthSession(rec, expires, newSession); err != nil {
+			logs.Warn.Println("s.login: failed to save session", err, s.sid)
+			return decodeStoreError(err, msgID, timestamp, nil)
+		}
+		// Authenticate the session.
+		s.infoLock.Lock()
+		s.uid = rec.Uid
+		s.authLvl = rec.AuthLevel
+		s.authSession = rec.SessionId
+		s.infoLock.Unlock()
+
+		if newSession {
+			auditRecord(auditLogin, rec.Uid, rec.Uid, "", s.getRemoteAddr(),
+				map[string]any{"authlvl": rec.AuthLevel.String(), "session": rec.SessionId.String()})
+		}
+	}
 
 	reply.Ctrl.Params = params
 	return reply
 }
 
+// saveAuthSession records the login on the device or refreshes the record of an existing login.
+func (s *Session) saveAuthSession(rec *auth.Rec, expires time.Time, isNew bool) error {
+	sess := &types.AuthSession{
+		ObjHeader:  types.ObjHeader{Id: rec.SessionId.String()},
+		User:       rec.Uid.String(),
+		Expires:    expires,
+		DeviceId:   s.deviceID,
+		Platform:   s.platf,
+		UserAgent:  s.userAgent,
+		RemoteAddr: s.getRemoteAddr(),
+	}
+	if isNew {
+		return store.AuthSessions.Create(sess)
+	}
+
+	err := store.AuthSessions.Update(sess)
+	if err == types.ErrNotFound {
+		// The session was revoked after the token was checked.
+		err = types.ErrFailed
+	}
+	return err
+}
+
 func (s *Session) get(msg *ClientComMessage) {
 	// Expand topic name.
 	var resp *ServerComMessage
@@ -1103,6 +1390,15 @@ func (s *Session) get(msg *ClientComMessage) {
 
 	msg.MetaWhat = parseMsgClientMeta(msg.Get.What)
 
+	if msg.MetaWhat&constMsgMetaSessions != 0 {
+		// Logins are not stored in the topic, reply here.
+		replyGetSessions(s, msg)
+		msg.MetaWhat &^= constMsgMetaSessions
+		if msg.MetaWhat == 0 {
+			return
+		}
+	}
+
 	sub := s.getSub(msg.RcptTo)
 	if msg.MetaWhat == 0 {
 		s.queueOut(ErrMalformedReply(msg, msg.Timestamp))
@@ -1154,6 +1450,12 @@ func (s *Session) set(msg *ClientComMessage) {
 	if msg.Set.Aux != nil {
 		msg.MetaWhat |= constMsgMetaAux
 	}
+	if msg.Set.Msg != nil {
+		msg.MetaWhat |= constMsgMetaMsg
+	}
+	if msg.Set.Pin != nil {
+		msg.MetaWhat |= constMsgMetaPin
+	}
 
 	if msg.MetaWhat == 0 {
 		s.queueOut(ErrMalformedReply(msg, msg.Timestamp))
@@ -1166,8 +1468,8 @@ func (s *Session) set(msg *ClientComMessage) {
 			s.queueOut(ErrServiceUnavailableReply(msg, msg.Timestamp))
 			logs.Err.Println("s.set: sub.meta channel full, topic ", msg.RcptTo, s.sid)
 		}
-	} else if msg.MetaWhat&(constMsgMetaTags|constMsgMetaCred|constMsgMetaAux) != 0 {
-		logs.Warn.Println("s.set: setting tags/creds/aux is allowed for subscribed topics only", msg.MetaWhat)
+	} else if msg.MetaWhat&(constMsgMetaTags|constMsgMetaCred|constMsgMetaAux|constMsgMetaMsg|constMsgMetaPin) != 0 {
+		logs.Warn.Println("s.set: setting tags/creds/aux/msg/pin is allowed for subscribed topics only", msg.MetaWhat)
 		s.queueOut(ErrPermissionDeniedReply(msg, msg.Timestamp))
 	} else {
 		// Desc.Private and Sub updates are possible without the subscription.
@@ -1190,6 +1492,12 @@ func (s *Session) del(msg *ClientComMessage) {
 		return
 	}
 
+	// Revoke login(s)
+	if msg.MetaWhat == constMsgDelSession {
+		replyDelSession(s, msg)
+		return
+	}
+
 	// Delete something other than user: topic, subscription, message(s)
 
 	// Expand topic name and validate request.
@@ -1273,6 +1581,14 @@ func (s *Session) note(msg *ClientComMessage) {
 		if msg.Note.SeqId <= 0 {
 			return
 		}
+	case "react":
+		if msg.Note.SeqId <= 0 || len(msg.Note.Reaction) > maxReactionLength {
+			return
+		}
+	case "vote":
+		if msg.Note.SeqId <= 0 || len(msg.Note.Vote) > maxPollVotes {
+			return
+		}
 	default:
 		return
 	}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"container/list"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -144,12 +148,16 @@ func TestDispatchUnsupportedVersion(t *testing.T) {
 func TestDispatchLogin(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
 	aa := mock_auth.NewMockAuthHandler(ctrl)
 
 	uid := types.Uid(1)
+	sessionId := types.Uid(100)
 	store.Store = ss
+	store.AuthSessions = as
 	defer func() {
 		store.Store = nil
+		store.AuthSessions = nil
 		ctrl.Finish()
 	}()
 
@@ -162,16 +170,26 @@ func TestDispatchLogin(t *testing.T) {
 	}
 	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
 	aa.EXPECT().Authenticate([]byte(secret), gomock.Any()).Return(authRec, nil, nil)
+	// New login on the device.
+	ss.EXPECT().GetUid().Return(sessionId)
 	// Token generation.
 	ss.EXPECT().GetLogicalAuthHandler("token").Return(aa)
 	token := "<==auth-token==>"
 	expires, _ := time.Parse(time.RFC822, "01 Jan 50 00:00 UTC")
 	aa.EXPECT().GenSecret(authRec).Return([]byte(token), expires, nil)
+	as.EXPECT().Create(gomock.Any()).DoAndReturn(func(sess *types.AuthSession) error {
+		if sess.Id != sessionId.String() || sess.User != uid.String() || sess.UserAgent != "TinodeWeb/1.0" ||
+			!sess.Expires.Equal(expires) {
+			t.Errorf("Session record: unexpected %+v", sess)
+		}
+		return nil
+	})
 
 	s := &Session{
-		send:    make(chan any, 10),
-		authLvl: auth.LevelAuth,
-		ver:     16,
+		send:      make(chan any, 10),
+		authLvl:   auth.LevelAuth,
+		ver:       16,
+		userAgent: "TinodeWeb/1.0",
 	}
 	wg := sync.WaitGroup{}
 	r := responses{}
@@ -217,6 +235,546 @@ func TestDispatchLogin(t *testing.T) {
 	} else {
 		t.Error("Response must contain a ctrl message.")
 	}
+	if s.authSession != sessionId || authRec.SessionId != sessionId {
+		t.Errorf("Session ID: expected '%s', found '%s'.", sessionId, s.authSession)
+	}
+}
+
+// secondFactorAuth is an auth handler which is also a second factor.
+type secondFactorAuth struct {
+	*mock_auth.MockAuthHandler
+	*mock_auth.MockSecondFactor
+}
+
+func TestDispatchLoginSecondFactor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+	sf := secondFactorAuth{mock_auth.NewMockAuthHandler(ctrl), mock_auth.NewMockSecondFactor(ctrl)}
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+
+	uid := types.Uid(1)
+	store.Store = ss
+	store.AuthSessions = as
+	globals.secondFactors = []string{"totp"}
+	defer func() {
+		store.Store = nil
+		store.AuthSessions = nil
+		globals.secondFactors = nil
+		ctrl.Finish()
+	}()
+
+	secret := "<==auth-secret==>"
+	authRec := &auth.Rec{
+		Uid:       uid,
+		AuthLevel: auth.LevelAuth,
+		State:     types.StateOK,
+	}
+	// First step.
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
+	aa.EXPECT().Authenticate([]byte(secret), gomock.Any()).Return(authRec, nil, nil)
+	ss.EXPECT().GetLogicalAuthHandler("totp").Return(sf).Times(3)
+	sf.MockSecondFactor.EXPECT().IsEnrolled(uid).Return(true, nil)
+	// Second step: invalid code, then valid code.
+	gomock.InOrder(
+		sf.MockSecondFactor.EXPECT().Verify(uid, []byte("000000"), gomock.Any()).Return(types.ErrFailed),
+		sf.MockSecondFactor.EXPECT().Verify(uid, []byte("123456"), gomock.Any()).Return(nil),
+	)
+	// Token generation.
+	ss.EXPECT().GetLogicalAuthHandler("token").Return(aa)
+	aa.EXPECT().GenSecret(gomock.Any()).DoAndReturn(func(rec *auth.Rec) ([]byte, time.Time, error) {
+		if rec.Features&auth.FeatureSecondFactor == 0 {
+			t.Errorf("Token features: expected second factor, got '%s'", rec.Features)
+		}
+		return []byte("<==auth-token==>"), time.Now(), nil
+	})
+	// The login is recorded after the second factor only.
+	ss.EXPECT().GetUid().Return(types.Uid(100))
+	as.EXPECT().Create(gomock.Any()).Return(nil)
+
+	s := &Session{
+		send: make(chan any, 10),
+		ver:  16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	for _, login := range []*MsgClientLogin{
+		{Id: "1", Scheme: "basic", Secret: []byte(secret)},
+		{Id: "2", Scheme: "totp", Secret: []byte("000000")},
+		{Id: "3", Scheme: "totp", Secret: []byte("123456")},
+	} {
+		s.dispatch(&ClientComMessage{Login: login})
+		if login.Id == "1" && !s.uid.IsZero() {
+			t.Error("Session must not be authenticated before the second factor")
+		}
+	}
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusMultipleChoices, http.StatusUnauthorized, http.StatusOK}, t)
+	if p := r.messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any); p["scheme"] != "totp" || p["token"] != nil {
+		t.Errorf("Second factor request: unexpected params %v", p)
+	}
+	if s.uid != uid || s.mfa != nil {
+		t.Errorf("Session: expected to be authenticated as %s, got '%s'", uid.UserId(), s.uid.UserId())
+	}
+}
+
+func TestDispatchLoginSecondFactorNotPending(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	sf := secondFactorAuth{mock_auth.NewMockAuthHandler(ctrl), mock_auth.NewMockSecondFactor(ctrl)}
+
+	store.Store = ss
+	defer func() {
+		store.Store = nil
+		ctrl.Finish()
+	}()
+
+	// The code is not checked without the first step.
+	ss.EXPECT().GetLogicalAuthHandler("totp").Return(sf)
+
+	s := &Session{
+		send: make(chan any, 10),
+		ver:  16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Login: &MsgClientLogin{Id: "1", Scheme: "totp", Secret: []byte("123456")}})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusUnauthorized}, t)
+	if !s.uid.IsZero() {
+		t.Error("Session must not be authenticated")
+	}
+}
+
+func TestDispatchLoginChallenge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+
+	store.Store = ss
+	defer func() {
+		store.Store = nil
+		ctrl.Finish()
+	}()
+
+	// The authenticator does not know the user until the client responds to the challenge.
+	challenge := []byte("challenge")
+	ss.EXPECT().GetLogicalAuthHandler("webauthn").Return(aa)
+	aa.EXPECT().Authenticate(nil, "").Return(nil, challenge, nil)
+
+	s := &Session{
+		send: make(chan any, 10),
+		ver:  16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Login: &MsgClientLogin{Id: "1", Scheme: "webauthn"}})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusMultipleChoices}, t)
+	if params := r.messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any); !bytes.Equal(params["challenge"].([]byte), challenge) {
+		t.Errorf("Expected challenge '%s', got %v", challenge, params["challenge"])
+	}
+	if !s.uid.IsZero() {
+		t.Error("Session must not be authenticated")
+	}
+}
+
+func TestDispatchLoginRevokedSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+
+	store.Store = ss
+	store.AuthSessions = as
+	defer func() {
+		store.Store = nil
+		store.AuthSessions = nil
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(1)
+	authRec := &auth.Rec{
+		Uid:       uid,
+		AuthLevel: auth.LevelAuth,
+		Features:  auth.FeatureValidated,
+		SessionId: types.Uid(100),
+		State:     types.StateOK,
+	}
+	ss.EXPECT().GetLogicalAuthHandler("token").Return(aa).Times(2)
+	aa.EXPECT().Authenticate([]byte("<==auth-token==>"), gomock.Any()).Return(authRec, nil, nil)
+	aa.EXPECT().GenSecret(authRec).Return([]byte("<==new-token==>"), time.Now(), nil)
+	// The login was revoked after the token was checked.
+	as.EXPECT().Update(gomock.Any()).Return(types.ErrNotFound)
+
+	s := &Session{
+		send: make(chan any, 10),
+		ver:  16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Login: &MsgClientLogin{Id: "1", Scheme: "token", Secret: []byte("<==auth-token==>")}})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusUnauthorized}, t)
+	if !s.uid.IsZero() || !s.authSession.IsZero() {
+		t.Error("Session must not be authenticated")
+	}
+}
+
+func TestDispatchGetSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+
+	store.AuthSessions = as
+	defer func() {
+		store.AuthSessions = nil
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(1)
+	current := types.Uid(100)
+	now := types.TimeNow()
+	as.EXPECT().GetAll(uid).Return([]types.AuthSession{
+		{
+			ObjHeader: types.ObjHeader{Id: current.String(), CreatedAt: now, UpdatedAt: now},
+			User:      uid.String(),
+			Expires:   now.Add(time.Hour),
+			DeviceId:  "device-1",
+			Platform:  "web",
+			UserAgent: "TinodeWeb/1.0",
+		},
+		{
+			ObjHeader:  types.ObjHeader{Id: types.Uid(200).String(), CreatedAt: now, UpdatedAt: now},
+			User:       uid.String(),
+			Expires:    now.Add(time.Hour),
+			Platform:   "android",
+			RemoteAddr: "192.0.2.1",
+		},
+	}, nil)
+
+	s := &Session{
+		send:        make(chan any, 10),
+		uid:         uid,
+		authLvl:     auth.LevelAuth,
+		authSession: current,
+		ver:         16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Get: &MsgClientGet{Id: "1", Topic: "me", MsgGetQuery: MsgGetQuery{What: "sessions"}}})
+	close(s.send)
+	wg.Wait()
+
+	if len(r.messages) != 1 {
+		t.Fatalf("responses: expected 1, received %d.", len(r.messages))
+	}
+	meta := r.messages[0].(*ServerComMessage).Meta
+	if meta == nil || meta.Topic != "me" || len(meta.Sessions) != 2 {
+		t.Fatalf("Expected meta with 2 sessions, got %+v", r.messages[0])
+	}
+	if !meta.Sessions[0].Current || meta.Sessions[0].UserAgent != "TinodeWeb/1.0" {
+		t.Errorf("First session: unexpected %+v", meta.Sessions[0])
+	}
+	if meta.Sessions[1].Current || meta.Sessions[1].RemoteAddr != "192.0.2.1" {
+		t.Errorf("Second session: unexpected %+v", meta.Sessions[1])
+	}
+}
+
+func TestDispatchDelSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+	dd := mock_store.NewMockDevicePersistenceInterface(ctrl)
+
+	uid := types.Uid(1)
+	current := types.Uid(100)
+	// Live session authenticated by another login.
+	other := &Session{sid: "other", uid: uid, authSession: types.Uid(200), proto: WEBSOCK, stop: make(chan any, 1)}
+
+	store.AuthSessions = as
+	store.Devices = dd
+	globals.sessionStore = &SessionStore{lru: list.New(), sessCache: map[string]*Session{other.sid: other}}
+	defer func() {
+		store.AuthSessions = nil
+		store.Devices = nil
+		globals.sessionStore = nil
+		ctrl.Finish()
+	}()
+
+	as.EXPECT().GetAll(uid).Return([]types.AuthSession{
+		{ObjHeader: types.ObjHeader{Id: current.String()}, User: uid.String(), DeviceId: "device-1"},
+		{ObjHeader: types.ObjHeader{Id: types.Uid(200).String()}, User: uid.String(), DeviceId: "device-2"},
+		{ObjHeader: types.ObjHeader{Id: types.Uid(300).String()}, User: uid.String(), DeviceId: "device-1"},
+	}, nil)
+	// All logins except the current one are revoked.
+	as.EXPECT().Delete(uid, types.Uid(200).String(), types.Uid(300).String()).Return(nil)
+	// The device still used by the current login keeps receiving push notifications.
+	dd.EXPECT().Delete(uid, "device-2").Return(nil)
+
+	s := &Session{
+		send:        make(chan any, 10),
+		uid:         uid,
+		authLvl:     auth.LevelAuth,
+		authSession: current,
+		ver:         16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Del: &MsgClientDel{Id: "1", What: "session"}})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusOK}, t)
+	if len(other.stop) != 1 {
+		t.Error("Session of the revoked login must be stopped")
+	}
+	if len(globals.sessionStore.sessCache) != 0 {
+		t.Error("Session of the revoked login must be removed from the store")
+	}
+}
+
+func TestDispatchDelSessionNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	as := mock_store.NewMockAuthSessionPersistenceInterface(ctrl)
+
+	store.AuthSessions = as
+	defer func() {
+		store.AuthSessions = nil
+		ctrl.Finish()
+	}()
+
+	uid := types.Uid(1)
+	as.EXPECT().GetAll(uid).Return([]types.AuthSession{
+		{ObjHeader: types.ObjHeader{Id: types.Uid(100).String()}, User: uid.String()},
+	}, nil)
+
+	s := &Session{
+		send:    make(chan any, 10),
+		uid:     uid,
+		authLvl: auth.LevelAuth,
+		ver:     16,
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{Del: &MsgClientDel{Id: "1", What: "session", Session: types.Uid(200).String()}})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusNotFound}, t)
+}
+
+func TestDispatchLoginLocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+
+	store.Store = ss
+	ll, cache := setupTestLoginLimiter(t)
+	globals.loginLimiter = ll
+	defer func() {
+		store.Store = nil
+		globals.loginLimiter = nil
+		ctrl.Finish()
+	}()
+
+	// The IP address is locked out.
+	until := time.Now().Add(time.Minute).UnixMilli()
+	cache[loginLimitAddrKey("10.0.0.1")] = "50:" + strconv.FormatInt(until, 10) + ":" + strconv.FormatInt(until, 10)
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(aa)
+	// Authenticate must not be called.
+
+	s := &Session{
+		send:       make(chan any, 10),
+		ver:        16,
+		remoteAddr: "10.0.0.1:12345",
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{
+		Login: &MsgClientLogin{
+			Id:     "123",
+			Scheme: "basic",
+			Secret: []byte("alice:password"),
+		},
+	})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusLocked}, t)
+	if retry, _ := r.messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any)["retry-after"].(int); retry < 59 || retry > 60 {
+		t.Errorf("Retry after: expected 60, got %d", retry)
+	}
+}
+
+func TestDispatchLoginLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+	ln := mock_auth.NewMockLoginNamer(ctrl)
+	handler := struct {
+		*mock_auth.MockAuthHandler
+		*mock_auth.MockLoginNamer
+	}{aa, ln}
+
+	store.Store = ss
+	store.Users = uu
+	ll, cache := setupTestLoginLimiter(t)
+	globals.loginLimiter = ll
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.loginLimiter = nil
+		ctrl.Finish()
+	}()
+
+	// One failure short of the account limit.
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	cache[loginLimitAccountKey("basic:alice")] = "2:0:" + now
+
+	secret := []byte("alice:password")
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(handler)
+	ln.EXPECT().LoginName(secret).Return("alice")
+	aa.EXPECT().GetRealName().Return("basic").AnyTimes()
+	aa.EXPECT().Authenticate(secret, gomock.Any()).Return(nil, nil, types.ErrFailed)
+	// Lookup of the locked out user for the plugins.
+	uu.EXPECT().GetAuthUniqueRecord("basic", "alice").Return(types.ZeroUid, auth.LevelNone, nil, time.Time{}, nil)
+
+	s := &Session{
+		send:       make(chan any, 10),
+		ver:        16,
+		remoteAddr: "10.0.0.1:12345",
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	s.dispatch(&ClientComMessage{
+		Login: &MsgClientLogin{
+			Id:     "123",
+			Scheme: "basic",
+			Secret: secret,
+		},
+	})
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusUnauthorized}, t)
+	if until, _ := ll.Check("basic:alice", ""); until.IsZero() {
+		t.Error("Account must be locked out")
+	}
+	if !strings.HasPrefix(cache[loginLimitAddrKey("10.0.0.1")], "1:0:") {
+		t.Errorf("IP address failure must be counted, got %s", cache[loginLimitAddrKey("10.0.0.1")])
+	}
+}
+
+func TestDispatchLoginSecondFactorLockout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	ss := mock_store.NewMockPersistentStorageInterface(ctrl)
+	uu := mock_store.NewMockUsersPersistenceInterface(ctrl)
+	aa := mock_auth.NewMockAuthHandler(ctrl)
+	ln := mock_auth.NewMockLoginNamer(ctrl)
+	handler := struct {
+		*mock_auth.MockAuthHandler
+		*mock_auth.MockLoginNamer
+	}{aa, ln}
+	sf := secondFactorAuth{mock_auth.NewMockAuthHandler(ctrl), mock_auth.NewMockSecondFactor(ctrl)}
+
+	uid := types.Uid(1)
+	store.Store = ss
+	store.Users = uu
+	ll, cache := setupTestLoginLimiter(t)
+	globals.loginLimiter = ll
+	globals.secondFactors = []string{"totp"}
+	defer func() {
+		store.Store = nil
+		store.Users = nil
+		globals.loginLimiter = nil
+		globals.secondFactors = nil
+		ctrl.Finish()
+	}()
+
+	// One failure short of the account limit.
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	cache[loginLimitAccountKey("basic:alice")] = "2:0:" + now
+
+	secret := []byte("alice:password")
+	authRec := &auth.Rec{
+		Uid:       uid,
+		AuthLevel: auth.LevelAuth,
+		State:     types.StateOK,
+	}
+	// The password is correct, the failed attempts are kept until the second factor is passed.
+	ss.EXPECT().GetLogicalAuthHandler("basic").Return(handler)
+	ln.EXPECT().LoginName(secret).Return("alice")
+	aa.EXPECT().GetRealName().Return("basic").AnyTimes()
+	aa.EXPECT().Authenticate(secret, gomock.Any()).Return(authRec, nil, nil)
+	ss.EXPECT().GetLogicalAuthHandler("totp").Return(sf).Times(3)
+	sf.MockSecondFactor.EXPECT().IsEnrolled(uid).Return(true, nil)
+	// The wrong code locks out the account, the next code is not checked.
+	sf.MockSecondFactor.EXPECT().Verify(uid, []byte("000000"), gomock.Any()).Return(types.ErrFailed)
+	// Lookup of the locked out user for the plugins.
+	uu.EXPECT().GetAuthUniqueRecord("basic", "alice").Return(types.ZeroUid, auth.LevelNone, nil, time.Time{}, nil)
+
+	s := &Session{
+		send:       make(chan any, 10),
+		ver:        16,
+		remoteAddr: "10.0.0.1:12345",
+	}
+	wg := sync.WaitGroup{}
+	r := responses{}
+	wg.Add(1)
+	go s.testWriteLoop(&r, &wg)
+
+	for _, login := range []*MsgClientLogin{
+		{Id: "1", Scheme: "basic", Secret: secret},
+		{Id: "2", Scheme: "totp", Secret: []byte("000000")},
+		{Id: "3", Scheme: "totp", Secret: []byte("123456")},
+	} {
+		s.dispatch(&ClientComMessage{Login: login})
+	}
+	close(s.send)
+	wg.Wait()
+
+	verifyResponseCodes(&r, []int{http.StatusMultipleChoices, http.StatusUnauthorized, http.StatusLocked}, t)
+	if until, _ := ll.Check("basic:alice", ""); until.IsZero() {
+		t.Error("Account must be locked out")
+	}
+	if !s.uid.IsZero() {
+		t.Error("Session must not be authenticated")
+	}
 }
 
 func TestDispatchSubscribe(t *testing.T) {
@@ -11,6 +11,7 @@ package main
 import (
 	"container/list"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -241,6 +242,52 @@ func (ss *SessionStore) EvictUser(uid types.Uid, skipSid string) {
 	statsSet("LiveSessions", int64(len(ss.sessCache)))
 }
 
+// EvictAuthSessions terminates sessions of the user authenticated by the given logins on devices.
+func (ss *SessionStore) EvictAuthSessions(uid types.Uid, ids []string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	evicted := NoErrEvicted("", "", types.TimeNow())
+	evicted.AsUser = uid.UserId()
+	for _, s := range ss.sessCache {
+		if suid, _, authSession := s.authInfo(); suid == uid && !s.isMultiplex() && !authSession.IsZero() &&
+			slices.Contains(ids, authSession.String()) {
+			_, data := s.serialize(evicted)
+			s.stopSession(data)
+			delete(ss.sessCache, s.sid)
+			if s.proto == LPOLL {
+				ss.lru.Remove(s.lpTracker)
+			}
+		}
+	}
+
+	statsSet("LiveSessions", int64(len(ss.sessCache)))
+}
+
+// Evict terminates the session with the given ID. Returns false if the session is not found.
+func (ss *SessionStore) Evict(sid string) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	s := ss.sessCache[sid]
+	if s == nil || s.isMultiplex() {
+		return false
+	}
+
+	evicted := NoErrEvicted("", "", types.TimeNow())
+	uid, _, _ := s.authInfo()
+	evicted.AsUser = uid.UserId()
+	_, data := s.serialize(evicted)
+	s.stopSession(data)
+	delete(ss.sessCache, s.sid)
+	if s.proto == LPOLL {
+		ss.lru.Remove(s.lpTracker)
+	}
+
+	statsSet("LiveSessions", int64(len(ss.sessCache)))
+	return true
+}
+
 // NodeRestarted removes stale sessions from a restarted cluster node.
 //   - nodeName is the name of affected node
 //   - fingerprint is the new fingerprint of the node.
@@ -544,6 +544,21 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetAuthUniqueRecord(scheme,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuthUniqueRecord", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetAuthUniqueRecord), scheme, unique)
 }
 
+// GetAuthUpdated mocks base method.
+func (m *MockUsersPersistenceInterface) GetAuthUpdated(uid types.Uid, scheme string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuthUpdated", uid, scheme)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuthUpdated indicates an expected call of GetAuthUpdated.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) GetAuthUpdated(uid, scheme interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuthUpdated", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetAuthUpdated), uid, scheme)
+}
+
 // GetByCred mocks base method.
 func (m *MockUsersPersistenceInterface) GetByCred(method, value string) (types.Uid, error) {
 	m.ctrl.T.Helper()
@@ -668,6 +683,21 @@ func (mr *MockUsersPersistenceInterfaceMockRecorder) GetUnvalidated(lastUpdatedB
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnvalidated", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).GetUnvalidated), lastUpdatedBefore, limit)
 }
 
+// List mocks base method.
+func (m *MockUsersPersistenceInterface) List(after types.Uid, limit int) ([]types.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", after, limit)
+	ret0, _ := ret[0].([]types.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockUsersPersistenceInterfaceMockRecorder) List(after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockUsersPersistenceInterface)(nil).List), after, limit)
+}
+
 // Update mocks base method.
 func (m *MockUsersPersistenceInterface) Update(uid types.Uid, update map[string]any) error {
 	m.ctrl.T.Helper()
@@ -894,6 +924,21 @@ func (mr *MockTopicsPersistenceInterfaceMockRecorder) GetUsersAny(topic, opts in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersAny", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).GetUsersAny), topic, opts)
 }
 
+// GetWithMsgTTL mocks base method.
+func (m *MockTopicsPersistenceInterface) GetWithMsgTTL() ([]types.Topic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithMsgTTL")
+	ret0, _ := ret[0].([]types.Topic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWithMsgTTL indicates an expected call of GetWithMsgTTL.
+func (mr *MockTopicsPersistenceInterfaceMockRecorder) GetWithMsgTTL() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithMsgTTL", reflect.TypeOf((*MockTopicsPersistenceInterface)(nil).GetWithMsgTTL))
+}
+
 // OwnerChange mocks base method.
 func (m *MockTopicsPersistenceInterface) OwnerChange(topic string, newOwner types.Uid) error {
 	m.ctrl.T.Helper()
@@ -1043,6 +1088,20 @@ func (m *MockMessagesPersistenceInterface) EXPECT() *MockMessagesPersistenceInte
 	return m.recorder
 }
 
+// ClaimScheduled mocks base method.
+func (m *MockMessagesPersistenceInterface) ClaimScheduled(id string, now, until time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimScheduled", id, now, until)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClaimScheduled indicates an expected call of ClaimScheduled.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) ClaimScheduled(id, now, until interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimScheduled", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).ClaimScheduled), id, now, until)
+}
+
 // DeleteList mocks base method.
 func (m *MockMessagesPersistenceInterface) DeleteList(topic string, delID int, forUser types.Uid, msgDelAge time.Duration, ranges []types.Range) error {
 	m.ctrl.T.Helper()
@@ -1057,6 +1116,50 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) DeleteList(topic, delID,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteList", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).DeleteList), topic, delID, forUser, msgDelAge, ranges)
 }
 
+// DeleteScheduled mocks base method.
+func (m *MockMessagesPersistenceInterface) DeleteScheduled(topic string, forUser types.Uid, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteScheduled", topic, forUser, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteScheduled indicates an expected call of DeleteScheduled.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) DeleteScheduled(topic, forUser, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteScheduled", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).DeleteScheduled), topic, forUser, id)
+}
+
+// Edit mocks base method.
+func (m *MockMessagesPersistenceInterface) Edit(topic string, forUser types.Uid, seqId int, head types.KVMap, content any) (*types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Edit", topic, forUser, seqId, head, content)
+	ret0, _ := ret[0].(*types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Edit indicates an expected call of Edit.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Edit(topic, forUser, seqId, head, content interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Edit", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Edit), topic, forUser, seqId, head, content)
+}
+
+// Get mocks base method.
+func (m *MockMessagesPersistenceInterface) Get(topic string, forUser types.Uid, seqId int) (*types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", topic, forUser, seqId)
+	ret0, _ := ret[0].(*types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Get(topic, forUser, seqId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Get), topic, forUser, seqId)
+}
+
 // GetAll mocks base method.
 func (m *MockMessagesPersistenceInterface) GetAll(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Message, error) {
 	m.ctrl.T.Helper()
@@ -1088,19 +1191,181 @@ func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetDeleted(topic, forUse
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeleted", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetDeleted), topic, forUser, opt)
 }
 
+// GetDueScheduled mocks base method.
+func (m *MockMessagesPersistenceInterface) GetDueScheduled(before time.Time, limit int) ([]types.ScheduledMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDueScheduled", before, limit)
+	ret0, _ := ret[0].([]types.ScheduledMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDueScheduled indicates an expected call of GetDueScheduled.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetDueScheduled(before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDueScheduled", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetDueScheduled), before, limit)
+}
+
+// GetEdits mocks base method.
+func (m *MockMessagesPersistenceInterface) GetEdits(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageRevision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEdits", topic, forUser, opt)
+	ret0, _ := ret[0].([]types.MessageRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEdits indicates an expected call of GetEdits.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetEdits(topic, forUser, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEdits", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetEdits), topic, forUser, opt)
+}
+
+// GetExpired mocks base method.
+func (m *MockMessagesPersistenceInterface) GetExpired(topic string, before time.Time, limit int) ([]types.Range, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpired", topic, before, limit)
+	ret0, _ := ret[0].([]types.Range)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpired indicates an expected call of GetExpired.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetExpired(topic, before, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpired", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetExpired), topic, before, limit)
+}
+
+// GetReactions mocks base method.
+func (m *MockMessagesPersistenceInterface) GetReactions(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageReaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetReactions", topic, forUser, opt)
+	ret0, _ := ret[0].([]types.MessageReaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetReactions indicates an expected call of GetReactions.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetReactions(topic, forUser, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetReactions", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetReactions), topic, forUser, opt)
+}
+
+// GetScheduled mocks base method.
+func (m *MockMessagesPersistenceInterface) GetScheduled(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.ScheduledMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetScheduled", topic, forUser, opt)
+	ret0, _ := ret[0].([]types.ScheduledMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetScheduled indicates an expected call of GetScheduled.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetScheduled(topic, forUser, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetScheduled", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetScheduled), topic, forUser, opt)
+}
+
+// GetThreads mocks base method.
+func (m *MockMessagesPersistenceInterface) GetThreads(topic string, opt *types.QueryOpt) ([]types.MessageThread, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetThreads", topic, opt)
+	ret0, _ := ret[0].([]types.MessageThread)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetThreads indicates an expected call of GetThreads.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetThreads(topic, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetThreads", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetThreads), topic, opt)
+}
+
+// GetVotes mocks base method.
+func (m *MockMessagesPersistenceInterface) GetVotes(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageVote, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVotes", topic, forUser, opt)
+	ret0, _ := ret[0].([]types.MessageVote)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVotes indicates an expected call of GetVotes.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) GetVotes(topic, forUser, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVotes", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).GetVotes), topic, forUser, opt)
+}
+
+// React mocks base method.
+func (m *MockMessagesPersistenceInterface) React(topic string, forUser types.Uid, seqId int, reaction string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "React", topic, forUser, seqId, reaction)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// React indicates an expected call of React.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) React(topic, forUser, seqId, reaction interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "React", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).React), topic, forUser, seqId, reaction)
+}
+
 // Save mocks base method.
-func (m *MockMessagesPersistenceInterface) Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool) {
+func (m *MockMessagesPersistenceInterface) Save(msg *types.Message, attachmentURLs, fids []string, readBySender bool) (error, bool) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Save", msg, attachmentURLs, readBySender)
+	ret := m.ctrl.Call(m, "Save", msg, attachmentURLs, fids, readBySender)
 	ret0, _ := ret[0].(error)
 	ret1, _ := ret[1].(bool)
 	return ret0, ret1
 }
 
 // Save indicates an expected call of Save.
-func (mr *MockMessagesPersistenceInterfaceMockRecorder) Save(msg, attachmentURLs, readBySender interface{}) *gomock.Call {
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Save(msg, attachmentURLs, fids, readBySender interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Save), msg, attachmentURLs, fids, readBySender)
+}
+
+// Schedule mocks base method.
+func (m *MockMessagesPersistenceInterface) Schedule(msg *types.ScheduledMessage) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Schedule", msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Schedule indicates an expected call of Schedule.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Schedule(msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Schedule", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Schedule), msg)
+}
+
+// Search mocks base method.
+func (m *MockMessagesPersistenceInterface) Search(topic string, forUser types.Uid, search string, opt *types.QueryOpt) ([]types.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", topic, forUser, search, opt)
+	ret0, _ := ret[0].([]types.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Search(topic, forUser, search, opt interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Save", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Save), msg, attachmentURLs, readBySender)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Search), topic, forUser, search, opt)
+}
+
+// Vote mocks base method.
+func (m *MockMessagesPersistenceInterface) Vote(topic string, forUser types.Uid, seqId int, opts []int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Vote", topic, forUser, seqId, opts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Vote indicates an expected call of Vote.
+func (mr *MockMessagesPersistenceInterfaceMockRecorder) Vote(topic, forUser, seqId, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Vote", reflect.TypeOf((*MockMessagesPersistenceInterface)(nil).Vote), topic, forUser, seqId, opts)
 }
 
 // MockDevicePersistenceInterface is a mock of DevicePersistenceInterface interface.
@@ -1174,6 +1439,253 @@ func (mr *MockDevicePersistenceInterfaceMockRecorder) Update(uid, oldDeviceID, d
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDevicePersistenceInterface)(nil).Update), uid, oldDeviceID, dev)
 }
 
+// MockAuthSessionPersistenceInterface is a mock of AuthSessionPersistenceInterface interface.
+type MockAuthSessionPersistenceInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuthSessionPersistenceInterfaceMockRecorder
+}
+
+// MockAuthSessionPersistenceInterfaceMockRecorder is the mock recorder for MockAuthSessionPersistenceInterface.
+type MockAuthSessionPersistenceInterfaceMockRecorder struct {
+	mock *MockAuthSessionPersistenceInterface
+}
+
+// NewMockAuthSessionPersistenceInterface creates a new mock instance.
+func NewMockAuthSessionPersistenceInterface(ctrl *gomock.Controller) *MockAuthSessionPersistenceInterface {
+	mock := &MockAuthSessionPersistenceInterface{ctrl: ctrl}
+	mock.recorder = &MockAuthSessionPersistenceInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuthSessionPersistenceInterface) EXPECT() *MockAuthSessionPersistenceInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockAuthSessionPersistenceInterface) Create(sess *types.AuthSession) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", sess)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) Create(sess interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).Create), sess)
+}
+
+// Delete mocks base method.
+func (m *MockAuthSessionPersistenceInterface) Delete(uid types.Uid, ids ...string) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{uid}
+	for _, a := range ids {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Delete", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) Delete(uid interface{}, ids ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{uid}, ids...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).Delete), varargs...)
+}
+
+// Expire mocks base method.
+func (m *MockAuthSessionPersistenceInterface) Expire(olderThan time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Expire", olderThan)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Expire indicates an expected call of Expire.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) Expire(olderThan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Expire", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).Expire), olderThan)
+}
+
+// Get mocks base method.
+func (m *MockAuthSessionPersistenceInterface) Get(id string) (*types.AuthSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", id)
+	ret0, _ := ret[0].(*types.AuthSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) Get(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).Get), id)
+}
+
+// GetAll mocks base method.
+func (m *MockAuthSessionPersistenceInterface) GetAll(uid types.Uid) ([]types.AuthSession, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", uid)
+	ret0, _ := ret[0].([]types.AuthSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) GetAll(uid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).GetAll), uid)
+}
+
+// Update mocks base method.
+func (m *MockAuthSessionPersistenceInterface) Update(sess *types.AuthSession) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", sess)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockAuthSessionPersistenceInterfaceMockRecorder) Update(sess interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockAuthSessionPersistenceInterface)(nil).Update), sess)
+}
+
+// MockAPIKeysPersistenceInterface is a mock of APIKeysPersistenceInterface interface.
+type MockAPIKeysPersistenceInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockAPIKeysPersistenceInterfaceMockRecorder
+}
+
+// MockAPIKeysPersistenceInterfaceMockRecorder is the mock recorder for MockAPIKeysPersistenceInterface.
+type MockAPIKeysPersistenceInterfaceMockRecorder struct {
+	mock *MockAPIKeysPersistenceInterface
+}
+
+// NewMockAPIKeysPersistenceInterface creates a new mock instance.
+func NewMockAPIKeysPersistenceInterface(ctrl *gomock.Controller) *MockAPIKeysPersistenceInterface {
+	mock := &MockAPIKeysPersistenceInterface{ctrl: ctrl}
+	mock.recorder = &MockAPIKeysPersistenceInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAPIKeysPersistenceInterface) EXPECT() *MockAPIKeysPersistenceInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockAPIKeysPersistenceInterface) Create(key *types.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockAPIKeysPersistenceInterfaceMockRecorder) Create(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockAPIKeysPersistenceInterface)(nil).Create), key)
+}
+
+// Get mocks base method.
+func (m *MockAPIKeysPersistenceInterface) Get(id string) (*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", id)
+	ret0, _ := ret[0].(*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockAPIKeysPersistenceInterfaceMockRecorder) Get(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockAPIKeysPersistenceInterface)(nil).Get), id)
+}
+
+// GetAll mocks base method.
+func (m *MockAPIKeysPersistenceInterface) GetAll() ([]types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll")
+	ret0, _ := ret[0].([]types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockAPIKeysPersistenceInterfaceMockRecorder) GetAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAPIKeysPersistenceInterface)(nil).GetAll))
+}
+
+// Update mocks base method.
+func (m *MockAPIKeysPersistenceInterface) Update(key *types.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockAPIKeysPersistenceInterfaceMockRecorder) Update(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockAPIKeysPersistenceInterface)(nil).Update), key)
+}
+
+// MockAuditPersistenceInterface is a mock of AuditPersistenceInterface interface.
+type MockAuditPersistenceInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuditPersistenceInterfaceMockRecorder
+}
+
+// MockAuditPersistenceInterfaceMockRecorder is the mock recorder for MockAuditPersistenceInterface.
+type MockAuditPersistenceInterfaceMockRecorder struct {
+	mock *MockAuditPersistenceInterface
+}
+
+// NewMockAuditPersistenceInterface creates a new mock instance.
+func NewMockAuditPersistenceInterface(ctrl *gomock.Controller) *MockAuditPersistenceInterface {
+	mock := &MockAuditPersistenceInterface{ctrl: ctrl}
+	mock.recorder = &MockAuditPersistenceInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuditPersistenceInterface) EXPECT() *MockAuditPersistenceInterfaceMockRecorder {
+	return m.recorder
+}
+
+// Add mocks base method.
+func (m *MockAuditPersistenceInterface) Add(rec *types.AuditRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Add", rec)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Add indicates an expected call of Add.
+func (mr *MockAuditPersistenceInterfaceMockRecorder) Add(rec interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockAuditPersistenceInterface)(nil).Add), rec)
+}
+
+// GetAll mocks base method.
+func (m *MockAuditPersistenceInterface) GetAll(query *types.AuditQuery) ([]types.AuditRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", query)
+	ret0, _ := ret[0].([]types.AuditRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockAuditPersistenceInterfaceMockRecorder) GetAll(query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockAuditPersistenceInterface)(nil).GetAll), query)
+}
+
 // MockFilePersistenceInterface is a mock of FilePersistenceInterface interface.
 type MockFilePersistenceInterface struct {
 	ctrl     *gomock.Controller
@@ -1197,6 +1709,20 @@ func (m *MockFilePersistenceInterface) EXPECT() *MockFilePersistenceInterfaceMoc
 	return m.recorder
 }
 
+// Delete mocks base method.
+func (m *MockFilePersistenceInterface) Delete(fid string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", fid)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockFilePersistenceInterfaceMockRecorder) Delete(fid interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockFilePersistenceInterface)(nil).Delete), fid)
+}
+
 // DeleteUnused mocks base method.
 func (m *MockFilePersistenceInterface) DeleteUnused(olderThan time.Time, limit int) error {
 	m.ctrl.T.Helper()
@@ -1241,6 +1767,36 @@ func (mr *MockFilePersistenceInterfaceMockRecorder) Get(fid interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockFilePersistenceInterface)(nil).Get), fid)
 }
 
+// GetAll mocks base method.
+func (m *MockFilePersistenceInterface) GetAll(user, after types.Uid, limit int) ([]types.FileDef, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", user, after, limit)
+	ret0, _ := ret[0].([]types.FileDef)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockFilePersistenceInterfaceMockRecorder) GetAll(user, after, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockFilePersistenceInterface)(nil).GetAll), user, after, limit)
+}
+
+// GetMessageAttachments mocks base method.
+func (m *MockFilePersistenceInterface) GetMessageAttachments(topic string, seqId int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMessageAttachments", topic, seqId)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMessageAttachments indicates an expected call of GetMessageAttachments.
+func (mr *MockFilePersistenceInterfaceMockRecorder) GetMessageAttachments(topic, seqId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMessageAttachments", reflect.TypeOf((*MockFilePersistenceInterface)(nil).GetMessageAttachments), topic, seqId)
+}
+
 // LinkAttachments mocks base method.
 func (m *MockFilePersistenceInterface) LinkAttachments(topic string, msgId types.Uid, attachments []string) error {
 	m.ctrl.T.Helper()
@@ -1335,6 +1891,21 @@ func (mr *MockPersistentCacheInterfaceMockRecorder) Get(key interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockPersistentCacheInterface)(nil).Get), key)
 }
 
+// Swap mocks base method.
+func (m *MockPersistentCacheInterface) Swap(key, oldValue, newValue string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Swap", key, oldValue, newValue)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Swap indicates an expected call of Swap.
+func (mr *MockPersistentCacheInterfaceMockRecorder) Swap(key, oldValue, newValue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Swap", reflect.TypeOf((*MockPersistentCacheInterface)(nil).Swap), key, oldValue, newValue)
+}
+
 // Upsert mocks base method.
 func (m *MockPersistentCacheInterface) Upsert(key, value string, failOnDuplicate bool) error {
 	m.ctrl.T.Helper()
@@ -213,6 +213,13 @@ func RegisterAdapter(a adapter.Adapter) {
 	availableAdapters[adapterName] = a
 }
 
+// GetAvailableAdapter returns a registered adapter by name or nil if the adapter is not linked into
+// the binary. The adapter is returned as is: it's not opened and it's not used by the store. It allows
+// tools to access a database other than the one configured with `use_adapter`, e.g. to migrate data.
+func GetAvailableAdapter(name string) adapter.Adapter {
+	return availableAdapters[name]
+}
+
 // GetUid generates a unique ID suitable for use as a primary key.
 func (storeObj) GetUid() types.Uid {
 	return uGen.Get()
@@ -254,11 +261,13 @@ type UsersPersistenceInterface interface {
 	Create(user *types.User, private any) (*types.User, error)
 	GetAuthRecord(user types.Uid, scheme string) (string, auth.Level, []byte, time.Time, error)
 	GetAuthUniqueRecord(scheme, unique string) (types.Uid, auth.Level, []byte, time.Time, error)
+	GetAuthUpdated(uid types.Uid, scheme string) (time.Time, error)
 	AddAuthRecord(uid types.Uid, authLvl auth.Level, scheme, unique string, secret []byte, expires time.Time) error
 	UpdateAuthRecord(uid types.Uid, authLvl auth.Level, scheme, unique string, secret []byte, expires time.Time) error
 	DelAuthRecords(uid types.Uid, scheme string) error
 	Get(uid types.Uid) (*types.User, error)
 	GetAll(uid ...types.Uid) ([]types.User, error)
+	List(after types.Uid, limit int) ([]types.User, error)
 	GetByCred(method, value string) (types.Uid, error)
 	Delete(id types.Uid, hard bool) error
 	UpdateLastSeen(uid types.Uid, userAgent string, when time.Time) error
@@ -350,6 +359,11 @@ func (usersMapper) GetAuthUniqueRecord(scheme, unique string) (types.Uid, auth.L
 	return adp.AuthGetUniqueRecord(scheme + ":" + unique)
 }
 
+// GetAuthUpdated returns the time when the secret of the user's authentication record was last changed.
+func (usersMapper) GetAuthUpdated(uid types.Uid, scheme string) (time.Time, error) {
+	return adp.AuthGetUpdated(uid, scheme)
+}
+
 // AddAuthRecord creates a new authentication record for the given user.
 func (usersMapper) AddAuthRecord(uid types.Uid, authLvl auth.Level, scheme, unique string, secret []byte,
 	expires time.Time) error {
@@ -379,6 +393,12 @@ func (usersMapper) GetAll(uid ...types.Uid) ([]types.User, error) {
 	return adp.UserGetAll(uid...)
 }
 
+// List returns a page of users ordered by ID, starting after the given user. Suspended and deleted
+// users are included.
+func (usersMapper) List(after types.Uid, limit int) ([]types.User, error) {
+	return adp.UserList(after, limit)
+}
+
 // GetByCred returns user ID for the given validated credential.
 func (usersMapper) GetByCred(method, value string) (types.Uid, error) {
 	return adp.UserGetByCred(method, value)
@@ -513,6 +533,7 @@ type TopicsPersistenceInterface interface {
 	Update(topic string, update map[string]any) error
 	UpdateSubCnt(topic string) error
 	OwnerChange(topic string, newOwner types.Uid) error
+	GetWithMsgTTL() ([]types.Topic, error)
 	Delete(topic string, isChan, hard bool) error
 }
 
@@ -604,6 +625,11 @@ func (topicsMapper) OwnerChange(topic string, newOwner types.Uid) error {
 	return adp.TopicOwnerChange(topic, newOwner)
 }
 
+// GetWithMsgTTL returns names and message TTLs of topics where messages expire.
+func (topicsMapper) GetWithMsgTTL() ([]types.Topic, error) {
+	return adp.TopicsWithMsgTTL()
+}
+
 // Delete deletes topic, messages, attachments, and subscriptions.
 func (topicsMapper) Delete(topic string, isChan, hard bool) error {
 	return adp.TopicDelete(topic, isChan, hard)
@@ -666,10 +692,25 @@ func (subsMapper) Delete(topic string, user types.Uid) error {
 
 // MessagesPersistenceInterface is an interface which defines methods for persistent storage of messages.
 type MessagesPersistenceInterface interface {
-	Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool)
+	Save(msg *types.Message, attachmentURLs []string, fids []string, readBySender bool) (error, bool)
 	DeleteList(topic string, delID int, forUser types.Uid, msgDelAge time.Duration, ranges []types.Range) error
+	Get(topic string, forUser types.Uid, seqId int) (*types.Message, error)
 	GetAll(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Message, error)
+	Search(topic string, forUser types.Uid, search string, opt *types.QueryOpt) ([]types.Message, error)
+	Edit(topic string, forUser types.Uid, seqId int, head types.KVMap, content any) (*types.Message, error)
+	GetEdits(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageRevision, error)
+	React(topic string, forUser types.Uid, seqId int, reaction string) error
+	GetReactions(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageReaction, error)
+	Vote(topic string, forUser types.Uid, seqId int, opts []int) error
+	GetVotes(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageVote, error)
+	GetThreads(topic string, opt *types.QueryOpt) ([]types.MessageThread, error)
 	GetDeleted(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Range, int, error)
+	GetExpired(topic string, before time.Time, limit int) ([]types.Range, error)
+	Schedule(msg *types.ScheduledMessage) error
+	GetScheduled(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.ScheduledMessage, error)
+	GetDueScheduled(before time.Time, limit int) ([]types.ScheduledMessage, error)
+	ClaimScheduled(id string, now, until time.Time) error
+	DeleteScheduled(topic string, forUser types.Uid, id string) error
 }
 
 // messagesMapper is a concrete type implementing MessagesPersistenceInterface.
@@ -678,8 +719,9 @@ type messagesMapper struct{}
 // Messages is a singleton ancor object for exporting MessagesPersistenceInterface.
 var Messages MessagesPersistenceInterface
 
-// Save message
-func (messagesMapper) Save(msg *types.Message, attachmentURLs []string, readBySender bool) (error, bool) {
+// Save message. The attachmentURLs are URLs of files uploaded for the message, the fids are IDs of already
+// stored files to link to the message, e.g. attachments of a forwarded message.
+func (messagesMapper) Save(msg *types.Message, attachmentURLs []string, fids []string, readBySender bool) (error, bool) {
 	msg.InitTimes()
 	msg.SetUid(Store.GetUid())
 	// Increment topic's or user's SeqId
@@ -711,18 +753,17 @@ func (messagesMapper) Save(msg *types.Message, attachmentURLs []string, readBySe
 		}
 	}
 
-	if len(attachmentURLs) > 0 {
-		var attachments []string
-		for _, url := range attachmentURLs {
-			// Convert attachment URLs to file IDs.
-			if fid := mediaHandler.GetIdFromUrl(url); !fid.IsZero() {
-				attachments = append(attachments, fid.String())
-			}
-		}
-		if len(attachments) > 0 {
-			return adp.FileLinkAttachments("", types.ZeroUid, msg.Uid(), attachments), markedReadBySender
+	var attachments []string
+	for _, url := range attachmentURLs {
+		// Convert attachment URLs to file IDs.
+		if fid := mediaHandler.GetIdFromUrl(url); !fid.IsZero() {
+			attachments = append(attachments, fid.String())
 		}
 	}
+	attachments = append(attachments, fids...)
+	if len(attachments) > 0 {
+		return adp.FileLinkAttachments("", types.ZeroUid, msg.Uid(), attachments), markedReadBySender
+	}
 
 	return nil, markedReadBySender
 }
@@ -772,6 +813,127 @@ func (messagesMapper) GetAll(topic string, forUser types.Uid, opt *types.QueryOp
 	return adp.MessageGetAll(topic, forUser, opt)
 }
 
+// Get returns one message by ID or nil if the message does not exist or was deleted for the user.
+// The message is always read from the primary database, so it reflects recent writes.
+func (messagesMapper) Get(topic string, forUser types.Uid, seqId int) (*types.Message, error) {
+	msgs, err := adp.MessageGetAll(topic, forUser, &types.QueryOpt{IdRanges: []types.Range{{Low: seqId}}, Limit: 1})
+	if err != nil || len(msgs) == 0 || msgs[0].SeqId != seqId {
+		return nil, err
+	}
+	return &msgs[0], nil
+}
+
+// Search returns messages which contain the given search string.
+func (messagesMapper) Search(topic string, forUser types.Uid, search string, opt *types.QueryOpt) ([]types.Message, error) {
+	return adp.MessageSearch(topic, forUser, search, opt)
+}
+
+// Edit replaces head and content of a message published by the given user. The previous version of
+// the message is saved as a revision. Returns the updated message.
+func (messagesMapper) Edit(topic string, forUser types.Uid, seqId int, head types.KVMap, content any) (*types.Message, error) {
+	msg, err := Messages.Get(topic, forUser, seqId)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, types.ErrNotFound
+	}
+
+	if types.ParseUid(msg.From) != forUser {
+		// Only the author can edit the message.
+		return nil, types.ErrPermissionDenied
+	}
+
+	rev := &types.MessageRevision{
+		CreatedAt: msg.UpdatedAt,
+		Topic:     topic,
+		SeqId:     seqId,
+		Rev:       messageRev(msg.Head),
+		Head:      msg.Head,
+		Content:   msg.Content,
+	}
+
+	if head == nil {
+		head = types.KVMap{}
+	}
+	head["rev"] = rev.Rev + 1
+	msg.Head = head
+	msg.Content = content
+	msg.UpdatedAt = types.TimeNow()
+
+	if err = adp.MessageEdit(msg, rev); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// messageRev returns the revision number of a message as recorded in message head.
+func messageRev(head types.KVMap) int {
+	switch rev := head["rev"].(type) {
+	case int:
+		return rev
+	case int32:
+		return int(rev)
+	case int64:
+		return int(rev)
+	case float64:
+		return int(rev)
+	}
+	// Message was never edited.
+	return 1
+}
+
+// GetEdits returns previous versions of messages matching the query which are available to the user.
+func (messagesMapper) GetEdits(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageRevision, error) {
+	// Skip messages deleted for the user.
+	msgs, err := adp.MessageGetAll(topic, forUser, opt)
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+
+	var seqIds []int
+	for i := range msgs {
+		if messageRev(msgs[i].Head) > 1 {
+			seqIds = append(seqIds, msgs[i].SeqId)
+		}
+	}
+	if len(seqIds) == 0 {
+		return nil, nil
+	}
+
+	sort.Ints(seqIds)
+	var limit int
+	if opt != nil {
+		limit = opt.Limit
+	}
+	return adp.MessageGetEdits(topic, &types.QueryOpt{IdRanges: types.SliceToRanges(seqIds), Limit: limit})
+}
+
+// React adds or replaces user's reaction to the message. Empty reaction removes it.
+func (messagesMapper) React(topic string, forUser types.Uid, seqId int, reaction string) error {
+	return adp.MessageReact(topic, seqId, forUser, reaction)
+}
+
+// GetReactions returns aggregated reactions to messages matching the query.
+func (messagesMapper) GetReactions(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageReaction, error) {
+	return adp.MessageGetReactions(topic, forUser, opt)
+}
+
+// Vote replaces user's vote in the poll with the given options. Empty list of options removes the vote.
+func (messagesMapper) Vote(topic string, forUser types.Uid, seqId int, opts []int) error {
+	return adp.MessageVote(topic, seqId, forUser, opts)
+}
+
+// GetVotes returns counts of votes for poll options in messages matching the query.
+func (messagesMapper) GetVotes(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.MessageVote, error) {
+	return adp.MessageGetVotes(topic, forUser, opt)
+}
+
+// GetThreads returns reply counts and timestamps of the latest replies to messages matching the query.
+func (messagesMapper) GetThreads(topic string, opt *types.QueryOpt) ([]types.MessageThread, error) {
+	return adp.MessageGetThreads(topic, opt)
+}
+
 // GetDeleted returns the ranges of deleted messages and the largest DelId reported in the list.
 func (messagesMapper) GetDeleted(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.Range, int, error) {
 	dmsgs, err := adp.MessageGetDeleted(topic, forUser, opt)
@@ -795,6 +957,45 @@ func (messagesMapper) GetDeleted(topic string, forUser types.Uid, opt *types.Que
 	return ranges, maxID, nil
 }
 
+// GetExpired returns ranges of up to limit not yet deleted messages created before the given time.
+func (messagesMapper) GetExpired(topic string, before time.Time, limit int) ([]types.Range, error) {
+	seqIDs, err := adp.MessageGetExpired(topic, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return types.SliceToRanges(seqIDs), nil
+}
+
+// Schedule saves a message for delivery at msg.DeliverAt.
+func (messagesMapper) Schedule(msg *types.ScheduledMessage) error {
+	msg.InitTimes()
+	msg.SetUid(Store.GetUid())
+	return adp.ScheduledMessageSave(msg)
+}
+
+// GetScheduled returns messages scheduled by the user in the topic.
+func (messagesMapper) GetScheduled(topic string, forUser types.Uid, opt *types.QueryOpt) ([]types.ScheduledMessage, error) {
+	return adp.ScheduledMessageGetAll(topic, forUser, opt)
+}
+
+// GetDueScheduled returns up to 'limit' scheduled messages which are due for delivery before the given time.
+func (messagesMapper) GetDueScheduled(before time.Time, limit int) ([]types.ScheduledMessage, error) {
+	return adp.ScheduledMessageGetDue(before, limit)
+}
+
+// ClaimScheduled claims the scheduled message for delivery until the given time. Returns ErrNotFound
+// if the message is already delivered or claimed by someone else.
+func (messagesMapper) ClaimScheduled(id string, now, until time.Time) error {
+	return adp.ScheduledMessageClaim(id, now, until)
+}
+
+// DeleteScheduled deletes a scheduled message. If forUser is not zero, the message must be scheduled by
+// that user. Returns types.ErrNotFound if the message does not exist or was already deleted.
+func (messagesMapper) DeleteScheduled(topic string, forUser types.Uid, id string) error {
+	return adp.ScheduledMessageDelete(topic, forUser, id)
+}
+
 // Registered authentication handlers.
 var authHandlers map[string]auth.AuthHandler
 
@@ -973,6 +1174,132 @@ func (deviceMapper) Delete(uid types.Uid, deviceID string) error {
 	return adp.DeviceDelete(uid, deviceID)
 }
 
+// AuthSessionPersistenceInterface is an interface which defines methods used for handling authenticated
+// sessions, i.e. logins on devices which can be listed and revoked by the user.
+type AuthSessionPersistenceInterface interface {
+	Create(sess *types.AuthSession) error
+	Update(sess *types.AuthSession) error
+	Get(id string) (*types.AuthSession, error)
+	GetAll(uid types.Uid) ([]types.AuthSession, error)
+	Delete(uid types.Uid, ids ...string) error
+	Expire(olderThan time.Time) error
+}
+
+// authSessionMapper is a concrete type implementing AuthSessionPersistenceInterface.
+type authSessionMapper struct{}
+
+// AuthSessions is a singleton instance of AuthSessionPersistenceInterface to map methods to.
+var AuthSessions AuthSessionPersistenceInterface
+
+// Create saves a new authenticated session. The ID is assigned if missing.
+func (authSessionMapper) Create(sess *types.AuthSession) error {
+	if sess.Id == "" {
+		sess.SetUid(Store.GetUid())
+	}
+	sess.InitTimes()
+	return adp.AuthSessionCreate(sess)
+}
+
+// Update marks the authenticated session as seen now and updates its expiration time and client details.
+func (authSessionMapper) Update(sess *types.AuthSession) error {
+	sess.UpdatedAt = types.TimeNow()
+	return adp.AuthSessionUpdate(sess)
+}
+
+// Get returns the authenticated session with the given ID or nil if it's not found or expired.
+func (authSessionMapper) Get(id string) (*types.AuthSession, error) {
+	sess, err := adp.AuthSessionGet(id)
+	if err != nil || sess == nil || sess.Expires.Before(types.TimeNow()) {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// GetAll returns authenticated sessions of the user which have not expired yet.
+func (authSessionMapper) GetAll(uid types.Uid) ([]types.AuthSession, error) {
+	return adp.AuthSessionGetAll(uid)
+}
+
+// Delete deletes authenticated sessions of the user.
+func (authSessionMapper) Delete(uid types.Uid, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return adp.AuthSessionDelete(uid, ids)
+}
+
+// Expire deletes authenticated sessions of all users which expired before the given time.
+func (authSessionMapper) Expire(olderThan time.Time) error {
+	return adp.AuthSessionExpire(olderThan)
+}
+
+// APIKeysPersistenceInterface is an interface which defines methods used for handling stored API keys.
+type APIKeysPersistenceInterface interface {
+	Create(key *types.APIKey) error
+	Update(key *types.APIKey) error
+	Get(id string) (*types.APIKey, error)
+	GetAll() ([]types.APIKey, error)
+}
+
+// apiKeyMapper is a concrete type implementing APIKeysPersistenceInterface.
+type apiKeyMapper struct{}
+
+// APIKeys is a singleton instance of APIKeysPersistenceInterface to map methods to.
+var APIKeys APIKeysPersistenceInterface
+
+// Create saves a new API key. The ID is assigned if missing.
+func (apiKeyMapper) Create(key *types.APIKey) error {
+	if key.Id == "" {
+		key.SetUid(Store.GetUid())
+	}
+	key.InitTimes()
+	return adp.APIKeyCreate(key)
+}
+
+// Update saves changes to the API key.
+func (apiKeyMapper) Update(key *types.APIKey) error {
+	key.UpdatedAt = types.TimeNow()
+	return adp.APIKeyUpdate(key)
+}
+
+// Get returns the API key with the given ID or nil if not found.
+func (apiKeyMapper) Get(id string) (*types.APIKey, error) {
+	return adp.APIKeyGet(id)
+}
+
+// GetAll returns all API keys, including revoked.
+func (apiKeyMapper) GetAll() ([]types.APIKey, error) {
+	return adp.APIKeyGetAll()
+}
+
+// AuditPersistenceInterface is an interface which defines methods used for handling the audit log.
+type AuditPersistenceInterface interface {
+	Add(rec *types.AuditRecord) error
+	GetAll(query *types.AuditQuery) ([]types.AuditRecord, error)
+}
+
+// auditMapper is a concrete type implementing AuditPersistenceInterface.
+type auditMapper struct{}
+
+// Audit is a singleton instance of AuditPersistenceInterface to map methods to.
+var Audit AuditPersistenceInterface
+
+// Add appends a record to the audit log. The ID and the time are assigned if missing.
+func (auditMapper) Add(rec *types.AuditRecord) error {
+	if rec.Id == "" {
+		rec.Id = Store.GetUid().String()
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = types.TimeNow()
+	}
+	return adp.AuditAdd(rec)
+}
+
+// GetAll returns records of the audit log matching the query, newest first.
+func (auditMapper) GetAll(query *types.AuditQuery) ([]types.AuditRecord, error) {
+	return adp.AuditGetAll(query)
+}
+
 // Registered media/file handlers.
 var fileHandlers map[string]media.Handler
 
@@ -1013,11 +1340,17 @@ type FilePersistenceInterface interface {
 	FinishUpload(fd *types.FileDef, success bool, size int64) (*types.FileDef, error)
 	// Get fetches a file record for a unique file id.
 	Get(fid string) (*types.FileDef, error)
+	// GetAll fetches records of files uploaded by the user, page by page.
+	GetAll(user, after types.Uid, limit int) ([]types.FileDef, error)
 	// DeleteUnused removes unused attachments.
 	DeleteUnused(olderThan time.Time, limit int) error
+	// Delete removes the file unless it's in use.
+	Delete(fid string) error
 	// LinkAttachments connects earlier uploaded attachments to a message or topic to prevent it
 	// from being garbage collected.
 	LinkAttachments(topic string, msgId types.Uid, attachments []string) error
+	// GetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+	GetMessageAttachments(topic string, seqId int) ([]string, error)
 }
 
 // fileMapper is concrete type which implements FilePersistenceInterface.
@@ -1042,6 +1375,11 @@ func (fileMapper) Get(fid string) (*types.FileDef, error) {
 	return adp.FileGet(fid)
 }
 
+// GetAll fetches records of completed uploads by the user, ordered by file ID.
+func (fileMapper) GetAll(user, after types.Uid, limit int) ([]types.FileDef, error) {
+	return adp.FileGetAll(user, after, limit)
+}
+
 // DeleteUnused removes unused attachments and avatars.
 func (fileMapper) DeleteUnused(olderThan time.Time, limit int) error {
 	toDel, err := adp.FileDeleteUnused(olderThan, limit)
@@ -1055,6 +1393,15 @@ func (fileMapper) DeleteUnused(olderThan time.Time, limit int) error {
 	return nil
 }
 
+// Delete removes the file record and the file itself unless the file is in use.
+func (fileMapper) Delete(fid string) error {
+	location, err := adp.FileDelete(fid)
+	if err != nil || location == "" {
+		return err
+	}
+	return Store.GetMediaHandler().Delete([]string{location})
+}
+
 // LinkAttachments connects earlier uploaded attachments to a message or topic to prevent it
 // from being garbage collected.
 func (fileMapper) LinkAttachments(topic string, msgId types.Uid, attachments []string) error {
@@ -1077,12 +1424,20 @@ func (fileMapper) LinkAttachments(topic string, msgId types.Uid, attachments []s
 	return nil
 }
 
+// GetMessageAttachments returns IDs of files attached to the message with the given SeqId.
+func (fileMapper) GetMessageAttachments(topic string, seqId int) ([]string, error) {
+	return adp.FileGetMessageAttachments(topic, seqId)
+}
+
 // PersistentCacheInterface is an interface which defines methods used for accessing persistent key-value cache.
 type PersistentCacheInterface interface {
 	// Get reads a persistent cache entry.
 	Get(key string) (string, error)
 	// Upsert creates or updates a persistent cache entry.
 	Upsert(key string, value string, failOnDuplicate bool) error
+	// Swap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+	// Returns false if the entry does not exist or has a different value.
+	Swap(key, oldValue, newValue string) (bool, error)
 	// Delete deletes a single persistent cache entry.
 	Delete(key string) error
 	// Expire expires older entries with the specified key prefix.
@@ -1104,6 +1459,11 @@ func (pcacheMapper) Upsert(key string, value string, failOnDuplicate bool) error
 	return adp.PCacheUpsert(key, value, failOnDuplicate)
 }
 
+// Swap replaces the value of a persistent cache entry if the current value is equal to oldValue.
+func (pcacheMapper) Swap(key, oldValue, newValue string) (bool, error) {
+	return adp.PCacheSwap(key, oldValue, newValue)
+}
+
 // Delete deletes a single persistent cache entry.
 func (pcacheMapper) Delete(key string) error {
 	return adp.PCacheDelete(key)
@@ -1125,6 +1485,9 @@ func init() {
 	Subs = subsMapper{}
 	Messages = messagesMapper{}
 	Devices = deviceMapper{}
+	AuthSessions = authSessionMapper{}
+	APIKeys = apiKeyMapper{}
+	Audit = auditMapper{}
 	Files = fileMapper{}
 	PCache = pcacheMapper{}
 }
@@ -36,6 +36,8 @@ const (
 	ErrUnsupported = StoreError("unsupported")
 	// ErrExpired means the secret has expired.
 	ErrExpired = StoreError("expired")
+	// ErrResetRequired means the secret is valid but must be reset, e.g. the password is too old.
+	ErrResetRequired = StoreError("reset required")
 	// ErrPolicy means policy violation, e.g. password too weak.
 	ErrPolicy = StoreError("policy")
 	// ErrCredentials means credentials like email or captcha must be validated.
@@ -404,6 +406,16 @@ func (h *ObjHeader) MergeTimes(h2 *ObjHeader) {
 	}
 }
 
+// scannedBytes converts a value read from an SQL database to a byte slice. Some drivers,
+// e.g. SQLite, return text as a string rather than a byte slice.
+func scannedBytes(val any) []byte {
+	if str, ok := val.(string); ok {
+		return []byte(str)
+	}
+	bb, _ := val.([]byte)
+	return bb
+}
+
 // StringSlice is defined so Scanner and Valuer can be attached to it.
 type StringSlice []string
 
@@ -412,7 +424,7 @@ func (ss *StringSlice) Scan(val any) error {
 	if val == nil {
 		return nil
 	}
-	return json.Unmarshal(val.([]byte), ss)
+	return json.Unmarshal(scannedBytes(val), ss)
 }
 
 // Value implements sql/driver.Valuer interface.
@@ -420,6 +432,22 @@ func (ss StringSlice) Value() (driver.Value, error) {
 	return json.Marshal(ss)
 }
 
+// IntSlice is defined so Scanner and Valuer can be attached to it.
+type IntSlice []int
+
+// Scan implements sql.Scanner interface.
+func (is *IntSlice) Scan(val any) error {
+	if val == nil {
+		return nil
+	}
+	return json.Unmarshal(scannedBytes(val), is)
+}
+
+// Value implements sql/driver.Valuer interface.
+func (is IntSlice) Value() (driver.Value, error) {
+	return json.Marshal(is)
+}
+
 // ObjState represents information on objects state,
 // such as an indication that User or Topic is suspended/soft-deleted.
 type ObjState int
@@ -682,9 +710,9 @@ func (m *AccessMode) UnmarshalJSON(b []byte) error {
 }
 
 // Scan is an implementation of sql.Scanner interface. It expects the
-// value to be a byte slice representation of an ASCII string.
+// value to be a byte slice or a string representation of an ASCII string.
 func (m *AccessMode) Scan(val any) error {
-	if bb, ok := val.([]byte); ok {
+	if bb := scannedBytes(val); bb != nil {
 		return m.UnmarshalText(bb)
 	}
 	return errors.New("scan failed: data is not a byte slice")
@@ -856,7 +884,7 @@ type DefaultAccess struct {
 // Scan is an implementation of Scanner interface so the value can be read from SQL DBs
 // It assumes the value is serialized and stored as JSON
 func (da *DefaultAccess) Scan(val any) error {
-	return json.Unmarshal(val.([]byte), da)
+	return json.Unmarshal(scannedBytes(val), da)
 }
 
 // Value implements sql's driver.Valuer interface.
@@ -1095,7 +1123,7 @@ func (kvm *KVMap) Scan(val any) error {
 		kvm = nil
 		return nil
 	}
-	return json.Unmarshal(val.([]byte), kvm)
+	return json.Unmarshal(scannedBytes(val), kvm)
 }
 
 // Value implements sql's driver.Valuer interface.
@@ -1140,6 +1168,17 @@ type Topic struct {
 	// Auxiliary set of key-value pairs.
 	Aux KVMap `json:"Aux,omitempty" bson:",omitempty"`
 
+	// SeqIds of pinned messages.
+	Pinned IntSlice `json:"Pinned,omitempty" bson:",omitempty"`
+
+	// Lifetime of messages in seconds, messages older than that are deleted. Zero means messages never expire.
+	MsgTTL int `json:"MsgTTL,omitempty" bson:",omitempty"`
+
+	// Slow mode: minimum interval in seconds between messages of a user. Zero means no limit.
+	SlowMode int `json:"SlowMode,omitempty" bson:",omitempty"`
+	// Number of messages a user may send in quick succession before slow mode kicks in. Zero means 1.
+	MsgBurst int `json:"MsgBurst,omitempty" bson:",omitempty"`
+
 	// Deserialized ephemeral params
 	perUser map[Uid]*perUserData // deserialized from Subscription
 }
@@ -1220,12 +1259,141 @@ type Message struct {
 	DeletedFor []SoftDelete `json:"DeletedFor,omitempty" bson:",omitempty"`
 	SeqId      int
 	Topic      string
+	// SeqId of the message this message is a reply to, 0 if not part of a thread.
+	Parent int `json:"Parent,omitempty" bson:",omitempty"`
 	// Sender's user ID as string (without 'usr' prefix), could be empty.
 	From    string
 	Head    KVMap `json:"Head,omitempty" bson:",omitempty"`
 	Content any
 }
 
+// MessageRevision is a stored previous version of an edited message.
+type MessageRevision struct {
+	// Timestamp when this version of the message was published.
+	CreatedAt time.Time
+	Topic     string
+	SeqId     int
+	// Revision number, the original message is revision 1.
+	Rev     int
+	Head    KVMap `json:"Head,omitempty" bson:",omitempty"`
+	Content any
+}
+
+// MessageReaction is an aggregated count of identical reactions to a message.
+type MessageReaction struct {
+	SeqId int
+	// Reaction such as an emoji.
+	Value string
+	// Number of users who reacted with this value.
+	Count int
+	// The user making the query is one of the reacting users.
+	Mine bool
+}
+
+// MessageVote is a count of votes for an option of a poll.
+type MessageVote struct {
+	SeqId int
+	// Zero-based index of the poll option.
+	Option int
+	// Number of users who chose this option.
+	Count int
+	// The user making the query is one of the voters for this option.
+	Mine bool
+}
+
+// MessageThread is a summary of replies to a message.
+type MessageThread struct {
+	// SeqId of the parent message.
+	SeqId int
+	// Number of replies in the thread.
+	Replies int
+	// Timestamp of the latest reply.
+	LastReplyAt time.Time
+}
+
+// ScheduledMessage is a message stored for delivery at a later time.
+type ScheduledMessage struct {
+	ObjHeader `bson:",inline"`
+	// Time when the message should be delivered.
+	DeliverAt time.Time
+	Topic     string
+	// UID as string of the user who scheduled the message.
+	From string
+	// SeqId of the message this message is a reply to.
+	Parent  int   `json:"Parent,omitempty" bson:",omitempty"`
+	Head    KVMap `json:"Head,omitempty" bson:",omitempty"`
+	Content any
+}
+
+// AuthSession is a persistent record of a login on a device. Tokens issued to the device refer to it,
+// the login is revoked by deleting the record. UpdatedAt is the time when the session was last seen.
+type AuthSession struct {
+	ObjHeader `bson:",inline"`
+	// UID as string of the user who logged in.
+	User string
+	// Expiration time of the most recently issued token.
+	Expires time.Time
+	// Device ID for push notifications.
+	DeviceId   string `json:"DeviceId,omitempty" bson:",omitempty"`
+	Platform   string `json:"Platform,omitempty" bson:",omitempty"`
+	UserAgent  string `json:"UserAgent,omitempty" bson:",omitempty"`
+	RemoteAddr string `json:"RemoteAddr,omitempty" bson:",omitempty"`
+}
+
+// APIKey is a stored API key. The key string issued to the client refers to the record by ID,
+// the key is revoked by setting RevokedAt. Empty lists mean no restriction.
+type APIKey struct {
+	ObjHeader `bson:",inline"`
+	// Human-readable name of the key, e.g. the name of the client app.
+	Name string
+	// Values of the HTTP Origin header allowed to use the key.
+	Origins StringSlice `json:"Origins,omitempty" bson:",omitempty"`
+	// Authentication schemes allowed in {login} and {acc}.
+	Schemes StringSlice `json:"Schemes,omitempty" bson:",omitempty"`
+	// Client messages allowed with the key, e.g. "hi", "login", "pub".
+	Messages StringSlice `json:"Messages,omitempty" bson:",omitempty"`
+	// Maximum number of client messages per minute by all sessions with the key, 0 for no limit.
+	RateLimit int `json:"RateLimit,omitempty" bson:",omitempty"`
+	// Number of messages which can be sent in a burst over the rate limit.
+	RateBurst int `json:"RateBurst,omitempty" bson:",omitempty"`
+	// Time when the key was revoked.
+	RevokedAt *time.Time `json:"RevokedAt,omitempty" bson:",omitempty"`
+}
+
+// AuditRecord is an entry in the audit log of privileged and security-relevant actions.
+// Records are never changed once written.
+type AuditRecord struct {
+	// Unique ID of the record.
+	Id        string `bson:"_id"`
+	CreatedAt time.Time
+	// Action performed, e.g. "login".
+	Action string
+	// ID of the user who performed the action, blank if not known.
+	Actor string `json:"Actor,omitempty" bson:",omitempty"`
+	// ID of the user affected by the action.
+	User string `json:"User,omitempty" bson:",omitempty"`
+	// Name of the topic affected by the action.
+	Topic string `json:"Topic,omitempty" bson:",omitempty"`
+	// IP address of the actor.
+	RemoteAddr string `json:"RemoteAddr,omitempty" bson:",omitempty"`
+	// Action-specific details.
+	Params KVMap `json:"Params,omitempty" bson:",omitempty"`
+}
+
+// AuditQuery selects records of the audit log. Zero values of fields mean no restriction.
+type AuditQuery struct {
+	// Records where the user is either the actor or the affected user.
+	User Uid
+	// Records of the given action.
+	Action string
+	// Records created at or after this time.
+	Since *time.Time
+	// Records created before this time.
+	Before *time.Time
+	// Maximum number of records to return.
+	Limit int
+}
+
 // Range is a range of message SeqIDs. Low end is inclusive (closed), high end is exclusive (open): [Low, Hi).
 // If the range contains just one ID, Hi is set to 0
 type Range struct {
@@ -1346,10 +1514,14 @@ type QueryOpt struct {
 	// ID-based query parameters: Messages
 	Since  int
 	Before int
+	// Return only replies to the message with this SeqId.
+	Thread int
 	// Common parameter
 	Limit int
 	// Ranges of IDs.
 	IdRanges []Range
+	// Messages may be read from a read replica and lag behind recent writes.
+	Replica bool
 }
 
 // TopicCat is an enum of topic categories.
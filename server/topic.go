@@ -10,12 +10,14 @@ package main
 
 import (
 	"errors"
+	"slices"
 	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/tinode/chat/server/auth"
+	"github.com/tinode/chat/server/drafty"
 	"github.com/tinode/chat/server/logs"
 	"github.com/tinode/chat/server/store"
 	"github.com/tinode/chat/server/store/types"
@@ -67,6 +69,15 @@ type Topic struct {
 	// Auxiliary set of key-value pairs
 	aux map[string]any
 
+	// IDs of pinned messages
+	pinned []int
+	// Lifetime of messages in seconds, 0 if messages never expire.
+	msgTTL int
+	// Slow mode: minimum interval in seconds between messages from one user, 0 if disabled.
+	slowMode int
+	// Number of messages a user may post at once in slow mode before the interval applies.
+	msgBurst int
+
 	// Topic's public data
 	public any
 	// Topic's trusted data
@@ -161,6 +172,9 @@ type perUserData struct {
 
 	// The user is a channel subscriber.
 	isChan bool
+
+	// Group topics in slow mode: the earliest time when the next message is counted against the interval.
+	slowModeNext time.Time
 }
 
 // perSubsData holds user's (on 'me' topic) cache of subscription data
@@ -407,6 +421,16 @@ func (t *Topic) handleMetaGet(msg *ClientComMessage, asUid types.Uid, asChan boo
 			logs.Warn.Printf("topic[%s] meta.Get.Aux failed: %s", t.name, err)
 		}
 	}
+	if msg.MetaWhat&constMsgMetaEdits != 0 {
+		if err := t.replyGetEdits(msg.sess, asUid, msg.Get.Edits, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Get.Edits failed: %s", t.name, err)
+		}
+	}
+	if msg.MetaWhat&constMsgMetaScheduled != 0 {
+		if err := t.replyGetScheduled(msg.sess, asUid, msg.Get.Scheduled, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Get.Scheduled failed: %s", t.name, err)
+		}
+	}
 }
 
 func (t *Topic) handleMetaSet(msg *ClientComMessage, asUid types.Uid, asChan bool, authLevel auth.Level) {
@@ -438,6 +462,16 @@ func (t *Topic) handleMetaSet(msg *ClientComMessage, asUid types.Uid, asChan boo
 			logs.Warn.Printf("topic[%s] meta.Set.Aux failed: %v", t.name, err)
 		}
 	}
+	if msg.MetaWhat&constMsgMetaMsg != 0 {
+		if err := t.replySetMsg(msg.sess, asUid, asChan, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Set.Msg failed: %v", t.name, err)
+		}
+	}
+	if msg.MetaWhat&constMsgMetaPin != 0 {
+		if err := t.replySetPin(msg.sess, asUid, asChan, msg); err != nil {
+			logs.Warn.Printf("topic[%s] meta.Set.Pin failed: %v", t.name, err)
+		}
+	}
 }
 
 func (t *Topic) handleMetaDel(msg *ClientComMessage, asUid types.Uid, asChan bool, authLevel auth.Level) {
@@ -451,6 +485,8 @@ func (t *Topic) handleMetaDel(msg *ClientComMessage, asUid types.Uid, asChan boo
 		err = t.replyDelTopic(msg.sess, asUid, msg)
 	case constMsgDelCred:
 		err = t.replyDelCred(msg.sess, asUid, authLevel, msg)
+	case constMsgDelScheduled:
+		err = t.replyDelScheduled(msg.sess, asUid, msg)
 	}
 
 	if err != nil {
@@ -623,10 +659,17 @@ func (t *Topic) handleClientMsg(msg *ClientComMessage) {
 		t.handlePubBroadcast(msg)
 	} else if msg.Note != nil {
 		t.handleNoteBroadcast(msg)
+	} else if msg.MsgExpiry {
+		t.deleteExpiredMessages()
 	} else {
 		// TODO(gene): maybe remove this panic.
 		logs.Err.Panic("topic: wrong client message type for broadcasting", t.name)
 	}
+
+	if (msg.Scheduled != "" || msg.MsgExpiry) && len(t.sessions) == 0 && t.cat != types.TopicCatSys {
+		// The topic may have been loaded just to handle a server-generated request. Let it expire if no one joins.
+		t.killTimer.Reset(idleMasterTopicTimeout)
+	}
 }
 
 // handleServerMsg is the top-level handler of messages generated at the server.
@@ -996,7 +1039,10 @@ func (t *Topic) sendSubNotifications(asUid types.Uid, sid, userAgent string) {
 
 // Saves a new message (defined by head, content and attachments) in the topic
 // in response to a client request (msg, asUid) and broadcasts it to the attached sessions.
-func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid, noEcho bool, attachments []string, head map[string]any, content any) error {
+// If parent is not zero, the message is a reply to the message with SeqId = parent.
+// The fids are IDs of already stored files to link to the message, e.g. attachments of a forwarded message.
+func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid, noEcho bool, attachments []string,
+	fids []string, parent int, head map[string]any, content any) error {
 	pud, userFound := t.perUser[asUid]
 	// Anyone is allowed to post to 'sys' topic.
 	if t.cat != types.TopicCatSys {
@@ -1019,15 +1065,17 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 	}
 
 	markedReadBySender := false
-	if err, unreadUpdated := store.Messages.Save(
-		&types.Message{
-			ObjHeader: types.ObjHeader{CreatedAt: msg.Timestamp},
-			SeqId:     t.lastID + 1,
-			Topic:     t.name,
-			From:      asUid.String(),
-			Head:      head,
-			Content:   content,
-		}, attachments, (pud.modeGiven & pud.modeWant).IsReader()); err != nil {
+	stored := &types.Message{
+		ObjHeader: types.ObjHeader{CreatedAt: msg.Timestamp},
+		SeqId:     t.lastID + 1,
+		Topic:     t.name,
+		Parent:    parent,
+		From:      asUid.String(),
+		Head:      head,
+		Content:   content,
+	}
+	if err, unreadUpdated := store.Messages.Save(stored, attachments, fids,
+		(pud.modeGiven & pud.modeWant).IsReader()); err != nil {
 		logs.Warn.Printf("topic[%s]: failed to save message: %v", t.name, err)
 		msg.sess.queueOut(ErrUnknown(msg.Id, t.original(asUid), msg.Timestamp))
 
@@ -1059,6 +1107,7 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 			SeqId:     t.lastID,
 			Head:      head,
 			Content:   content,
+			Thread:    parent,
 		},
 		// Internal-only values.
 		Id:        msg.Id,
@@ -1091,8 +1140,21 @@ func (t *Topic) saveAndBroadcastMessage(msg *ClientComMessage, asUid types.Uid,
 // This is a NON-proxy broadcast.
 func (t *Topic) handlePubBroadcast(msg *ClientComMessage) {
 	asUid := types.ParseUserId(msg.AsUser)
+
+	// A scheduled message is deleted once it's saved or rejected. It's kept if the topic is paused
+	// or the message fails to save: it will be delivered again when the claim expires.
+	keepScheduled := false
+	if msg.Scheduled != "" {
+		defer func() {
+			if !keepScheduled {
+				t.unscheduleMessage(msg.Scheduled)
+			}
+		}()
+	}
+
 	if t.isInactive() {
 		// Ignore broadcast - topic is paused or being deleted.
+		keepScheduled = true
 		msg.sess.queueOut(ErrLocked(msg.Id, t.original(asUid), msg.Timestamp))
 		return
 	}
@@ -1118,13 +1180,53 @@ func (t *Topic) handlePubBroadcast(msg *ClientComMessage) {
 		}
 	}
 
+	if msg.Pub.Forward != nil && (isCall || msg.Pub.Content != nil) {
+		// Forwarded message cannot be a call or have its own content.
+		msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
+		return
+	}
+
+	if msg.Pub.Thread != 0 && (isCall || msg.Pub.Thread < 0 || msg.Pub.Thread > t.lastID) {
+		// Calls cannot be replies; the parent message must exist.
+		msg.sess.queueOut(ErrMalformedReply(msg, types.TimeNow()))
+		return
+	}
+
+	if msg.Scheduled == "" {
+		// Messages delivered by the scheduler were counted when they were scheduled.
+		if wait := t.slowModeWait(asUid, msg.Timestamp); wait > 0 {
+			msg.sess.queueOut(ErrTooManyRequestsReply(msg, types.TimeNow(), wait))
+			return
+		}
+	}
+
+	if msg.Pub.DeliverAt != nil && msg.Pub.DeliverAt.After(msg.Timestamp) {
+		// Save the message for delivery at a later time.
+		if err := t.scheduleMessage(msg, asUid, isCall); err != nil {
+			logs.Warn.Printf("topic[%s]: failed to schedule message - %s", t.name, err)
+		}
+		return
+	}
+
 	// Save to DB at master topic.
 	var attachments []string
 	if msg.Extra != nil && len(msg.Extra.Attachments) > 0 {
 		attachments = msg.Extra.Attachments
 	}
 
-	if err := t.saveAndBroadcastMessage(msg, asUid, msg.Pub.NoEcho, attachments, msg.Pub.Head, msg.Pub.Content); err != nil {
+	head, content := msg.Pub.Head, msg.Pub.Content
+	var fids []string
+	if msg.Pub.Forward != nil {
+		// Copy the content and attachments of the forwarded message.
+		var err error
+		if head, content, fids, err = t.resolveForward(msg, asUid); err != nil {
+			return
+		}
+	}
+
+	if err := t.saveAndBroadcastMessage(msg, asUid, msg.Pub.NoEcho, attachments, fids, msg.Pub.Thread,
+		head, content); err != nil {
+		keepScheduled = err != types.ErrPermissionDenied
 		logs.Err.Printf("topic[%s]: failed to save messagge - %s", t.name, err)
 		return
 	}
@@ -1134,6 +1236,34 @@ func (t *Topic) handlePubBroadcast(msg *ClientComMessage) {
 	}
 }
 
+// slowModeWait checks if the user is permitted to post a message in slow mode. If so, the message
+// is counted and 0 is returned, otherwise it returns how long the user must wait before posting.
+// The user may post up to t.msgBurst messages at once, then one message per t.slowMode seconds.
+// Topic administrators are exempt.
+func (t *Topic) slowModeWait(asUid types.Uid, now time.Time) time.Duration {
+	if t.slowMode <= 0 {
+		return 0
+	}
+	pud, ok := t.perUser[asUid]
+	if !ok || (pud.modeGiven & pud.modeWant).IsAdmin() {
+		return 0
+	}
+
+	interval := time.Duration(t.slowMode) * time.Second
+	// Allowance for posting messages ahead of schedule.
+	allowance := time.Duration(max(t.msgBurst, 1)-1) * interval
+	next := pud.slowModeNext
+	if next.Before(now) {
+		next = now
+	}
+	if wait := next.Sub(now) - allowance; wait > 0 {
+		return wait
+	}
+	pud.slowModeNext = next.Add(interval)
+	t.perUser[asUid] = pud
+	return 0
+}
+
 // handleNoteBroadcast fans out {note} -> {info} messages to recipients in a master topic.
 // This is a NON-proxy broadcast (at master topic).
 func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
@@ -1175,6 +1305,20 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 		// Handle calls separately.
 		t.handleCallEvent(msg)
 		return
+	case "react":
+		// Filter out reactions from users with no 'R' permission and from channel readers.
+		if !mode.IsReader() || asChan {
+			return
+		}
+		t.handleReaction(msg, asUid)
+		return
+	case "vote":
+		// Filter out votes from users with no 'R' permission and from channel readers.
+		if !mode.IsReader() || asChan {
+			return
+		}
+		t.handlePollVote(msg, asUid)
+		return
 	}
 
 	var read, recv, unread, seq int
@@ -1269,6 +1413,97 @@ func (t *Topic) handleNoteBroadcast(msg *ClientComMessage) {
 	t.broadcastToSessions(info)
 }
 
+// handleReaction saves user's reaction to a message and broadcasts updated counts of reactions
+// to the message to topic subscribers.
+func (t *Topic) handleReaction(msg *ClientComMessage, asUid types.Uid) {
+	seq := msg.Note.SeqId
+	if err := store.Messages.React(t.name, asUid, seq, msg.Note.Reaction); err != nil {
+		logs.Warn.Printf("topic[%s]: failed to save reaction to %d: %v", t.name, seq, err)
+		return
+	}
+
+	reacts, err := store.Messages.GetReactions(t.name, types.ZeroUid,
+		&types.QueryOpt{IdRanges: []types.Range{{Low: seq}}})
+	if err != nil {
+		logs.Warn.Printf("topic[%s]: failed to load reactions to %d: %v", t.name, seq, err)
+		return
+	}
+
+	// Send updated counts to all sessions including the originating one.
+	t.broadcastToSessions(&ServerComMessage{
+		Info: &MsgServerInfo{
+			Topic:     msg.Original,
+			From:      msg.AsUser,
+			What:      "react",
+			SeqId:     seq,
+			Reactions: reactionsBySeq(reacts)[seq],
+		},
+		RcptTo:    msg.RcptTo,
+		AsUser:    msg.AsUser,
+		Timestamp: msg.Timestamp,
+		sess:      msg.sess,
+	})
+}
+
+// handlePollVote saves user's vote in a poll and broadcasts updated counts of votes
+// to sessions attached to the topic as {info what="vote"}.
+func (t *Topic) handlePollVote(msg *ClientComMessage, asUid types.Uid) {
+	seq := msg.Note.SeqId
+	if seq > t.lastID {
+		return
+	}
+
+	poll, err := store.Messages.Get(t.name, asUid, seq)
+	if err != nil || poll == nil || poll.DeletedAt != nil {
+		return
+	}
+
+	count, multi, err := drafty.PollOptions(poll.Content)
+	if err != nil || count == 0 {
+		// Not a poll.
+		return
+	}
+
+	// Validate the chosen options: must be distinct, within range, and single unless the poll is multiple choice.
+	if !multi && len(msg.Note.Vote) > 1 {
+		return
+	}
+	chosen := make(map[int]bool, len(msg.Note.Vote))
+	for _, opt := range msg.Note.Vote {
+		if opt < 0 || opt >= count || chosen[opt] {
+			return
+		}
+		chosen[opt] = true
+	}
+
+	if err := store.Messages.Vote(t.name, asUid, seq, msg.Note.Vote); err != nil {
+		logs.Warn.Printf("topic[%s]: failed to save vote in %d: %v", t.name, seq, err)
+		return
+	}
+
+	votes, err := store.Messages.GetVotes(t.name, types.ZeroUid,
+		&types.QueryOpt{IdRanges: []types.Range{{Low: seq}}})
+	if err != nil {
+		logs.Warn.Printf("topic[%s]: failed to load votes in %d: %v", t.name, seq, err)
+		return
+	}
+
+	// Send updated counts to all sessions including the originating one.
+	t.broadcastToSessions(&ServerComMessage{
+		Info: &MsgServerInfo{
+			Topic: msg.Original,
+			From:  msg.AsUser,
+			What:  "vote",
+			SeqId: seq,
+			Votes: votesBySeq(votes)[seq],
+		},
+		RcptTo:    msg.RcptTo,
+		AsUser:    msg.AsUser,
+		Timestamp: msg.Timestamp,
+		sess:      msg.sess,
+	})
+}
+
 // handlePresence fans out {pres} messages to recipients in topic.
 func (t *Topic) handlePresence(msg *ServerComMessage) {
 	what := t.procPresReq(msg.Pres.Src, msg.Pres.What, msg.Pres.WantReply)
@@ -1805,6 +2040,7 @@ func (t *Topic) thisUserSub(sess *Session, pkt *ClientComMessage, asUid types.Ui
 			if err := store.Topics.OwnerChange(t.name, asUid); err != nil {
 				return nil, err
 			}
+			auditRecord(auditOwnerChange, asUid, t.owner, t.name, sess.getRemoteAddr(), nil)
 			t.perUser[t.owner] = oldOwnerData
 			// Send presence notifications.
 			t.notifySubChange(t.owner, asUid, false,
@@ -2181,6 +2417,11 @@ func (t *Topic) replyGetDesc(sess *Session, asUid types.Uid, _ bool, opts *MsgGe
 			desc.DelId = max(pud.delID, t.delID)
 			desc.ReadSeqId = pud.readID
 			desc.RecvSeqId = max(pud.recvID, pud.readID)
+			desc.Pinned = t.pinned
+			desc.MsgTTL = t.msgTTL
+			if t.slowMode > 0 {
+				desc.SlowMode = &MsgSlowMode{Interval: t.slowMode, Burst: t.msgBurst}
+			}
 		} else {
 			// Send some sane value of touched.
 			desc.TouchedAt = &t.updated
@@ -2305,6 +2546,40 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 			return err
 		}
 
+		if set.Desc.MsgTTL != nil {
+			// Message TTL can be changed by the topic owner or by either party of a p2p topic.
+			if !(t.cat == types.TopicCatP2P ||
+				((t.cat == types.TopicCatGrp || t.cat == types.TopicCatSlf) && t.owner == asUid)) {
+				sess.queueOut(ErrPermissionDeniedReply(msg, now))
+				return errors.New("attempt to change message TTL by non-owner")
+			}
+			if *set.Desc.MsgTTL < 0 {
+				sess.queueOut(ErrMalformedReply(msg, now))
+				return errors.New("invalid message TTL")
+			}
+			if *set.Desc.MsgTTL != t.msgTTL {
+				core["MsgTTL"] = *set.Desc.MsgTTL
+				sendCommon = true
+			}
+		}
+
+		if slow := set.Desc.SlowMode; slow != nil {
+			// Slow mode can be configured by the owner of a group topic only.
+			if t.cat != types.TopicCatGrp || t.owner != asUid {
+				sess.queueOut(ErrPermissionDeniedReply(msg, now))
+				return errors.New("attempt to change slow mode by non-owner")
+			}
+			if slow.Interval < 0 || slow.Burst < 0 {
+				sess.queueOut(ErrMalformedReply(msg, now))
+				return errors.New("invalid slow mode settings")
+			}
+			if slow.Interval != t.slowMode || slow.Burst != t.msgBurst {
+				core["SlowMode"] = slow.Interval
+				core["MsgBurst"] = slow.Burst
+				sendCommon = true
+			}
+		}
+
 		sendPriv = assignGenericValues(sub, "Private", t.perUser[asUid].private, set.Desc.Private)
 	}
 
@@ -2362,6 +2637,13 @@ func (t *Topic) replySetDesc(sess *Session, asUid types.Uid, asChan bool,
 		// Assign per-session fnd.Public.
 		t.fndSetPublic(sess, core["Public"])
 	}
+	if ttl, ok := core["MsgTTL"]; ok {
+		t.msgTTL = ttl.(int)
+	}
+	if slow, ok := core["SlowMode"]; ok {
+		t.slowMode = slow.(int)
+		t.msgBurst = core["MsgBurst"].(int)
+	}
 
 	pud := t.perUser[asUid]
 	mode := pud.modeGiven & pud.modeWant
@@ -2769,7 +3051,7 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 	now := types.TimeNow()
 	toriginal := t.original(asUid)
 
-	if req != nil && (req.IfModifiedSince != nil || req.User != "" || req.Topic != "") {
+	if req != nil && (req.IfModifiedSince != nil || req.User != "" || req.Topic != "" || req.Thread < 0) {
 		sess.queueOut(ErrMalformedReply(msg, now))
 		return errors.New("invalid MsgGetOpts query")
 	}
@@ -2778,7 +3060,20 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 	count := 0
 	if userData := t.perUser[asUid]; (userData.modeGiven & userData.modeWant).IsReader() {
 		// Read messages from DB
-		messages, err := store.Messages.GetAll(t.name, asUid, msgOpts2storeOpts(req))
+		var messages []types.Message
+		var err error
+		opts := msgOpts2storeOpts(req)
+		if opts == nil {
+			opts = &types.QueryOpt{}
+		}
+		// Message history requested by the client may be served by a read replica.
+		opts.Replica = true
+		if req != nil && strings.TrimSpace(req.Search) != "" {
+			// Full-text search in message history.
+			messages, err = store.Messages.Search(t.name, asUid, strings.TrimSpace(req.Search), opts)
+		} else {
+			messages, err = store.Messages.GetAll(t.name, asUid, opts)
+		}
 		if err != nil {
 			sess.queueOut(ErrUnknownReply(msg, now))
 			return err
@@ -2788,6 +3083,41 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 		if messages != nil {
 			count = len(messages)
 			if count > 0 {
+				// Load reactions to the messages.
+				seqIds := make([]int, count)
+				for i := range messages {
+					seqIds[i] = messages[i].SeqId
+				}
+				sort.Ints(seqIds)
+				reacts, err := store.Messages.GetReactions(t.name, asUid,
+					&types.QueryOpt{IdRanges: types.SliceToRanges(seqIds)})
+				if err != nil {
+					// Not fatal: send messages without reactions.
+					logs.Warn.Printf("topic[%s]: failed to load reactions: %v", t.name, err)
+				}
+				reactions := reactionsBySeq(reacts)
+
+				// Load counts of votes in polls.
+				votes, err := store.Messages.GetVotes(t.name, asUid,
+					&types.QueryOpt{IdRanges: types.SliceToRanges(seqIds)})
+				if err != nil {
+					// Not fatal: send messages without votes.
+					logs.Warn.Printf("topic[%s]: failed to load votes: %v", t.name, err)
+				}
+				pollVotes := votesBySeq(votes)
+
+				// Load reply counts for messages which have replies.
+				threads, err := store.Messages.GetThreads(t.name,
+					&types.QueryOpt{IdRanges: types.SliceToRanges(seqIds)})
+				if err != nil {
+					// Not fatal: send messages without reply counts.
+					logs.Warn.Printf("topic[%s]: failed to load thread stats: %v", t.name, err)
+				}
+				threadsBySeq := make(map[int]*types.MessageThread, len(threads))
+				for i := range threads {
+					threadsBySeq[threads[i].SeqId] = &threads[i]
+				}
+
 				outgoingMessages := make([]*ServerComMessage, count)
 				for i := range messages {
 					mm := &messages[i]
@@ -2804,8 +3134,15 @@ func (t *Topic) replyGetData(sess *Session, asUid types.Uid, asChan bool, req *M
 							From:      from,
 							Timestamp: mm.CreatedAt,
 							Content:   mm.Content,
+							Reactions: reactions[mm.SeqId],
+							Votes:     pollVotes[mm.SeqId],
+							Thread:    mm.Parent,
 						},
 					}
+					if thread := threadsBySeq[mm.SeqId]; thread != nil {
+						outgoingMessages[i].Data.Replies = thread.Replies
+						outgoingMessages[i].Data.LastReply = &thread.LastReplyAt
+					}
 				}
 				sess.queueOutBatch(outgoingMessages)
 			}
@@ -3065,6 +3402,11 @@ func (t *Topic) replySetCred(sess *Session, asUid types.Uid, authLevel auth.Leve
 		t.presSubsOnline("tags", "", nilPresParams, nilPresFilters, "")
 	}
 
+	if err == nil {
+		auditRecord(auditCredAdd, asUid, asUid, "", sess.getRemoteAddr(),
+			map[string]any{"methods": []string{set.Cred.Method}, "confirmed": set.Cred.Response != ""})
+	}
+
 	sess.queueOut(decodeStoreErrorExplicitTs(err, set.Id, t.original(asUid), now, msg.Timestamp, nil))
 
 	return err
@@ -3125,6 +3467,190 @@ func (t *Topic) replySetAux(sess *Session, asUid types.Uid, msg *ClientComMessag
 	return nil
 }
 
+// replySetPin pins or unpins a message in response to set.pin request.
+func (t *Topic) replySetPin(sess *Session, asUid types.Uid, asChan bool, msg *ClientComMessage) error {
+	now := types.TimeNow()
+
+	if t.cat != types.TopicCatP2P && t.cat != types.TopicCatGrp {
+		sess.queueOut(ErrOperationNotAllowedReply(msg, now))
+		return errors.New("invalid topic category to pin messages")
+	}
+
+	pud := t.perUser[asUid]
+	mode := pud.modeGiven & pud.modeWant
+	if asChan || !mode.IsAdmin() {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("set.pin: permission denied")
+	}
+
+	pin := msg.Set.Pin
+	if pin.SeqId <= 0 || pin.SeqId > t.lastID {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("set.pin: invalid message ID")
+	}
+
+	var pinned []int
+	idx := slices.Index(t.pinned, pin.SeqId)
+	if pin.Unpin {
+		if idx < 0 {
+			sess.queueOut(InfoNotModifiedReply(msg, now))
+			return nil
+		}
+		pinned = slices.Delete(slices.Clone(t.pinned), idx, idx+1)
+	} else {
+		if idx >= 0 {
+			sess.queueOut(InfoNotModifiedReply(msg, now))
+			return nil
+		}
+		if len(t.pinned) >= maxPinnedMessages {
+			sess.queueOut(ErrPolicyReply(msg, now))
+			return errors.New("set.pin: too many pinned messages")
+		}
+		pinned = append(slices.Clone(t.pinned), pin.SeqId)
+	}
+
+	if err := store.Topics.Update(t.name, map[string]any{"Pinned": types.IntSlice(pinned), "UpdatedAt": now}); err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, t.original(asUid), now, msg.Timestamp, nil))
+		return err
+	}
+	t.pinned = pinned
+	t.updated = now
+
+	// Notify all readers on 'me' except the user who made the change.
+	filter := &presFilters{excludeUser: asUid.UserId(), filterIn: types.ModeRead}
+	t.presSubsOffline("upd", nilPresParams, filter, filter, sess.sid, false)
+	// Notify user's other sessions.
+	t.presSingleUserOffline(asUid, mode, "upd", nilPresParams, sess.sid, false)
+
+	sess.queueOut(NoErrParamsReply(msg, now, map[string]any{"pinned": pinned}))
+
+	return nil
+}
+
+// unpinDeleted removes hard-deleted messages from the list of pinned messages.
+func (t *Topic) unpinDeleted(ranges []types.Range) {
+	pinned := slices.DeleteFunc(slices.Clone(t.pinned), func(seq int) bool {
+		for _, r := range ranges {
+			if seq == r.Low || (seq > r.Low && seq < r.Hi) {
+				return true
+			}
+		}
+		return false
+	})
+	if len(pinned) == len(t.pinned) {
+		return
+	}
+
+	if err := store.Topics.Update(t.name, map[string]any{"Pinned": types.IntSlice(pinned)}); err != nil {
+		// The messages are gone regardless, the list will be saved with the next change.
+		logs.Warn.Printf("topic[%s] failed to unpin deleted messages: %v", t.name, err)
+	}
+	t.pinned = pinned
+}
+
+// Message headers which the client may set when editing a message.
+var editableMsgHeaders = map[string]bool{"mime": true, "mentions": true, "webrtc": true}
+
+// replySetMsg edits a previously published message in response to set.msg request.
+// The previous version of the message is saved as a revision.
+func (t *Topic) replySetMsg(sess *Session, asUid types.Uid, asChan bool, msg *ClientComMessage) error {
+	now := types.TimeNow()
+
+	if t.cat != types.TopicCatP2P && t.cat != types.TopicCatGrp && t.cat != types.TopicCatSlf {
+		sess.queueOut(ErrOperationNotAllowedReply(msg, now))
+		return errors.New("invalid topic category to edit messages")
+	}
+
+	if asChan || t.isReadOnly() {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("set.msg: topic is read-only")
+	}
+
+	if pud := t.perUser[asUid]; !(pud.modeGiven & pud.modeWant).IsWriter() {
+		sess.queueOut(ErrPermissionDeniedReply(msg, now))
+		return errors.New("set.msg: permission denied")
+	}
+
+	edit := msg.Set.Msg
+	if edit.SeqId <= 0 || edit.SeqId > t.lastID || edit.Content == nil {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("set.msg: invalid message ID or content")
+	}
+
+	var head types.KVMap
+	if edit.Head != nil {
+		head = types.KVMap{}
+		for k, v := range edit.Head {
+			// Other headers, such as "sender", "forwarded" or "rev", are set by the server.
+			if editableMsgHeaders[k] {
+				head[k] = v
+			}
+		}
+	}
+
+	updated, err := store.Messages.Edit(t.name, asUid, edit.SeqId, head, edit.Content)
+	if err != nil {
+		sess.queueOut(decodeStoreErrorExplicitTs(err, msg.Id, t.original(asUid), now, msg.Timestamp, nil))
+		return err
+	}
+
+	// Notify online readers, excluding the session making the change.
+	t.presSubsOnline("edit", asUid.UserId(), &presParams{seqID: edit.SeqId, actor: asUid.UserId()},
+		&presFilters{filterIn: types.ModeRead}, sess.sid)
+
+	sess.queueOut(NoErrParamsReply(msg, now, map[string]any{"seq": edit.SeqId, "rev": updated.Head["rev"]}))
+
+	return nil
+}
+
+// replyGetEdits is a response to a get[what=edits] request: load previous versions of edited messages,
+// send them to a session as {meta}.
+func (t *Topic) replyGetEdits(sess *Session, asUid types.Uid, req *MsgGetOpts, msg *ClientComMessage) error {
+	now := types.TimeNow()
+	toriginal := t.original(asUid)
+
+	if req != nil && (req.IfModifiedSince != nil || req.User != "" || req.Topic != "") {
+		sess.queueOut(ErrMalformedReply(msg, now))
+		return errors.New("invalid MsgGetOpts query")
+	}
+
+	// Check if the user has permission to read the topic data.
+	if userData := t.perUser[asUid]; (userData.modeGiven & userData.modeWant).IsReader() {
+		revs, err := store.Messages.GetEdits(t.name, asUid, msgOpts2storeOpts(req))
+		if err != nil {
+			sess.queueOut(ErrUnknownReply(msg, now))
+			return err
+		}
+
+		if len(revs) > 0 {
+			edits := make([]MsgEditRevision, 0, len(revs))
+			for i := range revs {
+				rev := &revs[i]
+				edits = append(edits, MsgEditRevision{
+					SeqId:     rev.SeqId,
+					Rev:       rev.Rev,
+					Timestamp: rev.CreatedAt,
+					Head:      rev.Head,
+					Content:   rev.Content,
+				})
+			}
+			sess.queueOut(&ServerComMessage{
+				Meta: &MsgServerMeta{
+					Id:        msg.Id,
+					Topic:     toriginal,
+					Edits:     edits,
+					Timestamp: &now,
+				},
+			})
+			return nil
+		}
+	}
+
+	sess.queueOut(NoContentParams(msg.Id, toriginal, now, msg.Timestamp, map[string]string{"what": "edits"}))
+
+	return nil
+}
+
 // replyGetDel is a response to a get[what=del] request: load a list of deleted message ids, send them to
 // a session as {meta}
 // response goes to a single session rather than all sessions in a topic
@@ -3258,6 +3784,8 @@ func (t *Topic) replyDelMsg(sess *Session, asUid types.Uid, asChan bool, msg *Cl
 	t.delID++
 	dr := rangeDeserialize(ranges)
 	if del.Hard {
+		t.unpinDeleted(ranges)
+
 		for uid, pud := range t.perUser {
 			pud.delID = t.delID
 			t.perUser[uid] = pud
@@ -3332,6 +3860,10 @@ func (t *Topic) replyDelCred(sess *Session, asUid types.Uid, authLvl auth.Level,
 		sess.queueOut(InfoNoActionReply(msg, now))
 		return nil
 	}
+	if err == nil {
+		auditRecord(auditCredDel, asUid, asUid, "", sess.getRemoteAddr(),
+			map[string]any{"methods": []string{del.Cred.Method}})
+	}
 	sess.queueOut(decodeStoreErrorExplicitTs(err, del.Id, del.Topic, now, incomingReqTs, nil))
 	return err
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -49,6 +50,7 @@ type TopicTestHelper struct {
 	uu *mock_store.MockUsersPersistenceInterface
 	tt *mock_store.MockTopicsPersistenceInterface
 	ss *mock_store.MockSubsPersistenceInterface
+	ff *mock_store.MockFilePersistenceInterface
 }
 
 func (b *TopicTestHelper) finish() {
@@ -94,10 +96,12 @@ func (b *TopicTestHelper) setUp(t *testing.T, numUsers int, cat types.TopicCat,
 	b.uu = mock_store.NewMockUsersPersistenceInterface(b.ctrl)
 	b.tt = mock_store.NewMockTopicsPersistenceInterface(b.ctrl)
 	b.ss = mock_store.NewMockSubsPersistenceInterface(b.ctrl)
+	b.ff = mock_store.NewMockFilePersistenceInterface(b.ctrl)
 	store.Messages = b.mm
 	store.Users = b.uu
 	store.Topics = b.tt
 	store.Subs = b.ss
+	store.Files = b.ff
 	// Sessions.
 	b.sessions = make([]*Session, b.numUsers)
 	b.results = make([]*responses, b.numUsers)
@@ -164,6 +168,7 @@ func (b *TopicTestHelper) tearDown() {
 	store.Users = nil
 	store.Topics = nil
 	store.Subs = nil
+	store.Files = nil
 	b.ctrl.Finish()
 }
 
@@ -198,7 +203,7 @@ func TestHandleBroadcastDataP2P(t *testing.T) {
 	helper := TopicTestHelper{}
 	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test" /*attach=*/, true)
 	defer helper.tearDown()
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
@@ -283,7 +288,7 @@ func TestHandleBroadcastCall(t *testing.T) {
 	globals.iceServers = []iceServer{{Username: "dummy"}}
 	helper.topic.lastID = 5
 	defer helper.tearDown()
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
@@ -395,7 +400,7 @@ func TestHandleBroadcastDataGroup(t *testing.T) {
 		store.Messages = nil
 		helper.tearDown()
 	}()
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	// User 3 isn't allowed to read.
 	pu3 := helper.topic.perUser[helper.uids[3]]
@@ -555,7 +560,7 @@ func TestHandleBroadcastDataDbError(t *testing.T) {
 	defer helper.tearDown()
 
 	// DB returns an error.
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(types.ErrInternal, false)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(types.ErrInternal, false)
 
 	// Make test message.
 	from := helper.uids[0].UserId()
@@ -652,291 +657,515 @@ func TestHandleBroadcastDataInactiveTopic(t *testing.T) {
 	}
 }
 
-func TestHandleBroadcastInfoP2P(t *testing.T) {
-	topicName := "usrP2P"
-	numUsers := 2
-	readId := 8
+func TestHandleBroadcastDataThreadReply(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 3
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 8.
-	from := helper.uids[0]
-	to := helper.uids[1]
-
-	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
+	helper.topic.lastID = 5
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, attachments, fids []string, readBySender bool) (error, bool) {
+			if msg.Parent != 3 {
+				t.Errorf("Saved message parent: expected 3, got %d", msg.Parent)
+			}
+			return nil, true
+		})
 
+	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
-		AsUser: from.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
+		AsUser:   from,
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Content: "reply",
+			NoEcho:  true,
+			Thread:  3,
 		},
 		sess: helper.sessions[0],
 	}
+
 	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Check for errors from testHubLoop
 	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
 		t.Fatal(errorMsgs[0].Ctrl.Text)
 	}
 
-	// Topic metadata.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != readId {
-		t.Errorf("perUser[%s].readID: expected %d, found %d.", from.UserId(), readId, actualReadId)
-	}
-	// Server messages.
-	if len(helper.results[0].messages) != 0 {
-		t.Errorf("Session 0 isn't expected to receive any messages. Received %d", len(helper.results[0].messages))
-	}
-	if len(helper.results[1].messages) != 1 {
-		t.Fatalf("Session 1 is expected to receive exactly 1 message. Received %d", len(helper.results[1].messages))
+	if helper.topic.lastID != 6 {
+		t.Errorf("Topic.lastID: expected 6, found %d", helper.topic.lastID)
 	}
-	res := helper.results[1].messages[0].(*ServerComMessage)
-	if res.Info != nil {
-		info := res.Info
-		// Topic name will be fixed (to -> from).
-		if info.Topic != from.UserId() {
-			t.Errorf("Info.Topic: expected '%s', found '%s'", to.UserId(), info.Topic)
-		}
-		if info.From != from.UserId() {
-			t.Errorf("Info.From: expected '%s', found '%s'", from.UserId(), info.From)
-		}
-		if info.What != "read" {
-			t.Errorf("Info.What: expected 'read', found '%s'", info.What)
+	for i := 1; i < numUsers; i++ {
+		m := helper.results[i]
+		if len(m.messages) != 1 {
+			t.Fatalf("Uid%d: expected 1 message, got %d", i, len(m.messages))
 		}
-		if info.SeqId != readId {
-			t.Errorf("Info.SeqId: expected %d, found %d", readId, info.SeqId)
+		r := m.messages[0].(*ServerComMessage)
+		if r.Data == nil {
+			t.Fatalf("Uid%d: expected a data message", i)
 		}
-	} else {
-		t.Error("Session message is expected to contain `info` section.")
-	}
-	// Checking presence messages routed through hub helper. These are intended for offline sessions.
-	if len(helper.hubMessages) != 2 {
-		t.Fatalf("Hubhelper.route expected exactly two recipients routed via hubhelper. Found %d", len(helper.hubMessages))
-	}
-	for i, uid := range helper.uids {
-		if routedMsgs, ok := helper.hubMessages[uid.UserId()]; ok {
-			expectedSrc := helper.uids[i^1].UserId()
-			for _, s := range routedMsgs {
-				if s.Info != nil {
-					// Info messages for offline sessions.
-					info := s.Info
-					if info.Topic != "me" {
-						t.Errorf("Uid %s: info.topic is expected to be 'me', got %s", uid.UserId(), info.Topic)
-					}
-					if info.Src != expectedSrc {
-						t.Errorf("Uid %s: info.src expected: %s, found: %s", uid.UserId(), expectedSrc, info.Src)
-					}
-					if info.What != "read" {
-						t.Error("info.what expected to be 'read'")
-					}
-					if info.SeqId != readId {
-						t.Errorf("info.seq: expected %d, found %d", readId, info.SeqId)
-					}
-				} else if s.Pres != nil {
-					// Pres messages for offline sessions.
-					pres := s.Pres
-					if pres.Topic != "me" {
-						t.Errorf("Uid %s: pres.topic is expected to be 'me', got %s", uid.UserId(), pres.Topic)
-					}
-					if pres.What != "read" {
-						t.Error("pres.what expected to be 'read'")
-					}
-					if pres.Src != expectedSrc {
-						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), expectedSrc, pres.Src)
-					}
-					if pres.SeqId != readId {
-						t.Errorf("pres.seq: expected %d, found %d", readId, pres.SeqId)
-					}
-				} else {
-					t.Error("Hub messages must be either `info` or `pres`.")
-				}
-			}
-		} else {
-			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		if r.Data.Thread != 3 {
+			t.Errorf("Uid%d: expected thread 3, got %d", i, r.Data.Thread)
 		}
 	}
 }
 
-func TestHandleBroadcastInfoBogusNotification(t *testing.T) {
-	topicName := "usrP2P"
+func TestHandleBroadcastDataInvalidThread(t *testing.T) {
+	topicName := "grp-test"
 	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 11
-	from := helper.uids[0]
-	to := helper.uids[1]
+	helper.topic.lastID = 5
 
+	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "read",
-			SeqId: readId,
+		AsUser:   from,
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Content: "reply",
+			Thread:  6,
 		},
 		sess: helper.sessions[0],
 	}
+
 	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Check for errors from testHubLoop
-	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
-		t.Fatal(errorMsgs[0].Ctrl.Text)
+	if len(helper.results[0].messages) != 1 {
+		t.Fatalf("User 1 is expected to receive one message vs %d received.", len(helper.results[0].messages))
 	}
-
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	em := helper.results[0].messages[0].(*ServerComMessage)
+	if em.Ctrl == nil || em.Ctrl.Code != http.StatusBadRequest {
+		t.Errorf("User 1: expected ctrl.code 400, received %+v", em.Ctrl)
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("User 2 is not expected to receive any messages, %d received.", len(helper.results[1].messages))
 	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if helper.topic.lastID != 5 {
+		t.Errorf("Topic.lastID: expected 5, found %d", helper.topic.lastID)
 	}
 }
 
-func TestHandleBroadcastInfoFilterOutRecvWithoutRPermission(t *testing.T) {
-	topicName := "usrP2P"
+func TestHandleBroadcastDataForward(t *testing.T) {
+	topicName := "grp-test"
 	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
+	helper.topic.lastID = 5
 
-	// Revoke R permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.modeGiven = types.ModeWrite | types.ModeJoin
-	helper.topic.perUser[from] = pud
+	srcUid := types.Uid(1000)
+	srcTopic := helper.uids[0].P2PName(srcUid)
+	fids := []string{"file1", "file2"}
+	helper.ss.EXPECT().Get(srcTopic, helper.uids[0], false).Return(&types.Subscription{
+		ModeWant:  types.ModeCP2P,
+		ModeGiven: types.ModeCP2P,
+	}, nil)
+	helper.mm.EXPECT().Get(srcTopic, helper.uids[0], 7).Return(&types.Message{
+		SeqId:   7,
+		Topic:   srcTopic,
+		From:    srcUid.String(),
+		Head:    map[string]any{"mime": "text/x-drafty", "reply": ":3"},
+		Content: "original",
+	}, nil)
+	helper.ff.EXPECT().GetMessageAttachments(srcTopic, 7).Return(fids, nil)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, attachments, linked []string, readBySender bool) (error, bool) {
+			if msg.Content != "original" {
+				t.Errorf("Saved message content: expected 'original', got %v", msg.Content)
+			}
+			// The name of the p2p topic is not revealed.
+			if msg.Head["forwarded"] != true || msg.Head["mime"] != "text/x-drafty" ||
+				msg.Head["reply"] != nil {
+				t.Errorf("Saved message head: unexpected value %v", msg.Head)
+			}
+			if !reflect.DeepEqual(linked, fids) {
+				t.Errorf("Saved message files: expected %v, got %v", fids, linked)
+			}
+			return nil, true
+		})
 
 	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "recv",
-			SeqId: readId,
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			NoEcho:  true,
+			Forward: &MsgForward{Topic: srcUid.UserId(), SeqId: 7},
 		},
 		sess: helper.sessions[0],
 	}
+
 	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Check for errors from testHubLoop
-	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
-		t.Fatal(errorMsgs[0].Ctrl.Text)
-	}
-
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	if helper.topic.lastID != 6 {
+		t.Errorf("Topic.lastID: expected 6, found %d", helper.topic.lastID)
 	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
+	if len(helper.results[1].messages) != 1 {
+		t.Fatalf("Uid1: expected 1 message, got %d", len(helper.results[1].messages))
 	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if r := helper.results[1].messages[0].(*ServerComMessage); r.Data == nil || r.Data.Content != "original" {
+		t.Errorf("Uid1: expected forwarded data message, got %+v", r)
 	}
 }
 
-func TestHandleBroadcastInfoFilterOutKpWithoutWPermission(t *testing.T) {
-	topicName := "usrP2P"
+func TestHandleBroadcastDataForwardChannel(t *testing.T) {
+	topicName := "grp-test"
 	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
-	from := helper.uids[0]
-	to := helper.uids[1]
+	helper.topic.lastID = 5
 
-	// Revoke W permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.modeGiven = types.ModeRead | types.ModeJoin
-	helper.topic.perUser[from] = pud
+	// Channel readers are subscribed to 'chnXXX', the messages are stored in 'grpXXX'.
+	helper.ss.EXPECT().Get("chnSource", helper.uids[0], false).Return(&types.Subscription{
+		ModeWant:  types.ModeCChnReader,
+		ModeGiven: types.ModeCChnReader,
+	}, nil)
+	helper.mm.EXPECT().Get("grpSource", helper.uids[0], 7).Return(&types.Message{
+		SeqId:   7,
+		Topic:   "grpSource",
+		Head:    map[string]any{"mime": "text/x-drafty"},
+		Content: "original",
+	}, nil)
+	helper.ff.EXPECT().GetMessageAttachments("grpSource", 7).Return(nil, nil)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(msg *types.Message, attachments, linked []string, readBySender bool) (error, bool) {
+			if msg.Head["forwarded"] != "chnSource:7" {
+				t.Errorf("Saved message head: expected the channel as the origin, got %v", msg.Head)
+			}
+			return nil, true
+		})
 
 	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
-		Note: &MsgClientNote{
-			Topic: to.UserId(),
-			What:  "kp",
-			SeqId: readId,
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			NoEcho:  true,
+			Forward: &MsgForward{Topic: "chnSource", SeqId: 7},
 		},
 		sess: helper.sessions[0],
 	}
+
 	helper.topic.handleClientMsg(msg)
 	helper.finish()
 
-	// Check for errors from testHubLoop
-	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
-		t.Fatal(errorMsgs[0].Ctrl.Text)
-	}
-
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
-		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
-	}
-	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
-		}
-	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	if helper.topic.lastID != 6 {
+		t.Errorf("Topic.lastID: expected 6, found %d", helper.topic.lastID)
 	}
 }
 
-func TestHandleBroadcastInfoDuplicatedRead(t *testing.T) {
-	topicName := "usrP2P"
+func TestHandleBroadcastDataForwardNotReader(t *testing.T) {
+	topicName := "grp-test"
 	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	// Pretend we have 10 messages.
-	helper.topic.lastID = 10
-	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	helper.topic.lastID = 5
+
+	// The user is subscribed to the source topic without the R permission.
+	helper.ss.EXPECT().Get("grpSource", helper.uids[0], false).Return(&types.Subscription{
+		ModeWant:  types.ModeCPublic,
+		ModeGiven: types.ModeJoin | types.ModeWrite,
+	}, nil)
+
+	msg := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Topic:   topicName,
+			Forward: &MsgForward{Topic: "grpSource", SeqId: 7},
+		},
+		sess: helper.sessions[0],
+	}
+
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusForbidden})
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("Uid1: expected no messages, got %d", len(helper.results[1].messages))
+	}
+	if helper.topic.lastID != 5 {
+		t.Errorf("Topic.lastID: expected 5, found %d", helper.topic.lastID)
+	}
+}
+
+func TestHandleBroadcastDataForwardInvalid(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 5
+
+	for _, pub := range []*MsgClientPub{
+		// Forwarded message cannot have its own content.
+		{Topic: topicName, Content: "test", Forward: &MsgForward{Topic: "grpSource", SeqId: 7}},
+		// Invalid source topic.
+		{Topic: topicName, Forward: &MsgForward{Topic: "fnd", SeqId: 7}},
+		{Topic: topicName, Forward: &MsgForward{Topic: helper.uids[0].UserId(), SeqId: 7}},
+		// Invalid SeqId.
+		{Topic: topicName, Forward: &MsgForward{Topic: "grpSource"}},
+	} {
+		helper.topic.handleClientMsg(&ClientComMessage{
+			AsUser:   helper.uids[0].UserId(),
+			Original: topicName,
+			Pub:      pub,
+			sess:     helper.sessions[0],
+		})
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest, http.StatusBadRequest,
+		http.StatusBadRequest, http.StatusBadRequest})
+	if helper.topic.lastID != 5 {
+		t.Errorf("Topic.lastID: expected 5, found %d", helper.topic.lastID)
+	}
+}
+
+func TestHandleBroadcastDataSlowMode(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+	helper.topic.slowMode = 60
+	helper.topic.msgBurst = 2
+
+	// User 1 is a regular subscriber, user 0 is the owner and exempt from slow mode.
+	pud := helper.topic.perUser[helper.uids[1]]
+	pud.modeWant = types.ModeCPublic
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[helper.uids[1]] = pud
+
+	// Two messages from user 1 and one from user 0 are saved.
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true).Times(3)
+
+	now := types.TimeNow()
+	for _, i := range []int{1, 1, 1, 0} {
+		helper.topic.handleClientMsg(&ClientComMessage{
+			AsUser:    helper.uids[i].UserId(),
+			Original:  topicName,
+			Pub:       &MsgClientPub{Topic: topicName, Content: "test", NoEcho: true},
+			Timestamp: now,
+			sess:      helper.sessions[i],
+		})
+	}
+	helper.finish()
+
+	if helper.topic.lastID != 3 {
+		t.Errorf("Topic.lastID: expected 3, found %d", helper.topic.lastID)
+	}
+	// Uid1 receives a rejection of the third message and the message from Uid0.
+	if len(helper.results[1].messages) != 2 {
+		t.Fatalf("Uid1: expected 2 messages, got %d", len(helper.results[1].messages))
+	}
+	r := helper.results[1].messages[0].(*ServerComMessage)
+	if r.Ctrl == nil || r.Ctrl.Code != http.StatusTooManyRequests {
+		t.Fatalf("Uid1: expected ctrl 429, got %+v", r)
+	}
+	if after, _ := r.Ctrl.Params.(map[string]any)["retry-after"].(int); after != 60 {
+		t.Errorf("Retry after: expected 60, got %v", r.Ctrl.Params)
+	}
+	if r := helper.results[1].messages[1].(*ServerComMessage); r.Data == nil {
+		t.Errorf("Uid1: expected data message, got %+v", r)
+	}
+	if len(helper.results[0].messages) != 2 {
+		t.Errorf("Uid0: expected 2 messages, got %d", len(helper.results[0].messages))
+	}
+}
+
+func TestHandleBroadcastDataScheduled(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 5
+
+	now := types.TimeNow()
+	deliverAt := now.Add(time.Hour)
+	helper.mm.EXPECT().GetScheduled(topicName, helper.uids[0], gomock.Any()).Return(nil, nil)
+	helper.mm.EXPECT().Schedule(gomock.Any()).DoAndReturn(func(smsg *types.ScheduledMessage) error {
+		if smsg.Topic != topicName || smsg.From != helper.uids[0].String() || smsg.Parent != 3 ||
+			smsg.Content != "later" || !smsg.DeliverAt.Equal(deliverAt) {
+			t.Errorf("Scheduled message: unexpected value %+v", smsg)
+		}
+		smsg.Id = "sched1"
+		return nil
+	})
+
+	msg := &ClientComMessage{
+		AsUser:   helper.uids[0].UserId(),
+		Original: topicName,
+		Pub: &MsgClientPub{
+			Id:        "id1",
+			Topic:     topicName,
+			Content:   "later",
+			Thread:    3,
+			DeliverAt: &deliverAt,
+		},
+		Id:        "id1",
+		Timestamp: now,
+		sess:      helper.sessions[0],
+	}
+
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	if helper.topic.lastID != 5 {
+		t.Errorf("Topic.lastID: expected 5, found %d", helper.topic.lastID)
+	}
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusAccepted})
+	if params, ok := helper.results[0].messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any); !ok ||
+		params["sched"] != "sched1" {
+		t.Errorf("Ctrl params: expected schedule ID, found %v", params)
+	}
+	if len(helper.results[1].messages) != 0 {
+		t.Errorf("Uid1: expected no messages, got %d", len(helper.results[1].messages))
+	}
+}
+
+func TestHandleBroadcastDataScheduledNotAllowed(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	// User 1 cannot publish.
+	pud := helper.topic.perUser[helper.uids[1]]
+	pud.modeGiven = types.ModeRead
+	helper.topic.perUser[helper.uids[1]] = pud
+	// User 2 has too many scheduled messages already.
+	helper.mm.EXPECT().GetScheduled(topicName, helper.uids[2], gomock.Any()).
+		Return(make([]types.ScheduledMessage, maxScheduledMessages), nil)
+
+	now := types.TimeNow()
+	deliverAt := now.Add(time.Hour)
+	for i, uid := range helper.uids {
+		msg := &ClientComMessage{
+			AsUser:   uid.UserId(),
+			Original: topicName,
+			Pub: &MsgClientPub{
+				Id:        "id1",
+				Topic:     topicName,
+				Content:   "later",
+				DeliverAt: &deliverAt,
+			},
+			Id:        "id1",
+			Timestamp: now,
+			sess:      helper.sessions[i],
+		}
+		if i == 0 {
+			// Out-of-band attachments cannot be scheduled.
+			msg.Extra = &MsgClientExtra{Attachments: []string{"/v0/file/s/abcdef12345.jpg"}}
+		}
+		helper.topic.handleClientMsg(msg)
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusUnprocessableEntity})
+	registerSessionVerifyOutputs(t, helper.results[1], []int{http.StatusForbidden})
+	registerSessionVerifyOutputs(t, helper.results[2], []int{http.StatusUnprocessableEntity})
+}
+
+func TestHandleBroadcastDataScheduledDelivery(t *testing.T) {
+	topicName := "grp-test"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	// User 1 cannot publish.
+	pud := helper.topic.perUser[helper.uids[1]]
+	pud.modeGiven = types.ModeRead
+	helper.topic.perUser[helper.uids[1]] = pud
+
+	// The first message fails to save and is kept for another attempt. The second message is deleted
+	// once saved, the third one once rejected.
+	gomock.InOrder(
+		helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(types.ErrInternal, false),
+		helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true),
+		helper.mm.EXPECT().DeleteScheduled(topicName, types.ZeroUid, "sched2").Return(nil),
+		helper.mm.EXPECT().DeleteScheduled(topicName, types.ZeroUid, "sched3").Return(nil),
+	)
+
+	now := types.TimeNow()
+	for i, id := range []string{"sched1", "sched2", "sched3"} {
+		uid := helper.uids[i/2]
+		helper.topic.handleClientMsg(&ClientComMessage{
+			Pub:       &MsgClientPub{Topic: topicName, Content: "later"},
+			Original:  topicName,
+			RcptTo:    topicName,
+			AsUser:    uid.UserId(),
+			AuthLvl:   int(auth.LevelAuth),
+			Timestamp: now,
+			Scheduled: id,
+			init:      true,
+		})
+	}
+	helper.finish()
+
+	if helper.topic.lastID != 1 {
+		t.Errorf("Topic.lastID: expected 1, found %d", helper.topic.lastID)
+	}
+}
+
+func TestReplyDelScheduled(t *testing.T) {
+	topicName := "grp-test"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 1, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	gomock.InOrder(
+		helper.mm.EXPECT().DeleteScheduled(topicName, uid, "sched1").Return(nil),
+		helper.mm.EXPECT().DeleteScheduled(topicName, uid, "sched1").Return(types.ErrNotFound),
+	)
+
+	for range 2 {
+		msg := &ClientComMessage{
+			Del: &MsgClientDel{
+				Id:    "id1",
+				Topic: topicName,
+				What:  "scheduled",
+				Sched: "sched1",
+			},
+			AsUser:   uid.UserId(),
+			MetaWhat: constMsgDelScheduled,
+			sess:     helper.sessions[0],
+		}
+		helper.topic.handleMetaDel(msg, uid, false, auth.LevelAuth)
+	}
+	helper.finish()
+
+	// Deleted, then already gone.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusOK, http.StatusNotFound})
+}
+
+func TestHandleBroadcastInfoP2P(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
 	readId := 8
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 8.
 	from := helper.uids[0]
 	to := helper.uids[1]
 
-	// Revoke R permission from the sender.
-	pud := helper.topic.perUser[from]
-	pud.readID = 8
-	helper.topic.perUser[from] = pud
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(nil)
 
 	msg := &ClientComMessage{
-		AsUser:   from.UserId(),
-		Original: to.UserId(),
+		AsUser: from.UserId(),
 		Note: &MsgClientNote{
 			Topic: to.UserId(),
 			What:  "read",
@@ -952,24 +1181,85 @@ func TestHandleBroadcastInfoDuplicatedRead(t *testing.T) {
 		t.Fatal(errorMsgs[0].Ctrl.Text)
 	}
 
-	// Read id should not be updated.
-	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 8 {
-		t.Errorf("perUser[%s].readID: expected 8, found %d.", from.UserId(), actualReadId)
+	// Topic metadata.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != readId {
+		t.Errorf("perUser[%s].readID: expected %d, found %d.", from.UserId(), readId, actualReadId)
 	}
 	// Server messages.
-	for i, r := range helper.results {
-		if numMessages := len(r.messages); numMessages != 0 {
-			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+	if len(helper.results[0].messages) != 0 {
+		t.Errorf("Session 0 isn't expected to receive any messages. Received %d", len(helper.results[0].messages))
+	}
+	if len(helper.results[1].messages) != 1 {
+		t.Fatalf("Session 1 is expected to receive exactly 1 message. Received %d", len(helper.results[1].messages))
+	}
+	res := helper.results[1].messages[0].(*ServerComMessage)
+	if res.Info != nil {
+		info := res.Info
+		// Topic name will be fixed (to -> from).
+		if info.Topic != from.UserId() {
+			t.Errorf("Info.Topic: expected '%s', found '%s'", to.UserId(), info.Topic)
+		}
+		if info.From != from.UserId() {
+			t.Errorf("Info.From: expected '%s', found '%s'", from.UserId(), info.From)
+		}
+		if info.What != "read" {
+			t.Errorf("Info.What: expected 'read', found '%s'", info.What)
 		}
+		if info.SeqId != readId {
+			t.Errorf("Info.SeqId: expected %d, found %d", readId, info.SeqId)
+		}
+	} else {
+		t.Error("Session message is expected to contain `info` section.")
 	}
-
-	// Nothing should be routed through the hub.
-	if len(helper.hubMessages) != 0 {
-		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	// Checking presence messages routed through hub helper. These are intended for offline sessions.
+	if len(helper.hubMessages) != 2 {
+		t.Fatalf("Hubhelper.route expected exactly two recipients routed via hubhelper. Found %d", len(helper.hubMessages))
+	}
+	for i, uid := range helper.uids {
+		if routedMsgs, ok := helper.hubMessages[uid.UserId()]; ok {
+			expectedSrc := helper.uids[i^1].UserId()
+			for _, s := range routedMsgs {
+				if s.Info != nil {
+					// Info messages for offline sessions.
+					info := s.Info
+					if info.Topic != "me" {
+						t.Errorf("Uid %s: info.topic is expected to be 'me', got %s", uid.UserId(), info.Topic)
+					}
+					if info.Src != expectedSrc {
+						t.Errorf("Uid %s: info.src expected: %s, found: %s", uid.UserId(), expectedSrc, info.Src)
+					}
+					if info.What != "read" {
+						t.Error("info.what expected to be 'read'")
+					}
+					if info.SeqId != readId {
+						t.Errorf("info.seq: expected %d, found %d", readId, info.SeqId)
+					}
+				} else if s.Pres != nil {
+					// Pres messages for offline sessions.
+					pres := s.Pres
+					if pres.Topic != "me" {
+						t.Errorf("Uid %s: pres.topic is expected to be 'me', got %s", uid.UserId(), pres.Topic)
+					}
+					if pres.What != "read" {
+						t.Error("pres.what expected to be 'read'")
+					}
+					if pres.Src != expectedSrc {
+						t.Errorf("Uid %s: pres.src expected: %s, found: %s", uid.UserId(), expectedSrc, pres.Src)
+					}
+					if pres.SeqId != readId {
+						t.Errorf("pres.seq: expected %d, found %d", readId, pres.SeqId)
+					}
+				} else {
+					t.Error("Hub messages must be either `info` or `pres`.")
+				}
+			}
+		} else {
+			t.Errorf("Uid %s: no hub results found.", uid.UserId())
+		}
 	}
 }
 
-func TestHandleBroadcastInfoDbError(t *testing.T) {
+func TestHandleBroadcastInfoBogusNotification(t *testing.T) {
 	topicName := "usrP2P"
 	numUsers := 2
 	helper := TopicTestHelper{}
@@ -978,12 +1268,10 @@ func TestHandleBroadcastInfoDbError(t *testing.T) {
 	// Pretend we have 10 messages.
 	helper.topic.lastID = 10
 	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
-	readId := 8
+	readId := 11
 	from := helper.uids[0]
 	to := helper.uids[1]
 
-	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(types.ErrInternal)
-
 	msg := &ClientComMessage{
 		AsUser:   from.UserId(),
 		Original: to.UserId(),
@@ -1019,29 +1307,238 @@ func TestHandleBroadcastInfoDbError(t *testing.T) {
 	}
 }
 
-func TestHandleBroadcastInfoInvalidChannelAccess(t *testing.T) {
-	topicName := "grpTest"
-	chanName := "chnTest"
-	numUsers := 3
+func TestHandleBroadcastInfoFilterOutRecvWithoutRPermission(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
 	helper := TopicTestHelper{}
-	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
-	// This is not a channel. However, we will try to handle an info message where
-	// the topic is referenced as "chn".
-	helper.topic.isChan = false
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
 	defer helper.tearDown()
 	// Pretend we have 10 messages.
 	helper.topic.lastID = 10
 	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
 	readId := 8
 	from := helper.uids[0]
-	for i := 1; i < numUsers; i++ {
-		uid := helper.uids[i]
-		pud := helper.topic.perUser[uid]
-		pud.modeGiven = types.ModeCChnReader
-		helper.topic.perUser[uid] = pud
-	}
+	to := helper.uids[1]
 
-	msg := &ClientComMessage{
+	// Revoke R permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.modeGiven = types.ModeWrite | types.ModeJoin
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "recv",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Check for errors from testHubLoop
+	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
+		t.Fatal(errorMsgs[0].Ctrl.Text)
+	}
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoFilterOutKpWithoutWPermission(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	// Revoke W permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.modeGiven = types.ModeRead | types.ModeJoin
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "kp",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Check for errors from testHubLoop
+	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
+		t.Fatal(errorMsgs[0].Ctrl.Text)
+	}
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoDuplicatedRead(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName /*attach=*/, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	// Revoke R permission from the sender.
+	pud := helper.topic.perUser[from]
+	pud.readID = 8
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Check for errors from testHubLoop
+	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
+		t.Fatal(errorMsgs[0].Ctrl.Text)
+	}
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 8 {
+		t.Errorf("perUser[%s].readID: expected 8, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoDbError(t *testing.T) {
+	topicName := "usrP2P"
+	numUsers := 2
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	helper.ss.EXPECT().Update(topicName, from, map[string]any{"ReadSeqId": readId}).Return(types.ErrInternal)
+
+	msg := &ClientComMessage{
+		AsUser:   from.UserId(),
+		Original: to.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "read",
+			SeqId: readId,
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Check for errors from testHubLoop
+	if errorMsgs, hasError := helper.hubMessages["__ERROR__"]; hasError {
+		t.Fatal(errorMsgs[0].Ctrl.Text)
+	}
+
+	// Read id should not be updated.
+	if actualReadId := helper.topic.perUser[from].readID; actualReadId != 0 {
+		t.Errorf("perUser[%s].readID: expected 0, found %d.", from.UserId(), actualReadId)
+	}
+	// Server messages.
+	for i, r := range helper.results {
+		if numMessages := len(r.messages); numMessages != 0 {
+			t.Errorf("User %d is not expected to receive any messages, %d received.", i, numMessages)
+		}
+	}
+
+	// Nothing should be routed through the hub.
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hubhelper.route did not expect any messages, however %d received.", len(helper.hubMessages))
+	}
+}
+
+func TestHandleBroadcastInfoInvalidChannelAccess(t *testing.T) {
+	topicName := "grpTest"
+	chanName := "chnTest"
+	numUsers := 3
+	helper := TopicTestHelper{}
+	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
+	// This is not a channel. However, we will try to handle an info message where
+	// the topic is referenced as "chn".
+	helper.topic.isChan = false
+	defer helper.tearDown()
+	// Pretend we have 10 messages.
+	helper.topic.lastID = 10
+	// uid1 notifies uid2 that uid1 has read messages up to seqid 11.
+	readId := 8
+	from := helper.uids[0]
+	for i := 1; i < numUsers; i++ {
+		uid := helper.uids[i]
+		pud := helper.topic.perUser[uid]
+		pud.modeGiven = types.ModeCChnReader
+		helper.topic.perUser[uid] = pud
+	}
+
+	msg := &ClientComMessage{
 		Original: chanName,
 		AsUser:   from.UserId(),
 		Note: &MsgClientNote{
@@ -2874,7 +3371,7 @@ func TestHandleBroadcastDataWithAttachments(t *testing.T) {
 	helper := TopicTestHelper{}
 	helper.setUp(t, numUsers, types.TopicCatP2P, "p2p-test", true)
 	defer helper.tearDown()
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	from := helper.uids[0].UserId()
 	msg := &ClientComMessage{
@@ -3037,7 +3534,7 @@ func TestHandleBroadcastDataGroupWithMutedUser(t *testing.T) {
 	helper := TopicTestHelper{}
 	helper.setUp(t, numUsers, types.TopicCatGrp, topicName, true)
 	defer helper.tearDown()
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	// User 2 has muted the topic (no Pres permission)
 	pu2 := helper.topic.perUser[helper.uids[2]]
@@ -3101,7 +3598,7 @@ func TestUnregisterSessionWithPendingCall(t *testing.T) {
 		isOriginator: true,
 		sess:         s,
 	}
-	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
+	helper.mm.EXPECT().Save(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, true)
 
 	leave := &ClientComMessage{
 		Leave: &MsgClientLeave{
@@ -3189,6 +3686,7 @@ func TestReplyDelMsgHardDelete(t *testing.T) {
 
 	// Set up initial state: user2 has read up to message 5, topic has messages up to 10
 	helper.topic.lastID = 10
+	helper.topic.pinned = []int{3, 8, 10}
 
 	pud1 := helper.topic.perUser[user1]
 	pud1.readID = 10
@@ -3219,6 +3717,8 @@ func TestReplyDelMsgHardDelete(t *testing.T) {
 
 	// Mock the message deletion for hard delete (forUser = types.ZeroUid)
 	helper.mm.EXPECT().DeleteList(topicName, 1, types.ZeroUid, gomock.Any(), []types.Range{{Low: 7, Hi: 9}}).Return(nil)
+	// Deleted message is unpinned.
+	helper.tt.EXPECT().Update(topicName, map[string]any{"Pinned": types.IntSlice{3, 10}}).Return(nil)
 
 	// Call the function under test
 	err := helper.topic.replyDelMsg(helper.sessions[0], user1, false, msg)
@@ -3239,6 +3739,9 @@ func TestReplyDelMsgHardDelete(t *testing.T) {
 	if helper.topic.perUser[user2].delID != 1 {
 		t.Errorf("Expected user2.delID to be 1, got %d", helper.topic.perUser[user2].delID)
 	}
+	if !reflect.DeepEqual(helper.topic.pinned, []int{3, 10}) {
+		t.Errorf("Topic pinned: expected [3 10], found %v", helper.topic.pinned)
+	}
 }
 
 func TestReplyDelMsgUpdatesUnreadCounters(t *testing.T) {
@@ -3306,6 +3809,542 @@ func TestReplyDelMsgUpdatesUnreadCounters(t *testing.T) {
 	}
 }
 
+func TestReplySetMsgEdit(t *testing.T) {
+	topicName := "p2pTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.topic.lastID = 10
+
+	helper.mm.EXPECT().Edit(topicName, uid, 3, types.KVMap{"mime": "text/x-drafty"}, "edited").
+		Return(&types.Message{SeqId: 3, Head: types.KVMap{"mime": "text/x-drafty", "rev": 2}}, nil)
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id789",
+			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Msg: &MsgSetMsg{
+					SeqId:   3,
+					Head: map[string]any{"mime": "text/x-drafty", "sender": "usrFake", "forwarded": "grpFake:1",
+						"rev": 5, "replace": ":1", "thread": ":1"},
+					Content: "edited",
+				},
+			},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaMsg,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetMsg(helper.sessions[0], uid, false, msg); err != nil {
+		t.Fatalf("replySetMsg failed: %v", err)
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusOK})
+	params := helper.results[0].messages[0].(*ServerComMessage).Ctrl.Params.(map[string]any)
+	if params["rev"] != 2 {
+		t.Errorf("Response rev: expected 2, found %v", params["rev"])
+	}
+
+	pres, ok := helper.hubMessages[topicName]
+	if !ok || len(pres) != 1 || pres[0].Pres == nil {
+		t.Fatalf("Expected a single presence notification, got %+v", helper.hubMessages)
+	}
+	if pres[0].Pres.What != "edit" || pres[0].Pres.SeqId != 3 {
+		t.Errorf("Presence message: expected 'edit' seq=3, found '%s' seq=%d", pres[0].Pres.What, pres[0].Pres.SeqId)
+	}
+	if pres[0].SkipSid != helper.sessions[0].sid {
+		t.Errorf("Pres notification SkipSid: %s expected vs %s found", helper.sessions[0].sid, pres[0].SkipSid)
+	}
+}
+
+func TestReplySetMsgInvalidSeq(t *testing.T) {
+	topicName := "p2pTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.topic.lastID = 10
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:    "id789",
+			Topic: topicName,
+			MsgSetQuery: MsgSetQuery{
+				Msg: &MsgSetMsg{SeqId: 11, Content: "edited"},
+			},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaMsg,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetMsg(helper.sessions[0], uid, false, msg); err == nil {
+		t.Error("replySetMsg expected to fail for a message ID beyond the last ID")
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
+	if len(helper.hubMessages) != 0 {
+		t.Errorf("Hub messages: expected 0, received %d", len(helper.hubMessages))
+	}
+}
+
+func TestReplySetPin(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.topic.lastID = 10
+	helper.topic.pinned = []int{5}
+
+	helper.tt.EXPECT().Update(topicName, gomock.Any()).DoAndReturn(func(topic string, update map[string]any) error {
+		if pinned, _ := update["Pinned"].(types.IntSlice); !reflect.DeepEqual(pinned, types.IntSlice{5, 3}) {
+			t.Errorf("Pinned update: expected [5 3], found %v", update["Pinned"])
+		}
+		return nil
+	})
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:          "id789",
+			Topic:       topicName,
+			MsgSetQuery: MsgSetQuery{Pin: &MsgSetPin{SeqId: 3}},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaPin,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetPin(helper.sessions[0], uid, false, msg); err != nil {
+		t.Fatalf("replySetPin failed: %v", err)
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusOK})
+	if !reflect.DeepEqual(helper.topic.pinned, []int{5, 3}) {
+		t.Errorf("Topic pinned: expected [5 3], found %v", helper.topic.pinned)
+	}
+
+	// The other subscriber is notified on 'me'.
+	pres, ok := helper.hubMessages[helper.uids[1].UserId()]
+	if !ok || len(pres) != 1 || pres[0].Pres == nil || pres[0].Pres.What != "upd" {
+		t.Errorf("Expected a single 'upd' presence notification, got %+v", helper.hubMessages)
+	}
+}
+
+func TestReplySetPinNotAllowed(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	helper.topic.lastID = 20
+	helper.topic.pinned = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	// User 1 is not an admin.
+	uid1 := helper.uids[1]
+	pud := helper.topic.perUser[uid1]
+	pud.modeGiven = types.ModeCPublic
+	helper.topic.perUser[uid1] = pud
+
+	for i, uid := range helper.uids {
+		msg := &ClientComMessage{
+			Set: &MsgClientSet{
+				Id:          "id789",
+				Topic:       topicName,
+				MsgSetQuery: MsgSetQuery{Pin: &MsgSetPin{SeqId: 15}},
+			},
+			AsUser:   uid.UserId(),
+			MetaWhat: constMsgMetaPin,
+			sess:     helper.sessions[i],
+		}
+		if err := helper.topic.replySetPin(helper.sessions[i], uid, false, msg); err == nil {
+			t.Errorf("replySetPin by user %d expected to fail", i)
+		}
+	}
+	helper.finish()
+
+	// Too many pinned messages.
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusUnprocessableEntity})
+	// Not an admin.
+	registerSessionVerifyOutputs(t, helper.results[1], []int{http.StatusForbidden})
+	if len(helper.topic.pinned) != maxPinnedMessages {
+		t.Errorf("Topic pinned: expected %d messages, found %v", maxPinnedMessages, helper.topic.pinned)
+	}
+}
+
+func TestReplySetDescMsgTTL(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.tt.EXPECT().Update(topicName, gomock.Any()).DoAndReturn(func(topic string, update map[string]any) error {
+		if ttl, _ := update["MsgTTL"].(int); ttl != 3600 {
+			t.Errorf("MsgTTL update: expected 3600, found %v", update["MsgTTL"])
+		}
+		return nil
+	})
+
+	ttl := 3600
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:          "id789",
+			Topic:       topicName,
+			MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{MsgTTL: &ttl}},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaDesc,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetDesc(helper.sessions[0], uid, false, auth.LevelAuth, msg); err != nil {
+		t.Fatalf("replySetDesc failed: %v", err)
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusOK})
+	if helper.topic.msgTTL != 3600 {
+		t.Errorf("Topic msgTTL: expected 3600, found %d", helper.topic.msgTTL)
+	}
+
+	// The other subscriber is notified on 'me'.
+	pres, ok := helper.hubMessages[helper.uids[1].UserId()]
+	if !ok || len(pres) != 1 || pres[0].Pres == nil || pres[0].Pres.What != "upd" {
+		t.Errorf("Expected a single 'upd' presence notification, got %+v", helper.hubMessages)
+	}
+}
+
+func TestReplySetDescMsgTTLNotOwner(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	uid := helper.uids[1]
+	ttl := 60
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:          "id789",
+			Topic:       topicName,
+			MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{MsgTTL: &ttl}},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaDesc,
+		sess:     helper.sessions[1],
+	}
+	if err := helper.topic.replySetDesc(helper.sessions[1], uid, false, auth.LevelAuth, msg); err == nil {
+		t.Error("replySetDesc by non-owner expected to fail")
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[1], []int{http.StatusForbidden})
+	if helper.topic.msgTTL != 0 {
+		t.Errorf("Topic msgTTL: expected 0, found %d", helper.topic.msgTTL)
+	}
+}
+
+func TestReplySetDescSlowMode(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, false)
+	defer helper.tearDown()
+
+	uid := helper.uids[0]
+	helper.tt.EXPECT().Update(topicName, gomock.Any()).DoAndReturn(func(topic string, update map[string]any) error {
+		if update["SlowMode"] != 30 || update["MsgBurst"] != 5 {
+			t.Errorf("Slow mode update: expected 30/5, found %v", update)
+		}
+		return nil
+	})
+
+	msg := &ClientComMessage{
+		Set: &MsgClientSet{
+			Id:          "id789",
+			Topic:       topicName,
+			MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{SlowMode: &MsgSlowMode{Interval: 30, Burst: 5}}},
+		},
+		AsUser:   uid.UserId(),
+		MetaWhat: constMsgMetaDesc,
+		sess:     helper.sessions[0],
+	}
+	if err := helper.topic.replySetDesc(helper.sessions[0], uid, false, auth.LevelAuth, msg); err != nil {
+		t.Fatalf("replySetDesc failed: %v", err)
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusOK})
+	if helper.topic.slowMode != 30 || helper.topic.msgBurst != 5 {
+		t.Errorf("Topic slow mode: expected 30/5, found %d/%d", helper.topic.slowMode, helper.topic.msgBurst)
+	}
+}
+
+func TestReplySetDescSlowModeInvalid(t *testing.T) {
+	topicName := "grpTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatGrp, topicName, true)
+	defer helper.tearDown()
+
+	for i, slow := range []*MsgSlowMode{
+		// Not an owner.
+		{Interval: 30},
+		// Negative interval.
+		{Interval: -1},
+	} {
+		uid := helper.uids[1-i]
+		msg := &ClientComMessage{
+			Set: &MsgClientSet{
+				Id:          "id789",
+				Topic:       topicName,
+				MsgSetQuery: MsgSetQuery{Desc: &MsgSetDesc{SlowMode: slow}},
+			},
+			AsUser:   uid.UserId(),
+			MetaWhat: constMsgMetaDesc,
+			sess:     helper.sessions[1-i],
+		}
+		if err := helper.topic.replySetDesc(helper.sessions[1-i], uid, false, auth.LevelAuth, msg); err == nil {
+			t.Errorf("replySetDesc %d expected to fail", i)
+		}
+	}
+	helper.finish()
+
+	registerSessionVerifyOutputs(t, helper.results[1], []int{http.StatusForbidden})
+	registerSessionVerifyOutputs(t, helper.results[0], []int{http.StatusBadRequest})
+	if helper.topic.slowMode != 0 {
+		t.Errorf("Topic slow mode: expected 0, found %d", helper.topic.slowMode)
+	}
+}
+
+func TestHandleClientMsgExpiry(t *testing.T) {
+	topicName := "p2pTest"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+
+	helper.topic.lastID = 10
+	helper.topic.msgTTL = 60
+
+	ranges := []types.Range{{Low: 1, Hi: 4}}
+	gomock.InOrder(
+		helper.mm.EXPECT().GetExpired(topicName, gomock.Any(), msgExpiryBlockSize).Return(ranges, nil),
+		helper.mm.EXPECT().DeleteList(topicName, 1, types.ZeroUid, time.Duration(0), ranges).Return(nil),
+	)
+
+	helper.topic.handleClientMsg(&ClientComMessage{
+		Original:  topicName,
+		RcptTo:    topicName,
+		MsgExpiry: true,
+		init:      true,
+	})
+	helper.finish()
+
+	if helper.topic.delID != 1 {
+		t.Errorf("Topic delID: expected 1, found %d", helper.topic.delID)
+	}
+	for i, uid := range helper.uids {
+		if helper.topic.perUser[uid].delID != 1 {
+			t.Errorf("User %d delID: expected 1, found %d", i, helper.topic.perUser[uid].delID)
+		}
+	}
+
+	// Each user is notified of the deleted messages.
+	pres := helper.hubMessages[topicName]
+	if len(pres) != len(helper.uids) {
+		t.Fatalf("Expected %d presence notifications, got %+v", len(helper.uids), helper.hubMessages)
+	}
+	for _, p := range pres {
+		if p.Pres == nil || p.Pres.What != "del" || p.Pres.DelId != 1 ||
+			!reflect.DeepEqual(p.Pres.DelSeq, []MsgRange{{LowId: 1, HiId: 4}}) {
+			t.Errorf("Expected 'del' presence notification, got %+v", p.Pres)
+		}
+	}
+}
+
+func TestHandleBroadcastInfoReaction(t *testing.T) {
+	topicName := "usrP2P"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	gomock.InOrder(
+		helper.mm.EXPECT().React(topicName, from, 5, "+1").Return(nil),
+		helper.mm.EXPECT().GetReactions(topicName, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 5}}}).
+			Return([]types.MessageReaction{{SeqId: 5, Value: "+1", Count: 2}}, nil),
+	)
+
+	msg := &ClientComMessage{
+		AsUser: from.UserId(),
+		Note: &MsgClientNote{
+			Topic:    to.UserId(),
+			What:     "react",
+			SeqId:    5,
+			Reaction: "+1",
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Both sessions including the originating one receive the updated counts.
+	for i := range helper.sessions {
+		if len(helper.results[i].messages) != 1 {
+			t.Fatalf("Session %d is expected to receive exactly 1 message. Received %d", i, len(helper.results[i].messages))
+		}
+		info := helper.results[i].messages[0].(*ServerComMessage).Info
+		if info == nil {
+			t.Fatalf("Session %d message is expected to contain `info` section.", i)
+		}
+		if info.What != "react" || info.SeqId != 5 || info.From != from.UserId() {
+			t.Errorf("Info: expected 'react' seq=5 from=%s, found '%s' seq=%d from=%s",
+				from.UserId(), info.What, info.SeqId, info.From)
+		}
+		if len(info.Reactions) != 1 || info.Reactions[0].Value != "+1" || info.Reactions[0].Count != 2 {
+			t.Errorf("Info.Reactions: unexpected %+v", info.Reactions)
+		}
+	}
+}
+
+func TestHandleBroadcastInfoReactionNoReadPermission(t *testing.T) {
+	topicName := "usrP2P"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	from := helper.uids[0]
+	pud := helper.topic.perUser[from]
+	pud.modeGiven = types.ModeWrite
+	helper.topic.perUser[from] = pud
+
+	msg := &ClientComMessage{
+		AsUser: from.UserId(),
+		Note: &MsgClientNote{
+			Topic:    helper.uids[1].UserId(),
+			What:     "react",
+			SeqId:    5,
+			Reaction: "+1",
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	for i := range helper.sessions {
+		if len(helper.results[i].messages) != 0 {
+			t.Errorf("Session %d isn't expected to receive any messages. Received %d", i, len(helper.results[i].messages))
+		}
+	}
+}
+
+// pollContent is a Drafty poll with three options.
+func pollContent(multi bool) map[string]any {
+	return map[string]any{
+		"txt": "Lunch?",
+		"fmt": []any{map[string]any{"len": 6}},
+		"ent": []any{map[string]any{"tp": "PL", "data": map[string]any{
+			"opts":  []any{"Pizza", "Sushi", "Tacos"},
+			"multi": multi,
+		}}},
+	}
+}
+
+func TestHandleBroadcastInfoVote(t *testing.T) {
+	topicName := "usrP2P"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	from := helper.uids[0]
+	to := helper.uids[1]
+
+	gomock.InOrder(
+		helper.mm.EXPECT().Get(topicName, from, 5).
+			Return(&types.Message{SeqId: 5, Content: pollContent(true)}, nil),
+		helper.mm.EXPECT().Vote(topicName, from, 5, []int{0, 2}).Return(nil),
+		helper.mm.EXPECT().GetVotes(topicName, types.ZeroUid, &types.QueryOpt{IdRanges: []types.Range{{Low: 5}}}).
+			Return([]types.MessageVote{{SeqId: 5, Option: 0, Count: 1}, {SeqId: 5, Option: 2, Count: 3}}, nil),
+	)
+
+	msg := &ClientComMessage{
+		AsUser: from.UserId(),
+		Note: &MsgClientNote{
+			Topic: to.UserId(),
+			What:  "vote",
+			SeqId: 5,
+			Vote:  []int{0, 2},
+		},
+		sess: helper.sessions[0],
+	}
+	helper.topic.handleClientMsg(msg)
+	helper.finish()
+
+	// Both sessions including the originating one receive the updated counts.
+	for i := range helper.sessions {
+		if len(helper.results[i].messages) != 1 {
+			t.Fatalf("Session %d is expected to receive exactly 1 message. Received %d", i, len(helper.results[i].messages))
+		}
+		info := helper.results[i].messages[0].(*ServerComMessage).Info
+		if info == nil {
+			t.Fatalf("Session %d message is expected to contain `info` section.", i)
+		}
+		if info.What != "vote" || info.SeqId != 5 || info.From != from.UserId() {
+			t.Errorf("Info: expected 'vote' seq=5 from=%s, found '%s' seq=%d from=%s",
+				from.UserId(), info.What, info.SeqId, info.From)
+		}
+		if len(info.Votes) != 2 || info.Votes[1].Option != 2 || info.Votes[1].Count != 3 {
+			t.Errorf("Info.Votes: unexpected %+v", info.Votes)
+		}
+	}
+}
+
+func TestHandleBroadcastInfoVoteInvalid(t *testing.T) {
+	topicName := "usrP2P"
+	helper := TopicTestHelper{}
+	helper.setUp(t, 2, types.TopicCatP2P, topicName, true)
+	defer helper.tearDown()
+	helper.topic.lastID = 10
+
+	from := helper.uids[0]
+	helper.mm.EXPECT().Get(topicName, from, 5).
+		Return(&types.Message{SeqId: 5, Content: pollContent(false)}, nil).Times(3)
+
+	for _, vote := range [][]int{
+		// Multiple options in a single choice poll.
+		{0, 1},
+		// No such option.
+		{3},
+		{-1},
+	} {
+		helper.topic.handleClientMsg(&ClientComMessage{
+			AsUser: from.UserId(),
+			Note: &MsgClientNote{
+				Topic: helper.uids[1].UserId(),
+				What:  "vote",
+				SeqId: 5,
+				Vote:  vote,
+			},
+			sess: helper.sessions[0],
+		})
+	}
+	helper.finish()
+
+	for i := range helper.sessions {
+		if len(helper.results[i].messages) != 0 {
+			t.Errorf("Session %d isn't expected to receive any messages. Received %d", i, len(helper.results[i].messages))
+		}
+	}
+}
+
 func TestCalculateUnreadInRanges(t *testing.T) {
 	tests := []struct {
 		name     string
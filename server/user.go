@@ -41,7 +41,7 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	}
 
 	// Check if login is unique and compliance with the policy (not too long or too short).
-	if ok, err := authhdl.IsUnique(msg.Acc.Secret, s.remoteAddr); !ok {
+	if ok, err := authhdl.IsUnique(msg.Acc.Secret, s.getRemoteAddr()); !ok {
 		logs.Warn.Println("create user: auth secret is not compliant", err, "sid=", s.sid)
 		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp,
 			map[string]any{"what": "auth"}))
@@ -136,7 +136,7 @@ func replyCreateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 	}
 
 	// Add authentication record. The authhdl.AddRecord may change tags.
-	rec, err := authhdl.AddRecord(&auth.Rec{Uid: user.Uid(), Tags: user.Tags}, msg.Acc.Secret, s.remoteAddr)
+	rec, err := authhdl.AddRecord(&auth.Rec{Uid: user.Uid(), Tags: user.Tags}, msg.Acc.Secret, s.getRemoteAddr())
 	if err != nil {
 		logs.Warn.Println("create user: add auth record failed", err, "sid=", s.sid)
 		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
@@ -281,7 +281,7 @@ func replyUpdateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 
 	var params map[string]any
 	if msg.Acc.Scheme != "" {
-		err = updateUserAuth(msg, user, rec, s.remoteAddr)
+		params, err = updateUserAuth(msg.Acc.Scheme, msg.Acc.Secret, user, s.getRemoteAddr())
 	} else if len(msg.Acc.Cred) > 0 {
 		if authLvl == auth.LevelNone {
 			// msg.Acc.AuthLevel contains invalid data.
@@ -311,7 +311,7 @@ func replyUpdateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 		}
 	} else if msg.Acc.State != "" {
 		var changed bool
-		changed, err = changeUserState(s, uid, user, msg)
+		changed, err = changeUserState(uid, user, msg.Acc.State)
 		if !changed && err == nil {
 			s.queueOut(InfoNotModified(msg.Id, "", msg.Timestamp))
 			return
@@ -326,23 +326,49 @@ func replyUpdateUser(s *Session, msg *ClientComMessage, rec *auth.Rec) {
 		return
 	}
 
+	if challenge, ok := params["challenge"].([]byte); ok {
+		// Multi-stage update. The client must respond to the challenge to complete it.
+		reply := InfoChallenge(msg.Id, msg.Timestamp, challenge)
+		reply.Ctrl.Params = params
+		s.queueOut(reply)
+		return
+	}
+
+	actor := s.uid
+	if actor.IsZero() {
+		// The user is authenticated by a temporary token, e.g. to reset the password.
+		actor = uid
+	}
+	switch {
+	case msg.Acc.Scheme != "":
+		auditRecord(auditAuthChange, actor, uid, "", s.getRemoteAddr(), map[string]any{"scheme": msg.Acc.Scheme})
+	case len(msg.Acc.Cred) > 0:
+		var methods []string
+		for i := range msg.Acc.Cred {
+			methods = append(methods, msg.Acc.Cred[i].Method)
+		}
+		auditRecord(auditCredAdd, actor, uid, "", s.getRemoteAddr(), map[string]any{"methods": methods})
+	case msg.Acc.State != "":
+		auditRecord(auditStateChange, actor, uid, "", s.getRemoteAddr(), map[string]any{"state": msg.Acc.State})
+	}
+
 	s.queueOut(NoErrParams(msg.Id, "", msg.Timestamp, params))
 
 	// Call plugin with the account update
 	pluginAccount(user, plgActUpd)
 }
 
-// Authentication update
-func updateUserAuth(msg *ClientComMessage, user *types.User, _ *auth.Rec, remoteAddr string) error {
-	authhdl := store.Store.GetLogicalAuthHandler(msg.Acc.Scheme)
+// Authentication update. Returns parameters to pass to the client, if any.
+func updateUserAuth(scheme string, secret []byte, user *types.User, remoteAddr string) (map[string]any, error) {
+	authhdl := store.Store.GetLogicalAuthHandler(scheme)
 	if authhdl != nil {
-		// Request to update auth of an existing account. Only basic & rest auth are currently supported
+		// Request to update auth of an existing account. Only basic, rest, totp, oidc & webauthn auth are currently supported
 
 		// TODO(gene): support adding new auth schemes
 
-		rec, err := authhdl.UpdateRecord(&auth.Rec{Uid: user.Uid(), Tags: user.Tags}, msg.Acc.Secret, remoteAddr)
+		rec, err := authhdl.UpdateRecord(&auth.Rec{Uid: user.Uid(), Tags: user.Tags}, secret, remoteAddr)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Tags may have been changed by authhdl.UpdateRecord, reset them.
@@ -350,11 +376,11 @@ func updateUserAuth(msg *ClientComMessage, user *types.User, _ *auth.Rec, remote
 		if _, err = store.Users.UpdateTags(user.Uid(), nil, nil, rec.Tags); err != nil {
 			logs.Warn.Println("updateUserAuth tags update failed:", err)
 		}
-		return nil
+		return rec.Params, nil
 	}
 
 	// Invalid or unknown auth scheme
-	return types.ErrMalformed
+	return nil, types.ErrMalformed
 }
 
 // addCreds adds new credentials and re-send validation request for existing ones.
@@ -553,10 +579,10 @@ func deleteCred(uid types.Uid, authLvl auth.Level, cred *MsgCredClient) ([]strin
 // 3. Suspend/activate p2p with the user.
 // 4. Suspend/activate grp topics where the user is the owner.
 // 5. Update user's DB record.
-func changeUserState(s *Session, uid types.Uid, user *types.User, msg *ClientComMessage) (bool, error) {
-	state, err := types.NewObjState(msg.Acc.State)
+func changeUserState(uid types.Uid, user *types.User, newState string) (bool, error) {
+	state, err := types.NewObjState(newState)
 	if err != nil || state == types.StateUndefined {
-		logs.Warn.Println("replyUpdateUser: invalid account state", s.sid)
+		logs.Warn.Println("changeUserState: invalid account state", newState, uid.UserId())
 		return false, types.ErrMalformed
 	}
 
@@ -617,6 +643,35 @@ func replyDelUser(s *Session, msg *ClientComMessage) {
 		return
 	}
 
+	if err := deleteUser(uid, msg.Del.Hard, s.sid); err != nil {
+		if err == types.ErrUnsupported {
+			// Authenticator refused to delete record: user account cannot be deleted.
+			s.queueOut(ErrOperationNotAllowed(msg.Id, "", msg.Timestamp))
+		} else {
+			s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
+		}
+		return
+	}
+
+	auditRecord(auditUserDelete, s.uid, uid, "", s.getRemoteAddr(), map[string]any{"hard": msg.Del.Hard})
+
+	s.queueOut(NoErr(msg.Id, "", msg.Timestamp))
+
+	if s.uid == uid && s.multi == nil {
+		// Evict the current session if it belongs to the deleted user.
+		// No need to send it to multiplexing session: remote node will be notified separately.
+		_, data := s.serialize(NoErrEvicted("", "", msg.Timestamp))
+		s.stopSession(data)
+	}
+}
+
+// deleteUser deletes the user:
+// 1. Disable user's logins
+// 2. Terminate all user's sessions except the session skipSid.
+// 3. Stop all active topics
+// 4. Notify other subscribers that topics are being deleted.
+// 5. Delete user from the database.
+func deleteUser(uid types.Uid, hard bool, skipSid string) error {
 	// Disable all authenticators
 	authnames := store.Store.GetAuthNames()
 	for _, name := range authnames {
@@ -626,62 +681,52 @@ func replyDelUser(s *Session, msg *ClientComMessage) {
 		}
 		if err := hdl.DelRecords(uid); err != nil {
 			// This could be completely benign, i.e. authenticator exists but not used.
-			logs.Warn.Println("replyDelUser: failed to delete auth record", uid.UserId(), name, err, s.sid)
+			logs.Warn.Println("deleteUser: failed to delete auth record", uid.UserId(), name, err)
 			if storeErr, ok := err.(types.StoreError); ok && storeErr == types.ErrUnsupported {
 				// Authenticator refused to delete record: user account cannot be deleted.
-				s.queueOut(ErrOperationNotAllowed(msg.Id, "", msg.Timestamp))
-				return
+				return types.ErrUnsupported
 			}
 		}
 	}
 
 	// Terminate all sessions. Skip the current session so the requester gets a response.
-	globals.sessionStore.EvictUser(uid, s.sid)
+	globals.sessionStore.EvictUser(uid, skipSid)
 	// Remove user from cache and announce to cluster that the user is deleted.
 	usersRemoveUser(uid)
 
 	// Stop topics where the user is the owner and p2p topics.
 	done := make(chan bool)
-	globals.hub.unreg <- &topicUnreg{forUser: uid, del: msg.Del.Hard, done: done}
+	globals.hub.unreg <- &topicUnreg{forUser: uid, del: hard, done: done}
 	<-done
 
 	// Notify users of interest that the user is gone.
 	if uoi, err := store.Users.GetSubs(uid); err == nil {
 		presUsersOfInterestOffline(uid, uoi, "gone")
 	} else {
-		logs.Warn.Println("replyDelUser: failed to send notifications to users", err, s.sid)
+		logs.Warn.Println("deleteUser: failed to send notifications to users", err, uid.UserId())
 	}
 
 	// Notify subscribers of the group topics where the user was the owner that the topics were deleted.
 	if ownTopics, err := store.Users.GetOwnTopics(uid); err == nil {
 		for _, topicName := range ownTopics {
 			if subs, err := store.Topics.GetSubs(topicName, nil); err == nil {
-				presSubsOfflineOffline(topicName, types.TopicCatGrp, subs, "gone", &presParams{}, s.sid)
+				presSubsOfflineOffline(topicName, types.TopicCatGrp, subs, "gone", &presParams{}, skipSid)
 			} else {
-				logs.Warn.Println("replyDelUser: failed to notify topic subscribers", err, topicName, s.sid)
+				logs.Warn.Println("deleteUser: failed to notify topic subscribers", err, topicName)
 			}
 		}
 	} else {
-		logs.Warn.Println("replyDelUser: failed to send notifications to owned topics", err, s.sid)
+		logs.Warn.Println("deleteUser: failed to send notifications to owned topics", err, uid.UserId())
 	}
 
 	// TODO: suspend all P2P topics with the user.
 
 	// Delete user's records from the database.
-	if err := store.Users.Delete(uid, msg.Del.Hard); err != nil {
-		logs.Warn.Println("replyDelUser: failed to delete user", err, s.sid)
-		s.queueOut(decodeStoreError(err, msg.Id, msg.Timestamp, nil))
-		return
-	}
-
-	s.queueOut(NoErr(msg.Id, "", msg.Timestamp))
-
-	if s.uid == uid && s.multi == nil {
-		// Evict the current session if it belongs to the deleted user.
-		// No need to send it to multiplexing session: remote node will be notified separately.
-		_, data := s.serialize(NoErrEvicted("", "", msg.Timestamp))
-		s.stopSession(data)
+	if err := store.Users.Delete(uid, hard); err != nil {
+		logs.Warn.Println("deleteUser: failed to delete user", err, uid.UserId())
+		return err
 	}
+	return nil
 }
 
 // Read user's state from DB.
@@ -696,6 +741,24 @@ func userGetState(uid types.Uid) (types.ObjState, error) {
 	return user.State, nil
 }
 
+// Find the second authentication factor enabled by the user. Returns an empty string if there is none.
+func secondFactorScheme(uid types.Uid) (string, error) {
+	for _, name := range globals.secondFactors {
+		sf, ok := store.Store.GetLogicalAuthHandler(name).(auth.SecondFactor)
+		if !ok {
+			continue
+		}
+		enrolled, err := sf.IsEnrolled(uid)
+		if err != nil {
+			return "", err
+		}
+		if enrolled {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
 // Subscribe or unsubscribe a single user's device to/from all FCM topics (channels).
 func userChannelsSubUnsub(uid types.Uid, deviceID string, sub bool) {
 	push.ChannelSub(&push.ChannelReq{
@@ -723,6 +786,8 @@ type UserCacheReq struct {
 	Inc bool
 	// User is being deleted, remove user from cache.
 	Gone bool
+	// Logins of the user UserId are revoked, terminate their sessions.
+	AuthSessions []string
 
 	// Optional push notification
 	PushRcpt *push.Receipt
@@ -156,11 +156,36 @@ func msgOpts2storeOpts(req *MsgGetOpts) *types.QueryOpt {
 			Since:           req.SinceId,
 			Before:          req.BeforeId,
 			IdRanges:        rangeSerialize(req.IdRanges),
+			Thread:          req.Thread,
 		}
 	}
 	return opts
 }
 
+// reactionsBySeq groups aggregated reactions by message ID.
+func reactionsBySeq(reacts []types.MessageReaction) map[int][]MsgReaction {
+	if len(reacts) == 0 {
+		return nil
+	}
+	result := make(map[int][]MsgReaction)
+	for _, r := range reacts {
+		result[r.SeqId] = append(result[r.SeqId], MsgReaction{Value: r.Value, Count: r.Count, Mine: r.Mine})
+	}
+	return result
+}
+
+// votesBySeq groups counts of votes in polls by message ID.
+func votesBySeq(votes []types.MessageVote) map[int][]MsgPollVote {
+	if len(votes) == 0 {
+		return nil
+	}
+	result := make(map[int][]MsgPollVote)
+	for _, v := range votes {
+		result[v.SeqId] = append(result[v.SeqId], MsgPollVote{Option: v.Option, Count: v.Count, Mine: v.Mine})
+	}
+	return result
+}
+
 // Check if the interface contains a string with a single Unicode Del control character.
 func isNullValue(i any) bool {
 	if str, ok := i.(string); ok {
@@ -198,6 +223,8 @@ func decodeStoreErrorExplicitTs(err error, id, topic string, serverTs, incomingR
 			errmsg = ErrNotImplemented(id, topic, serverTs, incomingReqTs)
 		case types.ErrExpired:
 			errmsg = ErrAuthFailed(id, topic, serverTs, incomingReqTs)
+		case types.ErrResetRequired:
+			errmsg = ErrAuthResetRequired(id, topic, serverTs, incomingReqTs)
 		case types.ErrPolicy:
 			errmsg = ErrPolicyExplicitTs(id, topic, serverTs, incomingReqTs)
 		case types.ErrCredentials:
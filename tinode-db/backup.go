@@ -0,0 +1,880 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	adapter "github.com/tinode/chat/server/db"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Identifier of the backup archive.
+	archiveFormat = "tinode-backup"
+	// Version of the archive layout. Increment when the meaning of records changes.
+	archiveVersion = 2
+)
+
+// Types of archive records.
+const (
+	recUser      = "user"
+	recAuth      = "auth"
+	recCred      = "cred"
+	recDevice    = "device"
+	recFile      = "file"
+	recSession   = "session"
+	recSub       = "sub"
+	recUserEnd   = "user_end"
+	recTopic     = "topic"
+	recMessage   = "message"
+	recEdit      = "edit"
+	recReaction  = "reaction"
+	recVote      = "vote"
+	recScheduled = "scheduled"
+	recDeletion  = "deletion"
+	recTopicEnd  = "topic_end"
+	recPCache    = "pcache"
+	recAPIKey    = "apikey"
+	recAudit     = "audit"
+	// The last record of a complete archive.
+	recEnd = "end"
+)
+
+// archiveHeader is the first line of the archive.
+type archiveHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// Version of the database schema as reported by the adapter.
+	DbVersion int       `json:"db_version"`
+	Adapter   string    `json:"adapter"`
+	CreatedAt time.Time `json:"created_at"`
+	// ID of the user or name of the topic if the archive contains only one user or topic.
+	Scope string `json:"scope,omitempty"`
+}
+
+// archiveRecord is one line of the archive following the header.
+type archiveRecord struct {
+	Type string `json:"type"`
+	// Owner of the auth record or device.
+	User string `json:"user,omitempty"`
+	// Topic of the reaction or vote.
+	Topic string `json:"topic,omitempty"`
+	// IDs of files attached to the message.
+	Attachments []string `json:"attachments,omitempty"`
+	// The record itself: types.User, types.Topic, adapter.AuthRecord etc. The 'end' record
+	// contains the number of records of each kind in the archive.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// archiveWriter writes records to a backup archive, one JSON object per line.
+type archiveWriter struct {
+	enc    *json.Encoder
+	counts recordCounts
+}
+
+func (w *archiveWriter) put(kind, count string, user string, attachments []string, val any) error {
+	return w.write(&archiveRecord{Type: kind, User: user, Attachments: attachments}, count, 1, val)
+}
+
+// write saves the record with the given value as data and adds n to the count.
+func (w *archiveWriter) write(rec *archiveRecord, count string, n int, val any) error {
+	var err error
+	if rec.Data, err = json.Marshal(val); err != nil {
+		return err
+	}
+	if err = w.enc.Encode(rec); err != nil {
+		return err
+	}
+	if count != "" {
+		w.counts[count] += n
+	}
+	return nil
+}
+
+func (w *archiveWriter) user(user *types.User) error {
+	return w.put(recUser, "users", "", nil, user)
+}
+
+func (w *archiveWriter) authRecord(uid types.Uid, rec *adapter.AuthRecord) error {
+	return w.put(recAuth, "auth", uid.UserId(), nil, rec)
+}
+
+func (w *archiveWriter) credential(cred *types.Credential) error {
+	return w.put(recCred, "creds", "", nil, cred)
+}
+
+func (w *archiveWriter) device(uid types.Uid, dev *types.DeviceDef) error {
+	return w.put(recDevice, "devices", uid.UserId(), nil, dev)
+}
+
+func (w *archiveWriter) file(fd *types.FileDef) error {
+	return w.put(recFile, "files", "", nil, fd)
+}
+
+func (w *archiveWriter) authSession(sess *types.AuthSession) error {
+	return w.put(recSession, "sessions", "", nil, sess)
+}
+
+func (w *archiveWriter) userDone(user *types.User) error {
+	return w.put(recUserEnd, "", "", nil, user.Id)
+}
+
+func (w *archiveWriter) topic(topic *types.Topic) error {
+	return w.put(recTopic, "topics", "", nil, topic)
+}
+
+func (w *archiveWriter) message(msg *types.Message, attachments []string) error {
+	if err := w.put(recMessage, "messages", "", attachments, msg); err != nil {
+		return err
+	}
+	if len(attachments) > 0 {
+		w.counts["attachments"] += len(attachments)
+	}
+	return nil
+}
+
+func (w *archiveWriter) edit(rev *types.MessageRevision) error {
+	return w.put(recEdit, "edits", "", nil, rev)
+}
+
+func (w *archiveWriter) reaction(topic string, react *adapter.UserReaction) error {
+	return w.write(&archiveRecord{Type: recReaction, Topic: topic}, "reactions", 1, react)
+}
+
+func (w *archiveWriter) vote(topic string, vote *pollVote) error {
+	return w.write(&archiveRecord{Type: recVote, Topic: topic}, "votes", len(vote.Options), vote)
+}
+
+func (w *archiveWriter) scheduled(msg *types.ScheduledMessage) error {
+	return w.put(recScheduled, "scheduled", "", nil, msg)
+}
+
+func (w *archiveWriter) deletion(del *types.DelMessage) error {
+	return w.put(recDeletion, "deletions", "", nil, del)
+}
+
+func (w *archiveWriter) topicDone(topic *types.Topic) error {
+	return w.put(recTopicEnd, "", "", nil, topic.Id)
+}
+
+func (w *archiveWriter) subscription(sub *types.Subscription) error {
+	return w.put(recSub, "subscriptions", "", nil, sub)
+}
+
+func (w *archiveWriter) pcache(entry *adapter.PCacheEntry) error {
+	return w.put(recPCache, "pcache", "", nil, entry)
+}
+
+func (w *archiveWriter) apiKey(key *types.APIKey) error {
+	return w.put(recAPIKey, "apikeys", "", nil, key)
+}
+
+func (w *archiveWriter) audit(rec *types.AuditRecord) error {
+	return w.put(recAudit, "audit", "", nil, rec)
+}
+
+// backupDb saves the data from the adapter configured in store_config to the archive file.
+// The scope limits the backup to one user or topic.
+func backupDb(storeConfig json.RawMessage, fileName, scope string) {
+	if err := store.Store.Open(1, storeConfig); err != nil {
+		log.Fatalln("Failed to open database:", err)
+	}
+	defer store.Store.Close()
+
+	a := store.Store.GetAdapter()
+	a.SetMaxResults(migrateMaxResults)
+
+	scope = normalizeScope(scope)
+	log.Printf("Saving backup of '%s' to '%s'", a.GetName(), fileName)
+
+	counts, err := writeArchive(a, fileName, scope)
+	if err != nil {
+		os.Remove(fileName)
+		log.Fatalln("Backup failed:", err)
+	}
+
+	logCounts(counts)
+	log.Println("Backup completed.")
+}
+
+// writeArchive creates the archive file and saves the data into it.
+func writeArchive(a adapter.Adapter, fileName, scope string) (recordCounts, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	var gz *gzip.Writer
+	if strings.HasSuffix(fileName, ".gz") {
+		gz = gzip.NewWriter(file)
+		out = gz
+	}
+
+	dbVersion, err := a.GetDbVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &archiveWriter{enc: json.NewEncoder(out), counts: recordCounts{}}
+	if err = w.enc.Encode(&archiveHeader{
+		Format:    archiveFormat,
+		Version:   archiveVersion,
+		DbVersion: dbVersion,
+		Adapter:   a.GetName(),
+		CreatedAt: types.TimeNow(),
+		Scope:     scope,
+	}); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case scope == "":
+		err = backupAll(a, w)
+	case !types.ParseUserId(scope).IsZero():
+		err = backupUser(a, types.ParseUserId(scope), w)
+	default:
+		err = backupTopic(a, scope, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.put(recEnd, "", "", nil, w.counts); err != nil {
+		return nil, err
+	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return w.counts, file.Close()
+}
+
+// backupAll saves all users, topics, cache entries, API keys and the audit log.
+func backupAll(a adapter.Adapter, w *archiveWriter) error {
+	after := types.ZeroUid
+	for {
+		users, err := a.UserList(after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+		for i := range users {
+			if err := exportUser(a, &users[i], w); err != nil {
+				return fmt.Errorf("user '%s': %w", users[i].Uid().UserId(), err)
+			}
+		}
+		after = users[len(users)-1].Uid()
+	}
+
+	var last string
+	for {
+		topics, err := a.TopicList(last, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(topics) == 0 {
+			break
+		}
+		for i := range topics {
+			if err := exportTopic(a, &topics[i], w); err != nil {
+				return fmt.Errorf("topic '%s': %w", topics[i].Id, err)
+			}
+		}
+		last = topics[len(topics)-1].Id
+	}
+
+	last = ""
+	for {
+		entries, err := a.PCacheList(last, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for i := range entries {
+			if err := w.pcache(&entries[i]); err != nil {
+				return err
+			}
+		}
+		last = entries[len(entries)-1].Key
+	}
+
+	keys, err := a.APIKeyGetAll()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := w.apiKey(&keys[i]); err != nil {
+			return err
+		}
+	}
+
+	last = ""
+	for {
+		recs, err := a.AuditList(last, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(recs) == 0 {
+			break
+		}
+		for i := range recs {
+			if err := w.audit(&recs[i]); err != nil {
+				return err
+			}
+		}
+		last = recs[len(recs)-1].Id
+	}
+	return nil
+}
+
+// backupUser saves one user with subscriptions to topics. The topics themselves are not saved.
+func backupUser(a adapter.Adapter, uid types.Uid, w *archiveWriter) error {
+	user, err := a.UserGet(uid)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user '%s' not found", uid.UserId())
+	}
+	if err = exportUser(a, user, w); err != nil {
+		return err
+	}
+
+	// Subscriptions are saved after the user, outside of any topic.
+	subs, err := a.SubsForUser(uid)
+	if err != nil {
+		return err
+	}
+	for i := range subs {
+		if subs[i].Topic == uid.UserId() || subs[i].Topic == uid.FndName() {
+			// Already saved with the user.
+			continue
+		}
+		if err = w.subscription(&subs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupTopic saves one topic, including a deleted one.
+func backupTopic(a adapter.Adapter, name string, w *archiveWriter) error {
+	topic, err := a.TopicGet(name)
+	if err != nil {
+		return err
+	}
+	if topic == nil {
+		return fmt.Errorf("topic '%s' not found", name)
+	}
+	return exportTopic(a, topic, w)
+}
+
+// restoreDb loads data from the archive file into the database opened by the store. Without a scope
+// the database must be empty. With a scope only the given user or topic is loaded; the user or
+// topic may be absent or deleted, but not live.
+func restoreDb(fileName, scope string) {
+	a := store.Store.GetAdapter()
+	a.SetMaxResults(migrateMaxResults)
+
+	r := &restorer{
+		scope: normalizeScope(scope),
+		w:     newDbWriter(a, scope != ""),
+	}
+	if err := r.run(fileName); err != nil {
+		log.Fatalln("Restore failed:", err)
+	}
+
+	logCounts(r.w.saved)
+	for kind, count := range r.w.skipped {
+		log.Printf("  skipped %s: %d", kind, count)
+	}
+	log.Println("Restore completed.")
+}
+
+// restorer reads the archive and passes the records within scope to the database writer.
+type restorer struct {
+	// ID of the user or the name of the topic to restore, or an empty string to restore everything.
+	scope string
+	w     *dbWriter
+
+	// User being restored.
+	user *types.User
+	// User or topic which the current record belongs to.
+	curUser  string
+	curTopic string
+	// The current user or topic is being restored.
+	inScope bool
+	// Something was found in scope.
+	found bool
+}
+
+func (r *restorer) run(fileName string) error {
+	// Read the archive to the end first: a truncated archive must not be restored partially.
+	if err := checkArchive(fileName); err != nil {
+		return err
+	}
+
+	ar, err := openArchive(fileName)
+	if err != nil {
+		return err
+	}
+	defer ar.close()
+
+	hdr := &ar.hdr
+	adapterVersion := store.Store.GetAdapterVersion()
+	if hdr.DbVersion > adapterVersion {
+		return fmt.Errorf("archive was created from database version %d, adapter supports %d", hdr.DbVersion,
+			adapterVersion)
+	}
+	log.Printf("Restoring backup of '%s' version %d created at %s", hdr.Adapter, hdr.DbVersion,
+		hdr.CreatedAt.Format(time.RFC3339))
+	if hdr.DbVersion < adapterVersion {
+		log.Printf("Archive database version %d is older than %d, fields added since are left empty",
+			hdr.DbVersion, adapterVersion)
+	}
+
+	if hdr.Scope != "" {
+		// A partial archive can only be restored into an existing deployment.
+		if r.scope == "" {
+			r.scope = hdr.Scope
+			r.w.protectLive = true
+		} else if r.scope != hdr.Scope {
+			return fmt.Errorf("archive contains only '%s'", hdr.Scope)
+		}
+	}
+	if r.scope == "" {
+		// Make sure the data is not restored over an existing deployment.
+		users, err := r.w.dst.UserList(types.ZeroUid, 1)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return errors.New("database is not empty, use --scope to restore a single user or topic")
+		}
+	} else {
+		log.Printf("Restoring '%s' only", r.scope)
+	}
+
+	for {
+		rec, err := ar.next()
+		if err != nil {
+			return err
+		}
+		if rec.Type == recEnd {
+			break
+		}
+		if err = r.restore(rec); err != nil {
+			switch {
+			case r.curUser != "":
+				return fmt.Errorf("user '%s': %w", r.curUser, err)
+			case r.curTopic != "":
+				return fmt.Errorf("topic '%s': %w", r.curTopic, err)
+			}
+			return err
+		}
+	}
+
+	if r.scope != "" && !r.found {
+		return fmt.Errorf("'%s' not found in the archive", r.scope)
+	}
+	return nil
+}
+
+// archiveReader reads records from a backup archive.
+type archiveReader struct {
+	file *os.File
+	gz   *gzip.Reader
+	dec  *json.Decoder
+	hdr  archiveHeader
+}
+
+// openArchive opens the archive file and reads the header.
+func openArchive(fileName string) (*archiveReader, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &archiveReader{file: file}
+	var in io.Reader = file
+	if strings.HasSuffix(fileName, ".gz") {
+		if ar.gz, err = gzip.NewReader(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		in = ar.gz
+	}
+	ar.dec = json.NewDecoder(in)
+
+	if err = ar.dec.Decode(&ar.hdr); err != nil {
+		ar.close()
+		return nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	if ar.hdr.Format != archiveFormat {
+		ar.close()
+		return nil, fmt.Errorf("'%s' is not a backup archive", fileName)
+	}
+	if ar.hdr.Version > archiveVersion {
+		ar.close()
+		return nil, fmt.Errorf("unsupported archive version %d, expected %d or lower", ar.hdr.Version,
+			archiveVersion)
+	}
+	return ar, nil
+}
+
+// next returns the next record. The 'end' record is the last one.
+func (ar *archiveReader) next() (*archiveRecord, error) {
+	var rec archiveRecord
+	if err := ar.dec.Decode(&rec); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, errors.New("archive is truncated")
+		}
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (ar *archiveReader) close() {
+	if ar.gz != nil {
+		ar.gz.Close()
+	}
+	ar.file.Close()
+}
+
+// checkArchive reads the archive to the end to make sure it's complete.
+func checkArchive(fileName string) error {
+	ar, err := openArchive(fileName)
+	if err != nil {
+		return err
+	}
+	defer ar.close()
+
+	for {
+		rec, err := ar.next()
+		if err != nil {
+			return err
+		}
+		if rec.Type == recEnd {
+			return nil
+		}
+	}
+}
+
+// restore saves one record if it's in scope.
+func (r *restorer) restore(rec *archiveRecord) error {
+	switch rec.Type {
+	case recUser:
+		var user types.User
+		if err := json.Unmarshal(rec.Data, &user); err != nil {
+			return err
+		}
+		r.curUser = user.Uid().UserId()
+		r.inScope = r.scope == "" || r.scope == r.curUser
+		if !r.inScope {
+			return nil
+		}
+		r.found = true
+		r.user = &user
+		return r.w.user(&user)
+
+	case recAuth:
+		if !r.inScope {
+			return nil
+		}
+		var auth adapter.AuthRecord
+		if err := json.Unmarshal(rec.Data, &auth); err != nil {
+			return err
+		}
+		return r.w.authRecord(types.ParseUserId(rec.User), &auth)
+
+	case recCred:
+		if !r.inScope {
+			return nil
+		}
+		var cred types.Credential
+		if err := json.Unmarshal(rec.Data, &cred); err != nil {
+			return err
+		}
+		return r.w.credential(&cred)
+
+	case recDevice:
+		if !r.inScope {
+			return nil
+		}
+		var dev types.DeviceDef
+		if err := json.Unmarshal(rec.Data, &dev); err != nil {
+			return err
+		}
+		return r.w.device(types.ParseUserId(rec.User), &dev)
+
+	case recFile:
+		if !r.inScope {
+			return nil
+		}
+		var fd types.FileDef
+		if err := json.Unmarshal(rec.Data, &fd); err != nil {
+			return err
+		}
+		return r.w.file(&fd)
+
+	case recSession:
+		if !r.inScope {
+			return nil
+		}
+		var sess types.AuthSession
+		if err := json.Unmarshal(rec.Data, &sess); err != nil {
+			return err
+		}
+		return r.w.authSession(&sess)
+
+	case recUserEnd:
+		if r.inScope {
+			if err := r.w.userDone(r.user); err != nil {
+				return err
+			}
+		}
+		r.user = nil
+		r.curUser = ""
+		r.inScope = false
+		return nil
+
+	case recTopic:
+		var topic types.Topic
+		if err := json.Unmarshal(rec.Data, &topic); err != nil {
+			return err
+		}
+		r.curTopic = topic.Id
+		inScope, err := r.topicInScope(&topic)
+		if err != nil {
+			return err
+		}
+		r.inScope = inScope
+		if !r.inScope {
+			return nil
+		}
+		if r.scope == topic.Id {
+			r.found = true
+		}
+		return r.w.topic(&topic)
+
+	case recMessage:
+		if !r.inScope {
+			return nil
+		}
+		var msg types.Message
+		if err := json.Unmarshal(rec.Data, &msg); err != nil {
+			return err
+		}
+		return r.w.message(&msg, rec.Attachments)
+
+	case recEdit:
+		if !r.inScope {
+			return nil
+		}
+		var rev types.MessageRevision
+		if err := json.Unmarshal(rec.Data, &rev); err != nil {
+			return err
+		}
+		return r.w.edit(&rev)
+
+	case recReaction:
+		if !r.inScope {
+			return nil
+		}
+		var react adapter.UserReaction
+		if err := json.Unmarshal(rec.Data, &react); err != nil {
+			return err
+		}
+		return r.w.reaction(rec.Topic, &react)
+
+	case recVote:
+		if !r.inScope {
+			return nil
+		}
+		var vote pollVote
+		if err := json.Unmarshal(rec.Data, &vote); err != nil {
+			return err
+		}
+		return r.w.vote(rec.Topic, &vote)
+
+	case recScheduled:
+		if !r.inScope {
+			return nil
+		}
+		var msg types.ScheduledMessage
+		if err := json.Unmarshal(rec.Data, &msg); err != nil {
+			return err
+		}
+		return r.w.scheduled(&msg)
+
+	case recDeletion:
+		if !r.inScope {
+			return nil
+		}
+		var del types.DelMessage
+		if err := json.Unmarshal(rec.Data, &del); err != nil {
+			return err
+		}
+		return r.w.deletion(&del)
+
+	case recTopicEnd:
+		if r.inScope {
+			name := r.curTopic
+			if err := r.w.topicDone(r.w.cur); err != nil {
+				return err
+			}
+			if r.scope != "" {
+				// Subscriptions of deleted users are not restored.
+				if err := r.w.dst.TopicUpdateSubCnt(name); err != nil {
+					return err
+				}
+			}
+		}
+		r.curTopic = ""
+		r.inScope = false
+		return nil
+
+	case recSub:
+		var sub types.Subscription
+		if err := json.Unmarshal(rec.Data, &sub); err != nil {
+			return err
+		}
+		return r.restoreSub(&sub)
+
+	case recPCache:
+		if r.scope != "" {
+			return nil
+		}
+		var entry adapter.PCacheEntry
+		if err := json.Unmarshal(rec.Data, &entry); err != nil {
+			return err
+		}
+		return r.w.pcache(&entry)
+
+	case recAPIKey:
+		if r.scope != "" {
+			return nil
+		}
+		var key types.APIKey
+		if err := json.Unmarshal(rec.Data, &key); err != nil {
+			return err
+		}
+		return r.w.apiKey(&key)
+
+	case recAudit:
+		if r.scope != "" {
+			return nil
+		}
+		var audit types.AuditRecord
+		if err := json.Unmarshal(rec.Data, &audit); err != nil {
+			return err
+		}
+		return r.w.audit(&audit)
+	}
+
+	return fmt.Errorf("unknown record type '%s'", rec.Type)
+}
+
+// topicInScope checks if the topic should be restored. With a user scope the p2p topics of the user
+// and the topics owned by the user are restored if they are missing or deleted.
+func (r *restorer) topicInScope(topic *types.Topic) (bool, error) {
+	if r.scope == "" || r.scope == topic.Id {
+		return true, nil
+	}
+	uid := types.ParseUserId(r.scope)
+	if uid.IsZero() || !r.found {
+		return false, nil
+	}
+
+	related := topic.Owner == uid.String()
+	if !related && types.GetTopicCat(topic.Id) == types.TopicCatP2P {
+		uid1, uid2, _ := types.ParseP2P(topic.Id)
+		related = uid1 == uid || uid2 == uid
+	}
+	if !related {
+		return false, nil
+	}
+
+	existing, err := r.w.dst.TopicGet(topic.Id)
+	if err != nil {
+		return false, err
+	}
+	return existing == nil || existing.State == types.StateDeleted, nil
+}
+
+// restoreSub saves the subscription if it belongs to the user or the topic being restored.
+func (r *restorer) restoreSub(sub *types.Subscription) error {
+	if r.inScope {
+		if r.scope != "" && r.curTopic != "" {
+			// The subscriber may have been deleted since the backup.
+			user, err := r.w.dst.UserGet(types.ParseUid(sub.User))
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				log.Printf("Skipped subscription of '%s': user not found", types.ParseUid(sub.User).UserId())
+				r.w.skipped["subscriptions"]++
+				return nil
+			}
+		}
+		return r.w.subscription(sub)
+	}
+
+	// Subscription of the restored user to a topic which is not restored.
+	if r.curUser != "" || !r.found || types.ParseUid(sub.User).UserId() != r.scope {
+		return nil
+	}
+	name := sub.Topic
+	if grp := types.ChnToGrp(name); grp != "" {
+		name = grp
+	}
+	topic, err := r.w.dst.TopicGet(name)
+	if err != nil {
+		return err
+	}
+	if topic == nil || topic.State == types.StateDeleted {
+		log.Printf("Skipped subscription to '%s': topic not found", sub.Topic)
+		r.w.skipped["subscriptions"]++
+		return nil
+	}
+	if err = r.w.subscription(sub); err != nil {
+		return fmt.Errorf("subscription to '%s': %w", sub.Topic, err)
+	}
+	return r.w.dst.TopicUpdateSubCnt(name)
+}
+
+// normalizeScope converts the name of a channel to the name of the group topic.
+func normalizeScope(scope string) string {
+	if grp := types.ChnToGrp(scope); grp != "" {
+		return grp
+	}
+	return scope
+}
+
+// logCounts prints the number of records of each kind.
+func logCounts(counts recordCounts) {
+	var kinds []string
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		log.Printf("  %s: %d", kind, counts[kind])
+	}
+}
@@ -334,7 +334,7 @@ func genDb(data *Data, p2pDel bool) {
 					Topic:     topic,
 					From:      from.String(),
 					Content:   str,
-				}, nil, true); err != nil {
+				}, nil, nil, true); err != nil {
 					log.Fatal("Failed to insert message: ", err)
 				}
 
@@ -356,7 +356,7 @@ func genDb(data *Data, p2pDel bool) {
 					Topic:     nameIndex[gt.Name],
 					From:      nameIndex[gt.Owner],
 					Content:   data.Messages[0],
-				}, nil, true); err != nil {
+				}, nil, nil, true); err != nil {
 					log.Fatal("Failed to insert message: ", err)
 				}
 			}
@@ -375,7 +375,7 @@ func genDb(data *Data, p2pDel bool) {
 					Topic:     nameIndex[sub.pair],
 					From:      nameIndex[sub.Users[0].Name],
 					Content:   data.Messages[0],
-				}, nil, true); err != nil {
+				}, nil, nil, true); err != nil {
 					log.Fatal("Failed to insert message: ", err)
 				}
 			}
@@ -399,7 +399,7 @@ func genDb(data *Data, p2pDel bool) {
 						Head:      types.KVMap{"mime": "text/x-drafty"},
 						From:      from,
 						Content:   form,
-					}, nil, true); err != nil {
+					}, nil, nil, true); err != nil {
 						log.Fatal("Failed to insert form: ", err)
 					}
 				}
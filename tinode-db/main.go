@@ -17,6 +17,7 @@ import (
 	_ "github.com/tinode/chat/server/db/mysql"
 	_ "github.com/tinode/chat/server/db/postgres"
 	_ "github.com/tinode/chat/server/db/rethinkdb"
+	_ "github.com/tinode/chat/server/db/sqlite"
 	"github.com/tinode/chat/server/store"
 	"github.com/tinode/chat/server/store/types"
 	jcr "github.com/tinode/jsonco"
@@ -199,6 +200,11 @@ func main() {
 	makeRoot := flag.String("make_root", "", "promote ordinary user to ROOT, auth scheme 'basic'")
 	datafile := flag.String("data", "", "name of file with sample data to load")
 	conffile := flag.String("config", "./tinode.conf", "config of the database connection")
+	migrateTo := flag.String("migrate_to", "", "copy all data to the database of the named adapter")
+	migrateState := flag.String("migrate_state", "./migrate-state.json", "file with the checkpoint of migration")
+	backup := flag.String("backup", "", "save all data to the named archive file")
+	restore := flag.String("restore", "", "load data from the named archive file")
+	scope := flag.String("scope", "", "limit backup or restore to one user or topic, e.g. 'usrAbC' or 'grpXyZ'")
 
 	flag.Parse()
 
@@ -238,6 +244,16 @@ func main() {
 		}
 	}
 
+	if *migrateTo != "" {
+		migrateDb(config.StoreConfig, *migrateTo, *migrateState)
+		os.Exit(0)
+	}
+
+	if *backup != "" {
+		backupDb(config.StoreConfig, *backup, *scope)
+		os.Exit(0)
+	}
+
 	err := store.Store.Open(1, config.StoreConfig)
 	defer store.Store.Close()
 
@@ -306,6 +322,10 @@ func main() {
 		log.Println("Sample data ignored.")
 	}
 
+	if *restore != "" {
+		restoreDb(*restore, *scope)
+	}
+
 	// Promote existing user account to root
 	if *makeRoot != "" {
 		adapter := store.Store.GetAdapter()
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	adapter "github.com/tinode/chat/server/db"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+const (
+	// Number of records to read from the database in one call.
+	migratePageSize = 100
+	// Maximum number of results returned by adapters in one call. Subscriptions and deletion logs
+	// of a topic are read without paging, the limit must accommodate the largest topic.
+	migrateMaxResults = 1 << 20
+)
+
+// Migration steps in the order of execution.
+const (
+	migrateStepUsers   = "users"
+	migrateStepTopics  = "topics"
+	migrateStepPCache  = "pcache"
+	migrateStepAPIKeys = "apikeys"
+	migrateStepAudit   = "audit"
+	migrateStepVerify  = "verify"
+)
+
+// recordCounts is the number of records of each kind, e.g. "users": 10.
+type recordCounts map[string]int
+
+// migrationState is the checkpoint of the migration saved after each copied user, topic
+// or page of cache entries or audit records. It allows an interrupted migration to be resumed.
+type migrationState struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Current step: users, topics, pcache, apikeys, audit, verify.
+	Step string `json:"step"`
+	// ID of the last completely copied user, name of the topic, cache key or ID of the audit record,
+	// depending on the step.
+	Last string `json:"last,omitempty"`
+	// Records which could not be copied and were skipped.
+	Skipped recordCounts `json:"skipped,omitempty"`
+}
+
+type migrator struct {
+	src adapter.Adapter
+	dst adapter.Adapter
+
+	// Writes records to dst.
+	w *dbWriter
+
+	stateFile string
+	state     migrationState
+}
+
+// migrateDb copies all data from the adapter configured in store_config to the target adapter.
+// Both adapters must be linked into the binary. The source is used read-only.
+func migrateDb(storeConfig json.RawMessage, target, stateFile string) {
+	var config struct {
+		Adapters map[string]json.RawMessage `json:"adapters"`
+	}
+	if err := json.Unmarshal(storeConfig, &config); err != nil {
+		log.Fatalln("Failed to parse store config:", err)
+	}
+
+	// Opening the source through the store also initializes the UID generator used
+	// by SQL adapters to encode and decode IDs.
+	if err := store.Store.Open(1, storeConfig); err != nil {
+		log.Fatalln("Failed to open source database:", err)
+	}
+	defer store.Store.Close()
+
+	m := &migrator{src: store.Store.GetAdapter(), stateFile: stateFile}
+	if m.src.GetName() == target {
+		log.Fatalf("Source and target adapters must be different, both are '%s'", target)
+	}
+
+	m.dst = store.GetAvailableAdapter(target)
+	if m.dst == nil {
+		log.Fatalf("Target adapter '%s' is not available in this binary", target)
+	}
+	if config.Adapters[target] == nil {
+		log.Fatalf("Missing config of the target adapter 'store_config.adapters.%s'", target)
+	}
+	m.dst.SetMaxResults(migrateMaxResults)
+	if err := m.dst.Open(config.Adapters[target]); err != nil {
+		log.Fatalln("Failed to open target database:", err)
+	}
+	defer m.dst.Close()
+
+	if err := m.dst.CheckDbVersion(); err != nil {
+		if !strings.Contains(err.Error(), "Database not initialized") {
+			log.Fatalln("Target database:", err)
+		}
+		log.Println("Target database not found. Creating.")
+		if err = m.dst.CreateDb(false); err != nil {
+			log.Fatalln("Failed to create target database:", err)
+		}
+	}
+	m.src.SetMaxResults(migrateMaxResults)
+
+	log.Printf("Migrating data from '%s' to '%s'", m.src.GetName(), target)
+
+	if err := m.run(); err != nil {
+		log.Fatalln("Migration failed:", err)
+	}
+}
+
+// run executes or resumes the migration.
+func (m *migrator) run() error {
+	resumed, err := m.loadState()
+	if err != nil {
+		return err
+	}
+
+	if resumed {
+		if m.state.Source != m.src.GetName() || m.state.Target != m.dst.GetName() {
+			return fmt.Errorf("state file '%s' belongs to migration from '%s' to '%s'", m.stateFile,
+				m.state.Source, m.state.Target)
+		}
+		log.Printf("Resuming migration at step '%s' after '%s'", m.state.Step, m.state.Last)
+	} else {
+		// Make sure the data is not copied over an existing deployment.
+		users, err := m.dst.UserList(types.ZeroUid, 1)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return errors.New("target database is not empty")
+		}
+		m.state = migrationState{
+			Source:  m.src.GetName(),
+			Target:  m.dst.GetName(),
+			Step:    migrateStepUsers,
+			Skipped: recordCounts{},
+		}
+		if err := m.saveState(); err != nil {
+			return err
+		}
+	}
+
+	m.w = newDbWriter(m.dst, false)
+	m.w.skipped = m.state.Skipped
+
+	if m.state.Step == migrateStepUsers {
+		if err := m.copyUsers(resumed); err != nil {
+			return err
+		}
+		if err := m.nextStep(migrateStepTopics); err != nil {
+			return err
+		}
+	}
+	if m.state.Step == migrateStepTopics {
+		if err := m.copyTopics(); err != nil {
+			return err
+		}
+		if err := m.nextStep(migrateStepPCache); err != nil {
+			return err
+		}
+	}
+	if m.state.Step == migrateStepPCache {
+		if err := m.copyPCache(); err != nil {
+			return err
+		}
+		if err := m.nextStep(migrateStepAPIKeys); err != nil {
+			return err
+		}
+	}
+	if m.state.Step == migrateStepAPIKeys {
+		if err := m.copyAPIKeys(); err != nil {
+			return err
+		}
+		if err := m.nextStep(migrateStepAudit); err != nil {
+			return err
+		}
+	}
+	if m.state.Step == migrateStepAudit {
+		if err := m.copyAudit(resumed); err != nil {
+			return err
+		}
+		if err := m.nextStep(migrateStepVerify); err != nil {
+			return err
+		}
+	}
+
+	return m.verify()
+}
+
+// loadState reads the checkpoint. It returns false if the migration has not been started yet.
+func (m *migrator) loadState() (bool, error) {
+	raw, err := os.ReadFile(m.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = json.Unmarshal(raw, &m.state); err != nil {
+		return false, fmt.Errorf("invalid state file '%s': %w", m.stateFile, err)
+	}
+	if m.state.Skipped == nil {
+		m.state.Skipped = recordCounts{}
+	}
+	return true, nil
+}
+
+// saveState writes the checkpoint. The file is replaced atomically so it's never left half-written.
+func (m *migrator) saveState() error {
+	raw, err := json.MarshalIndent(&m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.stateFile + ".tmp"
+	if err = os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.stateFile)
+}
+
+func (m *migrator) nextStep(step string) error {
+	m.state.Step = step
+	m.state.Last = ""
+	return m.saveState()
+}
+
+// copyUsers copies users with their auth records, credentials, devices, file records, authenticated
+// sessions and subscriptions to 'me' and 'fnd'.
+func (m *migrator) copyUsers(resumed bool) error {
+	log.Println("Copying users...")
+
+	after := types.ParseUid(m.state.Last)
+	// The first user after the checkpoint may have been copied partially before the interruption.
+	purge := resumed
+	for {
+		users, err := m.src.UserList(after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for i := range users {
+			m.w.purge = purge
+			if err := exportUser(m.src, &users[i], m.w); err != nil {
+				return fmt.Errorf("user '%s': %w", users[i].Uid().UserId(), err)
+			}
+			purge = false
+
+			m.state.Last = users[i].Id
+			if err := m.saveState(); err != nil {
+				return err
+			}
+		}
+		after = users[len(users)-1].Uid()
+		log.Printf("Copied users up to '%s'", after.UserId())
+	}
+	return nil
+}
+
+// copyTopics copies topics with their subscriptions, messages, revisions of messages, reactions, votes,
+// scheduled messages and logs of deleted messages.
+func (m *migrator) copyTopics() error {
+	log.Println("Copying topics...")
+
+	after := m.state.Last
+	for {
+		topics, err := m.src.TopicList(after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(topics) == 0 {
+			break
+		}
+
+		for i := range topics {
+			if err := exportTopic(m.src, &topics[i], m.w); err != nil {
+				return fmt.Errorf("topic '%s': %w", topics[i].Id, err)
+			}
+
+			m.state.Last = topics[i].Id
+			if err := m.saveState(); err != nil {
+				return err
+			}
+		}
+		after = topics[len(topics)-1].Id
+		log.Printf("Copied topics up to '%s'", after)
+	}
+	return nil
+}
+
+// copyPCache copies persistent cache entries. Creation times of the entries are not preserved.
+func (m *migrator) copyPCache() error {
+	log.Println("Copying cache...")
+
+	for {
+		entries, err := m.src.PCacheList(m.state.Last, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for i := range entries {
+			if err := m.w.pcache(&entries[i]); err != nil {
+				return err
+			}
+		}
+
+		m.state.Last = entries[len(entries)-1].Key
+		if err := m.saveState(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAPIKeys copies API keys. Keys which are already present in the target are skipped.
+func (m *migrator) copyAPIKeys() error {
+	log.Println("Copying API keys...")
+
+	keys, err := m.src.APIKeyGetAll()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err := m.w.apiKey(&keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAudit copies the audit log.
+func (m *migrator) copyAudit(resumed bool) error {
+	log.Println("Copying audit log...")
+
+	// Records following the checkpoint may have been copied before the interruption. The order of
+	// records in the target may differ from the source, so all copied records are skipped by ID.
+	copied := make(map[string]bool)
+	if resumed {
+		var last string
+		for {
+			recs, err := m.dst.AuditList(last, migratePageSize)
+			if err != nil {
+				return err
+			}
+			if len(recs) == 0 {
+				break
+			}
+			for i := range recs {
+				copied[recs[i].Id] = true
+			}
+			last = recs[len(recs)-1].Id
+		}
+	}
+
+	for {
+		recs, err := m.src.AuditList(m.state.Last, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(recs) == 0 {
+			break
+		}
+
+		for i := range recs {
+			if copied[recs[i].Id] {
+				m.w.saved["audit"]++
+				continue
+			}
+			if err := m.w.audit(&recs[i]); err != nil {
+				return err
+			}
+		}
+
+		m.state.Last = recs[len(recs)-1].Id
+		if err := m.saveState(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify compares the number of records in the source and in the target.
+func (m *migrator) verify() error {
+	log.Println("Verifying...")
+
+	want, err := countRecords(m.src)
+	if err != nil {
+		return err
+	}
+	got, err := countRecords(m.dst)
+	if err != nil {
+		return err
+	}
+	for kind, count := range m.state.Skipped {
+		want[kind] -= count
+	}
+
+	var kinds []string
+	for kind := range want {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var mismatched []string
+	for _, kind := range kinds {
+		if got[kind] != want[kind] {
+			log.Printf("  %s: %d in target, expected %d", kind, got[kind], want[kind])
+			mismatched = append(mismatched, kind)
+		} else {
+			log.Printf("  %s: %d", kind, got[kind])
+		}
+	}
+	if len(mismatched) > 0 {
+		return errors.New("record counts do not match: " + strings.Join(mismatched, ", "))
+	}
+
+	log.Println("Migration completed, record counts match.")
+	return nil
+}
+
+// countRecords counts records of each kind which are copied by the migration.
+func countRecords(a adapter.Adapter) (recordCounts, error) {
+	counts := recordCounts{}
+
+	after := types.ZeroUid
+	for {
+		users, err := a.UserList(after, migratePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		for i := range users {
+			uid := users[i].Uid()
+			counts["users"]++
+
+			recs, err := a.AuthGetAllRecords(uid)
+			if err != nil {
+				return nil, err
+			}
+			counts["auth"] += len(recs)
+
+			creds, err := a.CredGetAll(uid, "", false)
+			if err != nil {
+				return nil, err
+			}
+			counts["creds"] += len(creds)
+
+			devices, _, err := a.DeviceGetAll(uid)
+			if err != nil {
+				return nil, err
+			}
+			counts["devices"] += len(devices[uid])
+
+			if err := forEachFile(a, uid, func(*types.FileDef) error {
+				counts["files"]++
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+
+			sessions, err := a.AuthSessionGetAll(uid)
+			if err != nil {
+				return nil, err
+			}
+			counts["sessions"] += len(sessions)
+
+			subs, err := getSubs(a, uid.UserId(), uid.FndName())
+			if err != nil {
+				return nil, err
+			}
+			counts["subscriptions"] += len(subs)
+		}
+		after = users[len(users)-1].Uid()
+	}
+
+	var last string
+	for {
+		topics, err := a.TopicList(last, migratePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(topics) == 0 {
+			break
+		}
+		for i := range topics {
+			name := topics[i].Id
+			counts["topics"]++
+
+			subs, err := getSubs(a, topicSubNames(&topics[i])...)
+			if err != nil {
+				return nil, err
+			}
+			counts["subscriptions"] += len(subs)
+
+			if err := forEachMessage(a, name, func(msg *types.Message) error {
+				counts["messages"]++
+				fids, err := getAttachments(a, msg)
+				counts["attachments"] += len(fids)
+				return err
+			}); err != nil {
+				return nil, err
+			}
+
+			if err := forEachEdit(a, name, func(*types.MessageRevision) error {
+				counts["edits"]++
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+
+			if err := forEachReaction(a, name, func(*adapter.UserReaction) error {
+				counts["reactions"]++
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+
+			if err := forEachVote(a, name, func(*adapter.UserVote) error {
+				counts["votes"]++
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+
+			scheduled, err := a.ScheduledMessageGetAll(name, types.ZeroUid, nil)
+			if err != nil {
+				return nil, err
+			}
+			counts["scheduled"] += len(scheduled)
+
+			dels, err := getDeletions(a, name, subs)
+			if err != nil {
+				return nil, err
+			}
+			counts["deletions"] += len(dels)
+		}
+		last = topics[len(topics)-1].Id
+	}
+
+	last = ""
+	for {
+		entries, err := a.PCacheList(last, migratePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		counts["pcache"] += len(entries)
+		last = entries[len(entries)-1].Key
+	}
+
+	keys, err := a.APIKeyGetAll()
+	if err != nil {
+		return nil, err
+	}
+	counts["apikeys"] = len(keys)
+
+	last = ""
+	for {
+		recs, err := a.AuditList(last, migratePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(recs) == 0 {
+			break
+		}
+		counts["audit"] += len(recs)
+		last = recs[len(recs)-1].Id
+	}
+
+	return counts, nil
+}
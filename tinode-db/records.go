@@ -0,0 +1,826 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	adapter "github.com/tinode/chat/server/db"
+	"github.com/tinode/chat/server/store"
+	"github.com/tinode/chat/server/store/types"
+)
+
+// recordWriter receives records read from a database or from a backup archive. Records which belong
+// to a user or a topic are received between the user or the topic and the matching userDone or topicDone.
+type recordWriter interface {
+	user(user *types.User) error
+	authRecord(uid types.Uid, rec *adapter.AuthRecord) error
+	credential(cred *types.Credential) error
+	device(uid types.Uid, dev *types.DeviceDef) error
+	file(fd *types.FileDef) error
+	authSession(sess *types.AuthSession) error
+	userDone(user *types.User) error
+
+	topic(topic *types.Topic) error
+	message(msg *types.Message, attachments []string) error
+	// Revisions, reactions and votes are received after all messages of the topic.
+	edit(rev *types.MessageRevision) error
+	reaction(topic string, react *adapter.UserReaction) error
+	vote(topic string, vote *pollVote) error
+	scheduled(msg *types.ScheduledMessage) error
+	// Deletions are received after all messages of the topic in the order of deletion.
+	deletion(del *types.DelMessage) error
+	topicDone(topic *types.Topic) error
+
+	// Subscriptions to 'me' and 'fnd' are received with the user, subscriptions to other topics with the topic.
+	subscription(sub *types.Subscription) error
+	pcache(entry *adapter.PCacheEntry) error
+	apiKey(key *types.APIKey) error
+	audit(rec *types.AuditRecord) error
+}
+
+// pollVote is the list of options chosen by a user in a poll.
+type pollVote struct {
+	SeqId   int
+	User    string
+	Options []int
+}
+
+// exportUser reads the user with auth records, credentials, devices, uploaded files, authenticated sessions
+// and subscriptions to 'me' and 'fnd' from the adapter and passes them to w.
+func exportUser(a adapter.Adapter, user *types.User, w recordWriter) error {
+	uid := user.Uid()
+
+	if err := w.user(user); err != nil {
+		return err
+	}
+
+	recs, err := a.AuthGetAllRecords(uid)
+	if err != nil {
+		return err
+	}
+	for i := range recs {
+		if err := w.authRecord(uid, &recs[i]); err != nil {
+			return fmt.Errorf("auth record '%s': %w", recs[i].Unique, err)
+		}
+	}
+
+	creds, err := a.CredGetAll(uid, "", false)
+	if err != nil {
+		return err
+	}
+	for i := range creds {
+		if err := w.credential(&creds[i]); err != nil {
+			return fmt.Errorf("credential '%s:%s': %w", creds[i].Method, creds[i].Value, err)
+		}
+	}
+
+	devices, _, err := a.DeviceGetAll(uid)
+	if err != nil {
+		return err
+	}
+	for i := range devices[uid] {
+		if err := w.device(uid, &devices[uid][i]); err != nil {
+			return err
+		}
+	}
+
+	if err := forEachFile(a, uid, w.file); err != nil {
+		return err
+	}
+
+	sessions, err := a.AuthSessionGetAll(uid)
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		if err := w.authSession(&sessions[i]); err != nil {
+			return fmt.Errorf("session '%s': %w", sessions[i].Id, err)
+		}
+	}
+
+	subs, err := getSubs(a, uid.UserId(), uid.FndName())
+	if err != nil {
+		return err
+	}
+	for i := range subs {
+		if err := w.subscription(&subs[i]); err != nil {
+			return err
+		}
+	}
+
+	return w.userDone(user)
+}
+
+// exportTopic reads the topic with subscriptions, messages with their revisions, reactions and votes,
+// scheduled messages and the log of deleted messages from the adapter and passes them to w.
+func exportTopic(a adapter.Adapter, topic *types.Topic, w recordWriter) error {
+	if err := w.topic(topic); err != nil {
+		return err
+	}
+
+	subs, err := getSubs(a, topicSubNames(topic)...)
+	if err != nil {
+		return err
+	}
+	for i := range subs {
+		if err := w.subscription(&subs[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := forEachMessage(a, topic.Id, func(msg *types.Message) error {
+		fids, err := getAttachments(a, msg)
+		if err != nil {
+			return err
+		}
+		return w.message(msg, fids)
+	}); err != nil {
+		return err
+	}
+
+	if err := forEachEdit(a, topic.Id, w.edit); err != nil {
+		return err
+	}
+
+	if err := forEachReaction(a, topic.Id, func(react *adapter.UserReaction) error {
+		return w.reaction(topic.Id, react)
+	}); err != nil {
+		return err
+	}
+
+	votes, err := getVotes(a, topic.Id)
+	if err != nil {
+		return err
+	}
+	for i := range votes {
+		if err := w.vote(topic.Id, &votes[i]); err != nil {
+			return fmt.Errorf("message %d vote: %w", votes[i].SeqId, err)
+		}
+	}
+
+	scheduled, err := a.ScheduledMessageGetAll(topic.Id, types.ZeroUid, nil)
+	if err != nil {
+		return err
+	}
+	for i := range scheduled {
+		if err := w.scheduled(&scheduled[i]); err != nil {
+			return fmt.Errorf("scheduled message '%s': %w", scheduled[i].Id, err)
+		}
+	}
+
+	dels, err := getDeletions(a, topic.Id, subs)
+	if err != nil {
+		return err
+	}
+	for i := range dels {
+		if err := w.deletion(&dels[i]); err != nil {
+			return fmt.Errorf("deletion %d: %w", dels[i].DelId, err)
+		}
+	}
+
+	return w.topicDone(topic)
+}
+
+// dbWriter saves records to the database.
+type dbWriter struct {
+	dst adapter.Adapter
+
+	// Refuse to overwrite users and topics which exist and are not deleted. Otherwise an existing
+	// topic is replaced.
+	protectLive bool
+	// Delete the next user before saving it: the user may have been partially saved before.
+	// Users are always deleted first if protectLive is set.
+	purge bool
+	// IDs of file records of the current user which are already present in the target.
+	skipFiles map[string]bool
+	// Number of saved records by kind.
+	saved recordCounts
+	// Records which could not be saved and were skipped.
+	skipped recordCounts
+
+	// Topic being written.
+	cur *types.Topic
+}
+
+func newDbWriter(dst adapter.Adapter, protectLive bool) *dbWriter {
+	return &dbWriter{
+		dst:         dst,
+		protectLive: protectLive,
+		saved:       recordCounts{},
+		skipped:     recordCounts{},
+	}
+}
+
+func (w *dbWriter) user(user *types.User) error {
+	w.skipFiles = nil
+	if w.protectLive {
+		existing, err := w.dst.UserGet(user.Uid())
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return errors.New("user already exists")
+		}
+	}
+	if w.purge || w.protectLive {
+		// Remove what is left of the user, e.g. after a soft deletion.
+		if err := w.purgeUser(user.Uid()); err != nil {
+			return err
+		}
+		w.purge = false
+	}
+
+	// Devices are saved separately.
+	user.Devices = nil
+	user.DeviceArray = nil
+	if err := w.dst.UserCreate(user); err != nil {
+		return err
+	}
+	// These fields are not saved by UserCreate.
+	update := map[string]any{}
+	if user.StateAt != nil {
+		update["StateAt"] = *user.StateAt
+	}
+	if user.LastSeen != nil {
+		update["LastSeen"] = *user.LastSeen
+	}
+	if user.UserAgent != "" {
+		update["UserAgent"] = user.UserAgent
+	}
+	if len(update) > 0 {
+		if err := w.dst.UserUpdate(user.Uid(), update); err != nil {
+			return err
+		}
+	}
+	w.saved["users"]++
+	return nil
+}
+
+// purgeUser hard-deletes the user and remembers the uploaded files which are left in the target.
+func (w *dbWriter) purgeUser(uid types.Uid) error {
+	if err := w.dst.UserDelete(uid, true); err != nil {
+		return err
+	}
+	// File records are not deleted with the user.
+	w.skipFiles = make(map[string]bool)
+	return forEachFile(w.dst, uid, func(fd *types.FileDef) error {
+		w.skipFiles[fd.Id] = true
+		return nil
+	})
+}
+
+func (w *dbWriter) authRecord(uid types.Uid, rec *adapter.AuthRecord) error {
+	if err := w.dst.AuthAddRecord(uid, rec.Scheme, rec.Unique, rec.AuthLvl, rec.Secret, rec.Expires); err != nil {
+		return err
+	}
+	w.saved["auth"]++
+	return nil
+}
+
+func (w *dbWriter) credential(cred *types.Credential) error {
+	if _, err := w.dst.CredUpsert(cred); err != nil {
+		if err == types.ErrDuplicate && !cred.Done {
+			// The same value is validated by another user.
+			log.Printf("Skipped unvalidated credential '%s:%s' of user '%s': validated by another user",
+				cred.Method, cred.Value, types.ParseUid(cred.User).UserId())
+			w.skipped["creds"]++
+			return nil
+		}
+		return err
+	}
+	for range cred.Retries {
+		if err := w.dst.CredFail(types.ParseUid(cred.User), cred.Method); err != nil {
+			return err
+		}
+	}
+	w.saved["creds"]++
+	return nil
+}
+
+func (w *dbWriter) device(uid types.Uid, dev *types.DeviceDef) error {
+	if err := w.dst.DeviceUpsert(uid, dev); err != nil {
+		return err
+	}
+	w.saved["devices"]++
+	return nil
+}
+
+func (w *dbWriter) file(fd *types.FileDef) error {
+	if !w.skipFiles[fd.Id] {
+		if err := w.dst.FileStartUpload(fd); err != nil {
+			return err
+		}
+	}
+	w.saved["files"]++
+	return nil
+}
+
+func (w *dbWriter) authSession(sess *types.AuthSession) error {
+	if err := w.dst.AuthSessionCreate(sess); err != nil {
+		return err
+	}
+	w.saved["sessions"]++
+	return nil
+}
+
+func (w *dbWriter) userDone(user *types.User) error {
+	return w.linkAvatar("", user.Uid(), user.Public)
+}
+
+func (w *dbWriter) topic(topic *types.Topic) error {
+	// The topic may already exist: it was partially copied before the interruption, it was created
+	// together with the database, like 'sys', or it was soft-deleted.
+	existing, err := w.dst.TopicGet(topic.Id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if w.protectLive && existing.State != types.StateDeleted {
+			return errors.New("topic already exists")
+		}
+		if err := w.dst.TopicDelete(topic.Id, existing.UseBt, true); err != nil {
+			return err
+		}
+	}
+
+	if err := w.dst.TopicCreate(topic); err != nil {
+		return err
+	}
+	w.cur = topic
+	w.saved["topics"]++
+	return nil
+}
+
+func (w *dbWriter) message(msg *types.Message, attachments []string) error {
+	// Message IDs are internal to the adapter. A new ID is needed for adapters which
+	// use it as a primary key.
+	msg.SetUid(store.Store.GetUid())
+	// Deletions are saved separately.
+	msg.DeletedAt = nil
+	msg.DelId = 0
+	msg.DeletedFor = nil
+	if err := w.dst.MessageSave(msg); err != nil {
+		return err
+	}
+	w.saved["messages"]++
+
+	fids, err := w.existingFiles(attachments)
+	if err != nil {
+		return err
+	}
+	if missing := len(attachments) - len(fids); missing > 0 {
+		log.Printf("Skipped %d attachments of message %d in '%s': files not found", missing, msg.SeqId, msg.Topic)
+		w.skipped["attachments"] += missing
+	}
+	if len(fids) == 0 {
+		return nil
+	}
+	if err = w.dst.FileLinkAttachments("", types.ZeroUid, msg.Uid(), fids); err != nil {
+		return err
+	}
+	w.saved["attachments"] += len(fids)
+	return nil
+}
+
+func (w *dbWriter) edit(rev *types.MessageRevision) error {
+	if err := w.dst.MessageEditSave(rev); err != nil {
+		return err
+	}
+	w.saved["edits"]++
+	return nil
+}
+
+// reaction saves the reaction. Creation time of the reaction is not preserved.
+func (w *dbWriter) reaction(topic string, react *adapter.UserReaction) error {
+	if err := w.dst.MessageReact(topic, react.SeqId, types.ParseUid(react.User), react.Value); err != nil {
+		return err
+	}
+	w.saved["reactions"]++
+	return nil
+}
+
+// vote saves the vote. Creation time of the vote is not preserved.
+func (w *dbWriter) vote(topic string, vote *pollVote) error {
+	if err := w.dst.MessageVote(topic, vote.SeqId, types.ParseUid(vote.User), vote.Options); err != nil {
+		return err
+	}
+	w.saved["votes"] += len(vote.Options)
+	return nil
+}
+
+func (w *dbWriter) scheduled(msg *types.ScheduledMessage) error {
+	if err := w.dst.ScheduledMessageSave(msg); err != nil {
+		return err
+	}
+	w.saved["scheduled"]++
+	return nil
+}
+
+func (w *dbWriter) deletion(del *types.DelMessage) error {
+	if w.cur == nil || del.Topic != w.cur.Id {
+		return errors.New("deletion outside of its topic")
+	}
+
+	if del.DeletedFor == "" {
+		// Content of hard-deleted messages is not available. Adapters log only deletion
+		// of existing messages, so the messages are replaced by empty placeholders.
+		for _, r := range del.SeqIdRanges {
+			hi := r.Hi
+			if hi == 0 {
+				hi = r.Low + 1
+			}
+			for seq := r.Low; seq < hi && seq <= w.cur.SeqId; seq++ {
+				placeholder := &types.Message{
+					ObjHeader: types.ObjHeader{CreatedAt: w.cur.CreatedAt, UpdatedAt: w.cur.CreatedAt},
+					SeqId:     seq,
+					Topic:     w.cur.Id,
+				}
+				placeholder.SetUid(store.Store.GetUid())
+				if err := w.dst.MessageSave(placeholder); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	del.SetUid(store.Store.GetUid())
+	if del.CreatedAt.IsZero() {
+		del.InitTimes()
+	}
+	if err := w.dst.MessageDeleteList(del.Topic, del); err != nil {
+		return err
+	}
+	w.saved["deletions"]++
+	return nil
+}
+
+func (w *dbWriter) topicDone(topic *types.Topic) error {
+	w.cur = nil
+
+	if err := w.linkAvatar(topic.Id, types.ZeroUid, topic.Public); err != nil {
+		return err
+	}
+
+	// These fields are not saved by TopicCreate.
+	update := map[string]any{
+		"SeqId":  topic.SeqId,
+		"DelId":  topic.DelId,
+		"SubCnt": topic.SubCnt,
+	}
+	if topic.StateAt != nil {
+		update["StateAt"] = *topic.StateAt
+	}
+	if len(topic.Pinned) > 0 {
+		update["Pinned"] = topic.Pinned
+	}
+	if topic.MsgTTL > 0 {
+		update["MsgTTL"] = topic.MsgTTL
+	}
+	if topic.SlowMode > 0 {
+		update["SlowMode"] = topic.SlowMode
+	}
+	if topic.MsgBurst > 0 {
+		update["MsgBurst"] = topic.MsgBurst
+	}
+	return w.dst.TopicUpdate(topic.Id, update)
+}
+
+func (w *dbWriter) subscription(sub *types.Subscription) error {
+	// Subscriber count is restored with the rest of the topic.
+	if err := w.dst.TopicShare("", []*types.Subscription{sub}); err != nil {
+		return err
+	}
+
+	// TopicShare resets the IDs of deleted, received and read messages.
+	update := map[string]any{}
+	if sub.DelId > 0 {
+		update["DelId"] = sub.DelId
+	}
+	if sub.RecvSeqId > 0 {
+		update["RecvSeqId"] = sub.RecvSeqId
+	}
+	if sub.ReadSeqId > 0 {
+		update["ReadSeqId"] = sub.ReadSeqId
+	}
+	if sub.DeletedAt != nil {
+		update["DeletedAt"] = *sub.DeletedAt
+	}
+	if len(update) > 0 {
+		if err := w.dst.SubsUpdate(sub.Topic, types.ParseUid(sub.User), update); err != nil {
+			return err
+		}
+	}
+	w.saved["subscriptions"]++
+	return nil
+}
+
+func (w *dbWriter) pcache(entry *adapter.PCacheEntry) error {
+	err := w.dst.PCacheUpsert(entry.Key, entry.Value, true)
+	if err == types.ErrDuplicate {
+		// Saved before the interruption.
+		err = w.dst.PCacheUpsert(entry.Key, entry.Value, false)
+	}
+	if err == types.ErrMalformed {
+		log.Printf("Skipped cache entry '%s': key is not supported by the adapter", entry.Key)
+		w.skipped["pcache"]++
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cache entry '%s': %w", entry.Key, err)
+	}
+	w.saved["pcache"]++
+	return nil
+}
+
+// apiKey saves the API key unless it's already present in the target.
+func (w *dbWriter) apiKey(key *types.APIKey) error {
+	existing, err := w.dst.APIKeyGet(key.Id)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		if err = w.dst.APIKeyCreate(key); err != nil {
+			return fmt.Errorf("API key '%s': %w", key.Id, err)
+		}
+	}
+	w.saved["apikeys"]++
+	return nil
+}
+
+func (w *dbWriter) audit(rec *types.AuditRecord) error {
+	if err := w.dst.AuditAdd(rec); err != nil {
+		return fmt.Errorf("audit record '%s': %w", rec.Id, err)
+	}
+	w.saved["audit"]++
+	return nil
+}
+
+// linkAvatar links the uploaded avatar of a user or a topic to protect it from garbage collection.
+func (w *dbWriter) linkAvatar(topic string, user types.Uid, public any) error {
+	fid := avatarFileId(public)
+	if fid == "" {
+		return nil
+	}
+	fids, err := w.existingFiles([]string{fid})
+	if err != nil || len(fids) == 0 {
+		return err
+	}
+	return w.dst.FileLinkAttachments(topic, user, types.ZeroUid, fids)
+}
+
+// existingFiles returns IDs of files from the list which exist in the target.
+func (w *dbWriter) existingFiles(fids []string) ([]string, error) {
+	var found []string
+	for _, fid := range fids {
+		fd, err := w.dst.FileGet(fid)
+		if err != nil {
+			return nil, err
+		}
+		if fd != nil {
+			found = append(found, fid)
+		}
+	}
+	return found, nil
+}
+
+// topicSubNames returns names under which subscriptions to the topic are stored:
+// subscriptions of channel readers use the 'chn' name.
+func topicSubNames(topic *types.Topic) []string {
+	if topic.UseBt {
+		return []string{topic.Id, types.GrpToChn(topic.Id)}
+	}
+	return []string{topic.Id}
+}
+
+// getSubs returns all subscriptions to the given topics including the deleted ones.
+func getSubs(a adapter.Adapter, names ...string) ([]types.Subscription, error) {
+	var all []types.Subscription
+	for _, name := range names {
+		subs, err := a.SubsForTopic(name, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, subs...)
+	}
+	return all, nil
+}
+
+// getDeletions returns hard deletions of messages in the topic and soft deletions by the
+// subscribers ordered by deletion ID.
+func getDeletions(a adapter.Adapter, topic string, subs []types.Subscription) ([]types.DelMessage, error) {
+	dels, err := a.MessageGetDeleted(topic, types.ZeroUid, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range subs {
+		if seen[subs[i].User] {
+			continue
+		}
+		seen[subs[i].User] = true
+
+		// The result includes hard deletions too.
+		userDels, err := a.MessageGetDeleted(topic, types.ParseUid(subs[i].User), nil)
+		if err != nil {
+			return nil, err
+		}
+		for j := range userDels {
+			if userDels[j].DeletedFor != "" {
+				dels = append(dels, userDels[j])
+			}
+		}
+	}
+
+	sort.SliceStable(dels, func(i, j int) bool { return dels[i].DelId < dels[j].DelId })
+	return dels, nil
+}
+
+// getVotes returns the options chosen by each user in polls of the topic.
+func getVotes(a adapter.Adapter, topic string) ([]pollVote, error) {
+	var votes []pollVote
+	index := make(map[string]int)
+	if err := forEachVote(a, topic, func(v *adapter.UserVote) error {
+		key := strconv.Itoa(v.SeqId) + ":" + v.User
+		i, ok := index[key]
+		if !ok {
+			i = len(votes)
+			index[key] = i
+			votes = append(votes, pollVote{SeqId: v.SeqId, User: v.User})
+		}
+		votes[i].Options = append(votes[i].Options, v.Option)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// forEachReaction calls fn for every reaction of a user to a message in the topic.
+func forEachReaction(a adapter.Adapter, topic string, fn func(*adapter.UserReaction) error) error {
+	var after *adapter.UserReaction
+	for {
+		reacts, err := a.MessageGetUserReactions(topic, after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(reacts) == 0 {
+			return nil
+		}
+		for i := range reacts {
+			if err := fn(&reacts[i]); err != nil {
+				return fmt.Errorf("message %d reaction: %w", reacts[i].SeqId, err)
+			}
+		}
+		after = &reacts[len(reacts)-1]
+	}
+}
+
+// forEachVote calls fn for every poll option chosen by a user in a message of the topic.
+func forEachVote(a adapter.Adapter, topic string, fn func(*adapter.UserVote) error) error {
+	var after *adapter.UserVote
+	for {
+		votes, err := a.MessageGetUserVotes(topic, after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(votes) == 0 {
+			return nil
+		}
+		for i := range votes {
+			if err := fn(&votes[i]); err != nil {
+				return fmt.Errorf("message %d vote: %w", votes[i].SeqId, err)
+			}
+		}
+		after = &votes[len(votes)-1]
+	}
+}
+
+// getAttachments returns IDs of files attached to the message.
+func getAttachments(a adapter.Adapter, msg *types.Message) ([]string, error) {
+	if _, plain := msg.Content.(string); plain || msg.Content == nil {
+		// Plain text messages have no attachments.
+		return nil, nil
+	}
+	return a.FileGetMessageAttachments(msg.Topic, msg.SeqId)
+}
+
+// forEachMessage calls fn for every message in the topic which is not hard-deleted,
+// from the newest to the oldest.
+func forEachMessage(a adapter.Adapter, topic string, fn func(*types.Message) error) error {
+	before := 0
+	for {
+		msgs, err := a.MessageGetAll(topic, types.ZeroUid, &types.QueryOpt{Before: before, Limit: migratePageSize})
+		if err != nil {
+			return err
+		}
+		for i := range msgs {
+			if err := fn(&msgs[i]); err != nil {
+				return fmt.Errorf("message %d: %w", msgs[i].SeqId, err)
+			}
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		before = msgs[len(msgs)-1].SeqId
+		if before <= 1 {
+			return nil
+		}
+	}
+}
+
+// forEachEdit calls fn for every previous version of messages in the topic, from the newest message
+// to the oldest.
+func forEachEdit(a adapter.Adapter, topic string, fn func(*types.MessageRevision) error) error {
+	before := 0
+	for {
+		revs, err := a.MessageGetEdits(topic, &types.QueryOpt{Before: before, Limit: migratePageSize})
+		if err != nil {
+			return err
+		}
+		if len(revs) == 0 {
+			return nil
+		}
+		last := revs[len(revs)-1].SeqId
+		if len(revs) == migratePageSize {
+			// Versions of the oldest message in the page may continue past the page, read all of them.
+			// Versions are numbered from 1, so the newest one is also the count.
+			first := 0
+			for revs[first].SeqId != last {
+				first++
+			}
+			count := revs[first].Rev
+			rest, err := a.MessageGetEdits(topic, &types.QueryOpt{IdRanges: []types.Range{{Low: last}}, Limit: count})
+			if err != nil {
+				return err
+			}
+			if len(rest) != count {
+				return fmt.Errorf("message %d: read %d of %d revisions", last, len(rest), count)
+			}
+			revs = append(revs[:first], rest...)
+		}
+		for i := range revs {
+			if err := fn(&revs[i]); err != nil {
+				return fmt.Errorf("message %d revision %d: %w", revs[i].SeqId, revs[i].Rev, err)
+			}
+		}
+		before = last
+		if before <= 1 {
+			return nil
+		}
+	}
+}
+
+// forEachFile calls fn for every completed upload by the user.
+func forEachFile(a adapter.Adapter, user types.Uid, fn func(*types.FileDef) error) error {
+	after := types.ZeroUid
+	for {
+		files, err := a.FileGetAll(user, after, migratePageSize)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		for i := range files {
+			if err := fn(&files[i]); err != nil {
+				return fmt.Errorf("file '%s': %w", files[i].Id, err)
+			}
+		}
+		after = files[len(files)-1].Uid()
+	}
+}
+
+// avatarFileId returns ID of the uploaded avatar referenced by the Public value of a user or a topic
+// or an empty string. Uploads are referenced by URLs which end with the file ID and an optional
+// extension, e.g. '/v0/file/s/abcdef.jpeg'.
+func avatarFileId(public any) string {
+	card, ok := public.(map[string]any)
+	if !ok {
+		return ""
+	}
+	photo, ok := card["photo"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	ref, ok := photo["ref"].(string)
+	if !ok || ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	name := path.Base(u.Path)
+	fid := types.ParseUid(strings.TrimSuffix(name, path.Ext(name)))
+	if fid.IsZero() {
+		return ""
+	}
+	return fid.String()
+}